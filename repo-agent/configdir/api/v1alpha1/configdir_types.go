@@ -28,12 +28,71 @@ type ConfigDirSpec struct {
 	// +optional
 	FileContentSelector *metav1.LabelSelector `json:"fileContentSelector,omitempty"`
 	Files               []FileItem            `json:"files"`
+	// PrunePolicy removes the files of the target directory that are no
+	// longer in the spec when the files are synced.
+	// +optional
+	PrunePolicy *PrunePolicy `json:"prunePolicy,omitempty"`
+	// Variables are the values available to the files that are templates,
+	// overriding those of VariablesFrom.
+	// +optional
+	Variables map[string]string `json:"variables,omitempty"`
+	// VariablesFrom are ConfigMaps and Secrets whose data are variables, the
+	// later ones overriding the earlier ones.
+	// +optional
+	VariablesFrom []VariablesSource `json:"variablesFrom,omitempty"`
+	// Includes are ConfigDirs of the namespace whose files are merged into
+	// this one, e.g. a base config shared by all the repos. The files of
+	// later includes override those of earlier ones with the same path, and
+	// the files of this ConfigDir override them all. Included files are
+	// resolved with the fileContentSelector and variables of their ConfigDir.
+	// +optional
+	Includes []corev1.LocalObjectReference `json:"includes,omitempty"`
+}
+
+// VariablesSource is a ConfigMap or Secret whose keys are variables
+type VariablesSource struct {
+	// +optional
+	ConfigMapRef *corev1.LocalObjectReference `json:"configMapRef,omitempty"`
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// PrunePolicy defines which files are removed from the target directory
+type PrunePolicy struct {
+	// Enabled removes the files not in the spec, also enabled by the --prune
+	// flag of the CLI.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// Exclude are the glob patterns of the paths, relative to the target
+	// directory, that are never removed. A pattern matching a directory
+	// excludes everything under it, e.g. ".git".
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // FileSpec defines a single file in the ConfigDir
 type FileItem struct {
 	Path   string     `json:"path"`
 	Source FileSource `json:"source"`
+	// Template renders the content of the file as a Go text/template with
+	// the variables, e.g. {{ .repo }}. Besides those of the spec, namespace
+	// and name are the namespace and name of the ConfigDir, and the CLI adds
+	// those of its --var flags, e.g. sandbox and repo in sandboxes.
+	// +optional
+	Template bool `json:"template,omitempty"`
+	// Mode is the permission bits of the file, e.g. 0755 for scripts that
+	// need the executable bit. Defaults to 0644.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=511
+	// +optional
+	Mode *int32 `json:"mode,omitempty"`
+	// UID owns the file. Defaults to the user syncing the files, changing it
+	// requires running as root.
+	// +optional
+	UID *int64 `json:"uid,omitempty"`
+	// GID owns the file. Defaults to the group of the user syncing the files.
+	// +optional
+	GID *int64 `json:"gid,omitempty"`
 }
 
 // FileSource defines the source of a file's content
@@ -58,13 +117,66 @@ type URLSource struct {
 	// Optional secret for auth headers (e.g., "Authorization: Bearer <token>")
 	// +optional
 	SecretRef *corev1.SecretKeySelector `json:"secretRef,omitempty"`
+	// WorkloadIdentity authenticates with a token of the Google service
+	// account of the pod instead of a stored secret: an AccessToken for Cloud
+	// Storage and Artifact Registry URLs, or an IDToken whose audience is the
+	// origin of the URL, e.g. for Cloud Run. Exclusive with SecretRef.
+	// +kubebuilder:validation:Enum=AccessToken;IDToken
+	// +optional
+	WorkloadIdentity WorkloadIdentityToken `json:"workloadIdentity,omitempty"`
 }
 
+// WorkloadIdentityToken is the type of token authenticating a URL
+type WorkloadIdentityToken string
+
+const (
+	WorkloadIdentityAccessToken WorkloadIdentityToken = "AccessToken"
+	WorkloadIdentityIDToken     WorkloadIdentityToken = "IDToken"
+)
+
 // ConfigDirStatus defines the observed state of ConfigDir
 type ConfigDirStatus struct {
-	// Conditions of the ConfigDir.
+	// Conditions of the ConfigDir. Ready is false when a file can't be
+	// resolved, its message lists the errors of each file.
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ObservedGeneration is the generation of the spec last resolved.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ContentHash is the sha256 of the paths and checksums of the resolved
+	// files. It changes whenever the content of the directory does.
+	// +optional
+	ContentHash string `json:"contentHash,omitempty"`
+	// Files are the files of the spec as last resolved, to verify a
+	// materialized directory matches the spec.
+	// +optional
+	Files []FileStatus `json:"files,omitempty"`
+}
+
+// FileStatus is the observed state of a file of a ConfigDir
+type FileStatus struct {
+	Path string `json:"path"`
+	// SourceType is the type of the source of the file: inline, configMap,
+	// secret, url or fileContentKey.
+	SourceType string `json:"sourceType"`
+	// SHA256 is the hex encoded checksum of the content of the file, unset
+	// when it can't be resolved.
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+	// Size is the size of the content of the file in bytes.
+	// +optional
+	Size int64 `json:"size,omitempty"`
+	// Template is set for the files rendered as templates. The controller
+	// renders them without the variables of the CLI, as empty strings, so
+	// their materialized content doesn't match SHA256.
+	// +optional
+	Template bool `json:"template,omitempty"`
+	// LastSynced is when the file was first resolved to its current content.
+	// +optional
+	LastSynced *metav1.Time `json:"lastSynced,omitempty"`
+	// Error is why the file can't be resolved.
+	// +optional
+	Error string `json:"error,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -84,6 +84,30 @@ func (in *ConfigDirSpec) DeepCopyInto(out *ConfigDirSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PrunePolicy != nil {
+		in, out := &in.PrunePolicy, &out.PrunePolicy
+		*out = new(PrunePolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Variables != nil {
+		in, out := &in.Variables, &out.Variables
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.VariablesFrom != nil {
+		in, out := &in.VariablesFrom, &out.VariablesFrom
+		*out = make([]VariablesSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Includes != nil {
+		in, out := &in.Includes, &out.Includes
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigDirSpec.
@@ -106,6 +130,13 @@ func (in *ConfigDirStatus) DeepCopyInto(out *ConfigDirStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make([]FileStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigDirStatus.
@@ -238,6 +269,21 @@ func (in *FileContent) DeepCopy() *FileContent {
 func (in *FileItem) DeepCopyInto(out *FileItem) {
 	*out = *in
 	in.Source.DeepCopyInto(&out.Source)
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(int32)
+		**out = **in
+	}
+	if in.UID != nil {
+		in, out := &in.UID, &out.UID
+		*out = new(int64)
+		**out = **in
+	}
+	if in.GID != nil {
+		in, out := &in.GID, &out.GID
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FileItem.
@@ -280,6 +326,45 @@ func (in *FileSource) DeepCopy() *FileSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FileStatus) DeepCopyInto(out *FileStatus) {
+	*out = *in
+	if in.LastSynced != nil {
+		in, out := &in.LastSynced, &out.LastSynced
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FileStatus.
+func (in *FileStatus) DeepCopy() *FileStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FileStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrunePolicy) DeepCopyInto(out *PrunePolicy) {
+	*out = *in
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrunePolicy.
+func (in *PrunePolicy) DeepCopy() *PrunePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(PrunePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *URLSource) DeepCopyInto(out *URLSource) {
 	*out = *in
@@ -299,3 +384,28 @@ func (in *URLSource) DeepCopy() *URLSource {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VariablesSource) DeepCopyInto(out *VariablesSource) {
+	*out = *in
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VariablesSource.
+func (in *VariablesSource) DeepCopy() *VariablesSource {
+	if in == nil {
+		return nil
+	}
+	out := new(VariablesSource)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -17,17 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"flag"
 	"fmt"
-	"io"
+	"io/fs"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -37,18 +41,33 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/resolve"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
 )
 
 func main() {
 	var name, namespace, directory string
-	var syncToCluster, ignoreNotFoundError, includeFolderName bool
+	var syncToCluster, ignoreNotFoundError, includeFolderName, watchChanges bool
+	var opts syncOptions
+	var pruneExclude string
+	opts.variables = variables{}
+	var resyncPeriod time.Duration
 	flag.StringVar(&name, "name", "", "The name of the ConfigDir resource. If empty directory name is used.")
 	flag.StringVar(&namespace, "namespace", "default", "The namespace of the ConfigDir.")
 	flag.StringVar(&directory, "directory", "", "The directory to sync the files from or to.")
 	flag.BoolVar(&syncToCluster, "sync-to-cluster", false, "Sync from filesystem to cluster.")
 	flag.BoolVar(&includeFolderName, "include-folder-name", false, "includes the last item(folder) of the path passed to --directory parameter")
 	flag.BoolVar(&ignoreNotFoundError, "ignore-not-found-error", false, "ignores not found errors during sync.")
+	flag.BoolVar(&watchChanges, "watch", false, "Keep running and sync the files again whenever the ConfigDir or the ConfigMaps, Secrets and ConfigFiles it references change.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 5*time.Minute, "With --watch, how often the files are synced again to pick up changes of URL sources.")
+	flag.BoolVar(&opts.prune, "prune", false, "Remove the files of the directory that are not in the ConfigDir, as its spec.prunePolicy does.")
+	flag.StringVar(&pruneExclude, "prune-exclude", "", "Comma separated glob patterns of the paths never removed by --prune, in addition to the spec.prunePolicy ones, e.g. .git,*.log")
+	flag.Var(opts.variables, "var", "A key=value variable of the files that are templates, overriding those of the ConfigDir, e.g. sandbox=devc-1. Can be repeated.")
 	flag.Parse()
+	logging.Setup("configdir-cli", os.Stderr)
+	if pruneExclude != "" {
+		opts.pruneExclude = strings.Split(pruneExclude, ",")
+	}
 
 	cfg, err := config.GetConfig()
 	if err != nil {
@@ -84,6 +103,15 @@ func main() {
 		log.Fatalf("unable to create target directory: %v", err)
 	}
 
+	if watchChanges {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := watch(ctx, cfg, directory, name, namespace, resyncPeriod, opts); err != nil {
+			log.Fatalf("failed: %v", err)
+		}
+		return
+	}
+
 	configDir := &configdirv1alpha1.ConfigDir{}
 	if err := cli.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, configDir); err != nil {
 		if client.IgnoreNotFound(err) == nil && ignoreNotFoundError {
@@ -93,53 +121,197 @@ func main() {
 		log.Fatalf("unable to fetch ConfigDir: %v", err)
 	}
 
-	for _, file := range configDir.Spec.Files {
-		var content []byte
-		var err error
+	materialize(ctx, cli, configDir, directory, opts)
+}
 
-		source := file.Source
-		switch {
-		case source.Inline != "":
-			content = []byte(source.Inline)
-		case source.ConfigMapRef != nil:
-			cm := &corev1.ConfigMap{}
-			if err := cli.Get(ctx, types.NamespacedName{Name: source.ConfigMapRef.Name, Namespace: namespace}, cm); err != nil {
-				log.Printf("unable to fetch ConfigMap %s: %v", source.ConfigMapRef.Name, err)
-				continue
-			}
-			content = []byte(cm.Data[source.ConfigMapRef.Key])
-		case source.SecretRef != nil:
-			secret := &corev1.Secret{}
-			if err := cli.Get(ctx, types.NamespacedName{Name: source.SecretRef.Name, Namespace: namespace}, secret); err != nil {
-				log.Printf("unable to fetch Secret %s: %v", source.SecretRef.Name, err)
-				continue
+// syncOptions are the flags of the files synced to a directory.
+type syncOptions struct {
+	// prune and pruneExclude are merged with the spec.prunePolicy of the
+	// ConfigDir
+	prune        bool
+	pruneExclude []string
+	variables    variables
+}
+
+// variables are the --var flags.
+type variables map[string]string
+
+func (v variables) String() string {
+	return fmt.Sprint(map[string]string(v))
+}
+
+func (v variables) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not a key=value variable", value)
+	}
+	v[key] = val
+	return nil
+}
+
+// materialize writes the files of configDir to directory, logging those that
+// can't be resolved or written. Files whose content didn't change are left
+// untouched. With pruning enabled, the files not in the spec are removed.
+func materialize(ctx context.Context, reader client.Reader, configDir *configdirv1alpha1.ConfigDir, directory string, opts syncOptions) {
+	resolver := &resolve.Resolver{Client: reader, Variables: opts.variables}
+	files, err := resolver.Resolve(ctx, configDir)
+	if err != nil {
+		// Nothing is written, nor pruned, with files missing
+		log.Printf("unable to resolve ConfigDir %s: %v", configDir.Name, err)
+		return
+	}
+	for _, file := range files {
+		if file.Err != nil {
+			log.Printf("unable to resolve %s: %v", file.Path, file.Err)
+			continue
+		}
+
+		filePath := filepath.Join(directory, file.Path)
+		written, err := writeFile(filePath, file)
+		if err != nil {
+			log.Printf("unable to write file: %s: %v", filePath, err)
+			continue
+		}
+		if written {
+			log.Printf("synced file: %s", filePath)
+		}
+	}
+
+	prune, exclude := opts.prune, opts.pruneExclude
+	if policy := configDir.Spec.PrunePolicy; policy != nil {
+		prune = prune || policy.Enabled
+		exclude = append(append([]string{}, policy.Exclude...), exclude...)
+	}
+	if prune {
+		// Files that can't be resolved are kept, they are still in the spec
+		keep := map[string]bool{}
+		for _, file := range files {
+			keep[filepath.Clean(file.Path)] = true
+		}
+		if err := pruneFiles(directory, keep, exclude); err != nil {
+			log.Printf("unable to prune %s: %v", directory, err)
+		}
+	}
+}
+
+// pruneFiles removes the files of directory whose path relative to it is not
+// in keep nor matches a pattern of exclude, then the directories left empty.
+func pruneFiles(directory string, keep map[string]bool, exclude []string) error {
+	var dirs []string
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if excluded(rel, exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
-			content = secret.Data[source.SecretRef.Key]
-		case source.URL != nil:
-			content, err = fetchURL(ctx, cli, namespace, source.URL)
-			if err != nil {
-				log.Printf("unable to fetch URL %s: %v", source.URL.Location, err)
-				continue
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if keep[rel] {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Printf("pruned file: %s", path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Deepest first, so that parents emptied by their children are removed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := os.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
 			}
-		case source.FileContentKey != "":
-			content, err = findFileContent(ctx, cli, namespace, configDir.Spec.FileContentSelector, source.FileContentKey)
-			if err != nil {
-				log.Printf("unable to find file content key %s: %v", source.FileContentKey, err)
-				continue
+		}
+	}
+	return nil
+}
+
+// excluded reports whether a pattern of exclude matches path or one of its
+// parent directories.
+func excluded(path string, exclude []string) bool {
+	for p := path; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		for _, pattern := range exclude {
+			if ok, _ := filepath.Match(strings.TrimSpace(pattern), p); ok {
+				return true
 			}
 		}
+	}
+	return false
+}
 
-		filePath := filepath.Join(directory, file.Path)
-		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-			log.Printf("unable to create directory for %s: %v", filePath, err)
-			continue
+// writeFile writes a file to path unless it already has its content, then
+// sets its mode and owner. The file is replaced atomically, so that processes
+// reading it while it is synced again don't see it partially written.
+func writeFile(path string, file resolve.File) (bool, error) {
+	mode := file.Mode
+	if mode == 0 {
+		mode = resolve.DefaultMode
+	}
+	written := false
+	if existing, err := os.ReadFile(path); err != nil || !bytes.Equal(existing, file.Content) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return false, fmt.Errorf("unable to create directory: %w", err)
 		}
-		if err := os.WriteFile(filePath, content, 0644); err != nil {
-			log.Printf("unable to write file: %s: %v", filePath, err)
-			continue
+		tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(file.Content); err != nil {
+			tmp.Close()
+			return false, err
+		}
+		if err := tmp.Close(); err != nil {
+			return false, err
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return false, err
+		}
+		written = true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return written, err
+	}
+	if info.Mode().Perm() != mode {
+		if err := os.Chmod(path, mode); err != nil {
+			return written, fmt.Errorf("unable to set mode %o: %w", mode, err)
+		}
+		written = true
+	}
+	if file.UID != nil || file.GID != nil {
+		uid, gid := -1, -1
+		if file.UID != nil {
+			uid = int(*file.UID)
+		}
+		if file.GID != nil {
+			gid = int(*file.GID)
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || (uid != -1 && int(stat.Uid) != uid) || (gid != -1 && int(stat.Gid) != gid) {
+			if err := os.Lchown(path, uid, gid); err != nil {
+				return written, fmt.Errorf("unable to set owner %d:%d: %w", uid, gid, err)
+			}
+			written = true
 		}
-		log.Printf("synced file: %s", filePath)
 	}
+	return written, nil
 }
 
 func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir string, includeFolderName bool, configDirName, namespace string) error {
@@ -147,6 +319,7 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 		path    string // relative path
 		content []byte
 		size    int64
+		mode    os.FileMode
 	}
 	var files []fileInfo
 	var totalSize int64
@@ -170,7 +343,7 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 				return err
 			}
 			size := info.Size()
-			files = append(files, fileInfo{path: relPath, content: content, size: size})
+			files = append(files, fileInfo{path: relPath, content: content, size: size, mode: info.Mode().Perm()})
 			totalSize += size
 		}
 		return nil
@@ -189,6 +362,8 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 		Spec: configdirv1alpha1.ConfigDirSpec{},
 	}
 
+	// generated are the ConfigMaps and ConfigFiles holding the files
+	generated := map[string]bool{}
 	const oneMB = 1 * 1024 * 1024
 	if totalSize < oneMB {
 		log.Print("total size is less than 1MB, using inline files")
@@ -204,7 +379,24 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 		log.Print("total size is >= 1MB, using ConfigMaps for files")
 		for _, f := range files {
 			if f.size > oneMB {
-				return fmt.Errorf("file %s is larger than 1MB and cannot be stored in a ConfigMap", f.path)
+				log.Printf("file %s is larger than 1MB, splitting it across ConfigFiles", f.path)
+				names, err := syncConfigFileChunks(ctx, cli, configDirName, namespace, f.path, f.content)
+				if err != nil {
+					return err
+				}
+				for _, name := range names {
+					generated[name] = true
+				}
+				configDir.Spec.FileContentSelector = &metav1.LabelSelector{
+					MatchLabels: map[string]string{chunkLabel: configDirName},
+				}
+				configDir.Spec.Files = append(configDir.Spec.Files, configdirv1alpha1.FileItem{
+					Path: f.path,
+					Source: configdirv1alpha1.FileSource{
+						FileContentKey: f.path,
+					},
+				})
+				continue
 			}
 
 			cmName := fmt.Sprintf("%s-%s", configDirName, safeConfigMapName(f.path))
@@ -212,6 +404,7 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      cmName,
 					Namespace: namespace,
+					Labels:    map[string]string{generatedLabel: configDirName},
 				},
 				Data: map[string]string{
 					filepath.Base(f.path): string(f.content),
@@ -229,12 +422,17 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 				}
 				log.Printf("created configmap %s", cmName)
 			} else {
+				if existingCm.Labels == nil {
+					existingCm.Labels = map[string]string{}
+				}
+				existingCm.Labels[generatedLabel] = configDirName
 				existingCm.Data = cm.Data
 				if err := cli.Update(ctx, &existingCm); err != nil {
 					return fmt.Errorf("failed to update configmap %s: %w", cmName, err)
 				}
 				log.Printf("updated configmap %s", cmName)
 			}
+			generated[cmName] = true
 
 			configDir.Spec.Files = append(configDir.Spec.Files, configdirv1alpha1.FileItem{
 				Path: f.path,
@@ -250,6 +448,14 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 		}
 	}
 
+	// Keep the executable bit of scripts
+	for i, f := range files {
+		if f.mode != resolve.DefaultMode {
+			mode := int32(f.mode)
+			configDir.Spec.Files[i].Mode = &mode
+		}
+	}
+
 	var existingCd configdirv1alpha1.ConfigDir
 	err = cli.Get(ctx, types.NamespacedName{Name: configDirName, Namespace: namespace}, &existingCd)
 	if err != nil {
@@ -268,88 +474,114 @@ func syncConfigDataToCluster(ctx context.Context, cli client.Client, sourceDir s
 		log.Printf("updated configdir %s", configDirName)
 	}
 
-	return nil
+	// Only once the ConfigDir no longer references them
+	return deleteOrphans(ctx, cli, configDirName, namespace, generated)
 }
 
-func safeConfigMapName(filePath string) string {
-	h := sha256.New()
-	h.Write([]byte(filePath))
-	return hex.EncodeToString(h.Sum(nil))[:16]
-}
+// generatedLabel labels the ConfigMaps holding the files of a ConfigDir, so
+// that those no longer referenced are deleted.
+const generatedLabel = "configdir.gke.io/generated-for"
 
-func findFileContent(ctx context.Context, cli client.Client, namespace string, selector *metav1.LabelSelector, key string) ([]byte, error) {
-	// Search in ConfigMaps
+// deleteOrphans deletes the ConfigMaps and ConfigFile chunks generated for
+// the ConfigDir configDirName that are not in generated, e.g. those of files
+// removed from the directory or of a directory now small enough to be
+// inline.
+func deleteOrphans(ctx context.Context, cli client.Client, configDirName, namespace string, generated map[string]bool) error {
 	cmList := &corev1.ConfigMapList{}
-	if err := cli.List(ctx, cmList, client.InNamespace(namespace), client.MatchingLabels(selector.MatchLabels)); err != nil {
-		return nil, err
+	if err := cli.List(ctx, cmList, client.InNamespace(namespace), client.MatchingLabels{generatedLabel: configDirName}); err != nil {
+		return fmt.Errorf("failed to list configmaps: %w", err)
 	}
-	for _, cm := range cmList.Items {
-		if val, ok := cm.Data[key]; ok {
-			return []byte(val), nil
+	for i := range cmList.Items {
+		cm := &cmList.Items[i]
+		if generated[cm.Name] {
+			continue
 		}
-		if val, ok := cm.BinaryData[key]; ok {
-			return val, nil
+		if err := cli.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete configmap %s: %w", cm.Name, err)
 		}
+		log.Printf("deleted orphaned configmap %s", cm.Name)
 	}
 
-	// Search in ConfigFiles
 	cfList := &configdirv1alpha1.ConfigFileList{}
-	if err := cli.List(ctx, cfList, client.InNamespace(namespace), client.MatchingLabels(selector.MatchLabels)); err != nil {
-		return nil, err
-	}
-	for _, cf := range cfList.Items {
-		for _, file := range cf.Spec.Files {
-			if file.Path == key {
-				// content is base64 encoded
-				return base64.StdEncoding.DecodeString(file.Content)
-			}
+	if err := cli.List(ctx, cfList, client.InNamespace(namespace), client.MatchingLabels{chunkLabel: configDirName}); err != nil {
+		return fmt.Errorf("failed to list configfiles: %w", err)
+	}
+	for i := range cfList.Items {
+		cf := &cfList.Items[i]
+		if generated[cf.Name] {
+			continue
 		}
+		if err := cli.Delete(ctx, cf); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete configfile %s: %w", cf.Name, err)
+		}
+		log.Printf("deleted orphaned configfile %s", cf.Name)
 	}
-
-	return nil, fmt.Errorf("key %s not found in any matching ConfigMap or ConfigFile", key)
+	return nil
 }
 
-func fetchURL(ctx context.Context, cli client.Client, namespace string, urlSource *configdirv1alpha1.URLSource) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", urlSource.Location, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	if urlSource.SecretRef != nil {
-		secret := &corev1.Secret{}
-		if err := cli.Get(ctx, types.NamespacedName{Name: urlSource.SecretRef.Name, Namespace: namespace}, secret); err != nil {
-			return nil, fmt.Errorf("unable to fetch secret for URL auth: %w", err)
+// chunkLabel labels the ConfigFiles holding the chunks of the large files of
+// a ConfigDir, selected by its fileContentSelector.
+const chunkLabel = "configdir.gke.io/chunks-of"
+
+// chunkSize is the size of the content of each ConfigFile chunk, well under
+// the size limit of objects once base64 encoded.
+const chunkSize = 512 * 1024
+
+// syncConfigFileChunks splits a large file into ConfigFiles named
+// <configdir>-<hash>-<n>, each Continued in the next one, and returns their
+// names.
+func syncConfigFileChunks(ctx context.Context, cli client.Client, configDirName, namespace, path string, content []byte) ([]string, error) {
+	prefix := fmt.Sprintf("%s-%s", configDirName, safeConfigMapName(path))
+	count := (len(content) + chunkSize - 1) / chunkSize
+	names := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*chunkSize, len(content))
+		file := configdirv1alpha1.FileContent{
+			Path:    path,
+			Content: base64.StdEncoding.EncodeToString(content[i*chunkSize : end]),
 		}
-		token, ok := secret.Data[urlSource.SecretRef.Key]
-		if !ok {
-			return nil, fmt.Errorf("key %s not found in secret %s", urlSource.SecretRef.Key, urlSource.SecretRef.Name)
+		if i+1 < count {
+			file.Continued = fmt.Sprintf("%s-%d", prefix, i+1)
 		}
-		req.Header.Set("Authorization", string(token))
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		names = append(names, name)
+		spec := configdirv1alpha1.ConfigFileSpec{Files: []configdirv1alpha1.FileContent{file}}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if urlSource.SHA256 != "" {
-		hasher := sha256.New()
-		hasher.Write(content)
-		hash := hex.EncodeToString(hasher.Sum(nil))
-		if hash != urlSource.SHA256 {
-			return nil, fmt.Errorf("sha256 mismatch for %s", urlSource.Location)
+		var existing configdirv1alpha1.ConfigFile
+		err := cli.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &existing)
+		if err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("failed to get configfile %s: %w", name, err)
+			}
+			cf := &configdirv1alpha1.ConfigFile{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels:    map[string]string{chunkLabel: configDirName},
+				},
+				Spec: spec,
+			}
+			if err := cli.Create(ctx, cf); err != nil {
+				return nil, fmt.Errorf("failed to create configfile %s: %w", name, err)
+			}
+			log.Printf("created configfile %s", name)
+			continue
+		}
+		if existing.Labels == nil {
+			existing.Labels = map[string]string{}
 		}
+		existing.Labels[chunkLabel] = configDirName
+		existing.Spec = spec
+		if err := cli.Update(ctx, &existing); err != nil {
+			return nil, fmt.Errorf("failed to update configfile %s: %w", name, err)
+		}
+		log.Printf("updated configfile %s", name)
 	}
+	return names, nil
+}
 
-	return content, nil
+func safeConfigMapName(filePath string) string {
+	h := sha256.New()
+	h.Write([]byte(filePath))
+	return hex.EncodeToString(h.Sum(nil))[:16]
 }
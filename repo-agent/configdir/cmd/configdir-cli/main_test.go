@@ -0,0 +1,305 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/resolve"
+)
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompts", "review.txt")
+	for _, tt := range []struct {
+		content     string
+		wantWritten bool
+	}{
+		{"v1", true},
+		{"v1", false},
+		{"v2", true},
+	} {
+		written, err := writeFile(path, resolve.File{Content: []byte(tt.content)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != tt.wantWritten {
+			t.Errorf("writeFile(%q) = %v, want %v", tt.content, written, tt.wantWritten)
+		}
+		if got, _ := os.ReadFile(path); string(got) != tt.content {
+			t.Errorf("content = %q, want %q", got, tt.content)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want the temporary files removed", len(entries))
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pre-script.sh")
+	if _, err := writeFile(path, resolve.File{Content: []byte("#!/bin/sh")}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the mode changes
+	written, err := writeFile(path, resolve.File{Content: []byte("#!/bin/sh"), Mode: 0755})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if !written || info.Mode().Perm() != 0755 {
+		t.Errorf("writeFile() = %v, mode %o, want the file made executable", written, info.Mode().Perm())
+	}
+
+	// Owning the file by its current owner needs no privilege
+	stat := info.Sys().(*syscall.Stat_t)
+	uid, gid := int64(stat.Uid), int64(stat.Gid)
+	written, err = writeFile(path, resolve.File{Content: []byte("#!/bin/sh"), Mode: 0755, UID: &uid, GID: &gid})
+	if err != nil || written {
+		t.Errorf("writeFile() with the current owner = %v, %v, want nothing to do", written, err)
+	}
+}
+
+func TestMaterializePrune(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"old/removed.md", "old/nested/removed.md", ".git/HEAD", "agent.log", "kept.md"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configDir := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			Files: []configdirv1alpha1.FileItem{
+				{Path: "kept.md", Source: configdirv1alpha1.FileSource{Inline: "kept"}},
+				{Path: "prompts/review.md", Source: configdirv1alpha1.FileSource{Inline: "review"}},
+				// Not resolved, but still in the spec
+				{Path: "old/missing.md", Source: configdirv1alpha1.FileSource{ConfigMapRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Key: "k"}}},
+			},
+		},
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old/missing.md"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cli := clientfake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()
+
+	// Without pruning nothing is removed
+	materialize(context.Background(), cli, configDir, dir, syncOptions{})
+	if _, err := os.Stat(filepath.Join(dir, "old/removed.md")); err != nil {
+		t.Errorf("old/removed.md was removed without pruning: %v", err)
+	}
+
+	configDir.Spec.PrunePolicy = &configdirv1alpha1.PrunePolicy{Enabled: true, Exclude: []string{".git"}}
+	materialize(context.Background(), cli, configDir, dir, syncOptions{pruneExclude: []string{"*.log"}})
+	for path, want := range map[string]bool{
+		"kept.md":           true,
+		"prompts/review.md": true,
+		"old/missing.md":    true,
+		".git/HEAD":         true,
+		"agent.log":         true,
+		"old/removed.md":    false,
+		"old/nested":        false,
+	} {
+		_, err := os.Stat(filepath.Join(dir, path))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %v, want %v", path, got, want)
+		}
+	}
+	if len(configDir.Spec.PrunePolicy.Exclude) != 1 {
+		t.Errorf("spec.prunePolicy.exclude = %v, want it unchanged", configDir.Spec.PrunePolicy.Exclude)
+	}
+}
+
+func TestVariablesSet(t *testing.T) {
+	v := variables{}
+	for _, value := range []string{"sandbox=devc-1", "url=https://example.com/?a=b", "empty="} {
+		if err := v.Set(value); err != nil {
+			t.Errorf("Set(%q) = %v", value, err)
+		}
+	}
+	if v["url"] != "https://example.com/?a=b" || v["empty"] != "" || v["sandbox"] != "devc-1" {
+		t.Errorf("variables = %v", v)
+	}
+	for _, value := range []string{"novalue", "=value"} {
+		if err := v.Set(value); err == nil {
+			t.Errorf("Set(%q) = nil, want an error", value)
+		}
+	}
+}
+
+func TestReferencesMatches(t *testing.T) {
+	secretRef := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "url-auth"}, Key: "header"}
+	refs := &references{name: "agent"}
+	refs.set(&configdirv1alpha1.ConfigDir{
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			FileContentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"project": "agent"}},
+			Files: []configdirv1alpha1.FileItem{
+				{Path: "a", Source: configdirv1alpha1.FileSource{ConfigMapRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "prompts"}}}},
+				{Path: "b", Source: configdirv1alpha1.FileSource{URL: &configdirv1alpha1.URLSource{Location: "https://example.com", SecretRef: secretRef}}},
+			},
+			VariablesFrom: []configdirv1alpha1.VariablesSource{{SecretRef: &corev1.LocalObjectReference{Name: "repo-vars"}}},
+			Includes:      []corev1.LocalObjectReference{{Name: "base"}},
+		},
+	}, []*configdirv1alpha1.ConfigDir{{
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			FileContentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"project": "base"}},
+		},
+	}})
+	meta := func(name string, labels map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Labels: labels}
+	}
+	tests := []struct {
+		obj  interface{}
+		want bool
+	}{
+		{&corev1.ConfigMap{ObjectMeta: meta("prompts", nil)}, true},
+		{&corev1.ConfigMap{ObjectMeta: meta("other", nil)}, false},
+		{&corev1.ConfigMap{ObjectMeta: meta("chunk", map[string]string{"project": "agent"})}, true},
+		{&corev1.Secret{ObjectMeta: meta("url-auth", nil)}, true},
+		{toolscache.DeletedFinalStateUnknown{Obj: &corev1.Secret{ObjectMeta: meta("url-auth", nil)}}, true},
+		{&corev1.Secret{ObjectMeta: meta("other", map[string]string{"project": "agent"})}, false},
+		{&corev1.Secret{ObjectMeta: meta("repo-vars", nil)}, true},
+		{&configdirv1alpha1.ConfigFile{ObjectMeta: meta("assets", map[string]string{"project": "agent"})}, true},
+		{&configdirv1alpha1.ConfigFile{ObjectMeta: meta("assets", nil)}, false},
+		{&configdirv1alpha1.ConfigFile{ObjectMeta: meta("base-assets", map[string]string{"project": "base"})}, true},
+		{&configdirv1alpha1.ConfigDir{ObjectMeta: meta("agent", nil)}, true},
+		{&configdirv1alpha1.ConfigDir{ObjectMeta: meta("base", nil)}, true},
+		{&configdirv1alpha1.ConfigDir{ObjectMeta: meta("other", nil)}, false},
+	}
+	for _, tt := range tests {
+		if got := refs.matches(tt.obj); got != tt.want {
+			t.Errorf("matches(%#v) = %v, want %v", tt.obj, got, tt.want)
+		}
+	}
+}
+
+func TestSyncLargeFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	large := bytes.Repeat([]byte("0123456789"), 130*1024)
+	if err := os.WriteFile(filepath.Join(dir, "large.bin"), large, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "small.sh"), []byte("#!/bin/sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = configdirv1alpha1.AddToScheme(s)
+	cli := clientfake.NewClientBuilder().WithScheme(s).Build()
+	if err := syncConfigDataToCluster(ctx, cli, dir, false, "agent", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := &configdirv1alpha1.ConfigFileList{}
+	if err := cli.List(ctx, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if want := (len(large) + chunkSize - 1) / chunkSize; len(chunks.Items) != want {
+		t.Errorf("got %d ConfigFiles, want %d chunks", len(chunks.Items), want)
+	}
+	configDir := &configdirv1alpha1.ConfigDir{}
+	if err := cli.Get(ctx, types.NamespacedName{Name: "agent", Namespace: "default"}, configDir); err != nil {
+		t.Fatal(err)
+	}
+	files, err := (&resolve.Resolver{Client: cli}).Resolve(ctx, configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.Err != nil {
+			t.Errorf("%s: %v", f.Path, f.Err)
+		}
+		if f.Path == "small.sh" && f.Mode != 0755 {
+			t.Errorf("small.sh mode = %o, want the executable bit kept", f.Mode)
+		}
+		if f.Path == "large.bin" && !bytes.Equal(f.Content, large) {
+			t.Errorf("large.bin resolved to %d bytes, want the %d bytes synced", len(f.Content), len(large))
+		}
+	}
+}
+
+func TestSyncDeletesOrphans(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = configdirv1alpha1.AddToScheme(s)
+	unrelated := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "agent-unrelated", Namespace: "default"}}
+	cli := clientfake.NewClientBuilder().WithScheme(s).WithObjects(unrelated).Build()
+
+	count := func() (configMaps, configFiles int) {
+		cmList := &corev1.ConfigMapList{}
+		cfList := &configdirv1alpha1.ConfigFileList{}
+		if err := cli.List(ctx, cmList); err != nil {
+			t.Fatal(err)
+		}
+		if err := cli.List(ctx, cfList); err != nil {
+			t.Fatal(err)
+		}
+		return len(cmList.Items), len(cfList.Items)
+	}
+	sync := func(files map[string][]byte) {
+		for path, content := range files {
+			if content == nil {
+				os.Remove(filepath.Join(dir, path))
+			} else if err := os.WriteFile(filepath.Join(dir, path), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := syncConfigDataToCluster(ctx, cli, dir, false, "agent", "default"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sync(map[string][]byte{"large.bin": bytes.Repeat([]byte("0123456789"), 170*1024), "a.txt": []byte("a")})
+	if cms, cfs := count(); cms != 2 || cfs != 4 {
+		t.Errorf("got %d ConfigMaps and %d ConfigFiles, want 2 and 4 chunks", cms, cfs)
+	}
+
+	// The file shrinks to 3 chunks
+	sync(map[string][]byte{"large.bin": bytes.Repeat([]byte("0123456789"), 130*1024)})
+	if cms, cfs := count(); cms != 2 || cfs != 3 {
+		t.Errorf("got %d ConfigMaps and %d ConfigFiles, want 2 and 3 chunks", cms, cfs)
+	}
+
+	// Small enough to be inline, only the unrelated ConfigMap is left
+	sync(map[string][]byte{"large.bin": nil})
+	if cms, cfs := count(); cms != 1 || cfs != 0 {
+		t.Errorf("got %d ConfigMaps and %d ConfigFiles, want only the unrelated ConfigMap", cms, cfs)
+	}
+	if err := cli.Get(ctx, types.NamespacedName{Name: "agent-unrelated", Namespace: "default"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("unrelated ConfigMap: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/resolve"
+)
+
+// watchDebounce is how long the files are synced after the first change of a
+// burst, e.g. a ConfigDir and its ConfigMaps applied together.
+const watchDebounce = time.Second
+
+// references are the objects the files of a ConfigDir are read from, so that
+// only their changes trigger a sync.
+type references struct {
+	// name is the ConfigDir synced
+	name       string
+	mu         sync.Mutex
+	configDirs map[string]bool
+	configMaps map[string]bool
+	secrets    map[string]bool
+	selectors  []labels.Selector
+}
+
+// set records the objects configDir and the ConfigDirs it includes
+// reference, none if it is nil.
+func (r *references) set(configDir *configdirv1alpha1.ConfigDir, included []*configdirv1alpha1.ConfigDir) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configDirs, r.configMaps, r.secrets, r.selectors = map[string]bool{}, map[string]bool{}, map[string]bool{}, nil
+	if configDir == nil {
+		return
+	}
+	for _, dir := range append(included, configDir) {
+		r.add(dir)
+	}
+}
+
+func (r *references) add(configDir *configdirv1alpha1.ConfigDir) {
+	// Includes that don't exist yet too
+	for _, include := range configDir.Spec.Includes {
+		r.configDirs[include.Name] = true
+	}
+	for _, file := range configDir.Spec.Files {
+		source := file.Source
+		switch {
+		case source.ConfigMapRef != nil:
+			r.configMaps[source.ConfigMapRef.Name] = true
+		case source.SecretRef != nil:
+			r.secrets[source.SecretRef.Name] = true
+		case source.URL != nil && source.URL.SecretRef != nil:
+			r.secrets[source.URL.SecretRef.Name] = true
+		}
+	}
+	for _, from := range configDir.Spec.VariablesFrom {
+		switch {
+		case from.ConfigMapRef != nil:
+			r.configMaps[from.ConfigMapRef.Name] = true
+		case from.SecretRef != nil:
+			r.secrets[from.SecretRef.Name] = true
+		}
+	}
+	if configDir.Spec.FileContentSelector != nil {
+		if selector, err := metav1.LabelSelectorAsSelector(configDir.Spec.FileContentSelector); err == nil {
+			r.selectors = append(r.selectors, selector)
+		}
+	}
+}
+
+// matches reports whether a change of obj may change the files.
+func (r *references) matches(obj interface{}) bool {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch o := obj.(type) {
+	case *configdirv1alpha1.ConfigDir:
+		return o.Name == r.name || r.configDirs[o.Name]
+	case *configdirv1alpha1.ConfigFile:
+		return r.selected(o.Labels)
+	case *corev1.ConfigMap:
+		return r.configMaps[o.Name] || r.selected(o.Labels)
+	case *corev1.Secret:
+		return r.secrets[o.Name]
+	}
+	return false
+}
+
+func (r *references) selected(objLabels map[string]string) bool {
+	for _, selector := range r.selectors {
+		if selector.Matches(labels.Set(objLabels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch syncs the files of the ConfigDir name to directory, then again
+// whenever it or the objects it references change, and every resyncPeriod
+// for its URL sources, until ctx is done. It needs to list and watch the
+// ConfigMaps and Secrets of the namespace.
+func watch(ctx context.Context, cfg *rest.Config, directory, name, namespace string, resyncPeriod time.Duration, opts syncOptions) error {
+	// All the ConfigDirs of the namespace are watched for the included ones
+	c, err := cache.New(cfg, cache.Options{
+		Scheme:            scheme.Scheme,
+		DefaultNamespaces: map[string]cache.Config{namespace: {}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create cache: %w", err)
+	}
+
+	refs := &references{name: name}
+	refs.set(nil, nil)
+	changed := make(chan struct{}, 1)
+	notify := func(obj interface{}) {
+		if refs.matches(obj) {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	handler := toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj interface{}) { notify(obj) },
+		DeleteFunc: notify,
+	}
+	for _, obj := range []client.Object{&configdirv1alpha1.ConfigDir{}, &configdirv1alpha1.ConfigFile{}, &corev1.ConfigMap{}, &corev1.Secret{}} {
+		informer, err := c.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("unable to watch %T: %w", obj, err)
+		}
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("unable to watch %T: %w", obj, err)
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.Start(ctx) }()
+	if !c.WaitForCacheSync(ctx) {
+		return errors.New("unable to sync cache")
+	}
+	log.Printf("watching ConfigDir %s in namespace %s", name, namespace)
+
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+	for {
+		configDir := &configdirv1alpha1.ConfigDir{}
+		err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, configDir)
+		switch {
+		case apierrors.IsNotFound(err):
+			log.Printf("ConfigDir %s not found in namespace %s, waiting for it", name, namespace)
+			refs.set(nil, nil)
+		case err != nil:
+			log.Printf("unable to fetch ConfigDir: %v", err)
+		default:
+			// Without the includes that can't be fetched, the error is logged
+			// by materialize
+			included, _ := resolve.Includes(ctx, c, configDir)
+			refs.set(configDir, included)
+			materialize(ctx, c, configDir, directory, opts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return <-errs
+		case <-ticker.C:
+		case <-changed:
+			// Let the rest of the burst land
+			select {
+			case <-ctx.Done():
+				return <-errs
+			case <-time.After(watchDebounce):
+			}
+			select {
+			case <-changed:
+			default:
+			}
+		}
+	}
+}
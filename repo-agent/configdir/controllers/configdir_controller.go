@@ -18,45 +18,231 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/resolve"
+)
+
+const (
+	// ConditionReady is true when all the files of a ConfigDir are resolved.
+	ConditionReady = "Ready"
+
+	reasonResolved      = "Resolved"
+	reasonResolveFailed = "ResolveFailed"
+	reasonIncludeFailed = "IncludeFailed"
+
+	// defaultResyncPeriod is how often ConfigDirs are resolved again to
+	// notice changes of their URLs, Secrets and ConfigMaps, which aren't
+	// watched.
+	defaultResyncPeriod = 5 * time.Minute
+	// failedResyncPeriod is how often ConfigDirs with unresolved files are
+	// retried.
+	failedResyncPeriod = time.Minute
 )
 
 // ConfigDirReconciler reconciles a ConfigDir object
 type ConfigDirReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// APIReader reads the sources of the files. It should be uncached, so
+	// that the Secrets of the cluster aren't all kept in memory.
+	APIReader client.Reader
+	// HTTPClient fetches URL sources, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// ResyncPeriod is defaultResyncPeriod if zero
+	ResyncPeriod time.Duration
 }
 
 //+kubebuilder:rbac:groups=configdir.gke.io,resources=configdirs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=configdir.gke.io,resources=configdirs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=configdir.gke.io,resources=configdirs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=configdir.gke.io,resources=configfiles,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile resolves the sources of the files of a ConfigDir and records the
+// aggregate checksum of their content, or the errors of the files that
+// couldn't be resolved, in its status.
+func (r *ConfigDirReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	configDir := &configdirv1alpha1.ConfigDir{}
+	if err := r.Get(ctx, req.NamespacedName, configDir); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "unable to fetch ConfigDir")
+		return ctrl.Result{}, err
+	}
+
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	// The variables given to the CLI are unknown, the status marks the
+	// templates whose checksums don't cover them
+	resolver := &resolve.Resolver{Client: reader, HTTPClient: r.HTTPClient, IgnoreMissingVariables: true}
+	files, err := resolver.Resolve(ctx, configDir)
+	if err != nil {
+		// The files of the previous resolution are kept in the status
+		status := configDir.Status.DeepCopy()
+		status.ObservedGeneration = configDir.Generation
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             reasonIncludeFailed,
+			Message:            err.Error(),
+			ObservedGeneration: configDir.Generation,
+		})
+		if !equality.Semantic.DeepEqual(status, &configDir.Status) {
+			configDir.Status = *status
+			if err := r.Status().Update(ctx, configDir); err != nil {
+				log.Error(err, "unable to update ConfigDir status")
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("unable to resolve includes", "error", err.Error())
+		return ctrl.Result{RequeueAfter: failedResyncPeriod}, nil
+	}
+
+	status := configDir.Status.DeepCopy()
+	status.ObservedGeneration = configDir.Generation
+	status.ContentHash = resolve.ContentHash(files)
+	condition := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonResolved,
+		Message:            fmt.Sprintf("%d files resolved", len(files)),
+		ObservedGeneration: configDir.Generation,
+	}
+	status.Files = fileStatuses(files, configDir.Status.Files, metav1.Now())
+	var failed []string
+	for _, f := range files {
+		if f.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.Path, f.Err))
+		}
+	}
+	if len(failed) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonResolveFailed
+		condition.Message = fmt.Sprintf("%d of %d files failed to resolve: %s", len(failed), len(files), strings.Join(failed, "; "))
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if !equality.Semantic.DeepEqual(status, &configDir.Status) {
+		configDir.Status = *status
+		if err := r.Status().Update(ctx, configDir); err != nil {
+			log.Error(err, "unable to update ConfigDir status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if len(failed) > 0 {
+		log.Info("unable to resolve files", "errors", failed)
+		return ctrl.Result{RequeueAfter: failedResyncPeriod}, nil
+	}
+	resync := r.ResyncPeriod
+	if resync == 0 {
+		resync = defaultResyncPeriod
+	}
+	return ctrl.Result{RequeueAfter: resync}, nil
+}
+
+// fileStatuses returns the status of the resolved files. Files whose content
+// didn't change since the previous statuses keep their LastSynced, others
+// are synced at now.
+func fileStatuses(files []resolve.File, previous []configdirv1alpha1.FileStatus, now metav1.Time) []configdirv1alpha1.FileStatus {
+	synced := map[string]*metav1.Time{}
+	for _, f := range previous {
+		if f.SHA256 != "" {
+			synced[f.Path+"\x00"+f.SHA256] = f.LastSynced
+		}
+	}
+	statuses := make([]configdirv1alpha1.FileStatus, 0, len(files))
+	for _, f := range files {
+		status := configdirv1alpha1.FileStatus{Path: f.Path, SourceType: f.Source, Template: f.Template}
+		if f.Err != nil {
+			status.Error = f.Err.Error()
+			statuses = append(statuses, status)
+			continue
+		}
+		status.SHA256 = f.SHA256
+		status.Size = int64(len(f.Content))
+		status.LastSynced = synced[f.Path+"\x00"+f.SHA256]
+		if status.LastSynced == nil {
+			status.LastSynced = &now
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// configDirsForConfigFile returns the ConfigDirs whose fileContentSelector
+// matches a ConfigFile.
+func (r *ConfigDirReconciler) configDirsForConfigFile(ctx context.Context, obj client.Object) []reconcile.Request {
+	configDirs := &configdirv1alpha1.ConfigDirList{}
+	if err := r.List(ctx, configDirs, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list ConfigDirs")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, configDir := range configDirs.Items {
+		if configDir.Spec.FileContentSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(configDir.Spec.FileContentSelector)
+		if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: configDir.Name, Namespace: configDir.Namespace}})
+	}
+	return requests
+}
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the ConfigDir object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
-func (r *ConfigDirReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-
-	// TODO(user): your logic here
-
-	return ctrl.Result{}, nil
+// configDirsForConfigDir returns the ConfigDirs including a ConfigDir. Those
+// including them in turn are reconciled when their status changes.
+func (r *ConfigDirReconciler) configDirsForConfigDir(ctx context.Context, obj client.Object) []reconcile.Request {
+	configDirs := &configdirv1alpha1.ConfigDirList{}
+	if err := r.List(ctx, configDirs, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list ConfigDirs")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, configDir := range configDirs.Items {
+		for _, include := range configDir.Spec.Includes {
+			if include.Name == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: configDir.Name, Namespace: configDir.Namespace}})
+				break
+			}
+		}
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ConfigDirReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&configdirv1alpha1.ConfigDir{}).
+		Watches(&configdirv1alpha1.ConfigFile{}, handler.EnqueueRequestsFromMapFunc(r.configDirsForConfigFile)).
+		Watches(&configdirv1alpha1.ConfigDir{}, handler.EnqueueRequestsFromMapFunc(r.configDirsForConfigDir)).
 		Complete(r)
 }
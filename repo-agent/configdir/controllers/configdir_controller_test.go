@@ -0,0 +1,160 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/resolve"
+)
+
+func TestConfigDirReconciler_Reconcile(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = configdirv1alpha1.AddToScheme(s)
+	configDir := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default", Generation: 1},
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			Files: []configdirv1alpha1.FileItem{
+				{Path: "GEMINI.md", Source: configdirv1alpha1.FileSource{Inline: "# Agent"}},
+				{Path: "prompts/review.txt", Source: configdirv1alpha1.FileSource{ConfigMapRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "prompts"}, Key: "review.txt",
+				}}},
+			},
+		},
+	}
+	c := clientfake.NewClientBuilder().WithScheme(s).WithObjects(configDir).WithStatusSubresource(configDir).Build()
+	r := &ConfigDirReconciler{Client: c, Scheme: s}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "agent", Namespace: "default"}}
+
+	// The ConfigMap doesn't exist yet
+	result, err := r.Reconcile(ctx, req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.Equal(failedResyncPeriod))
+	g.Expect(c.Get(ctx, req.NamespacedName, configDir)).To(gomega.Succeed())
+	ready := meta.FindStatusCondition(configDir.Status.Conditions, ConditionReady)
+	g.Expect(ready).NotTo(gomega.BeNil())
+	g.Expect(ready.Status).To(gomega.Equal(metav1.ConditionFalse))
+	g.Expect(ready.Message).To(gomega.ContainSubstring("1 of 2 files failed to resolve: prompts/review.txt: unable to fetch ConfigMap prompts"))
+	partialHash := configDir.Status.ContentHash
+
+	g.Expect(c.Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "prompts", Namespace: "default"},
+		Data:       map[string]string{"review.txt": "Review the PR."},
+	})).To(gomega.Succeed())
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.Equal(defaultResyncPeriod))
+	g.Expect(c.Get(ctx, req.NamespacedName, configDir)).To(gomega.Succeed())
+	g.Expect(meta.IsStatusConditionTrue(configDir.Status.Conditions, ConditionReady)).To(gomega.BeTrue())
+	g.Expect(configDir.Status.ObservedGeneration).To(gomega.Equal(int64(1)))
+	g.Expect(configDir.Status.ContentHash).To(gomega.HaveLen(64))
+	g.Expect(configDir.Status.ContentHash).NotTo(gomega.Equal(partialHash))
+	g.Expect(configDir.Status.Files).To(gomega.HaveLen(2))
+	review := configDir.Status.Files[1]
+	g.Expect(review.Path).To(gomega.Equal("prompts/review.txt"))
+	g.Expect(review.SourceType).To(gomega.Equal("configMap"))
+	g.Expect(review.Size).To(gomega.Equal(int64(len("Review the PR."))))
+	g.Expect(review.SHA256).To(gomega.HaveLen(64))
+	g.Expect(review.LastSynced).NotTo(gomega.BeNil())
+	g.Expect(review.Error).To(gomega.BeEmpty())
+
+	// Resolving the same content again doesn't update the status
+	resourceVersion := configDir.ResourceVersion
+	_, err = r.Reconcile(ctx, req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Get(ctx, req.NamespacedName, configDir)).To(gomega.Succeed())
+	g.Expect(configDir.ResourceVersion).To(gomega.Equal(resourceVersion))
+}
+
+func TestFileStatuses(t *testing.T) {
+	g := gomega.NewWithT(t)
+	before := metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := metav1.NewTime(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+	previous := []configdirv1alpha1.FileStatus{
+		{Path: "same.md", SHA256: "aaa", LastSynced: &before},
+		{Path: "changed.md", SHA256: "bbb", LastSynced: &before},
+	}
+	statuses := fileStatuses([]resolve.File{
+		{Path: "same.md", Source: resolve.SourceInline, SHA256: "aaa", Content: []byte("a")},
+		{Path: "changed.md", Source: resolve.SourceInline, SHA256: "ccc", Content: []byte("cc"), Template: true},
+		{Path: "failed.md", Source: resolve.SourceURL, Err: errors.New("unexpected status code: 404")},
+	}, previous, now)
+	g.Expect(statuses).To(gomega.Equal([]configdirv1alpha1.FileStatus{
+		{Path: "same.md", SourceType: "inline", SHA256: "aaa", Size: 1, LastSynced: &before},
+		{Path: "changed.md", SourceType: "inline", SHA256: "ccc", Size: 2, LastSynced: &now, Template: true},
+		{Path: "failed.md", SourceType: "url", Error: "unexpected status code: 404"},
+	}))
+}
+
+func TestConfigDirsForConfigFile(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	s := runtime.NewScheme()
+	_ = configdirv1alpha1.AddToScheme(s)
+	selected := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "selected", Namespace: "default"},
+		Spec:       configdirv1alpha1.ConfigDirSpec{FileContentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"project": "agent"}}},
+	}
+	other := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+		Spec:       configdirv1alpha1.ConfigDirSpec{FileContentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"project": "other"}}},
+	}
+	noSelector := &configdirv1alpha1.ConfigDir{ObjectMeta: metav1.ObjectMeta{Name: "none", Namespace: "default"}}
+	r := &ConfigDirReconciler{Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(selected, other, noSelector).Build()}
+
+	requests := r.configDirsForConfigFile(context.Background(), &configdirv1alpha1.ConfigFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "assets", Namespace: "default", Labels: map[string]string{"project": "agent"}},
+	})
+	g.Expect(requests).To(gomega.HaveLen(1))
+	g.Expect(requests[0].Name).To(gomega.Equal("selected"))
+}
+
+func TestConfigDirsForConfigDir(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	s := runtime.NewScheme()
+	_ = configdirv1alpha1.AddToScheme(s)
+	base := &configdirv1alpha1.ConfigDir{ObjectMeta: metav1.ObjectMeta{Name: "base", Namespace: "default"}}
+	repo := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+		Spec:       configdirv1alpha1.ConfigDirSpec{Includes: []corev1.LocalObjectReference{{Name: "base"}}},
+	}
+	r := &ConfigDirReconciler{Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(base, repo).Build()}
+
+	requests := r.configDirsForConfigDir(context.Background(), base)
+	g.Expect(requests).To(gomega.HaveLen(1))
+	g.Expect(requests[0].Name).To(gomega.Equal("repo"))
+	g.Expect(r.configDirsForConfigDir(context.Background(), repo)).To(gomega.BeEmpty())
+}
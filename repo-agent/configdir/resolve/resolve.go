@@ -0,0 +1,481 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resolve fetches the content of the files of a ConfigDir from their
+// sources. It is shared by the CLI, which writes the files to a directory,
+// and the controller, which reports their checksums in the status.
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/gcp"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+)
+
+// Source types of a file
+const (
+	SourceInline         = "inline"
+	SourceConfigMap      = "configMap"
+	SourceSecret         = "secret"
+	SourceURL            = "url"
+	SourceFileContentKey = "fileContentKey"
+)
+
+// DefaultMode is the mode of the files without one.
+const DefaultMode os.FileMode = 0644
+
+// Built-in variables of templates
+const (
+	VariableNamespace = "namespace"
+	VariableName      = "name"
+)
+
+// File is a file of a ConfigDir with its content, or the error fetching it.
+type File struct {
+	Path string
+	// Source is the type of the source of the file, e.g. SourceInline
+	Source  string
+	Content []byte
+	// Mode is the permission bits of the file, DefaultMode if unset
+	Mode os.FileMode
+	// UID and GID own the file, nil to leave it to the syncing user
+	UID, GID *int64
+	// Template is set when Content is rendered from a template
+	Template bool
+	// SHA256 is the hex encoded checksum of Content
+	SHA256 string
+	Err    error
+}
+
+// Resolver fetches the sources of the files of ConfigDirs.
+type Resolver struct {
+	Client client.Reader
+	// HTTPClient fetches URL sources, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// MetadataURL mints the workload identity tokens of URL sources, the
+	// default service account of the metadata server if empty
+	MetadataURL string
+	// Variables are added to those of templates, overriding those of the
+	// spec, e.g. the name of the sandbox the files are synced to
+	Variables map[string]string
+	// IgnoreMissingVariables renders the variables of templates that aren't
+	// set as empty strings instead of failing, for the controller which
+	// doesn't know the Variables of the CLI
+	IgnoreMissingVariables bool
+
+	tokensOnce sync.Once
+	tokens     *gcp.TokenSource
+}
+
+// Resolve returns the files of configDir merged with those of the ConfigDirs
+// it includes, in the order of their specs. A file whose source can't be
+// fetched has its Err set, the others are still resolved. An error is
+// returned only when an included ConfigDir can't be fetched, since the files
+// would be incomplete.
+func (r *Resolver) Resolve(ctx context.Context, configDir *configdirv1alpha1.ConfigDir) ([]File, error) {
+	included, err := Includes(ctx, r.Client, configDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	index := map[string]int{}
+	for _, dir := range append(included, configDir) {
+		for _, file := range r.resolveFiles(ctx, dir) {
+			// Files override those of the earlier ConfigDirs in place
+			if i, ok := index[file.Path]; ok {
+				files[i] = file
+				continue
+			}
+			index[file.Path] = len(files)
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// Includes returns the ConfigDirs configDir includes, directly or through
+// other included ConfigDirs, in the order their files are merged: those a
+// ConfigDir includes before it. A ConfigDir included several times is merged
+// once, where first included.
+func Includes(ctx context.Context, reader client.Reader, configDir *configdirv1alpha1.ConfigDir) ([]*configdirv1alpha1.ConfigDir, error) {
+	var included []*configdirv1alpha1.ConfigDir
+	done := map[string]bool{}
+	including := map[string]bool{}
+	var include func(dir *configdirv1alpha1.ConfigDir) error
+	include = func(dir *configdirv1alpha1.ConfigDir) error {
+		including[dir.Name] = true
+		for _, ref := range dir.Spec.Includes {
+			if including[ref.Name] {
+				return fmt.Errorf("ConfigDir %s includes %s in a loop", dir.Name, ref.Name)
+			}
+			if done[ref.Name] {
+				continue
+			}
+			child := &configdirv1alpha1.ConfigDir{}
+			if err := reader.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: configDir.Namespace}, child); err != nil {
+				return fmt.Errorf("unable to fetch ConfigDir %s included by %s: %w", ref.Name, dir.Name, err)
+			}
+			if err := include(child); err != nil {
+				return err
+			}
+			done[ref.Name] = true
+			included = append(included, child)
+		}
+		including[dir.Name] = false
+		return nil
+	}
+	if err := include(configDir); err != nil {
+		return nil, err
+	}
+	return included, nil
+}
+
+// resolveFiles returns the files of the spec of configDir.
+func (r *Resolver) resolveFiles(ctx context.Context, configDir *configdirv1alpha1.ConfigDir) []File {
+	files := make([]File, 0, len(configDir.Spec.Files))
+	var vars map[string]string
+	var varsErr error
+	for _, item := range configDir.Spec.Files {
+		file := File{Path: item.Path, Source: SourceType(item.Source), Mode: DefaultMode, UID: item.UID, GID: item.GID, Template: item.Template}
+		if item.Mode != nil {
+			file.Mode = os.FileMode(*item.Mode).Perm()
+		}
+		file.Content, file.Err = r.fetch(ctx, configDir, item.Source)
+		if file.Err == nil && item.Template {
+			// Variables are only fetched for ConfigDirs with templates
+			if vars == nil && varsErr == nil {
+				vars, varsErr = r.variables(ctx, configDir)
+			}
+			if file.Err = varsErr; file.Err == nil {
+				file.Content, file.Err = r.render(item.Path, file.Content, vars)
+			}
+		}
+		if file.Err == nil {
+			sum := sha256.Sum256(file.Content)
+			file.SHA256 = hex.EncodeToString(sum[:])
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// SourceType returns the type of a source. A source without any set is an
+// empty inline file.
+func SourceType(source configdirv1alpha1.FileSource) string {
+	switch {
+	case source.ConfigMapRef != nil:
+		return SourceConfigMap
+	case source.SecretRef != nil:
+		return SourceSecret
+	case source.URL != nil:
+		return SourceURL
+	case source.FileContentKey != "":
+		return SourceFileContentKey
+	}
+	return SourceInline
+}
+
+func (r *Resolver) fetch(ctx context.Context, configDir *configdirv1alpha1.ConfigDir, source configdirv1alpha1.FileSource) ([]byte, error) {
+	namespace := configDir.Namespace
+	switch SourceType(source) {
+	case SourceInline:
+		return []byte(source.Inline), nil
+	case SourceConfigMap:
+		cm := &corev1.ConfigMap{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: source.ConfigMapRef.Name, Namespace: namespace}, cm); err != nil {
+			return nil, fmt.Errorf("unable to fetch ConfigMap %s: %w", source.ConfigMapRef.Name, err)
+		}
+		if val, ok := cm.Data[source.ConfigMapRef.Key]; ok {
+			return []byte(val), nil
+		}
+		if val, ok := cm.BinaryData[source.ConfigMapRef.Key]; ok {
+			return val, nil
+		}
+		return nil, fmt.Errorf("key %s not found in ConfigMap %s", source.ConfigMapRef.Key, source.ConfigMapRef.Name)
+	case SourceSecret:
+		secret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: source.SecretRef.Name, Namespace: namespace}, secret); err != nil {
+			return nil, fmt.Errorf("unable to fetch Secret %s: %w", source.SecretRef.Name, err)
+		}
+		val, ok := secret.Data[source.SecretRef.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in Secret %s", source.SecretRef.Key, source.SecretRef.Name)
+		}
+		return val, nil
+	case SourceURL:
+		content, err := r.fetchURL(ctx, namespace, source.URL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch URL %s: %w", source.URL.Location, err)
+		}
+		return content, nil
+	case SourceFileContentKey:
+		content, err := r.findFileContent(ctx, namespace, configDir.Spec.FileContentSelector, source.FileContentKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find file content key %s: %w", source.FileContentKey, err)
+		}
+		return content, nil
+	}
+	return nil, fmt.Errorf("unknown source type %s", SourceType(source))
+}
+
+func (r *Resolver) findFileContent(ctx context.Context, namespace string, selector *metav1.LabelSelector, key string) ([]byte, error) {
+	if selector == nil {
+		return nil, errors.New("spec.fileContentSelector is not set")
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec.fileContentSelector: %w", err)
+	}
+
+	// Search in ConfigMaps
+	cmList := &corev1.ConfigMapList{}
+	if err := r.Client.List(ctx, cmList, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		return nil, err
+	}
+	for _, cm := range cmList.Items {
+		if val, ok := cm.Data[key]; ok {
+			return []byte(val), nil
+		}
+		if val, ok := cm.BinaryData[key]; ok {
+			return val, nil
+		}
+	}
+
+	// Search in ConfigFiles
+	cfList := &configdirv1alpha1.ConfigFileList{}
+	if err := r.Client.List(ctx, cfList, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		return nil, err
+	}
+	parts := map[string]configdirv1alpha1.FileContent{}
+	continuations := map[string]bool{}
+	for _, cf := range cfList.Items {
+		for _, file := range cf.Spec.Files {
+			if file.Path == key {
+				parts[cf.Name] = file
+				if file.Continued != "" {
+					continuations[file.Continued] = true
+				}
+			}
+		}
+	}
+	// The first part of a file split across ConfigFiles is the one no other
+	// part continues
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !continuations[name] {
+			return r.joinParts(ctx, namespace, name, parts[name], parts)
+		}
+	}
+
+	return nil, fmt.Errorf("key %s not found in any matching ConfigMap or ConfigFile", key)
+}
+
+// joinParts returns the content of a file starting with the part first of
+// the ConfigFile name, followed by the parts of the ConfigFiles it is
+// Continued in. Those not in parts, e.g. not matching the selector, are
+// fetched.
+func (r *Resolver) joinParts(ctx context.Context, namespace, name string, first configdirv1alpha1.FileContent, parts map[string]configdirv1alpha1.FileContent) ([]byte, error) {
+	var content []byte
+	seen := map[string]bool{}
+	part := first
+	for {
+		seen[name] = true
+		// content is base64 encoded
+		decoded, err := base64.StdEncoding.DecodeString(part.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content in ConfigFile %s: %w", name, err)
+		}
+		content = append(content, decoded...)
+		if part.Continued == "" {
+			return content, nil
+		}
+
+		next := part.Continued
+		if seen[next] {
+			return nil, fmt.Errorf("ConfigFile %s continues %s in a loop", name, next)
+		}
+		var ok bool
+		if part, ok = parts[next]; !ok {
+			cf := &configdirv1alpha1.ConfigFile{}
+			if err := r.Client.Get(ctx, types.NamespacedName{Name: next, Namespace: namespace}, cf); err != nil {
+				return nil, fmt.Errorf("unable to fetch ConfigFile %s continuing %s: %w", next, name, err)
+			}
+			for _, file := range cf.Spec.Files {
+				if file.Path == first.Path {
+					part, ok = file, true
+				}
+			}
+			if !ok {
+				return nil, fmt.Errorf("ConfigFile %s continuing %s has no file %s", next, name, first.Path)
+			}
+		}
+		name = next
+	}
+}
+
+func (r *Resolver) fetchURL(ctx context.Context, namespace string, urlSource *configdirv1alpha1.URLSource) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlSource.Location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if urlSource.SecretRef != nil && urlSource.WorkloadIdentity != "" {
+		return nil, errors.New("secretRef and workloadIdentity are exclusive")
+	}
+	if urlSource.WorkloadIdentity != "" {
+		header, err := r.workloadIdentityToken(ctx, urlSource.WorkloadIdentity, urlSource.Location)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", header)
+	}
+	if urlSource.SecretRef != nil {
+		secret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: urlSource.SecretRef.Name, Namespace: namespace}, secret); err != nil {
+			return nil, fmt.Errorf("unable to fetch secret for URL auth: %w", err)
+		}
+		token, ok := secret.Data[urlSource.SecretRef.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in secret %s", urlSource.SecretRef.Key, urlSource.SecretRef.Name)
+		}
+		req.Header.Set("Authorization", string(token))
+	}
+
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if urlSource.SHA256 != "" {
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != urlSource.SHA256 {
+			return nil, fmt.Errorf("sha256 mismatch for %s", urlSource.Location)
+		}
+	}
+
+	return content, nil
+}
+
+// variables returns the variables of the templates of configDir: those of its
+// VariablesFrom, its Variables, the built-in ones and the Variables of r, each
+// overriding the previous ones.
+func (r *Resolver) variables(ctx context.Context, configDir *configdirv1alpha1.ConfigDir) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, from := range configDir.Spec.VariablesFrom {
+		switch {
+		case from.ConfigMapRef != nil:
+			cm := &corev1.ConfigMap{}
+			if err := r.Client.Get(ctx, types.NamespacedName{Name: from.ConfigMapRef.Name, Namespace: configDir.Namespace}, cm); err != nil {
+				return nil, fmt.Errorf("unable to fetch variables from ConfigMap %s: %w", from.ConfigMapRef.Name, err)
+			}
+			maps.Copy(vars, cm.Data)
+		case from.SecretRef != nil:
+			secret := &corev1.Secret{}
+			if err := r.Client.Get(ctx, types.NamespacedName{Name: from.SecretRef.Name, Namespace: configDir.Namespace}, secret); err != nil {
+				return nil, fmt.Errorf("unable to fetch variables from Secret %s: %w", from.SecretRef.Name, err)
+			}
+			for key, val := range secret.Data {
+				vars[key] = string(val)
+			}
+		}
+	}
+	maps.Copy(vars, configDir.Spec.Variables)
+	vars[VariableNamespace] = configDir.Namespace
+	vars[VariableName] = configDir.Name
+	maps.Copy(vars, r.Variables)
+	return vars, nil
+}
+
+// render executes the template content of the file path with vars.
+func (r *Resolver) render(path string, content []byte, vars map[string]string) ([]byte, error) {
+	missingKey := "missingkey=error"
+	if r.IgnoreMissingVariables {
+		missingKey = "missingkey=zero"
+	}
+	tmpl, err := template.New(path).Option(missingKey).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("unable to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *Resolver) httpClient() *http.Client {
+	if r.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return r.HTTPClient
+}
+
+// ContentHash returns the aggregate checksum of the resolved files: the
+// sha256 of their paths, checksums and modes, sorted by path. It changes
+// whenever a file is added, removed, renamed or changed.
+func ContentHash(files []File) string {
+	sorted := make([]File, 0, len(files))
+	for _, f := range files {
+		if f.Err == nil {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "%s\x00%s", f.Path, f.SHA256)
+		// The hash of ConfigDirs without modes doesn't change
+		if f.Mode != 0 && f.Mode != DefaultMode {
+			fmt.Fprintf(h, "\x00%o", f.Mode)
+		}
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+)
+
+func TestResolve(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("from url"))
+	}))
+	defer srv.Close()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = configdirv1alpha1.AddToScheme(s)
+	project := map[string]string{"project": "agent"}
+	c := clientfake.NewClientBuilder().WithScheme(s).WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "prompts", Namespace: "default"},
+			Data:       map[string]string{"review.txt": "from configmap"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: "default"},
+			Data:       map[string][]byte{"header": []byte("Bearer token"), "key": []byte("from secret")},
+		},
+		&configdirv1alpha1.ConfigFile{
+			ObjectMeta: metav1.ObjectMeta{Name: "assets", Namespace: "default", Labels: project},
+			Spec: configdirv1alpha1.ConfigFileSpec{Files: []configdirv1alpha1.FileContent{
+				{Path: "logo.png", Content: base64.StdEncoding.EncodeToString([]byte("from configfile"))},
+			}},
+		},
+	).Build()
+
+	ref := func(name, key string) corev1.SecretKeySelector {
+		return corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key}
+	}
+	authRef := ref("auth", "header")
+	secretRef := ref("auth", "key")
+	configDir := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			FileContentSelector: &metav1.LabelSelector{MatchLabels: project},
+			Files: []configdirv1alpha1.FileItem{
+				{Path: "inline.txt", Source: configdirv1alpha1.FileSource{Inline: "inline"}},
+				{Path: "empty.txt"},
+				{Path: "review.txt", Source: configdirv1alpha1.FileSource{ConfigMapRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "prompts"}, Key: "review.txt",
+				}}},
+				{Path: "key", Source: configdirv1alpha1.FileSource{SecretRef: &secretRef}},
+				{Path: "url.md", Source: configdirv1alpha1.FileSource{URL: &configdirv1alpha1.URLSource{Location: srv.URL, SecretRef: &authRef}}},
+				{Path: "logo.png", Source: configdirv1alpha1.FileSource{FileContentKey: "logo.png"}},
+				{Path: "missing.txt", Source: configdirv1alpha1.FileSource{ConfigMapRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "prompts"}, Key: "missing.txt",
+				}}},
+				{Path: "bad-sum.md", Source: configdirv1alpha1.FileSource{URL: &configdirv1alpha1.URLSource{Location: srv.URL, SecretRef: &authRef, SHA256: "00"}}},
+			},
+		},
+	}
+
+	r := &Resolver{Client: c}
+	files, err := r.Resolve(context.Background(), configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		source, content, err string
+	}{
+		{SourceInline, "inline", ""},
+		{SourceInline, "", ""},
+		{SourceConfigMap, "from configmap", ""},
+		{SourceSecret, "from secret", ""},
+		{SourceURL, "from url", ""},
+		{SourceFileContentKey, "from configfile", ""},
+		{SourceConfigMap, "", "key missing.txt not found"},
+		{SourceURL, "", "sha256 mismatch"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("Resolve() returned %d files, want %d", len(files), len(want))
+	}
+	for i, f := range files {
+		w := want[i]
+		if f.Source != w.source || string(f.Content) != w.content {
+			t.Errorf("%s = %s %q, want %s %q", f.Path, f.Source, f.Content, w.source, w.content)
+		}
+		if (f.Err == nil) != (w.err == "") || (f.Err != nil && !strings.Contains(f.Err.Error(), w.err)) {
+			t.Errorf("%s error = %v, want %q", f.Path, f.Err, w.err)
+		}
+		if f.Err == nil && len(f.SHA256) != 64 {
+			t.Errorf("%s sha256 = %q, want a hex checksum", f.Path, f.SHA256)
+		}
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	a := File{Path: "a", SHA256: "1"}
+	b := File{Path: "b", SHA256: "2"}
+	if ContentHash([]File{a, b}) != ContentHash([]File{b, a}) {
+		t.Error("ContentHash() depends on the order of the files")
+	}
+	renamed := File{Path: "c", SHA256: "2"}
+	if ContentHash([]File{a, b}) == ContentHash([]File{a, renamed}) {
+		t.Error("ContentHash() doesn't change when a file is renamed")
+	}
+	failed := File{Path: "d", Err: context.Canceled}
+	if ContentHash([]File{a, b}) != ContentHash([]File{a, b, failed}) {
+		t.Error("ContentHash() covers the files that failed to resolve")
+	}
+}
+
+func TestResolveChunks(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = configdirv1alpha1.AddToScheme(s)
+	chunks := map[string]string{"chunk-0": "hello ", "chunk-1": "large ", "chunk-2": "world"}
+	var objs []client.Object
+	for i, name := range []string{"chunk-0", "chunk-1", "chunk-2"} {
+		file := configdirv1alpha1.FileContent{Path: "big.bin", Content: base64.StdEncoding.EncodeToString([]byte(chunks[name]))}
+		if i < 2 {
+			file.Continued = fmt.Sprintf("chunk-%d", i+1)
+		}
+		cf := &configdirv1alpha1.ConfigFile{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"project": "agent"}},
+			Spec:       configdirv1alpha1.ConfigFileSpec{Files: []configdirv1alpha1.FileContent{file}},
+		}
+		if i == 2 {
+			// Continuations are followed even if they don't match the selector
+			cf.Labels = nil
+		}
+		objs = append(objs, cf)
+	}
+	r := &Resolver{Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()}
+	configDir := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			FileContentSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"project": "agent"}},
+			Files:               []configdirv1alpha1.FileItem{{Path: "big.bin", Source: configdirv1alpha1.FileSource{FileContentKey: "big.bin"}}},
+		},
+	}
+	files, err := r.Resolve(context.Background(), configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Err != nil || string(files[0].Content) != "hello large world" {
+		t.Errorf("Resolve() = %q, %v, want the chunks joined", files[0].Content, files[0].Err)
+	}
+}
+
+func TestResolveTemplates(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = configdirv1alpha1.AddToScheme(s)
+	c := clientfake.NewClientBuilder().WithScheme(s).WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "repo-vars", Namespace: "default"},
+			Data:       map[string]string{"repo": "from configmap", "branch": "main"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "repo-secrets", Namespace: "default"},
+			Data:       map[string][]byte{"token": []byte("secret")},
+		},
+	).Build()
+
+	configDir := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: configdirv1alpha1.ConfigDirSpec{
+			Variables: map[string]string{"repo": "from spec"},
+			VariablesFrom: []configdirv1alpha1.VariablesSource{
+				{ConfigMapRef: &corev1.LocalObjectReference{Name: "repo-vars"}},
+				{SecretRef: &corev1.LocalObjectReference{Name: "repo-secrets"}},
+			},
+			Files: []configdirv1alpha1.FileItem{
+				{Path: "vars.txt", Template: true, Source: configdirv1alpha1.FileSource{
+					Inline: "{{ .repo }} {{ .branch }} {{ .token }} {{ .namespace }}/{{ .name }} {{ .sandbox }}",
+				}},
+				{Path: "plain.txt", Source: configdirv1alpha1.FileSource{Inline: "{{ .repo }}"}},
+				{Path: "missing.txt", Template: true, Source: configdirv1alpha1.FileSource{Inline: "{{ .missing }}"}},
+				{Path: "invalid.txt", Template: true, Source: configdirv1alpha1.FileSource{Inline: "{{ .repo "}},
+			},
+		},
+	}
+
+	resolver := &Resolver{Client: c, Variables: map[string]string{"sandbox": "devc-1"}}
+	files, err := resolver.Resolve(context.Background(), configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(files[0].Content), "from spec main secret default/agent devc-1"; got != want {
+		t.Errorf("vars.txt = %q, want %q", got, want)
+	}
+	if got, want := string(files[1].Content), "{{ .repo }}"; got != want {
+		t.Errorf("plain.txt = %q, want %q", got, want)
+	}
+	if !files[0].Template || files[1].Template {
+		t.Errorf("Template = %v, %v, want only vars.txt marked", files[0].Template, files[1].Template)
+	}
+	if files[2].Err == nil || !strings.Contains(files[2].Err.Error(), "missing") {
+		t.Errorf("missing.txt error = %v, want the missing variable", files[2].Err)
+	}
+	if files[3].Err == nil || !strings.Contains(files[3].Err.Error(), "invalid template") {
+		t.Errorf("invalid.txt error = %v, want an invalid template", files[3].Err)
+	}
+
+	// The controller doesn't know the variables of the CLI
+	resolver = &Resolver{Client: c, IgnoreMissingVariables: true}
+	files, err = resolver.Resolve(context.Background(), configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(files[0].Content), "from spec main secret default/agent "; got != want || files[0].Err != nil {
+		t.Errorf("vars.txt = %q, %v, want %q", got, files[0].Err, want)
+	}
+	if files[2].Err != nil {
+		t.Errorf("missing.txt error = %v, want it rendered empty", files[2].Err)
+	}
+
+	configDir.Spec.VariablesFrom = append(configDir.Spec.VariablesFrom, configdirv1alpha1.VariablesSource{ConfigMapRef: &corev1.LocalObjectReference{Name: "absent"}})
+	files, err = resolver.Resolve(context.Background(), configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Err == nil || files[1].Err != nil {
+		t.Errorf("errors = %v, %v, want only the templates failing without their variables", files[0].Err, files[1].Err)
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = configdirv1alpha1.AddToScheme(s)
+	inline := func(path, content string) configdirv1alpha1.FileItem {
+		return configdirv1alpha1.FileItem{Path: path, Source: configdirv1alpha1.FileSource{Inline: content}}
+	}
+	configDir := func(name string, includes []string, files ...configdirv1alpha1.FileItem) *configdirv1alpha1.ConfigDir {
+		cd := &configdirv1alpha1.ConfigDir{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       configdirv1alpha1.ConfigDirSpec{Files: files},
+		}
+		for _, include := range includes {
+			cd.Spec.Includes = append(cd.Spec.Includes, corev1.LocalObjectReference{Name: include})
+		}
+		return cd
+	}
+	c := clientfake.NewClientBuilder().WithScheme(s).WithObjects(
+		configDir("org", nil, inline("GEMINI.md", "org"), inline("styleguide.md", "org"), inline("settings.json", "org")),
+		configDir("go", []string{"org"}, inline("styleguide.md", "go")),
+		configDir("security", []string{"org"}, inline("settings.json", "security")),
+		configDir("loop-a", []string{"loop-b"}),
+		configDir("loop-b", []string{"loop-a"}),
+	).Build()
+	r := &Resolver{Client: c}
+
+	repo := configDir("repo", []string{"go", "security"}, inline("GEMINI.md", "repo"), inline("repo.md", "repo"))
+	files, err := r.Resolve(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Path+"="+string(f.Content))
+	}
+	want := []string{"GEMINI.md=repo", "styleguide.md=go", "settings.json=security", "repo.md=repo"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Resolve() = %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		includes []string
+		wantErr  string
+	}{
+		{[]string{"missing"}, "unable to fetch ConfigDir missing included by repo"},
+		{[]string{"loop-a"}, "in a loop"},
+		{[]string{"repo"}, "ConfigDir repo includes repo in a loop"},
+	} {
+		_, err := r.Resolve(context.Background(), configDir("repo", tt.includes))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Resolve() with includes %v = %v, want %q", tt.includes, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/gcp"
+)
+
+// accessTokenHosts are the suffixes of the hosts access tokens are sent to.
+// Access tokens aren't bound to an audience, so they are only sent to Google
+// APIs, Cloud Storage and Artifact Registry.
+var accessTokenHosts = []string{".googleapis.com", ".pkg.dev"}
+
+// workloadIdentityToken returns the Authorization header of location with a
+// token of the type kind, minted by the metadata server.
+func (r *Resolver) workloadIdentityToken(ctx context.Context, kind configdirv1alpha1.WorkloadIdentityToken, location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("workload identity tokens are only sent over https")
+	}
+
+	r.tokensOnce.Do(func() {
+		r.tokens = &gcp.TokenSource{URL: r.MetadataURL, Client: r.httpClient()}
+	})
+	switch kind {
+	case configdirv1alpha1.WorkloadIdentityAccessToken:
+		if !hasSuffix(u.Hostname(), accessTokenHosts) {
+			return "", fmt.Errorf("access tokens are only sent to hosts ending in %s", strings.Join(accessTokenHosts, ", "))
+		}
+		token, err := r.tokens.AccessToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	case configdirv1alpha1.WorkloadIdentityIDToken:
+		token, err := r.tokens.IDToken(ctx, u.Scheme+"://"+u.Host)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "", fmt.Errorf("unknown workload identity token %s", kind)
+}
+
+func hasSuffix(host string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	configdirv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/configdir/api/v1alpha1"
+)
+
+type roundTripFunc func(*http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+func TestResolveWorkloadIdentity(t *testing.T) {
+	var tokens []string
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		rec := httptest.NewRecorder()
+		switch {
+		case req.URL.Host == "metadata.google.internal":
+			if req.Header.Get("Metadata-Flavor") != "Google" {
+				rec.WriteHeader(http.StatusForbidden)
+			} else if strings.HasSuffix(req.URL.Path, "/token") {
+				rec.WriteString(`{"access_token": "access", "expires_in": 3600}`)
+			} else if req.URL.Query().Get("audience") == "https://agent-config.a.run.app" {
+				rec.WriteString("id\n")
+			} else {
+				rec.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			tokens = append(tokens, req.Header.Get("Authorization"))
+			rec.WriteString("from " + req.URL.Host)
+		}
+		return rec.Result()
+	})}
+
+	source := func(location string, token configdirv1alpha1.WorkloadIdentityToken) configdirv1alpha1.FileItem {
+		return configdirv1alpha1.FileItem{Path: location, Source: configdirv1alpha1.FileSource{
+			URL: &configdirv1alpha1.URLSource{Location: location, WorkloadIdentity: token},
+		}}
+	}
+	configDir := &configdirv1alpha1.ConfigDir{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: configdirv1alpha1.ConfigDirSpec{Files: []configdirv1alpha1.FileItem{
+			source("https://storage.googleapis.com/configs/GEMINI.md", configdirv1alpha1.WorkloadIdentityAccessToken),
+			source("https://agent-config.a.run.app/GEMINI.md", configdirv1alpha1.WorkloadIdentityIDToken),
+			// Access tokens aren't sent to other hosts, nor over http
+			source("https://example.com/GEMINI.md", configdirv1alpha1.WorkloadIdentityAccessToken),
+			source("http://storage.googleapis.com/configs/GEMINI.md", configdirv1alpha1.WorkloadIdentityAccessToken),
+		}},
+	}
+	both := source("https://storage.googleapis.com/configs/both.md", configdirv1alpha1.WorkloadIdentityAccessToken)
+	both.Source.URL.SecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "auth"}, Key: "header"}
+	configDir.Spec.Files = append(configDir.Spec.Files, both)
+
+	r := &Resolver{HTTPClient: httpClient}
+	files, err := r.Resolve(context.Background(), configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"from storage.googleapis.com", "from agent-config.a.run.app"} {
+		if files[i].Err != nil || string(files[i].Content) != want {
+			t.Errorf("%s = %q, %v, want %q", files[i].Path, files[i].Content, files[i].Err, want)
+		}
+	}
+	if want := []string{"Bearer access", "Bearer id"}; strings.Join(tokens, ",") != strings.Join(want, ",") {
+		t.Errorf("Authorization headers = %v, want %v", tokens, want)
+	}
+	for i, wantErr := range map[int]string{2: "only sent to hosts ending in", 3: "only sent over https", 4: "exclusive"} {
+		if files[i].Err == nil || !strings.Contains(files[i].Err.Error(), wantErr) {
+			t.Errorf("%s error = %v, want %q", files[i].Path, files[i].Err, wantErr)
+		}
+	}
+}
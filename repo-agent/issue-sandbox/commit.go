@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+)
+
+const (
+	defaultCommitMessageTemplate = `{{.Type}}{{if .Scope}}({{.Scope}}){{end}}: {{.Subject}}
+
+{{.Body}}
+
+Fixes #{{.IssueNumber}}
+`
+	// maxCommitSubjectLength keeps the commit subject within the usual
+	// git line length conventions.
+	maxCommitSubjectLength = 72
+	// maxCommitBodyFiles bounds the number of files listed in the body.
+	maxCommitBodyFiles = 20
+)
+
+// commitMessageData is the data passed to the commit message template.
+type commitMessageData struct {
+	Type        string
+	Scope       string
+	Subject     string
+	Body        string
+	Files       []string
+	IssueNumber string
+	IssueTitle  string
+}
+
+// generateCommitMessage builds a conventional commit message for the staged
+// files. The type and scope are derived from the changed paths. tmpl
+// overrides the default template when set.
+func generateCommitMessage(tmpl, issueNumber, issueTitle string, files []string) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultCommitMessageTemplate
+	}
+
+	data := commitMessageData{
+		Type:        commitType(files),
+		Scope:       commitScope(files),
+		Subject:     commitSubject(issueNumber, issueTitle),
+		Body:        commitBody(files),
+		Files:       files,
+		IssueNumber: issueNumber,
+		IssueTitle:  issueTitle,
+	}
+
+	t, err := template.New("commit").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse commit message template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render commit message template: %w", err)
+	}
+	return strings.TrimSpace(buf.String()) + "\n", nil
+}
+
+// commitType returns the conventional commit type for the changed files.
+// Changes touching only docs, tests or CI config get the matching type,
+// anything else is a fix.
+func commitType(files []string) string {
+	if len(files) == 0 {
+		return "fix"
+	}
+	for _, kind := range []struct {
+		name  string
+		match func(string) bool
+	}{
+		{"docs", isDocFile},
+		{"test", isTestFile},
+		{"ci", isCIFile},
+	} {
+		all := true
+		for _, f := range files {
+			if !kind.match(f) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return kind.name
+		}
+	}
+	return "fix"
+}
+
+func isDocFile(f string) bool {
+	ext := strings.ToLower(path.Ext(f))
+	return ext == ".md" || ext == ".rst" || ext == ".txt" || strings.HasPrefix(f, "docs/")
+}
+
+func isTestFile(f string) bool {
+	base := path.Base(f)
+	return strings.HasSuffix(base, "_test.go") ||
+		strings.Contains(base, ".test.") ||
+		strings.Contains(base, ".spec.") ||
+		strings.HasPrefix(f, "test/") ||
+		strings.HasPrefix(f, "tests/") ||
+		strings.Contains(f, "/test/") ||
+		strings.Contains(f, "/testdata/")
+}
+
+func isCIFile(f string) bool {
+	return strings.HasPrefix(f, ".github/") || strings.HasPrefix(f, ".gitlab-ci") || strings.HasPrefix(f, ".circleci/")
+}
+
+// commitScope returns the top level directory shared by all changed files,
+// or an empty string if there is none.
+func commitScope(files []string) string {
+	scope := ""
+	for _, f := range files {
+		dir, _, found := strings.Cut(f, "/")
+		if !found {
+			return ""
+		}
+		if scope == "" {
+			scope = dir
+		} else if scope != dir {
+			return ""
+		}
+	}
+	return scope
+}
+
+func commitSubject(issueNumber, issueTitle string) string {
+	subject := strings.TrimSpace(issueTitle)
+	if subject == "" {
+		subject = fmt.Sprintf("address issue #%s", issueNumber)
+	}
+	// Truncate by runes so that multibyte titles aren't cut mid-character
+	if runes := []rune(subject); len(runes) > maxCommitSubjectLength {
+		subject = strings.TrimSpace(string(runes[:maxCommitSubjectLength-3])) + "..."
+	}
+	return subject
+}
+
+func commitBody(files []string) string {
+	var b strings.Builder
+	b.WriteString("Changed files:\n")
+	for i, f := range files {
+		if i == maxCommitBodyFiles {
+			fmt.Fprintf(&b, "- ... and %d more\n", len(files)-maxCommitBodyFiles)
+			break
+		}
+		fmt.Fprintf(&b, "- %s\n", f)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// configureCommitSigning sets up git to sign commits with the key mounted at
+// signingKeyFile. format is either gpg or ssh, an empty format disables
+// signing.
+func configureCommitSigning(format string) error {
+	if format == "" {
+		return nil
+	}
+	if _, err := os.Stat(signingKeyFile); err != nil {
+		return fmt.Errorf("signing key not found: %w", err)
+	}
+
+	var signingKey string
+	switch format {
+	case "ssh":
+		// ssh-keygen refuses keys readable by others, so copy the key out
+		// of the secret volume with tighter permissions.
+		key, err := os.ReadFile(signingKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read signing key: %w", err)
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		signingKey = home + "/.ssh/commit-signing-key"
+		if err := os.MkdirAll(home+"/.ssh", 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(signingKey, key, 0600); err != nil {
+			return fmt.Errorf("failed to write signing key: %w", err)
+		}
+	case "gpg":
+		if _, err := sandbox.RunCommand("gpg", "--batch", "--import", signingKeyFile); err != nil {
+			return fmt.Errorf("failed to import gpg key: %w", err)
+		}
+		output, err := sandbox.RunCommand("gpg", "--batch", "--list-secret-keys", "--with-colons")
+		if err != nil {
+			return fmt.Errorf("failed to list gpg keys: %w", err)
+		}
+		signingKey = gpgFingerprint(string(output))
+		if signingKey == "" {
+			return fmt.Errorf("no secret key found in %s", signingKeyFile)
+		}
+	default:
+		return fmt.Errorf("unsupported signing format %q", format)
+	}
+
+	for _, kv := range [][]string{
+		{"gpg.format", format},
+		{"user.signingkey", signingKey},
+		{"commit.gpgsign", "true"},
+	} {
+		if _, err := sandbox.RunCommand("git", "config", "--global", kv[0], kv[1]); err != nil {
+			return fmt.Errorf("failed to set git %s: %w", kv[0], err)
+		}
+	}
+	log.Printf("Commits will be signed with the %s key", format)
+	return nil
+}
+
+// gpgFingerprint returns the fingerprint of the first secret key in the
+// output of `gpg --list-secret-keys --with-colons`.
+func gpgFingerprint(output string) string {
+	inSecretKey := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "sec":
+			inSecretKey = true
+		case "fpr":
+			if inSecretKey && len(fields) > 9 {
+				return fields[9]
+			}
+		}
+	}
+	return ""
+}
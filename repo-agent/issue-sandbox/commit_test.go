@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGenerateCommitMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		tmpl       string
+		issueTitle string
+		files      []string
+		want       string
+	}{
+		{
+			name:       "fix with scope",
+			issueTitle: "Crash when config is empty",
+			files:      []string{"pkg/config.go", "pkg/config_test.go"},
+			want:       "fix(pkg): Crash when config is empty\n\nChanged files:\n- pkg/config.go\n- pkg/config_test.go\n\nFixes #42\n",
+		},
+		{
+			name:       "docs without scope",
+			issueTitle: "Typo in README",
+			files:      []string{"README.md", "docs/install.md"},
+			want:       "docs: Typo in README\n\nChanged files:\n- README.md\n- docs/install.md\n\nFixes #42\n",
+		},
+		{
+			name:       "tests only",
+			issueTitle: "Flaky test",
+			files:      []string{"pkg/a_test.go"},
+			want:       "test(pkg): Flaky test\n\nChanged files:\n- pkg/a_test.go\n\nFixes #42\n",
+		},
+		{
+			name:       "template override",
+			tmpl:       "{{.Type}}: fix issue {{.IssueNumber}} ({{len .Files}} files)",
+			issueTitle: "Something",
+			files:      []string{"main.go"},
+			want:       "fix: fix issue 42 (1 files)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateCommitMessage(tt.tmpl, "42", tt.issueTitle, tt.files)
+			if err != nil {
+				t.Fatalf("generateCommitMessage() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("generateCommitMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommitSubject(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "short", title: "Flaky test", want: "Flaky test"},
+		{name: "empty", title: " ", want: "address issue #42"},
+		{name: "ascii", title: strings.Repeat("a", 80), want: strings.Repeat("a", 69) + "..."},
+		{name: "multibyte at limit", title: strings.Repeat("é", 72), want: strings.Repeat("é", 72)},
+		{name: "multibyte", title: strings.Repeat("a", 68) + "日本語です", want: strings.Repeat("a", 68) + "日..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := commitSubject("42", tt.title)
+			if got != tt.want {
+				t.Errorf("commitSubject() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("commitSubject() = %q is not valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestGPGFingerprint(t *testing.T) {
+	output := `sec:u:255:22:ABCDEF0123456789:1700000000:::u:::scESC:::+:::ed25519:::0:
+fpr:::::::::0123456789ABCDEF0123456789ABCDEF01234567:
+grp:::::::::1111111111111111111111111111111111111111:
+uid:u::::1700000000::HASH::gemini <gemini@gemini.ai>::::::::::0:
+ssb:u:255:18:1234567890ABCDEF:1700000000::::::e:::+:::cv25519::
+fpr:::::::::FEDCBA9876543210FEDCBA9876543210FEDCBA98:
+`
+	if got, want := gpgFingerprint(output), "0123456789ABCDEF0123456789ABCDEF01234567"; got != want {
+		t.Errorf("gpgFingerprint() = %q, want %q", got, want)
+	}
+	if got := gpgFingerprint(""); got != "" {
+		t.Errorf("gpgFingerprint(\"\") = %q, want empty", got)
+	}
+}
@@ -1,50 +1,163 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/llm"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/secretmanager"
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	devcontainerFile = "/devcontainer.json"
+	signingKeyFile   = "/signing-key/key"
+
+	defaultValidationMaxAttempts = 3
+	// maxValidationOutput bounds how much of the validation output is fed
+	// back to the agent.
+	maxValidationOutput = 8 * 1024
+	// maxReviewDiff bounds how much of the diff is fed back to the agent
+	// during self-review.
+	maxReviewDiff = 32 * 1024
+	// maxResultSummary bounds the agent output kept as the result summary.
+	maxResultSummary = 4 * 1024
+
+	forkReadyTimeout = 5 * time.Minute
+	forkPollInterval = 5 * time.Second
+)
+
+// errValidationFailed is returned when the agent's changes do not pass the
+// validation command within the allowed attempts.
+var errValidationFailed = errors.New("validation failed")
+
+// transcript records the gemini runs if the sandbox enables it, see
+// llm.TranscriptEnv.
+var transcript = llm.Workspace{}.Transcript()
+
+var (
+	devcontainerLineComments   = regexp.MustCompile(`(?m)^\s*//.*$`)
+	devcontainerTrailingCommas = regexp.MustCompile(`,(\s*[}\]])`)
 )
 
 func main() {
-	cmdCodeSrv, err := startCodeServer()
+	// Also write the log to the workspace so that the sidecar can publish it
+	if f, err := os.OpenFile(sandbox.RunLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		logging.Setup("issue-sandbox", os.Stderr)
+		log.Printf("failed to open %s: %v", sandbox.RunLogFile, err)
+	} else {
+		defer f.Close()
+		logging.Setup("issue-sandbox", io.MultiWriter(os.Stderr, f))
+	}
+
+	codeServerConfig, err := sandbox.CodeServerConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid code-server config: %v", err)
+	}
+	codeServer, err := sandbox.StartCodeServer(codeServerConfig)
 	if err != nil {
 		log.Fatalf("failed to start code-server: %v", err)
 	}
-	defer func() {
-		if cmdCodeSrv.Process != nil {
-			_ = cmdCodeSrv.Process.Kill()
-		}
-	}()
+	defer codeServer.Stop()
+
+	if err := loadGithubToken(context.Background(), secretmanager.New()); err != nil {
+		fatalf("failed to load the GitHub token: %v", err)
+	}
 
 	// Prepare git branch
 	oldCommitID, err := prepareGitBranch()
 	if err != nil {
-		log.Fatalf("failed to prepare git branch: %v", err)
+		fatalf("failed to prepare git branch: %v", err)
 	}
 
-	if _, err := os.Stat("../agent-prompt.txt"); os.IsNotExist(err) {
+	// Run the agent on first start, and again when the prompt changed because
+	// follow-up comments were appended to it.
+	if savedPrompt, err := os.ReadFile(sandbox.PromptFile); os.IsNotExist(err) || string(savedPrompt) != os.Getenv("AGENT_PROMPT") {
+		if err := os.Remove(sandbox.ErrorFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove %s: %v", sandbox.ErrorFile, err)
+		}
 		// Try solving the issue
-		if err := runIssueSolver(); err != nil {
-			log.Fatalf("failed solving issue: %v", err)
+		err := runIssueSolver()
+		testStatus := sandbox.TestStatusPassed
+		switch {
+		case errors.Is(err, errValidationFailed):
+			// Leave the changes uncommitted in the workspace for inspection
+			log.Printf("not committing changes: %v", err)
+			testStatus = sandbox.TestStatusFailed
+		case err != nil:
+			fatalf("failed solving issue: %v", err)
+		default:
+			// Push the changes
+			if err := processGitChanges(oldCommitID); err != nil {
+				fatalf("failed to process git changes: %v", err)
+			}
+			if getValidationCommand() == "" {
+				testStatus = sandbox.TestStatusSkipped
+			}
 		}
-
-		// Push the changes
-		if err := processGitChanges(oldCommitID); err != nil {
-			log.Fatalf("failed to process git changes: %v", err)
+		if err := writeResult(oldCommitID, testStatus); err != nil {
+			log.Printf("failed to write result manifest: %v", err)
+		}
+		if testStatus == sandbox.TestStatusFailed {
+			setPhase(sandbox.PhaseFailed)
+		} else {
+			setPhase(sandbox.PhaseCompleted)
 		}
 	} else {
-		log.Println("agent-prompt.txt exists, skipping code generation")
+		log.Println("agent-prompt.txt is up to date, skipping code generation")
 	}
 
 	// Wait for code-server to exit
-	err = cmdCodeSrv.Wait()
+	codeServer.Wait()
+}
+
+// fatalf records the error in sandbox.ErrorFile for the sidecar to publish, then
+// logs it and exits. The error is redacted since it is published in the status
+// of the IssueSandbox, e.g. a failed push may print the origin URL.
+func fatalf(format string, v ...any) {
+	msg := logging.Redact(fmt.Sprintf(format, v...))
+	if err := os.WriteFile(sandbox.ErrorFile, []byte(msg), 0644); err != nil {
+		log.Printf("failed to write %s: %v", sandbox.ErrorFile, err)
+	}
+	setPhase(sandbox.PhaseFailed)
+	log.Fatal(msg)
+}
+
+// loadGithubToken sets GITHUB_TOKEN from the GITHUB_TOKEN_SECRET Secret
+// Manager secret when the RepoWatch keeps its PAT there rather than in a
+// Kubernetes secret.
+func loadGithubToken(ctx context.Context, secrets secretmanager.Accessor) error {
+	name := os.Getenv("GITHUB_TOKEN_SECRET")
+	if name == "" {
+		return nil
+	}
+	pat, err := secrets.Access(ctx, name)
 	if err != nil {
-		log.Printf("Code Server exited with error: %v", err)
-	} else {
-		log.Println("Code Server exited with no error")
+		return err
+	}
+	return os.Setenv("GITHUB_TOKEN", strings.TrimSpace(string(pat)))
+}
+
+// setPhase records the current phase for the sidecar to publish.
+func setPhase(phase string) {
+	log.Printf("Entering phase %s", phase)
+	if err := sandbox.WritePhase(sandbox.PhaseFile, phase); err != nil {
+		log.Print(err)
 	}
 }
 
@@ -58,7 +171,7 @@ func prepareGitBranch() (string, error) {
 	githubUserName := os.Getenv("GITHUB_USER_NAME")
 	issueBranch := os.Getenv("ISSUE_BRANCH")
 
-	cmdop, err := runCommand("git", "rev-parse", "HEAD")
+	cmdop, err := sandbox.RunCommand("git", "rev-parse", "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("failed to get old commit id: %w", err)
 	}
@@ -66,42 +179,55 @@ func prepareGitBranch() (string, error) {
 
 	// Typically origin would be the upstream repo and not the user's fork
 	// Removing origin to prevent accidental pushes to upstream
-	if _, err := runCommand("git", "remote", "remove", "origin"); err != nil {
+	if _, err := sandbox.RunCommand("git", "remote", "remove", "origin"); err != nil {
 		log.Printf("could not remove origin, probably because it does not exist: %v", err)
 	}
 
 	if gitPushEnabled && githubUserOrigin != "" {
+		if err := ensureFork(); err != nil {
+			return oldCommitID, fmt.Errorf("failed to ensure fork exists: %w", err)
+		}
 		originURL := fmt.Sprintf("https://%s:%s@%s", githubUserLogin, githubToken, githubUserOrigin)
-		if _, err := _runCommand("git", "remote", "add", "origin", originURL); err != nil {
+		if _, err := sandbox.RunCommandQuiet("git", "remote", "add", "origin", originURL); err != nil {
 			return oldCommitID, fmt.Errorf("failed to add origin: %w", err)
 		}
 	}
 
 	if githubUserEmail != "" {
-		if _, err := runCommand("git", "config", "--global", "user.email", githubUserEmail); err != nil {
+		if _, err := sandbox.RunCommand("git", "config", "--global", "user.email", githubUserEmail); err != nil {
 			return oldCommitID, fmt.Errorf("failed to set git user email: %w", err)
 		}
 	}
 
 	if githubUserName != "" {
-		if _, err := runCommand("git", "config", "--global", "user.name", githubUserName); err != nil {
+		if _, err := sandbox.RunCommand("git", "config", "--global", "user.name", githubUserName); err != nil {
 			return oldCommitID, fmt.Errorf("failed to set git user name: %w", err)
 		}
 	}
 
+	if err := configureCommitSigning(os.Getenv("GIT_SIGNING_FORMAT")); err != nil {
+		return oldCommitID, fmt.Errorf("failed to configure commit signing: %w", err)
+	}
+
 	// Check if the issue branch already exists
-	branchesOutput, err := runCommand("git", "branch", "--list", issueBranch)
+	branchesOutput, err := sandbox.RunCommand("git", "branch", "--list", issueBranch)
 	if err != nil {
 		return oldCommitID, fmt.Errorf("failed to list git branches: %w", err)
 	}
 	if strings.TrimSpace(string(branchesOutput)) != "" {
 		log.Printf("Issue branch %s already exists, checking it out", issueBranch)
-		if _, err := runCommand("git", "checkout", issueBranch); err != nil {
+		if _, err := sandbox.RunCommand("git", "checkout", issueBranch); err != nil {
 			return oldCommitID, fmt.Errorf("failed to checkout existing issue branch: %w", err)
 		}
+	} else if gitPushEnabled && githubUserOrigin != "" && remoteBranchExists(issueBranch) {
+		// The branch was pushed by an earlier run, continue from it
+		log.Printf("Issue branch %s exists in origin, checking it out", issueBranch)
+		if _, err := sandbox.RunCommand("git", "checkout", "-b", issueBranch, "FETCH_HEAD"); err != nil {
+			return oldCommitID, fmt.Errorf("failed to checkout issue branch from origin: %w", err)
+		}
 	} else {
 		log.Printf("Issue branch %s does not exist, creating it", issueBranch)
-		if _, err := runCommand("git", "checkout", "-b", issueBranch); err != nil {
+		if _, err := sandbox.RunCommand("git", "checkout", "-b", issueBranch); err != nil {
 			return oldCommitID, fmt.Errorf("failed to create issue branch: %w", err)
 		}
 	}
@@ -109,6 +235,59 @@ func prepareGitBranch() (string, error) {
 	return oldCommitID, nil
 }
 
+// writeResult writes the result manifest for the run that started at
+// oldCommitID.
+func writeResult(oldCommitID, testStatus string) error {
+	result := &sandbox.Result{
+		Branch:     os.Getenv("ISSUE_BRANCH"),
+		BaseCommit: oldCommitID,
+		TestStatus: testStatus,
+	}
+
+	commits, err := sandbox.RunCommand("git", "rev-list", "--reverse", oldCommitID+"..HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+	result.Commits = strings.Fields(string(commits))
+
+	// Compare against the working tree so that uncommitted changes are
+	// included when validation failed.
+	files, err := sandbox.RunCommand("git", "diff", "--name-only", oldCommitID)
+	if err != nil {
+		return fmt.Errorf("failed to list changed files: %w", err)
+	}
+	result.FilesChanged = strings.Fields(string(files))
+
+	if output, err := os.ReadFile(sandbox.OutputFile); err == nil {
+		if len(output) > maxResultSummary {
+			output = output[len(output)-maxResultSummary:]
+		}
+		result.Summary = strings.TrimSpace(string(output))
+	}
+	if prURL, err := os.ReadFile(sandbox.PullRequestURLFile); err == nil {
+		result.PullRequestURL = strings.TrimSpace(string(prURL))
+	}
+	if questions, err := os.ReadFile(sandbox.QuestionsFile); err == nil {
+		for _, q := range strings.Split(string(questions), "\n") {
+			if q = strings.TrimSpace(q); q != "" {
+				result.FollowUpQuestions = append(result.FollowUpQuestions, q)
+			}
+		}
+	}
+
+	return sandbox.WriteResult(sandbox.ResultFile, result)
+}
+
+// remoteBranchExists fetches branch from origin and reports whether it
+// exists there. The fetched commit is left in FETCH_HEAD.
+func remoteBranchExists(branch string) bool {
+	if _, err := sandbox.RunCommand("git", "fetch", "origin", branch); err != nil {
+		log.Printf("branch %s not found in origin: %v", branch, err)
+		return false
+	}
+	return true
+}
+
 func processGitChanges(oldCommitID string) error {
 	// Environment variables
 	gitPushEnabled := os.Getenv("GIT_PUSH_ENABLED") == "true"
@@ -116,26 +295,40 @@ func processGitChanges(oldCommitID string) error {
 	issueBranch := os.Getenv("ISSUE_BRANCH")
 	issueID := os.Getenv("ISSUEID")
 
+	setPhase(sandbox.PhasePushing)
+
 	// Commit and push
 	if githubUserEmail != "" {
 		// Check if there are any changes to commit
-		statusOutput, err := runCommand("git", "status", "--porcelain")
+		statusOutput, err := sandbox.RunCommand("git", "status", "--porcelain")
 		if err != nil {
 			return fmt.Errorf("failed to get git status: %w", err)
 		}
 		if strings.TrimSpace(string(statusOutput)) != "" {
 			log.Println("Changes detected, committing")
-			if _, err := runCommand("git", "add", "."); err != nil {
-				return fmt.Errorf("failed to git add: %v", err)
+			staged, err := stageChanges(getStagingConfig())
+			if err != nil {
+				return err
 			}
-			commitMsg := fmt.Sprintf("fix for issue # %s", issueID)
-			if _, err := runCommand("git", "commit", "-m", commitMsg); err != nil {
-				return fmt.Errorf("failed to git commit: %v", err)
+			if len(staged) == 0 {
+				log.Println("No changes left to commit after applying the staging config")
+			} else {
+				commitMsg, err := generateCommitMessage(os.Getenv("COMMIT_MESSAGE_TEMPLATE"), issueID, os.Getenv("ISSUE_TITLE"), staged)
+				if err != nil {
+					return err
+				}
+				commitArgs := []string{"commit", "-m", commitMsg}
+				if os.Getenv("GIT_SIGN_OFF") == "true" {
+					commitArgs = append(commitArgs, "--signoff")
+				}
+				if _, err := sandbox.RunCommand("git", commitArgs...); err != nil {
+					return fmt.Errorf("failed to git commit: %v", err)
+				}
 			}
 		}
 	}
 
-	newCommitID, err := runCommand("git", "rev-parse", "HEAD")
+	newCommitID, err := sandbox.RunCommand("git", "rev-parse", "HEAD")
 	if err != nil {
 		return fmt.Errorf("failed to get new commit id: %w", err)
 	}
@@ -143,7 +336,7 @@ func processGitChanges(oldCommitID string) error {
 	if strings.TrimSpace(string(newCommitID)) != oldCommitID {
 		log.Println("New changes being committed")
 		if gitPushEnabled {
-			if _, err := runCommand("git", "push", "--set-upstream", "origin", issueBranch, "--force"); err != nil {
+			if _, err := sandbox.RunCommand("git", "push", "--set-upstream", "origin", issueBranch, "--force"); err != nil {
 				return fmt.Errorf("failed to push changes: %w", err)
 			}
 			log.Println("New changes pushed")
@@ -151,36 +344,161 @@ func processGitChanges(oldCommitID string) error {
 			log.Println("New changes not pushed. Git push not enabled")
 		}
 	}
+
+	if gitPushEnabled && os.Getenv("GIT_CREATE_PR") == "true" {
+		if err := createPullRequest(); err != nil {
+			return fmt.Errorf("failed to create pull request: %w", err)
+		}
+	}
+	return nil
+}
+
+// createPullRequest opens a draft pull request from the pushed issue branch
+// against the default branch of the upstream repo. If a pull request for the
+// branch is already open it is reused. The pull request URL is written to
+// sandbox.PullRequestURLFile so that the sidecar can publish it.
+func createPullRequest() error {
+	githubToken := os.Getenv("GITHUB_TOKEN")
+	githubUserLogin := os.Getenv("GITHUB_USER_LOGIN")
+	issueBranch := os.Getenv("ISSUE_BRANCH")
+	upstreamURL := os.Getenv("ENVBUILDER_GIT_URL")
+
+	owner, repo, err := parseGitHubURL(upstreamURL)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client := newGitHubClient(ctx, githubToken)
+
+	upstream, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get upstream repo %s/%s: %w", owner, repo, err)
+	}
+
+	head := fmt.Sprintf("%s:%s", githubUserLogin, issueBranch)
+	existing, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{State: "open", Head: head})
+	if err != nil {
+		return fmt.Errorf("failed to list pull requests for %s: %w", head, err)
+	}
+
+	var prURL string
+	if len(existing) > 0 {
+		prURL = existing[0].GetHTMLURL()
+		log.Printf("Pull request already exists for %s: %s", head, prURL)
+	} else {
+		pr, _, err := client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+			Title:               github.String(os.Getenv("PR_TITLE")),
+			Body:                github.String(os.Getenv("PR_BODY")),
+			Head:                github.String(head),
+			Base:                github.String(upstream.GetDefaultBranch()),
+			Draft:               github.Bool(true),
+			MaintainerCanModify: github.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create pull request for %s: %w", head, err)
+		}
+		prURL = pr.GetHTMLURL()
+		log.Printf("Created pull request %s", prURL)
+	}
+
+	if err := os.WriteFile(sandbox.PullRequestURLFile, []byte(prURL), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", sandbox.PullRequestURLFile, err)
+	}
 	return nil
 }
 
+// ensureFork makes sure the robot account has a fork of the upstream repo,
+// creating it and waiting for it to become available if it is missing.
+func ensureFork() error {
+	githubToken := os.Getenv("GITHUB_TOKEN")
+	githubUserLogin := os.Getenv("GITHUB_USER_LOGIN")
+	upstreamURL := os.Getenv("ENVBUILDER_GIT_URL")
+
+	owner, repo, err := parseGitHubURL(upstreamURL)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(owner, githubUserLogin) {
+		// Pushing directly to a repo owned by the robot, no fork needed.
+		return nil
+	}
+
+	ctx := context.Background()
+	client := newGitHubClient(ctx, githubToken)
+
+	fork, resp, err := client.Repositories.Get(ctx, githubUserLogin, repo)
+	if err == nil {
+		if !fork.GetFork() {
+			log.Printf("%s/%s exists but is not a fork of %s/%s", githubUserLogin, repo, owner, repo)
+		}
+		return nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to get fork %s/%s: %w", githubUserLogin, repo, err)
+	}
+
+	log.Printf("Fork %s/%s not found, creating it from %s/%s", githubUserLogin, repo, owner, repo)
+	if _, _, err := client.Repositories.CreateFork(ctx, owner, repo, &github.RepositoryCreateForkOptions{}); err != nil {
+		// GitHub creates forks asynchronously and answers with 202 Accepted.
+		var accepted *github.AcceptedError
+		if !errors.As(err, &accepted) {
+			return fmt.Errorf("failed to create fork of %s/%s: %w", owner, repo, err)
+		}
+	}
+
+	deadline := time.Now().Add(forkReadyTimeout)
+	for time.Now().Before(deadline) {
+		// The repo becomes visible before its git data is copied over, so
+		// wait until it has branches before pushing to it.
+		branches, _, err := client.Repositories.ListBranches(ctx, githubUserLogin, repo, &github.BranchListOptions{ListOptions: github.ListOptions{PerPage: 1}})
+		if err == nil && len(branches) > 0 {
+			log.Printf("Fork %s/%s is ready", githubUserLogin, repo)
+			return nil
+		}
+		log.Printf("Waiting for fork %s/%s to be ready", githubUserLogin, repo)
+		time.Sleep(forkPollInterval)
+	}
+	return fmt.Errorf("timed out waiting for fork %s/%s to be ready", githubUserLogin, repo)
+}
+
+func newGitHubClient(ctx context.Context, token string) *github.Client {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	return github.NewClient(oauth2.NewClient(ctx, ts))
+}
+
+// parseGitHubURL returns the owner and repo from a github clone URL such as
+// https://github.com/owner/repo.git
+func parseGitHubURL(cloneURL string) (string, string, error) {
+	u, err := url.Parse(cloneURL)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid github url: %s", cloneURL)
+	}
+	return parts[0], parts[1], nil
+}
+
 func runIssueSolver() error {
 	log.Println("Starting issue solver")
 
 	// Environment variables
 	agentPrompt := os.Getenv("AGENT_PROMPT")
 
-	// Handle .gemini directory
-	if _, err := os.Stat("/workspaces/.gemini"); err == nil {
-		log.Println(".gemini directory exists in /workspaces, copying to repo directory")
-		if _, err := os.Stat(".gemini"); err == nil {
-			log.Println(".gemini directory exists in repo directory, moving to .gemini.bak")
-			if err := os.Rename(".gemini", ".gemini.bak"); err != nil {
-				return fmt.Errorf("failed to move .gemini to .gemini.bak: %w", err)
-			}
-		}
-		if _, err := runCommand("cp", "-R", "/workspaces/.gemini", ".gemini"); err != nil {
-			return fmt.Errorf("failed to copy .gemini directory: %w", err)
-		}
-	} else {
-		log.Println(".gemini directory does not exist in /workspaces")
+	if err := sandbox.SetupGeminiConfig(sandbox.WorkspacesDir, "."); err != nil {
+		return err
 	}
 
 	// Run gemini
-	log.Println("agent-prompt.txt does not exist, running gemini")
-	if err := os.WriteFile("../agent-prompt.txt", []byte(agentPrompt), 0644); err != nil {
+	log.Println("agent-prompt.txt is missing or outdated, running gemini")
+	if err := os.WriteFile(sandbox.PromptFile, []byte(agentPrompt), 0644); err != nil {
 		return fmt.Errorf("failed to write agent-prompt.txt: %w", err)
 	}
+	if err := os.Remove(sandbox.QuestionsFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove %s: %v", sandbox.QuestionsFile, err)
+	}
 	geminiAPIKey := os.Getenv("GEMINI_API_KEY")
 	if geminiAPIKey == "" {
 		geminiAPIKeyBytes, err := os.ReadFile("/tokens/gemini")
@@ -189,54 +507,179 @@ func runIssueSolver() error {
 		}
 		geminiAPIKey = string(geminiAPIKeyBytes)
 	}
-	cmd := exec.Command("gemini", "-y", "-p", agentPrompt)
-	cmd.Env = append(os.Environ(), "GEMINI_API_KEY="+geminiAPIKey)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("gemini command failed: %v, output: %s", err, string(output))
+
+	validationCmd := getValidationCommand()
+	maxAttempts := 1
+	if validationCmd != "" {
+		maxAttempts = defaultValidationMaxAttempts
+		if v, err := strconv.Atoi(os.Getenv("VALIDATION_MAX_ATTEMPTS")); err == nil && v > 0 {
+			maxAttempts = v
+		}
+		log.Printf("Validating changes with %q, up to %d attempts", validationCmd, maxAttempts)
 	}
-	if err := os.WriteFile("../agent-output.txt", output, 0644); err != nil {
-		return fmt.Errorf("failed to write agent-output.txt: %w", err)
+	selfReviewIterations, _ := strconv.Atoi(os.Getenv("SELF_REVIEW_ITERATIONS"))
+	if selfReviewIterations < 0 {
+		selfReviewIterations = 0
+	}
+	maxIterations := maxAttempts
+	if selfReviewIterations+1 > maxIterations {
+		maxIterations = selfReviewIterations + 1
+	}
+	if selfReviewIterations > 0 {
+		log.Printf("Self-review enabled, up to %d iterations", maxIterations)
 	}
 
-	// Cleanup .gemini
-	if _, err := os.Stat(".gemini.bak"); err == nil {
-		log.Println("moving .gemini.bak -> .gemini")
-		if err := os.RemoveAll(".gemini"); err != nil {
-			log.Printf("failed to remove .gemini directory: %v", err)
+	var agentOutput []byte
+	validated := validationCmd == ""
+	agentPrompt = fmt.Sprintf("%s\n\nIf you have open questions for the maintainers about this issue, write them one per line to the file %s.", agentPrompt, sandbox.QuestionsFile)
+	prompt := agentPrompt
+	lastDiff := ""
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		setPhase(sandbox.PhaseGenerating)
+		output := runGemini(prompt, geminiAPIKey)
+		agentOutput = append(agentOutput, output...)
+
+		diff := currentDiff()
+		if selfReviewIterations > 0 && iteration > 1 && diff == lastDiff {
+			log.Printf("No changes made in iteration %d, stopping", iteration)
+			break
+		}
+		lastDiff = diff
+
+		validationFeedback := ""
+		if validationCmd != "" {
+			setPhase(sandbox.PhaseTesting)
+			validationOutput, err := runValidation(validationCmd)
+			if err == nil {
+				log.Printf("Validation passed on iteration %d", iteration)
+				agentOutput = append(agentOutput, fmt.Sprintf("\n\nValidation `%s` passed on iteration %d/%d.\n", validationCmd, iteration, maxIterations)...)
+				validated = true
+				break
+			}
+			log.Printf("Validation failed on iteration %d: %v", iteration, err)
+			agentOutput = append(agentOutput, fmt.Sprintf("\n\nValidation `%s` failed on iteration %d/%d: %v\n", validationCmd, iteration, maxIterations, err)...)
+			validationFeedback = fmt.Sprintf("The command `%s` failed with the following output:\n```\n%s\n```\n", validationCmd, validationOutput)
+		} else if iteration > selfReviewIterations {
+			break
 		}
-		if err := os.Rename(".gemini.bak", ".gemini"); err != nil {
-			return fmt.Errorf("failed to move .gemini.bak to .gemini: %w", err)
+
+		if selfReviewIterations > 0 {
+			prompt = selfReviewPrompt(agentPrompt, diff, validationFeedback)
+		} else {
+			prompt = fmt.Sprintf("%s\n\nYour previous changes did not pass validation. %sPlease fix the problems so that the command passes.", agentPrompt, validationFeedback)
 		}
 	}
 
+	if err := os.WriteFile(sandbox.OutputFile, agentOutput, 0644); err != nil {
+		return fmt.Errorf("failed to write agent-output.txt: %w", err)
+	}
+
+	// Cleanup .gemini
+	if err := sandbox.RestoreGeminiConfig("."); err != nil {
+		return err
+	}
+
+	if !validated {
+		return fmt.Errorf("%w: %q did not pass after %d iterations", errValidationFailed, validationCmd, maxIterations)
+	}
 	return nil
 }
 
-func _runCommand(name string, args ...string) ([]byte, error) {
-	cmd := exec.Command(name, args...)
+// runGemini runs the gemini cli with the given prompt and returns its
+// combined output. Failures are logged and the output is still returned so
+// that it ends up in agent-output.txt. The run is recorded to the transcript
+// and the tokens used are added to the usage file.
+func runGemini(prompt, geminiAPIKey string) []byte {
+	cmd := exec.Command("gemini", "-y", "-p", prompt)
+	cmd.Env = append(os.Environ(), "GEMINI_API_KEY="+geminiAPIKey)
+	start := time.Now()
 	output, err := cmd.CombinedOutput()
+	transcript.Record("gemini-cli", prompt, output, start, err)
 	if err != nil {
-		return output, fmt.Errorf("command %s %v failed with output %s: %w", name, args, string(output), err)
+		log.Printf("gemini command failed: %v, output: %s", err, string(output))
+	}
+	// The gemini cli doesn't report its tokens in its text output
+	usage := sandbox.Usage{InputTokens: llm.EstimateTokens([]byte(prompt)), OutputTokens: llm.EstimateTokens(output)}
+	if err := sandbox.AddUsage(sandbox.UsageFile, usage); err != nil {
+		log.Printf("failed to record the token usage: %v", err)
 	}
-	return output, nil
+	return output
 }
 
-func runCommand(name string, args ...string) ([]byte, error) {
-	log.Printf("Running command: %s %v", name, args)
-	return _runCommand(name, args...)
+// currentDiff returns the changes made to the repo so far, including the
+// names of new files, truncated to maxReviewDiff.
+func currentDiff() string {
+	diff, err := sandbox.RunCommand("git", "diff", "HEAD")
+	if err != nil {
+		log.Printf("failed to get diff: %v", err)
+	}
+	untracked, err := sandbox.RunCommand("git", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		log.Printf("failed to list untracked files: %v", err)
+	}
+	out := string(diff)
+	if files := strings.TrimSpace(string(untracked)); files != "" {
+		out += "\nNew files:\n" + files + "\n"
+	}
+	if len(out) > maxReviewDiff {
+		out = out[:maxReviewDiff] + "\n... (diff truncated)\n"
+	}
+	return out
 }
 
-func startCodeServer() (*exec.Cmd, error) {
-	log.Println("starting code-server")
-	codeServerPath := "/usr/bin/code-server"
-	args := []string{"--auth=none", "--bind-addr=0.0.0.0:13337"}
-	cmd := exec.Command(codeServerPath, args...)
-	cmd.Stdout = os.Stdout
-	err := cmd.Start()
+// selfReviewPrompt asks the agent to critique and refine the diff it
+// produced for the original prompt.
+func selfReviewPrompt(agentPrompt, diff, validationFeedback string) string {
+	var b strings.Builder
+	b.WriteString(agentPrompt)
+	b.WriteString("\n\nYou have already made changes to address this task. This is the diff you produced:\n```diff\n")
+	b.WriteString(diff)
+	b.WriteString("\n```\n")
+	if validationFeedback != "" {
+		b.WriteString("\nThe changes do not pass validation yet. ")
+		b.WriteString(validationFeedback)
+	}
+	b.WriteString("\nReview the diff critically as a maintainer would: check that it fully addresses the task, look for bugs, missing edge cases, missing tests and unrelated changes. Then refine the changes in place. If the changes are already correct and complete, do not modify any files.")
+	return b.String()
+}
+
+// runValidation runs the validation command in the repo directory and
+// returns the tail of its output.
+func runValidation(command string) (string, error) {
+	log.Printf("Running validation: %s", command)
+	output, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if len(output) > maxValidationOutput {
+		output = output[len(output)-maxValidationOutput:]
+	}
+	return string(output), err
+}
+
+// getValidationCommand returns the validation command from the environment,
+// falling back to customizations.repo-agent.validationCommand in the
+// devcontainer.json.
+func getValidationCommand() string {
+	if command := os.Getenv("VALIDATION_COMMAND"); command != "" {
+		return command
+	}
+
+	b, err := os.ReadFile(devcontainerFile)
 	if err != nil {
-		return nil, err
+		return ""
+	}
+	// devcontainer.json allows comments and trailing commas
+	b = devcontainerLineComments.ReplaceAll(b, nil)
+	b = devcontainerTrailingCommas.ReplaceAll(b, []byte("$1"))
+
+	var devcontainer struct {
+		Customizations struct {
+			RepoAgent struct {
+				ValidationCommand string `json:"validationCommand"`
+			} `json:"repo-agent"`
+		} `json:"customizations"`
+	}
+	if err := json.Unmarshal(b, &devcontainer); err != nil {
+		log.Printf("failed to parse %s, skipping validation command lookup: %v", devcontainerFile, err)
+		return ""
 	}
-	log.Printf("Running code-server in subprocess %d\n", cmd.Process.Pid)
-	return cmd, nil
+	return devcontainer.Customizations.RepoAgent.ValidationCommand
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+)
+
+const defaultMaxStageFileSize = 1024 * 1024
+
+// defaultStageExcludes are agent artifacts that should never be committed.
+var defaultStageExcludes = []string{
+	".gemini",
+	".gemini.bak",
+	"node_modules",
+	"*.log",
+	"*.orig",
+	"*.rej",
+	".DS_Store",
+}
+
+// stagingConfig controls which changed files are staged for the commit.
+type stagingConfig struct {
+	include     []string
+	exclude     []string
+	maxFileSize int64
+}
+
+// getStagingConfig reads the staging configuration from the environment.
+func getStagingConfig() stagingConfig {
+	cfg := stagingConfig{
+		include:     splitPatterns(os.Getenv("STAGE_INCLUDE")),
+		exclude:     append(splitPatterns(os.Getenv("STAGE_EXCLUDE")), defaultStageExcludes...),
+		maxFileSize: defaultMaxStageFileSize,
+	}
+	if v := os.Getenv("STAGE_MAX_FILE_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Printf("invalid STAGE_MAX_FILE_SIZE %q, using %d", v, cfg.maxFileSize)
+		} else {
+			cfg.maxFileSize = n
+		}
+	}
+	return cfg
+}
+
+func splitPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// stageChanges stages the changed files that pass the staging config and
+// returns them. Untracked files ignored by .gitignore are never considered.
+func stageChanges(cfg stagingConfig) ([]string, error) {
+	// Unstage the files the agent may have added itself, so that they are
+	// filtered too.
+	if _, err := sandbox.RunCommand("git", "reset", "-q"); err != nil {
+		return nil, fmt.Errorf("failed to unstage changes: %w", err)
+	}
+	// Lists modified, deleted and untracked files, honoring .gitignore.
+	output, err := sandbox.RunCommand("git", "ls-files", "-z", "--modified", "--deleted", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files: %w", err)
+	}
+
+	var staged []string
+	seen := map[string]bool{}
+	for _, f := range bytes.Split(output, []byte{0}) {
+		file := string(f)
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		if !cfg.allows(file) {
+			log.Printf("Not staging %s: excluded by staging config", file)
+			continue
+		}
+		if info, err := os.Lstat(file); err == nil && info.Mode().IsRegular() && info.Size() > cfg.maxFileSize {
+			log.Printf("Not staging %s: size %d exceeds limit of %d bytes", file, info.Size(), cfg.maxFileSize)
+			continue
+		}
+		staged = append(staged, file)
+	}
+
+	if len(staged) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"add", "--all", "--"}, staged...)
+	if _, err := sandbox.RunCommand("git", args...); err != nil {
+		return nil, fmt.Errorf("failed to git add: %w", err)
+	}
+	return staged, nil
+}
+
+// allows reports whether file passes the include and exclude patterns.
+func (c stagingConfig) allows(file string) bool {
+	for _, p := range c.exclude {
+		if matchPath(p, file) {
+			return false
+		}
+	}
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, p := range c.include {
+		if matchPath(p, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath reports whether pattern matches file, one of its parent
+// directories, or the base name of either.
+func matchPath(pattern, file string) bool {
+	parts := strings.Split(file, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		if ok, _ := path.Match(pattern, prefix); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, parts[i]); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStagingConfigAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		file    string
+		want    bool
+	}{
+		{name: "no patterns", file: "main.go", want: true},
+		{name: "default exclude dir", exclude: defaultStageExcludes, file: "web/node_modules/foo/index.js", want: false},
+		{name: "default exclude base name", exclude: defaultStageExcludes, file: "logs/agent.log", want: false},
+		{name: "default exclude top level dir", exclude: defaultStageExcludes, file: ".gemini.bak/settings.json", want: false},
+		{name: "include dir", include: []string{"pkg"}, file: "pkg/foo/bar.go", want: true},
+		{name: "include glob", include: []string{"*.go"}, file: "cmd/main.go", want: true},
+		{name: "not included", include: []string{"pkg"}, file: "cmd/main.go", want: false},
+		{name: "exclude wins over include", include: []string{"pkg"}, exclude: []string{"pkg/gen"}, file: "pkg/gen/zz.go", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := stagingConfig{include: tt.include, exclude: tt.exclude}
+			if got := cfg.allows(tt.file); got != tt.want {
+				t.Errorf("allows(%q) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageChanges(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	git("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+
+	// The agent staged an excluded and a too large file itself
+	write("main.go", "package main\n")
+	write(".gemini/settings.json", "{}")
+	write("big.bin", strings.Repeat("x", 100))
+	git("add", "--all")
+
+	staged, err := stageChanges(stagingConfig{exclude: defaultStageExcludes, maxFileSize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main.go"}; !reflect.DeepEqual(staged, want) {
+		t.Errorf("stageChanges() = %q, want %q", staged, want)
+	}
+	if got, want := git("diff", "--cached", "--name-only"), "main.go\n"; got != want {
+		t.Errorf("staged files = %q, want %q", got, want)
+	}
+}
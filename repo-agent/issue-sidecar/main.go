@@ -19,20 +19,99 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
-	outputFile = "/workspaces/agent-output.txt"
+	fieldManager = "issue-sidecar"
+
+	conditionRunning   = "Running"
+	conditionCompleted = "Completed"
+	conditionFailed    = "Failed"
+
+	// debounceInterval is how long to wait after the last change to a file
+	// before publishing it, so bursts of writes result in a single update.
+	debounceInterval = time.Second
+	// resyncInterval is how often all files are checked regardless of
+	// filesystem events, in case an event was missed.
+	resyncInterval = time.Minute
+	// heartbeatInterval is how often the heartbeat timestamp is published.
+	heartbeatInterval = 30 * time.Second
 )
 
+// statusField describes how a file written by the issue sandbox is published
+// to the IssueSandbox status.
+type statusField struct {
+	// name of the status field
+	name string
+	// maxSize truncates the content to this many bytes
+	maxSize int
+	// tail keeps the end of the content instead of the start when truncating
+	tail bool
+}
+
+// statusFields maps the files written by the issue sandbox to the
+// IssueSandbox status fields they are published to.
+var statusFields = map[string]statusField{
+	sandbox.OutputFile:         {name: "agentDraft", maxSize: 64 * 1024, tail: true},
+	sandbox.PromptFile:         {name: "agentPrompt", maxSize: 32 * 1024},
+	sandbox.PullRequestURLFile: {name: "pullRequestURL", maxSize: 1024},
+	sandbox.ResultFile:         {name: "result", maxSize: 64 * 1024},
+	sandbox.RunLogFile:         {name: "runLog", maxSize: 8 * 1024, tail: true},
+	sandbox.ErrorFile:          {name: "error", maxSize: 4 * 1024},
+	sandbox.PhaseFile:          {name: "phase", maxSize: 64},
+	sandbox.UsageFile:          {name: "usage"},
+}
+
+// statusValue converts the contents of file to the value of its status
+// field. The result and usage manifests are published as typed objects, other
+// files as plain strings truncated to the field's size limit.
+func statusValue(file string, b []byte) (interface{}, error) {
+	switch file {
+	case sandbox.ResultFile:
+		result, err := sandbox.ParseResult(b)
+		if err != nil {
+			return nil, err
+		}
+		return result.StatusFields(), nil
+	case sandbox.UsageFile:
+		usage, err := sandbox.ParseUsage(b)
+		if err != nil {
+			return nil, err
+		}
+		return usage.StatusFields(), nil
+	}
+	return truncate(string(b), statusFields[file]), nil
+}
+
+func truncate(s string, field statusField) string {
+	if field.maxSize <= 0 || len(s) <= field.maxSize {
+		return s
+	}
+	if field.tail {
+		return s[len(s)-field.maxSize:]
+	}
+	return s[:field.maxSize]
+}
+
 var (
 	gvr = schema.GroupVersionResource{
 		Group:    "custom.agents.x-k8s.io",
@@ -42,15 +121,26 @@ var (
 )
 
 func main() {
-	fmt.Println("starting issue sidecar")
+	// The run-log container of the sandbox pods only streams the run log,
+	// read by the API as its logs
+	if len(os.Args) > 1 && os.Args[1] == sandbox.RunLogContainer {
+		if err := sandbox.FollowFile(context.Background(), sandbox.RunLogFile, os.Stdout, time.Second); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to follow %s: %v\n", sandbox.RunLogFile, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logging.Setup("issue-sidecar", os.Stderr)
+	slog.Info("starting issue sidecar")
 	name := os.Getenv("NAME")
 	if name == "" {
-		fmt.Println("missing NAME env")
+		slog.Error("missing NAME env")
 		os.Exit(1)
 	}
 	namespace := os.Getenv("NAMESPACE")
 	if namespace == "" {
-		fmt.Println("missing NAMESPACE env")
+		slog.Error("missing NAMESPACE env")
 		os.Exit(1)
 	}
 
@@ -58,45 +148,282 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
+	config.Wrap(tracing.Transport)
 
 	dc, err := dynamic.NewForConfig(config)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	var last string
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		panic(err.Error())
+	}
+	defer watcher.Close()
+	if err := watcher.Add(sandbox.WorkspacesDir); err != nil {
+		panic(err.Error())
+	}
+	slog.Info("watching for changes", "dir", sandbox.WorkspacesDir)
+
+	p := &publisher{dc: dc, namespace: namespace, name: name, last: map[string]string{}, status: map[string]interface{}{}}
+	// Status updates continue the trace of the controller creating the sandbox
+	p.tracer = tracing.FromEnv("issue-sidecar")
+	p.traceCtx = tracing.ContextWithTraceparent(context.Background(), os.Getenv("TRACEPARENT"))
+	if bucketURL := os.Getenv("ARTIFACT_BUCKET_URL"); bucketURL != "" {
+		store, prefix, err := sandbox.NewArtifactStore(bucketURL)
+		if err != nil {
+			slog.Warn("artifact upload disabled", "error", err)
+		} else {
+			p.artifacts = store
+			p.artifactPrefix = path.Join(prefix, namespace, name)
+		}
+	}
+	// The sidecar starts while envbuilder is still cloning the repo, before
+	// the issue sandbox writes its first phase.
+	p.setPhase(sandbox.PhaseCloning)
+	p.status["heartbeat"] = time.Now().UTC().Format(time.RFC3339)
+
+	// Publish whatever already exists, e.g. after a restart
+	pending := map[string]bool{}
+	for file := range statusFields {
+		pending[file] = true
+	}
+	debounce := time.NewTimer(0)
+	resync := time.NewTicker(resyncInterval)
+	defer resync.Stop()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
 	for {
-		time.Sleep(10 * time.Second)
-		fmt.Println("watching for file", outputFile)
-		_, err := os.Stat(outputFile)
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if _, watched := statusFields[event.Name]; !watched {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+				pending[event.Name] = true
+				debounce.Reset(debounceInterval)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("watch error", "error", err)
+		case <-heartbeat.C:
+			p.status["heartbeat"] = time.Now().UTC().Format(time.RFC3339)
+			if err := p.apply(context.TODO()); err != nil {
+				slog.Error("unable to update heartbeat", "error", err)
+			}
+		case <-resync.C:
+			for file := range statusFields {
+				pending[file] = true
+			}
+			debounce.Reset(0)
+		case <-debounce.C:
+			if err := p.publish(pending); err != nil {
+				slog.Error("unable to update status", "error", err)
+				// Retry failed updates later
+				debounce.Reset(10 * time.Second)
+				continue
+			}
+			clear(pending)
+			if err := p.uploadArtifacts(); err != nil {
+				slog.Error("unable to upload artifacts", "error", err)
+			}
+		}
+	}
+}
+
+// publisher updates the IssueSandbox status with the contents of the files
+// written by the issue sandbox.
+type publisher struct {
+	dc        dynamic.Interface
+	namespace string
+	name      string
+	// last holds the last published content of each file
+	last map[string]string
+	// status holds the values of all the status fields owned by the
+	// sidecar. Server-side apply drops owned fields missing from the applied
+	// object, so every apply sends all of them.
+	status     map[string]interface{}
+	conditions []metav1.Condition
+
+	// artifacts is where the run artifacts are uploaded once the run ends,
+	// nil if no bucket is configured.
+	artifacts      sandbox.ArtifactStore
+	artifactPrefix string
+	// uploadedPhaseStart is the phaseStartTime of the last uploaded run
+	uploadedPhaseStart interface{}
+
+	// tracer traces the status updates as children of the span of traceCtx
+	tracer   *tracing.Tracer
+	traceCtx context.Context
+}
+
+// startSpan starts a span of a status update.
+func (p *publisher) startSpan(name string) (context.Context, *tracing.Span) {
+	ctx := p.traceCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return p.tracer.Start(ctx, name, tracing.KindInternal)
+}
+
+// publish reads the given files and applies the status fields of the ones
+// that changed in a single update. The fields of the files removed since they
+// were published are dropped, e.g. the error of a failed run once rerun.
+func (p *publisher) publish(files map[string]bool) error {
+	changed := map[string]string{}
+	removed := map[string]bool{}
+	for file := range files {
+		field := statusFields[file]
+		b, err := os.ReadFile(file)
 		if os.IsNotExist(err) {
+			if _, published := p.last[file]; published && file != sandbox.PhaseFile {
+				delete(p.status, field.name)
+				removed[file] = true
+			}
 			continue
 		}
-		b, err := os.ReadFile(outputFile)
 		if err != nil {
-			fmt.Println("reading file:", err)
-			continue
+			return fmt.Errorf("reading file: %w", err)
 		}
-		if string(b) == last {
+		if string(b) == p.last[file] {
 			continue
 		}
-		fmt.Println("file changed, updating crd")
-		iss, err := dc.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		value, err := statusValue(file, b)
 		if err != nil {
-			fmt.Println("getting issuesandbox:", err)
+			// The file may be partially written, wait for the next event
+			slog.Warn("unable to parse file", "file", file, "error", err)
 			continue
 		}
+		slog.Info("file changed, updating crd field", "field", field.name)
+		if file == sandbox.PhaseFile {
+			p.setPhase(value.(string))
+		} else {
+			p.status[field.name] = value
+		}
+		changed[file] = string(b)
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
 
-		if err := unstructured.SetNestedField(iss.Object, string(b), "status", "agentDraft"); err != nil {
-			fmt.Println("setting status:", err)
-			continue
+	setConditions(&p.conditions, p.status)
+	ctx, span := p.startSpan("issue-sidecar.publish")
+	span.SetAttribute("phase", fmt.Sprint(p.status["phase"]))
+	err := p.apply(ctx)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	for file, content := range changed {
+		p.last[file] = content
+	}
+	for file := range removed {
+		delete(p.last, file)
+	}
+	slog.Info("updated crd with latest changes")
+	return nil
+}
+
+// uploadArtifacts uploads the run artifacts once per finished run and
+// publishes their URL.
+func (p *publisher) uploadArtifacts() (err error) {
+	if p.artifacts == nil {
+		return nil
+	}
+	if phase := p.status["phase"]; phase != sandbox.PhaseCompleted && phase != sandbox.PhaseFailed {
+		return nil
+	}
+	if p.status["phaseStartTime"] == p.uploadedPhaseStart {
+		return nil
+	}
+	ctx, span := p.startSpan("issue-sidecar.uploadArtifacts")
+	defer func() { span.End(err) }()
+	url, err := sandbox.UploadArtifacts(ctx, p.artifacts, sandbox.WorkspacesDir, p.artifactPrefix)
+	if err != nil {
+		return err
+	}
+	p.uploadedPhaseStart = p.status["phaseStartTime"]
+	slog.Info("uploaded artifacts", "url", url)
+	p.status["artifactURL"] = url
+	return p.apply(ctx)
+}
+
+// setPhase sets the phase and, if it changed, the time it was entered.
+func (p *publisher) setPhase(phase string) {
+	phase = strings.TrimSpace(phase)
+	if p.status["phase"] == phase {
+		return
+	}
+	p.status["phase"] = phase
+	p.status["phaseStartTime"] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// apply server-side applies the sidecar's status fields, retrying transient
+// failures.
+func (p *publisher) apply(ctx context.Context) error {
+	status := map[string]interface{}{}
+	for k, v := range p.status {
+		status[k] = v
+	}
+	conditions := make([]interface{}, 0, len(p.conditions))
+	for i := range p.conditions {
+		c, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&p.conditions[i])
+		if err != nil {
+			return err
 		}
+		conditions = append(conditions, c)
+	}
+	status["agentConditions"] = conditions
 
-		if _, err := dc.Resource(gvr).Namespace(namespace).UpdateStatus(context.TODO(), iss, metav1.UpdateOptions{}); err != nil {
-			fmt.Println("updating status:", err)
-			continue
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       "IssueSandbox",
+			"metadata": map[string]interface{}{
+				"name":      p.name,
+				"namespace": p.namespace,
+			},
+			"status": status,
+		},
+	}
+	return retry.OnError(retry.DefaultBackoff, isRetriable, func() error {
+		_, err := p.dc.Resource(gvr).Namespace(p.namespace).ApplyStatus(ctx, p.name, obj,
+			metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+		return err
+	})
+}
+
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err)
+}
+
+// setConditions derives the Running, Completed and Failed conditions from the
+// published status fields. Exactly one of them is true.
+func setConditions(conditions *[]metav1.Condition, status map[string]interface{}) {
+	current, reason, message := conditionRunning, "AgentRunning", "The agent is working on the issue"
+	testStatus, _, _ := unstructured.NestedString(status, "result", "testStatus")
+	switch {
+	case status["error"] != nil && status["error"] != "":
+		current, reason, message = conditionFailed, "SandboxError", fmt.Sprint(status["error"])
+	case testStatus == sandbox.TestStatusFailed:
+		current, reason, message = conditionFailed, "ValidationFailed", "The changes did not pass validation"
+	case status["result"] != nil:
+		current, reason, message = conditionCompleted, "AgentCompleted", "The agent finished working on the issue"
+	}
+
+	for _, t := range []string{conditionRunning, conditionCompleted, conditionFailed} {
+		c := metav1.Condition{Type: t, Status: metav1.ConditionFalse, Reason: reason, Message: message}
+		if t == current {
+			c.Status = metav1.ConditionTrue
 		}
-		last = string(b)
-		fmt.Println("updated crd with latest changes")
+		meta.SetStatusCondition(conditions, c)
 	}
 }
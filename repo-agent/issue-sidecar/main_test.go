@@ -0,0 +1,146 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		field statusField
+		want  string
+	}{
+		{name: "no limit", in: "abcdef", field: statusField{}, want: "abcdef"},
+		{name: "within limit", in: "abc", field: statusField{maxSize: 3}, want: "abc"},
+		{name: "keep head", in: "abcdef", field: statusField{maxSize: 3}, want: "abc"},
+		{name: "keep tail", in: "abcdef", field: statusField{maxSize: 3, tail: true}, want: "def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncate(tt.in, tt.field); got != tt.want {
+				t.Errorf("truncate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		status map[string]interface{}
+		want   string
+		reason string
+	}{
+		{name: "running", status: map[string]interface{}{"agentPrompt": "fix it"}, want: conditionRunning, reason: "AgentRunning"},
+		{name: "completed", status: map[string]interface{}{"result": map[string]interface{}{"testStatus": "Passed"}}, want: conditionCompleted, reason: "AgentCompleted"},
+		{name: "validation failed", status: map[string]interface{}{"result": map[string]interface{}{"testStatus": "Failed"}}, want: conditionFailed, reason: "ValidationFailed"},
+		{name: "error", status: map[string]interface{}{"error": "failed to push"}, want: conditionFailed, reason: "SandboxError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conditions []metav1.Condition
+			setConditions(&conditions, tt.status)
+			if len(conditions) != 3 {
+				t.Fatalf("got %d conditions, want 3", len(conditions))
+			}
+			for _, c := range conditions {
+				wantStatus := metav1.ConditionFalse
+				if c.Type == tt.want {
+					wantStatus = metav1.ConditionTrue
+				}
+				if c.Status != wantStatus {
+					t.Errorf("condition %s status = %s, want %s", c.Type, c.Status, wantStatus)
+				}
+				if c.Reason != tt.reason {
+					t.Errorf("condition %s reason = %s, want %s", c.Type, c.Reason, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishRerunAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	errorFile, outputFile := filepath.Join(dir, "error.txt"), filepath.Join(dir, "agent-output.txt")
+	oldFields := statusFields
+	defer func() { statusFields = oldFields }()
+	statusFields = map[string]statusField{
+		errorFile:  {name: "error"},
+		outputFile: {name: "agentDraft"},
+	}
+
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	var applied map[string]interface{}
+	failApply := false
+	dc.PrependReactor("patch", "issuesandboxes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failApply {
+			return true, nil, errors.New("apply failed")
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(action.(k8stesting.PatchAction).GetPatch()); err != nil {
+			return true, nil, err
+		}
+		applied, _, _ = unstructured.NestedMap(obj.Object, "status")
+		return true, obj, nil
+	})
+	p := &publisher{dc: dc, namespace: "default", name: "repo-issue-1-fix", last: map[string]string{}, status: map[string]interface{}{}, tracer: tracing.FromEnv("issue-sidecar")}
+	files := map[string]bool{errorFile: true, outputFile: true}
+
+	// The first run fails
+	if err := os.WriteFile(errorFile, []byte("failed to push"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.publish(files); err != nil {
+		t.Fatal(err)
+	}
+	if applied["error"] != "failed to push" {
+		t.Fatalf("status = %v, want the error", applied)
+	}
+
+	// The rerun removes the error, whose removal is retried if the update
+	// fails
+	if err := os.Remove(errorFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outputFile, []byte("fixed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failApply = true
+	if err := p.publish(files); err == nil {
+		t.Fatal("publish() succeeded, want the apply error")
+	}
+	failApply = false
+	if err := p.publish(files); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := applied["error"]; found || applied["agentDraft"] != "fixed" {
+		t.Errorf("status = %v, want the output of the rerun without the error", applied)
+	}
+}
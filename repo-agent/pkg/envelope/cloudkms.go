@@ -0,0 +1,101 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/gcp"
+)
+
+const cloudKMSEndpoint = "https://cloudkms.googleapis.com"
+
+// CloudKMS encrypts data keys with a Cloud KMS symmetric key through its REST
+// API, authenticated as the pod's service account, which needs the Cloud KMS
+// CryptoKey Encrypter/Decrypter role.
+type CloudKMS struct {
+	// KeyName is projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+	KeyName string
+
+	endpoint string
+	client   *http.Client
+	tokens   *gcp.TokenSource
+}
+
+// NewCloudKMS returns the Cloud KMS key keyName.
+func NewCloudKMS(keyName string) *CloudKMS {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &CloudKMS{
+		KeyName:  keyName,
+		endpoint: cloudKMSEndpoint,
+		client:   client,
+		tokens:   &gcp.TokenSource{Client: client},
+	}
+}
+
+func (k *CloudKMS) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := k.call(ctx, "encrypt", map[string][]byte{"plaintext": plaintext}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (k *CloudKMS) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := k.call(ctx, "decrypt", map[string][]byte{"ciphertext": ciphertext}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// call calls a method of the key. Bytes are base64 encoded in JSON, as the
+// API expects.
+func (k *CloudKMS) call(ctx context.Context, method string, body, out interface{}) error {
+	token, err := k.tokens.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/v1/%s:%s", k.endpoint, k.KeyName, method), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("cloud kms %s: %s: %s", method, resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,131 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envelope encrypts the credentials stored in Kubernetes secrets with
+// envelope encryption: each value is encrypted with a random AES-256-GCM data
+// key, itself encrypted by a KMS. Reading the secret is not enough to get the
+// credential, the KMS key is needed too.
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// prefix marks sealed values, so that plain values stored before encryption
+// was turned on are still read.
+const prefix = "envelope:v1:"
+
+// KMS encrypts and decrypts data keys.
+type KMS interface {
+	// Encrypt returns the ciphertext of a data key.
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	// Decrypt returns the data key of a ciphertext returned by Encrypt.
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// FromEnv returns the KMS configured by KMS_KEY_NAME, the name of a Cloud KMS
+// key, or nil if it is unset and values are stored in plain.
+func FromEnv() KMS {
+	if name := os.Getenv("KMS_KEY_NAME"); name != "" {
+		return NewCloudKMS(name)
+	}
+	return nil
+}
+
+// sealed is the JSON encoding of a sealed value.
+type sealed struct {
+	// Key is the data key, encrypted by the KMS
+	Key   []byte `json:"key"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// IsSealed reports whether value was returned by Seal.
+func IsSealed(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(prefix))
+}
+
+// Seal encrypts plaintext with a new data key encrypted by kms.
+func Seal(ctx context.Context, kms KMS, plaintext []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped, err := kms.Encrypt(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt the data key: %w", err)
+	}
+	b, err := json.Marshal(sealed{Key: wrapped, Nonce: nonce, Data: gcm.Seal(nil, nonce, plaintext, nil)})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(prefix + base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// Open decrypts a value returned by Seal. Values that are not sealed are
+// returned as is.
+func Open(ctx context.Context, kms KMS, value []byte) ([]byte, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if kms == nil {
+		return nil, errors.New("the value is encrypted but no KMS is configured, set KMS_KEY_NAME")
+	}
+	b, err := base64.StdEncoding.DecodeString(string(value[len(prefix):]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed value: %w", err)
+	}
+	var s sealed
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("invalid sealed value: %w", err)
+	}
+	key, err := kms.Decrypt(ctx, s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, s.Nonce, s.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the value: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,112 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	kms := newTestCloudKMS(t)
+
+	sealed, err := Seal(ctx, kms, []byte("ghp_secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("ghp_secret")) {
+		t.Fatalf("Seal() = %q, want an opaque sealed value", sealed)
+	}
+	got, err := Open(ctx, kms, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ghp_secret" {
+		t.Errorf("Open() = %q, want ghp_secret", got)
+	}
+
+	// Values stored before encryption was turned on are read as is
+	if got, err := Open(ctx, nil, []byte("plain")); err != nil || string(got) != "plain" {
+		t.Errorf("Open(plain) = %q, %v, want plain", got, err)
+	}
+	if _, err := Open(ctx, nil, sealed); err == nil {
+		t.Error("Open() without a KMS succeeded, want an error")
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := Open(ctx, kms, tampered); err == nil {
+		t.Error("Open() of a tampered value succeeded, want an error")
+	}
+}
+
+// newTestCloudKMS returns a CloudKMS whose API "encrypts" by reversing the
+// bytes, checking the requests are authenticated.
+func newTestCloudKMS(t *testing.T) *CloudKMS {
+	const keyName = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req map[string][]byte
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reverse := func(b []byte) []byte {
+			out := make([]byte, len(b))
+			for i := range b {
+				out[len(b)-1-i] = b[i]
+			}
+			return out
+		}
+		switch {
+		case r.URL.Path == "/v1/"+keyName+":encrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{"ciphertext": reverse(req["plaintext"])})
+		case r.URL.Path == "/v1/"+keyName+":decrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{"plaintext": reverse(req["ciphertext"])})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	kms := NewCloudKMS(keyName)
+	kms.endpoint = srv.URL
+	kms.tokens.URL = srv.URL
+	return kms
+}
+
+func TestCloudKMSError(t *testing.T) {
+	kms := newTestCloudKMS(t)
+	kms.KeyName = "projects/p/locations/global/keyRings/r/cryptoKeys/missing"
+	_, err := kms.Encrypt(context.Background(), []byte("key"))
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Encrypt() with a missing key = %v, want a 404 error", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcp authenticates to Google APIs as the pod's service account,
+// e.g. through workload identity, with the tokens of the metadata server.
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceAccountURL is the metadata server path of the pod's service account.
+const ServiceAccountURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default"
+
+// TokenSource mints the tokens of the pod's service account. Its zero value
+// uses the metadata server with http.DefaultClient.
+type TokenSource struct {
+	// URL is the service account, ServiceAccountURL if empty
+	URL string
+	// Client reaches the metadata server, http.DefaultClient if nil
+	Client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// AccessToken returns a cached access token, refreshing it shortly before it
+// expires.
+func (s *TokenSource) AccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expiry) {
+		return s.token, nil
+	}
+	body, err := s.get(ctx, "/token")
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %w", err)
+	}
+	s.token = token.AccessToken
+	s.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// IDToken returns an ID token for audience.
+func (s *TokenSource) IDToken(ctx context.Context, audience string) (string, error) {
+	body, err := s.get(ctx, "/identity?format=full&audience="+url.QueryEscape(audience))
+	if err != nil {
+		return "", fmt.Errorf("failed to get ID token: %w", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// get reads path of the service account from the metadata server.
+func (s *TokenSource) get(ctx context.Context, path string) ([]byte, error) {
+	base := s.URL
+	if base == "" {
+		base = ServiceAccountURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
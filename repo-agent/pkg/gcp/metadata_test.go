@@ -0,0 +1,65 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenSource(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		requests = append(requests, r.URL.String())
+		switch r.URL.Path {
+		case "/sa/token":
+			w.Write([]byte(`{"access_token": "access", "expires_in": 3600}`))
+		case "/sa/identity":
+			w.Write([]byte("id\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	s := &TokenSource{URL: srv.URL + "/sa"}
+
+	// Access tokens are cached until they expire
+	for range 2 {
+		token, err := s.AccessToken(ctx)
+		if err != nil || token != "access" {
+			t.Fatalf("AccessToken() = %q, %v, want access", token, err)
+		}
+	}
+	token, err := s.IDToken(ctx, "https://example.com")
+	if err != nil || token != "id" {
+		t.Fatalf("IDToken() = %q, %v, want id", token, err)
+	}
+	want := []string{"/sa/token", "/sa/identity?format=full&audience=https%3A%2F%2Fexample.com"}
+	if len(requests) != len(want) || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+
+	s = &TokenSource{URL: srv.URL + "/missing"}
+	if _, err := s.AccessToken(ctx); err == nil {
+		t.Error("AccessToken() of a missing service account succeeded")
+	}
+}
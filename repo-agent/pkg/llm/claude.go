@@ -21,7 +21,9 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
 )
 
 const (
@@ -35,11 +37,24 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+var _ UsageReporter = &Claude{}
+
 type Claude struct {
 	apiKey         string
 	client         HTTPClient
 	postProcessors []PostProcessor
 	URL            string
+	// Workspace provides the API key, from the environment of the process
+	// if it doesn't set it
+	Workspace Workspace
+	// Transcript records the runs, nil to not record them
+	Transcript *Transcript
+	usage      sandbox.Usage
+}
+
+// Usage returns the tokens of the runs so far, as reported by the API.
+func (c *Claude) Usage() sandbox.Usage {
+	return c.usage
 }
 
 func (c *Claude) AddPostProcessor(p PostProcessor) {
@@ -47,7 +62,7 @@ func (c *Claude) AddPostProcessor(p PostProcessor) {
 }
 
 func (c *Claude) Setup(_, _ string) error {
-	apiKey, ok := os.LookupEnv("ANTHROPIC_API_KEY")
+	apiKey, ok := c.Workspace.Getenv("ANTHROPIC_API_KEY")
 	if !ok {
 		return fmt.Errorf("ANTHROPIC_API_KEY environment variable not set")
 	}
@@ -89,30 +104,43 @@ func (c *Claude) Run(prompt string) ([]byte, error) {
 	if client == nil {
 		client = &http.Client{}
 	}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		c.Transcript.Record("claude", prompt, nil, start, err)
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		c.Transcript.Record("claude", prompt, body, start, err)
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		c.Transcript.Record("claude", prompt, body, start, err)
 		log.Printf("Claude API request failed with status %d: %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, err
 	}
+	// The raw response is the body of the API, with the usage
+	c.Transcript.Record("claude", prompt, body, start, nil)
 
 	var response struct {
 		Content []struct {
 			Text string `json:"text"`
 		} `json:"content"`
+		Usage struct {
+			InputTokens  int64 `json:"input_tokens"`
+			OutputTokens int64 `json:"output_tokens"`
+		} `json:"usage"`
 	}
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
 	}
+	c.usage.InputTokens += response.Usage.InputTokens
+	c.usage.OutputTokens += response.Usage.OutputTokens
 
 	if len(response.Content) == 0 {
 		return nil, fmt.Errorf("no content in response")
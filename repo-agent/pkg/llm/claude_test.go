@@ -250,3 +250,25 @@ func TestClaudeRunWithStripYAMLMarkers(t *testing.T) {
 		t.Errorf("TestClaudeRunWithStripYAMLMarkers: Expected %q, got %q", expected, string(resp))
 	}
 }
+
+func TestClaudeUsage(t *testing.T) {
+	mockClient := &MockClient{
+		DoFunc: func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"content":[{"text":"Hello!"}],"usage":{"input_tokens":12,"output_tokens":3}}`)),
+			}, nil
+		},
+	}
+
+	c := &Claude{apiKey: "test-key", client: mockClient}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Run("test prompt"); err != nil {
+			t.Fatalf("TestClaudeUsage failed: %v", err)
+		}
+	}
+
+	if usage := c.Usage(); usage.InputTokens != 24 || usage.OutputTokens != 6 {
+		t.Errorf("TestClaudeUsage: Expected 24 input and 6 output tokens, got %+v", usage)
+	}
+}
@@ -17,14 +17,17 @@ package llm
 import "os/exec"
 
 type CommandExecutor interface {
-	Run(command string, args ...string) ([]byte, error)
+	// Run runs a command in a workspace and returns its combined output.
+	Run(workspace Workspace, command string, args ...string) ([]byte, error)
 }
 
 // RealCommandExecutor is a real implementation of CommandExecutor that runs commands.
 
 type RealCommandExecutor struct{}
 
-func (e *RealCommandExecutor) Run(command string, args ...string) ([]byte, error) {
+func (e *RealCommandExecutor) Run(workspace Workspace, command string, args ...string) ([]byte, error) {
 	cmd := exec.Command(command, args...)
+	cmd.Dir = workspace.Dir
+	cmd.Env = workspace.Environ()
 	return cmd.CombinedOutput()
 }
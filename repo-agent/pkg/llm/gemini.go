@@ -18,18 +18,34 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
 )
 
 // Gemini is an Provider that uses the gemini-cli.
 //
 // Make sure that the Gemini struct implements the Provider interface.
 var _ Provider = &Gemini{}
+var _ UsageReporter = &Gemini{}
 
 type Gemini struct {
-	Executor   CommandExecutor
+	Executor CommandExecutor
+	// Workspace is where the gemini-cli runs, with the API key read by
+	// Setup
+	Workspace Workspace
+	// Transcript records the runs, nil to not record them
+	Transcript *Transcript
 	processors []PostProcessor
+	// usage is estimated from the prompts and outputs, the gemini-cli
+	// doesn't report it in its text output
+	usage sandbox.Usage
+}
+
+// Usage returns the estimated tokens of the runs so far.
+func (g *Gemini) Usage() sandbox.Usage {
+	return g.usage
 }
 
 func (g *Gemini) AddPostProcessor(p PostProcessor) {
@@ -37,26 +53,9 @@ func (g *Gemini) AddPostProcessor(p PostProcessor) {
 }
 
 func (g *Gemini) Setup(workspacesDir, tokensDir string) error {
-	// if .gemini directory exists in /workspaces copy it to home directory
-	geminiConfigDir := filepath.Join(workspacesDir, ".gemini")
-	if _, err := os.Stat(geminiConfigDir); err == nil {
-		log.Println(".gemini directory exists in /workspaces, copying to repo directory")
-		// if desitation .gemini directory exists move it to .gemini.bak
-		if _, err := os.Stat(".gemini"); err == nil {
-			log.Println(".gemini directory exists in repo directory, moving to .gemini.bak")
-			err := os.Rename(".gemini", ".gemini.bak")
-			if err != nil {
-				return fmt.Errorf("failed to move .gemini to .gemini.bak: %v", err)
-			}
-		}
-		cmd := exec.Command("cp", "-R", geminiConfigDir, ".gemini")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to copy .gemini directory: %v", err)
-		}
-	} else {
-		log.Println(".gemini directory does not exist in /workspaces")
+	// if .gemini directory exists in /workspaces copy it to the repo directory
+	if err := sandbox.SetupGeminiConfig(workspacesDir, g.Workspace.Dir); err != nil {
+		return err
 	}
 
 	geminiTokenFile := filepath.Join(tokensDir, "gemini")
@@ -64,14 +63,18 @@ func (g *Gemini) Setup(workspacesDir, tokensDir string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read %s: %v", geminiTokenFile, err)
 	}
-	os.Setenv("GEMINI_API_KEY", string(geminiKey))
+	g.Workspace.Setenv("GEMINI_API_KEY", string(geminiKey))
 	return nil
 }
 
 func (g *Gemini) Run(agentPrompt string) ([]byte, error) {
 	log.Println("running gemini")
 
-	output, err := g.Executor.Run("gemini", "-y", "-p", agentPrompt)
+	start := time.Now()
+	output, err := g.Executor.Run(g.Workspace, "gemini", "-y", "-p", agentPrompt)
+	g.Transcript.Record("gemini-cli", agentPrompt, output, start, err)
+	g.usage.InputTokens += EstimateTokens([]byte(agentPrompt))
+	g.usage.OutputTokens += EstimateTokens(output)
 	if err != nil {
 		log.Printf("gemini command failed: %v. Output: %s", err, string(output))
 		return nil, err
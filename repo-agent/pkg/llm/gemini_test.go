@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -82,29 +83,28 @@ func TestGemini_Setup(t *testing.T) {
 			t.Fatalf("Failed to write gemini token file: %v", err)
 		}
 
-		// Change the current working directory to the temporary directory
-		wd, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("Failed to get current working directory: %v", err)
-		}
-		defer func() {
-			_ = os.Chdir(wd)
-		}()
-		if err := os.Chdir(tmpDir); err != nil {
-			t.Fatalf("Failed to change working directory: %v", err)
+		// Create a Gemini provider for a repo and run Setup
+		repoDir := filepath.Join(tmpDir, "repo")
+		if err := os.MkdirAll(repoDir, 0755); err != nil {
+			t.Fatalf("Failed to create repo dir: %v", err)
 		}
-
-		// Create a Gemini provider and run Setup
-		g := &Gemini{}
+		g := &Gemini{Workspace: Workspace{Dir: repoDir}}
 		if err := g.Setup(workspacesDir, tokensDir); err != nil {
 			t.Fatalf("Gemini.Setup() failed: %v", err)
 		}
 
-		// Check if the environment variable is set
-		apiKey := os.Getenv("GEMINI_API_KEY")
+		// Check that the environment variable is set for the agent only
+		apiKey := g.Workspace.Env["GEMINI_API_KEY"]
 		if apiKey != "test-api-key" {
 			t.Errorf("Expected GEMINI_API_KEY to be 'test-api-key', but got '%s'", apiKey)
 		}
+		if _, ok := os.LookupEnv("GEMINI_API_KEY"); ok {
+			t.Error("Expected GEMINI_API_KEY not to be set in the process environment")
+		}
+		// And that the config is copied to the repo
+		if _, err := os.Stat(filepath.Join(repoDir, ".gemini")); err != nil {
+			t.Errorf("Expected .gemini to be copied to the repo: %v", err)
+		}
 	})
 
 	t.Run("read token error", func(t *testing.T) {
@@ -125,13 +125,15 @@ func TestGemini_Setup(t *testing.T) {
 
 // MockCommandExecutor is a mock implementation of CommandExecutor for testing.
 type MockCommandExecutor struct {
-	Command string
-	Args    []string
-	Output  []byte
-	Err     error
+	Workspace Workspace
+	Command   string
+	Args      []string
+	Output    []byte
+	Err       error
 }
 
-func (e *MockCommandExecutor) Run(command string, args ...string) ([]byte, error) {
+func (e *MockCommandExecutor) Run(workspace Workspace, command string, args ...string) ([]byte, error) {
+	e.Workspace = workspace
 	e.Command = command
 	e.Args = args
 	return e.Output, e.Err
@@ -178,6 +180,45 @@ func TestGemini_Run(t *testing.T) {
 		}
 	})
 
+	t.Run("workspace", func(t *testing.T) {
+		// Providers of concurrent agents run in their own workspaces
+		var executors []*MockCommandExecutor
+		for _, dir := range []string{"/workspaces/a", "/workspaces/b"} {
+			mockExecutor := &MockCommandExecutor{}
+			g := &Gemini{Executor: mockExecutor, Workspace: Workspace{Dir: dir}}
+			g.Workspace.Setenv("GEMINI_API_KEY", "key-"+filepath.Base(dir))
+			if _, err := g.Run("test prompt"); err != nil {
+				t.Fatalf("Gemini.Run() failed: %v", err)
+			}
+			executors = append(executors, mockExecutor)
+		}
+		for i, want := range []Workspace{
+			{Dir: "/workspaces/a", Env: map[string]string{"GEMINI_API_KEY": "key-a"}},
+			{Dir: "/workspaces/b", Env: map[string]string{"GEMINI_API_KEY": "key-b"}},
+		} {
+			if got := executors[i].Workspace; !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected workspace %+v, but got %+v", want, got)
+			}
+		}
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		mockExecutor := &MockCommandExecutor{
+			Output: []byte("12345678"),
+			Err:    nil,
+		}
+
+		g := &Gemini{Executor: mockExecutor}
+		if _, err := g.Run("test prompt"); err != nil {
+			t.Fatalf("Gemini.Run() failed: %v", err)
+		}
+
+		// The tokens are estimated at 4 bytes per token, rounded up
+		if usage := g.Usage(); usage.InputTokens != 3 || usage.OutputTokens != 2 {
+			t.Errorf("Expected 3 input and 2 output tokens, but got %+v", usage)
+		}
+	})
+
 	t.Run("error", func(t *testing.T) {
 		// Create a mock executor that returns an error
 		mockExecutor := &MockCommandExecutor{
@@ -17,6 +17,8 @@ package llm
 import (
 	"bytes"
 	"fmt"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
 )
 
 // PostProcessor defines the signature for functions that can post-process the LLM's raw output.
@@ -31,12 +33,33 @@ type Provider interface {
 	AddPostProcessor(p PostProcessor)
 }
 
+// UsageReporter is implemented by the providers that report the tokens their
+// runs used.
+type UsageReporter interface {
+	// Usage returns the tokens used by all the runs so far.
+	Usage() sandbox.Usage
+}
+
+// EstimateTokens estimates the tokens of text for the providers that don't
+// report them, assuming 4 bytes per token.
+func EstimateTokens(text []byte) int64 {
+	return int64(len(text)+3) / 4
+}
+
 func NewLLMProvider(name string) (Provider, error) {
+	return NewLLMProviderIn(name, Workspace{})
+}
+
+// NewLLMProviderIn returns a provider running its agent in workspace rather
+// than in the working directory and environment of the process. Its runs are
+// recorded to sandbox.TranscriptFile if the workspace sets TranscriptEnv.
+func NewLLMProviderIn(name string, workspace Workspace) (Provider, error) {
+	transcript := workspace.Transcript()
 	switch name {
 	case "gemini-cli":
-		return &Gemini{Executor: &RealCommandExecutor{}}, nil
+		return &Gemini{Executor: &RealCommandExecutor{}, Workspace: workspace, Transcript: transcript}, nil
 	case "claude":
-		return &Claude{}, nil
+		return &Claude{Workspace: workspace, Transcript: transcript}, nil
 	default:
 		return nil, fmt.Errorf("unknown provider: %s", name)
 	}
@@ -0,0 +1,105 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+)
+
+// TranscriptEnv enables the transcripts of the providers created by
+// NewLLMProvider when "true".
+const TranscriptEnv = "LLM_TRANSCRIPT"
+
+// Transcript returns the transcript of the runs in the workspace, written to
+// sandbox.TranscriptFile, if it sets TranscriptEnv, and nil otherwise.
+func (w Workspace) Transcript() *Transcript {
+	if v, _ := w.Getenv(TranscriptEnv); v != "true" {
+		return nil
+	}
+	return &Transcript{Path: sandbox.TranscriptFile}
+}
+
+// TranscriptEntry is the record of a run. The hashes tell apart the runs of
+// the same prompt or with the same response across sandboxes.
+type TranscriptEntry struct {
+	Time            time.Time `json:"time"`
+	Provider        string    `json:"provider"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Prompt          string    `json:"prompt"`
+	PromptSHA256    string    `json:"promptSHA256"`
+	// Response is the raw output of the model, before the post-processors
+	Response       string `json:"response"`
+	ResponseSHA256 string `json:"responseSHA256"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Transcript appends the prompt and raw response of each run of the providers
+// to a JSON lines file, to debug validation failures and prompt regressions.
+// A nil Transcript records nothing.
+type Transcript struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Record appends a run started at start. Failing to record it is logged
+// rather than failing the run.
+func (t *Transcript) Record(provider, prompt string, response []byte, start time.Time, runErr error) {
+	if t == nil {
+		return
+	}
+	entry := TranscriptEntry{
+		Time:            start.UTC(),
+		Provider:        provider,
+		DurationSeconds: time.Since(start).Seconds(),
+		Prompt:          prompt,
+		PromptSHA256:    sha256Hex([]byte(prompt)),
+		Response:        string(response),
+		ResponseSHA256:  sha256Hex(response),
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to marshal the transcript entry: %v", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.OpenFile(t.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("failed to open transcript %s: %v", t.Path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("failed to write transcript %s: %v", t.Path, err)
+	}
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
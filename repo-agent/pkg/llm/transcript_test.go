@@ -0,0 +1,93 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+)
+
+func TestTranscript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm-transcript.jsonl")
+	mockExecutor := &MockCommandExecutor{Output: []byte("```yaml\nfoo: bar\n```")}
+	g := &Gemini{Executor: mockExecutor, Transcript: &Transcript{Path: path}}
+	g.AddPostProcessor(StripYAMLMarkers)
+
+	if _, err := g.Run("test prompt"); err != nil {
+		t.Fatalf("Gemini.Run() failed: %v", err)
+	}
+	mockExecutor.Output, mockExecutor.Err = []byte("quota exceeded"), errors.New("exit status 1")
+	if _, err := g.Run("test prompt"); err == nil {
+		t.Fatal("Gemini.Run() should have failed, but it didn't")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open the transcript: %v", err)
+	}
+	defer f.Close()
+	var entries []TranscriptEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry TranscriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid transcript line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 transcript entries, but got %d", len(entries))
+	}
+
+	// The response is recorded before the post-processors
+	first := entries[0]
+	if first.Provider != "gemini-cli" || first.Prompt != "test prompt" || first.Response != "```yaml\nfoo: bar\n```" || first.Error != "" {
+		t.Errorf("Unexpected first entry %+v", first)
+	}
+	if first.Time.IsZero() {
+		t.Error("Expected the first entry to have a time")
+	}
+	// sha256 of "test prompt"
+	if want := "cb2fea287ffb357f914bffe2f58c7583bb74a60f3f96f259866c94b77a843a2d"; first.PromptSHA256 != want {
+		t.Errorf("Expected prompt hash %s, but got %s", want, first.PromptSHA256)
+	}
+	if first.ResponseSHA256 == entries[1].ResponseSHA256 {
+		t.Error("Expected different responses to have different hashes")
+	}
+	if second := entries[1]; second.Response != "quota exceeded" || second.Error != "exit status 1" {
+		t.Errorf("Unexpected failed entry %+v", second)
+	}
+}
+
+func TestWorkspaceTranscript(t *testing.T) {
+	if tr := (Workspace{}).Transcript(); tr != nil {
+		t.Errorf("Expected no transcript by default, but got %+v", tr)
+	}
+	tr := Workspace{Env: map[string]string{TranscriptEnv: "true"}}.Transcript()
+	if tr == nil || tr.Path != sandbox.TranscriptFile {
+		t.Errorf("Expected a transcript to %s, but got %+v", sandbox.TranscriptFile, tr)
+	}
+
+	// A nil transcript records nothing
+	var nilTranscript *Transcript
+	nilTranscript.Record("gemini-cli", "prompt", nil, time.Time{}, nil)
+}
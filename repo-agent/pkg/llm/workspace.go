@@ -0,0 +1,63 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llm
+
+import (
+	"maps"
+	"os"
+	"slices"
+)
+
+// Workspace is where a provider runs its agent. Providers don't change the
+// working directory or the environment of the process, so that a binary can
+// run several agents concurrently, each with its own provider and Workspace.
+type Workspace struct {
+	// Dir is the working directory of the agent, the repository checkout.
+	// The process's if empty.
+	Dir string
+	// Env are the variables set for the agent on top of the environment of
+	// the process.
+	Env map[string]string
+}
+
+// Getenv returns the value of the variable key of the workspace, or of the
+// process if the workspace doesn't set it.
+func (w Workspace) Getenv(key string) (string, bool) {
+	if v, ok := w.Env[key]; ok {
+		return v, true
+	}
+	return os.LookupEnv(key)
+}
+
+// Setenv sets a variable of the workspace, copying its Env so that the
+// workspaces sharing it are left unchanged.
+func (w *Workspace) Setenv(key, value string) {
+	env := maps.Clone(w.Env)
+	if env == nil {
+		env = map[string]string{}
+	}
+	env[key] = value
+	w.Env = env
+}
+
+// Environ returns the environment of the process with the variables of the
+// workspace, for the commands of the agent.
+func (w Workspace) Environ() []string {
+	env := os.Environ()
+	for _, k := range slices.Sorted(maps.Keys(w.Env)) {
+		env = append(env, k+"="+w.Env[k])
+	}
+	return env
+}
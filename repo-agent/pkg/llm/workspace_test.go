@@ -0,0 +1,56 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	t.Setenv("WORKSPACE_TEST_PROCESS", "process")
+
+	shared := map[string]string{"B": "2", "A": "1"}
+	w := Workspace{Env: shared}
+	w.Setenv("C", "3")
+	if _, ok := shared["C"]; ok {
+		t.Error("Setenv() changed the Env shared with other workspaces")
+	}
+
+	if v, ok := w.Getenv("C"); !ok || v != "3" {
+		t.Errorf("Getenv(C) = %q, %v, want 3", v, ok)
+	}
+	if v, ok := w.Getenv("WORKSPACE_TEST_PROCESS"); !ok || v != "process" {
+		t.Errorf("Getenv() of a process variable = %q, %v, want process", v, ok)
+	}
+
+	env := w.Environ()
+	if !slices.Contains(env, "WORKSPACE_TEST_PROCESS=process") {
+		t.Errorf("Environ() = %v, want the process environment", env)
+	}
+	if got, want := env[len(env)-3:], []string{"A=1", "B=2", "C=3"}; !slices.Equal(got, want) {
+		t.Errorf("Environ() ends with %v, want %v", got, want)
+	}
+}
+
+func TestClaudeSetupWorkspace(t *testing.T) {
+	c := &Claude{Workspace: Workspace{Env: map[string]string{"ANTHROPIC_API_KEY": "workspace-key"}}}
+	if err := c.Setup("", ""); err != nil {
+		t.Fatalf("Claude.Setup() failed: %v", err)
+	}
+	if c.apiKey != "workspace-key" {
+		t.Errorf("Expected apiKey 'workspace-key', got %q", c.apiKey)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging sets up the structured logger shared by the repo-agent
+// binaries. Records are written as JSON, or text with LOG_FORMAT=text, at the
+// LOG_LEVEL level, info by default. Credentials are redacted from messages
+// and attributes, e.g. the token of a git remote URL, and the records of a
+// request carry its ID.
+//
+// Setup routes the log package, klog and, through logr, controller-runtime
+// to the logger, so that the existing calls are redacted too.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// RequestIDKey is the attribute of the request ID of a record.
+const RequestIDKey = "request_id"
+
+// Setup makes the logger of component, writing to w, the default one of the
+// log, log/slog and klog packages and returns it.
+func Setup(component string, w io.Writer) *slog.Logger {
+	logger := New(w).With("component", component)
+	slog.SetDefault(logger)
+	klog.SetSlogLogger(logger)
+	return logger
+}
+
+// New returns a logger writing to w in the LOG_FORMAT format at the
+// LOG_LEVEL level.
+func New(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: levelFromEnv(), ReplaceAttr: redactAttr}
+	var h slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&handler{Handler: h})
+}
+
+// levelFromEnv returns the level of LOG_LEVEL, e.g. debug or warn, info if
+// unset or invalid.
+func levelFromEnv() slog.Level {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid LOG_LEVEL %q, using info\n", v)
+			return slog.LevelInfo
+		}
+	}
+	return level
+}
+
+// redactAttr redacts the credentials of the string, error and Stringer
+// attributes, including the message.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(Redact(v.Error()))
+		case fmt.Stringer:
+			a.Value = slog.StringValue(Redact(v.String()))
+		case []byte:
+			a.Value = slog.StringValue(Redact(string(v)))
+		}
+	}
+	return a
+}
+
+// handler adds the request ID of the context to the records.
+type handler struct {
+	slog.Handler
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{Handler: h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx whose records carry the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, empty if there is none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,45 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import "regexp"
+
+// Redacted replaces the credentials removed by Redact.
+const Redacted = "REDACTED"
+
+var redactions = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// The password of URLs, e.g. https://user:<pat>@github.com/owner/repo
+	{regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@'"]*):[^/\s@'"]+@`), "${1}:" + Redacted + "@"},
+	// GitHub personal access, OAuth, app and refresh tokens
+	{regexp.MustCompile(`\b(ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{20,}\b`), "${1}_" + Redacted},
+	{regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{20,}`), "github_pat_" + Redacted},
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`), "${1} " + Redacted},
+	// Credentials in query strings, e.g. OAuth codes and signed URLs
+	{regexp.MustCompile(`(?i)([?&](?:access_token|token|client_secret|code|state|password|sig|signature|x-goog-signature|x-goog-credential)=)[^&#\s'"]+`), "${1}" + Redacted},
+}
+
+// Redact returns s with the credentials it may contain replaced by Redacted:
+// the passwords of URLs, GitHub tokens, bearer and basic authorizations and
+// the credentials of query strings.
+func Redact(s string) string {
+	for _, r := range redactions {
+		s = r.re.ReplaceAllString(s, r.repl)
+	}
+	return s
+}
@@ -0,0 +1,249 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/gcp"
+)
+
+// ArtifactStore stores run artifacts in an object storage bucket.
+type ArtifactStore interface {
+	// Put uploads body to the object key.
+	Put(ctx context.Context, key string, body []byte) error
+	// URL returns the URL of the object key.
+	URL(key string) string
+}
+
+// NewArtifactStore returns the store for a bucket URL of the form
+// gs://bucket/prefix or s3://bucket/prefix, and the prefix.
+//
+// GCS credentials are taken from the metadata server, e.g. through workload
+// identity. S3 credentials are read from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN env vars, the region from
+// AWS_REGION and an S3 compatible endpoint from AWS_ENDPOINT_URL_S3.
+func NewArtifactStore(bucketURL string) (ArtifactStore, string, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid bucket URL %q: %w", bucketURL, err)
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("invalid bucket URL %q: missing bucket", bucketURL)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "gs":
+		return &gcsStore{bucket: u.Host, client: http.DefaultClient, tokens: &gcp.TokenSource{}}, prefix, nil
+	case "s3":
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		return &s3Store{
+			bucket:       u.Host,
+			endpoint:     strings.TrimSuffix(endpoint, "/"),
+			region:       region,
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			client:       http.DefaultClient,
+		}, prefix, nil
+	default:
+		return nil, "", fmt.Errorf("invalid bucket URL %q: scheme must be gs or s3", bucketURL)
+	}
+}
+
+// UploadArtifacts uploads the regular files directly inside dir to prefix in
+// store and returns the URL of the prefix. Subdirectories, such as the
+// cloned repo, are skipped.
+func UploadArtifacts(ctx context.Context, store ArtifactStore, dir, prefix string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("failed to read artifact: %w", err)
+		}
+		if err := store.Put(ctx, path.Join(prefix, entry.Name()), b); err != nil {
+			return "", fmt.Errorf("failed to upload %s: %w", entry.Name(), err)
+		}
+	}
+	return store.URL(prefix + "/"), nil
+}
+
+type gcsStore struct {
+	bucket string
+	client *http.Client
+	tokens *gcp.TokenSource
+}
+
+func (s *gcsStore) URL(key string) string {
+	return fmt.Sprintf("gs://%s/%s", s.bucket, key)
+}
+
+func (s *gcsStore) Put(ctx context.Context, key string, body []byte) error {
+	token, err := s.tokens.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		url.PathEscape(s.bucket), url.QueryEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return do(s.client, req)
+}
+
+type s3Store struct {
+	bucket       string
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func (s *s3Store) URL(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key)
+}
+
+// Put uploads the object with a path style request signed with AWS
+// signature version 4.
+func (s *s3Store) Put(ctx context.Context, key string, body []byte) error {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint %q: %w", s.endpoint, err)
+	}
+	u.Path = "/" + s.bucket + "/" + key
+	u.RawPath = awsURIEscape(u.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, body, time.Now().UTC())
+	return do(s.client, req)
+}
+
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEscape escapes a path as required by AWS signature version 4: every
+// byte except unreserved characters and '/' is percent encoded.
+func awsURIEscape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, msg)
+	}
+	return nil
+}
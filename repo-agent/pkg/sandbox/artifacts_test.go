@@ -0,0 +1,97 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewArtifactStore(t *testing.T) {
+	tests := []struct {
+		bucketURL  string
+		wantURL    string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{bucketURL: "gs://artifacts/runs", wantURL: "gs://artifacts/runs/x", wantPrefix: "runs"},
+		{bucketURL: "s3://artifacts", wantURL: "s3://artifacts/runs/x", wantPrefix: ""},
+		{bucketURL: "https://artifacts", wantErr: true},
+		{bucketURL: "gs:///runs", wantErr: true},
+	}
+	for _, tt := range tests {
+		store, prefix, err := NewArtifactStore(tt.bucketURL)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("NewArtifactStore(%q) error = %v, wantErr %v", tt.bucketURL, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if prefix != tt.wantPrefix {
+			t.Errorf("NewArtifactStore(%q) prefix = %q, want %q", tt.bucketURL, prefix, tt.wantPrefix)
+		}
+		if got := store.URL("runs/x"); got != tt.wantURL {
+			t.Errorf("URL() = %q, want %q", got, tt.wantURL)
+		}
+	}
+}
+
+func TestUploadArtifactsS3(t *testing.T) {
+	uploaded := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") {
+			t.Errorf("Authorization = %q, want a SigV4 signature", auth)
+		}
+		b, _ := io.ReadAll(r.Body)
+		uploaded[r.URL.Path] = string(b)
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	store, prefix, err := NewArtifactStore("s3://artifacts/runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "agent-output.txt"), []byte("done"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "repo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := UploadArtifacts(context.Background(), store, dir, prefix+"/default/sandbox")
+	if err != nil {
+		t.Fatalf("UploadArtifacts() error = %v", err)
+	}
+	if want := "s3://artifacts/runs/default/sandbox/"; got != want {
+		t.Errorf("UploadArtifacts() = %q, want %q", got, want)
+	}
+	if len(uploaded) != 1 || uploaded["/artifacts/runs/default/sandbox/agent-output.txt"] != "done" {
+		t.Errorf("uploaded = %v, want only agent-output.txt", uploaded)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// DefaultCodeServerPort is the port code-server listens on unless
+	// configured otherwise.
+	DefaultCodeServerPort = 13337
+
+	// CodeServerAuthNone disables authentication.
+	CodeServerAuthNone = "none"
+	// CodeServerAuthPassword requires the password in the PASSWORD env var.
+	CodeServerAuthPassword = "password"
+)
+
+// CodeServerConfig configures the code-server started in a sandbox.
+type CodeServerConfig struct {
+	Enabled bool
+	Port    int
+	Auth    string
+}
+
+// CodeServerConfigFromEnv reads the code-server config from the environment.
+// code-server is only started when CODE_SERVER_ENABLED is true.
+func CodeServerConfigFromEnv() (CodeServerConfig, error) {
+	cfg := CodeServerConfig{
+		Enabled: os.Getenv("CODE_SERVER_ENABLED") == "true",
+		Port:    DefaultCodeServerPort,
+		Auth:    CodeServerAuthNone,
+	}
+	if v := os.Getenv("CODE_SERVER_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return cfg, fmt.Errorf("invalid CODE_SERVER_PORT %q", v)
+		}
+		cfg.Port = port
+	}
+	if v := os.Getenv("CODE_SERVER_AUTH"); v != "" {
+		cfg.Auth = v
+	}
+	switch cfg.Auth {
+	case CodeServerAuthNone:
+	case CodeServerAuthPassword:
+		if cfg.Enabled && os.Getenv("PASSWORD") == "" {
+			return cfg, fmt.Errorf("CODE_SERVER_AUTH is %s but PASSWORD is not set", cfg.Auth)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid CODE_SERVER_AUTH %q", cfg.Auth)
+	}
+	return cfg, nil
+}
+
+// CodeServer is a code-server process, or a placeholder when code-server is
+// disabled.
+type CodeServer struct {
+	cmd *exec.Cmd
+}
+
+// StartCodeServer starts code-server in the background so that users can
+// open the sandbox in a browser.
+func StartCodeServer(cfg CodeServerConfig) (*CodeServer, error) {
+	if !cfg.Enabled {
+		log.Println("code-server is disabled")
+		return &CodeServer{}, nil
+	}
+	log.Println("starting code-server")
+	codeServerPath := "/usr/bin/code-server"
+	args := []string{"--auth=" + cfg.Auth, fmt.Sprintf("--bind-addr=0.0.0.0:%d", cfg.Port)}
+	cmd := exec.Command(codeServerPath, args...)
+	cmd.Stdout = os.Stdout
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Running code-server in subprocess %d\n", cmd.Process.Pid)
+	return &CodeServer{cmd: cmd}, nil
+}
+
+// Wait blocks until code-server exits. When code-server is disabled it
+// blocks until the sandbox is asked to terminate, so that the container
+// does not exit and get restarted.
+func (c *CodeServer) Wait() {
+	if c.cmd == nil {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		log.Printf("Received %v, exiting", <-sig)
+		return
+	}
+	if err := c.cmd.Wait(); err != nil {
+		log.Printf("Code Server exited with error: %v", err)
+	} else {
+		log.Println("Code Server exited with no error")
+	}
+}
+
+// Stop kills code-server if it is running.
+func (c *CodeServer) Stop() {
+	if c.cmd != nil && c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import "testing"
+
+func TestCodeServerConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    CodeServerConfig
+		wantErr bool
+	}{
+		{
+			name: "disabled by default",
+			want: CodeServerConfig{Port: DefaultCodeServerPort, Auth: CodeServerAuthNone},
+		},
+		{
+			name: "custom port",
+			env:  map[string]string{"CODE_SERVER_ENABLED": "true", "CODE_SERVER_PORT": "8080"},
+			want: CodeServerConfig{Enabled: true, Port: 8080, Auth: CodeServerAuthNone},
+		},
+		{
+			name: "password auth",
+			env:  map[string]string{"CODE_SERVER_ENABLED": "true", "CODE_SERVER_AUTH": "password", "PASSWORD": "secret"},
+			want: CodeServerConfig{Enabled: true, Port: DefaultCodeServerPort, Auth: CodeServerAuthPassword},
+		},
+		{
+			name:    "password auth without password",
+			env:     map[string]string{"CODE_SERVER_ENABLED": "true", "CODE_SERVER_AUTH": "password"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			env:     map[string]string{"CODE_SERVER_PORT": "http"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid auth",
+			env:     map[string]string{"CODE_SERVER_AUTH": "github"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"CODE_SERVER_ENABLED", "CODE_SERVER_PORT", "CODE_SERVER_AUTH", "PASSWORD"} {
+				t.Setenv(k, tt.env[k])
+			}
+			got, err := CodeServerConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CodeServerConfigFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("CodeServerConfigFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
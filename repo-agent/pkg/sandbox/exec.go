@@ -0,0 +1,41 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+)
+
+// RunCommand logs and runs a command, returning its combined output.
+func RunCommand(name string, args ...string) ([]byte, error) {
+	log.Printf("Running command: %s %v", name, args)
+	return RunCommandQuiet(name, args...)
+}
+
+// RunCommandQuiet runs a command without logging it, for commands whose
+// arguments contain credentials.
+func RunCommandQuiet(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// The arguments and output may hold credentials, e.g. a git remote URL
+		return output, fmt.Errorf("command %s %s failed with output %s: %w", name, logging.Redact(fmt.Sprint(args)), logging.Redact(string(output)), err)
+	}
+	return output, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+)
+
+// Phases a sandbox run goes through, in order. A run ends in either
+// PhaseCompleted or PhaseFailed.
+const (
+	PhaseCloning    = "Cloning"
+	PhaseGenerating = "Generating"
+	PhaseTesting    = "Testing"
+	PhasePushing    = "Pushing"
+	PhaseCompleted  = "Completed"
+	PhaseFailed     = "Failed"
+)
+
+// WritePhase records the current phase of the run in path.
+func WritePhase(path, phase string) error {
+	if err := os.WriteFile(path, []byte(phase), 0644); err != nil {
+		return fmt.Errorf("failed to write phase %s: %w", phase, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sandbox contains code shared by the sandbox entrypoints and their
+// sidecars.
+package sandbox
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Test statuses reported in a Result.
+const (
+	TestStatusPassed  = "Passed"
+	TestStatusFailed  = "Failed"
+	TestStatusSkipped = "Skipped"
+)
+
+// Result is the manifest an issue sandbox writes once the agent is done. The
+// sidecar publishes it to the IssueSandbox status.
+type Result struct {
+	Branch            string   `yaml:"branch"`
+	BaseCommit        string   `yaml:"baseCommit"`
+	Commits           []string `yaml:"commits"`
+	FilesChanged      []string `yaml:"filesChanged"`
+	Summary           string   `yaml:"summary"`
+	TestStatus        string   `yaml:"testStatus"`
+	PullRequestURL    string   `yaml:"pullRequestURL,omitempty"`
+	FollowUpQuestions []string `yaml:"followUpQuestions,omitempty"`
+}
+
+// WriteResult writes the result manifest to path.
+func WriteResult(path string, result *Result) error {
+	b, err := yaml.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ParseResult parses a result manifest.
+func ParseResult(b []byte) (*Result, error) {
+	result := &Result{}
+	if err := yaml.Unmarshal(b, result); err != nil {
+		return nil, fmt.Errorf("failed to parse result: %w", err)
+	}
+	return result, nil
+}
+
+// StatusFields returns the result as an unstructured object suitable for
+// setting on a custom resource status.
+func (r *Result) StatusFields() map[string]interface{} {
+	return map[string]interface{}{
+		"branch":            r.Branch,
+		"baseCommit":        r.BaseCommit,
+		"commits":           stringSlice(r.Commits),
+		"filesChanged":      stringSlice(r.FilesChanged),
+		"summary":           r.Summary,
+		"testStatus":        r.TestStatus,
+		"pullRequestURL":    r.PullRequestURL,
+		"followUpQuestions": stringSlice(r.FollowUpQuestions),
+	}
+}
+
+func stringSlice(s []string) []interface{} {
+	out := make([]interface{}, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
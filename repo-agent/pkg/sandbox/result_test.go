@@ -0,0 +1,58 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestResultRoundTrip(t *testing.T) {
+	want := &Result{
+		Branch:            "issue-1-fix-abcd",
+		BaseCommit:        "1111111",
+		Commits:           []string{"2222222"},
+		FilesChanged:      []string{"main.go", "main_test.go"},
+		Summary:           "Fixed the nil pointer.",
+		TestStatus:        TestStatusPassed,
+		FollowUpQuestions: []string{"Should the flag be renamed?"},
+	}
+
+	path := filepath.Join(t.TempDir(), "result.yaml")
+	if err := WriteResult(path, want); err != nil {
+		t.Fatalf("WriteResult() error = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseResult(b)
+	if err != nil {
+		t.Fatalf("ParseResult() error = %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	// The status fields must be settable on an unstructured object
+	obj := map[string]interface{}{}
+	if err := unstructured.SetNestedField(obj, got.StatusFields(), "status", "result"); err != nil {
+		t.Errorf("SetNestedField() error = %v", err)
+	}
+}
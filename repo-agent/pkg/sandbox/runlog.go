@@ -0,0 +1,66 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// RunLogContainer is the container of the sandbox pods whose logs are the
+// run log, so that it is read through pods/log rather than by exec in the
+// sandbox.
+const RunLogContainer = "run-log"
+
+// FollowFile copies path to w, then the content appended to it, until ctx is
+// done. A missing file is waited for, and a truncated one is copied again
+// from its start.
+func FollowFile(ctx context.Context, path string, w io.Writer, poll time.Duration) error {
+	var offset int64
+	for {
+		if info, err := os.Stat(path); err == nil {
+			if info.Size() < offset {
+				offset = 0
+			}
+			if info.Size() > offset {
+				n, err := copyFrom(path, offset, w)
+				offset += n
+				if err != nil {
+					return err
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(poll):
+		}
+	}
+}
+
+func copyFrom(path string, offset int64, w io.Writer) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		// Removed since it was stat'ed, retried at the next poll
+		return 0, nil
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, f)
+}
@@ -0,0 +1,73 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Usage is the LLM tokens the runs of a sandbox used. The sandbox adds the
+// tokens of each run to UsageFile, which lives in the workspace so that it
+// survives restarts, and the sidecar publishes it for the controller's cost
+// report.
+type Usage struct {
+	InputTokens  int64 `yaml:"inputTokens" json:"inputTokens"`
+	OutputTokens int64 `yaml:"outputTokens" json:"outputTokens"`
+}
+
+// UsageAnnotation is the annotation of the review sandboxes their sidecar
+// publishes the usage to as JSON. The issue sidecars publish it to
+// status.usage.
+const UsageAnnotation = "agentUsage"
+
+// AddUsage adds usage to the usage recorded in path.
+func AddUsage(path string, usage Usage) error {
+	total := &Usage{}
+	if b, err := os.ReadFile(path); err == nil {
+		if total, err = ParseUsage(b); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	b, err := yaml.Marshal(total)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+	return WriteArtifact(path, b)
+}
+
+// ParseUsage parses a usage file.
+func ParseUsage(b []byte) (*Usage, error) {
+	usage := &Usage{}
+	if err := yaml.Unmarshal(b, usage); err != nil {
+		return nil, fmt.Errorf("failed to parse usage: %w", err)
+	}
+	return usage, nil
+}
+
+// StatusFields returns the usage as an unstructured object suitable for
+// setting on a custom resource status.
+func (u *Usage) StatusFields() map[string]interface{} {
+	return map[string]interface{}{
+		"inputTokens":  u.InputTokens,
+		"outputTokens": u.OutputTokens,
+	}
+}
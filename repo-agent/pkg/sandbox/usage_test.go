@@ -0,0 +1,56 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAddUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.yaml")
+	for _, u := range []Usage{{InputTokens: 100, OutputTokens: 10}, {InputTokens: 50, OutputTokens: 5}} {
+		if err := AddUsage(path, u); err != nil {
+			t.Fatalf("AddUsage() error = %v", err)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseUsage(b)
+	if err != nil {
+		t.Fatalf("ParseUsage() error = %v", err)
+	}
+	if want := (Usage{InputTokens: 150, OutputTokens: 15}); *got != want {
+		t.Errorf("usage = %+v, want %+v", *got, want)
+	}
+
+	// The status fields must be settable on an unstructured object
+	obj := map[string]interface{}{}
+	if err := unstructured.SetNestedField(obj, got.StatusFields(), "status", "usage"); err != nil {
+		t.Errorf("SetNestedField() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("inputTokens: [}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUsage(path, Usage{InputTokens: 1}); err == nil {
+		t.Error("AddUsage() to a corrupted file succeeded, want an error")
+	}
+}
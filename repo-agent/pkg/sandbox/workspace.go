@@ -0,0 +1,91 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// WorkspacesDir is the volume shared by a sandbox and its sidecar. The repo
+// is cloned into a subdirectory and the run artifacts are written next to it.
+const WorkspacesDir = "/workspaces"
+
+// Artifacts written by the sandboxes and published by the sidecars.
+var (
+	PromptFile         = filepath.Join(WorkspacesDir, "agent-prompt.txt")
+	OutputFile         = filepath.Join(WorkspacesDir, "agent-output.txt")
+	QuestionsFile      = filepath.Join(WorkspacesDir, "agent-questions.txt")
+	PullRequestURLFile = filepath.Join(WorkspacesDir, "pull-request-url.txt")
+	ResultFile         = filepath.Join(WorkspacesDir, "result.yaml")
+	RunLogFile         = filepath.Join(WorkspacesDir, "run.log")
+	ErrorFile          = filepath.Join(WorkspacesDir, "error.txt")
+	PhaseFile          = filepath.Join(WorkspacesDir, "phase.txt")
+	UsageFile          = filepath.Join(WorkspacesDir, "usage.yaml")
+	TranscriptFile     = filepath.Join(WorkspacesDir, "llm-transcript.jsonl")
+)
+
+// geminiConfigDir is the gemini-cli config directory inside the repo.
+const geminiConfigDir = ".gemini"
+
+// WriteArtifact writes an artifact file.
+func WriteArtifact(path string, content []byte) error {
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// SetupGeminiConfig copies the .gemini directory from workspacesDir into the
+// repo in repoDir. An existing .gemini directory in the repo is moved aside to
+// .gemini.bak and restored by RestoreGeminiConfig.
+func SetupGeminiConfig(workspacesDir, repoDir string) error {
+	src := filepath.Join(workspacesDir, geminiConfigDir)
+	if _, err := os.Stat(src); err != nil {
+		log.Printf(".gemini directory does not exist in %s", workspacesDir)
+		return nil
+	}
+	log.Printf(".gemini directory exists in %s, copying to repo directory", workspacesDir)
+	dst := filepath.Join(repoDir, geminiConfigDir)
+	if _, err := os.Stat(dst); err == nil {
+		log.Println(".gemini directory exists in repo directory, moving to .gemini.bak")
+		if err := os.Rename(dst, dst+".bak"); err != nil {
+			return fmt.Errorf("failed to move .gemini to .gemini.bak: %w", err)
+		}
+	}
+	if _, err := RunCommand("cp", "-R", src, dst); err != nil {
+		return fmt.Errorf("failed to copy .gemini directory: %w", err)
+	}
+	return nil
+}
+
+// RestoreGeminiConfig moves the own .gemini directory of the repo in repoDir
+// back in place if SetupGeminiConfig moved it aside.
+func RestoreGeminiConfig(repoDir string) error {
+	dst := filepath.Join(repoDir, geminiConfigDir)
+	if _, err := os.Stat(dst + ".bak"); err != nil {
+		return nil
+	}
+	log.Println("moving .gemini.bak -> .gemini")
+	if err := os.RemoveAll(dst); err != nil {
+		log.Printf("failed to remove .gemini directory: %v", err)
+	}
+	if err := os.Rename(dst+".bak", dst); err != nil {
+		return fmt.Errorf("failed to move .gemini.bak to .gemini: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secretmanager reads credentials from Google Secret Manager, so that
+// they are kept in a single place rather than copied to the secrets of each
+// tenant namespace. Secrets are accessed as the pod's service account, e.g.
+// through workload identity, which needs the Secret Manager Secret Accessor
+// role on them.
+package secretmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/gcp"
+)
+
+const secretManagerEndpoint = "https://secretmanager.googleapis.com"
+
+// secretName matches the names of secrets, optionally with a version.
+var secretName = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+(/versions/[^/]+)?$`)
+
+// Accessor reads secrets.
+type Accessor interface {
+	// Access returns the payload of the secret version name,
+	// projects/<project>/secrets/<secret>/versions/<version>, or of the
+	// latest version if name has none.
+	Access(ctx context.Context, name string) ([]byte, error)
+}
+
+// Client accesses secrets through the Secret Manager REST API.
+type Client struct {
+	endpoint string
+	client   *http.Client
+	tokens   *gcp.TokenSource
+}
+
+var _ Accessor = &Client{}
+
+// New returns a client authenticated as the pod's service account.
+func New() *Client {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &Client{
+		endpoint: secretManagerEndpoint,
+		client:   client,
+		tokens:   &gcp.TokenSource{Client: client},
+	}
+}
+
+// Access returns the payload of a secret version, checking its checksum.
+func (c *Client) Access(ctx context.Context, name string) ([]byte, error) {
+	if !secretName.MatchString(name) {
+		return nil, fmt.Errorf("invalid secret name %q, want projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token, err := c.tokens.AccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s:access", c.endpoint, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("secret manager access %s: %s: %s", name, resp.Status, msg)
+	}
+	var version struct {
+		Payload struct {
+			Data       []byte `json:"data"`
+			DataCrc32c string `json:"dataCrc32c"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return nil, fmt.Errorf("failed to decode secret %s: %w", name, err)
+	}
+	if sum := version.Payload.DataCrc32c; sum != "" {
+		want, err := strconv.ParseUint(sum, 10, 32)
+		if err != nil || crc32.Checksum(version.Payload.Data, crc32.MakeTable(crc32.Castagnoli)) != uint32(want) {
+			return nil, fmt.Errorf("secret %s is corrupted, its checksum doesn't match", name)
+		}
+	}
+	return version.Payload.Data, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretmanager
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestClient returns a client of an API serving the secret
+// projects/p/secrets/pat, checking the requests are authenticated.
+func newTestClient(t *testing.T, crc32c string) *Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/projects/p/secrets/pat/versions/latest:access", "/v1/projects/p/secrets/pat/versions/2:access":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"name":    "projects/p/secrets/pat/versions/2",
+				"payload": map[string]interface{}{"data": []byte("ghp_secret"), "dataCrc32c": crc32c},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c := New()
+	c.endpoint = srv.URL
+	c.tokens.URL = srv.URL
+	return c
+}
+
+func TestAccess(t *testing.T) {
+	ctx := context.Background()
+	sum := strconv.FormatUint(uint64(crc32.Checksum([]byte("ghp_secret"), crc32.MakeTable(crc32.Castagnoli))), 10)
+	c := newTestClient(t, sum)
+
+	for _, name := range []string{"projects/p/secrets/pat", "projects/p/secrets/pat/versions/2"} {
+		got, err := c.Access(ctx, name)
+		if err != nil {
+			t.Fatalf("Access(%q) failed: %v", name, err)
+		}
+		if string(got) != "ghp_secret" {
+			t.Errorf("Access(%q) = %q, want %q", name, got, "ghp_secret")
+		}
+	}
+
+	if _, err := c.Access(ctx, "projects/p/secrets/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Access() of a missing secret = %v, want a 404 error", err)
+	}
+	if _, err := c.Access(ctx, "github-pat"); err == nil {
+		t.Error("Access() of an invalid name succeeded, want an error")
+	}
+}
+
+func TestAccessCorrupted(t *testing.T) {
+	c := newTestClient(t, "1")
+	if _, err := c.Access(context.Background(), "projects/p/secrets/pat"); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Access() of a corrupted secret = %v, want a checksum error", err)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// flushInterval is how often the queued spans are exported
+	flushInterval = 5 * time.Second
+	// maxQueuedSpans are dropped beyond, e.g. while the collector is down
+	maxQueuedSpans = 2048
+)
+
+// FromEnv returns the tracer of service, exporting its spans over OTLP/HTTP
+// to OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or OTEL_EXPORTER_OTLP_ENDPOINT with
+// the /v1/traces path, as other OpenTelemetry SDKs do. OTEL_SERVICE_NAME
+// overrides service. Spans are only propagated when neither is set.
+func FromEnv(service string) *Tracer {
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	t := &Tracer{service: service}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint != "" {
+		t.exporter = newExporter(endpoint, service)
+		go t.exporter.run()
+	}
+	return t
+}
+
+// Endpoint returns the URL spans are exported to, empty if they aren't.
+func (t *Tracer) Endpoint() string {
+	if t == nil || t.exporter == nil {
+		return ""
+	}
+	return t.exporter.endpoint
+}
+
+// Flush exports the spans ended so far, e.g. before exiting.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	return t.exporter.flush(ctx)
+}
+
+// exporter exports spans in batches in the OTLP/HTTP JSON encoding.
+type exporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func newExporter(endpoint, service string) *exporter {
+	return &exporter{endpoint: endpoint, service: service, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (e *exporter) add(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.spans) < maxQueuedSpans {
+		e.spans = append(e.spans, s)
+	}
+}
+
+func (e *exporter) run() {
+	for range time.Tick(flushInterval) {
+		if err := e.flush(context.Background()); err != nil {
+			log.Printf("failed to export spans: %v", err)
+		}
+	}
+}
+
+func (e *exporter) flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("otlp export: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding, with hex encoded IDs and nanosecond timestamps as
+// strings
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	// Code is 1 for ok and 2 for errors
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *exporter) encode(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		keys := make([]string, 0, len(s.attrs))
+		for key := range s.attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: s.attrs[key]}})
+		}
+		if s.err != nil {
+			span.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: e.service}}}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "repo-agent"}, Spans: encoded}},
+	}}}
+}
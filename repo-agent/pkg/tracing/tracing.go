@@ -0,0 +1,229 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing traces a review end to end: the trace started when the
+// repowatch controller creates a sandbox is passed to the sandbox as a W3C
+// traceparent, continued by its sidecar, and by the review API when the
+// review is posted. Spans are exported to an OTLP/HTTP collector when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, and only propagated otherwise.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span and its trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether sc has a trace and span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the hex encoded trace ID, e.g. to find the trace of
+// a log line.
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Traceparent returns the W3C traceparent header of sc, empty if it is
+// invalid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceparent parses a W3C traceparent header.
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, fmt.Errorf("invalid trace id in traceparent %q", traceparent)
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, fmt.Errorf("invalid span id in traceparent %q", traceparent)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	return sc, nil
+}
+
+// Span kinds, as numbered by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Span is an operation of a trace. A nil *Span is a no-op.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     int
+	sc       SpanContext
+	parentID [8]byte
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]string
+	end   time.Time
+	err   error
+}
+
+// SpanContext returns the IDs of s, those of a nil span are invalid.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute records an attribute of s.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// End ends s, failed if err is not nil, and queues it for export.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+	if s.tracer != nil && s.tracer.exporter != nil {
+		s.tracer.exporter.add(s)
+	}
+}
+
+// Tracer starts the spans of a service. A nil *Tracer starts spans that are
+// propagated but not exported.
+type Tracer struct {
+	service  string
+	exporter *exporter
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of ctx in which s is the current span.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the current span of ctx, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithTraceparent returns a copy of ctx whose spans are children of
+// the remote span traceparent, e.g. passed to a sandbox. ctx is returned as
+// is if traceparent is empty or invalid.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start starts a span named name, a child of the current span of ctx or of
+// its remote parent, and returns it with a copy of ctx in which it is the
+// current span. It starts a new trace if ctx has neither.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: map[string]string{}}
+	parent := SpanFromContext(ctx).SpanContext()
+	if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && !parent.IsValid() {
+		parent = remote
+	}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.parentID = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+	return ContextWithSpan(ctx, s), s
+}
+
+// Transport traces the requests of base, http.DefaultTransport if nil, as
+// children of the current span of their context, and propagates the trace in
+// their traceparent header. Requests without a current span are sent as is.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent := SpanFromContext(req.Context())
+	if parent == nil {
+		return t.base.RoundTrip(req)
+	}
+	ctx, span := parent.tracer.Start(req.Context(), "HTTP "+req.Method, KindClient)
+	// The query is left out, it may hold credentials
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.SpanContext().Traceparent())
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.End(fmt.Errorf("%s", resp.Status))
+	} else {
+		span.End(nil)
+	}
+	return resp, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.Traceparent(); got != traceparent {
+		t.Errorf("Traceparent() = %q, want %q", got, traceparent)
+	}
+	if got := sc.TraceIDString(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceIDString() = %q", got)
+	}
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("ParseTraceparent(%q) = nil, want an error", invalid)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	var tracer *Tracer
+	ctx, root := tracer.Start(context.Background(), "root", KindInternal)
+	_, child := tracer.Start(ctx, "child", KindInternal)
+	if child.sc.TraceID != root.sc.TraceID || child.parentID != root.sc.SpanID {
+		t.Errorf("child of %v = %v with parent %x, want the same trace", root.sc, child.sc, child.parentID)
+	}
+
+	remote := ContextWithTraceparent(context.Background(), root.SpanContext().Traceparent())
+	_, continued := tracer.Start(remote, "continued", KindServer)
+	if continued.sc.TraceID != root.sc.TraceID || continued.parentID != root.sc.SpanID {
+		t.Errorf("span continuing %v = %v, want the same trace", root.sc, continued.sc)
+	}
+
+	_, other := tracer.Start(ContextWithTraceparent(context.Background(), "invalid"), "other", KindInternal)
+	if !other.SpanContext().IsValid() || other.sc.TraceID == root.sc.TraceID {
+		t.Errorf("span with an invalid parent = %v, want a new trace", other.sc)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var received otlpRequest
+	var headers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		headers = append(headers, r.Header.Get("traceparent"))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL+"/")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+
+	tracer := FromEnv("repowatch")
+	if got, want := tracer.Endpoint(), srv.URL+"/v1/traces"; got != want {
+		t.Errorf("Endpoint() = %q, want %q", got, want)
+	}
+	ctx, span := tracer.Start(context.Background(), "reconcile", KindInternal)
+	span.SetAttribute("repowatch", "default/agent")
+	client := &http.Client{Transport: Transport(nil)}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/repos?access_token=secret", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	span.End(errors.New("failed"))
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 1 {
+		t.Fatalf("got %d requests, want 1", len(headers))
+	}
+	sc, err := ParseTraceparent(headers[0])
+	if err != nil || sc.TraceID != span.sc.TraceID {
+		t.Errorf("traceparent = %q, want one of trace %s", headers[0], span.sc.TraceIDString())
+	}
+	if len(received.ResourceSpans) != 1 || received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "repowatch" {
+		t.Fatalf("exported %+v, want the spans of repowatch", received)
+	}
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	httpSpan, reconcile := spans[0], spans[1]
+	if httpSpan.Kind != KindClient || httpSpan.ParentSpanID != reconcile.SpanID || httpSpan.Status.Code != 2 {
+		t.Errorf("http span = %+v, want a failed client span of %s", httpSpan, reconcile.SpanID)
+	}
+	for _, attr := range httpSpan.Attributes {
+		if attr.Key == "url.path" && attr.Value.StringValue != "/repos" {
+			t.Errorf("url.path = %q, want the path without the query", attr.Value.StringValue)
+		}
+	}
+	if reconcile.Status.Message != "failed" || reconcile.TraceID != span.sc.TraceIDString() {
+		t.Errorf("reconcile span = %+v", reconcile)
+	}
+}
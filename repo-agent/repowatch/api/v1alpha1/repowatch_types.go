@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -45,10 +46,36 @@ type LLMConfig struct {
 	// request or issue.
 	Prompt string `json:"prompt,omitempty"`
 
+	// PromptTemplateRef is a reference to a prompt template managed in the
+	// namespace of the RepoWatch. When set, it is used instead of Prompt.
+	// +kubebuilder:validation:Optional
+	PromptTemplateRef *PromptTemplateRef `json:"promptTemplateRef,omitempty"`
+
 	// ConfigdirRef is a reference to a ConfigDir resource that contains
 	// additional configuration for the LLM agent, such as tool schemas and
 	// model configurations.
 	ConfigdirRef string `json:"configdirRef,omitempty"`
+
+	// Transcript records the prompt and raw response of each run of the
+	// model to the llm-transcript.jsonl artifact of the sandboxes, to debug
+	// validation failures and prompt regressions. Prompts may quote private
+	// code, so it is off by default.
+	// +kubebuilder:validation:Optional
+	Transcript bool `json:"transcript,omitempty"`
+}
+
+// PromptTemplateRef references a version of a prompt template. The versions
+// of the template <name> are stored in the ConfigMap prompt-template-<name>,
+// under the keys v1, v2, and so on.
+type PromptTemplateRef struct {
+	// Name of the prompt template.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Version of the prompt template, the latest one if not set.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	Version int `json:"version,omitempty"`
 }
 
 type PRReviewSpec struct {
@@ -65,6 +92,65 @@ type PRReviewSpec struct {
 	// PullRequests to filter for this handler
 	// +kubebuilder:validation:Optional
 	PullRequests []int `json:"pullRequests,omitempty"`
+
+	// CodeServer configures the code-server exposed by the review sandboxes.
+	// +kubebuilder:validation:Optional
+	CodeServer CodeServerSpec `json:"codeServer,omitempty"`
+
+	// Resources of the review sandbox container, e.g. cpu, memory and
+	// ephemeral-storage requests and limits.
+	// +kubebuilder:validation:Optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// PriorityClassName of the review sandbox pods, e.g. repo-agent-low so
+	// that they are preempted first and never preempt other pods. The
+	// cluster default if empty.
+	// +kubebuilder:validation:Optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// InProgress tells the author of a PR that its review is in progress
+	// while the sandbox runs: reaction adds an eyes reaction to the PR,
+	// removed when the draft is ready, the review fails or the PR is closed,
+	// and comment posts a short comment, edited when it is.
+	// +kubebuilder:validation:Enum=none;reaction;comment
+	// +kubebuilder:default=none
+	// +kubebuilder:validation:Optional
+	InProgress string `json:"inProgress,omitempty"`
+
+	// Reviewers configures the humans whose review of a PR is requested once
+	// its agent review is posted.
+	// +kubebuilder:validation:Optional
+	Reviewers ReviewersSpec `json:"reviewers,omitempty"`
+
+	// Supersede configures what happens to the agent review previously
+	// posted on a PR when a new one is: dismiss dismisses it if it approved
+	// or requested changes and marks it outdated otherwise, and outdate
+	// only edits its body to mark it outdated. Pending reviews, which GitHub
+	// allows one of per user, are deleted unless none.
+	// +kubebuilder:validation:Enum=none;dismiss;outdate
+	// +kubebuilder:default=none
+	// +kubebuilder:validation:Optional
+	Supersede string `json:"supersede,omitempty"`
+}
+
+// ReviewersSpec configures the reviewers requested on a PR after its agent
+// review is posted. None are requested if both are unset.
+type ReviewersSpec struct {
+	// CodeOwners requests reviews from the owners of the changed files, as
+	// listed in the CODEOWNERS file of the base branch.
+	// +kubebuilder:validation:Optional
+	CodeOwners bool `json:"codeOwners,omitempty"`
+
+	// Pool of GitHub users, and teams as org/team, to request reviews from
+	// in turn.
+	// +kubebuilder:validation:Optional
+	Pool []string `json:"pool,omitempty"`
+
+	// Count of the reviewers requested from the pool on each PR.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Optional
+	Count int `json:"count,omitempty"`
 }
 
 type IssueHandlerSpec struct {
@@ -93,9 +179,155 @@ type IssueHandlerSpec struct {
 	// PushEnabled - allow pushing to user origin
 	// +kubebuilder:validation:Optional
 	PushEnabled bool `json:"pushEnabled,omitempty"`
+
+	// CreatePR - open a draft pull request against the upstream repo's default
+	// branch once the fix is pushed. Requires PushEnabled.
+	// +kubebuilder:validation:Optional
+	CreatePR bool `json:"createPR,omitempty"`
+
+	// RespondToComments - when new comments are posted on an issue after its
+	// sandbox was created, append them to the prompt and scale the sandbox
+	// back up so the agent can address the feedback on the same branch.
+	// +kubebuilder:validation:Optional
+	RespondToComments bool `json:"respondToComments,omitempty"`
+
+	// PullRequest configures the title and body of the created pull request.
+	// +kubebuilder:validation:Optional
+	PullRequest PullRequestTemplate `json:"pullRequest,omitempty"`
+
+	// CommitMessageTemplate overrides the generated conventional commit
+	// message. It is a Go template populated with .Type, .Scope, .Subject,
+	// .Body, .Files, .IssueNumber and .IssueTitle.
+	// +kubebuilder:validation:Optional
+	CommitMessageTemplate string `json:"commitMessageTemplate,omitempty"`
+
+	// CommitSigning configures DCO sign-off and signing of the fix commit.
+	// +kubebuilder:validation:Optional
+	CommitSigning CommitSigningSpec `json:"commitSigning,omitempty"`
+
+	// Validation configures a build/test command that must pass before the
+	// fix is committed.
+	// +kubebuilder:validation:Optional
+	Validation ValidationSpec `json:"validation,omitempty"`
+
+	// SelfReviewIterations is the number of extra iterations in which the
+	// agent reviews the diff it produced and refines it. Iterations stop early
+	// once the validation command passes or the agent makes no further
+	// changes.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Optional
+	SelfReviewIterations int `json:"selfReviewIterations,omitempty"`
+
+	// Staging controls which of the agent's changes are committed.
+	// +kubebuilder:validation:Optional
+	Staging StagingSpec `json:"staging,omitempty"`
+
+	// CodeServer configures the code-server exposed by the issue sandboxes.
+	// +kubebuilder:validation:Optional
+	CodeServer CodeServerSpec `json:"codeServer,omitempty"`
+
+	// Resources of the issue sandbox container, e.g. cpu, memory and
+	// ephemeral-storage requests and limits.
+	// +kubebuilder:validation:Optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// PriorityClassName of the issue sandbox pods, e.g. repo-agent-high for
+	// a handler of security fixes. The cluster default if empty.
+	// +kubebuilder:validation:Optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// CodeServerSpec defines the code-server a sandbox runs so that users can
+// inspect and edit the agent's work in a browser.
+type CodeServerSpec struct {
+	// Disabled turns code-server off, for headless runs.
+	// +kubebuilder:validation:Optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Port code-server listens on inside the sandbox.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=13337
+	Port int `json:"port,omitempty"`
+
+	// Auth is the code-server authentication mode. With `password`, a random
+	// password is generated and stored under the `password` key of the
+	// `<sandbox>-code-server` secret.
+	// +kubebuilder:validation:Enum=none;password
+	// +kubebuilder:default=none
+	Auth string `json:"auth,omitempty"`
+}
+
+// StagingSpec defines which files an issue sandbox stages for the commit.
+// Files ignored by the repo's .gitignore are never staged.
+type StagingSpec struct {
+	// Include is a list of glob patterns. If set, only matching paths are
+	// staged. A pattern matches a path, any of its parent directories or its
+	// base name.
+	// +kubebuilder:validation:Optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude is a list of glob patterns for paths that are never staged.
+	// They are added to the built in list of agent artifacts such as .gemini
+	// and node_modules.
+	// +kubebuilder:validation:Optional
+	Exclude []string `json:"exclude,omitempty"`
+
+	// MaxFileSizeBytes is the size above which a file is not staged.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1048576
+	MaxFileSizeBytes int64 `json:"maxFileSizeBytes,omitempty"`
+}
+
+// CommitSigningSpec defines how an issue sandbox signs its commits.
+type CommitSigningSpec struct {
+	// SignOff adds a `Signed-off-by` trailer for the robot user to the commit.
+	// +kubebuilder:validation:Optional
+	SignOff bool `json:"signOff,omitempty"`
+
+	// Format of the signing key. If empty, commits are not signed.
+	// +kubebuilder:validation:Enum=gpg;ssh
+	// +kubebuilder:validation:Optional
+	Format string `json:"format,omitempty"`
+
+	// SecretName is the name of the secret holding the private signing key
+	// under the `key` entry. For gpg it is an ASCII armored secret key, for
+	// ssh a private key file whose public key is registered as a signing key
+	// of the robot user.
+	// +kubebuilder:default=commit-signing-key
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// ValidationSpec defines how an issue sandbox validates the agent's changes.
+type ValidationSpec struct {
+	// Command is run with `sh -c` in the repo directory. A non-zero exit code
+	// fails validation and its output is fed back to the agent. If empty, the
+	// `customizations.repo-agent.validationCommand` field of the
+	// devcontainer.json is used.
+	// +kubebuilder:validation:Optional
+	Command string `json:"command,omitempty"`
+
+	// MaxAttempts is the number of times the agent is run to get the
+	// validation command to pass.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+}
+
+// PullRequestTemplate defines the title and body of a pull request opened by
+// an issue handler. Both are Go templates populated with the issue.
+type PullRequestTemplate struct {
+	// Title of the pull request.
+	// +kubebuilder:default="Fix #{{.Number}}: {{.Title}}"
+	Title string `json:"title,omitempty"`
+
+	// Body of the pull request.
+	// +kubebuilder:default="Fixes {{.HTMLURL}}"
+	Body string `json:"body,omitempty"`
 }
 
 // RepoWatchSpec defines the desired state of RepoWatch
+// +kubebuilder:validation:XValidation:rule="has(self.githubSecretName) || (has(self.secretManager) && has(self.secretManager.githubPAT))",message="githubSecretName or secretManager.githubPAT is required"
 type RepoWatchSpec struct {
 	// The full URL of the GitHub repository to watch.
 	// e.g., https://github.com/owner/repo
@@ -111,13 +343,199 @@ type RepoWatchSpec struct {
 	IssueHandlers []IssueHandlerSpec `json:"issueHandlers,omitempty"`
 
 	// Secret containing the GitHub Personal Access Token (PAT) for accessing the repo.
-	// +kubebuilder:validation:Required
-	GithubSecretName string `json:"githubSecretName"`
+	// Its name and email keys set the git identity of the issue sandboxes.
+	// The PAT is optional if read from Secret Manager.
+	// +kubebuilder:validation:Optional
+	GithubSecretName string `json:"githubSecretName,omitempty"`
+
+	// GitHub configures the GitHub API of the repository. It defaults to
+	// github.com and is set for GitHub Enterprise Server.
+	// +kubebuilder:validation:Optional
+	GitHub GitHubSpec `json:"github,omitempty"`
 
 	// How often to check for new PRs (in seconds).
 	// +kubebuilder:validation:Minimum=30
 	// +kubebuilder:default=300
 	PollIntervalSeconds int `json:"pollIntervalSeconds,omitempty"`
+
+	// Workers caps the sandboxes of the review and all the issue handlers
+	// running at once, on top of their maxActiveSandboxes. The PRs and issues
+	// beyond it wait in the queue. It is the replicas of the scale
+	// subresource, so that an autoscaler such as KEDA can size it on the
+	// queue depth rather than keeping a large maxActiveSandboxes. Unset, only
+	// the maxActiveSandboxes apply.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Optional
+	Workers *int32 `json:"workers,omitempty"`
+
+	// Clone configures shallow and partial clones and a shared clone cache
+	// for large repositories.
+	// +kubebuilder:validation:Optional
+	Clone CloneSpec `json:"clone,omitempty"`
+
+	// Artifacts configures uploading the sandboxes' prompts, outputs and
+	// logs to object storage.
+	// +kubebuilder:validation:Optional
+	Artifacts ArtifactsSpec `json:"artifacts,omitempty"`
+
+	// NetworkPolicy restricts the network access of the sandboxes, which run
+	// model-driven code.
+	// +kubebuilder:validation:Optional
+	NetworkPolicy NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// SandboxSecurity relaxes the hardening of the sandbox pods for the
+	// repositories whose devcontainers need it.
+	// +kubebuilder:validation:Optional
+	SandboxSecurity SandboxSecuritySpec `json:"sandboxSecurity,omitempty"`
+
+	// SecretManager reads the credentials from Google Secret Manager instead
+	// of Kubernetes secrets copied to each namespace.
+	// +kubebuilder:validation:Optional
+	SecretManager SecretManagerSpec `json:"secretManager,omitempty"`
+}
+
+// GitHubSpec defines the GitHub API endpoints of a repository.
+type GitHubSpec struct {
+	// BaseURL is the REST API URL, e.g. https://ghe.example.com/api/v3/
+	// +kubebuilder:validation:Optional
+	BaseURL string `json:"baseURL,omitempty"`
+
+	// UploadURL is the upload API URL, e.g.
+	// https://ghe.example.com/api/uploads/. It defaults to BaseURL.
+	// +kubebuilder:validation:Optional
+	UploadURL string `json:"uploadURL,omitempty"`
+}
+
+// CloneSpec defines how sandboxes clone the repository.
+type CloneSpec struct {
+	// Depth limits the clone to this many commits. 0 clones the full
+	// history.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Optional
+	Depth int `json:"depth,omitempty"`
+
+	// Filter is a partial clone filter, e.g. `blob:none` for a blobless
+	// clone that fetches file contents on demand.
+	// +kubebuilder:validation:Enum="blob:none";"tree:0"
+	// +kubebuilder:validation:Optional
+	Filter string `json:"filter,omitempty"`
+
+	// CacheClaimName is the name of a PVC in the RepoWatch namespace holding
+	// bare mirrors of repositories at `<owner>/<repo>.git`. It is mounted
+	// read-only and clones borrow objects from the matching mirror with
+	// `git clone --reference`. Mirrors can be kept fresh with a CronJob
+	// running `git remote update`.
+	// +kubebuilder:validation:Optional
+	CacheClaimName string `json:"cacheClaimName,omitempty"`
+}
+
+// ArtifactsSpec defines where sandboxes upload their run artifacts. Each
+// sandbox uploads to `<bucketURL>/<namespace>/<sandbox>/` and records the
+// URL in its status.
+type ArtifactsSpec struct {
+	// BucketURL is a gs://bucket/prefix or s3://bucket/prefix URL. If empty,
+	// artifacts are not uploaded.
+	// +kubebuilder:validation:Pattern=`^(gs|s3)://.+`
+	// +kubebuilder:validation:Optional
+	BucketURL string `json:"bucketURL,omitempty"`
+
+	// CredentialsSecretName is the name of a secret whose entries are set as
+	// env vars of the sidecar, e.g. AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_REGION for S3. GCS uses the sandbox service account through
+	// workload identity.
+	// +kubebuilder:default=artifact-credentials
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty"`
+}
+
+// NetworkPolicySpec defines the NetworkPolicy created with each sandbox. Its
+// egress is limited to the cluster DNS and API server and, on port 443, to
+// GitHub, the LLM endpoints and the AllowedEgress destinations. Sandboxes
+// reading the GitHub PAT from Secret Manager or uploading artifacts to GCS
+// may also reach the metadata server and those APIs, and the OTLP collector
+// of the controller when tracing is exported. Hosts are resolved to their
+// addresses when the sandbox is created and again at every poll of the
+// RepoWatch, which updates the sandboxes whose addresses changed. CIDRs are
+// still preferred for hosts whose addresses change often.
+type NetworkPolicySpec struct {
+	// Enabled creates the NetworkPolicy of the sandboxes.
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// LLMEndpoints are the hosts or CIDRs of the LLM API. They default to
+	// those of the provider, e.g. generativelanguage.googleapis.com for
+	// gemini-cli.
+	// +kubebuilder:validation:Optional
+	LLMEndpoints []string `json:"llmEndpoints,omitempty"`
+
+	// AllowedEgress are additional hosts or CIDRs the sandboxes may reach on
+	// port 443, e.g. the registry of the devcontainer images or the
+	// artifacts bucket.
+	// +kubebuilder:validation:Optional
+	AllowedEgress []string `json:"allowedEgress,omitempty"`
+
+	// IngressPodLabels and IngressNamespaceLabels select the pods allowed to
+	// connect to the sandboxes, e.g. those of the gateway: the pods with
+	// IngressPodLabels, all if empty, in the namespaces with
+	// IngressNamespaceLabels, that of the sandbox if empty. Any pod may
+	// connect if both are empty.
+	// +kubebuilder:validation:Optional
+	IngressPodLabels map[string]string `json:"ingressPodLabels,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	IngressNamespaceLabels map[string]string `json:"ingressNamespaceLabels,omitempty"`
+}
+
+// SandboxSecuritySpec defines the security context of the sandbox pods. By
+// default they run with the RuntimeDefault seccomp profile and without
+// privilege escalation, their sidecar runs as non-root and the containers
+// but the agent's have a read-only root filesystem and only the capabilities
+// they need. The agent container builds the devcontainer with envbuilder,
+// so it runs as root with the default capabilities. The defaults meet the
+// baseline Pod Security Standard, which the sandbox namespaces can enforce.
+type SandboxSecuritySpec struct {
+	// SeccompProfile of the sandbox pods, RuntimeDefault if not set.
+	// +kubebuilder:validation:Optional
+	SeccompProfile *corev1.SeccompProfile `json:"seccompProfile,omitempty"`
+
+	// AppArmorProfile of the sandbox pods, that of the container runtime if
+	// not set. Pods requiring a profile are rejected by nodes without
+	// AppArmor.
+	// +kubebuilder:validation:Optional
+	AppArmorProfile *corev1.AppArmorProfile `json:"appArmorProfile,omitempty"`
+
+	// AllowPrivilegeEscalation lets the processes of the agent container gain
+	// privileges, e.g. for devcontainers whose setup uses sudo.
+	// +kubebuilder:validation:Optional
+	AllowPrivilegeEscalation bool `json:"allowPrivilegeEscalation,omitempty"`
+
+	// Disabled leaves the security context of the sandbox pods unset.
+	// +kubebuilder:validation:Optional
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// SecretManagerSpec references credentials kept in Google Secret Manager.
+// They are read with the workload identity of the controller and the review
+// API, and of the sandboxes for those they use, which need the Secret
+// Manager Secret Accessor role on them.
+type SecretManagerSpec struct {
+	// GithubPAT is the secret of the GitHub PAT, e.g.
+	// projects/<project>/secrets/github-pat, read at its latest version
+	// unless one is given with /versions/<version>. It is used instead of
+	// the pat key of GithubSecretName.
+	// +kubebuilder:validation:Pattern=`^projects/[^/]+/secrets/[^/]+(/versions/[^/]+)?$`
+	// +kubebuilder:validation:Optional
+	GithubPAT string `json:"githubPAT,omitempty"`
+
+	// TokensSecretProviderClass is a SecretProviderClass of the Secrets
+	// Store CSI driver, e.g. of the GKE Secret Manager add-on, mounted at
+	// /tokens in the sandboxes instead of the secret of the LLM API keys.
+	// +kubebuilder:validation:Optional
+	TokensSecretProviderClass string `json:"tokensSecretProviderClass,omitempty"`
+
+	// CSIDriver is the driver of TokensSecretProviderClass.
+	// +kubebuilder:default=secrets-store-gke.csi.k8s.io
+	// +kubebuilder:validation:Optional
+	CSIDriver string `json:"csiDriver,omitempty"`
 }
 
 // RepoWatchStatus defines the observed state of RepoWatch
@@ -139,6 +557,79 @@ type RepoWatchStatus struct {
 
 	// +optional
 	PendingIssues map[string][]PendingIssue `json:"pendingIssues,omitempty"`
+
+	// Cost reports what the sandboxes cost this month and the last
+	// +optional
+	Cost *CostStatus `json:"cost,omitempty"`
+
+	// Workers is the number of sandboxes running, the replicas of the scale
+	// subresource
+	// +optional
+	Workers int32 `json:"workers"`
+
+	// QueueDepth is the number of PRs and issues waiting for a sandbox
+	// +optional
+	QueueDepth int32 `json:"queueDepth"`
+
+	// Selector is the label selector of the sandboxes, for the scale
+	// subresource
+	// +optional
+	Selector string `json:"selector,omitempty"`
+}
+
+// CostStatus is the cost report of a RepoWatch, accumulated by the controller
+// at each reconcile.
+type CostStatus struct {
+	// Current is the report of this month
+	Current CostReport `json:"current"`
+
+	// Previous is the report of the last month
+	// +optional
+	Previous *CostReport `json:"previous,omitempty"`
+
+	// LastUpdateTime is when the running sandboxes were last accounted
+	LastUpdateTime metav1.Time `json:"lastUpdateTime"`
+
+	// CountedTokens are the tokens of each sandbox already reported, by
+	// sandbox UID, so that only the new ones are added
+	// +optional
+	CountedTokens map[string]TokenUsage `json:"countedTokens,omitempty"`
+}
+
+// CostReport is the usage and estimated cost of the sandboxes of a RepoWatch
+// in a month. The compute is that requested by the agent containers of the
+// running sandboxes.
+type CostReport struct {
+	// Period is the month, in UTC, e.g. 2025-10
+	Period string `json:"period"`
+
+	// CPUCoreHours the sandboxes requested, e.g. 12.5
+	CPUCoreHours string `json:"cpuCoreHours"`
+
+	// MemoryGiBHours the sandboxes requested
+	MemoryGiBHours string `json:"memoryGiBHours"`
+
+	// Tokens the LLMs of the sandboxes used, estimated for the providers
+	// that don't report them
+	Tokens TokenUsage `json:"tokens"`
+
+	// EstimatedCost is the cost of the usage at the rates of the
+	// controller, e.g. 10.42 USD
+	EstimatedCost string `json:"estimatedCost"`
+
+	// CPUMilliCoreSeconds is CPUCoreHours with the precision accumulated
+	CPUMilliCoreSeconds int64 `json:"cpuMilliCoreSeconds"`
+
+	// MemoryMiBSeconds is MemoryGiBHours with the precision accumulated
+	MemoryMiBSeconds int64 `json:"memoryMiBSeconds"`
+}
+
+// TokenUsage is a number of LLM tokens.
+type TokenUsage struct {
+	// +optional
+	InputTokens int64 `json:"inputTokens"`
+	// +optional
+	OutputTokens int64 `json:"outputTokens"`
 }
 
 // WatchedPR defines the state of a watched PR
@@ -167,6 +658,9 @@ type WatchedIssue struct {
 	SandboxName string `json:"sandboxName"`
 	// Status of the sandbox
 	Status string `json:"status"`
+	// Phase of the agent run reported by the sandbox
+	// +optional
+	Phase string `json:"phase,omitempty"`
 }
 
 // PendingIssue defines the state of a pending PR
@@ -179,6 +673,7 @@ type PendingIssue struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.workers,statuspath=.status.workers,selectorpath=.status.selector
 // RepoWatch is the Schema for the repowatches API
 type RepoWatch struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -5,10 +5,131 @@
 package v1alpha1
 
 import (
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactsSpec) DeepCopyInto(out *ArtifactsSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactsSpec.
+func (in *ArtifactsSpec) DeepCopy() *ArtifactsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloneSpec) DeepCopyInto(out *CloneSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloneSpec.
+func (in *CloneSpec) DeepCopy() *CloneSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CloneSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CodeServerSpec) DeepCopyInto(out *CodeServerSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CodeServerSpec.
+func (in *CodeServerSpec) DeepCopy() *CodeServerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CodeServerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CommitSigningSpec) DeepCopyInto(out *CommitSigningSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommitSigningSpec.
+func (in *CommitSigningSpec) DeepCopy() *CommitSigningSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CommitSigningSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CostReport) DeepCopyInto(out *CostReport) {
+	*out = *in
+	out.Tokens = in.Tokens
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CostReport.
+func (in *CostReport) DeepCopy() *CostReport {
+	if in == nil {
+		return nil
+	}
+	out := new(CostReport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CostStatus) DeepCopyInto(out *CostStatus) {
+	*out = *in
+	out.Current = in.Current
+	if in.Previous != nil {
+		in, out := &in.Previous, &out.Previous
+		*out = new(CostReport)
+		**out = **in
+	}
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+	if in.CountedTokens != nil {
+		in, out := &in.CountedTokens, &out.CountedTokens
+		*out = make(map[string]TokenUsage, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CostStatus.
+func (in *CostStatus) DeepCopy() *CostStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CostStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitHubSpec) DeepCopyInto(out *GitHubSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitHubSpec.
+func (in *GitHubSpec) DeepCopy() *GitHubSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GitHubSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IssueHandlerSpec) DeepCopyInto(out *IssueHandlerSpec) {
 	*out = *in
@@ -22,7 +143,13 @@ func (in *IssueHandlerSpec) DeepCopyInto(out *IssueHandlerSpec) {
 		*out = make([]int, len(*in))
 		copy(*out, *in)
 	}
-	out.LLM = in.LLM
+	in.LLM.DeepCopyInto(&out.LLM)
+	out.PullRequest = in.PullRequest
+	out.CommitSigning = in.CommitSigning
+	out.Validation = in.Validation
+	in.Staging.DeepCopyInto(&out.Staging)
+	out.CodeServer = in.CodeServer
+	in.Resources.DeepCopyInto(&out.Resources)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssueHandlerSpec.
@@ -38,6 +165,11 @@ func (in *IssueHandlerSpec) DeepCopy() *IssueHandlerSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LLMConfig) DeepCopyInto(out *LLMConfig) {
 	*out = *in
+	if in.PromptTemplateRef != nil {
+		in, out := &in.PromptTemplateRef, &out.PromptTemplateRef
+		*out = new(PromptTemplateRef)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LLMConfig.
@@ -50,15 +182,57 @@ func (in *LLMConfig) DeepCopy() *LLMConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkPolicySpec) DeepCopyInto(out *NetworkPolicySpec) {
+	*out = *in
+	if in.LLMEndpoints != nil {
+		in, out := &in.LLMEndpoints, &out.LLMEndpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedEgress != nil {
+		in, out := &in.AllowedEgress, &out.AllowedEgress
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IngressPodLabels != nil {
+		in, out := &in.IngressPodLabels, &out.IngressPodLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.IngressNamespaceLabels != nil {
+		in, out := &in.IngressNamespaceLabels, &out.IngressNamespaceLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkPolicySpec.
+func (in *NetworkPolicySpec) DeepCopy() *NetworkPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PRReviewSpec) DeepCopyInto(out *PRReviewSpec) {
 	*out = *in
-	out.LLM = in.LLM
+	in.LLM.DeepCopyInto(&out.LLM)
 	if in.PullRequests != nil {
 		in, out := &in.PullRequests, &out.PullRequests
 		*out = make([]int, len(*in))
 		copy(*out, *in)
 	}
+	out.CodeServer = in.CodeServer
+	in.Resources.DeepCopyInto(&out.Resources)
+	in.Reviewers.DeepCopyInto(&out.Reviewers)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PRReviewSpec.
@@ -101,6 +275,36 @@ func (in *PendingPR) DeepCopy() *PendingPR {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromptTemplateRef) DeepCopyInto(out *PromptTemplateRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromptTemplateRef.
+func (in *PromptTemplateRef) DeepCopy() *PromptTemplateRef {
+	if in == nil {
+		return nil
+	}
+	out := new(PromptTemplateRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullRequestTemplate) DeepCopyInto(out *PullRequestTemplate) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullRequestTemplate.
+func (in *PullRequestTemplate) DeepCopy() *PullRequestTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(PullRequestTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RepoWatch) DeepCopyInto(out *RepoWatch) {
 	*out = *in
@@ -171,6 +375,17 @@ func (in *RepoWatchSpec) DeepCopyInto(out *RepoWatchSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	out.GitHub = in.GitHub
+	if in.Workers != nil {
+		in, out := &in.Workers, &out.Workers
+		*out = new(int32)
+		**out = **in
+	}
+	out.Clone = in.Clone
+	out.Artifacts = in.Artifacts
+	in.NetworkPolicy.DeepCopyInto(&out.NetworkPolicy)
+	in.SandboxSecurity.DeepCopyInto(&out.SandboxSecurity)
+	out.SecretManager = in.SecretManager
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepoWatchSpec.
@@ -188,7 +403,7 @@ func (in *RepoWatchStatus) DeepCopyInto(out *RepoWatchStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
-		*out = make([]v1.Condition, len(*in))
+		*out = make([]metav1.Condition, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -235,6 +450,11 @@ func (in *RepoWatchStatus) DeepCopyInto(out *RepoWatchStatus) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.Cost != nil {
+		in, out := &in.Cost, &out.Cost
+		*out = new(CostStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepoWatchStatus.
@@ -247,6 +467,121 @@ func (in *RepoWatchStatus) DeepCopy() *RepoWatchStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReviewersSpec) DeepCopyInto(out *ReviewersSpec) {
+	*out = *in
+	if in.Pool != nil {
+		in, out := &in.Pool, &out.Pool
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReviewersSpec.
+func (in *ReviewersSpec) DeepCopy() *ReviewersSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReviewersSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SandboxSecuritySpec) DeepCopyInto(out *SandboxSecuritySpec) {
+	*out = *in
+	if in.SeccompProfile != nil {
+		in, out := &in.SeccompProfile, &out.SeccompProfile
+		*out = new(v1.SeccompProfile)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AppArmorProfile != nil {
+		in, out := &in.AppArmorProfile, &out.AppArmorProfile
+		*out = new(v1.AppArmorProfile)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SandboxSecuritySpec.
+func (in *SandboxSecuritySpec) DeepCopy() *SandboxSecuritySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SandboxSecuritySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretManagerSpec) DeepCopyInto(out *SecretManagerSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretManagerSpec.
+func (in *SecretManagerSpec) DeepCopy() *SecretManagerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretManagerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StagingSpec) DeepCopyInto(out *StagingSpec) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StagingSpec.
+func (in *StagingSpec) DeepCopy() *StagingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StagingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenUsage) DeepCopyInto(out *TokenUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenUsage.
+func (in *TokenUsage) DeepCopy() *TokenUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValidationSpec) DeepCopyInto(out *ValidationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValidationSpec.
+func (in *ValidationSpec) DeepCopy() *ValidationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ValidationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WatchedIssue) DeepCopyInto(out *WatchedIssue) {
 	*out = *in
@@ -27,14 +27,18 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/secretmanager"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
 	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
 	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/controllers"
 	//+kubebuilder:scaffold:imports
@@ -55,37 +59,62 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var leaderElectionNamespace string
 	var probeAddr string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	opts := zap.Options{
-		Development: true,
-	}
-	opts.BindFlags(flag.CommandLine)
+	flag.StringVar(&leaderElectionNamespace, "leader-elect-namespace", "",
+		"The namespace of the leader election lease, that of the pod if empty. Required out of cluster.")
+	costRates := controllers.DefaultCostRates
+	flag.StringVar(&costRates.Currency, "cost-currency", costRates.Currency, "The currency of the cost rates.")
+	flag.Float64Var(&costRates.CPUCoreHour, "cost-cpu-core-hour", costRates.CPUCoreHour,
+		"The price of a CPU core hour requested by a sandbox, for the cost reports.")
+	flag.Float64Var(&costRates.MemoryGiBHour, "cost-memory-gib-hour", costRates.MemoryGiBHour,
+		"The price of a GiB hour of memory requested by a sandbox, for the cost reports.")
+	flag.Float64Var(&costRates.InputMTokens, "cost-input-mtokens", costRates.InputMTokens,
+		"The price of a million LLM input tokens, for the cost reports.")
+	flag.Float64Var(&costRates.OutputMTokens, "cost-output-mtokens", costRates.OutputMTokens,
+		"The price of a million LLM output tokens, for the cost reports.")
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	// Logs are redacted and leveled with LOG_LEVEL, see pkg/logging
+	ctrl.SetLogger(logr.FromSlogHandler(logging.Setup("repowatch-controller", os.Stderr).Handler()))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "1a2b3c4d.x-k8s.io",
+		Scheme:                  scheme,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "repowatch.review.gemini.google.com",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		// The leader releases the lease once its reconciles are done when it
+		// is stopped, so that a standby replica takes over without waiting
+		// for the lease to expire.
+		LeaderElectionReleaseOnCancel: true,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// Credentials stored encrypted by the review API are decrypted with the
+	// KMS_KEY_NAME key
+	kms := envelope.FromEnv()
+	// and those kept in Secret Manager are read with the workload identity
+	// of the controller
+	secrets := secretmanager.New()
 	if err = (&controllers.RepoWatchReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 		NewGithubClient: func(ctx context.Context, k8sClient client.Client, repoWatch *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
-			return controllers.NewGithubClient(ctx, k8sClient, repoWatch)
+			return controllers.NewGithubClient(ctx, k8sClient, kms, secrets, repoWatch)
 		},
+		KMS:       kms,
+		Tracer:    tracing.FromEnv("repowatch-controller"),
+		APIReader: mgr.GetAPIReader(),
+		CostRates: costRates,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RepoWatch")
 		os.Exit(1)
@@ -0,0 +1,216 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+)
+
+// CostRates are the prices the cost reports estimate the usage at.
+type CostRates struct {
+	Currency string
+	// CPUCoreHour and MemoryGiBHour are the prices of the compute requested
+	// by the sandboxes
+	CPUCoreHour   float64
+	MemoryGiBHour float64
+	// InputMTokens and OutputMTokens are the prices of a million LLM tokens
+	InputMTokens  float64
+	OutputMTokens float64
+}
+
+// DefaultCostRates are the list prices of GKE Autopilot pods in us-central1
+// and of Gemini 2.5 Pro.
+var DefaultCostRates = CostRates{
+	Currency:      "USD",
+	CPUCoreHour:   0.0445,
+	MemoryGiBHour: 0.0049225,
+	InputMTokens:  1.25,
+	OutputMTokens: 10,
+}
+
+var (
+	costUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "repowatch_month_usage",
+		Help: "Usage of the sandboxes of a RepoWatch this month, by resource: cpu_core_hours, memory_gib_hours, input_tokens or output_tokens.",
+	}, []string{"namespace", "repowatch", "resource"})
+	costEstimate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "repowatch_month_estimated_cost",
+		Help: "Estimated cost of the sandboxes of a RepoWatch this month.",
+	}, []string{"namespace", "repowatch", "currency"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(costUsage, costEstimate)
+}
+
+// sandboxGVKs are the kinds of the sandboxes whose cost is reported.
+var sandboxGVKs = []schema.GroupVersionKind{
+	{Group: "custom.agents.x-k8s.io", Version: "v1alpha1", Kind: "ReviewSandbox"},
+	{Group: "custom.agents.x-k8s.io", Version: "v1alpha1", Kind: "IssueSandbox"},
+}
+
+// reconcileCost adds the usage of the sandboxes of a RepoWatch since the last
+// reconcile to its cost report and exports it as metrics. The tokens the
+// sandboxes of closed PRs and issues use until they are deleted are added by
+// countDeletedSandbox.
+func (r *RepoWatchReconciler) reconcileCost(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandboxes []unstructured.Unstructured) error {
+	repoWatch.Status.Cost = updateCost(repoWatch.Status.Cost, sandboxes, time.Now(), r.CostRates)
+	setCostMetrics(repoWatch, r.CostRates)
+	return r.Status().Update(ctx, repoWatch)
+}
+
+// updateCost returns the cost report updated at now: the compute requested
+// by the running sandboxes since the last update, assuming they ran
+// throughout, and the tokens they used since. The report of the last month
+// is kept when a new one starts.
+func updateCost(cost *reviewv1alpha1.CostStatus, sandboxes []unstructured.Unstructured, now time.Time, rates CostRates) *reviewv1alpha1.CostStatus {
+	var elapsed time.Duration
+	if cost == nil {
+		cost = &reviewv1alpha1.CostStatus{}
+	} else {
+		cost = cost.DeepCopy()
+		elapsed = max(now.Sub(cost.LastUpdateTime.Time), 0)
+	}
+	period := now.UTC().Format("2006-01")
+	if cost.Current.Period != period {
+		if cost.Current.Period != "" {
+			previous := cost.Current
+			cost.Previous = &previous
+		}
+		cost.Current = reviewv1alpha1.CostReport{Period: period}
+	}
+	report := &cost.Current
+
+	counted := map[string]reviewv1alpha1.TokenUsage{}
+	for i := range sandboxes {
+		obj := &sandboxes[i]
+		if replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas"); !found || replicas > 0 {
+			cpu, memory := sandboxRequests(obj)
+			report.CPUMilliCoreSeconds += int64(math.Round(float64(cpu.MilliValue()) * elapsed.Seconds()))
+			report.MemoryMiBSeconds += int64(math.Round(float64(memory.Value()) / (1 << 20) * elapsed.Seconds()))
+		}
+
+		uid := string(obj.GetUID())
+		usage := sandboxUsage(obj)
+		addTokens(report, cost.CountedTokens[uid], usage)
+		counted[uid] = usage
+	}
+	cost.CountedTokens = counted
+	cost.LastUpdateTime = metav1.NewTime(now)
+
+	report.CPUCoreHours = formatHours(float64(report.CPUMilliCoreSeconds) / 1000)
+	report.MemoryGiBHours = formatHours(float64(report.MemoryMiBSeconds) / 1024)
+	report.EstimatedCost = fmt.Sprintf("%.2f %s", estimateCost(report, rates), rates.Currency)
+	return cost
+}
+
+// countDeletedSandbox adds the tokens a sandbox used since the last reconcile
+// to the cost report of its RepoWatch before it is deleted, since they can't
+// be read afterwards. The report is saved with the status of the RepoWatch.
+func (r *RepoWatchReconciler) countDeletedSandbox(repoWatch *reviewv1alpha1.RepoWatch, obj *unstructured.Unstructured) {
+	cost := repoWatch.Status.Cost
+	if cost == nil {
+		return
+	}
+	uid := string(obj.GetUID())
+	report := &cost.Current
+	addTokens(report, cost.CountedTokens[uid], sandboxUsage(obj))
+	delete(cost.CountedTokens, uid)
+	report.EstimatedCost = fmt.Sprintf("%.2f %s", estimateCost(report, r.CostRates), r.CostRates.Currency)
+}
+
+// addTokens adds the tokens of usage not counted yet in last to a report.
+func addTokens(report *reviewv1alpha1.CostReport, last, usage reviewv1alpha1.TokenUsage) {
+	if usage.InputTokens < last.InputTokens || usage.OutputTokens < last.OutputTokens {
+		// The usage file was reset, e.g. with the workspace
+		last = reviewv1alpha1.TokenUsage{}
+	}
+	report.Tokens.InputTokens += usage.InputTokens - last.InputTokens
+	report.Tokens.OutputTokens += usage.OutputTokens - last.OutputTokens
+}
+
+// sandboxRequests returns the CPU and memory requested by the agent container
+// of a sandbox, see setResources.
+func sandboxRequests(obj *unstructured.Unstructured) (cpu, memory resource.Quantity) {
+	requests, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "resources", "requests")
+	cpu, _ = resource.ParseQuantity(requests["cpu"])
+	memory, _ = resource.ParseQuantity(requests["memory"])
+	return cpu, memory
+}
+
+// sandboxUsage returns the tokens a sandbox used so far, published by its
+// sidecar to the status of issue sandboxes and to an annotation of review
+// sandboxes.
+func sandboxUsage(obj *unstructured.Unstructured) reviewv1alpha1.TokenUsage {
+	var usage sandbox.Usage
+	if status, found, _ := unstructured.NestedMap(obj.Object, "status", "usage"); found {
+		usage.InputTokens, _, _ = unstructured.NestedInt64(status, "inputTokens")
+		usage.OutputTokens, _, _ = unstructured.NestedInt64(status, "outputTokens")
+	} else if annotation, ok := obj.GetAnnotations()[sandbox.UsageAnnotation]; ok {
+		_ = json.Unmarshal([]byte(annotation), &usage)
+	}
+	return reviewv1alpha1.TokenUsage{InputTokens: usage.InputTokens, OutputTokens: usage.OutputTokens}
+}
+
+// estimateCost returns the cost of the usage of a report at rates.
+func estimateCost(report *reviewv1alpha1.CostReport, rates CostRates) float64 {
+	return float64(report.CPUMilliCoreSeconds)/1000/3600*rates.CPUCoreHour +
+		float64(report.MemoryMiBSeconds)/1024/3600*rates.MemoryGiBHour +
+		float64(report.Tokens.InputTokens)/1e6*rates.InputMTokens +
+		float64(report.Tokens.OutputTokens)/1e6*rates.OutputMTokens
+}
+
+// formatHours formats unit-seconds as unit-hours.
+func formatHours(seconds float64) string {
+	return strconv.FormatFloat(seconds/3600, 'f', 2, 64)
+}
+
+// setCostMetrics exports the report of this month of a RepoWatch.
+func setCostMetrics(repoWatch *reviewv1alpha1.RepoWatch, rates CostRates) {
+	report := repoWatch.Status.Cost.Current
+	labels := func(resource string) prometheus.Labels {
+		return prometheus.Labels{"namespace": repoWatch.Namespace, "repowatch": repoWatch.Name, "resource": resource}
+	}
+	costUsage.With(labels("cpu_core_hours")).Set(float64(report.CPUMilliCoreSeconds) / 1000 / 3600)
+	costUsage.With(labels("memory_gib_hours")).Set(float64(report.MemoryMiBSeconds) / 1024 / 3600)
+	costUsage.With(labels("input_tokens")).Set(float64(report.Tokens.InputTokens))
+	costUsage.With(labels("output_tokens")).Set(float64(report.Tokens.OutputTokens))
+	costEstimate.With(prometheus.Labels{"namespace": repoWatch.Namespace, "repowatch": repoWatch.Name, "currency": rates.Currency}).
+		Set(estimateCost(&report, rates))
+}
+
+// deleteCostMetrics stops exporting the metrics of a deleted RepoWatch.
+func deleteCostMetrics(namespace, name string) {
+	labels := prometheus.Labels{"namespace": namespace, "repowatch": name}
+	costUsage.DeletePartialMatch(labels)
+	costEstimate.DeletePartialMatch(labels)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+)
+
+func costSandbox(kind, uid string, replicas int64, usage map[string]interface{}) unstructured.Unstructured {
+	sandbox := unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas":  replicas,
+			"resources": map[string]interface{}{"requests": map[string]interface{}{"cpu": "500m", "memory": "1Gi"}},
+		},
+	}}
+	sandbox.SetAPIVersion("custom.agents.x-k8s.io/v1alpha1")
+	sandbox.SetKind(kind)
+	sandbox.SetUID(types.UID(uid))
+	if kind == "IssueSandbox" {
+		_ = unstructured.SetNestedMap(sandbox.Object, usage, "status", "usage")
+	} else {
+		b, _ := json.Marshal(usage)
+		sandbox.SetAnnotations(map[string]string{"agentUsage": string(b)})
+	}
+	return sandbox
+}
+
+func TestUpdateCost(t *testing.T) {
+	g := gomega.NewWithT(t)
+	rates := CostRates{Currency: "USD", CPUCoreHour: 1, MemoryGiBHour: 1, InputMTokens: 1000, OutputMTokens: 10000}
+	start := time.Date(2025, 10, 31, 22, 0, 0, 0, time.UTC)
+
+	review := costSandbox("ReviewSandbox", "review", 1, map[string]interface{}{"inputTokens": int64(1000), "outputTokens": int64(100)})
+	issue := costSandbox("IssueSandbox", "issue", 0, map[string]interface{}{"inputTokens": int64(500), "outputTokens": int64(50)})
+	cost := updateCost(nil, []unstructured.Unstructured{review, issue}, start, rates)
+	g.Expect(cost.Current.Period).To(gomega.Equal("2025-10"))
+	g.Expect(cost.Current.CPUMilliCoreSeconds).To(gomega.BeZero())
+	g.Expect(cost.Current.Tokens).To(gomega.Equal(reviewv1alpha1.TokenUsage{InputTokens: 1500, OutputTokens: 150}))
+
+	// An hour later only the running sandbox used compute, and only the new
+	// tokens are added
+	review = costSandbox("ReviewSandbox", "review", 1, map[string]interface{}{"inputTokens": int64(3000), "outputTokens": int64(300)})
+	cost = updateCost(cost, []unstructured.Unstructured{review, issue}, start.Add(time.Hour), rates)
+	g.Expect(cost.Current.CPUCoreHours).To(gomega.Equal("0.50"))
+	g.Expect(cost.Current.MemoryGiBHours).To(gomega.Equal("1.00"))
+	g.Expect(cost.Current.Tokens).To(gomega.Equal(reviewv1alpha1.TokenUsage{InputTokens: 3500, OutputTokens: 350}))
+	// 0.5 + 1 + 3500 * 1000 / 1e6 + 350 * 10000 / 1e6
+	g.Expect(cost.Current.EstimatedCost).To(gomega.Equal("8.50 USD"))
+	g.Expect(cost.CountedTokens).To(gomega.HaveLen(2))
+
+	// The report of October is kept when November starts, and deleted
+	// sandboxes are no longer tracked
+	cost = updateCost(cost, []unstructured.Unstructured{review}, start.Add(3*time.Hour), rates)
+	g.Expect(cost.Previous).NotTo(gomega.BeNil())
+	g.Expect(cost.Previous.Period).To(gomega.Equal("2025-10"))
+	g.Expect(cost.Current.Period).To(gomega.Equal("2025-11"))
+	g.Expect(cost.Current.CPUCoreHours).To(gomega.Equal("1.00"))
+	g.Expect(cost.Current.Tokens).To(gomega.Equal(reviewv1alpha1.TokenUsage{}))
+	g.Expect(cost.CountedTokens).To(gomega.HaveKey("review"))
+	g.Expect(cost.CountedTokens).NotTo(gomega.HaveKey("issue"))
+}
+
+func TestReconcileCost(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	repoWatch := &reviewv1alpha1.RepoWatch{ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default", UID: "rw-uid"}}
+	owned := costSandbox("IssueSandbox", "owned", 1, map[string]interface{}{"inputTokens": int64(10), "outputTokens": int64(1)})
+	owned.SetName("owned")
+	owned.SetNamespace("default")
+	owned.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "review.gemini.google.com/v1alpha1", Kind: "RepoWatch", Name: "rw", UID: "rw-uid"}})
+	other := costSandbox("IssueSandbox", "other", 1, map[string]interface{}{"inputTokens": int64(99), "outputTokens": int64(9)})
+	other.SetName("other")
+	other.SetNamespace("default")
+	r := &RepoWatchReconciler{
+		Client:    clientfake.NewClientBuilder().WithScheme(s).WithObjects(repoWatch, &owned, &other).WithStatusSubresource(repoWatch).Build(),
+		Scheme:    s,
+		CostRates: CostRates{Currency: "USD", InputMTokens: 1e6},
+	}
+
+	sandboxes, err := r.ownedSandboxes(ctx, repoWatch)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(sandboxes).To(gomega.HaveLen(1))
+	g.Expect(r.reconcileCost(ctx, repoWatch, sandboxes)).To(gomega.Succeed())
+	g.Expect(repoWatch.Status.Cost.Current.Tokens).To(gomega.Equal(reviewv1alpha1.TokenUsage{InputTokens: 10, OutputTokens: 1}))
+	g.Expect(testutil.ToFloat64(costUsage.WithLabelValues("default", "rw", "input_tokens"))).To(gomega.Equal(float64(10)))
+	g.Expect(testutil.ToFloat64(costEstimate.WithLabelValues("default", "rw", "USD"))).To(gomega.Equal(float64(10)))
+
+	deleteCostMetrics("default", "rw")
+	g.Expect(testutil.CollectAndCount(costUsage)).To(gomega.BeZero())
+}
+
+func TestCountDeletedSandbox(t *testing.T) {
+	g := gomega.NewWithT(t)
+	r := &RepoWatchReconciler{CostRates: CostRates{Currency: "USD", InputMTokens: 1e6}}
+	repoWatch := &reviewv1alpha1.RepoWatch{}
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	review := costSandbox("ReviewSandbox", "review", 1, map[string]interface{}{"inputTokens": int64(10), "outputTokens": int64(1)})
+	repoWatch.Status.Cost = updateCost(nil, []unstructured.Unstructured{review}, start, r.CostRates)
+
+	// The tokens used since the last reconcile are added once it is deleted
+	review = costSandbox("ReviewSandbox", "review", 0, map[string]interface{}{"inputTokens": int64(30), "outputTokens": int64(3)})
+	r.countDeletedSandbox(repoWatch, &review)
+	g.Expect(repoWatch.Status.Cost.Current.Tokens).To(gomega.Equal(reviewv1alpha1.TokenUsage{InputTokens: 30, OutputTokens: 3}))
+	g.Expect(repoWatch.Status.Cost.Current.EstimatedCost).To(gomega.Equal("30.00 USD"))
+	g.Expect(repoWatch.Status.Cost.CountedTokens).NotTo(gomega.HaveKey("review"))
+}
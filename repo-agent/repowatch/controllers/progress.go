@@ -0,0 +1,171 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+)
+
+// inProgressAnnotation records the reaction or comment telling the author of
+// a PR that its review is in progress, as reaction/<id> or comment/<id>, until
+// the draft is ready, the review fails or the PR is closed.
+const inProgressAnnotation = "review.gemini.google.com/in-progress"
+
+const (
+	inProgressReaction  = "eyes"
+	inProgressComment   = "An automated review of this pull request is in progress."
+	draftReadyComment   = "An automated review of this pull request is drafted. A maintainer will check it before it is posted."
+	reviewFailedComment = "The automated review of this pull request failed. A maintainer can rerun it."
+	prClosedComment     = "The automated review of this pull request was stopped since it was closed."
+)
+
+// reconcileInProgress tells the authors of the open PRs being reviewed that
+// their review is in progress, and removes the reaction or edits the comment
+// once the sidecar of the sandbox publishes the draft or the error of the
+// review, or the PR is closed. A rerun clears the draft and the error, so it
+// is announced again. It runs before the sandboxes of the closed PRs are
+// deleted.
+func (r *RepoWatchReconciler) reconcileInProgress(ctx context.Context, ghClient *github.Client, repoWatch *reviewv1alpha1.RepoWatch, owner, repo string, prs []*github.PullRequest) error {
+	mode := repoWatch.Spec.Review.InProgress
+	if mode == "" || mode == "none" {
+		return nil
+	}
+
+	open := map[string]bool{}
+	for _, pr := range prs {
+		open[strconv.Itoa(pr.GetNumber())] = true
+	}
+	sandboxList := &unstructured.UnstructuredList{}
+	sandboxList.SetGroupVersionKind(sandboxGVKs[0])
+	if err := r.List(ctx, sandboxList, client.InNamespace(repoWatch.Namespace)); err != nil {
+		return err
+	}
+	var errs error
+	for i := range sandboxList.Items {
+		obj := &sandboxList.Items[i]
+		if !isOwnedBy(obj, repoWatch) {
+			continue
+		}
+		pr, _, _ := unstructured.NestedString(obj.Object, "spec", "source", "pr")
+		if err := r.updateInProgress(ctx, ghClient, mode, owner, repo, obj, open[pr]); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("sandbox %s: %w", obj.GetName(), err))
+		}
+	}
+	return errs
+}
+
+// updateInProgress posts the in-progress notice of the open PR of a review
+// sandbox without a draft or an error, or clears it once the review is over,
+// and records it in the sandbox's annotations.
+func (r *RepoWatchReconciler) updateInProgress(ctx context.Context, ghClient *github.Client, mode, owner, repo string, obj *unstructured.Unstructured, open bool) error {
+	annotations := obj.GetAnnotations()
+	notice := annotations[inProgressAnnotation]
+	// The review sidecar publishes the draft and the error to annotations
+	var done string
+	switch {
+	case !open:
+		done = prClosedComment
+	case annotations["agentError"] != "":
+		done = reviewFailedComment
+	case annotations["agentDraft"] != "":
+		done = draftReadyComment
+	}
+	if (notice == "") == (done != "") {
+		return nil
+	}
+
+	pr, _, _ := unstructured.NestedString(obj.Object, "spec", "source", "pr")
+	number, err := strconv.Atoi(pr)
+	if err != nil {
+		return fmt.Errorf("invalid pr number %q: %w", pr, err)
+	}
+	orig := obj.DeepCopy()
+	if notice == "" {
+		notice, err = postInProgress(ctx, ghClient, mode, owner, repo, number)
+		if err != nil {
+			return err
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[inProgressAnnotation] = notice
+	} else {
+		if err := clearInProgress(ctx, ghClient, owner, repo, number, notice, done); err != nil {
+			return err
+		}
+		delete(annotations, inProgressAnnotation)
+	}
+	obj.SetAnnotations(annotations)
+	// A merge patch doesn't conflict with the sidecar publishing the draft
+	// meanwhile, so that a posted notice is recorded and not posted again
+	return retry.OnError(retry.DefaultRetry, func(err error) bool { return !apierrors.IsNotFound(err) }, func() error {
+		return r.Patch(ctx, obj, client.MergeFrom(orig))
+	})
+}
+
+// postInProgress reacts to or comments on a PR, and returns the notice to
+// record.
+func postInProgress(ctx context.Context, ghClient *github.Client, mode, owner, repo string, number int) (string, error) {
+	if mode == "reaction" {
+		reaction, _, err := ghClient.Reactions.CreateIssueReaction(ctx, owner, repo, number, inProgressReaction)
+		if err != nil {
+			return "", fmt.Errorf("unable to react to pr %d: %w", number, err)
+		}
+		return fmt.Sprintf("reaction/%d", reaction.GetID()), nil
+	}
+	comment, _, err := ghClient.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: github.String(inProgressComment)})
+	if err != nil {
+		return "", fmt.Errorf("unable to comment on pr %d: %w", number, err)
+	}
+	return fmt.Sprintf("comment/%d", comment.GetID()), nil
+}
+
+// clearInProgress removes the reaction of a notice, or replaces its comment
+// with body. Notices deleted on GitHub are ignored.
+func clearInProgress(ctx context.Context, ghClient *github.Client, owner, repo string, number int, notice, body string) error {
+	kind, id, _ := strings.Cut(notice, "/")
+	noticeID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid in-progress notice %q", notice)
+	}
+	var resp *github.Response
+	switch kind {
+	case "reaction":
+		resp, err = ghClient.Reactions.DeleteIssueReaction(ctx, owner, repo, number, noticeID)
+	case "comment":
+		_, resp, err = ghClient.Issues.EditComment(ctx, owner, repo, noticeID, &github.IssueComment{Body: github.String(body)})
+	default:
+		return fmt.Errorf("invalid in-progress notice %q", notice)
+	}
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("unable to clear the in-progress %s of pr %d: %w", kind, number, err)
+	}
+	return nil
+}
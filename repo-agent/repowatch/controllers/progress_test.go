@@ -0,0 +1,162 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+)
+
+func TestReconcileInProgress(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+
+	for _, tc := range []struct {
+		mode    string
+		posted  string
+		notice  string
+		cleared func(body string) string
+	}{
+		{
+			mode:   "reaction",
+			posted: "POST /repos/test/repo/issues/1/reactions {\"content\":\"eyes\"}\n",
+			notice: "reaction/7",
+			cleared: func(string) string {
+				return "DELETE /repos/test/repo/issues/1/reactions/7 "
+			},
+		},
+		{
+			mode:   "comment",
+			posted: "POST /repos/test/repo/issues/1/comments {\"body\":\"" + inProgressComment + "\"}\n",
+			notice: "comment/7",
+			cleared: func(body string) string {
+				return "PATCH /repos/test/repo/issues/comments/7 {\"body\":\"" + body + "\"}\n"
+			},
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+
+			var requests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+				if r.Method == http.MethodDelete {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				w.Write([]byte(`{"id": 7}`))
+			}))
+			defer srv.Close()
+			ghClient := github.NewClient(nil)
+			ghClient.BaseURL, _ = url.Parse(srv.URL + "/")
+
+			repoWatch := &reviewv1alpha1.RepoWatch{
+				ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default", UID: "rw-uid"},
+				Spec:       reviewv1alpha1.RepoWatchSpec{Review: reviewv1alpha1.PRReviewSpec{InProgress: tc.mode}},
+			}
+			sandbox := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "custom.agents.x-k8s.io/v1alpha1",
+				"kind":       "ReviewSandbox",
+				"metadata": map[string]interface{}{
+					"name":      "repo-pr-1",
+					"namespace": "default",
+					"ownerReferences": []interface{}{
+						map[string]interface{}{"apiVersion": "review.gemini.google.com/v1alpha1", "kind": "RepoWatch", "name": "rw", "uid": "rw-uid"},
+					},
+				},
+				"spec": map[string]interface{}{"source": map[string]interface{}{"pr": "1"}},
+			}}
+			r := &RepoWatchReconciler{
+				Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(repoWatch, sandbox).Build(),
+				Scheme: s,
+			}
+			get := func() *unstructured.Unstructured {
+				obj := &unstructured.Unstructured{}
+				obj.SetGroupVersionKind(sandboxGVKs[0])
+				g.Expect(r.Get(ctx, types.NamespacedName{Name: "repo-pr-1", Namespace: "default"}, obj)).To(gomega.Succeed())
+				return obj
+			}
+			// The sidecar publishes the draft or the error, and a rerun
+			// drops them
+			publish := func(annotations map[string]string) {
+				obj := get()
+				if notice := obj.GetAnnotations()[inProgressAnnotation]; notice != "" {
+					annotations[inProgressAnnotation] = notice
+				}
+				obj.SetAnnotations(annotations)
+				g.Expect(r.Update(ctx, obj)).To(gomega.Succeed())
+			}
+			reconcile := func(prs ...*github.PullRequest) {
+				g.Expect(r.reconcileInProgress(ctx, ghClient, repoWatch, "test", "repo", prs)).To(gomega.Succeed())
+				g.Expect(r.reconcileInProgress(ctx, ghClient, repoWatch, "test", "repo", prs)).To(gomega.Succeed())
+			}
+			pr := &github.PullRequest{Number: github.Int(1)}
+
+			// The notice is posted once while the review runs
+			reconcile(pr)
+			g.Expect(requests).To(gomega.Equal([]string{tc.posted}))
+			g.Expect(get().GetAnnotations()).To(gomega.HaveKeyWithValue(inProgressAnnotation, tc.notice))
+
+			// And cleared once the draft is ready
+			publish(map[string]string{"agentDraft": "LGTM"})
+			reconcile(pr)
+			g.Expect(requests).To(gomega.Equal([]string{tc.posted, tc.cleared(draftReadyComment)}))
+			g.Expect(get().GetAnnotations()).NotTo(gomega.HaveKey(inProgressAnnotation))
+
+			// Or once the rerun fails
+			publish(map[string]string{})
+			reconcile(pr)
+			publish(map[string]string{"agentError": "failed reviewing"})
+			reconcile(pr)
+			g.Expect(requests[2:]).To(gomega.Equal([]string{tc.posted, tc.cleared(reviewFailedComment)}))
+			g.Expect(get().GetAnnotations()).NotTo(gomega.HaveKey(inProgressAnnotation))
+
+			// Or once the PR is closed
+			publish(map[string]string{})
+			reconcile(pr)
+			reconcile()
+			g.Expect(requests[4:]).To(gomega.Equal([]string{tc.posted, tc.cleared(prClosedComment)}))
+			g.Expect(get().GetAnnotations()).NotTo(gomega.HaveKey(inProgressAnnotation))
+		})
+	}
+}
+
+func TestReconcileInProgressDisabled(t *testing.T) {
+	g := gomega.NewWithT(t)
+	repoWatch := &reviewv1alpha1.RepoWatch{Spec: reviewv1alpha1.RepoWatchSpec{Review: reviewv1alpha1.PRReviewSpec{InProgress: "none"}}}
+	// Neither the sandboxes nor GitHub are read
+	r := &RepoWatchReconciler{}
+	g.Expect(r.reconcileInProgress(context.Background(), nil, repoWatch, "test", "repo", nil)).To(gomega.Succeed())
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+)
+
+var queueItems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "repowatch_work_items",
+	Help: "PRs and issues of a RepoWatch, by state: queued waiting for a sandbox or running in one. Their sum is the workers a RepoWatch needs.",
+}, []string{"namespace", "repowatch", "state"})
+
+func init() {
+	metrics.Registry.MustRegister(queueItems)
+}
+
+// ownedSandboxes returns the review and issue sandboxes of a RepoWatch.
+func (r *RepoWatchReconciler) ownedSandboxes(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch) ([]unstructured.Unstructured, error) {
+	var sandboxes []unstructured.Unstructured
+	for _, gvk := range sandboxGVKs {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk)
+		if err := r.List(ctx, list, client.InNamespace(repoWatch.Namespace)); err != nil {
+			return nil, err
+		}
+		for _, obj := range list.Items {
+			if isOwnedBy(&obj, repoWatch) {
+				sandboxes = append(sandboxes, obj)
+			}
+		}
+	}
+	return sandboxes, nil
+}
+
+// isOwnedBy returns true if a sandbox was created by the RepoWatch.
+func isOwnedBy(obj *unstructured.Unstructured, repoWatch *reviewv1alpha1.RepoWatch) bool {
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		if ownerRef.UID == repoWatch.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// workerPool admits the sandboxes of a RepoWatch to run while its workers
+// are not all busy, and counts the PRs and issues queued behind them. It
+// keeps the workers and queue depth of the status up to date. A nil pool
+// admits every sandbox.
+type workerPool struct {
+	status *reviewv1alpha1.RepoWatchStatus
+	limit  *int32
+}
+
+// newWorkerPool returns the pool of the workers of a RepoWatch, busy running
+// its scaled up sandboxes. The review is reconciled first, so PRs are
+// admitted before issues.
+func newWorkerPool(repoWatch *reviewv1alpha1.RepoWatch, sandboxes []unstructured.Unstructured) *workerPool {
+	running := int32(0)
+	for _, obj := range sandboxes {
+		if replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas"); replicas > 0 {
+			running++
+		}
+	}
+	repoWatch.Status.Workers = running
+	repoWatch.Status.QueueDepth = 0
+	repoWatch.Status.Selector = "review.gemini.google.com/repowatch=" + repoWatch.Name
+	return &workerPool{status: &repoWatch.Status, limit: repoWatch.Spec.Workers}
+}
+
+// acquire returns true and takes a worker if one is free, to start or wake a
+// sandbox.
+func (p *workerPool) acquire() bool {
+	if p == nil {
+		return true
+	}
+	if p.limit != nil && p.status.Workers >= *p.limit {
+		return false
+	}
+	p.status.Workers++
+	return true
+}
+
+// release returns a worker taken by acquire whose sandbox failed to start.
+func (p *workerPool) release() {
+	if p != nil {
+		p.status.Workers--
+	}
+}
+
+// enqueue counts a PR or issue waiting for a sandbox.
+func (p *workerPool) enqueue() {
+	if p != nil {
+		p.status.QueueDepth++
+	}
+}
+
+// setQueueMetrics exports the queue of a RepoWatch, for autoscalers to size
+// its workers.
+func setQueueMetrics(repoWatch *reviewv1alpha1.RepoWatch) {
+	queueItems.WithLabelValues(repoWatch.Namespace, repoWatch.Name, "queued").Set(float64(repoWatch.Status.QueueDepth))
+	queueItems.WithLabelValues(repoWatch.Namespace, repoWatch.Name, "running").Set(float64(repoWatch.Status.Workers))
+}
+
+// deleteQueueMetrics stops exporting the queue of a deleted RepoWatch.
+func deleteQueueMetrics(namespace, name string) {
+	queueItems.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "repowatch": name})
+}
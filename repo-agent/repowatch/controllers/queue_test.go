@@ -0,0 +1,114 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+)
+
+func TestWorkerPool(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	repoWatch := &reviewv1alpha1.RepoWatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default"},
+		Spec:       reviewv1alpha1.RepoWatchSpec{Workers: ptr.To(int32(2))},
+	}
+	running := costSandbox("IssueSandbox", "running", 1, nil)
+	stopped := costSandbox("IssueSandbox", "stopped", 0, nil)
+	workers := newWorkerPool(repoWatch, []unstructured.Unstructured{running, stopped})
+	g.Expect(repoWatch.Status.Workers).To(gomega.Equal(int32(1)))
+	g.Expect(repoWatch.Status.Selector).To(gomega.Equal("review.gemini.google.com/repowatch=rw"))
+
+	g.Expect(workers.acquire()).To(gomega.BeTrue())
+	g.Expect(workers.acquire()).To(gomega.BeFalse())
+	workers.enqueue()
+	workers.release()
+	g.Expect(workers.acquire()).To(gomega.BeTrue())
+	g.Expect(repoWatch.Status.Workers).To(gomega.Equal(int32(2)))
+	g.Expect(repoWatch.Status.QueueDepth).To(gomega.Equal(int32(1)))
+
+	setQueueMetrics(repoWatch)
+	g.Expect(testutil.ToFloat64(queueItems.WithLabelValues("default", "rw", "queued"))).To(gomega.Equal(float64(1)))
+	g.Expect(testutil.ToFloat64(queueItems.WithLabelValues("default", "rw", "running"))).To(gomega.Equal(float64(2)))
+	deleteQueueMetrics("default", "rw")
+	g.Expect(testutil.CollectAndCount(queueItems)).To(gomega.BeZero())
+
+	// Without workers, only the maxActiveSandboxes apply
+	repoWatch.Spec.Workers = nil
+	workers = newWorkerPool(repoWatch, []unstructured.Unstructured{running})
+	for range 10 {
+		g.Expect(workers.acquire()).To(gomega.BeTrue())
+	}
+	g.Expect((*workerPool)(nil).acquire()).To(gomega.BeTrue())
+}
+
+func TestReconcileReviewSandboxesWorkers(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	repoWatch := &reviewv1alpha1.RepoWatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default", UID: "rw-uid"},
+		Spec: reviewv1alpha1.RepoWatchSpec{
+			RepoURL:          "https://github.com/test/repo",
+			GithubSecretName: "github-secret",
+			Review:           reviewv1alpha1.PRReviewSpec{MaxActiveSandboxes: 10},
+			Workers:          ptr.To(int32(2)),
+		},
+	}
+	r := &RepoWatchReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(repoWatch).WithStatusSubresource(repoWatch).Build(),
+		Scheme: s,
+	}
+	var prs []*github.PullRequest
+	for i := 1; i <= 3; i++ {
+		prs = append(prs, &github.PullRequest{
+			Number:  github.Int(i),
+			Head:    &github.PullRequestBranch{Repo: &github.Repository{CloneURL: github.String("https://github.com/test/repo")}, Ref: github.String("main")},
+			HTMLURL: github.String(fmt.Sprintf("https://github.com/test/repo/pull/%d", i)),
+			DiffURL: github.String(fmt.Sprintf("https://github.com/test/repo/pull/%d.diff", i)),
+			Title:   github.String("Test PR"),
+		})
+	}
+
+	// An issue sandbox already takes one of the two workers, so a single PR
+	// is reviewed and the others are queued
+	issue := costSandbox("IssueSandbox", "issue", 1, nil)
+	workers := newWorkerPool(repoWatch, []unstructured.Unstructured{issue})
+	g.Expect(r.reconcileReviewSandboxes(ctx, repoWatch, workers, prs, &unstructured.UnstructuredList{})).To(gomega.Succeed())
+
+	g.Expect(repoWatch.Status.WatchedPRs).To(gomega.HaveLen(1))
+	g.Expect(repoWatch.Status.PendingPRs).To(gomega.HaveLen(2))
+	g.Expect(repoWatch.Status.Workers).To(gomega.Equal(int32(2)))
+	g.Expect(repoWatch.Status.QueueDepth).To(gomega.Equal(int32(2)))
+}
@@ -19,10 +19,14 @@ package controllers
 import (
 	"bytes"
 	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -31,19 +35,48 @@ import (
 	"github.com/google/go-github/v39/github"
 	"golang.org/x/oauth2"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/secretmanager"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
 	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
 )
 
+// stalledSandboxTimeout is how old the heartbeat of an active IssueSandbox
+// can be before it is reported as stalled.
+const stalledSandboxTimeout = 5 * time.Minute
+
+// commentsSeenAnnotation records how many issue comments an IssueSandbox's
+// prompt already accounts for.
+const commentsSeenAnnotation = "review.gemini.google.com/comments-seen"
+
+// demoAnnotation marks the RepoWatches seeded by review-api seed-demo. They
+// are not reconciled so that their fake sandboxes are kept and GitHub is not
+// polled.
+const demoAnnotation = "review.gemini.google.com/demo"
+
+// Prompt templates are ConfigMaps named promptTemplatePrefix + <name>, managed
+// by the review API, with one key per version.
+const (
+	promptTemplatePrefix          = "prompt-template-"
+	promptLatestVersionAnnotation = "review.gemini.google.com/latest-version"
+)
+
 // Character set for the random string
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
@@ -54,23 +87,46 @@ var seededRand = rand.New(
 
 type githubClientFactory func(ctx context.Context, k8sClient client.Client, repoWatch *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error)
 
-func NewGithubClient(ctx context.Context, k8sClient client.Client, repoWatch *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
-	secret := &corev1.Secret{}
-	secretName := repoWatch.Spec.GithubSecretName
-	if err := k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: repoWatch.Namespace}, secret); err != nil {
-		return nil, nil, err
-	}
+// NewGithubClient returns a client authenticated with the PAT of a RepoWatch,
+// decrypted with kms if it was stored encrypted or read with secrets if it is
+// kept in Secret Manager, and the git config of its user.
+func NewGithubClient(ctx context.Context, k8sClient client.Client, kms envelope.KMS, secrets secretmanager.Accessor, repoWatch *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
 	githubConfig := map[string]string{
 		"name":  "",
 		"email": "",
 	}
-	pat, ok := secret.Data["pat"]
-	if !ok {
-		return nil, nil, fmt.Errorf("\"pat\" not found in secret %s", secretName)
+	secret := &corev1.Secret{}
+	secretName := repoWatch.Spec.GithubSecretName
+	if secretName != "" {
+		if err := k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: repoWatch.Namespace}, secret); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var pat []byte
+	if name := repoWatch.Spec.SecretManager.GithubPAT; name != "" {
+		if secrets == nil {
+			return nil, nil, fmt.Errorf("secret manager is not configured to read %s", name)
+		}
+		var err error
+		if pat, err = secrets.Access(ctx, name); err != nil {
+			return nil, nil, err
+		}
+		// Secrets created with echo end with a newline
+		pat = bytes.TrimSpace(pat)
+	} else {
+		var ok bool
+		if pat, ok = secret.Data["pat"]; !ok {
+			return nil, nil, fmt.Errorf("\"pat\" not found in secret %s", secretName)
+		}
+		var err error
+		if pat, err = envelope.Open(ctx, kms, pat); err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt \"pat\" in secret %s: %w", secretName, err)
+		}
 	}
 	githubConfig["pat"] = string(pat)
 
-	_, ok = secret.Data["name"]
+	_, ok := secret.Data["name"]
 	if ok {
 		githubConfig["name"] = string(secret.Data["name"])
 	}
@@ -84,6 +140,18 @@ func NewGithubClient(ctx context.Context, k8sClient client.Client, repoWatch *re
 		&oauth2.Token{AccessToken: string(pat)},
 	)
 	tc := oauth2.NewClient(ctx, ts)
+	tc.Transport = tracing.Transport(tc.Transport)
+	if baseURL := repoWatch.Spec.GitHub.BaseURL; baseURL != "" {
+		uploadURL := repoWatch.Spec.GitHub.UploadURL
+		if uploadURL == "" {
+			uploadURL = baseURL
+		}
+		client, err := github.NewEnterpriseClient(baseURL, uploadURL, tc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid github baseURL %q: %w", baseURL, err)
+		}
+		return client, githubConfig, nil
+	}
 	return github.NewClient(tc), githubConfig, nil
 }
 
@@ -92,6 +160,18 @@ type RepoWatchReconciler struct {
 	client.Client
 	Scheme          *runtime.Scheme
 	NewGithubClient githubClientFactory
+	// KMS decrypts the credentials stored encrypted by the review API, nil
+	// if they are stored in plain
+	KMS envelope.KMS
+	// Tracer traces reconciles and the GitHub calls they make, and passes
+	// the trace to the sandboxes created, nil to only propagate it
+	Tracer *tracing.Tracer
+	// APIReader reads the objects the manager doesn't cache, e.g. the API
+	// server endpoints, the client if nil
+	APIReader client.Reader
+	// CostRates are the prices the cost reports of the RepoWatches estimate
+	// their usage at
+	CostRates CostRates
 }
 
 //+kubebuilder:rbac:groups=review.gemini.google.com,resources=repowatches,verbs=get;list;watch;create;update;patch;delete
@@ -99,19 +179,48 @@ type RepoWatchReconciler struct {
 //+kubebuilder:rbac:groups=review.gemini.google.com,resources=repowatches/finalizers,verbs=update
 //+kubebuilder:rbac:groups=custom.agents.x-k8s.io,resources=reviewsandboxes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=custom.agents.x-k8s.io,resources=issuesandboxes,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
-
-func (r *RepoWatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := log.FromContext(ctx)
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=services,verbs=get
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=list
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *RepoWatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := r.Tracer.Start(ctx, "repowatch.reconcile", tracing.KindInternal)
+	span.SetAttribute("repowatch.namespace", req.Namespace)
+	span.SetAttribute("repowatch.name", req.Name)
+	defer func() { span.End(err) }()
+	log := log.FromContext(ctx).WithValues("traceID", span.SpanContext().TraceIDString())
 
 	repoWatch := &reviewv1alpha1.RepoWatch{}
 	if err := r.Get(ctx, req.NamespacedName, repoWatch); err != nil {
 		if apierrors.IsNotFound(err) {
+			deleteCostMetrics(req.Namespace, req.Name)
+			deleteQueueMetrics(req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch RepoWatch")
 		return ctrl.Result{}, err
 	}
+	if repoWatch.Annotations[demoAnnotation] == "true" {
+		return ctrl.Result{}, nil
+	}
+
+	sandboxes, err := r.ownedSandboxes(ctx, repoWatch)
+	if err != nil {
+		log.Error(err, "unable to list sandboxes")
+		return ctrl.Result{}, err
+	}
+
+	// Account the sandboxes even if GitHub can't be reached, they still run
+	if err := r.reconcileCost(ctx, repoWatch, sandboxes); err != nil {
+		log.Error(err, "unable to report cost")
+	}
+
+	if err := r.reconcileNetworkPolicies(ctx, repoWatch, sandboxes); err != nil {
+		log.Error(err, "unable to update network policies")
+	}
 
 	ghClient, githubConfig, err := r.NewGithubClient(ctx, r.Client, repoWatch)
 	if err != nil {
@@ -126,15 +235,18 @@ func (r *RepoWatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	}
 
 	var reconcileErr error
+	workers := newWorkerPool(repoWatch, sandboxes)
+	defer setQueueMetrics(repoWatch)
+
 	// Reconcile Reviews for Pull Requests
-	if err := r.reconcileReviews(ctx, repoWatch, ghClient, owner, repo); err != nil {
+	if err := r.reconcileReviews(ctx, repoWatch, workers, ghClient, owner, repo); err != nil {
 		log.Error(err, "unable to reconcile reviews")
 		reconcileErr = errors.Join(reconcileErr, err)
 		// Continue to next reconciliation
 	}
 
 	// Reconcile Issues
-	if err := r.reconcileIssues(ctx, githubConfig, repoWatch, ghClient, owner, repo); err != nil {
+	if err := r.reconcileIssues(ctx, githubConfig, repoWatch, workers, ghClient, owner, repo); err != nil {
 		log.Error(err, "unable to reconcile issues")
 		reconcileErr = errors.Join(reconcileErr, err)
 		// Continue to next reconciliation
@@ -143,7 +255,7 @@ func (r *RepoWatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	return ctrl.Result{RequeueAfter: time.Second * time.Duration(repoWatch.Spec.PollIntervalSeconds)}, reconcileErr
 }
 
-func (r *RepoWatchReconciler) reconcileReviews(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, client *github.Client, owner string, repo string) error {
+func (r *RepoWatchReconciler) reconcileReviews(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, workers *workerPool, client *github.Client, owner string, repo string) error {
 	log := log.FromContext(ctx)
 
 	var prs []*github.PullRequest
@@ -189,8 +301,14 @@ func (r *RepoWatchReconciler) reconcileReviews(ctx context.Context, repoWatch *r
 		return err
 	}
 
+	// The notices of the closed PRs are cleared before their sandboxes are
+	// deleted
+	if err := r.reconcileInProgress(ctx, client, repoWatch, owner, repo, prs); err != nil {
+		log.Error(err, "unable to update the in-progress notices")
+	}
+
 	// Reconcile
-	if err := r.reconcileReviewSandboxes(ctx, repoWatch, prs, sandboxList); err != nil {
+	if err := r.reconcileReviewSandboxes(ctx, repoWatch, workers, prs, sandboxList); err != nil {
 		log.Error(err, "unable to reconcile sandboxes")
 		return err
 	}
@@ -198,7 +316,7 @@ func (r *RepoWatchReconciler) reconcileReviews(ctx context.Context, repoWatch *r
 	return nil
 }
 
-func (r *RepoWatchReconciler) reconcileIssues(ctx context.Context, githubConfig map[string]string, repoWatch *reviewv1alpha1.RepoWatch, ghClient *github.Client, owner string, repo string) error {
+func (r *RepoWatchReconciler) reconcileIssues(ctx context.Context, githubConfig map[string]string, repoWatch *reviewv1alpha1.RepoWatch, workers *workerPool, ghClient *github.Client, owner string, repo string) error {
 	log := log.FromContext(ctx)
 	var reconcileErr error
 
@@ -232,7 +350,7 @@ func (r *RepoWatchReconciler) reconcileIssues(ctx context.Context, githubConfig
 	log.Info("Obtained current user", "user", *user)
 
 	for _, handler := range repoWatch.Spec.IssueHandlers {
-		if err := r.reconcileIssuesForHandler(ctx, user, sandboxList, handler, repoWatch, ghClient, owner, repo, githubConfig); err != nil {
+		if err := r.reconcileIssuesForHandler(ctx, user, sandboxList, handler, repoWatch, workers, ghClient, owner, repo, githubConfig); err != nil {
 			log.Error(err, "unable to reconcile issues for handler: "+handler.Name)
 			reconcileErr = errors.Join(reconcileErr, err)
 			// Continue to next reconciliation
@@ -241,7 +359,7 @@ func (r *RepoWatchReconciler) reconcileIssues(ctx context.Context, githubConfig
 	return reconcileErr
 }
 
-func (r *RepoWatchReconciler) reconcileIssuesForHandler(ctx context.Context, user *github.User, sandboxList *unstructured.UnstructuredList, handler reviewv1alpha1.IssueHandlerSpec, repoWatch *reviewv1alpha1.RepoWatch, client *github.Client, owner string, repo string, _ map[string]string) error {
+func (r *RepoWatchReconciler) reconcileIssuesForHandler(ctx context.Context, user *github.User, sandboxList *unstructured.UnstructuredList, handler reviewv1alpha1.IssueHandlerSpec, repoWatch *reviewv1alpha1.RepoWatch, workers *workerPool, client *github.Client, owner string, repo string, _ map[string]string) error {
 	log := log.FromContext(ctx)
 
 	listOptions := &github.IssueListByRepoOptions{
@@ -299,7 +417,7 @@ func (r *RepoWatchReconciler) reconcileIssuesForHandler(ctx context.Context, use
 		return nil
 	}
 	// Reconcile
-	if err := r.reconcileIssueHandlerSandboxes(ctx, user, handler, repoWatch, repoIssues, sandboxList); err != nil {
+	if err := r.reconcileIssueHandlerSandboxes(ctx, client, user, handler, repoWatch, workers, repoIssues, sandboxList); err != nil {
 		log.Error(err, "unable to reconcile triage sandboxes")
 		return err
 	}
@@ -319,7 +437,10 @@ func parseRepoURL(repoURL string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
-func (r *RepoWatchReconciler) reconcileReviewSandboxes(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, prs []*github.PullRequest, sandboxes *unstructured.UnstructuredList) error {
+// reconcileReviewSandboxes deletes the sandboxes of closed PRs and creates
+// those of new PRs while the review and the RepoWatch have room for them,
+// queuing the others.
+func (r *RepoWatchReconciler) reconcileReviewSandboxes(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, workers *workerPool, prs []*github.PullRequest, sandboxes *unstructured.UnstructuredList) error {
 	log := log.FromContext(ctx)
 	activeSandboxes := 0
 	watchedPRs := []reviewv1alpha1.WatchedPR{}
@@ -354,6 +475,7 @@ func (r *RepoWatchReconciler) reconcileReviewSandboxes(ctx context.Context, repo
 
 		if !found {
 			log.Info("deleting sandbox for closed pr", "pr", prNumber)
+			r.countDeletedSandbox(repoWatch, &sandbox)
 			if err := r.Delete(ctx, &sandbox); err != nil {
 				log.Error(err, "unable to delete sandbox", "sandbox", sandbox.GetName())
 			}
@@ -386,10 +508,11 @@ func (r *RepoWatchReconciler) reconcileReviewSandboxes(ctx context.Context, repo
 		}
 
 		if !sandboxExists {
-			if activeSandboxes < repoWatch.Spec.Review.MaxActiveSandboxes {
+			if activeSandboxes < repoWatch.Spec.Review.MaxActiveSandboxes && workers.acquire() {
 				log.Info("creating sandbox for pr", "pr", *pr.Number)
 				if err := r.createReviewSandboxForPR(ctx, repoWatch, pr); err != nil {
 					log.Error(err, "unable to create sandbox for pr", "pr", *pr.Number)
+					workers.release()
 				} else {
 					activeSandboxes++
 					watchedPRs = append(watchedPRs, reviewv1alpha1.WatchedPR{
@@ -399,6 +522,7 @@ func (r *RepoWatchReconciler) reconcileReviewSandboxes(ctx context.Context, repo
 					})
 				}
 			} else {
+				workers.enqueue()
 				pendingPRs = append(pendingPRs, reviewv1alpha1.PendingPR{
 					Number: *pr.Number,
 					Status: "Pending",
@@ -414,7 +538,10 @@ func (r *RepoWatchReconciler) reconcileReviewSandboxes(ctx context.Context, repo
 	return r.Status().Update(ctx, repoWatch)
 }
 
-func (r *RepoWatchReconciler) reconcileIssueHandlerSandboxes(ctx context.Context, user *github.User, handler reviewv1alpha1.IssueHandlerSpec, repoWatch *reviewv1alpha1.RepoWatch, issues []*github.Issue, sandboxes *unstructured.UnstructuredList) error {
+// reconcileIssueHandlerSandboxes deletes the sandboxes of the closed issues of
+// a handler, and creates or wakes those of open issues while the handler and
+// the RepoWatch have room for them, queuing the others.
+func (r *RepoWatchReconciler) reconcileIssueHandlerSandboxes(ctx context.Context, ghClient *github.Client, user *github.User, handler reviewv1alpha1.IssueHandlerSpec, repoWatch *reviewv1alpha1.RepoWatch, workers *workerPool, issues []*github.Issue, sandboxes *unstructured.UnstructuredList) error {
 	log := log.FromContext(ctx)
 	activeSandboxes := 0
 	watchedIssues := []reviewv1alpha1.WatchedIssue{}
@@ -468,6 +595,7 @@ func (r *RepoWatchReconciler) reconcileIssueHandlerSandboxes(ctx context.Context
 
 		if !found {
 			log.Info("deleting sandbox for closed issue", "issue", issueNumber)
+			r.countDeletedSandbox(repoWatch, &sandbox)
 			if err := r.Delete(ctx, &sandbox); err != nil {
 				log.Error(err, "unable to delete sandbox", "sandbox", sandbox.GetName())
 			}
@@ -486,23 +614,42 @@ func (r *RepoWatchReconciler) reconcileIssueHandlerSandboxes(ctx context.Context
 					log.Error(err, "unable to get replicas for sandbox", "sandbox", sandbox.GetName())
 					break
 				}
+				if replicas == 0 && handler.RespondToComments && activeSandboxes < handler.MaxActiveSandboxes && workers.acquire() {
+					woken, err := r.wakeSandboxForComments(ctx, ghClient, user, repoWatch, issue, &sandbox)
+					if err != nil {
+						log.Error(err, "unable to wake sandbox for new issue comments", "sandbox", sandbox.GetName())
+					} else if woken {
+						replicas = 1
+					}
+					if replicas == 0 {
+						workers.release()
+					}
+				}
 				if replicas > 0 {
 					activeSandboxes++
 				}
+				status := "Active"
+				if replicas > 0 && isSandboxStalled(&sandbox, time.Now()) {
+					log.Info("sandbox heartbeat is stale", "sandbox", sandbox.GetName())
+					status = "Stalled"
+				}
+				phase, _, _ := unstructured.NestedString(sandbox.Object, "status", "phase")
 				watchedIssues = append(watchedIssues, reviewv1alpha1.WatchedIssue{
 					Number:      *issue.Number,
 					SandboxName: sandboxName,
-					Status:      "Active",
+					Status:      status,
+					Phase:       phase,
 				})
 				break
 			}
 		}
 
 		if !sandboxExists {
-			if activeSandboxes < handler.MaxActiveSandboxes {
+			if activeSandboxes < handler.MaxActiveSandboxes && workers.acquire() {
 				log.Info("creating sandbox for issue", "issue", *issue.Number)
 				if err := r.createSandboxForIssueHandler(ctx, user, handler, repoWatch, issue); err != nil {
 					log.Error(err, "unable to create sandbox for issue", "issue", *issue.Number)
+					workers.release()
 				} else {
 					activeSandboxes++
 					watchedIssues = append(watchedIssues, reviewv1alpha1.WatchedIssue{
@@ -512,6 +659,7 @@ func (r *RepoWatchReconciler) reconcileIssueHandlerSandboxes(ctx context.Context
 					})
 				}
 			} else {
+				workers.enqueue()
 				pendingIssues = append(pendingIssues, reviewv1alpha1.PendingIssue{
 					Number: *issue.Number,
 					Status: "Pending",
@@ -532,10 +680,138 @@ func (r *RepoWatchReconciler) reconcileIssueHandlerSandboxes(ctx context.Context
 	return r.Status().Update(ctx, repoWatch)
 }
 
+// isSandboxStalled reports whether the heartbeat published by the sandbox's
+// sidecar is older than stalledSandboxTimeout. Sandboxes that have not
+// published a heartbeat yet, or whose run has ended, are not stalled.
+func isSandboxStalled(sandbox *unstructured.Unstructured, now time.Time) bool {
+	phase, _, _ := unstructured.NestedString(sandbox.Object, "status", "phase")
+	if phase == "Completed" || phase == "Failed" {
+		return false
+	}
+	heartbeat, found, err := unstructured.NestedString(sandbox.Object, "status", "heartbeat")
+	if err != nil || !found {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, heartbeat)
+	if err != nil {
+		return false
+	}
+	return now.Sub(t) > stalledSandboxTimeout
+}
+
+// wakeSandboxForComments appends comments posted on the issue since the
+// sandbox last ran to its prompt and scales it back up. Comments made by the
+// robot user are ignored. It returns true if the sandbox was scaled up.
+func (r *RepoWatchReconciler) wakeSandboxForComments(ctx context.Context, ghClient *github.Client, user *github.User, repoWatch *reviewv1alpha1.RepoWatch, issue *github.Issue, sandbox *unstructured.Unstructured) (bool, error) {
+	log := log.FromContext(ctx)
+
+	seen, _ := strconv.Atoi(sandbox.GetAnnotations()[commentsSeenAnnotation])
+	if issue.GetComments() <= seen {
+		return false, nil
+	}
+
+	owner, repo, err := parseRepoURL(repoWatch.Spec.RepoURL)
+	if err != nil {
+		return false, err
+	}
+
+	var comments []*github.IssueComment
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := ghClient.Issues.ListComments(ctx, owner, repo, issue.GetNumber(), opts)
+		if err != nil {
+			return false, fmt.Errorf("unable to list comments for issue %d: %w", issue.GetNumber(), err)
+		}
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if seen > len(comments) {
+		seen = len(comments)
+	}
+
+	prompt, _, err := unstructured.NestedString(sandbox.Object, "spec", "llm", "prompt")
+	if err != nil {
+		return false, err
+	}
+	newPrompt := appendFollowUpComments(prompt, comments[seen:], user.GetLogin())
+
+	annotations := sandbox.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[commentsSeenAnnotation] = strconv.Itoa(len(comments))
+	sandbox.SetAnnotations(annotations)
+
+	woken := newPrompt != prompt
+	if woken {
+		log.Info("waking sandbox for new issue comments", "sandbox", sandbox.GetName(), "issue", issue.GetNumber())
+		if err := unstructured.SetNestedField(sandbox.Object, newPrompt, "spec", "llm", "prompt"); err != nil {
+			return false, err
+		}
+		if err := unstructured.SetNestedField(sandbox.Object, int64(1), "spec", "replicas"); err != nil {
+			return false, err
+		}
+	}
+	if err := r.Update(ctx, sandbox); err != nil {
+		return false, err
+	}
+	return woken, nil
+}
+
+// appendFollowUpComments appends the comments not written by robotLogin to
+// the prompt as feedback to address.
+func appendFollowUpComments(prompt string, comments []*github.IssueComment, robotLogin string) string {
+	var b strings.Builder
+	for _, comment := range comments {
+		if comment.GetUser().GetLogin() == robotLogin {
+			continue
+		}
+		fmt.Fprintf(&b, "\n@%s wrote:\n%s\n", comment.GetUser().GetLogin(), comment.GetBody())
+	}
+	if b.Len() == 0 {
+		return prompt
+	}
+	return prompt + "\n\nThe following comments were posted on the issue after your last changes. Address this feedback with further changes on the same branch:\n" + b.String()
+}
+
+// resolvePrompt returns the prompt of an LLM configuration, read from its
+// prompt template if it references one.
+func (r *RepoWatchReconciler) resolvePrompt(ctx context.Context, namespace string, llm reviewv1alpha1.LLMConfig) (string, error) {
+	ref := llm.PromptTemplateRef
+	if ref == nil || ref.Name == "" {
+		return llm.Prompt, nil
+	}
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: promptTemplatePrefix + ref.Name}, cm); err != nil {
+		return "", fmt.Errorf("failed to get prompt template %s: %w", ref.Name, err)
+	}
+	version := ref.Version
+	if version == 0 {
+		latest, err := strconv.Atoi(cm.Annotations[promptLatestVersionAnnotation])
+		if err != nil {
+			return "", fmt.Errorf("prompt template %s has no valid latest version: %w", ref.Name, err)
+		}
+		version = latest
+	}
+	prompt, ok := cm.Data[fmt.Sprintf("v%d", version)]
+	if !ok {
+		return "", fmt.Errorf("prompt template %s has no version %d", ref.Name, version)
+	}
+	return prompt, nil
+}
+
 // generateReviewPrompt generates a prompt for a pull request review.
 // It uses the prompt specified in the RepoWatch CRD, and if it is not
 // specified, it uses a default prompt.
-func (r *RepoWatchReconciler) generateReviewPrompt(repoWatch *reviewv1alpha1.RepoWatch, pr *github.PullRequest) (string, error) {
+func (r *RepoWatchReconciler) generateReviewPrompt(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, pr *github.PullRequest) (string, error) {
+	prompt, err := r.resolvePrompt(ctx, repoWatch.Namespace, repoWatch.Spec.Review.LLM)
+	if err != nil {
+		return "", err
+	}
+
 	// Level 1 substitution
 	promptTmpl := reviewPromptTemplate
 
@@ -544,7 +820,7 @@ func (r *RepoWatchReconciler) generateReviewPrompt(repoWatch *reviewv1alpha1.Rep
 		Prompt string
 	}{
 		PullRequest: *pr,
-		Prompt:      repoWatch.Spec.Review.LLM.Prompt,
+		Prompt:      prompt,
 	}
 
 	lvl1, err := template.New("lvl1").Parse(promptTmpl)
@@ -574,9 +850,12 @@ func (r *RepoWatchReconciler) generateReviewPrompt(repoWatch *reviewv1alpha1.Rep
 
 // generateIssueHandlerPrompt generates a prompt for an issue handler.
 // It uses the prompt specified in the RepoWatch CRD.
-func (r *RepoWatchReconciler) generateIssueHandlerPrompt(handler reviewv1alpha1.IssueHandlerSpec, issue *github.Issue) (string, error) {
+func (r *RepoWatchReconciler) generateIssueHandlerPrompt(ctx context.Context, namespace string, handler reviewv1alpha1.IssueHandlerSpec, issue *github.Issue) (string, error) {
 	// promptTmpl := "You are an expert kubernetes developer who is helping with bug triage. Please look at the issue {{.Number}} linked at {{.HTMLURL}} and provide a triage summary. Please suggest possible causes and solutions."
-	promptTmpl := handler.LLM.Prompt
+	promptTmpl, err := r.resolvePrompt(ctx, namespace, handler.LLM)
+	if err != nil {
+		return "", err
+	}
 	tmpl, err := template.New("myTemplate").Parse(promptTmpl)
 	if err != nil {
 		return "", err
@@ -590,6 +869,42 @@ func (r *RepoWatchReconciler) generateIssueHandlerPrompt(handler reviewv1alpha1.
 	return buf.String(), nil
 }
 
+const (
+	defaultPullRequestTitle = "Fix #{{.Number}}: {{.Title}}"
+	defaultPullRequestBody  = "Fixes {{.HTMLURL}}"
+)
+
+// generatePullRequestText renders the title and body of the pull request that
+// the issue sandbox opens for an issue. It falls back to default templates
+// when the handler does not specify them.
+func (r *RepoWatchReconciler) generatePullRequestText(handler reviewv1alpha1.IssueHandlerSpec, issue *github.Issue) (string, string, error) {
+	titleTmpl := handler.PullRequest.Title
+	if titleTmpl == "" {
+		titleTmpl = defaultPullRequestTitle
+	}
+	bodyTmpl := handler.PullRequest.Body
+	if bodyTmpl == "" {
+		bodyTmpl = defaultPullRequestBody
+	}
+
+	var title, body bytes.Buffer
+	tmpl, err := template.New("prTitle").Parse(titleTmpl)
+	if err != nil {
+		return "", "", err
+	}
+	if err := tmpl.Execute(&title, issue); err != nil {
+		return "", "", err
+	}
+	tmpl, err = template.New("prBody").Parse(bodyTmpl)
+	if err != nil {
+		return "", "", err
+	}
+	if err := tmpl.Execute(&body, issue); err != nil {
+		return "", "", err
+	}
+	return title.String(), body.String(), nil
+}
+
 // createReviewSandboxForPR creates a ReviewSandbox for a pull request.
 // It uses the LLM configuration from the RepoWatch CRD to configure the
 // sandbox.
@@ -598,7 +913,7 @@ func (r *RepoWatchReconciler) createReviewSandboxForPR(ctx context.Context, repo
 	repoName := strings.Split(repoWatch.Spec.RepoURL, "/")[len(strings.Split(repoWatch.Spec.RepoURL, "/"))-1]
 	sandboxName := fmt.Sprintf("%s-pr-%d", repoName, *pr.Number)
 
-	prompt, err := r.generateReviewPrompt(repoWatch, pr)
+	prompt, err := r.generateReviewPrompt(ctx, repoWatch, pr)
 	if err != nil {
 		return err
 	}
@@ -622,6 +937,7 @@ func (r *RepoWatchReconciler) createReviewSandboxForPR(ctx context.Context, repo
 				"llm": map[string]interface{}{
 					"configdirRef": repoWatch.Spec.Review.LLM.ConfigdirRef,
 					"prompt":       prompt,
+					"transcript":   repoWatch.Spec.Review.LLM.Transcript,
 				},
 				"source": map[string]interface{}{
 					"cloneURL": fmt.Sprintf("%s#refs/heads/%s", *pr.Head.Repo.CloneURL, *pr.Head.Ref),
@@ -645,11 +961,103 @@ func (r *RepoWatchReconciler) createReviewSandboxForPR(ctx context.Context, repo
 		}
 	}
 
+	if err := r.setCodeServer(ctx, repoWatch, sandbox, repoWatch.Spec.Review.CodeServer); err != nil {
+		return err
+	}
+
+	if err := r.setTokensSecret(ctx, repoWatch, sandbox, repoWatch.Spec.Review.LLM.APIKeySecretRef); err != nil {
+		return err
+	}
+
+	if err := setResources(sandbox, repoWatch.Spec.Review.Resources); err != nil {
+		return err
+	}
+
+	if err := setPriorityClass(sandbox, repoWatch.Spec.Review.PriorityClassName); err != nil {
+		return err
+	}
+
+	if err := setArtifacts(sandbox, repoWatch.Spec.Artifacts); err != nil {
+		return err
+	}
+
+	if err := r.setGithubSecret(ctx, repoWatch, sandbox); err != nil {
+		return err
+	}
+
+	if err := setClone(sandbox, repoWatch.Spec.Clone); err != nil {
+		return err
+	}
+
+	if err := setSecurityContext(sandbox, repoWatch.Spec.SandboxSecurity); err != nil {
+		return err
+	}
+
+	if err := r.setNetworkPolicy(ctx, repoWatch, sandbox, repoWatch.Spec.Review.LLM.Provider); err != nil {
+		return err
+	}
+
+	if err := r.setTracing(ctx, sandbox); err != nil {
+		return err
+	}
+
 	if err := controllerutil.SetControllerReference(repoWatch, sandbox, r.Scheme); err != nil {
 		return err
 	}
 
-	return r.Create(ctx, sandbox)
+	return r.createSandbox(ctx, repoWatch, sandbox)
+}
+
+// createSandbox creates a sandbox and hands it the secrets created for it.
+// Sandboxes are named after their PR or issue, so one that already exists,
+// e.g. created by the previous leader and not in the cache yet, is the same
+// sandbox and is left as is.
+func (r *RepoWatchReconciler) createSandbox(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured) error {
+	if err := r.Create(ctx, sandbox); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(sandbox.GroupVersionKind())
+		if err := r.Get(ctx, client.ObjectKeyFromObject(sandbox), existing); err != nil {
+			return err
+		}
+		sandbox = existing
+	}
+	return r.adoptSandboxSecrets(ctx, repoWatch, sandbox)
+}
+
+// sandboxSecretSuffixes are those of the secrets created for a sandbox and
+// named after it.
+var sandboxSecretSuffixes = []string{"-code-server", "-tokens", "-github"}
+
+// adoptSandboxSecrets makes a sandbox the owner of the secrets created for it,
+// owned by its RepoWatch until the sandbox exists, so that they are deleted
+// with the sandbox rather than with the RepoWatch.
+func (r *RepoWatchReconciler) adoptSandboxSecrets(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured) error {
+	for _, suffix := range sandboxSecretSuffixes {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: sandbox.GetName() + suffix, Namespace: sandbox.GetNamespace()}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		// Also those of a former sandbox of the same name, not collected yet
+		owner := metav1.GetControllerOf(secret)
+		former := owner != nil && owner.Kind == sandbox.GetKind() && owner.Name == sandbox.GetName() && owner.UID != sandbox.GetUID()
+		if !metav1.IsControlledBy(secret, repoWatch) && !former {
+			continue
+		}
+		secret.OwnerReferences = nil
+		if err := controllerutil.SetControllerReference(sandbox, secret, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Update(ctx, secret); err != nil {
+			return fmt.Errorf("failed to hand secret %s to its sandbox: %w", secret.Name, err)
+		}
+	}
+	return nil
 }
 
 // randString generates a random string of length n.
@@ -667,6 +1075,678 @@ func randString(n int) string {
 	return string(b)
 }
 
+// setCodeServer configures the code-server of a sandbox. With password auth,
+// it also creates the secret holding the generated password.
+func (r *RepoWatchReconciler) setCodeServer(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured, spec reviewv1alpha1.CodeServerSpec) error {
+	codeServer := map[string]interface{}{
+		"enabled": !spec.Disabled,
+	}
+	if spec.Port > 0 {
+		codeServer["port"] = int64(spec.Port)
+	}
+	if spec.Auth != "" {
+		codeServer["auth"] = spec.Auth
+	}
+	if err := unstructured.SetNestedMap(sandbox.Object, codeServer, "spec", "codeServer"); err != nil {
+		return err
+	}
+	if spec.Disabled || spec.Auth != "password" {
+		return nil
+	}
+	return r.ensureCodeServerSecret(ctx, repoWatch, sandbox.GetName())
+}
+
+// setResources sets the resource requests and limits of the sandbox
+// container. Both are always set, since the sandbox template references them.
+func setResources(sandbox *unstructured.Unstructured, resources corev1.ResourceRequirements) error {
+	if err := unstructured.SetNestedMap(sandbox.Object, resourceListToMap(resources.Requests), "spec", "resources", "requests"); err != nil {
+		return err
+	}
+	return unstructured.SetNestedMap(sandbox.Object, resourceListToMap(resources.Limits), "spec", "resources", "limits")
+}
+
+// setPriorityClass sets the priority class of the pod of a sandbox, left to
+// the cluster default if empty.
+func setPriorityClass(sandbox *unstructured.Unstructured, priorityClassName string) error {
+	if priorityClassName == "" {
+		return nil
+	}
+	return unstructured.SetNestedField(sandbox.Object, priorityClassName, "spec", "priorityClassName")
+}
+
+func resourceListToMap(list corev1.ResourceList) map[string]interface{} {
+	m := map[string]interface{}{}
+	for name, quantity := range list {
+		m[string(name)] = quantity.String()
+	}
+	return m
+}
+
+// setClone configures how a sandbox clones the repository. The clone cache
+// volume is always set, as an empty dir if no cache is configured, since the
+// sandbox template references it.
+func setClone(sandbox *unstructured.Unstructured, spec reviewv1alpha1.CloneSpec) error {
+	cacheVolume := map[string]interface{}{
+		"name":     "clone-cache",
+		"emptyDir": map[string]interface{}{},
+	}
+	if spec.CacheClaimName != "" {
+		delete(cacheVolume, "emptyDir")
+		cacheVolume["persistentVolumeClaim"] = map[string]interface{}{
+			"claimName": spec.CacheClaimName,
+			"readOnly":  true,
+		}
+	}
+	clone := map[string]interface{}{
+		"depth":       int64(spec.Depth),
+		"filter":      spec.Filter,
+		"cacheVolume": cacheVolume,
+	}
+	return unstructured.SetNestedMap(sandbox.Object, clone, "spec", "clone")
+}
+
+// sidecarUser is the nonroot user of the ko images of the sidecars.
+const sidecarUser = 65532
+
+// setSecurityContext sets the security contexts of the pod and of the init,
+// sidecar and agent containers of a sandbox. They are always set, empty if
+// disabled, since the sandbox template references them.
+func setSecurityContext(sandbox *unstructured.Unstructured, spec reviewv1alpha1.SandboxSecuritySpec) error {
+	pod := &corev1.PodSecurityContext{}
+	initContainers := &corev1.SecurityContext{}
+	sidecar := &corev1.SecurityContext{}
+	agent := &corev1.SecurityContext{}
+	if !spec.Disabled {
+		pod.SeccompProfile = spec.SeccompProfile
+		if pod.SeccompProfile == nil {
+			pod.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+		pod.AppArmorProfile = spec.AppArmorProfile
+
+		// The init containers write to the workspace as root, which may
+		// already be owned by the devcontainer user
+		initContainers = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: ptr.To(false),
+			ReadOnlyRootFilesystem:   ptr.To(true),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  []corev1.Capability{"CHOWN", "DAC_OVERRIDE", "FOWNER"},
+			},
+		}
+		// The sidecar only reads the workspace and calls APIs
+		sidecar = &corev1.SecurityContext{
+			RunAsNonRoot:             ptr.To(true),
+			RunAsUser:                ptr.To[int64](sidecarUser),
+			AllowPrivilegeEscalation: ptr.To(false),
+			ReadOnlyRootFilesystem:   ptr.To(true),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		}
+		agent.AllowPrivilegeEscalation = ptr.To(spec.AllowPrivilegeEscalation)
+	}
+
+	securityContext := map[string]interface{}{}
+	for name, object := range map[string]interface{}{"pod": pod, "initContainers": initContainers, "sidecar": sidecar, "agent": agent} {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return err
+		}
+		securityContext[name] = u
+	}
+	return unstructured.SetNestedMap(sandbox.Object, securityContext, "spec", "securityContext")
+}
+
+// setArtifacts configures where a sandbox uploads its run artifacts.
+func setArtifacts(sandbox *unstructured.Unstructured, spec reviewv1alpha1.ArtifactsSpec) error {
+	if spec.BucketURL == "" {
+		return nil
+	}
+	artifacts := map[string]interface{}{
+		"bucketURL": spec.BucketURL,
+	}
+	if spec.CredentialsSecretName != "" {
+		artifacts["credentialsSecretName"] = spec.CredentialsSecretName
+	}
+	return unstructured.SetNestedMap(sandbox.Object, artifacts, "spec", "artifacts")
+}
+
+// setTracing passes the trace of the reconcile creating sandbox to it, to be
+// continued by its sidecar and by the review API posting its review.
+func (r *RepoWatchReconciler) setTracing(ctx context.Context, sandbox *unstructured.Unstructured) error {
+	traceparent := tracing.SpanFromContext(ctx).SpanContext().Traceparent()
+	if traceparent == "" {
+		return nil
+	}
+	spec := map[string]interface{}{
+		"traceparent": traceparent,
+	}
+	if endpoint := r.Tracer.Endpoint(); endpoint != "" {
+		spec["endpoint"] = endpoint
+	}
+	return unstructured.SetNestedMap(sandbox.Object, spec, "spec", "tracing")
+}
+
+// defaultLLMEndpoints are the hosts of the API of each LLM provider. The
+// Gemini CLI also refreshes its OAuth credentials and may use Code Assist.
+var defaultLLMEndpoints = map[string][]string{
+	reviewv1alpha1.GeminiProvider: {"generativelanguage.googleapis.com", "oauth2.googleapis.com", "cloudcode-pa.googleapis.com"},
+}
+
+// metadataServerCIDRs are the addresses of the metadata server minting the
+// tokens of the Google APIs: that of Compute Engine, on port 80, and that of
+// GKE Workload Identity, on port 988.
+var metadataServerCIDRs = []string{"169.254.169.252/32", "169.254.169.254/32"}
+
+// lookupIPAddr resolves the hosts of the NetworkPolicies, replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// setNetworkPolicy configures the NetworkPolicy of a sandbox running an
+// llmProvider agent, if enabled. Its egress is limited to the cluster DNS
+// and API server and, on port 443, to GitHub, the LLM endpoints and the
+// allowed destinations. Sandboxes reading their GitHub PAT from Secret
+// Manager or uploading their artifacts to GCS may also reach the metadata
+// server and those APIs, and traced sandboxes the OTLP collector. It runs
+// after the other fields of the sandbox are set.
+func (r *RepoWatchReconciler) setNetworkPolicy(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured, llmProvider string) error {
+	if !repoWatch.Spec.NetworkPolicy.Enabled {
+		return nil
+	}
+	shared, err := r.sharedEgress(ctx, repoWatch)
+	if err != nil {
+		return err
+	}
+	networkPolicy, err := r.networkPolicy(ctx, repoWatch, shared, sandbox, llmProvider)
+	if err != nil {
+		return err
+	}
+	return unstructured.SetNestedMap(sandbox.Object, networkPolicy, "spec", "networkPolicy")
+}
+
+// reconcileNetworkPolicies resolves the NetworkPolicies of the sandboxes of a
+// RepoWatch again and updates those whose addresses changed, e.g. those of
+// GitHub or of the LLM endpoints, so that long running sandboxes keep
+// reaching them.
+func (r *RepoWatchReconciler) reconcileNetworkPolicies(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandboxes []unstructured.Unstructured) error {
+	if !repoWatch.Spec.NetworkPolicy.Enabled || len(sandboxes) == 0 {
+		return nil
+	}
+	log := log.FromContext(ctx)
+	shared, err := r.sharedEgress(ctx, repoWatch)
+	if err != nil {
+		return err
+	}
+	var errs error
+	for i := range sandboxes {
+		obj := &sandboxes[i]
+		if obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+		networkPolicy, err := r.networkPolicy(ctx, repoWatch, shared, obj, sandboxLLMProvider(repoWatch, obj))
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		current, _, _ := unstructured.NestedMap(obj.Object, "spec", "networkPolicy")
+		if equality.Semantic.DeepEqual(current, networkPolicy) {
+			continue
+		}
+		log.Info("updating the network policy of sandbox", "name", obj.GetName())
+		orig := obj.DeepCopy()
+		if err := unstructured.SetNestedMap(obj.Object, networkPolicy, "spec", "networkPolicy"); err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if err := r.Patch(ctx, obj, client.MergeFrom(orig)); err != nil && !apierrors.IsNotFound(err) {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
+
+// sandboxLLMProvider returns the LLM provider of the agent of a sandbox: that
+// of the reviews or of the handler of an issue sandbox.
+func sandboxLLMProvider(repoWatch *reviewv1alpha1.RepoWatch, obj *unstructured.Unstructured) string {
+	if obj.GetKind() == "ReviewSandbox" {
+		return repoWatch.Spec.Review.LLM.Provider
+	}
+	name, _, _ := unstructured.NestedString(obj.Object, "spec", "source", "handler")
+	for _, handler := range repoWatch.Spec.IssueHandlers {
+		if handler.Name == name {
+			return handler.LLM.Provider
+		}
+	}
+	return ""
+}
+
+// sharedEgress is the egress of the NetworkPolicies common to the sandboxes
+// of a RepoWatch, resolved once for all of them.
+type sharedEgress struct {
+	apiServer networkingv1.NetworkPolicyEgressRule
+	github    []string
+}
+
+func (r *RepoWatchReconciler) sharedEgress(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch) (*sharedEgress, error) {
+	apiServer, err := r.apiServerEgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := r.githubEndpoints(ctx, repoWatch)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedEgress{apiServer: apiServer, github: endpoints}, nil
+}
+
+// networkPolicy returns the networkPolicy field of a sandbox, see
+// setNetworkPolicy.
+func (r *RepoWatchReconciler) networkPolicy(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, shared *sharedEgress, sandbox *unstructured.Unstructured, llmProvider string) (map[string]interface{}, error) {
+	spec := repoWatch.Spec.NetworkPolicy
+	endpoints := append([]string{}, shared.github...)
+	if len(spec.LLMEndpoints) > 0 {
+		endpoints = append(endpoints, spec.LLMEndpoints...)
+	} else {
+		if llmProvider == "" {
+			llmProvider = reviewv1alpha1.GeminiProvider
+		}
+		endpoints = append(endpoints, defaultLLMEndpoints[llmProvider]...)
+	}
+	endpoints = append(endpoints, spec.AllowedEgress...)
+	googleAPIs := sandboxGoogleAPIs(sandbox)
+	endpoints = append(endpoints, googleAPIs...)
+	https, err := ipBlockEgress(ctx, endpoints, 443)
+	if err != nil {
+		return nil, err
+	}
+	dns := networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"}},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+		}},
+		Ports: []networkingv1.NetworkPolicyPort{networkPolicyPort(corev1.ProtocolUDP, 53), networkPolicyPort(corev1.ProtocolTCP, 53)},
+	}
+
+	ingress := networkingv1.NetworkPolicyIngressRule{}
+	if len(spec.IngressPodLabels) > 0 || len(spec.IngressNamespaceLabels) > 0 {
+		peer := networkingv1.NetworkPolicyPeer{}
+		if len(spec.IngressPodLabels) > 0 {
+			peer.PodSelector = &metav1.LabelSelector{MatchLabels: spec.IngressPodLabels}
+		}
+		if len(spec.IngressNamespaceLabels) > 0 {
+			peer.NamespaceSelector = &metav1.LabelSelector{MatchLabels: spec.IngressNamespaceLabels}
+		}
+		ingress.From = []networkingv1.NetworkPolicyPeer{peer}
+	}
+
+	apiServer := shared.apiServer
+	rules := []interface{}{&dns, &apiServer, &https}
+	if len(googleAPIs) > 0 {
+		metadata, err := ipBlockEgress(ctx, metadataServerCIDRs, 80, 988)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &metadata)
+	}
+	if host, port, ok := otlpEndpoint(r.Tracer.Endpoint()); ok {
+		otlp, err := ipBlockEgress(ctx, []string{host}, port)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &otlp)
+	}
+	egressRules, err := toUnstructuredList(rules...)
+	if err != nil {
+		return nil, err
+	}
+	ingressRules, err := toUnstructuredList(&ingress)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"enabled": true,
+		"egress":  map[string]interface{}{"rules": egressRules},
+		"ingress": map[string]interface{}{"rules": ingressRules},
+	}, nil
+}
+
+// sandboxGoogleAPIs returns the hosts of the Google APIs a sandbox uses with
+// the token of the metadata server: Secret Manager for its GitHub PAT and
+// GCS for its artifacts.
+func sandboxGoogleAPIs(sandbox *unstructured.Unstructured) []string {
+	var hosts []string
+	if name, _, _ := unstructured.NestedString(sandbox.Object, "spec", "githubTokenSecret"); name != "" {
+		hosts = append(hosts, "secretmanager.googleapis.com")
+	}
+	if bucketURL, _, _ := unstructured.NestedString(sandbox.Object, "spec", "artifacts", "bucketURL"); strings.HasPrefix(bucketURL, "gs://") {
+		hosts = append(hosts, "storage.googleapis.com")
+	}
+	return hosts
+}
+
+// otlpEndpoint returns the host and port of the OTLP endpoint URL the
+// sandboxes export their spans to, if any.
+func otlpEndpoint(endpoint string) (string, int32, bool) {
+	u, err := url.Parse(endpoint)
+	if endpoint == "" || err != nil || u.Hostname() == "" {
+		return "", 0, false
+	}
+	port := int32(80)
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if p, err := strconv.ParseInt(u.Port(), 10, 32); err == nil {
+		port = int32(p)
+	}
+	return u.Hostname(), port, true
+}
+
+// ipBlockEgress allows the TCP ports of the CIDRs of endpoints.
+func ipBlockEgress(ctx context.Context, endpoints []string, ports ...int32) (networkingv1.NetworkPolicyEgressRule, error) {
+	rule := networkingv1.NetworkPolicyEgressRule{}
+	cidrs, err := resolveCIDRs(ctx, endpoints)
+	if err != nil {
+		return rule, err
+	}
+	for _, cidr := range cidrs {
+		rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	for _, port := range ports {
+		rule.Ports = append(rule.Ports, networkPolicyPort(corev1.ProtocolTCP, port))
+	}
+	return rule, nil
+}
+
+// apiServerEgress allows the sidecars to reach the API server, both through
+// the kubernetes service and at its endpoints since network plugins may
+// apply policies after translating the service address.
+func (r *RepoWatchReconciler) apiServerEgress(ctx context.Context) (networkingv1.NetworkPolicyEgressRule, error) {
+	rule := networkingv1.NetworkPolicyEgressRule{}
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	service := &corev1.Service{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "kubernetes"}, service); err != nil {
+		return rule, fmt.Errorf("failed to get the kubernetes service: %w", err)
+	}
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := reader.List(ctx, slices, client.InNamespace(metav1.NamespaceDefault), client.MatchingLabels{discoveryv1.LabelServiceName: "kubernetes"}); err != nil {
+		return rule, fmt.Errorf("failed to list the kubernetes endpoints: %w", err)
+	}
+
+	addresses := append([]string{}, service.Spec.ClusterIPs...)
+	ports := map[int32]bool{}
+	for _, port := range service.Spec.Ports {
+		ports[port.Port] = true
+	}
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			addresses = append(addresses, endpoint.Addresses...)
+		}
+		for _, port := range slice.Ports {
+			if port.Port != nil {
+				ports[*port.Port] = true
+			}
+		}
+	}
+	return ipBlockEgress(ctx, addresses, sortedPorts(ports)...)
+}
+
+// githubEndpoints returns the CIDRs GitHub publishes for its API, git and
+// web hosts, or the API host for a GitHub Enterprise Server publishing none.
+func (r *RepoWatchReconciler) githubEndpoints(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch) ([]string, error) {
+	ghClient, _, err := r.NewGithubClient(ctx, r.Client, repoWatch)
+	if err != nil {
+		return nil, err
+	}
+	req, err := ghClient.NewRequest("GET", "meta", nil)
+	if err != nil {
+		return nil, err
+	}
+	// github.APIMeta of this version lacks the api and web CIDRs
+	meta := struct {
+		API []string `json:"api"`
+		Git []string `json:"git"`
+		Web []string `json:"web"`
+	}{}
+	if _, err := ghClient.Do(ctx, req, &meta); err != nil {
+		return nil, fmt.Errorf("failed to get the github meta: %w", err)
+	}
+	endpoints := append(append(append([]string{}, meta.API...), meta.Git...), meta.Web...)
+	if len(endpoints) == 0 {
+		endpoints = append(endpoints, ghClient.BaseURL.Hostname())
+	}
+	if repoWatch.Spec.GitHub.BaseURL == "" {
+		// The diffs of github.com PRs are served from there
+		endpoints = append(endpoints, "patch-diff.githubusercontent.com")
+	}
+	return endpoints, nil
+}
+
+// resolveCIDRs returns the sorted CIDRs of endpoints, which are CIDRs, IPs
+// or hosts.
+func resolveCIDRs(ctx context.Context, endpoints []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, endpoint := range endpoints {
+		if _, ipNet, err := net.ParseCIDR(endpoint); err == nil {
+			set[ipNet.String()] = true
+			continue
+		}
+		if ip := net.ParseIP(endpoint); ip != nil {
+			set[hostCIDR(ip)] = true
+			continue
+		}
+		addrs, err := lookupIPAddr(ctx, endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", endpoint, err)
+		}
+		for _, addr := range addrs {
+			set[hostCIDR(addr.IP)] = true
+		}
+	}
+	cidrs := make([]string, 0, len(set))
+	for cidr := range set {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	return cidrs, nil
+}
+
+// hostCIDR returns the CIDR of the single address ip.
+func hostCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+func sortedPorts(set map[int32]bool) []int32 {
+	ports := make([]int32, 0, len(set))
+	for port := range set {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+func networkPolicyPort(protocol corev1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	p := intstr.FromInt32(port)
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+}
+
+// toUnstructuredList converts the objects pointed to to the list of a
+// sandbox field.
+func toUnstructuredList(objects ...interface{}) ([]interface{}, error) {
+	list := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// ensureCodeServerSecret creates the `<sandbox>-code-server` secret with a
+// random code-server password, unless it already exists. It is owned by the
+// RepoWatch until createSandbox hands it to the sandbox.
+func (r *RepoWatchReconciler) ensureCodeServerSecret(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandboxName string) error {
+	b := make([]byte, 24)
+	if _, err := cryptorand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate code-server password: %w", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sandboxName + "-code-server",
+			Namespace: repoWatch.Namespace,
+			Labels: map[string]string{
+				"review.gemini.google.com/repowatch": repoWatch.Name,
+			},
+		},
+		StringData: map[string]string{
+			"password": hex.EncodeToString(b),
+		},
+	}
+	if err := controllerutil.SetControllerReference(repoWatch, secret, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create code-server secret: %w", err)
+	}
+	return nil
+}
+
+// defaultTokensSecretName is the secret the sandboxes mount at /tokens when
+// the LLM config doesn't reference one.
+const defaultTokensSecretName = "gemini-vscode-tokens"
+
+// defaultSecretsStoreCSIDriver is the driver of the GKE Secret Manager add-on.
+const defaultSecretsStoreCSIDriver = "secrets-store-gke.csi.k8s.io"
+
+// setTokensSecret points a sandbox at a plain copy of the secret of its LLM
+// API keys if they are encrypted, and sets the volume mounted at /tokens:
+// that secret, or the SecretProviderClass of the RepoWatch if it reads the
+// keys from Secret Manager.
+func (r *RepoWatchReconciler) setTokensSecret(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured, apiKeySecretRef string) error {
+	if spec := repoWatch.Spec.SecretManager; spec.TokensSecretProviderClass != "" {
+		driver := spec.CSIDriver
+		if driver == "" {
+			driver = defaultSecretsStoreCSIDriver
+		}
+		return setTokensVolume(sandbox, corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{
+				Driver:           driver,
+				ReadOnly:         ptr.To(true),
+				VolumeAttributes: map[string]string{"secretProviderClass": spec.TokensSecretProviderClass},
+			},
+		})
+	}
+
+	if apiKeySecretRef == "" {
+		apiKeySecretRef = defaultTokensSecretName
+	}
+	name, err := r.unsealedSecret(ctx, repoWatch, apiKeySecretRef, sandbox.GetName()+"-tokens")
+	if err != nil {
+		return err
+	}
+	if name != apiKeySecretRef {
+		if err := unstructured.SetNestedField(sandbox.Object, name, "spec", "tokensSecretName"); err != nil {
+			return err
+		}
+	} else {
+		// The sandboxes mount the default secret unless given a copy
+		name = defaultTokensSecretName
+	}
+	return setTokensVolume(sandbox, corev1.VolumeSource{
+		Secret: &corev1.SecretVolumeSource{SecretName: name},
+	})
+}
+
+// setTokensVolume sets the tokens-secret volume of a sandbox to source.
+func setTokensVolume(sandbox *unstructured.Unstructured, source corev1.VolumeSource) error {
+	volume, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.Volume{Name: "tokens-secret", VolumeSource: source})
+	if err != nil {
+		return err
+	}
+	return unstructured.SetNestedMap(sandbox.Object, volume, "spec", "tokensVolume")
+}
+
+// setGithubToken points an issue sandbox at the GitHub PAT of the RepoWatch:
+// its Secret Manager secret, read by the sandbox itself, or else its GitHub
+// secret.
+func (r *RepoWatchReconciler) setGithubToken(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured) error {
+	if name := repoWatch.Spec.SecretManager.GithubPAT; name != "" {
+		return unstructured.SetNestedField(sandbox.Object, name, "spec", "githubTokenSecret")
+	}
+	return r.setGithubSecret(ctx, repoWatch, sandbox)
+}
+
+// setGithubSecret points a sandbox at the GitHub secret of the RepoWatch, or
+// at a plain copy of it if the PAT is encrypted. The clone init container of
+// the sandboxes reads the PAT from it.
+func (r *RepoWatchReconciler) setGithubSecret(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, sandbox *unstructured.Unstructured) error {
+	if repoWatch.Spec.GithubSecretName == "" {
+		return nil
+	}
+	name, err := r.unsealedSecret(ctx, repoWatch, repoWatch.Spec.GithubSecretName, sandbox.GetName()+"-github")
+	if err != nil {
+		return err
+	}
+	return unstructured.SetNestedField(sandbox.Object, name, "spec", "githubSecretName")
+}
+
+// unsealedSecret returns the secret a sandbox should use for the secret
+// source. Sandboxes can't decrypt values encrypted with the KMS, so when
+// source has some, they are decrypted into the copySecret secret, owned by
+// the RepoWatch until createSandbox hands it to the sandbox like the
+// code-server secrets. Otherwise source is returned.
+func (r *RepoWatchReconciler) unsealedSecret(ctx context.Context, repoWatch *reviewv1alpha1.RepoWatch, source, copySecret string) (string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: source, Namespace: repoWatch.Namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Left to the sandbox to report
+			return source, nil
+		}
+		return "", err
+	}
+	sealed := false
+	for _, v := range secret.Data {
+		if envelope.IsSealed(v) {
+			sealed = true
+			break
+		}
+	}
+	if !sealed {
+		return source, nil
+	}
+
+	data := map[string][]byte{}
+	for k, v := range secret.Data {
+		plain, err := envelope.Open(ctx, r.KMS, v)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt %q in secret %s: %w", k, source, err)
+		}
+		data[k] = plain
+	}
+	unsealed := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      copySecret,
+			Namespace: repoWatch.Namespace,
+		},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, unsealed, func() error {
+		unsealed.Labels = map[string]string{
+			"review.gemini.google.com/repowatch": repoWatch.Name,
+		}
+		unsealed.Data = data
+		// Taken back from a former sandbox of the same name
+		unsealed.OwnerReferences = nil
+		return controllerutil.SetControllerReference(repoWatch, unsealed, r.Scheme)
+	}); err != nil {
+		return "", fmt.Errorf("failed to write secret %s: %w", copySecret, err)
+	}
+	return copySecret, nil
+}
+
 // createSandboxForIssueHandler creates an IssueSandbox for an issue.
 // It uses the LLM configuration from the RepoWatch CRD to configure the
 // sandbox.
@@ -675,7 +1755,7 @@ func (r *RepoWatchReconciler) createSandboxForIssueHandler(ctx context.Context,
 	repoName := strings.Split(repoWatch.Spec.RepoURL, "/")[len(strings.Split(repoWatch.Spec.RepoURL, "/"))-1]
 	sandboxName := fmt.Sprintf("%s-issue-%d-%s", repoName, *issue.Number, handler.Name)
 
-	prompt, err := r.generateIssueHandlerPrompt(handler, issue)
+	prompt, err := r.generateIssueHandlerPrompt(ctx, repoWatch.Namespace, handler, issue)
 	if err != nil {
 		return err
 	}
@@ -701,6 +1781,9 @@ func (r *RepoWatchReconciler) createSandboxForIssueHandler(ctx context.Context,
 					"review.gemini.google.com/repowatch": repoWatch.Name,
 					"review.gemini.google.com/handler":   handler.Name,
 				},
+				"annotations": map[string]interface{}{
+					commentsSeenAnnotation: strconv.Itoa(issue.GetComments()),
+				},
 			},
 			"spec": map[string]interface{}{
 				"llmBackend": map[string]interface{}{
@@ -709,6 +1792,7 @@ func (r *RepoWatchReconciler) createSandboxForIssueHandler(ctx context.Context,
 				"llm": map[string]interface{}{
 					"configdirRef": handler.LLM.ConfigdirRef,
 					"prompt":       prompt,
+					"transcript":   handler.LLM.Transcript,
 				},
 				"source": map[string]interface{}{
 					// change *issue.RepositoryURL from https://api.github.com/repos/org/repo-name to https://github.com/org/repo-name.git
@@ -743,11 +1827,119 @@ func (r *RepoWatchReconciler) createSandboxForIssueHandler(ctx context.Context,
 		}
 	}
 
+	if err := r.setCodeServer(ctx, repoWatch, sandbox, handler.CodeServer); err != nil {
+		return err
+	}
+
+	if err := r.setTokensSecret(ctx, repoWatch, sandbox, handler.LLM.APIKeySecretRef); err != nil {
+		return err
+	}
+
+	if err := r.setGithubToken(ctx, repoWatch, sandbox); err != nil {
+		return err
+	}
+
+	if err := setResources(sandbox, handler.Resources); err != nil {
+		return err
+	}
+
+	if err := setPriorityClass(sandbox, handler.PriorityClassName); err != nil {
+		return err
+	}
+
+	if err := setArtifacts(sandbox, repoWatch.Spec.Artifacts); err != nil {
+		return err
+	}
+
+	if err := setClone(sandbox, repoWatch.Spec.Clone); err != nil {
+		return err
+	}
+
+	if err := setSecurityContext(sandbox, repoWatch.Spec.SandboxSecurity); err != nil {
+		return err
+	}
+
+	if handler.CommitMessageTemplate != "" {
+		if err := unstructured.SetNestedField(sandbox.Object, handler.CommitMessageTemplate, "spec", "destination", "commitMessageTemplate"); err != nil {
+			return err
+		}
+	}
+
+	if handler.CommitSigning.SignOff {
+		if err := unstructured.SetNestedField(sandbox.Object, true, "spec", "destination", "signOff"); err != nil {
+			return err
+		}
+	}
+
+	if handler.CommitSigning.Format != "" {
+		signing := map[string]interface{}{
+			"format": handler.CommitSigning.Format,
+		}
+		if handler.CommitSigning.SecretName != "" {
+			signing["secretName"] = handler.CommitSigning.SecretName
+		}
+		if err := unstructured.SetNestedMap(sandbox.Object, signing, "spec", "destination", "signing"); err != nil {
+			return err
+		}
+	}
+
+	if handler.Validation.Command != "" || handler.Validation.MaxAttempts > 0 {
+		validation := map[string]interface{}{
+			"command": handler.Validation.Command,
+		}
+		if handler.Validation.MaxAttempts > 0 {
+			validation["maxAttempts"] = int64(handler.Validation.MaxAttempts)
+		}
+		if err := unstructured.SetNestedMap(sandbox.Object, validation, "spec", "validation"); err != nil {
+			return err
+		}
+	}
+
+	if handler.SelfReviewIterations > 0 {
+		if err := unstructured.SetNestedField(sandbox.Object, int64(handler.SelfReviewIterations), "spec", "selfReviewIterations"); err != nil {
+			return err
+		}
+	}
+
+	staging := map[string]interface{}{
+		"include": strings.Join(handler.Staging.Include, ","),
+		"exclude": strings.Join(handler.Staging.Exclude, ","),
+	}
+	if handler.Staging.MaxFileSizeBytes > 0 {
+		staging["maxFileSizeBytes"] = handler.Staging.MaxFileSizeBytes
+	}
+	if err := unstructured.SetNestedMap(sandbox.Object, staging, "spec", "staging"); err != nil {
+		return err
+	}
+
+	if handler.CreatePR {
+		prTitle, prBody, err := r.generatePullRequestText(handler, issue)
+		if err != nil {
+			return err
+		}
+		pullRequest := map[string]interface{}{
+			"create": true,
+			"title":  prTitle,
+			"body":   prBody,
+		}
+		if err := unstructured.SetNestedMap(sandbox.Object, pullRequest, "spec", "destination", "pullRequest"); err != nil {
+			return err
+		}
+	}
+
+	if err := r.setNetworkPolicy(ctx, repoWatch, sandbox, handler.LLM.Provider); err != nil {
+		return err
+	}
+
+	if err := r.setTracing(ctx, sandbox); err != nil {
+		return err
+	}
+
 	if err := controllerutil.SetControllerReference(repoWatch, sandbox, r.Scheme); err != nil {
 		return err
 	}
 
-	return r.Create(ctx, sandbox)
+	return r.createSandbox(ctx, repoWatch, sandbox)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -19,24 +19,32 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v39/github"
 	"github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
 	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
 )
 
@@ -377,7 +385,7 @@ func TestReconcileReviewSandboxes(t *testing.T) {
 		g.Expect(r.Client.List(context.Background(), sandboxList)).To(gomega.Succeed())
 		g.Expect(sandboxList.Items).To(gomega.HaveLen(1)) // Should contain the closedPRSandbox initially
 
-		err := r.reconcileReviewSandboxes(context.Background(), repoWatch, []*github.PullRequest{pr}, sandboxList)
+		err := r.reconcileReviewSandboxes(context.Background(), repoWatch, nil, []*github.PullRequest{pr}, sandboxList)
 		g.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Check that the sandbox for the closed PR is deleted and a new one for the open PR is created
@@ -390,6 +398,9 @@ func TestReconcileReviewSandboxes(t *testing.T) {
 		g.Expect(r.Client.List(context.Background(), sandboxList)).To(gomega.Succeed())
 		g.Expect(sandboxList.Items).To(gomega.HaveLen(1)) // Should contain only the sandbox for prNumber 1
 		g.Expect(sandboxList.Items[0].GetName()).To(gomega.Equal("repo-pr-1"))
+		// The clone init container reads the PAT of the GitHub secret
+		name, _, _ := unstructured.NestedString(sandboxList.Items[0].Object, "spec", "githubSecretName")
+		g.Expect(name).To(gomega.Equal("github-secret"))
 	})
 
 	// Test case 2: Not creating a new sandbox if the maximum number of active sandboxes has been reached.
@@ -443,7 +454,7 @@ func TestReconcileReviewSandboxes(t *testing.T) {
 		}
 
 		// Call reconcileReviewSandboxes with the active PR and the new PR
-		err := r.reconcileReviewSandboxes(context.Background(), repoWatch, []*github.PullRequest{pr, newPR}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*activePRSandbox}})
+		err := r.reconcileReviewSandboxes(context.Background(), repoWatch, nil, []*github.PullRequest{pr, newPR}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*activePRSandbox}})
 		g.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Check that no new sandbox was created
@@ -506,7 +517,7 @@ func TestReconcileReviewSandboxes(t *testing.T) {
 		}
 
 		// Call reconcileReviewSandboxes with the existing PR
-		err := r.reconcileReviewSandboxes(context.Background(), repoWatch, []*github.PullRequest{pr}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*existingPRSandbox}})
+		err := r.reconcileReviewSandboxes(context.Background(), repoWatch, nil, []*github.PullRequest{pr}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*existingPRSandbox}})
 		g.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Check that no new sandbox was created and the existing one is still there
@@ -619,7 +630,7 @@ func TestReconcileIssueHandlerSandboxes(t *testing.T) {
 		g.Expect(r.Client.List(context.Background(), sandboxList)).To(gomega.Succeed())
 		g.Expect(sandboxList.Items).To(gomega.HaveLen(1)) // Should contain the closedIssueSandbox initially
 
-		err := r.reconcileIssueHandlerSandboxes(context.Background(), currentUser, handler, repoWatch, []*github.Issue{issue}, sandboxList)
+		err := r.reconcileIssueHandlerSandboxes(context.Background(), &github.Client{}, currentUser, handler, repoWatch, nil, []*github.Issue{issue}, sandboxList)
 		g.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Check that the sandbox for the closed issue is deleted and a new one for the open issue is created
@@ -680,7 +691,7 @@ func TestReconcileIssueHandlerSandboxes(t *testing.T) {
 		}
 
 		// Call reconcileIssueHandlerSandboxes with the active issue and the new issue
-		err := r.reconcileIssueHandlerSandboxes(context.Background(), currentUser, handler, repoWatch, []*github.Issue{issue, newIssue}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*activeIssueSandbox}})
+		err := r.reconcileIssueHandlerSandboxes(context.Background(), &github.Client{}, currentUser, handler, repoWatch, nil, []*github.Issue{issue, newIssue}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*activeIssueSandbox}})
 		g.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Check that no new sandbox was created
@@ -741,7 +752,7 @@ func TestReconcileIssueHandlerSandboxes(t *testing.T) {
 		}
 
 		// Call reconcileIssueHandlerSandboxes with the existing issue
-		err := r.reconcileIssueHandlerSandboxes(context.Background(), currentUser, handler, repoWatch, []*github.Issue{issue}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*existingIssueSandbox}})
+		err := r.reconcileIssueHandlerSandboxes(context.Background(), &github.Client{}, currentUser, handler, repoWatch, nil, []*github.Issue{issue}, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*existingIssueSandbox}})
 		g.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Check that no new sandbox was created and the existing one is still there
@@ -795,6 +806,40 @@ func TestRepoWatchReconciler_Reconcile_NotFound(t *testing.T) {
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 }
 
+func TestRepoWatchReconciler_Reconcile_Demo(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+
+	repoWatch := &reviewv1alpha1.RepoWatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-repowatch",
+			Namespace:   "default",
+			Annotations: map[string]string{demoAnnotation: "true"},
+		},
+		Spec: reviewv1alpha1.RepoWatchSpec{
+			RepoURL:             "https://github.com/test/repo",
+			PollIntervalSeconds: 60,
+		},
+	}
+	fakeClient := clientfake.NewClientBuilder().WithScheme(s).WithObjects(repoWatch).Build()
+
+	r := &RepoWatchReconciler{
+		Client: fakeClient,
+		Scheme: s,
+		NewGithubClient: func(_ context.Context, _ client.Client, _ *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
+			return nil, nil, errors.New("demo RepoWatches must not reach GitHub")
+		},
+	}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-repowatch", Namespace: "default"}}
+	result, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.BeZero())
+}
+
 func TestRepoWatchReconciler_Reconcile_GitHubSecretNotFound(t *testing.T) {
 	g := gomega.NewWithT(t)
 
@@ -1018,7 +1063,7 @@ func TestNewGithubClient(t *testing.T) {
 			}
 
 			// 5. Call NewGithubClient
-			_, githubConfig, err := NewGithubClient(context.Background(), fakeClient, repoWatch)
+			_, githubConfig, err := NewGithubClient(context.Background(), fakeClient, nil, nil, repoWatch)
 
 			// 6. Assert expected outcomes
 			if tc.expectErr {
@@ -1032,3 +1077,659 @@ func TestNewGithubClient(t *testing.T) {
 		})
 	}
 }
+
+// fakeSecrets is a Secret Manager of the secrets names.
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) Access(_ context.Context, name string) ([]byte, error) {
+	v, ok := f[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", name)
+	}
+	return []byte(v), nil
+}
+
+func TestNewGithubClientSecretManager(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	fakeClient := clientfake.NewClientBuilder().WithScheme(s).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "github-secret", Namespace: "default"},
+		Data: map[string][]byte{
+			"pat":  []byte("k8s-pat"),
+			"name": []byte("test-user"),
+		},
+	}).Build()
+	secrets := fakeSecrets{"projects/p/secrets/github-pat": "sm-pat\n"}
+	repoWatch := &reviewv1alpha1.RepoWatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-repowatch", Namespace: "default"},
+		Spec: reviewv1alpha1.RepoWatchSpec{
+			RepoURL:       "https://github.com/test/repo",
+			SecretManager: reviewv1alpha1.SecretManagerSpec{GithubPAT: "projects/p/secrets/github-pat"},
+		},
+	}
+
+	// The PAT alone is read from Secret Manager
+	_, githubConfig, err := NewGithubClient(ctx, fakeClient, nil, secrets, repoWatch)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(githubConfig["pat"]).To(gomega.Equal("sm-pat"))
+	g.Expect(githubConfig["name"]).To(gomega.BeEmpty())
+
+	// and takes precedence over the secret, still read for the git config
+	repoWatch.Spec.GithubSecretName = "github-secret"
+	_, githubConfig, err = NewGithubClient(ctx, fakeClient, nil, secrets, repoWatch)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(githubConfig["pat"]).To(gomega.Equal("sm-pat"))
+	g.Expect(githubConfig["name"]).To(gomega.Equal("test-user"))
+
+	_, _, err = NewGithubClient(ctx, fakeClient, nil, nil, repoWatch)
+	g.Expect(err).To(gomega.HaveOccurred())
+	repoWatch.Spec.SecretManager.GithubPAT = "projects/p/secrets/missing"
+	_, _, err = NewGithubClient(ctx, fakeClient, nil, secrets, repoWatch)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestGeneratePullRequestText(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	issue := &github.Issue{
+		Number:  github.Int(10),
+		Title:   github.String("Test Issue"),
+		HTMLURL: github.String("https://github.com/test/repo/issues/10"),
+	}
+
+	testCases := []struct {
+		name          string
+		handler       reviewv1alpha1.IssueHandlerSpec
+		expectedTitle string
+		expectedBody  string
+	}{
+		{
+			name:          "default templates",
+			handler:       reviewv1alpha1.IssueHandlerSpec{Name: "bugfix", CreatePR: true},
+			expectedTitle: "Fix #10: Test Issue",
+			expectedBody:  "Fixes https://github.com/test/repo/issues/10",
+		},
+		{
+			name: "custom templates",
+			handler: reviewv1alpha1.IssueHandlerSpec{
+				Name:     "bugfix",
+				CreatePR: true,
+				PullRequest: reviewv1alpha1.PullRequestTemplate{
+					Title: "fix: {{.Title}}",
+					Body:  "Closes #{{.Number}}",
+				},
+			},
+			expectedTitle: "fix: Test Issue",
+			expectedBody:  "Closes #10",
+		},
+	}
+
+	r := &RepoWatchReconciler{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(_ *testing.T) {
+			title, body, err := r.generatePullRequestText(tc.handler, issue)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(title).To(gomega.Equal(tc.expectedTitle))
+			g.Expect(body).To(gomega.Equal(tc.expectedBody))
+		})
+	}
+}
+
+func TestResolvePrompt(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "prompt-template-triage",
+			Namespace:   "default",
+			Annotations: map[string]string{promptLatestVersionAnnotation: "2"},
+		},
+		Data: map[string]string{"v1": "Triage {{.Number}}.", "v2": "Triage issue {{.Number}}."},
+	}
+	r := &RepoWatchReconciler{Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(cm).Build()}
+	ctx := context.Background()
+
+	prompt, err := r.resolvePrompt(ctx, "default", reviewv1alpha1.LLMConfig{Prompt: "inline"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(prompt).To(gomega.Equal("inline"))
+
+	ref := &reviewv1alpha1.PromptTemplateRef{Name: "triage"}
+	prompt, err = r.resolvePrompt(ctx, "default", reviewv1alpha1.LLMConfig{Prompt: "inline", PromptTemplateRef: ref})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(prompt).To(gomega.Equal("Triage issue {{.Number}}."))
+
+	ref.Version = 1
+	prompt, err = r.resolvePrompt(ctx, "default", reviewv1alpha1.LLMConfig{PromptTemplateRef: ref})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(prompt).To(gomega.Equal("Triage {{.Number}}."))
+
+	ref.Version = 3
+	_, err = r.resolvePrompt(ctx, "default", reviewv1alpha1.LLMConfig{PromptTemplateRef: ref})
+	g.Expect(err).To(gomega.HaveOccurred())
+	_, err = r.resolvePrompt(ctx, "default", reviewv1alpha1.LLMConfig{PromptTemplateRef: &reviewv1alpha1.PromptTemplateRef{Name: "fix"}})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestAppendFollowUpComments(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	comment := func(login, body string) *github.IssueComment {
+		return &github.IssueComment{User: &github.User{Login: github.String(login)}, Body: github.String(body)}
+	}
+
+	// Only robot comments leave the prompt unchanged
+	g.Expect(appendFollowUpComments("fix it", []*github.IssueComment{comment("robot", "done")}, "robot")).To(gomega.Equal("fix it"))
+
+	got := appendFollowUpComments("fix it", []*github.IssueComment{
+		comment("maintainer", "please add a test"),
+		comment("robot", "done"),
+	}, "robot")
+	g.Expect(got).To(gomega.HavePrefix("fix it\n\n"))
+	g.Expect(got).To(gomega.ContainSubstring("@maintainer wrote:\nplease add a test\n"))
+	g.Expect(got).NotTo(gomega.ContainSubstring("@robot"))
+}
+
+func TestIsSandboxStalled(t *testing.T) {
+	g := gomega.NewWithT(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	sandboxWithStatus := func(status map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	}
+
+	g.Expect(isSandboxStalled(sandboxWithStatus(map[string]interface{}{}), now)).To(gomega.BeFalse())
+	g.Expect(isSandboxStalled(sandboxWithStatus(map[string]interface{}{
+		"phase":     "Generating",
+		"heartbeat": now.Add(-time.Minute).Format(time.RFC3339),
+	}), now)).To(gomega.BeFalse())
+	g.Expect(isSandboxStalled(sandboxWithStatus(map[string]interface{}{
+		"phase":     "Generating",
+		"heartbeat": now.Add(-time.Hour).Format(time.RFC3339),
+	}), now)).To(gomega.BeTrue())
+	g.Expect(isSandboxStalled(sandboxWithStatus(map[string]interface{}{
+		"phase":     "Completed",
+		"heartbeat": now.Add(-time.Hour).Format(time.RFC3339),
+	}), now)).To(gomega.BeFalse())
+}
+
+func TestSetResources(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	g.Expect(setResources(sandbox, corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse("2"),
+			corev1.ResourceEphemeralStorage: resource.MustParse("20Gi"),
+		},
+	})).To(gomega.Succeed())
+
+	requests, _, _ := unstructured.NestedStringMap(sandbox.Object, "spec", "resources", "requests")
+	g.Expect(requests).To(gomega.Equal(map[string]string{"cpu": "2", "ephemeral-storage": "20Gi"}))
+	limits, found, _ := unstructured.NestedStringMap(sandbox.Object, "spec", "resources", "limits")
+	g.Expect(found).To(gomega.BeTrue())
+	g.Expect(limits).To(gomega.BeEmpty())
+}
+
+func TestSetPriorityClass(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	g.Expect(setPriorityClass(sandbox, "")).To(gomega.Succeed())
+	_, found, _ := unstructured.NestedString(sandbox.Object, "spec", "priorityClassName")
+	g.Expect(found).To(gomega.BeFalse())
+
+	g.Expect(setPriorityClass(sandbox, "repo-agent-high")).To(gomega.Succeed())
+	name, _, _ := unstructured.NestedString(sandbox.Object, "spec", "priorityClassName")
+	g.Expect(name).To(gomega.Equal("repo-agent-high"))
+}
+
+func TestSetClone(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	g.Expect(setClone(sandbox, reviewv1alpha1.CloneSpec{Depth: 1})).To(gomega.Succeed())
+	_, found, _ := unstructured.NestedMap(sandbox.Object, "spec", "clone", "cacheVolume", "emptyDir")
+	g.Expect(found).To(gomega.BeTrue())
+
+	g.Expect(setClone(sandbox, reviewv1alpha1.CloneSpec{Filter: "blob:none", CacheClaimName: "mirrors"})).To(gomega.Succeed())
+	claimName, _, _ := unstructured.NestedString(sandbox.Object, "spec", "clone", "cacheVolume", "persistentVolumeClaim", "claimName")
+	g.Expect(claimName).To(gomega.Equal("mirrors"))
+	_, found, _ = unstructured.NestedMap(sandbox.Object, "spec", "clone", "cacheVolume", "emptyDir")
+	g.Expect(found).To(gomega.BeFalse())
+}
+
+func TestSetSecurityContext(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	g.Expect(setSecurityContext(sandbox, reviewv1alpha1.SandboxSecuritySpec{})).To(gomega.Succeed())
+	seccomp, _, _ := unstructured.NestedString(sandbox.Object, "spec", "securityContext", "pod", "seccompProfile", "type")
+	g.Expect(seccomp).To(gomega.Equal("RuntimeDefault"))
+	_, found, _ := unstructured.NestedMap(sandbox.Object, "spec", "securityContext", "pod", "appArmorProfile")
+	g.Expect(found).To(gomega.BeFalse())
+	nonRoot, _, _ := unstructured.NestedBool(sandbox.Object, "spec", "securityContext", "sidecar", "runAsNonRoot")
+	g.Expect(nonRoot).To(gomega.BeTrue())
+	readOnly, _, _ := unstructured.NestedBool(sandbox.Object, "spec", "securityContext", "initContainers", "readOnlyRootFilesystem")
+	g.Expect(readOnly).To(gomega.BeTrue())
+	escalation, found, _ := unstructured.NestedBool(sandbox.Object, "spec", "securityContext", "agent", "allowPrivilegeEscalation")
+	g.Expect(found).To(gomega.BeTrue())
+	g.Expect(escalation).To(gomega.BeFalse())
+
+	g.Expect(setSecurityContext(sandbox, reviewv1alpha1.SandboxSecuritySpec{
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: ptr.To("profiles/sandbox.json")},
+		AppArmorProfile:          &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeRuntimeDefault},
+		AllowPrivilegeEscalation: true,
+	})).To(gomega.Succeed())
+	profile, _, _ := unstructured.NestedString(sandbox.Object, "spec", "securityContext", "pod", "seccompProfile", "localhostProfile")
+	g.Expect(profile).To(gomega.Equal("profiles/sandbox.json"))
+	appArmor, _, _ := unstructured.NestedString(sandbox.Object, "spec", "securityContext", "pod", "appArmorProfile", "type")
+	g.Expect(appArmor).To(gomega.Equal("RuntimeDefault"))
+	escalation, _, _ = unstructured.NestedBool(sandbox.Object, "spec", "securityContext", "agent", "allowPrivilegeEscalation")
+	g.Expect(escalation).To(gomega.BeTrue())
+
+	g.Expect(setSecurityContext(sandbox, reviewv1alpha1.SandboxSecuritySpec{Disabled: true})).To(gomega.Succeed())
+	securityContext, _, _ := unstructured.NestedMap(sandbox.Object, "spec", "securityContext")
+	g.Expect(securityContext).To(gomega.Equal(map[string]interface{}{
+		"pod":            map[string]interface{}{},
+		"initContainers": map[string]interface{}{},
+		"sidecar":        map[string]interface{}{},
+		"agent":          map[string]interface{}{},
+	}))
+}
+
+func TestSetTracing(t *testing.T) {
+	g := gomega.NewWithT(t)
+	r := &RepoWatchReconciler{}
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	g.Expect(r.setTracing(context.Background(), sandbox)).To(gomega.Succeed())
+	_, found, _ := unstructured.NestedMap(sandbox.Object, "spec", "tracing")
+	g.Expect(found).To(gomega.BeFalse())
+
+	ctx, span := r.Tracer.Start(context.Background(), "repowatch.reconcile", tracing.KindInternal)
+	g.Expect(r.setTracing(ctx, sandbox)).To(gomega.Succeed())
+	traceparent, _, _ := unstructured.NestedString(sandbox.Object, "spec", "tracing", "traceparent")
+	g.Expect(traceparent).To(gomega.Equal(span.SpanContext().Traceparent()))
+	_, found, _ = unstructured.NestedString(sandbox.Object, "spec", "tracing", "endpoint")
+	g.Expect(found).To(gomega.BeFalse())
+}
+
+// xorKMS "encrypts" data keys by flipping their bits.
+type xorKMS struct{}
+
+func (xorKMS) Encrypt(_ context.Context, b []byte) ([]byte, error) { return xor(b), nil }
+func (xorKMS) Decrypt(_ context.Context, b []byte) ([]byte, error) { return xor(b), nil }
+
+func xor(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0xff
+	}
+	return out
+}
+
+func TestSetTokensSecret(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	sealed, err := envelope.Seal(ctx, xorKMS{}, []byte("key"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	repoWatch := &reviewv1alpha1.RepoWatch{ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default"}}
+	r := &RepoWatchReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(repoWatch,
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"},
+				Data:       map[string][]byte{"gemini": []byte("key")},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "gemini-vscode-tokens", Namespace: "default"},
+				Data:       map[string][]byte{"gemini": sealed},
+			},
+		).Build(),
+		Scheme: s,
+		KMS:    xorKMS{},
+	}
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	sandbox.SetName("rw-1")
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "plain")).To(gomega.Succeed())
+	_, found, _ := unstructured.NestedString(sandbox.Object, "spec", "tokensSecretName")
+	g.Expect(found).To(gomega.BeFalse())
+
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	name, _, _ := unstructured.NestedString(sandbox.Object, "spec", "tokensSecretName")
+	g.Expect(name).To(gomega.Equal("rw-1-tokens"))
+	secret := &corev1.Secret{}
+	g.Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, secret)).To(gomega.Succeed())
+	g.Expect(string(secret.Data["gemini"])).To(gomega.Equal("key"))
+	g.Expect(secret.OwnerReferences).To(gomega.HaveLen(1))
+
+	r.KMS = nil
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "")).NotTo(gomega.Succeed())
+}
+
+func TestSetTokensSecretVolume(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	repoWatch := &reviewv1alpha1.RepoWatch{ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default"}}
+	r := &RepoWatchReconciler{Client: clientfake.NewClientBuilder().WithScheme(s).Build(), Scheme: s}
+
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	sandbox.SetName("rw-1")
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	secretName, _, _ := unstructured.NestedString(sandbox.Object, "spec", "tokensVolume", "secret", "secretName")
+	g.Expect(secretName).To(gomega.Equal("gemini-vscode-tokens"))
+
+	repoWatch.Spec.SecretManager.TokensSecretProviderClass = "gemini-tokens"
+	sandbox = &unstructured.Unstructured{Object: map[string]interface{}{}}
+	sandbox.SetName("rw-1")
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	volume, _, _ := unstructured.NestedMap(sandbox.Object, "spec", "tokensVolume")
+	g.Expect(volume).To(gomega.Equal(map[string]interface{}{
+		"name": "tokens-secret",
+		"csi": map[string]interface{}{
+			"driver":           "secrets-store-gke.csi.k8s.io",
+			"readOnly":         true,
+			"volumeAttributes": map[string]interface{}{"secretProviderClass": "gemini-tokens"},
+		},
+	}))
+}
+
+func TestCreateSandboxExists(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	newSandbox := func(branch string) *unstructured.Unstructured {
+		sandbox := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"branch": branch},
+		}}
+		sandbox.SetAPIVersion("custom.agents.x-k8s.io/v1alpha1")
+		sandbox.SetKind("IssueSandbox")
+		sandbox.SetName("repo-issue-1-fix")
+		sandbox.SetNamespace("default")
+		return sandbox
+	}
+	// Created by the previous leader
+	r := &RepoWatchReconciler{Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(newSandbox("first")).Build(), Scheme: s}
+
+	g.Expect(r.createSandbox(ctx, &reviewv1alpha1.RepoWatch{}, newSandbox("second"))).To(gomega.Succeed())
+	existing := newSandbox("")
+	g.Expect(r.Get(ctx, types.NamespacedName{Name: "repo-issue-1-fix", Namespace: "default"}, existing)).To(gomega.Succeed())
+	branch, _, _ := unstructured.NestedString(existing.Object, "spec", "branch")
+	g.Expect(branch).To(gomega.Equal("first"))
+}
+
+func TestCreateSandboxAdoptsSecrets(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	sealed, err := envelope.Seal(ctx, xorKMS{}, []byte("key"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	repoWatch := &reviewv1alpha1.RepoWatch{ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default", UID: "rw-uid"}}
+	r := &RepoWatchReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(repoWatch,
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "gemini-vscode-tokens", Namespace: "default"},
+				Data:       map[string][]byte{"gemini": sealed},
+			},
+		).Build(),
+		Scheme: s,
+		KMS:    xorKMS{},
+	}
+
+	newSandbox := func(uid types.UID) *unstructured.Unstructured {
+		sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		sandbox.SetAPIVersion("custom.agents.x-k8s.io/v1alpha1")
+		sandbox.SetKind("ReviewSandbox")
+		sandbox.SetName("rw-1")
+		sandbox.SetNamespace("default")
+		sandbox.SetUID(uid)
+		return sandbox
+	}
+	sandbox := newSandbox("sandbox-uid")
+	g.Expect(r.ensureCodeServerSecret(ctx, repoWatch, "rw-1")).To(gomega.Succeed())
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	g.Expect(r.createSandbox(ctx, repoWatch, sandbox)).To(gomega.Succeed())
+
+	// Deleted with the sandbox rather than the RepoWatch
+	for _, name := range []string{"rw-1-code-server", "rw-1-tokens"} {
+		secret := &corev1.Secret{}
+		g.Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, secret)).To(gomega.Succeed())
+		g.Expect(secret.OwnerReferences).To(gomega.HaveLen(1))
+		g.Expect(metav1.IsControlledBy(secret, sandbox)).To(gomega.BeTrue(), name)
+	}
+
+	// A new sandbox of the same name takes them from the deleted one before
+	// they are collected
+	g.Expect(r.Delete(ctx, sandbox)).To(gomega.Succeed())
+	sandbox = newSandbox("new-sandbox-uid")
+	g.Expect(r.setTokensSecret(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	g.Expect(r.createSandbox(ctx, repoWatch, sandbox)).To(gomega.Succeed())
+	for _, name := range []string{"rw-1-code-server", "rw-1-tokens"} {
+		secret := &corev1.Secret{}
+		g.Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, secret)).To(gomega.Succeed())
+		g.Expect(metav1.IsControlledBy(secret, sandbox)).To(gomega.BeTrue(), name)
+	}
+}
+
+func TestSetNetworkPolicy(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = lookup }(lookupIPAddr)
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "patch-diff.githubusercontent.com":
+			return []net.IPAddr{{IP: net.ParseIP("185.199.108.133")}}, nil
+		case "llm.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.7")}}, nil
+		case "secretmanager.googleapis.com":
+			return []net.IPAddr{{IP: net.ParseIP("142.250.1.95")}}, nil
+		case "storage.googleapis.com":
+			return []net.IPAddr{{IP: net.ParseIP("142.250.2.207")}}, nil
+		case "otel-collector.monitoring.svc":
+			return []net.IPAddr{{IP: net.ParseIP("10.100.0.9")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	port := int32(6443)
+	r := &RepoWatchReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					ClusterIPs: []string{"10.96.0.1"},
+					Ports:      []corev1.ServicePort{{Port: 443}},
+				},
+			},
+			&discoveryv1.EndpointSlice{
+				ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes", Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "kubernetes"}},
+				AddressType: discoveryv1.AddressTypeIPv4,
+				Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"172.18.0.2"}}},
+				Ports:       []discoveryv1.EndpointPort{{Port: &port}},
+			},
+		).Build(),
+		Scheme: s,
+		NewGithubClient: func(_ context.Context, _ client.Client, _ *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
+			return github.NewClient(&http.Client{Transport: &mockRoundTripper{responses: map[string]*http.Response{
+				"https://api.github.com/meta": {
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"api": ["192.30.252.0/22"], "git": ["192.30.252.0/22", "2a0a:a440::/29"], "web": ["140.82.112.0/20"]}`)),
+				},
+			}}}), nil, nil
+		},
+	}
+	repoWatch := &reviewv1alpha1.RepoWatch{ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default"}}
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	g.Expect(r.setNetworkPolicy(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	g.Expect(sandbox.Object).NotTo(gomega.HaveKey("spec"))
+
+	repoWatch.Spec.NetworkPolicy = reviewv1alpha1.NetworkPolicySpec{
+		Enabled:          true,
+		LLMEndpoints:     []string{"llm.example.com"},
+		AllowedEgress:    []string{"10.0.0.0/8"},
+		IngressPodLabels: map[string]string{"app": "gateway"},
+	}
+	g.Expect(r.setNetworkPolicy(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	egress, _, _ := unstructured.NestedSlice(sandbox.Object, "spec", "networkPolicy", "egress", "rules")
+	g.Expect(egress).To(gomega.HaveLen(3))
+	cidrs := func(rule interface{}) []string {
+		var cidrs []string
+		to, _, _ := unstructured.NestedSlice(rule.(map[string]interface{}), "to")
+		for _, peer := range to {
+			cidr, _, _ := unstructured.NestedString(peer.(map[string]interface{}), "ipBlock", "cidr")
+			cidrs = append(cidrs, cidr)
+		}
+		return cidrs
+	}
+	ports := func(rule interface{}) []interface{} {
+		var ports []interface{}
+		list, _, _ := unstructured.NestedSlice(rule.(map[string]interface{}), "ports")
+		for _, port := range list {
+			ports = append(ports, port.(map[string]interface{})["port"])
+		}
+		return ports
+	}
+	// DNS
+	g.Expect(ports(egress[0])).To(gomega.Equal([]interface{}{int64(53), int64(53)}))
+	// API server
+	g.Expect(cidrs(egress[1])).To(gomega.Equal([]string{"10.96.0.1/32", "172.18.0.2/32"}))
+	g.Expect(ports(egress[1])).To(gomega.Equal([]interface{}{int64(443), int64(6443)}))
+	// GitHub, the LLM and the allowed CIDRs
+	g.Expect(cidrs(egress[2])).To(gomega.Equal([]string{"10.0.0.0/8", "140.82.112.0/20", "185.199.108.133/32", "192.30.252.0/22", "203.0.113.7/32", "2a0a:a440::/29"}))
+	g.Expect(ports(egress[2])).To(gomega.Equal([]interface{}{int64(443)}))
+
+	ingress, _, _ := unstructured.NestedSlice(sandbox.Object, "spec", "networkPolicy", "ingress", "rules")
+	g.Expect(ingress).To(gomega.Equal([]interface{}{map[string]interface{}{
+		"from": []interface{}{map[string]interface{}{
+			"podSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "gateway"}},
+		}},
+	}}))
+
+	// Sandboxes using Secret Manager and GCS reach them and the metadata
+	// server, and traced ones the OTLP collector
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://otel-collector.monitoring.svc:4318/v1/traces")
+	r.Tracer = tracing.FromEnv("repowatch-controller")
+	_ = unstructured.SetNestedField(sandbox.Object, "projects/p/secrets/github-pat", "spec", "githubTokenSecret")
+	_ = unstructured.SetNestedField(sandbox.Object, "gs://artifacts/runs", "spec", "artifacts", "bucketURL")
+	g.Expect(r.setNetworkPolicy(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	egress, _, _ = unstructured.NestedSlice(sandbox.Object, "spec", "networkPolicy", "egress", "rules")
+	g.Expect(egress).To(gomega.HaveLen(5))
+	g.Expect(cidrs(egress[2])).To(gomega.ContainElements("142.250.1.95/32", "142.250.2.207/32"))
+	g.Expect(cidrs(egress[3])).To(gomega.Equal([]string{"169.254.169.252/32", "169.254.169.254/32"}))
+	g.Expect(ports(egress[3])).To(gomega.Equal([]interface{}{int64(80), int64(988)}))
+	g.Expect(cidrs(egress[4])).To(gomega.Equal([]string{"10.100.0.9/32"}))
+	g.Expect(ports(egress[4])).To(gomega.Equal([]interface{}{int64(4318)}))
+
+	// Unresolvable hosts fail the creation of the sandbox rather than
+	// leaving it without access
+	repoWatch.Spec.NetworkPolicy.LLMEndpoints = []string{"unknown.example.com"}
+	g.Expect(r.setNetworkPolicy(ctx, repoWatch, sandbox, "")).NotTo(gomega.Succeed())
+}
+
+func TestReconcileNetworkPolicies(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	llmIP := "203.0.113.7"
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = lookup }(lookupIPAddr)
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "patch-diff.githubusercontent.com":
+			return []net.IPAddr{{IP: net.ParseIP("185.199.108.133")}}, nil
+		case "llm.example.com":
+			return []net.IPAddr{{IP: net.ParseIP(llmIP)}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = reviewv1alpha1.AddToScheme(s)
+	repoWatch := &reviewv1alpha1.RepoWatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "rw", Namespace: "default", UID: "rw-uid"},
+		Spec: reviewv1alpha1.RepoWatchSpec{
+			NetworkPolicy: reviewv1alpha1.NetworkPolicySpec{Enabled: true, LLMEndpoints: []string{"llm.example.com"}},
+		},
+	}
+	sandbox := &unstructured.Unstructured{}
+	sandbox.SetGroupVersionKind(sandboxGVKs[0])
+	sandbox.SetName("rw-pr-1")
+	sandbox.SetNamespace("default")
+	sandbox.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "review.gemini.google.com/v1alpha1", Kind: "RepoWatch", Name: "rw", UID: "rw-uid"}})
+	r := &RepoWatchReconciler{
+		Client: clientfake.NewClientBuilder().WithScheme(s).WithObjects(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIPs: []string{"10.96.0.1"}, Ports: []corev1.ServicePort{{Port: 443}}},
+			},
+		).Build(),
+		Scheme: s,
+		NewGithubClient: func(_ context.Context, _ client.Client, _ *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
+			return github.NewClient(&http.Client{Transport: &mockRoundTripper{responses: map[string]*http.Response{
+				"https://api.github.com/meta": {
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(`{"api": ["192.30.252.0/22"]}`)),
+				},
+			}}}), nil, nil
+		},
+	}
+	g.Expect(r.setNetworkPolicy(ctx, repoWatch, sandbox, "")).To(gomega.Succeed())
+	g.Expect(r.Create(ctx, sandbox)).To(gomega.Succeed())
+
+	llmCIDRs := func() []string {
+		sandboxes, err := r.ownedSandboxes(ctx, repoWatch)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(sandboxes).To(gomega.HaveLen(1))
+		egress, _, _ := unstructured.NestedSlice(sandboxes[0].Object, "spec", "networkPolicy", "egress", "rules")
+		to, _, _ := unstructured.NestedSlice(egress[2].(map[string]interface{}), "to")
+		var cidrs []string
+		for _, peer := range to {
+			cidr, _, _ := unstructured.NestedString(peer.(map[string]interface{}), "ipBlock", "cidr")
+			cidrs = append(cidrs, cidr)
+		}
+		return cidrs
+	}
+	reconcile := func() string {
+		sandboxes, err := r.ownedSandboxes(ctx, repoWatch)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(r.reconcileNetworkPolicies(ctx, repoWatch, sandboxes)).To(gomega.Succeed())
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(sandboxGVKs[0])
+		g.Expect(r.Get(ctx, client.ObjectKeyFromObject(sandbox), obj)).To(gomega.Succeed())
+		return obj.GetResourceVersion()
+	}
+
+	// Unchanged addresses leave the sandbox alone
+	version := reconcile()
+	g.Expect(reconcile()).To(gomega.Equal(version))
+	g.Expect(llmCIDRs()).To(gomega.ContainElement("203.0.113.7/32"))
+
+	// The sandbox follows the new address of the LLM endpoint
+	llmIP = "203.0.113.8"
+	g.Expect(reconcile()).NotTo(gomega.Equal(version))
+	g.Expect(llmCIDRs()).To(gomega.ContainElement("203.0.113.8/32"))
+	g.Expect(llmCIDRs()).NotTo(gomega.ContainElement("203.0.113.7/32"))
+}
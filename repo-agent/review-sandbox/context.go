@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bluekeyes/go-gitdiff/gitdiff"
+)
+
+// maxHunkContextBytes bounds the declarations added to the prompt, so that
+// large refactors don't exceed the context of the model.
+const maxHunkContextBytes = 64 << 10
+
+// hunkContext returns the full Go functions and types enclosing the changed
+// lines of the diff, read from the files of the head checked out in dir, so
+// that the model reviews partial changes of a function knowing all of it.
+// Files of other languages are left to the model to read.
+func hunkContext(dir string, files []*gitdiff.File) string {
+	var b strings.Builder
+	for _, file := range files {
+		if file.IsDelete || file.IsBinary || !strings.HasSuffix(file.NewName, ".go") {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(dir, file.NewName))
+		if err != nil {
+			log.Printf("Failed to read %s for context: %v", file.NewName, err)
+			continue
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, file.NewName, src, parser.ParseComments)
+		if err != nil {
+			log.Printf("Failed to parse %s for context: %v", file.NewName, err)
+			continue
+		}
+		lines := changedLines(file)
+		for _, decl := range f.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				continue
+			}
+			start, end := fset.Position(declStart(decl)), fset.Position(decl.End())
+			if !containsAny(lines, start.Line, end.Line) {
+				continue
+			}
+			text := fmt.Sprintf("%s lines %d-%d:\n```go\n%s\n```\n\n", file.NewName, start.Line, end.Line, src[start.Offset:end.Offset])
+			if b.Len()+len(text) > maxHunkContextBytes {
+				log.Printf("Skipping the context of %s lines %d-%d, the prompt is full", file.NewName, start.Line, end.Line)
+				continue
+			}
+			b.WriteString(text)
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "Here are the Go declarations enclosing the changed lines, as of the head of the change:\n\n" + b.String()
+}
+
+// changedLines returns the lines of the new version of a file that the diff
+// adds, and those next to which it deletes lines.
+func changedLines(file *gitdiff.File) []int64 {
+	var lines []int64
+	for _, fragment := range file.TextFragments {
+		line := fragment.NewPosition
+		for _, l := range fragment.Lines {
+			switch l.Op {
+			case gitdiff.OpAdd:
+				lines = append(lines, line)
+				line++
+			case gitdiff.OpDelete:
+				lines = append(lines, max(line, 1))
+			default:
+				line++
+			}
+		}
+	}
+	return lines
+}
+
+// declStart returns the start of a declaration, including its doc comment.
+func declStart(decl ast.Decl) token.Pos {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	}
+	return decl.Pos()
+}
+
+func containsAny(lines []int64, start, end int) bool {
+	for _, line := range lines {
+		if int64(start) <= line && line <= int64(end) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bluekeyes/go-gitdiff/gitdiff"
+)
+
+const contextSource = `package calc
+
+import "fmt"
+
+// Add returns the sum of a and b.
+func Add(a, b int) int {
+	sum := a + b
+	return sum
+}
+
+// Sub returns the difference of a and b.
+func Sub(a, b int) int {
+	return a - b
+}
+
+func Print(v int) {
+	fmt.Println(v)
+}
+`
+
+const contextDiff = `diff --git a/calc/calc.go b/calc/calc.go
+--- a/calc/calc.go
++++ b/calc/calc.go
+@@ -6,3 +6,4 @@ func Add(a, b int) int {
+ func Add(a, b int) int {
+-	return a + b
++	sum := a + b
++	return sum
+ }
+@@ -15,4 +16,3 @@ func Print(v int) {
+ func Print(v int) {
+ 	fmt.Println(v)
+-	fmt.Println()
+ }
+diff --git a/README.md b/README.md
+--- a/README.md
++++ b/README.md
+@@ -1 +1 @@
+-calc
++Calc
+`
+
+func TestHunkContext(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "calc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "calc", "calc.go"), []byte(contextSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := gitdiff.Parse(strings.NewReader(contextDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := hunkContext(dir, files)
+	for _, want := range []string{
+		"calc/calc.go lines 5-9:\n```go\n// Add returns the sum of a and b.\nfunc Add(a, b int) int {\n\tsum := a + b\n\treturn sum\n}\n```",
+		"calc/calc.go lines 16-18:\n```go\nfunc Print(v int) {\n\tfmt.Println(v)\n}\n```",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("hunkContext() = %q, want it to contain %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"func Sub", "import", "README"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("hunkContext() = %q, want no %q", got, unwanted)
+		}
+	}
+
+	// Files that are missing, e.g. deleted, add no context
+	if got := hunkContext(t.TempDir(), files); got != "" {
+		t.Errorf("hunkContext() of missing files = %q, want none", got)
+	}
+}
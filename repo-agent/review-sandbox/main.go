@@ -3,14 +3,18 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/bluekeyes/go-gitdiff/gitdiff"
 	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/llm"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
 	"github.com/google/go-github/v39/github"
 	"gopkg.in/yaml.v3"
 )
@@ -22,61 +26,99 @@ type AgentOutput struct {
 }
 
 func main() {
-	cmdCodeSrv, err := startCodeServer()
+	// Also write the log to the workspace so that it can be read through the
+	// API
+	if f, err := os.OpenFile(sandbox.RunLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		logging.Setup("review-sandbox", os.Stderr)
+		log.Printf("failed to open %s: %v", sandbox.RunLogFile, err)
+	} else {
+		defer f.Close()
+		logging.Setup("review-sandbox", io.MultiWriter(os.Stderr, f))
+	}
+
+	codeServerConfig, err := sandbox.CodeServerConfigFromEnv()
+	if err != nil {
+		log.Fatalf("invalid code-server config: %v", err)
+	}
+	codeServer, err := sandbox.StartCodeServer(codeServerConfig)
 	if err != nil {
 		log.Fatalf("failed to start code-server: %v", err)
 	}
-	defer func() {
-		if cmdCodeSrv.Process != nil {
-			_ = cmdCodeSrv.Process.Kill()
-		}
-	}()
+	defer codeServer.Stop()
 
 	err = runReview()
 	if err != nil {
-		log.Fatalf("failed reviewing: %v", err)
+		fatalf("failed reviewing: %v", err)
 	}
 
-	err = cmdCodeSrv.Wait()
-	if err != nil {
-		log.Printf("Code Server exited with error: %v", err)
-	} else {
-		log.Println("Code Server exited with no error")
+	codeServer.Wait()
+}
+
+// fatalf records the error in sandbox.ErrorFile for the sidecar to publish, then
+// logs it and exits. The error is redacted since it is published in the
+// annotations of the ReviewSandbox.
+func fatalf(format string, v ...any) {
+	msg := logging.Redact(fmt.Sprintf(format, v...))
+	if err := os.WriteFile(sandbox.ErrorFile, []byte(msg), 0644); err != nil {
+		log.Printf("failed to write %s: %v", sandbox.ErrorFile, err)
 	}
+	log.Fatal(msg)
 }
 
 func runReview() error {
 	agentName := os.Getenv("AGENT_NAME")
 	log.Printf("Review with AGENT_NAME: %s", agentName)
 
+	// Remove the output of a previous run so that the sidecar doesn't
+	// publish it again
+	if err := os.Remove(sandbox.OutputFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove previous output: %v", err)
+	}
+	if err := os.Remove(sandbox.ErrorFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove previous error: %v", err)
+	}
+
 	// save the incoming prompt
-	if err := os.WriteFile("../agent-prompt.txt", []byte(os.Getenv("AGENT_PROMPT")), 0644); err != nil {
+	if err := sandbox.WriteArtifact(sandbox.PromptFile, []byte(os.Getenv("AGENT_PROMPT"))); err != nil {
 		log.Printf("Failed to write prompt to file: %v", err)
 	}
 
 	var diffFiles []*gitdiff.File
 	var err error
 	diffURL := os.Getenv("GIT_DIFF_URL")
-	var expectedComments int
-	// Check if diffURL beings with https://github.com/
-	if !bytes.HasPrefix([]byte(diffURL), []byte("https://github.com/")) {
-		return fmt.Errorf("GIT_DIFF_URL must start with https://github.com/")
-	}
-	if diffURL != "" {
+	diffBase := os.Getenv("GIT_DIFF_BASE")
+	diffHead := os.Getenv("GIT_DIFF_HEAD")
+	switch {
+	case diffURL != "":
+		// Check if diffURL beings with https://github.com/
+		if !bytes.HasPrefix([]byte(diffURL), []byte("https://github.com/")) {
+			return fmt.Errorf("GIT_DIFF_URL must start with https://github.com/")
+		}
 		log.Printf("Downloading and parsing diff from %s", diffURL)
 		diffFiles, err = parseDiffFromURL(diffURL)
 		if err != nil {
 			return fmt.Errorf("failed to parse diff from URL: %v", err)
 		}
-		diffSize := getDiffSize(diffFiles)
-		expectedComments = sizeToComments[diffSize]
-		log.Printf("Diff size categorized as %s, expecting up to %d comments.", diffSize, expectedComments)
-	} else {
-		return fmt.Errorf("GIT_DIFF_URL not set, skipping diff-based validation")
+	case diffBase != "":
+		// No PR yet (e.g. a pushed branch), compute the diff from the local clone.
+		log.Printf("Computing diff locally for %s...%s", diffBase, diffHead)
+		diffFiles, err = parseDiffFromRefs(diffBase, diffHead)
+		if err != nil {
+			return fmt.Errorf("failed to parse diff from refs: %v", err)
+		}
+	default:
+		return fmt.Errorf("neither GIT_DIFF_URL nor GIT_DIFF_BASE set, skipping diff-based validation")
 	}
+	diffSize := getDiffSize(diffFiles)
+	expectedComments := sizeToComments[diffSize]
+	log.Printf("Diff size categorized as %s, expecting up to %d comments.", diffSize, expectedComments)
 
 	agentPrompt := os.Getenv("AGENT_PROMPT")
 	agentPrompt = fmt.Sprintf("%s \n\n Try generating at least %d review comments", agentPrompt, expectedComments)
+	// envbuilder runs the review in the clone of the head
+	if context := hunkContext(".", diffFiles); context != "" {
+		agentPrompt = fmt.Sprintf("%s\n\n%s", agentPrompt, context)
+	}
 
 	provider, err := llm.NewLLMProvider(agentName)
 	if err != nil {
@@ -84,7 +126,7 @@ func runReview() error {
 	}
 	provider.AddPostProcessor(llm.StripYAMLMarkers)
 
-	if err := provider.Setup("/workspaces", "/tokens"); err != nil {
+	if err := provider.Setup(sandbox.WorkspacesDir, "/tokens"); err != nil {
 		return err
 	}
 
@@ -123,7 +165,7 @@ func runReview() error {
 		}
 
 		// Write output to file for debugging, regardless of validation result.
-		filename := fmt.Sprintf("../agent-output-run%d.txt", i+1)
+		filename := filepath.Join(sandbox.WorkspacesDir, fmt.Sprintf("agent-output-run%d.txt", i+1))
 		if err := os.WriteFile(filename, output, 0644); err != nil {
 			log.Printf("Failed to write agent output to %s: %v", filename, err)
 		} else {
@@ -164,6 +206,14 @@ func runReview() error {
 		}
 	}
 
+	// Record the tokens of all the runs, failed ones included, before the
+	// output so that the sidecar publishes them with it
+	if reporter, ok := provider.(llm.UsageReporter); ok {
+		if err := sandbox.AddUsage(sandbox.UsageFile, reporter.Usage()); err != nil {
+			log.Printf("Failed to record the token usage: %v", err)
+		}
+	}
+
 	if successfulRuns == 0 {
 		return fmt.Errorf("agent failed to produce any valid output after %d attempts", maxRuns)
 	}
@@ -175,7 +225,7 @@ func runReview() error {
 		return fmt.Errorf("failed to re-marshal agent output: %w", err)
 	}
 
-	filename := "../agent-output.txt"
+	filename := sandbox.OutputFile
 	if err := os.WriteFile(filename, finalOutput, 0644); err != nil {
 		return fmt.Errorf("failed to write agent output to %s: %v", filename, err)
 	}
@@ -290,16 +340,51 @@ func parseDiffFromURL(url string) ([]*gitdiff.File, error) {
 	return files, nil
 }
 
-func startCodeServer() (*exec.Cmd, error) {
-	log.Println("starting code-server")
-	codeServerPath := "/usr/bin/code-server"
-	args := []string{"--auth=none", "--bind-addr=0.0.0.0:13337"}
-	cmd := exec.Command(codeServerPath, args...)
-	cmd.Stdout = os.Stdout
-	err := cmd.Start()
+// parseDiffFromRefs computes the diff between base and head using the local
+// git clone. Like a PR diff, it is taken from the merge base of the two refs.
+// The base ref is fetched from origin if it is not available locally, since
+// sandboxes usually clone a single branch, and a shallow clone is unshallowed
+// if it lacks the merge base.
+func parseDiffFromRefs(base, head string) ([]*gitdiff.File, error) {
+	if head == "" {
+		head = "HEAD"
+	}
+
+	if _, err := sandbox.RunCommand("git", "rev-parse", "--verify", "--quiet", base+"^{commit}"); err != nil {
+		log.Printf("base ref %s not found locally, fetching it from origin", base)
+		if _, err := sandbox.RunCommand("git", "fetch", "--no-tags", "origin", base); err != nil {
+			return nil, fmt.Errorf("failed to fetch base ref %s: %w", base, err)
+		}
+		// Resolved since unshallowing the clone overwrites FETCH_HEAD
+		output, err := sandbox.RunCommand("git", "rev-parse", "FETCH_HEAD")
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve base ref %s: %w", base, err)
+		}
+		base = strings.TrimSpace(string(output))
+	}
+
+	output, err := sandbox.RunCommand("git", "rev-parse", "--is-shallow-repository")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to check for a shallow clone: %w", err)
 	}
-	log.Printf("Running code-server in subprocess %d\n", cmd.Process.Pid)
-	return cmd, nil
+	if strings.TrimSpace(string(output)) == "true" {
+		if _, err := sandbox.RunCommand("git", "merge-base", base, head); err != nil {
+			log.Printf("merge base of %s and %s not in the shallow clone, unshallowing it", base, head)
+			if _, err := sandbox.RunCommand("git", "fetch", "--no-tags", "--unshallow", "origin"); err != nil {
+				return nil, fmt.Errorf("failed to unshallow the clone: %w", err)
+			}
+		}
+	}
+
+	output, err = sandbox.RunCommand("git", "diff", "--no-color", "--no-ext-diff", base+"..."+head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute diff %s...%s: %w", base, head, err)
+	}
+
+	files, _, err := gitdiff.Parse(bytes.NewReader(output))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse diff: %w", err)
+	}
+
+	return files, nil
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiffFromRefsShallow(t *testing.T) {
+	for k, v := range map[string]string{
+		"GIT_AUTHOR_NAME":     "test",
+		"GIT_AUTHOR_EMAIL":    "test@example.com",
+		"GIT_COMMITTER_NAME":  "test",
+		"GIT_COMMITTER_EMAIL": "test@example.com",
+	} {
+		t.Setenv(k, v)
+	}
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream")
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+	commit := func(file string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(upstream, file), []byte(file+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git(upstream, "add", file)
+		git(upstream, "commit", "-q", "-m", file)
+	}
+
+	// The merge base of main and feature is two commits behind feature
+	git(dir, "init", "-q", "-b", "main", upstream)
+	commit("README.md")
+	git(upstream, "checkout", "-q", "-b", "feature")
+	commit("feature.go")
+	commit("feature_test.go")
+	git(upstream, "checkout", "-q", "main")
+	commit("main.go")
+	git(dir, "clone", "-q", "--depth=1", "--single-branch", "--branch=feature", "file://"+upstream, "clone")
+	t.Chdir(filepath.Join(dir, "clone"))
+
+	files, err := parseDiffFromRefs("main", "")
+	if err != nil {
+		t.Fatalf("parseDiffFromRefs() error = %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.NewName)
+	}
+	if want := []string{"feature.go", "feature_test.go"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("parseDiffFromRefs() files = %q, want %q", names, want)
+	}
+}
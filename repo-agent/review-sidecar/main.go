@@ -18,20 +18,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"time"
 
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/sandbox"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 )
 
-const (
-	outputFile = "/workspaces/agent-output.txt"
-)
-
 var (
 	gvr = schema.GroupVersionResource{
 		Group:    "custom.agents.x-k8s.io",
@@ -41,15 +43,26 @@ var (
 )
 
 func main() {
-	fmt.Println("starting review sidecar")
+	// The run-log container of the sandbox pods only streams the run log,
+	// read by the API as its logs
+	if len(os.Args) > 1 && os.Args[1] == sandbox.RunLogContainer {
+		if err := sandbox.FollowFile(context.Background(), sandbox.RunLogFile, os.Stdout, time.Second); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to follow %s: %v\n", sandbox.RunLogFile, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logging.Setup("review-sidecar", os.Stderr)
+	slog.Info("starting review sidecar")
 	name := os.Getenv("NAME")
 	if name == "" {
-		fmt.Println("missing NAME env")
+		slog.Error("missing NAME env")
 		os.Exit(1)
 	}
 	namespace := os.Getenv("NAMESPACE")
 	if namespace == "" {
-		fmt.Println("missing NAMESPACE env")
+		slog.Error("missing NAMESPACE env")
 		os.Exit(1)
 	}
 
@@ -57,32 +70,60 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
+	config.Wrap(tracing.Transport)
+
+	// Draft updates continue the trace of the controller creating the sandbox
+	tracer := tracing.FromEnv("review-sidecar")
+	traceCtx := tracing.ContextWithTraceparent(context.Background(), os.Getenv("TRACEPARENT"))
 
 	dc, err := dynamic.NewForConfig(config)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	var last string
+	var artifacts sandbox.ArtifactStore
+	var artifactPrefix string
+	if bucketURL := os.Getenv("ARTIFACT_BUCKET_URL"); bucketURL != "" {
+		store, prefix, err := sandbox.NewArtifactStore(bucketURL)
+		if err != nil {
+			slog.Warn("artifact upload disabled", "error", err)
+		} else {
+			artifacts = store
+			artifactPrefix = path.Join(prefix, namespace, name)
+		}
+	}
+
+	var last, lastError string
 	for {
 		time.Sleep(10 * time.Second)
-		fmt.Println("watching for file", outputFile)
-		_, err := os.Stat(outputFile)
+		// The error is kept until a draft is published, since the sandbox
+		// removes it whenever it restarts the review
+		if b, err := os.ReadFile(sandbox.ErrorFile); err == nil && string(b) != lastError {
+			if err := publishError(traceCtx, dc, namespace, name, string(b)); err != nil {
+				slog.Error("unable to publish the review error", "error", err)
+			} else {
+				lastError = string(b)
+			}
+		}
+		slog.Debug("watching for file", "file", sandbox.OutputFile)
+		_, err := os.Stat(sandbox.OutputFile)
 		if os.IsNotExist(err) {
 			continue
 		}
-		b, err := os.ReadFile(outputFile)
+		b, err := os.ReadFile(sandbox.OutputFile)
 		if err != nil {
-			fmt.Println("reading file:", err)
+			slog.Error("unable to read file", "file", sandbox.OutputFile, "error", err)
 			continue
 		}
 		if string(b) == last {
 			continue
 		}
-		fmt.Println("file changed, updating crd")
-		rs, err := dc.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		slog.Info("file changed, updating crd")
+		ctx, span := tracer.Start(traceCtx, "review-sidecar.updateDraft", tracing.KindInternal)
+		rs, err := dc.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
-			fmt.Println("error getting reviewsandbox:", err)
+			slog.Error("unable to get reviewsandbox", "error", err)
+			span.End(err)
 			continue
 		}
 
@@ -92,15 +133,51 @@ func main() {
 		}
 		annotations := rs.GetAnnotations()
 		annotations["agentDraft"] = string(b)
+		delete(annotations, "agentError")
+		// The sandbox records its tokens before writing the draft
+		if usage, err := os.ReadFile(sandbox.UsageFile); err == nil {
+			if u, err := sandbox.ParseUsage(usage); err != nil {
+				slog.Error("unable to parse usage", "error", err)
+			} else if v, err := json.Marshal(u); err == nil {
+				annotations[sandbox.UsageAnnotation] = string(v)
+			}
+		}
+		if artifacts != nil {
+			url, err := sandbox.UploadArtifacts(ctx, artifacts, sandbox.WorkspacesDir, artifactPrefix)
+			if err != nil {
+				slog.Error("unable to upload artifacts", "error", err)
+			} else {
+				annotations["artifactURL"] = url
+			}
+		}
 		rs.SetAnnotations(annotations)
 
-		_, err = dc.Resource(gvr).Namespace(namespace).Update(context.TODO(), rs, metav1.UpdateOptions{})
+		_, err = dc.Resource(gvr).Namespace(namespace).Update(ctx, rs, metav1.UpdateOptions{})
+		span.End(err)
 		if err != nil {
-			fmt.Println("error updating reviewsandbox:", err)
+			slog.Error("unable to update reviewsandbox", "error", err)
 			continue
 		}
 
 		last = string(b)
-		fmt.Println("updated crd with latest changes")
+		lastError = ""
+		slog.Info("updated crd with latest changes")
+	}
+}
+
+// publishError records the error of a failed review in the agentError
+// annotation of the ReviewSandbox.
+func publishError(ctx context.Context, dc dynamic.Interface, namespace, name, msg string) error {
+	rs, err := dc.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	annotations := rs.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
 	}
+	annotations["agentError"] = msg
+	rs.SetAnnotations(annotations)
+	_, err = dc.Resource(gvr).Namespace(namespace).Update(ctx, rs, metav1.UpdateOptions{})
+	return err
 }
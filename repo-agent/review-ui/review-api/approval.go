@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// approvalRequired makes submitted reviews and comments wait for a second
+// user with the approver role before they are posted to GitHub.
+var approvalRequired = os.Getenv("APPROVAL_REQUIRED") == "true"
+
+// PendingApproval is a review or comment waiting for approval.
+type PendingApproval struct {
+	Content     string `json:"content"`
+	SubmittedBy string `json:"submittedBy,omitempty"`
+	SubmittedAt string `json:"submittedAt,omitempty"`
+}
+
+// getPendingApproval returns the review or comment pending approval in the
+// stored state of a PR or issue, or nil if there is none.
+func getPendingApproval(data map[string]string) *PendingApproval {
+	content, ok := data["pending"]
+	if !ok {
+		return nil
+	}
+	return &PendingApproval{
+		Content:     content,
+		SubmittedBy: data["pendingBy"],
+		SubmittedAt: data["pendingAt"],
+	}
+}
+
+// requestApproval stores content as pending approval on the PR or issue
+// stored at key instead of posting it.
+func requestApproval(c *gin.Context, key, content string) {
+	if err := storePendingApproval(c, key, content); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "pending approval"})
+}
+
+// storePendingApproval stores content as pending approval on the PR or issue
+// stored at key. It returns an *apiError on failure.
+func storePendingApproval(c *gin.Context, key, content string) error {
+	if err := store.HSet(c.Request.Context(), key,
+		"pending", content,
+		"pendingBy", requestUser(c),
+		"pendingAt", time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		log.Printf("Failed to store %s pending approval: %v", key, err)
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to save for approval", err: err}
+	}
+	return nil
+}
+
+// pendingForApproval returns the review or comment the requesting user can
+// approve or reject on the PR or issue stored at key. It responds with an
+// error and returns nil if there is none or the user submitted it.
+func pendingForApproval(c *gin.Context, key string) *PendingApproval {
+	data, err := store.HGetAll(c.Request.Context(), key)
+	if err != nil {
+		log.Printf("Failed to get %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pending approval"})
+		return nil
+	}
+	pending := getPendingApproval(data)
+	if pending == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "nothing is pending approval"})
+		return nil
+	}
+	user := requestUser(c)
+	if user == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+		return nil
+	}
+	if user == pending.SubmittedBy {
+		c.JSON(http.StatusForbidden, gin.H{"error": "must be approved by another user"})
+		return nil
+	}
+	// Record who submitted it next to the approver
+	c.Set(auditSubmitterKey, pending.SubmittedBy)
+	return pending
+}
+
+// clearPendingApproval removes the review or comment pending approval of the
+// PR or issue stored at key.
+func clearPendingApproval(c *gin.Context, key string) bool {
+	if err := store.HDel(c.Request.Context(), key, "pending", "pendingBy", "pendingAt"); err != nil {
+		log.Printf("Failed to clear pending approval of %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear pending approval"})
+		return false
+	}
+	return true
+}
+
+func approveReview(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	prID := c.Param("id")
+	pending := pendingForApproval(c, prKey(repo, prID))
+	if pending == nil {
+		return
+	}
+	if err := postReview(c, namespace, repo, prID, pending.Content); err != nil {
+		respondError(c, err)
+		return
+	}
+	if clearPendingApproval(c, prKey(repo, prID)) {
+		c.Status(http.StatusOK)
+	}
+}
+
+func rejectReview(c *gin.Context) {
+	repo := c.Param("repo")
+	prID := c.Param("id")
+	if pendingForApproval(c, prKey(repo, prID)) == nil {
+		return
+	}
+	if clearPendingApproval(c, prKey(repo, prID)) {
+		c.Status(http.StatusOK)
+	}
+}
+
+func approveIssueComment(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	issueID := c.Param("issue_id")
+	handler := c.Param("handler")
+	pending := pendingForApproval(c, issueKey(repo, handler, issueID))
+	if pending == nil {
+		return
+	}
+	if err := postIssueComment(c, namespace, repo, handler, issueID, pending.Content); err != nil {
+		respondError(c, err)
+		return
+	}
+	if clearPendingApproval(c, issueKey(repo, handler, issueID)) {
+		c.Status(http.StatusOK)
+	}
+}
+
+func rejectIssueComment(c *gin.Context) {
+	repo := c.Param("repo")
+	issueID := c.Param("issue_id")
+	handler := c.Param("handler")
+	if pendingForApproval(c, issueKey(repo, handler, issueID)) == nil {
+		return
+	}
+	if clearPendingApproval(c, issueKey(repo, handler, issueID)) {
+		c.Status(http.StatusOK)
+	}
+}
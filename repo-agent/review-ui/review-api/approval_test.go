@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestApprovalRequiresAnotherUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	oldHeader := userHeader
+	userHeader = "X-User"
+	defer func() { userHeader = oldHeader }()
+	ctx := context.Background()
+	key := prKey("kubernetes", "1")
+
+	request := func(user string) (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		c.Request.Header.Set("X-User", user)
+		c.Params = gin.Params{{Key: "repo", Value: "kubernetes"}, {Key: "id", Value: "1"}}
+		return c, w
+	}
+
+	c, w := request("alice")
+	requestApproval(c, key, "LGTM")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("requestApproval() status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+
+	c, w = request("alice")
+	rejectReview(c)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("rejectReview() by the submitter status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	c, w = request("bob")
+	rejectReview(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rejectReview() by another user status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if got := c.GetString(auditSubmitterKey); got != "alice" {
+		t.Errorf("audited submitter = %q, want alice", got)
+	}
+	data, err := store.HGetAll(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := getPendingApproval(data); p != nil {
+		t.Errorf("pending approval after reject = %+v, want none", p)
+	}
+
+	c, w = request("bob")
+	rejectReview(c)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("rejectReview() without pending approval status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// auditDateFormat is the format of the day in audit keys and in the
+	// since and until query parameters.
+	auditDateFormat = "2006-01-02"
+	// maxAuditDays is the longest range /api/audit returns at once.
+	maxAuditDays = 90
+
+	// auditNamespaceKey and auditRepoKey are the context keys for the
+	// RepoWatch of requests without namespace and repo parameters.
+	auditNamespaceKey = "auditNamespace"
+	auditRepoKey      = "auditRepo"
+	// auditSubmitterKey is the context key for the user who submitted an
+	// approved or rejected review or comment.
+	auditSubmitterKey = "auditSubmitter"
+	// auditTargetKey is the context key for the target of requests acting on
+	// several PRs or issues.
+	auditTargetKey = "auditTarget"
+)
+
+// AuditEntry records a mutating action done through the API.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	// Target is the PR, issue or issue handler acted on
+	Target string `json:"target,omitempty"`
+	// Submitter is who submitted the review or comment of an approval, the
+	// User being the approver
+	Submitter string `json:"submitter,omitempty"`
+	Status    int    `json:"status"`
+}
+
+// auditKey returns the key of the hash holding the audit entries of a day.
+func auditKey(day time.Time) string {
+	return fmt.Sprintf("audit:%s", day.UTC().Format(auditDateFormat))
+}
+
+// recordAudit stores an audit entry. Entries are grouped by day so that date
+// ranges can be queried without scanning the whole log.
+func recordAudit(ctx context.Context, e AuditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return err
+	}
+	field := e.Time.UTC().Format(time.RFC3339Nano) + "-" + id
+	return store.HSet(ctx, auditKey(e.Time), field, string(b))
+}
+
+// audit is a middleware recording action in the audit log once the request
+// succeeded.
+func audit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		e := AuditEntry{
+			Time:      time.Now().UTC(),
+			User:      requestUser(c),
+			Action:    action,
+			Namespace: c.Param("namespace"),
+			Repo:      c.Param("repo"),
+			Submitter: c.GetString(auditSubmitterKey),
+			Status:    c.Writer.Status(),
+		}
+		if e.Repo == "" {
+			e.Repo = c.Param("name")
+		}
+		// Set by handlers taking the RepoWatch from the body
+		if e.Namespace == "" {
+			e.Namespace = c.GetString(auditNamespaceKey)
+		}
+		if e.Repo == "" {
+			e.Repo = c.GetString(auditRepoKey)
+		}
+		if e.User == "" {
+			e.User = "anonymous"
+		}
+		for _, p := range []string{"id", "issue_id", "handler", "template"} {
+			if v := c.Param(p); v != "" {
+				if e.Target != "" {
+					e.Target += "/"
+				}
+				e.Target += v
+			}
+		}
+		if target := c.GetString(auditTargetKey); target != "" {
+			e.Target = target
+		}
+		if err := recordAudit(c.Request.Context(), e); err != nil {
+			log.Printf("Failed to record audit entry %+v: %v", e, err)
+		}
+	}
+}
+
+// parseDate parses a day or RFC 3339 time.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(auditDateFormat, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// getAudit returns the audit entries, newest first, filtered by the user,
+// namespace and repo query parameters. since and until bound the range and
+// default to the last 7 days.
+func getAudit(c *gin.Context) {
+	until := time.Now().UTC()
+	since := until.AddDate(0, 0, -7)
+	var err error
+	if s := c.Query("since"); s != "" {
+		if since, err = parseDate(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + err.Error()})
+			return
+		}
+	}
+	if s := c.Query("until"); s != "" {
+		if until, err = parseDate(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid until: " + err.Error()})
+			return
+		}
+		if len(s) == len(auditDateFormat) {
+			// Include the whole day
+			until = until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	if until.Before(since) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until is before since"})
+		return
+	}
+	if until.Sub(since) > maxAuditDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range is longer than %d days", maxAuditDays)})
+		return
+	}
+
+	user := c.Query("user")
+	namespace := c.Query("namespace")
+	repo := c.Query("repo")
+	viewer := requestUser(c)
+	roles := map[string]role{}
+	isAdmin := func(namespace, repo string) bool {
+		if !rbacEnabled {
+			return true
+		}
+		key := namespace + "/" + repo
+		r, ok := roles[key]
+		if !ok {
+			r = k8sCache.roleFor(viewer, namespace, repo)
+			roles[key] = r
+		}
+		return r >= roleAdmin
+	}
+
+	entries := []AuditEntry{}
+	start := since.UTC().Truncate(24 * time.Hour)
+	for day := start; !day.After(until); day = day.AddDate(0, 0, 1) {
+		data, err := store.HGetAll(c.Request.Context(), auditKey(day))
+		if err != nil {
+			log.Printf("Failed to get audit entries of %s: %v", day.Format(auditDateFormat), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit entries"})
+			return
+		}
+		for _, v := range data {
+			var e AuditEntry
+			if err := json.Unmarshal([]byte(v), &e); err != nil {
+				log.Printf("Failed to parse audit entry %q: %v", v, err)
+				continue
+			}
+			if e.Time.Before(since) || e.Time.After(until) {
+				continue
+			}
+			if (user != "" && e.User != user) || (namespace != "" && e.Namespace != namespace) || (repo != "" && e.Repo != repo) {
+				continue
+			}
+			// Only admins see the audit log of a repo
+			if !isAdmin(e.Namespace, e.Repo) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	ctx := context.Background()
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, e := range []AuditEntry{
+		{Time: day, User: "alice", Action: "pr.review.submit", Namespace: "default", Repo: "kubernetes", Target: "1"},
+		{Time: day.Add(time.Hour), User: "bob", Action: "pr.draft.save", Namespace: "default", Repo: "kubernetes", Target: "2"},
+		{Time: day.AddDate(0, 0, 1), User: "alice", Action: "issue.sandbox.delete", Namespace: "default", Repo: "linux", Target: "3/triage"},
+		{Time: day.AddDate(0, 0, -10), User: "alice", Action: "repowatch.delete", Namespace: "default", Repo: "redis"},
+	} {
+		if err := recordAudit(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query       string
+		wantTargets []string
+	}{
+		{query: "since=2025-03-10&until=2025-03-11", wantTargets: []string{"3/triage", "2", "1"}},
+		{query: "since=2025-03-10&until=2025-03-11&user=alice", wantTargets: []string{"3/triage", "1"}},
+		{query: "since=2025-03-10&until=2025-03-10&repo=kubernetes", wantTargets: []string{"2", "1"}},
+		{query: "since=2025-03-10T12:30:00Z&until=2025-03-10", wantTargets: []string{"2"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/audit?"+tt.query, nil)
+		getAudit(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("getAudit(%s) status = %d: %s", tt.query, w.Code, w.Body.String())
+		}
+		var entries []AuditEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		var targets []string
+		for _, e := range entries {
+			targets = append(targets, e.Target)
+		}
+		if len(targets) != len(tt.wantTargets) {
+			t.Errorf("getAudit(%s) = %v, want %v", tt.query, targets, tt.wantTargets)
+			continue
+		}
+		for i := range targets {
+			if targets[i] != tt.wantTargets[i] {
+				t.Errorf("getAudit(%s) = %v, want %v", tt.query, targets, tt.wantTargets)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+	githuboauth "golang.org/x/oauth2/github"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	sessionCookie    = "review_session"
+	oauthStateCookie = "review_oauth_state"
+	sessionTTL       = 7 * 24 * time.Hour
+)
+
+// oauthConfig is the GitHub OAuth app users log in with. It is nil when
+// GITHUB_OAUTH_CLIENT_ID is not set, in which case everything is posted with
+// the RepoWatch's token.
+var oauthConfig *oauth2.Config
+
+// session is a logged in GitHub user.
+type session struct {
+	Login string
+	Token string
+}
+
+// initOAuth configures the GitHub OAuth app from the environment. The repo
+// scope lets reviews and comments be posted as the user.
+func initOAuth() {
+	clientID := os.Getenv("GITHUB_OAUTH_CLIENT_ID")
+	if clientID == "" {
+		log.Println("GITHUB_OAUTH_CLIENT_ID not set, posting to GitHub with the repo tokens")
+		return
+	}
+	oauthConfig = &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("GITHUB_OAUTH_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("GITHUB_OAUTH_REDIRECT_URL"),
+		Scopes:       []string{"repo"},
+		Endpoint:     githuboauth.Endpoint,
+	}
+	// The OAuth app of a GitHub Enterprise Server is registered on it
+	if githubAPIURL != "" {
+		host := githubHost(githubAPIURL)
+		oauthConfig.Endpoint = oauth2.Endpoint{
+			AuthURL:  fmt.Sprintf("https://%s/login/oauth/authorize", host),
+			TokenURL: fmt.Sprintf("https://%s/login/oauth/access_token", host),
+		}
+	}
+}
+
+var (
+	// cookieSecure is COOKIE_SECURE: "true" or "false" to force the Secure
+	// attribute of the cookies, or empty to set it on HTTPS requests,
+	// including those forwarded by a TLS terminating proxy.
+	cookieSecure = os.Getenv("COOKIE_SECURE")
+	// cookieSameSite is the SameSite attribute of the session and CSRF
+	// cookies, COOKIE_SAMESITE lax (default), strict or none.
+	cookieSameSite = parseSameSite(os.Getenv("COOKIE_SAMESITE"))
+	// cookieDomain is the Domain attribute of the cookies, COOKIE_DOMAIN.
+	cookieDomain = os.Getenv("COOKIE_DOMAIN")
+)
+
+// sessionSecret signs the CSRF tokens. It is SESSION_SECRET, shared by the
+// replicas and kept across restarts, or random if that is not set.
+var sessionSecret []byte
+
+func parseSameSite(s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "", "lax":
+		return http.SameSiteLaxMode
+	default:
+		log.Printf("Invalid COOKIE_SAMESITE %q, using lax", s)
+		return http.SameSiteLaxMode
+	}
+}
+
+// initSessionSecret loads the session secret from the environment.
+func initSessionSecret() {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		sessionSecret = []byte(secret)
+		return
+	}
+	log.Println("SESSION_SECRET not set, using a random secret. CSRF tokens are invalidated on restart and not shared by replicas")
+	secret, err := randomHex(32)
+	if err != nil {
+		log.Fatalf("Failed to generate session secret: %v", err)
+	}
+	sessionSecret = []byte(secret)
+}
+
+// isHTTPS reports whether the request was made over HTTPS, directly or
+// through a TLS terminating proxy.
+func isHTTPS(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// secureCookie returns the Secure attribute of a cookie set in response to a
+// request.
+func secureCookie(c *gin.Context, sameSite http.SameSite) bool {
+	secure := isHTTPS(c)
+	switch cookieSecure {
+	case "true":
+		secure = true
+	case "false":
+		secure = false
+	}
+	// Browsers reject SameSite=None cookies that are not Secure
+	if sameSite == http.SameSiteNoneMode {
+		secure = true
+	}
+	return secure
+}
+
+// setCookie sets a cookie with the configured Secure and Domain attributes.
+// Cookies are HttpOnly unless they are read by the UI.
+func setCookie(c *gin.Context, name, value string, maxAge int, path string, sameSite http.SameSite) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     path,
+		Domain:   cookieDomain,
+		Secure:   secureCookie(c, sameSite),
+		HttpOnly: name != csrfCookie,
+		SameSite: sameSite,
+	})
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("session:%s", id)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// redirectURL returns the OAuth callback URL, derived from the request when
+// GITHUB_OAUTH_REDIRECT_URL is not set.
+func redirectURL(c *gin.Context) string {
+	if oauthConfig.RedirectURL != "" {
+		return oauthConfig.RedirectURL
+	}
+	scheme := "http"
+	if isHTTPS(c) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/auth/callback", scheme, c.Request.Host)
+}
+
+// login redirects the user to GitHub to consent to the repo scope.
+func login(c *gin.Context) {
+	if oauthConfig == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "GitHub login is not configured"})
+		return
+	}
+	state, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+		return
+	}
+	// GitHub redirects back cross-site, so the state cookie can't be strict
+	setCookie(c, oauthStateCookie, state, 600, "/api/auth", http.SameSiteLaxMode)
+	c.Redirect(http.StatusFound, oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("redirect_uri", redirectURL(c))))
+}
+
+// oauthCallback exchanges the authorization code for the user's token and
+// starts a session.
+func oauthCallback(c *gin.Context) {
+	if oauthConfig == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "GitHub login is not configured"})
+		return
+	}
+	state, err := c.Cookie(oauthStateCookie)
+	if err != nil || state == "" || state != c.Query("state") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid oauth state"})
+		return
+	}
+	setCookie(c, oauthStateCookie, "", -1, "/api/auth", http.SameSiteLaxMode)
+
+	ctx := c.Request.Context()
+	token, err := oauthConfig.Exchange(ctx, c.Query("code"), oauth2.SetAuthURLParam("redirect_uri", redirectURL(c)))
+	if err != nil {
+		log.Printf("Failed to exchange oauth code: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to log in with GitHub"})
+		return
+	}
+	client, err := newGitHubAPIClient(oauthConfig.Client(ctx, token), githubAPIURL, githubUploadURL)
+	if err != nil {
+		log.Printf("Failed to create GitHub client: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create GitHub client"})
+		return
+	}
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		log.Printf("Failed to get GitHub user: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to get GitHub user"})
+		return
+	}
+
+	id, err := randomHex(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	if err := store.HSet(ctx, sessionKey(id),
+		"login", user.GetLogin(),
+		"token", token.AccessToken,
+		"expiry", time.Now().Add(sessionTTL).Unix(),
+	); err != nil {
+		log.Printf("Failed to store session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	log.Printf("User %s logged in", user.GetLogin())
+	setCookie(c, sessionCookie, id, int(sessionTTL.Seconds()), "/", cookieSameSite)
+	c.Redirect(http.StatusFound, "/")
+}
+
+func logout(c *gin.Context) {
+	if id, err := c.Cookie(sessionCookie); err == nil && id != "" {
+		if err := store.Del(c.Request.Context(), sessionKey(id)); err != nil {
+			log.Printf("Failed to delete session: %v", err)
+		}
+	}
+	setCookie(c, sessionCookie, "", -1, "/", cookieSameSite)
+	c.Status(http.StatusOK)
+}
+
+// currentUser reports whether GitHub login is enabled and who is logged in.
+func currentUser(c *gin.Context) {
+	resp := gin.H{"loginEnabled": oauthConfig != nil}
+	if s := getSession(c); s != nil {
+		resp["login"] = s.Login
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// getSession returns the session of the request, or nil if the user is not
+// logged in.
+func getSession(c *gin.Context) *session {
+	id, err := c.Cookie(sessionCookie)
+	if err != nil || id == "" {
+		return nil
+	}
+	data, err := store.HGetAll(c.Request.Context(), sessionKey(id))
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Failed to get session: %v", err)
+		}
+		return nil
+	}
+	if data["token"] == "" {
+		return nil
+	}
+	if expiry, _ := strconv.ParseInt(data["expiry"], 10, 64); time.Now().Unix() > expiry {
+		if err := store.Del(c.Request.Context(), sessionKey(id)); err != nil {
+			log.Printf("Failed to delete expired session: %v", err)
+		}
+		return nil
+	}
+	return &session{Login: data["login"], Token: data["token"]}
+}
+
+// callGitHub calls call with a client acting as the logged in user, and
+// falls back to the RepoWatch's token if there is no session or the user's
+// token is not allowed to access the repo. It returns who made the call, the
+// user's login or "bot".
+func callGitHub(c *gin.Context, repoWatch *unstructured.Unstructured, call func(*github.Client) (*github.Response, error)) (string, error) {
+	ctx := c.Request.Context()
+	if s := getSession(c); s != nil {
+		client, err := newGitHubClient(ctx, repoWatch, s.Token)
+		if err != nil {
+			return "", err
+		}
+		resp, err := call(client)
+		if err == nil {
+			return s.Login, nil
+		}
+		countGitHubError(resp)
+		if resp == nil || (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusNotFound) {
+			return "", err
+		}
+		log.Printf("User %s can't access the repo on GitHub, falling back to the repo token: %v", s.Login, err)
+	}
+
+	token, err := getGitHubToken(ctx, repoWatch)
+	if err != nil {
+		return "", fmt.Errorf("failed to get github token: %w", err)
+	}
+	client, err := newGitHubClient(ctx, repoWatch, token)
+	if err != nil {
+		return "", err
+	}
+	if resp, err := call(client); err != nil {
+		countGitHubError(resp)
+		return "", err
+	}
+	return "bot", nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkItems is the most PRs or issues a bulk request can act on.
+const maxBulkItems = 100
+
+// Bulk actions on PRs and issues.
+const (
+	// bulkScaleDown scales down the sandboxes, keeping the drafts.
+	bulkScaleDown = "scaledown"
+	// bulkDelete scales down the sandboxes and deletes the drafts, like
+	// deleting them one by one.
+	bulkDelete = "delete"
+	// bulkSubmit submits the user's draft, or the agent's if the user has not
+	// edited it, like submitting them one by one.
+	bulkSubmit = "submit"
+)
+
+type bulkPayload struct {
+	IDs    []string `json:"ids"`
+	Action string   `json:"action"`
+	// Handler is the issue handler of the issues
+	Handler string `json:"handler"`
+}
+
+// BulkResult is the outcome of a bulk action on a PR or issue.
+type BulkResult struct {
+	ID string `json:"id"`
+	// Status is ok, pending approval or error
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bindBulkPayload binds and validates a bulk request. It responds with an
+// error and returns false if it is invalid.
+func bindBulkPayload(c *gin.Context, payload *bulkPayload) bool {
+	if err := c.ShouldBindJSON(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	switch payload.Action {
+	case bulkScaleDown, bulkDelete, bulkSubmit:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown action %q, must be %s, %s or %s", payload.Action, bulkScaleDown, bulkDelete, bulkSubmit)})
+		return false
+	}
+	if len(payload.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must not be empty"})
+		return false
+	}
+	if len(payload.IDs) > maxBulkItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d ids can be acted on at once", maxBulkItems)})
+		return false
+	}
+	// Audited as a single entry
+	c.Set(auditTargetKey, payload.Action+" "+strings.Join(payload.IDs, ","))
+	return true
+}
+
+// runBulk runs do on each ID and responds with the results. A failure on one
+// ID does not stop the others.
+func runBulk(c *gin.Context, ids []string, do func(id string) (string, error)) {
+	results := make([]BulkResult, 0, len(ids))
+	failed := 0
+	for _, id := range ids {
+		status, err := do(id)
+		if err != nil {
+			failed++
+			results = append(results, BulkResult{ID: id, Status: "error", Error: err.Error()})
+			continue
+		}
+		results = append(results, BulkResult{ID: id, Status: status})
+	}
+	code := http.StatusOK
+	if failed == len(ids) {
+		code = http.StatusInternalServerError
+	}
+	c.JSON(code, bulkResponse{Results: results, Failed: failed})
+}
+
+// bulkSubmitContent returns the draft to submit for the PR or issue stored at
+// key, or an error if there is nothing to submit.
+func bulkSubmitContent(ctx context.Context, key, agentDraft string) (string, error) {
+	data, err := store.HGetAll(ctx, key)
+	if err != nil {
+		return "", &apiError{status: http.StatusInternalServerError, message: "Failed to get draft", err: err}
+	}
+	if data["pending"] != "" {
+		return "", &apiError{status: http.StatusConflict, message: "already pending approval"}
+	}
+	if draft := data["draft"]; draft != "" {
+		return draft, nil
+	}
+	// The draft is cleared once submitted
+	if data["review"] != "" || data["comment"] != "" {
+		return "", &apiError{status: http.StatusConflict, message: "already submitted"}
+	}
+	if agentDraft == "" {
+		return "", &apiError{status: http.StatusConflict, message: "no draft to submit"}
+	}
+	return agentDraft, nil
+}
+
+// bulkPRs scales down, deletes or submits the drafts of several PRs.
+func bulkPRs(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	var payload bulkPayload
+	if !bindBulkPayload(c, &payload) {
+		return
+	}
+	ctx := c.Request.Context()
+	runBulk(c, payload.IDs, func(prID string) (string, error) {
+		switch payload.Action {
+		case bulkScaleDown:
+			return "ok", scaledownSandbox(ctx, namespace, repo, prID)
+		case bulkDelete:
+			return "ok", discardPR(ctx, namespace, repo, prID)
+		}
+		review, err := bulkSubmitContent(ctx, prKey(repo, prID), prAgentDraft(namespace, repo, prID))
+		if err != nil {
+			return "", err
+		}
+		if approvalRequired {
+			return "pending approval", storePendingApproval(c, prKey(repo, prID), review)
+		}
+		return "ok", postReview(c, namespace, repo, prID, review)
+	})
+}
+
+// bulkIssues scales down, deletes or submits the drafts of several issues of
+// an issue handler.
+func bulkIssues(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	var payload bulkPayload
+	if !bindBulkPayload(c, &payload) {
+		return
+	}
+	handler := payload.Handler
+	if handler == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "handler is required"})
+		return
+	}
+	ctx := c.Request.Context()
+	runBulk(c, payload.IDs, func(issueID string) (string, error) {
+		switch payload.Action {
+		case bulkScaleDown:
+			return "ok", scaledownIssueSandbox(ctx, namespace, repo, issueID, handler)
+		case bulkDelete:
+			return "ok", discardIssue(ctx, namespace, repo, issueID, handler)
+		}
+		comment, err := bulkSubmitContent(ctx, issueKey(repo, handler, issueID), issueAgentDraft(namespace, repo, handler, issueID))
+		if err != nil {
+			return "", err
+		}
+		if approvalRequired {
+			return "pending approval", storePendingApproval(c, issueKey(repo, handler, issueID), comment)
+		}
+		return "ok", postIssueComment(c, namespace, repo, handler, issueID, comment)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestBulkRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// The static bulk routes live next to the PR and issue routes
+	router.POST("/repo/:namespace/:repo/prs/bulk", bulkPRs)
+	router.POST("/repo/:namespace/:repo/prs/:id/draft", saveDraft)
+	router.POST("/repo/:namespace/:repo/issues/bulk", bulkIssues)
+	router.POST("/repo/:namespace/:repo/issues/:issue_id/handler/:handler/draft", saveIssueDraft)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/repo/team-a/kubernetes/prs/bulk", strings.NewReader(`{"action": "merge", "ids": ["1"]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown action status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/repo/team-a/kubernetes/issues/bulk", strings.NewReader(`{"action": "delete", "ids": ["1"]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("issues without handler status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestBulkSubmitPRsForApproval(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	oldCache, oldApproval := k8sCache, approvalRequired
+	defer func() { k8sCache, approvalRequired = oldCache, oldApproval }()
+	approvalRequired = true
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "kubernetes-pr-2",
+			"namespace":   "team-a",
+			"labels":      map[string]interface{}{"review.gemini.google.com/repowatch": "kubernetes"},
+			"annotations": map[string]interface{}{"agentDraft": "agent review"},
+		},
+		"spec": map[string]interface{}{"source": map[string]interface{}{"pr": "2"}},
+	}}
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{reviewSandboxGVR: {sandbox}})
+	ctx := context.Background()
+	if err := store.HSet(ctx, prKey("kubernetes", "1"), "draft", "user review"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.HSet(ctx, prKey("kubernetes", "3"), "review", "posted", "draft", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"action": "submit", "ids": ["1", "2", "3"]}`))
+	c.Params = gin.Params{{Key: "namespace", Value: "team-a"}, {Key: "repo", Value: "kubernetes"}}
+	bulkPRs(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulkPRs() status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var resp struct {
+		Results []BulkResult
+		Failed  int
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []BulkResult{
+		{ID: "1", Status: "pending approval"},
+		{ID: "2", Status: "pending approval"},
+		{ID: "3", Status: "error", Error: "already submitted"},
+	}
+	if len(resp.Results) != len(want) || resp.Failed != 1 {
+		t.Fatalf("bulkPRs() = %+v, want %+v", resp, want)
+	}
+	for i := range want {
+		if resp.Results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, resp.Results[i], want[i])
+		}
+	}
+	if got := c.GetString(auditTargetKey); got != "submit 1,2,3" {
+		t.Errorf("audited target = %q, want %q", got, "submit 1,2,3")
+	}
+
+	for id, content := range map[string]string{"1": "user review", "2": "agent review"} {
+		data, err := store.HGetAll(ctx, prKey("kubernetes", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := getPendingApproval(data); p == nil || p.Content != content {
+			t.Errorf("PR %s pending approval = %+v, want %q", id, p, content)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	repoWatchGVR = schema.GroupVersionResource{
+		Group:    "review.gemini.google.com",
+		Version:  "v1alpha1",
+		Resource: "repowatches",
+	}
+	reviewSandboxGVR = schema.GroupVersionResource{
+		Group:    "custom.agents.x-k8s.io",
+		Version:  "v1alpha1",
+		Resource: "reviewsandboxes",
+	}
+	issueSandboxGVR = schema.GroupVersionResource{
+		Group:    "custom.agents.x-k8s.io",
+		Version:  "v1alpha1",
+		Resource: "issuesandboxes",
+	}
+	configMapGVR = schema.GroupVersionResource{
+		Version:  "v1",
+		Resource: "configmaps",
+	}
+)
+
+// resyncPeriod is how often the informers replay their cached objects.
+const resyncPeriod = 10 * time.Minute
+
+// resourceCache is an informer backed, namespace indexed cache of the
+// RepoWatches and sandboxes served by the API.
+type resourceCache struct {
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+}
+
+// newResourceCache starts watching RepoWatches, sandboxes and role
+// ConfigMaps in all namespaces and waits for the initial list.
+func newResourceCache(ctx context.Context, client dynamic.Interface) (*resourceCache, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, resyncPeriod)
+	// Only the role ConfigMaps are cached
+	rolesFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, resyncPeriod, metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", rolesConfigMapName).String()
+	})
+	c := &resourceCache{informers: map[schema.GroupVersionResource]cache.SharedIndexInformer{}}
+	for _, gvr := range []schema.GroupVersionResource{repoWatchGVR, reviewSandboxGVR, issueSandboxGVR} {
+		c.informers[gvr] = factory.ForResource(gvr).Informer()
+	}
+	c.informers[configMapGVR] = rolesFactory.ForResource(configMapGVR).Informer()
+	for _, f := range []dynamicinformer.DynamicSharedInformerFactory{factory, rolesFactory} {
+		f.Start(ctx.Done())
+		for gvr, synced := range f.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return nil, fmt.Errorf("failed to sync cache for %s", gvr.Resource)
+			}
+		}
+	}
+	log.Println("Kubernetes cache synced")
+	return c, nil
+}
+
+// list returns the cached objects of a resource in namespace, or in all
+// namespaces if namespace is empty, that match selector.
+func (c *resourceCache) list(gvr schema.GroupVersionResource, namespace string, selector labels.Selector) []*unstructured.Unstructured {
+	var objs []*unstructured.Unstructured
+	appendObj := func(obj interface{}) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if ok && selector.Matches(labels.Set(u.GetLabels())) {
+			objs = append(objs, u)
+		}
+	}
+	indexer := c.informers[gvr].GetIndexer()
+	if namespace == "" {
+		for _, obj := range indexer.List() {
+			appendObj(obj)
+		}
+		return objs
+	}
+	items, err := indexer.ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		log.Printf("Failed to list cached %s in %s: %v", gvr.Resource, namespace, err)
+		return nil
+	}
+	for _, obj := range items {
+		appendObj(obj)
+	}
+	return objs
+}
+
+// get returns a cached object. The returned object must not be modified.
+func (c *resourceCache) get(gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	obj, exists, err := c.informers[gvr].GetIndexer().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s %s/%s not found", gvr.Resource, namespace, name)
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	return u, nil
+}
+
+// repoSandboxes returns the sandboxes of a resource created for a RepoWatch,
+// optionally restricted to an issue handler.
+func (c *resourceCache) repoSandboxes(gvr schema.GroupVersionResource, namespace, repo, handler string) []*unstructured.Unstructured {
+	set := labels.Set{"review.gemini.google.com/repowatch": repo}
+	if handler != "" {
+		set["review.gemini.google.com/handler"] = handler
+	}
+	return c.list(gvr, namespace, labels.SelectorFromSet(set))
+}
+
+// findSandbox returns the sandbox of a RepoWatch whose spec.source[field] is
+// id, or nil.
+func (c *resourceCache) findSandbox(gvr schema.GroupVersionResource, namespace, repo, handler, field, id string) *unstructured.Unstructured {
+	for _, sandbox := range c.repoSandboxes(gvr, namespace, repo, handler) {
+		if v, _, _ := unstructured.NestedString(sandbox.Object, "spec", "source", field); v == id {
+			return sandbox
+		}
+	}
+	return nil
+}
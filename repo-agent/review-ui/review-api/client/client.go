@@ -0,0 +1,164 @@
+// Package client is a typed client of the review API. Its types and methods
+// are generated from the OpenAPI spec served by the API at /api/openapi.json.
+package client
+
+//go:generate sh -c "cd .. && go run . openapi > client/openapi.json"
+//go:generate go run ./gen -spec openapi.json -out zz_generated.client.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+const (
+	// csrfCookie and csrfHeader are how the API protects mutating requests
+	// from CSRF, see csrf.go of the API.
+	csrfCookie = "review_csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Client calls the review API.
+type Client struct {
+	// BaseURL is the URL of the API, e.g. https://review.example.com/api
+	BaseURL string
+	// HTTPClient sends the requests. It needs a cookie jar for the CSRF
+	// token of the API.
+	HTTPClient *http.Client
+	// Header is added to every request, e.g. the user header of an
+	// authenticating proxy.
+	Header http.Header
+}
+
+// New returns a client of the API at baseURL.
+func New(baseURL string) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Jar: jar},
+		Header:     http.Header{},
+	}
+}
+
+// Error is an error response of the API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("review API: %d %s", e.StatusCode, e.Message)
+}
+
+// do sends a request with body encoded as JSON if not nil, and decodes the
+// response into out if not nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// stream sends a request and returns the body of the response, which the
+// caller must close.
+func (c *Client) stream(ctx context.Context, method, path string, query url.Values) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, method, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// send sends a request, returning an *Error for non 2xx responses.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method != http.MethodGet && method != http.MethodHead {
+		token, err := c.csrfToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set(csrfHeader, token)
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			apiErr.Message = e.Error
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// csrfToken returns the CSRF token of the cookie jar, getting one from the
+// API first if there is none.
+func (c *Client) csrfToken(ctx context.Context) (string, error) {
+	if c.HTTPClient.Jar == nil {
+		return "", nil
+	}
+	u, err := url.Parse(c.BaseURL + "/")
+	if err != nil {
+		return "", err
+	}
+	find := func() string {
+		for _, cookie := range c.HTTPClient.Jar.Cookies(u) {
+			if cookie.Name == csrfCookie {
+				return cookie.Value
+			}
+		}
+		return ""
+	}
+	if token := find(); token != "" {
+		return token, nil
+	}
+	// Any GET issues a token
+	if _, err := c.CurrentUser(ctx); err != nil {
+		return "", err
+	}
+	return find(), nil
+}
@@ -0,0 +1,302 @@
+// Command gen generates the types and methods of the typed client from the
+// OpenAPI spec of the review API.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Items                *schema            `json:"items"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	AllOf                []*schema          `json:"allOf"`
+	Required             []string           `json:"required"`
+	GoName               string             `json:"x-go-name"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type parameter struct {
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type operation struct {
+	OperationID string      `json:"operationId"`
+	Summary     string      `json:"summary"`
+	Parameters  []parameter `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"responses"`
+}
+
+type spec struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+func main() {
+	specFile := flag.String("spec", "openapi.json", "OpenAPI spec to generate the client of")
+	out := flag.String("out", "zz_generated.client.go", "file to write the client to")
+	flag.Parse()
+
+	b, err := os.ReadFile(*specFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the source of the client of the API described by b.
+func generate(b []byte) ([]byte, error) {
+	var s spec
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse the spec: %w", err)
+	}
+	g := &generator{}
+
+	for _, name := range sortedKeys(s.Components.Schemas) {
+		g.printf("type %s %s\n\n", name, g.goType(s.Components.Schemas[name]))
+	}
+
+	for _, path := range sortedKeys(s.Paths) {
+		for _, method := range sortedKeys(s.Paths[path]) {
+			if err := g.method(path, strings.ToUpper(method), s.Paths[path][method]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString("// Code generated by gen from openapi.json. DO NOT EDIT.\n\npackage client\n\nimport (\n")
+	for _, pkg := range []string{"context", "fmt", "io", "net/url", "time"} {
+		name := pkg[strings.LastIndex(pkg, "/")+1:]
+		if strings.Contains(g.buf.String(), name+".") {
+			fmt.Fprintf(&file, "%q\n", pkg)
+		}
+	}
+	file.WriteString(")\n\n")
+	file.Write(g.buf.Bytes())
+	src, err := format.Source(file.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format the client: %w\n%s", err, file.Bytes())
+	}
+	return src, nil
+}
+
+type generator struct {
+	buf bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// goType returns the Go type of a schema.
+func (g *generator) goType(s *schema) string {
+	if s == nil {
+		return "interface{}"
+	}
+	if s.Ref != "" {
+		return s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+	}
+	if len(s.AllOf) == 1 {
+		t := g.goType(s.AllOf[0])
+		if s.Nullable {
+			return "*" + t
+		}
+		return t
+	}
+	var t string
+	switch s.Type {
+	case "string":
+		t = "string"
+		if s.Format == "date-time" {
+			t = "time.Time"
+		}
+	case "boolean":
+		t = "bool"
+	case "integer":
+		t = "int"
+		if s.Format == "int64" {
+			t = "int64"
+		}
+	case "number":
+		t = "float64"
+	case "array":
+		return "[]" + g.goType(s.Items)
+	case "object":
+		if s.Properties != nil {
+			return g.structType(s)
+		}
+		return "map[string]" + g.goType(s.AdditionalProperties)
+	default:
+		return "interface{}"
+	}
+	if s.Nullable {
+		return "*" + t
+	}
+	return t
+}
+
+func (g *generator) structType(s *schema) string {
+	required := map[string]bool{}
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, name := range sortedKeys(s.Properties) {
+		p := s.Properties[name]
+		field := p.GoName
+		if field == "" {
+			field = goName(name)
+		}
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", field, g.goType(p), tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+var pathParamRegexp = regexp.MustCompile(`\{([A-Za-z_]+)\}`)
+
+// method generates the method of an operation.
+func (g *generator) method(path, method string, op *operation) error {
+	if op.OperationID == "" {
+		return fmt.Errorf("%s %s has no operationId", method, path)
+	}
+	name := goName(op.OperationID)
+
+	args := []string{"ctx context.Context"}
+	var pathArgs []string
+	for _, m := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+		arg := argName(m[1])
+		args = append(args, arg+" string")
+		pathArgs = append(pathArgs, "url.PathEscape("+arg+")")
+	}
+	query := "nil"
+	for _, p := range op.Parameters {
+		if p.In == "query" {
+			args = append(args, "query url.Values")
+			query = "query"
+			break
+		}
+	}
+	body := "nil"
+	if op.RequestBody != nil {
+		args = append(args, "body "+g.goType(op.RequestBody.Content["application/json"].Schema))
+		body = "body"
+	}
+	pathExpr := fmt.Sprintf("%q", path)
+	if len(pathArgs) > 0 {
+		pathExpr = fmt.Sprintf("fmt.Sprintf(%q, %s)", pathParamRegexp.ReplaceAllString(path, "%s"), strings.Join(pathArgs, ", "))
+	}
+
+	var success map[string]mediaType
+	for _, code := range sortedKeys(op.Responses) {
+		if strings.HasPrefix(code, "2") {
+			success = op.Responses[code].Content
+		}
+	}
+
+	if op.Summary != "" {
+		g.printf("// %s: %s.\n", name, op.Summary)
+	}
+	jsonType, isJSON := success["application/json"]
+	switch {
+	case len(success) > 0 && !isJSON:
+		g.printf("func (c *Client) %s(%s) (io.ReadCloser, error) {\n", name, strings.Join(args, ", "))
+		g.printf("return c.stream(ctx, %q, %s, %s)\n}\n\n", method, pathExpr, query)
+	case isJSON:
+		t := g.goType(jsonType.Schema)
+		if jsonType.Schema.Ref != "" {
+			g.printf("func (c *Client) %s(%s) (*%s, error) {\n", name, strings.Join(args, ", "), t)
+			g.printf("out := new(%s)\n", t)
+			g.printf("if err := c.do(ctx, %q, %s, %s, %s, out); err != nil {\nreturn nil, err\n}\n", method, pathExpr, query, body)
+			g.printf("return out, nil\n}\n\n")
+		} else {
+			g.printf("func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), t)
+			g.printf("var out %s\n", t)
+			g.printf("err := c.do(ctx, %q, %s, %s, %s, &out)\n", method, pathExpr, query, body)
+			g.printf("return out, err\n}\n\n")
+		}
+	default:
+		g.printf("func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+		g.printf("return c.do(ctx, %q, %s, %s, %s, nil)\n}\n\n", method, pathExpr, query, body)
+	}
+	return nil
+}
+
+// goName returns the exported Go name of a JSON name or operation ID.
+func goName(s string) string {
+	parts := strings.Split(s, "_")
+	for i, p := range parts {
+		parts[i] = initialism(strings.ToUpper(p[:1]) + p[1:])
+	}
+	return strings.Join(parts, "")
+}
+
+// argName returns the Go argument name of a path parameter, e.g. issueID for
+// issue_id.
+func argName(s string) string {
+	name := goName(s)
+	for i, r := range name {
+		if r < 'A' || r > 'Z' {
+			if i > 1 {
+				i--
+			}
+			return strings.ToLower(name[:i]) + name[i:]
+		}
+	}
+	return strings.ToLower(name)
+}
+
+func initialism(s string) string {
+	switch s {
+	case "Id":
+		return "ID"
+	case "Url":
+		return "URL"
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,600 @@
+// Code generated by gen from openapi.json. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+type AuditEntry struct {
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	Status    int       `json:"status"`
+	Submitter string    `json:"submitter,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+}
+
+type BulkPayload struct {
+	Action  string   `json:"action"`
+	Handler string   `json:"handler"`
+	IDs     []string `json:"ids"`
+}
+
+type BulkResponse struct {
+	Failed  int          `json:"failed"`
+	Results []BulkResult `json:"results"`
+}
+
+type BulkResult struct {
+	Error  string `json:"error,omitempty"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type CommentSubmission struct {
+	Comment string `json:"comment"`
+}
+
+type CredentialStatus struct {
+	Encrypted   bool       `json:"encrypted,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	FineGrained bool       `json:"fineGrained,omitempty"`
+	Login       string     `json:"login,omitempty"`
+	Scopes      []string   `json:"scopes,omitempty"`
+	Secret      string     `json:"secret"`
+}
+
+type DiffChange struct {
+	Content       string `json:"content"`
+	IsDelete      bool   `json:"isDelete,omitempty"`
+	IsInsert      bool   `json:"isInsert,omitempty"`
+	IsNormal      bool   `json:"isNormal,omitempty"`
+	LineNumber    int64  `json:"lineNumber,omitempty"`
+	NewLineNumber int64  `json:"newLineNumber,omitempty"`
+	OldLineNumber int64  `json:"oldLineNumber,omitempty"`
+	Type          string `json:"type"`
+}
+
+type DiffFile struct {
+	Additions   int64      `json:"additions"`
+	Deletions   int64      `json:"deletions"`
+	Hunks       []DiffHunk `json:"hunks"`
+	IsBinary    bool       `json:"isBinary,omitempty"`
+	Language    string     `json:"language,omitempty"`
+	NewPath     string     `json:"newPath"`
+	NewRevision string     `json:"newRevision"`
+	OldPath     string     `json:"oldPath"`
+	OldRevision string     `json:"oldRevision"`
+	Type        string     `json:"type"`
+}
+
+type DiffHunk struct {
+	Changes  []DiffChange `json:"changes"`
+	Content  string       `json:"content"`
+	NewLines int64        `json:"newLines"`
+	NewStart int64        `json:"newStart"`
+	OldLines int64        `json:"oldLines"`
+	OldStart int64        `json:"oldStart"`
+	Section  string       `json:"section,omitempty"`
+}
+
+type DraftPayload struct {
+	Draft string `json:"draft"`
+}
+
+type DraftVersion struct {
+	Draft        string    `json:"draft"`
+	ID           string    `json:"id"`
+	RestoredFrom string    `json:"restoredFrom,omitempty"`
+	Source       string    `json:"source"`
+	Time         time.Time `json:"time"`
+	User         string    `json:"user,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type GeminiCredentialPayload struct {
+	APIKey string `json:"apiKey"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type GitHubAppCredentialPayload struct {
+	AppID          int64  `json:"appID"`
+	InstallationID int64  `json:"installationID"`
+	PrivateKey     string `json:"privateKey"`
+	Secret         string `json:"secret,omitempty"`
+}
+
+type GitHubCredentialPayload struct {
+	PAT    string `json:"pat"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type IdeResponse struct {
+	URL string `json:"url"`
+}
+
+type Issue struct {
+	BranchURL       string           `json:"branchURL,omitempty"`
+	Comment         string           `json:"comment,omitempty"`
+	Draft           string           `json:"draft,omitempty"`
+	Heartbeat       string           `json:"heartbeat,omitempty"`
+	HTMLURL         string           `json:"htmlURL,omitempty"`
+	ID              string           `json:"id"`
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"`
+	Phase           string           `json:"phase,omitempty"`
+	PullRequestURL  string           `json:"pullRequestURL,omitempty"`
+	PushBranch      bool             `json:"pushBranch"`
+	Sandbox         string           `json:"sandbox,omitempty"`
+	SandboxReplica  string           `json:"sandboxReplica,omitempty"`
+	Title           string           `json:"title"`
+}
+
+type IssueHandler struct {
+	MaxActiveSandboxes int64  `json:"maxActiveSandboxes"`
+	Name               string `json:"name"`
+	PushBranch         bool   `json:"pushBranch"`
+}
+
+type IssueHandlerPayload struct {
+	DevcontainerConfigRef string     `json:"devcontainerConfigRef"`
+	Issues                []int64    `json:"issues"`
+	Labels                []string   `json:"labels"`
+	LLM                   LLMPayload `json:"llm"`
+	MaxActiveSandboxes    int64      `json:"maxActiveSandboxes"`
+	Name                  string     `json:"name"`
+	PushEnabled           bool       `json:"pushEnabled"`
+}
+
+type LLMPayload struct {
+	APIKeySecretRef   string                    `json:"apiKeySecretRef"`
+	ConfigdirRef      string                    `json:"configdirRef"`
+	Prompt            string                    `json:"prompt"`
+	PromptTemplateRef *PromptTemplateRefPayload `json:"promptTemplateRef,omitempty"`
+	Provider          string                    `json:"provider"`
+}
+
+type PR struct {
+	DiffURL            string           `json:"diffURL,omitempty"`
+	Draft              string           `json:"draft,omitempty"`
+	HTMLURL            string           `json:"htmlURL,omitempty"`
+	ID                 string           `json:"id"`
+	PendingApproval    *PendingApproval `json:"pendingApproval,omitempty"`
+	RequestedReviewers []string         `json:"requestedReviewers,omitempty"`
+	Review             string           `json:"review,omitempty"`
+	Sandbox            string           `json:"sandbox,omitempty"`
+	SandboxReplica     string           `json:"sandboxReplica,omitempty"`
+	Title              string           `json:"title"`
+}
+
+type PendingApproval struct {
+	Content     string `json:"content"`
+	SubmittedAt string `json:"submittedAt,omitempty"`
+	SubmittedBy string `json:"submittedBy,omitempty"`
+}
+
+type PromptAssignment struct {
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Version int64  `json:"version"`
+}
+
+type PromptTemplate struct {
+	Kind          string          `json:"kind"`
+	LatestVersion int64           `json:"latestVersion"`
+	Name          string          `json:"name"`
+	Prompt        string          `json:"prompt"`
+	UsedBy        []string        `json:"usedBy"`
+	Versions      []PromptVersion `json:"versions,omitempty"`
+}
+
+type PromptTemplateCreation struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+}
+
+type PromptTemplateRefPayload struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version,omitempty"`
+}
+
+type PromptVersion struct {
+	Author  string `json:"author,omitempty"`
+	Prompt  string `json:"prompt"`
+	Version int64  `json:"version"`
+}
+
+type PromptVersionCreation struct {
+	Prompt string `json:"prompt"`
+}
+
+type Repo struct {
+	IssueHandlers []IssueHandler `json:"issueHandlers,omitempty"`
+	Name          string         `json:"name"`
+	Namespace     string         `json:"namespace"`
+	Review        *ReviewConfig  `json:"review,omitempty"`
+	Role          string         `json:"role"`
+	URL           string         `json:"url"`
+}
+
+type RepoWatchPayload struct {
+	IssueHandlers       []IssueHandlerPayload `json:"issueHandlers"`
+	Name                string                `json:"name"`
+	Namespace           string                `json:"namespace"`
+	PollIntervalSeconds int64                 `json:"pollIntervalSeconds"`
+	RepoURL             string                `json:"repoURL"`
+	Review              *ReviewPayload        `json:"review,omitempty"`
+}
+
+type RerunPayload struct {
+	Prompt string `json:"prompt"`
+}
+
+type ReviewConfig struct {
+	MaxActiveSandboxes int64 `json:"maxActiveSandboxes"`
+}
+
+type ReviewPayload struct {
+	DevcontainerConfigRef string     `json:"devcontainerConfigRef"`
+	LLM                   LLMPayload `json:"llm"`
+	MaxActiveSandboxes    int64      `json:"maxActiveSandboxes"`
+	PullRequests          []int64    `json:"pullRequests"`
+}
+
+type ReviewSubmission struct {
+	Review string `json:"review"`
+}
+
+// GetAudit: List the audit log.
+func (c *Client) GetAudit(ctx context.Context, query url.Values) ([]AuditEntry, error) {
+	var out []AuditEntry
+	err := c.do(ctx, "GET", "/audit", query, nil, &out)
+	return out, err
+}
+
+// OauthCallback: Complete the GitHub login.
+func (c *Client) OauthCallback(ctx context.Context, query url.Values) error {
+	return c.do(ctx, "GET", "/auth/callback", query, nil, nil)
+}
+
+// Login: Log in with GitHub.
+func (c *Client) Login(ctx context.Context) error {
+	return c.do(ctx, "GET", "/auth/login", nil, nil, nil)
+}
+
+// Logout: Log out.
+func (c *Client) Logout(ctx context.Context) error {
+	return c.do(ctx, "POST", "/auth/logout", nil, nil, nil)
+}
+
+// CurrentUser: Get the logged in user.
+func (c *Client) CurrentUser(ctx context.Context) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do(ctx, "GET", "/auth/user", nil, nil, &out)
+	return out, err
+}
+
+// ExportFeedback: Export the human feedback as JSON lines.
+func (c *Client) ExportFeedback(ctx context.Context, query url.Values) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", "/feedback/export", query)
+}
+
+// GetOpenAPISpec: Get the OpenAPI spec of the API.
+func (c *Client) GetOpenAPISpec(ctx context.Context) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do(ctx, "GET", "/openapi.json", nil, nil, &out)
+	return out, err
+}
+
+// ListPromptTemplates: List the prompt templates of a namespace.
+func (c *Client) ListPromptTemplates(ctx context.Context, namespace string) ([]PromptTemplate, error) {
+	var out []PromptTemplate
+	err := c.do(ctx, "GET", fmt.Sprintf("/prompts/%s", url.PathEscape(namespace)), nil, nil, &out)
+	return out, err
+}
+
+// CreatePromptTemplate: Create a prompt template.
+func (c *Client) CreatePromptTemplate(ctx context.Context, namespace string, body PromptTemplateCreation) (*PromptTemplate, error) {
+	out := new(PromptTemplate)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/prompts/%s", url.PathEscape(namespace)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeletePromptTemplate: Delete a prompt template that no RepoWatch uses.
+func (c *Client) DeletePromptTemplate(ctx context.Context, namespace string, template string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/prompts/%s/%s", url.PathEscape(namespace), url.PathEscape(template)), nil, nil, nil)
+}
+
+// GetPromptTemplate: Get a prompt template and its versions.
+func (c *Client) GetPromptTemplate(ctx context.Context, namespace string, template string) (*PromptTemplate, error) {
+	out := new(PromptTemplate)
+	if err := c.do(ctx, "GET", fmt.Sprintf("/prompts/%s/%s", url.PathEscape(namespace), url.PathEscape(template)), nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddPromptVersion: Save a new version of a prompt template.
+func (c *Client) AddPromptVersion(ctx context.Context, namespace string, template string, body PromptVersionCreation) (*PromptTemplate, error) {
+	out := new(PromptTemplate)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/prompts/%s/%s/versions", url.PathEscape(namespace), url.PathEscape(template)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Proxy: Fetch a GitHub URL.
+func (c *Client) Proxy(ctx context.Context, query url.Values) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", "/proxy", query)
+}
+
+// GetIssueDraftHistory: List the saved drafts of an issue.
+func (c *Client) GetIssueDraftHistory(ctx context.Context, namespace string, repo string, handler string, issueID string) ([]DraftVersion, error) {
+	var out []DraftVersion
+	err := c.do(ctx, "GET", fmt.Sprintf("/repo/%s/%s/handlers/%s/issues/%s/drafts", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(handler), url.PathEscape(issueID)), nil, nil, &out)
+	return out, err
+}
+
+// RestoreIssueDraft: Restore a saved draft of an issue.
+func (c *Client) RestoreIssueDraft(ctx context.Context, namespace string, repo string, handler string, issueID string, version string) (*DraftPayload, error) {
+	out := new(DraftPayload)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/handlers/%s/issues/%s/drafts/%s/restore", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(handler), url.PathEscape(issueID), url.PathEscape(version)), nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetIssueLogs: Stream the logs of the sandbox of an issue.
+func (c *Client) GetIssueLogs(ctx context.Context, namespace string, repo string, handler string, issueID string, query url.Values) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", fmt.Sprintf("/repo/%s/%s/handlers/%s/issues/%s/logs", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(handler), url.PathEscape(issueID)), query)
+}
+
+// BulkIssues: Scale down, delete or submit issues at once.
+func (c *Client) BulkIssues(ctx context.Context, namespace string, repo string, body BulkPayload) (*BulkResponse, error) {
+	out := new(BulkResponse)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/issues/bulk", url.PathEscape(namespace), url.PathEscape(repo)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetIssues: List the issues of an issue handler.
+func (c *Client) GetIssues(ctx context.Context, namespace string, repo string, handler string) ([]Issue, error) {
+	var out []Issue
+	err := c.do(ctx, "GET", fmt.Sprintf("/repo/%s/%s/issues/%s", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(handler)), nil, nil, &out)
+	return out, err
+}
+
+// DeleteIssue: Scale down the sandbox of an issue and discard its draft.
+func (c *Client) DeleteIssue(ctx context.Context, namespace string, repo string, issueID string, handler string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/repo/%s/%s/issues/%s/handler/%s", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(issueID), url.PathEscape(handler)), nil, nil, nil)
+}
+
+// ApproveIssueComment: Approve and post the pending comment of an issue.
+func (c *Client) ApproveIssueComment(ctx context.Context, namespace string, repo string, issueID string, handler string) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/issues/%s/handler/%s/approve", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(issueID), url.PathEscape(handler)), nil, nil, nil)
+}
+
+// SaveIssueDraft: Save the draft comment of an issue.
+func (c *Client) SaveIssueDraft(ctx context.Context, namespace string, repo string, issueID string, handler string, body DraftPayload) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/issues/%s/handler/%s/draft", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(issueID), url.PathEscape(handler)), nil, body, nil)
+}
+
+// RejectIssueComment: Reject the pending comment of an issue.
+func (c *Client) RejectIssueComment(ctx context.Context, namespace string, repo string, issueID string, handler string) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/issues/%s/handler/%s/reject", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(issueID), url.PathEscape(handler)), nil, nil, nil)
+}
+
+// RerunIssue: Run the agent of an issue again.
+func (c *Client) RerunIssue(ctx context.Context, namespace string, repo string, issueID string, handler string, body RerunPayload) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/issues/%s/handler/%s/rerun", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(issueID), url.PathEscape(handler)), nil, body, nil)
+}
+
+// SubmitIssueComment: Post a comment on an issue, or request its approval.
+func (c *Client) SubmitIssueComment(ctx context.Context, namespace string, repo string, issueID string, handler string, body CommentSubmission) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/issues/%s/handler/%s/submitcomment", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(issueID), url.PathEscape(handler)), nil, body, nil)
+}
+
+// GetPRs: List the PRs under review.
+func (c *Client) GetPRs(ctx context.Context, namespace string, repo string) ([]PR, error) {
+	var out []PR
+	err := c.do(ctx, "GET", fmt.Sprintf("/repo/%s/%s/prs", url.PathEscape(namespace), url.PathEscape(repo)), nil, nil, &out)
+	return out, err
+}
+
+// BulkPRs: Scale down, delete or submit PRs at once.
+func (c *Client) BulkPRs(ctx context.Context, namespace string, repo string, body BulkPayload) (*BulkResponse, error) {
+	out := new(BulkResponse)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/bulk", url.PathEscape(namespace), url.PathEscape(repo)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeletePR: Scale down the sandbox of a PR and discard its draft.
+func (c *Client) DeletePR(ctx context.Context, namespace string, repo string, id string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/repo/%s/%s/prs/%s", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, nil, nil)
+}
+
+// ApproveReview: Approve and post the pending review of a PR.
+func (c *Client) ApproveReview(ctx context.Context, namespace string, repo string, id string) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/%s/approve", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, nil, nil)
+}
+
+// GetPRDiff: Get the diff of a PR.
+func (c *Client) GetPRDiff(ctx context.Context, namespace string, repo string, id string) ([]DiffFile, error) {
+	var out []DiffFile
+	err := c.do(ctx, "GET", fmt.Sprintf("/repo/%s/%s/prs/%s/diff", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, nil, &out)
+	return out, err
+}
+
+// SaveDraft: Save the draft review of a PR.
+func (c *Client) SaveDraft(ctx context.Context, namespace string, repo string, id string, body DraftPayload) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/%s/draft", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, body, nil)
+}
+
+// GetPRDraftHistory: List the saved drafts of a PR.
+func (c *Client) GetPRDraftHistory(ctx context.Context, namespace string, repo string, id string) ([]DraftVersion, error) {
+	var out []DraftVersion
+	err := c.do(ctx, "GET", fmt.Sprintf("/repo/%s/%s/prs/%s/drafts", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, nil, &out)
+	return out, err
+}
+
+// RestorePRDraft: Restore a saved draft of a PR.
+func (c *Client) RestorePRDraft(ctx context.Context, namespace string, repo string, id string, version string) (*DraftPayload, error) {
+	out := new(DraftPayload)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/%s/drafts/%s/restore", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id), url.PathEscape(version)), nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetPRLogs: Stream the logs of the sandbox of a PR.
+func (c *Client) GetPRLogs(ctx context.Context, namespace string, repo string, id string, query url.Values) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", fmt.Sprintf("/repo/%s/%s/prs/%s/logs", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), query)
+}
+
+// RejectReview: Reject the pending review of a PR.
+func (c *Client) RejectReview(ctx context.Context, namespace string, repo string, id string) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/%s/reject", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, nil, nil)
+}
+
+// RerunPR: Run the agent of a PR again.
+func (c *Client) RerunPR(ctx context.Context, namespace string, repo string, id string, body RerunPayload) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/%s/rerun", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, body, nil)
+}
+
+// SubmitReview: Post a review on a PR, or request its approval.
+func (c *Client) SubmitReview(ctx context.Context, namespace string, repo string, id string, body ReviewSubmission) error {
+	return c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/prs/%s/submitreview", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(id)), nil, body, nil)
+}
+
+// OpenSandboxIDE: Get a link to the IDE of a sandbox, served from its own host.
+func (c *Client) OpenSandboxIDE(ctx context.Context, namespace string, repo string, name string) (*IdeResponse, error) {
+	out := new(IdeResponse)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/repo/%s/%s/sandboxes/%s/ide", url.PathEscape(namespace), url.PathEscape(repo), url.PathEscape(name)), nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetRepos: List the RepoWatches.
+func (c *Client) GetRepos(ctx context.Context) ([]Repo, error) {
+	var out []Repo
+	err := c.do(ctx, "GET", "/repos", nil, nil, &out)
+	return out, err
+}
+
+// CreateRepoWatch: Create a RepoWatch.
+func (c *Client) CreateRepoWatch(ctx context.Context, body RepoWatchPayload) error {
+	return c.do(ctx, "POST", "/repowatch", nil, body, nil)
+}
+
+// DeleteRepoWatch: Delete a RepoWatch.
+func (c *Client) DeleteRepoWatch(ctx context.Context, namespace string, name string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/repowatch/%s/%s", url.PathEscape(namespace), url.PathEscape(name)), nil, nil, nil)
+}
+
+// GetRepoWatchSpec: Get the configuration of a RepoWatch.
+func (c *Client) GetRepoWatchSpec(ctx context.Context, namespace string, name string) (*RepoWatchPayload, error) {
+	out := new(RepoWatchPayload)
+	if err := c.do(ctx, "GET", fmt.Sprintf("/repowatch/%s/%s", url.PathEscape(namespace), url.PathEscape(name)), nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateRepoWatch: Update the configuration of a RepoWatch.
+func (c *Client) UpdateRepoWatch(ctx context.Context, namespace string, name string, body RepoWatchPayload) error {
+	return c.do(ctx, "PUT", fmt.Sprintf("/repowatch/%s/%s", url.PathEscape(namespace), url.PathEscape(name)), nil, body, nil)
+}
+
+// CreateIssueHandler: Add an issue handler to a RepoWatch.
+func (c *Client) CreateIssueHandler(ctx context.Context, namespace string, name string, body IssueHandlerPayload) (*IssueHandlerPayload, error) {
+	out := new(IssueHandlerPayload)
+	if err := c.do(ctx, "POST", fmt.Sprintf("/repowatch/%s/%s/handlers", url.PathEscape(namespace), url.PathEscape(name)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateIssueHandlers: Replace the issue handlers of a RepoWatch.
+func (c *Client) UpdateIssueHandlers(ctx context.Context, namespace string, name string, body []IssueHandlerPayload) error {
+	return c.do(ctx, "PUT", fmt.Sprintf("/repowatch/%s/%s/handlers", url.PathEscape(namespace), url.PathEscape(name)), nil, body, nil)
+}
+
+// DeleteIssueHandler: Remove an issue handler from a RepoWatch.
+func (c *Client) DeleteIssueHandler(ctx context.Context, namespace string, name string, handler string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/repowatch/%s/%s/handlers/%s", url.PathEscape(namespace), url.PathEscape(name), url.PathEscape(handler)), nil, nil, nil)
+}
+
+// UpdateIssueHandler: Create or update an issue handler of a RepoWatch.
+func (c *Client) UpdateIssueHandler(ctx context.Context, namespace string, name string, handler string, body IssueHandlerPayload) error {
+	return c.do(ctx, "PUT", fmt.Sprintf("/repowatch/%s/%s/handlers/%s", url.PathEscape(namespace), url.PathEscape(name), url.PathEscape(handler)), nil, body, nil)
+}
+
+// AssignPromptTemplate: Assign a prompt template to the review or an issue handler of a RepoWatch.
+func (c *Client) AssignPromptTemplate(ctx context.Context, namespace string, name string, body PromptAssignment) error {
+	return c.do(ctx, "PUT", fmt.Sprintf("/repowatch/%s/%s/prompt", url.PathEscape(namespace), url.PathEscape(name)), nil, body, nil)
+}
+
+// DeleteReviewConfig: Stop reviewing the PRs of a RepoWatch.
+func (c *Client) DeleteReviewConfig(ctx context.Context, namespace string, name string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/repowatch/%s/%s/review", url.PathEscape(namespace), url.PathEscape(name)), nil, nil, nil)
+}
+
+// UpdateReviewConfig: Configure the PR reviews of a RepoWatch.
+func (c *Client) UpdateReviewConfig(ctx context.Context, namespace string, name string, body ReviewPayload) error {
+	return c.do(ctx, "PUT", fmt.Sprintf("/repowatch/%s/%s/review", url.PathEscape(namespace), url.PathEscape(name)), nil, body, nil)
+}
+
+// GetSettings: Get the features enabled and the quota of a namespace.
+func (c *Client) GetSettings(ctx context.Context, query url.Values) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do(ctx, "GET", "/settings", query, nil, &out)
+	return out, err
+}
+
+// SaveGeminiCredential: Validate a Gemini API key and store it in the secret of a namespace.
+func (c *Client) SaveGeminiCredential(ctx context.Context, namespace string, body GeminiCredentialPayload) (*CredentialStatus, error) {
+	out := new(CredentialStatus)
+	if err := c.do(ctx, "PUT", fmt.Sprintf("/settings/%s/gemini", url.PathEscape(namespace)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveGitHubCredential: Validate a GitHub PAT and store it in the secret of a namespace.
+func (c *Client) SaveGitHubCredential(ctx context.Context, namespace string, body GitHubCredentialPayload) (*CredentialStatus, error) {
+	out := new(CredentialStatus)
+	if err := c.do(ctx, "PUT", fmt.Sprintf("/settings/%s/github", url.PathEscape(namespace)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveGitHubAppCredential: Validate a GitHub App installation and store it in the secret of a namespace, its tokens are rotated.
+func (c *Client) SaveGitHubAppCredential(ctx context.Context, namespace string, body GitHubAppCredentialPayload) (*CredentialStatus, error) {
+	out := new(CredentialStatus)
+	if err := c.do(ctx, "PUT", fmt.Sprintf("/settings/%s/github-app", url.PathEscape(namespace)), nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Stream: Stream the changes of PRs and issues as server-sent events.
+func (c *Client) Stream(ctx context.Context, query url.Values) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", "/stream", query)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/secretmanager"
+	"github.com/google/go-github/v39/github"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// githubRepoScope is the scope classic PATs need to clone, comment on
+	// and push to private and public repos.
+	githubRepoScope = "repo"
+	// geminiSecretKey is the key of the Gemini API key in its secret, mounted
+	// at /tokens/gemini in the sandboxes.
+	geminiSecretKey = "gemini"
+	// managedByLabel marks the secrets the settings may write. Those created
+	// by the settings have it, existing ones must be labeled to be replaced.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "repo-agent"
+)
+
+// secretKMS encrypts the credentials stored by the settings endpoints when
+// KMS_KEY_NAME is set, see pkg/envelope. The controller and the API decrypt
+// them when they need them.
+var secretKMS envelope.KMS
+
+// secretManager reads the GitHub PATs of the RepoWatches that keep them in
+// Secret Manager, spec.secretManager.githubPAT, with the workload identity of
+// the API.
+var secretManager secretmanager.Accessor
+
+// geminiAPIURL is the Gemini API keys are validated against, e.g. a proxy.
+// https://generativelanguage.googleapis.com is used when unset.
+var geminiAPIURL = os.Getenv("GEMINI_API_URL")
+
+// GitHubCredentialPayload is a GitHub PAT to store in the secret of a
+// namespace.
+type GitHubCredentialPayload struct {
+	PAT string `json:"pat" binding:"required"`
+	// Secret is the name of the secret, github-pat if empty
+	Secret string `json:"secret,omitempty"`
+}
+
+// GeminiCredentialPayload is a Gemini API key to store in the secret of a
+// namespace.
+type GeminiCredentialPayload struct {
+	APIKey string `json:"apiKey" binding:"required"`
+	// Secret is the name of the secret, gemini-vscode-tokens if empty
+	Secret string `json:"secret,omitempty"`
+}
+
+// CredentialStatus is what was learnt validating a credential.
+type CredentialStatus struct {
+	Secret string `json:"secret"`
+	// Login is the GitHub user of a PAT
+	Login string `json:"login,omitempty"`
+	// Scopes granted to a classic PAT
+	Scopes []string `json:"scopes,omitempty"`
+	// FineGrained is set for fine-grained PATs, whose permissions GitHub
+	// doesn't report
+	FineGrained bool `json:"fineGrained,omitempty"`
+	// Encrypted is set when the credential is stored encrypted with the KMS
+	Encrypted bool `json:"encrypted,omitempty"`
+	// ExpiresAt is when the token expires, if it does
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// githubIdentity is the user a PAT belongs to, stored next to it for the
+// commits of the sandboxes.
+type githubIdentity struct {
+	login, name, email string
+	scopes             []string
+	fineGrained        bool
+	// expiresAt is when the PAT expires, nil if it doesn't
+	expiresAt *time.Time
+}
+
+// validateGitHubPAT checks that a PAT is valid and, for classic PATs, that it
+// has the repo scope.
+func validateGitHubPAT(ctx context.Context, pat string) (*githubIdentity, error) {
+	client, err := newGitHubClient(ctx, nil, pat)
+	if err != nil {
+		return nil, err
+	}
+	user, resp, err := client.Users.Get(ctx, "")
+	if err != nil {
+		var ghErr *github.ErrorResponse
+		if errors.As(err, &ghErr) && ghErr.Response.StatusCode == http.StatusUnauthorized {
+			return nil, &apiError{status: http.StatusBadRequest, message: "GitHub rejected the token, it is invalid, expired or revoked"}
+		}
+		return nil, &apiError{status: http.StatusBadGateway, message: "Failed to validate the token with GitHub", err: err}
+	}
+	id := &githubIdentity{login: user.GetLogin(), name: user.GetName(), email: user.GetEmail()}
+	if id.name == "" {
+		id.name = id.login
+	}
+	id.expiresAt = parseTokenExpiration(resp.Header.Get(githubTokenExpirationHeader))
+	// Only classic PATs report their scopes
+	header, classic := resp.Header["X-Oauth-Scopes"]
+	if !classic {
+		id.fineGrained = true
+		return id, nil
+	}
+	for _, scope := range strings.Split(strings.Join(header, ","), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			id.scopes = append(id.scopes, scope)
+		}
+	}
+	for _, scope := range id.scopes {
+		if scope == githubRepoScope {
+			return id, nil
+		}
+	}
+	return nil, &apiError{status: http.StatusBadRequest, message: fmt.Sprintf("the token is missing the %s scope, it has: %s", githubRepoScope, strings.Join(id.scopes, ", "))}
+}
+
+// validateGeminiKey checks that a Gemini API key is valid by listing a model,
+// which is free.
+func validateGeminiKey(ctx context.Context, key string) error {
+	baseURL := geminiAPIURL
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/v1beta/models?pageSize=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-goog-api-key", key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &apiError{status: http.StatusBadGateway, message: "Failed to validate the key with Gemini", err: err}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &apiError{status: http.StatusBadRequest, message: "Gemini rejected the API key"}
+	default:
+		return &apiError{status: http.StatusBadGateway, message: fmt.Sprintf("Failed to validate the key with Gemini: %s", resp.Status)}
+	}
+}
+
+// sealCredential encrypts a credential with secretKMS, if configured.
+func sealCredential(ctx context.Context, value string) ([]byte, error) {
+	if secretKMS == nil {
+		return []byte(value), nil
+	}
+	sealed, err := envelope.Seal(ctx, secretKMS, []byte(value))
+	if err != nil {
+		return nil, &apiError{status: http.StatusInternalServerError, message: "Failed to encrypt the credential", err: err}
+	}
+	return sealed, nil
+}
+
+// saveCredentialSecret creates a secret or updates it with update, which
+// sets the keys and annotations of a credential, keeping the others. Only the
+// secrets of the kind of credential of the namespace, see credentialSecrets,
+// are written, and existing ones only if they are managed by repo-agent.
+func saveCredentialSecret(ctx context.Context, namespace, name, kind string, update func(*corev1.Secret)) error {
+	githubSecrets, geminiSecrets := credentialSecrets(namespace)
+	allowed := githubSecrets
+	if kind == "gemini" {
+		allowed = geminiSecrets
+	}
+	if !slices.Contains(allowed, name) {
+		return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf("secret %s is not a %s credential of namespace %s, use %s or reference it from a RepoWatch first", name, kind, namespace, strings.Join(allowed, ", "))}
+	}
+	secrets := kubeClient.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(ctx, name, v1.GetOptions{})
+	exists := !k8serrors.IsNotFound(err)
+	if !exists {
+		secret = &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: namespace},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	} else if err != nil {
+		return &apiError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to save secret %s", name), err: err}
+	} else if secret.Labels[managedByLabel] != managedBy {
+		return &apiError{status: http.StatusConflict, message: fmt.Sprintf("secret %s is not managed by %s, label it %s=%s to replace it from the settings", name, managedBy, managedByLabel, managedBy)}
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[managedByLabel] = managedBy
+	update(secret)
+	if !exists {
+		_, err = secrets.Create(ctx, secret, v1.CreateOptions{})
+	} else {
+		_, err = secrets.Update(ctx, secret, v1.UpdateOptions{})
+	}
+	if err != nil {
+		return &apiError{status: http.StatusInternalServerError, message: fmt.Sprintf("Failed to save secret %s", name), err: err}
+	}
+	return nil
+}
+
+// saveGitHubCredential validates a PAT and stores it with the name and email
+// of its user in the secret RepoWatches reference in githubSecretName.
+func saveGitHubCredential(c *gin.Context) {
+	namespace := c.Param("namespace")
+	var payload GitHubCredentialPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Secret == "" {
+		payload.Secret = defaultGithubSecretName
+	}
+	pat := strings.TrimSpace(payload.PAT)
+	id, err := validateGitHubPAT(c.Request.Context(), pat)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	sealed, err := sealCredential(c.Request.Context(), pat)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	err = saveCredentialSecret(c.Request.Context(), namespace, payload.Secret, "github", func(secret *corev1.Secret) {
+		secret.Data["pat"] = sealed
+		secret.Data["name"] = []byte(id.name)
+		secret.Data["email"] = []byte(id.email)
+		// The PAT replaces the GitHub App the secret may have been rotated for
+		for _, key := range githubAppSecretKeys {
+			delete(secret.Data, key)
+		}
+		delete(secret.Labels, githubAppLabel)
+		delete(secret.Annotations, rotationErrorAnnotation)
+		setCredentialDates(secret, "github", time.Now(), id.expiresAt)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, CredentialStatus{Secret: payload.Secret, Login: id.login, Scopes: id.scopes, FineGrained: id.fineGrained, Encrypted: secretKMS != nil, ExpiresAt: id.expiresAt})
+}
+
+// saveGeminiCredential validates a Gemini API key and stores it in the secret
+// LLM configs reference in apiKeySecretRef.
+func saveGeminiCredential(c *gin.Context) {
+	namespace := c.Param("namespace")
+	var payload GeminiCredentialPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Secret == "" {
+		payload.Secret = defaultAPIKeySecretRef
+	}
+	key := strings.TrimSpace(payload.APIKey)
+	if err := validateGeminiKey(c.Request.Context(), key); err != nil {
+		respondError(c, err)
+		return
+	}
+	sealed, err := sealCredential(c.Request.Context(), key)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	err = saveCredentialSecret(c.Request.Context(), namespace, payload.Secret, "gemini", func(secret *corev1.Secret) {
+		secret.Data[geminiSecretKey] = sealed
+		// Gemini API keys don't expire
+		setCredentialDates(secret, "gemini", time.Now(), nil)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, CredentialStatus{Secret: payload.Secret, Encrypted: secretKMS != nil})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSaveGitHubCredential(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer classic":
+			w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+			w.Header().Set(githubTokenExpirationHeader, "2030-01-02 03:04:05 UTC")
+		case "Bearer no-repo":
+			w.Header().Set("X-OAuth-Scopes", "read:org")
+		case "Bearer fine-grained":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Bad credentials"}`))
+			return
+		}
+		w.Write([]byte(`{"login": "octocat", "email": "octocat@example.com"}`))
+	}))
+	defer gh.Close()
+
+	oldKube, oldURL, oldCache := kubeClient, githubAPIURL, k8sCache
+	defer func() { kubeClient, githubAPIURL, k8sCache = oldKube, oldURL, oldCache }()
+	githubAPIURL = gh.URL + "/"
+	k8sCache = newTestCache(t, nil)
+	kubeClient = fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "github-pat", Namespace: "team-a", Labels: map[string]string{managedByLabel: managedBy}},
+		Data:       map[string][]byte{"pat": []byte("old"), "other": []byte("kept")},
+	}, &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "github-pat", Namespace: "team-b"},
+		Data:       map[string][]byte{"pat": []byte("old")},
+	})
+
+	tests := []struct {
+		namespace string
+		body      string
+		wantCode  int
+	}{
+		{"team-a", `{"pat": "invalid"}`, http.StatusBadRequest},
+		{"team-a", `{"pat": "no-repo"}`, http.StatusBadRequest},
+		{"team-a", `{"pat": "fine-grained"}`, http.StatusOK},
+		{"team-a", `{"pat": " classic\n"}`, http.StatusOK},
+		// Only the credential secrets of the namespace, managed by repo-agent
+		{"team-a", `{"pat": "classic", "secret": "other-app-config"}`, http.StatusBadRequest},
+		{"team-b", `{"pat": "classic"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		w := callPromptHandler(saveGitHubCredential, gin.Params{{Key: "namespace", Value: tt.namespace}}, tt.body)
+		if w.Code != tt.wantCode {
+			t.Errorf("save %s in %s = %d %s, want %d", tt.body, tt.namespace, w.Code, w.Body, tt.wantCode)
+		}
+	}
+	if _, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), "other-app-config", v1.GetOptions{}); err == nil {
+		t.Error("secret other-app-config was created, want it refused")
+	}
+	if secret, _ := kubeClient.CoreV1().Secrets("team-b").Get(context.Background(), "github-pat", v1.GetOptions{}); string(secret.Data["pat"]) != "old" {
+		t.Errorf("unmanaged secret pat = %q, want it kept", secret.Data["pat"])
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), "github-pat", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data["pat"]); got != "classic" {
+		t.Errorf("pat = %q, want the trimmed classic token", got)
+	}
+	if string(secret.Data["name"]) != "octocat" || string(secret.Data["email"]) != "octocat@example.com" || string(secret.Data["other"]) != "kept" {
+		t.Errorf("secret data = %q, want the identity of the token and the other keys kept", secret.Data)
+	}
+	if got := secret.Annotations["review.gemini.google.com/github-expires-at"]; got != "2030-01-02T03:04:05Z" {
+		t.Errorf("expires-at = %q, want the expiration of the token", got)
+	}
+}
+
+func TestSaveGeminiCredential(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gemini := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models" || r.Header.Get("x-goog-api-key") != "valid" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"models": []}`))
+	}))
+	defer gemini.Close()
+
+	oldKube, oldURL, oldCache := kubeClient, geminiAPIURL, k8sCache
+	defer func() { kubeClient, geminiAPIURL, k8sCache = oldKube, oldURL, oldCache }()
+	geminiAPIURL = gemini.URL
+	kubeClient = fake.NewSimpleClientset()
+	// The secret referenced by the LLM config of a RepoWatch can be saved
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{repoWatchGVR: {{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "rw", "namespace": "team-a"},
+		"spec":     map[string]interface{}{"review": map[string]interface{}{"llm": map[string]interface{}{"apiKeySecretRef": "gemini"}}},
+	}}}})
+
+	ns := gin.Params{{Key: "namespace", Value: "team-a"}}
+	if w := callPromptHandler(saveGeminiCredential, ns, `{"apiKey": "invalid"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("save invalid key = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w := callPromptHandler(saveGeminiCredential, ns, `{"apiKey": "valid", "secret": "gemini"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("save valid key = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	var status CredentialStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.Secret != "gemini" {
+		t.Errorf("status = %+v %v, want secret gemini", status, err)
+	}
+	secret, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), "gemini", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data[geminiSecretKey]); got != "valid" {
+		t.Errorf("key = %q, want valid", got)
+	}
+	if secret.Labels[managedByLabel] != managedBy {
+		t.Errorf("labels = %v, want it managed by %s", secret.Labels, managedBy)
+	}
+}
+
+// xorKMS "encrypts" data keys by flipping their bits.
+type xorKMS struct{}
+
+func (xorKMS) Encrypt(_ context.Context, b []byte) ([]byte, error) { return xor(b), nil }
+func (xorKMS) Decrypt(_ context.Context, b []byte) ([]byte, error) { return xor(b), nil }
+
+func xor(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0xff
+	}
+	return out
+}
+
+func TestSaveCredentialEncrypted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gemini := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models": []}`))
+	}))
+	defer gemini.Close()
+
+	oldKube, oldURL, oldKMS, oldCache := kubeClient, geminiAPIURL, secretKMS, k8sCache
+	defer func() { kubeClient, geminiAPIURL, secretKMS, k8sCache = oldKube, oldURL, oldKMS, oldCache }()
+	geminiAPIURL = gemini.URL
+	k8sCache = newTestCache(t, nil)
+	kubeClient = fake.NewSimpleClientset()
+	secretKMS = xorKMS{}
+
+	w := callPromptHandler(saveGeminiCredential, gin.Params{{Key: "namespace", Value: "team-a"}}, `{"apiKey": "valid"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("save key = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	var status CredentialStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.Encrypted {
+		t.Errorf("status = %+v %v, want encrypted", status, err)
+	}
+	secret, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), defaultAPIKeySecretRef, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := secret.Data[geminiSecretKey]
+	if !envelope.IsSealed(stored) {
+		t.Fatalf("key = %q, want it encrypted", stored)
+	}
+	if got, err := envelope.Open(context.Background(), secretKMS, stored); err != nil || string(got) != "valid" {
+		t.Errorf("decrypted key = %q, %v, want valid", got, err)
+	}
+}
+
+// fakeSecretManager is a Secret Manager of the secrets names.
+type fakeSecretManager map[string]string
+
+func (f fakeSecretManager) Access(_ context.Context, name string) ([]byte, error) {
+	v, ok := f[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", name)
+	}
+	return []byte(v), nil
+}
+
+func TestGetGitHubTokenSecretManager(t *testing.T) {
+	oldSecretManager := secretManager
+	defer func() { secretManager = oldSecretManager }()
+	secretManager = fakeSecretManager{"projects/p/secrets/github-pat": "ghp_sm\n"}
+
+	repoWatch := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "rw", "namespace": "team-a"},
+		"spec": map[string]interface{}{
+			"secretManager": map[string]interface{}{"githubPAT": "projects/p/secrets/github-pat"},
+		},
+	}}
+	if token, err := getGitHubToken(context.Background(), repoWatch); err != nil || token != "ghp_sm" {
+		t.Errorf("getGitHubToken() = %q, %v, want ghp_sm", token, err)
+	}
+
+	secretManager = fakeSecretManager{}
+	if _, err := getGitHubToken(context.Background(), repoWatch); err == nil {
+		t.Error("getGitHubToken() of a missing secret succeeded, want an error")
+	}
+}
+
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sessionSecret = []byte("secret")
+	router := gin.New()
+	router.Use(csrfProtect())
+	router.GET("/api/repos", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/api/repowatch", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// A safe request gets a token
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/repos", nil))
+	var token string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == csrfCookie {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		t.Fatal("GET did not set a CSRF cookie")
+	}
+	otherToken, err := newCSRFToken("another-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cookie   string
+		header   string
+		wantCode int
+	}{
+		{name: "no token", wantCode: http.StatusForbidden},
+		{name: "cookie only", cookie: token, wantCode: http.StatusForbidden},
+		{name: "header mismatch", cookie: token, header: otherToken, wantCode: http.StatusForbidden},
+		{name: "token of another session", cookie: otherToken, header: otherToken, wantCode: http.StatusForbidden},
+		{name: "valid", cookie: token, header: token, wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/repowatch", nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			req.Header.Set(csrfHeader, tt.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bluekeyes/go-gitdiff/gitdiff"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v39/github"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// devNull is the path of the missing side of added and deleted files.
+const devNull = "/dev/null"
+
+// DiffFile is a file of a PR diff. It has the shape of the files parsed by
+// react-diff-view so that the UI can render it directly.
+type DiffFile struct {
+	OldPath     string `json:"oldPath"`
+	NewPath     string `json:"newPath"`
+	OldRevision string `json:"oldRevision"`
+	NewRevision string `json:"newRevision"`
+	// Type is add, delete, modify, rename or copy
+	Type     string `json:"type"`
+	IsBinary bool   `json:"isBinary,omitempty"`
+	// Language is the language of the file for syntax highlighting, if
+	// known.
+	Language  string     `json:"language,omitempty"`
+	Additions int64      `json:"additions"`
+	Deletions int64      `json:"deletions"`
+	Hunks     []DiffHunk `json:"hunks"`
+}
+
+// DiffHunk is a contiguous block of changes.
+type DiffHunk struct {
+	// Content is the hunk header, e.g. "@@ -1,3 +1,4 @@ func main() {"
+	Content  string `json:"content"`
+	OldStart int64  `json:"oldStart"`
+	OldLines int64  `json:"oldLines"`
+	NewStart int64  `json:"newStart"`
+	NewLines int64  `json:"newLines"`
+	// Section is the enclosing function or section git found for the hunk.
+	Section string       `json:"section,omitempty"`
+	Changes []DiffChange `json:"changes"`
+}
+
+// DiffChange is a line of a hunk. Inserted and deleted lines have a
+// lineNumber, unchanged lines an oldLineNumber and a newLineNumber.
+type DiffChange struct {
+	Type          string `json:"type"`
+	IsInsert      bool   `json:"isInsert,omitempty"`
+	IsDelete      bool   `json:"isDelete,omitempty"`
+	IsNormal      bool   `json:"isNormal,omitempty"`
+	LineNumber    int64  `json:"lineNumber,omitempty"`
+	OldLineNumber int64  `json:"oldLineNumber,omitempty"`
+	NewLineNumber int64  `json:"newLineNumber,omitempty"`
+	Content       string `json:"content"`
+}
+
+// languages maps file extensions and names to syntax highlighting languages.
+var languages = map[string]string{
+	".go":        "go",
+	".py":        "python",
+	".js":        "javascript",
+	".jsx":       "javascript",
+	".ts":        "typescript",
+	".tsx":       "typescript",
+	".java":      "java",
+	".c":         "c",
+	".h":         "c",
+	".cc":        "cpp",
+	".cpp":       "cpp",
+	".hpp":       "cpp",
+	".rs":        "rust",
+	".rb":        "ruby",
+	".sh":        "bash",
+	".yaml":      "yaml",
+	".yml":       "yaml",
+	".json":      "json",
+	".md":        "markdown",
+	".proto":     "protobuf",
+	".css":       "css",
+	".html":      "html",
+	".sql":       "sql",
+	"Dockerfile": "dockerfile",
+	"Makefile":   "makefile",
+}
+
+func languageOf(p string) string {
+	if lang, ok := languages[path.Base(p)]; ok {
+		return lang
+	}
+	return languages[path.Ext(p)]
+}
+
+// parseDiff parses a git diff into the files rendered by the UI.
+func parseDiff(diff string) ([]DiffFile, error) {
+	files, _, err := gitdiff.Parse(strings.NewReader(diff))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]DiffFile, 0, len(files))
+	for _, f := range files {
+		file := DiffFile{
+			OldPath:     f.OldName,
+			NewPath:     f.NewName,
+			OldRevision: f.OldOIDPrefix,
+			NewRevision: f.NewOIDPrefix,
+			Type:        "modify",
+			IsBinary:    f.IsBinary,
+			Hunks:       []DiffHunk{},
+		}
+		switch {
+		case f.IsNew:
+			file.Type = "add"
+			file.OldPath = devNull
+		case f.IsDelete:
+			file.Type = "delete"
+			file.NewPath = devNull
+		case f.IsRename:
+			file.Type = "rename"
+		case f.IsCopy:
+			file.Type = "copy"
+		}
+		name := file.NewPath
+		if name == devNull {
+			name = file.OldPath
+		}
+		file.Language = languageOf(name)
+		// The UI keys files by revision, which mode only changes don't have
+		if file.OldRevision == "" && file.NewRevision == "" {
+			file.OldRevision, file.NewRevision = file.OldPath, file.NewPath
+		}
+
+		for _, frag := range f.TextFragments {
+			hunk := DiffHunk{
+				Content:  fmt.Sprintf("@@ -%d,%d +%d,%d @@", frag.OldPosition, frag.OldLines, frag.NewPosition, frag.NewLines),
+				OldStart: frag.OldPosition,
+				OldLines: frag.OldLines,
+				NewStart: frag.NewPosition,
+				NewLines: frag.NewLines,
+				Section:  strings.TrimSpace(frag.Comment),
+				Changes:  make([]DiffChange, 0, len(frag.Lines)),
+			}
+			if hunk.Section != "" {
+				hunk.Content += " " + hunk.Section
+			}
+			oldLine, newLine := frag.OldPosition, frag.NewPosition
+			for _, line := range frag.Lines {
+				change := DiffChange{Content: strings.TrimSuffix(line.Line, "\n")}
+				switch line.Op {
+				case gitdiff.OpAdd:
+					change.Type, change.IsInsert, change.LineNumber = "insert", true, newLine
+					newLine++
+					file.Additions++
+				case gitdiff.OpDelete:
+					change.Type, change.IsDelete, change.LineNumber = "delete", true, oldLine
+					oldLine++
+					file.Deletions++
+				default:
+					change.Type, change.IsNormal = "normal", true
+					change.OldLineNumber, change.NewLineNumber = oldLine, newLine
+					oldLine++
+					newLine++
+				}
+				hunk.Changes = append(hunk.Changes, change)
+			}
+			file.Hunks = append(file.Hunks, hunk)
+		}
+		result = append(result, file)
+	}
+	return result, nil
+}
+
+// getPRDiff returns the parsed diff of a PR. It is fetched with the user's
+// or the repo's token so that private repos work.
+func getPRDiff(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	prNumber, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pr id"})
+		return
+	}
+
+	repoWatch, err := getRepoWatch(c.Request.Context(), namespace, repo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	repoURL, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "repoURL")
+	owner, repoName, err := parseRepoURL(repoURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse repo url"})
+		return
+	}
+
+	var raw string
+	_, err = callGitHub(c, repoWatch, func(client *github.Client) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		raw, resp, err = client.PullRequests.GetRaw(c.Request.Context(), owner, repoName, prNumber, github.RawOptions{Type: github.Diff})
+		return resp, err
+	})
+	if err != nil {
+		log.Printf("Failed to get diff of PR %d in %s/%s: %v", prNumber, owner, repoName, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to get diff from github: %v", err)})
+		return
+	}
+
+	files, err := parseDiff(raw)
+	if err != nil {
+		log.Printf("Failed to parse diff of PR %d in %s/%s: %v", prNumber, owner, repoName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse diff: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
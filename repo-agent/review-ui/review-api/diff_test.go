@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,3 @@ package main
+ func main() {
+-	println("hello")
++	println("hello, world")
+ }
+diff --git a/docs/new.md b/docs/new.md
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/docs/new.md
+@@ -0,0 +1 @@
++# New
+`
+
+func TestParseDiff(t *testing.T) {
+	files, err := parseDiff(testDiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffFile{
+		{
+			OldPath: "main.go", NewPath: "main.go",
+			OldRevision: "1111111", NewRevision: "2222222",
+			Type: "modify", Language: "go", Additions: 1, Deletions: 1,
+			Hunks: []DiffHunk{{
+				Content:  "@@ -1,3 +1,3 @@ package main",
+				OldStart: 1, OldLines: 3, NewStart: 1, NewLines: 3,
+				Section: "package main",
+				Changes: []DiffChange{
+					{Type: "normal", IsNormal: true, OldLineNumber: 1, NewLineNumber: 1, Content: "func main() {"},
+					{Type: "delete", IsDelete: true, LineNumber: 2, Content: "\tprintln(\"hello\")"},
+					{Type: "insert", IsInsert: true, LineNumber: 2, Content: "\tprintln(\"hello, world\")"},
+					{Type: "normal", IsNormal: true, OldLineNumber: 3, NewLineNumber: 3, Content: "}"},
+				},
+			}},
+		},
+		{
+			OldPath: devNull, NewPath: "docs/new.md",
+			OldRevision: "0000000", NewRevision: "3333333",
+			Type: "add", Language: "markdown", Additions: 1,
+			Hunks: []DiffHunk{{
+				Content:  "@@ -0,0 +1,1 @@",
+				NewStart: 1, NewLines: 1,
+				Changes: []DiffChange{
+					{Type: "insert", IsInsert: true, LineNumber: 1, Content: "# New"},
+				},
+			}},
+		},
+	}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("parseDiff() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// maxDraftVersions is how many versions of a draft are kept.
+	maxDraftVersions = 20
+
+	draftSourceAgent = "agent"
+	draftSourceUser  = "user"
+)
+
+// DraftVersion is a version of the draft of a PR review or issue comment.
+type DraftVersion struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Source is agent for drafts written by the agent and user for edits
+	Source string `json:"source"`
+	User   string `json:"user,omitempty"`
+	Draft  string `json:"draft"`
+	// RestoredFrom is the version a restored draft was copied from
+	RestoredFrom string `json:"restoredFrom,omitempty"`
+}
+
+// draftHistoryKey returns the key of the hash holding the draft versions of
+// the PR or issue stored at key.
+func draftHistoryKey(key string) string {
+	return fmt.Sprintf("drafthistory:%s", key)
+}
+
+// draftVersions returns the draft versions of the PR or issue stored at key,
+// newest first.
+func draftVersions(ctx context.Context, key string) ([]DraftVersion, error) {
+	data, err := store.HGetAll(ctx, draftHistoryKey(key))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]DraftVersion, 0, len(data))
+	for _, v := range data {
+		var version DraftVersion
+		if err := json.Unmarshal([]byte(v), &version); err != nil {
+			log.Printf("Failed to parse draft version %q: %v", v, err)
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Time.After(versions[j].Time)
+	})
+	return versions, nil
+}
+
+// recordDraftVersion adds a version to the draft history of the PR or issue
+// stored at key, dropping the oldest versions beyond maxDraftVersions. User
+// drafts equal to the latest version and agent drafts equal to the latest
+// agent version are not recorded, so saving an unchanged draft is a no-op.
+func recordDraftVersion(ctx context.Context, key string, v DraftVersion) error {
+	versions, err := draftVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, prev := range versions {
+		if v.Source == draftSourceUser || prev.Source == draftSourceAgent {
+			if prev.Draft == v.Draft {
+				return nil
+			}
+			break
+		}
+	}
+
+	if v.Time.IsZero() {
+		v.Time = time.Now().UTC()
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return err
+	}
+	v.ID = v.Time.Format(time.RFC3339Nano) + "-" + id
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := store.HSet(ctx, draftHistoryKey(key), v.ID, string(b)); err != nil {
+		return err
+	}
+
+	if len(versions)+1 > maxDraftVersions {
+		var stale []string
+		for _, old := range versions[maxDraftVersions-1:] {
+			stale = append(stale, old.ID)
+		}
+		return store.HDel(ctx, draftHistoryKey(key), stale...)
+	}
+	return nil
+}
+
+// recordAgentDraft adds the agent's current draft to the history. The agent
+// drafts are read from the sandboxes, so they are recorded when the history is
+// read or a user edit is saved.
+func recordAgentDraft(ctx context.Context, key, agentDraft string) {
+	if agentDraft == "" {
+		return
+	}
+	if err := recordDraftVersion(ctx, key, DraftVersion{Source: draftSourceAgent, Draft: agentDraft}); err != nil {
+		log.Printf("Failed to record agent draft of %s: %v", key, err)
+	}
+}
+
+// recordUserDraft adds a draft saved by the user of the request to the
+// history.
+func recordUserDraft(c *gin.Context, key, draft string) {
+	if err := recordDraftVersion(c.Request.Context(), key, DraftVersion{Source: draftSourceUser, User: requestUser(c), Draft: draft}); err != nil {
+		log.Printf("Failed to record draft of %s: %v", key, err)
+	}
+}
+
+// prAgentDraft returns the agent's draft of a PR.
+func prAgentDraft(namespace, repo, prID string) string {
+	if sandbox := k8sCache.findSandbox(reviewSandboxGVR, namespace, repo, "", "pr", prID); sandbox != nil {
+		return sandbox.GetAnnotations()["agentDraft"]
+	}
+	return ""
+}
+
+// issueAgentDraft returns the agent's draft of an issue.
+func issueAgentDraft(namespace, repo, handler, issueID string) string {
+	if sandbox := k8sCache.findSandbox(issueSandboxGVR, namespace, repo, handler, "issue", issueID); sandbox != nil {
+		draft, _, _ := unstructured.NestedString(sandbox.Object, "status", "agentDraft")
+		return draft
+	}
+	return ""
+}
+
+func getPRDraftHistory(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	prID := c.Param("id")
+	respondDraftHistory(c, prKey(repo, prID), prAgentDraft(namespace, repo, prID))
+}
+
+func restorePRDraft(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	prID := c.Param("id")
+	restoreDraft(c, prKey(repo, prID), prAgentDraft(namespace, repo, prID))
+}
+
+func getIssueDraftHistory(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	issueID := c.Param("issue_id")
+	handler := c.Param("handler")
+	respondDraftHistory(c, issueKey(repo, handler, issueID), issueAgentDraft(namespace, repo, handler, issueID))
+}
+
+func restoreIssueDraft(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	issueID := c.Param("issue_id")
+	handler := c.Param("handler")
+	restoreDraft(c, issueKey(repo, handler, issueID), issueAgentDraft(namespace, repo, handler, issueID))
+}
+
+// respondDraftHistory responds with the draft versions of the PR or issue
+// stored at key, newest first.
+func respondDraftHistory(c *gin.Context, key, agentDraft string) {
+	ctx := c.Request.Context()
+	recordAgentDraft(ctx, key, agentDraft)
+	versions, err := draftVersions(ctx, key)
+	if err != nil {
+		log.Printf("Failed to get draft history of %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get draft history"})
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// restoreDraft makes the version of the version parameter the current draft
+// of the PR or issue stored at key. The restore is recorded as a new version
+// so that it can be undone.
+func restoreDraft(c *gin.Context, key, agentDraft string) {
+	ctx := c.Request.Context()
+	recordAgentDraft(ctx, key, agentDraft)
+	versions, err := draftVersions(ctx, key)
+	if err != nil {
+		log.Printf("Failed to get draft history of %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get draft history"})
+		return
+	}
+	var restored *DraftVersion
+	for i := range versions {
+		if versions[i].ID == c.Param("version") {
+			restored = &versions[i]
+			break
+		}
+	}
+	if restored == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "draft version not found"})
+		return
+	}
+
+	if err := store.HSet(ctx, key, "draft", restored.Draft); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+		return
+	}
+	if err := recordDraftVersion(ctx, key, DraftVersion{
+		Source:       draftSourceUser,
+		User:         requestUser(c),
+		Draft:        restored.Draft,
+		RestoredFrom: restored.ID,
+	}); err != nil {
+		log.Printf("Failed to record restored draft of %s: %v", key, err)
+	}
+	c.JSON(http.StatusOK, draftPayload{Draft: restored.Draft})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecordDraftVersion(t *testing.T) {
+	store = newMemoryStore()
+	ctx := context.Background()
+	key := prKey("kubernetes", "1")
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	record := func(i int, source, draft string) {
+		t.Helper()
+		v := DraftVersion{Time: start.Add(time.Duration(i) * time.Minute), Source: source, Draft: draft}
+		if err := recordDraftVersion(ctx, key, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(0, draftSourceAgent, "agent")
+	// Saving the agent draft unchanged is not a new version
+	record(1, draftSourceUser, "agent")
+	record(2, draftSourceUser, "edit")
+	// Neither is seeing the same agent draft again
+	record(3, draftSourceAgent, "agent")
+
+	versions, err := draftVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Draft != "edit" || versions[1].Draft != "agent" {
+		t.Fatalf("draftVersions() = %+v, want edit and agent", versions)
+	}
+
+	for i := 0; i < maxDraftVersions; i++ {
+		record(10+i, draftSourceUser, fmt.Sprintf("edit %d", i))
+	}
+	versions, err = draftVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != maxDraftVersions {
+		t.Fatalf("got %d versions, want %d", len(versions), maxDraftVersions)
+	}
+	if want := fmt.Sprintf("edit %d", maxDraftVersions-1); versions[0].Draft != want {
+		t.Errorf("newest version = %q, want %q", versions[0].Draft, want)
+	}
+	if versions[len(versions)-1].Draft != "edit 0" {
+		t.Errorf("oldest version = %q, want the oldest ones dropped", versions[len(versions)-1].Draft)
+	}
+}
+
+func TestRestoreDraft(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	ctx := context.Background()
+	key := prKey("kubernetes", "1")
+	if err := recordDraftVersion(ctx, key, DraftVersion{Source: draftSourceUser, Draft: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.HSet(ctx, key, "draft", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordDraftVersion(ctx, key, DraftVersion{Source: draftSourceUser, Draft: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := draftVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := versions[len(versions)-1]
+
+	for _, tt := range []struct {
+		version  string
+		wantCode int
+	}{
+		{version: "missing", wantCode: http.StatusNotFound},
+		{version: first.ID, wantCode: http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		c.Params = gin.Params{{Key: "version", Value: tt.version}}
+		restoreDraft(c, key, "")
+		if w.Code != tt.wantCode {
+			t.Errorf("restoreDraft(%s) status = %d, want %d", tt.version, w.Code, tt.wantCode)
+		}
+	}
+
+	if draft, _ := store.HGet(ctx, key, "draft"); draft != "first" {
+		t.Errorf("draft after restore = %q, want first", draft)
+	}
+	versions, err = draftVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].RestoredFrom != first.ID {
+		t.Errorf("draftVersions() after restore = %+v, want a version restored from %s", versions, first.ID)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FeedbackRecord is a line of the human feedback export: the agent's output
+// for a prompt and the version a human corrected and posted.
+type FeedbackRecord struct {
+	Prompt      string           `json:"prompt"`
+	AgentOutput string           `json:"agentOutput"`
+	HumanOutput string           `json:"humanOutput"`
+	Metadata    FeedbackMetadata `json:"metadata"`
+}
+
+// FeedbackMetadata describes where a feedback record comes from.
+type FeedbackMetadata struct {
+	// Type is review or issue
+	Type      string `json:"type"`
+	Owner     string `json:"owner"`
+	Namespace string `json:"namespace,omitempty"`
+	Repo      string `json:"repo"`
+	Handler   string `json:"handler,omitempty"`
+	// ID is the PR or issue number
+	ID          string `json:"id"`
+	Configdir   string `json:"configdir,omitempty"`
+	SubmittedBy string `json:"submittedBy,omitempty"`
+	// SubmittedAt is unset for feedback stored before it was recorded
+	SubmittedAt *time.Time `json:"submittedAt,omitempty"`
+}
+
+// parseFeedbackKey parses the metadata of a feedback key, e.g.
+// hf:review:githubuser:<owner>:repo:<repo>:pr:<id> or
+// hf:issue:githubuser:<owner>:repo:<repo>:handler:<handler>:pr:<id>.
+func parseFeedbackKey(key string) (FeedbackMetadata, bool) {
+	parts := strings.Split(key, ":")
+	if len(parts) < 2 || parts[0] != "hf" || len(parts)%2 != 0 {
+		return FeedbackMetadata{}, false
+	}
+	m := FeedbackMetadata{Type: parts[1]}
+	for i := 2; i+1 < len(parts); i += 2 {
+		switch parts[i] {
+		case "githubuser":
+			m.Owner = parts[i+1]
+		case "repo":
+			m.Repo = parts[i+1]
+		case "handler":
+			m.Handler = parts[i+1]
+		case "pr":
+			m.ID = parts[i+1]
+		}
+	}
+	if m.Repo == "" || m.ID == "" {
+		return FeedbackMetadata{}, false
+	}
+	return m, true
+}
+
+// feedbackRecord builds the export record of the feedback stored at key.
+func feedbackRecord(key string, data map[string]string) (FeedbackRecord, bool) {
+	m, ok := parseFeedbackKey(key)
+	if !ok {
+		return FeedbackRecord{}, false
+	}
+	m.Namespace = data["namespace"]
+	m.SubmittedBy = data["submittedBy"]
+	m.Configdir = data["configdir"]
+	if m.Configdir == "" {
+		m.Configdir = data["configdirname"]
+	}
+	if t, err := time.Parse(time.RFC3339, data["submittedAt"]); err == nil {
+		m.SubmittedAt = &t
+	}
+	return FeedbackRecord{
+		Prompt:      data["prompt"],
+		AgentOutput: data["agentDraft"],
+		HumanOutput: data["draft"],
+		Metadata:    m,
+	}, true
+}
+
+// exportFeedback streams the human feedback as JSONL for fine-tuning, oldest
+// first. It is filtered by the type, namespace and repo query parameters, and
+// since and until bound the submission time. Feedback without a submission
+// time is only exported when no range is given.
+func exportFeedback(c *gin.Context) {
+	var since, until time.Time
+	var err error
+	if s := c.Query("since"); s != "" {
+		if since, err = parseDate(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + err.Error()})
+			return
+		}
+	}
+	if s := c.Query("until"); s != "" {
+		if until, err = parseDate(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid until: " + err.Error()})
+			return
+		}
+		if len(s) == len(auditDateFormat) {
+			// Include the whole day
+			until = until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	feedbackType := c.Query("type")
+	namespace := c.Query("namespace")
+	repo := c.Query("repo")
+
+	ctx := c.Request.Context()
+	keys, err := store.Keys(ctx, "hf:*")
+	if err != nil {
+		log.Printf("Failed to list feedback: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list feedback"})
+		return
+	}
+	sort.Strings(keys)
+
+	viewer := requestUser(c)
+	roles := map[string]role{}
+	// Feedback holds the prompts and drafts of the repo, so only admins can
+	// export it
+	isAdmin := func(namespace, repo string) bool {
+		if !rbacEnabled {
+			return true
+		}
+		if namespace == "" {
+			return false
+		}
+		key := namespace + "/" + repo
+		r, ok := roles[key]
+		if !ok {
+			r = k8sCache.roleFor(viewer, namespace, repo)
+			roles[key] = r
+		}
+		return r >= roleAdmin
+	}
+
+	var records []FeedbackRecord
+	for _, key := range keys {
+		data, err := store.HGetAll(ctx, key)
+		if err != nil {
+			log.Printf("Failed to get feedback %s: %v", key, err)
+			continue
+		}
+		r, ok := feedbackRecord(key, data)
+		if !ok {
+			continue
+		}
+		m := r.Metadata
+		if (feedbackType != "" && m.Type != feedbackType) || (namespace != "" && m.Namespace != namespace) || (repo != "" && m.Repo != repo) {
+			continue
+		}
+		if !since.IsZero() || !until.IsZero() {
+			if m.SubmittedAt == nil || (!since.IsZero() && m.SubmittedAt.Before(since)) || (!until.IsZero() && m.SubmittedAt.After(until)) {
+				continue
+			}
+		}
+		if !isAdmin(m.Namespace, m.Repo) {
+			continue
+		}
+		records = append(records, r)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i].Metadata.SubmittedAt, records[j].Metadata.SubmittedAt
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return a.Before(*b)
+	})
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="feedback.jsonl"`)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			log.Printf("Failed to write feedback export: %v", err)
+			return
+		}
+	}
+}
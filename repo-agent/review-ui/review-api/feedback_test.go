@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExportFeedback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store = newMemoryStore()
+	ctx := context.Background()
+	for key, values := range map[string][]interface{}{
+		"hf:review:githubuser:kubernetes:repo:kubernetes:pr:1": {
+			"draft", "human", "agentDraft", "agent", "prompt", "review", "configdir", "go",
+			"namespace", "default", "submittedAt", "2025-03-10T12:00:00Z",
+		},
+		"hf:issue:githubuser:kubernetes:repo:kubernetes:handler:triage:pr:2": {
+			"draft", "fixed", "agentDraft", "broken", "prompt", "triage", "configdirname", "k8s",
+			"namespace", "default", "submittedAt", "2025-03-12T12:00:00Z",
+		},
+		// Stored before submission times were recorded
+		"hf:review:githubuser:redis:repo:redis:pr:3": {"draft", "old", "agentDraft", "older"},
+	} {
+		if err := store.HSet(ctx, key, values...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query   string
+		wantIDs []string
+	}{
+		{query: "", wantIDs: []string{"3", "1", "2"}},
+		{query: "type=issue", wantIDs: []string{"2"}},
+		{query: "since=2025-03-11", wantIDs: []string{"2"}},
+		{query: "until=2025-03-10", wantIDs: []string{"1"}},
+		{query: "repo=redis", wantIDs: []string{"3"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/feedback/export?"+tt.query, nil)
+		exportFeedback(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("exportFeedback(%s) status = %d: %s", tt.query, w.Code, w.Body.String())
+		}
+		var ids []string
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var r FeedbackRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("exportFeedback(%s) line %q: %v", tt.query, scanner.Text(), err)
+			}
+			ids = append(ids, r.Metadata.ID)
+			if r.Metadata.ID == "2" && (r.Metadata.Handler != "triage" || r.Metadata.Configdir != "k8s" || r.HumanOutput != "fixed" || r.AgentOutput != "broken") {
+				t.Errorf("exportFeedback(%s) record = %+v", tt.query, r)
+			}
+		}
+		if len(ids) != len(tt.wantIDs) {
+			t.Errorf("exportFeedback(%s) ids = %v, want %v", tt.query, ids, tt.wantIDs)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.wantIDs[i] {
+				t.Errorf("exportFeedback(%s) ids = %v, want %v", tt.query, ids, tt.wantIDs)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	// githubAPIURL and githubUploadURL point every RepoWatch without its own
+	// spec.github at a GitHub Enterprise Server, e.g.
+	// https://ghe.example.com/api/v3/. github.com is used when unset.
+	githubAPIURL    = os.Getenv("GITHUB_API_URL")
+	githubUploadURL = os.Getenv("GITHUB_UPLOAD_URL")
+)
+
+// githubEndpoints returns the API and upload URLs of a RepoWatch, falling
+// back to the global ones. Both are empty for github.com.
+func githubEndpoints(repoWatch *unstructured.Unstructured) (string, string) {
+	baseURL, uploadURL := githubAPIURL, githubUploadURL
+	if repoWatch != nil {
+		if u, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "github", "baseURL"); u != "" {
+			baseURL = u
+			uploadURL, _, _ = unstructured.NestedString(repoWatch.Object, "spec", "github", "uploadURL")
+		}
+	}
+	if uploadURL == "" {
+		uploadURL = baseURL
+	}
+	return baseURL, uploadURL
+}
+
+// newGitHubAPIClient returns a client for the GitHub API at baseURL, or
+// github.com if it is empty. Its calls are traced as part of the trace of
+// their context, if any.
+func newGitHubAPIClient(httpClient *http.Client, baseURL, uploadURL string) (*github.Client, error) {
+	httpClient.Transport = tracing.Transport(httpClient.Transport)
+	if baseURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+	client, err := github.NewEnterpriseClient(baseURL, uploadURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid github api url %q: %w", baseURL, err)
+	}
+	return client, nil
+}
+
+// newGitHubClient returns a client authenticated with token for the GitHub
+// API of a RepoWatch, or the global one if repoWatch is nil.
+func newGitHubClient(ctx context.Context, repoWatch *unstructured.Unstructured, token string) (*github.Client, error) {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	baseURL, uploadURL := githubEndpoints(repoWatch)
+	return newGitHubAPIClient(oauth2.NewClient(ctx, ts), baseURL, uploadURL)
+}
+
+// githubHost returns the web host of the GitHub API at baseURL, e.g.
+// ghe.example.com for https://ghe.example.com/api/v3/.
+func githubHost(baseURL string) string {
+	if baseURL == "" {
+		return "github.com"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "api.")
+}
+
+// proxyAllowed reports whether /api/proxy may fetch rawURL: an https URL on
+// github.com, raw.githubusercontent.com or a configured GitHub Enterprise
+// Server.
+func proxyAllowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return false
+	}
+	// Reject explicit ports so that only the hosts' HTTPS endpoints are
+	// reachable
+	if u.Port() != "" {
+		return false
+	}
+	host := u.Hostname()
+	if host == "github.com" || host == "raw.githubusercontent.com" || host == githubHost(githubAPIURL) {
+		return true
+	}
+	if k8sCache == nil {
+		return false
+	}
+	for _, repoWatch := range k8sCache.list(repoWatchGVR, "", labels.Everything()) {
+		if baseURL, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "github", "baseURL"); baseURL != "" && githubHost(baseURL) == host {
+			return true
+		}
+	}
+	return false
+}
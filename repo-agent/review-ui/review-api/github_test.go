@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestGitHubEndpoints(t *testing.T) {
+	oldAPI, oldUpload := githubAPIURL, githubUploadURL
+	defer func() { githubAPIURL, githubUploadURL = oldAPI, oldUpload }()
+
+	ghe := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"github": map[string]interface{}{"baseURL": "https://ghe.example.com/api/v3/"},
+		},
+	}}
+	tests := []struct {
+		name                    string
+		globalAPI, globalUpload string
+		repoWatch               *unstructured.Unstructured
+		wantBaseURL, wantUpload string
+	}{
+		{name: "github.com", repoWatch: &unstructured.Unstructured{Object: map[string]interface{}{}}},
+		{name: "global", globalAPI: "https://global.example.com/api/v3/", globalUpload: "https://global.example.com/api/uploads/",
+			wantBaseURL: "https://global.example.com/api/v3/", wantUpload: "https://global.example.com/api/uploads/"},
+		{name: "repowatch overrides global", globalAPI: "https://global.example.com/api/v3/", globalUpload: "https://global.example.com/api/uploads/", repoWatch: ghe,
+			wantBaseURL: "https://ghe.example.com/api/v3/", wantUpload: "https://ghe.example.com/api/v3/"},
+	}
+	for _, tt := range tests {
+		githubAPIURL, githubUploadURL = tt.globalAPI, tt.globalUpload
+		baseURL, uploadURL := githubEndpoints(tt.repoWatch)
+		if baseURL != tt.wantBaseURL || uploadURL != tt.wantUpload {
+			t.Errorf("%s: githubEndpoints() = %q, %q, want %q, %q", tt.name, baseURL, uploadURL, tt.wantBaseURL, tt.wantUpload)
+		}
+	}
+}
+
+func TestProxyAllowed(t *testing.T) {
+	oldAPI := githubAPIURL
+	defer func() { githubAPIURL = oldAPI }()
+	githubAPIURL = "https://ghe.example.com/api/v3/"
+
+	for url, want := range map[string]bool{
+		"https://github.com/owner/repo/pull/1.diff":         true,
+		"https://raw.githubusercontent.com/owner/repo/x.go": true,
+		"https://ghe.example.com/owner/repo/pull/1.diff":    true,
+		"http://github.com/owner/repo":                      false,
+		"https://github.com.evil.com/owner/repo":            false,
+		"https://github.com:8443/owner/repo":                false,
+		"https://user@github.com/owner/repo":                false,
+		"https://other.example.com/owner/repo/pull/1.diff":  false,
+	} {
+		if got := proxyAllowed(url); got != want {
+			t.Errorf("proxyAllowed(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds each dependency check of /readyz.
+const healthCheckTimeout = 2 * time.Second
+
+// healthz is the liveness probe. The API is alive as long as it serves.
+func healthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// readyz is the readiness probe. The API is ready when it can reach the
+// store and the Kubernetes API, or serves from memory while Redis is down.
+func readyz(c *gin.Context) {
+	checks := map[string]func(context.Context) error{
+		"redis": store.Ping,
+		"kubernetes": func(ctx context.Context) error {
+			return kubeClient.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+		},
+	}
+	status := http.StatusOK
+	results := gin.H{}
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+		err := check(ctx)
+		cancel()
+		var degraded *degradedError
+		if errors.As(err, &degraded) {
+			// Still serving, from memory
+			dependencyUp.WithLabelValues(name).Set(0)
+			results[name] = err.Error()
+			continue
+		}
+		if err != nil {
+			log.Printf("Readiness check %s failed: %v", name, err)
+			dependencyUp.WithLabelValues(name).Set(0)
+			results[name] = err.Error()
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		dependencyUp.WithLabelValues(name).Set(1)
+		results[name] = "ok"
+	}
+	c.JSON(status, results)
+}
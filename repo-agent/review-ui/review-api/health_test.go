@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metricsMiddleware())
+	router.GET("/api/repo/:namespace/:repo/prs", func(c *gin.Context) {
+		c.Status(http.StatusTeapot)
+	})
+
+	before := testutil.CollectAndCount(requestDuration)
+	for _, path := range []string{"/api/repo/default/kubernetes/prs", "/api/repo/default/linux/prs"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	// Both requests are labeled with the route, not the path
+	if got := testutil.CollectAndCount(requestDuration); got != before+1 {
+		t.Errorf("got %d request series, want %d", got, before+1)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	healthz(c)
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz() status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
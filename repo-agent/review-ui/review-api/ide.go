@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// codeServerServicePort is the port of the service of a sandbox forwarding to
+// its code-server, see the service resource of the sandbox RGDs.
+var codeServerServicePort = 13338
+
+const (
+	// ideCookie lets a user in the IDE of the sandbox of the host it is set
+	// by.
+	ideCookie = "review_ide"
+	// ideTicketParam is the query parameter of the URL of openSandboxIDE
+	// carrying the ticket exchanged for an ideCookie.
+	ideTicketParam = "ide_ticket"
+	ideTicketTTL   = time.Minute
+)
+
+// ideDomain is IDE_DOMAIN, the domain the IDE of each sandbox is served from
+// under its own host, <name>--<namespace>.<domain>, including the port if it
+// is not the default one. The IDE is disabled if it is empty.
+var ideDomain = strings.ToLower(os.Getenv("IDE_DOMAIN"))
+
+type ideResponse struct {
+	URL string `json:"url"`
+}
+
+// validateIDEDomain checks that the hosts of the IDEs don't receive the
+// cookies of the API.
+func validateIDEDomain() error {
+	if ideDomain == "" || cookieDomain == "" {
+		return nil
+	}
+	host := ideDomain
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	domain := strings.ToLower(strings.TrimPrefix(cookieDomain, "."))
+	if host == domain || strings.HasSuffix(host, "."+domain) {
+		return fmt.Errorf("IDE_DOMAIN %s is within COOKIE_DOMAIN %s, the sandboxes would get the cookies of the API", ideDomain, cookieDomain)
+	}
+	return nil
+}
+
+// ideHost returns the host the IDE of a sandbox is served from.
+func ideHost(namespace, name string) string {
+	return name + "--" + namespace + "." + ideDomain
+}
+
+// parseIDEHost returns the sandbox whose IDE is served from host.
+func parseIDEHost(host string) (namespace, name string, ok bool) {
+	if ideDomain == "" {
+		return "", "", false
+	}
+	label, found := strings.CutSuffix(strings.ToLower(host), "."+ideDomain)
+	if !found || len(label) > 63 || strings.Contains(label, ".") {
+		return "", "", false
+	}
+	i := strings.LastIndex(label, "--")
+	if i <= 0 || i+2 == len(label) {
+		return "", "", false
+	}
+	return label[i+2:], label[:i], true
+}
+
+// signIDEAccess returns a token letting user in the IDE of a sandbox until
+// expiry.
+func signIDEAccess(namespace, name, user string, expiry time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(namespace + "|" + name + "|" + strconv.FormatInt(expiry.Unix(), 10) + "|" + user))
+	return payload + "." + ideMAC(payload)
+}
+
+// verifyIDEAccess returns the user of a token of signIDEAccess, if it is
+// valid for the IDE of a sandbox.
+func verifyIDEAccess(token, namespace, name string) (string, bool) {
+	payload, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(ideMAC(payload))) {
+		return "", false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+	fields := strings.SplitN(string(decoded), "|", 4)
+	if len(fields) != 4 || fields[0] != namespace || fields[1] != name {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", false
+	}
+	return fields[3], true
+}
+
+func ideMAC(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte("ide|" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ideSandbox returns a review or issue sandbox by name.
+func ideSandbox(namespace, name string) (*unstructured.Unstructured, error) {
+	sandbox, err := k8sCache.get(reviewSandboxGVR, namespace, name)
+	if err != nil {
+		sandbox, err = k8sCache.get(issueSandboxGVR, namespace, name)
+	}
+	if err != nil {
+		return nil, &apiError{status: http.StatusNotFound, message: fmt.Sprintf("sandbox %s not found", name)}
+	}
+	return sandbox, nil
+}
+
+// ideTarget returns the URL of the code-server of a sandbox.
+func ideTarget(sandbox *unstructured.Unstructured) (*url.URL, error) {
+	name := sandbox.GetName()
+	if enabled, found, _ := unstructured.NestedBool(sandbox.Object, "spec", "codeServer", "enabled"); found && !enabled {
+		return nil, &apiError{status: http.StatusConflict, message: fmt.Sprintf("code-server is disabled in sandbox %s", name)}
+	}
+	if replicas, found, _ := unstructured.NestedInt64(sandbox.Object, "spec", "replicas"); found && replicas == 0 {
+		return nil, &apiError{status: http.StatusConflict, message: fmt.Sprintf("sandbox %s is scaled down", name)}
+	}
+	fqdn, _, _ := unstructured.NestedString(sandbox.Object, "status", "fqdn")
+	if fqdn == "" {
+		return nil, &apiError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("sandbox %s is not ready", name)}
+	}
+	return &url.URL{Scheme: "http", Host: fmt.Sprintf("%s:%d", fqdn, codeServerServicePort)}, nil
+}
+
+// openSandboxIDE returns the URL of the IDE of a sandbox of a RepoWatch,
+// with a ticket letting the user in for ideTicketTTL.
+func openSandboxIDE(c *gin.Context) {
+	if ideDomain == "" {
+		respondError(c, &apiError{status: http.StatusNotFound, message: "the IDE is not enabled, IDE_DOMAIN is not set"})
+		return
+	}
+	namespace, name := c.Param("namespace"), c.Param("name")
+	sandbox, err := ideSandbox(namespace, name)
+	if err == nil && sandbox.GetLabels()["review.gemini.google.com/repowatch"] != c.Param("repo") {
+		err = &apiError{status: http.StatusNotFound, message: fmt.Sprintf("sandbox %s not found", name)}
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if _, err := ideTarget(sandbox); err != nil {
+		respondError(c, err)
+		return
+	}
+	host := ideHost(namespace, name)
+	if ns, n, ok := parseIDEHost(host); !ok || ns != namespace || n != name {
+		respondError(c, &apiError{status: http.StatusConflict, message: fmt.Sprintf("sandbox %s can't be served from its own host %s", name, host)})
+		return
+	}
+	scheme := "http"
+	if isHTTPS(c) {
+		scheme = "https"
+	}
+	ticket := signIDEAccess(namespace, name, requestUser(c), time.Now().Add(ideTicketTTL))
+	u := url.URL{Scheme: scheme, Host: host, Path: "/", RawQuery: url.Values{ideTicketParam: {ticket}}.Encode()}
+	c.JSON(http.StatusOK, ideResponse{URL: u.String()})
+}
+
+// ideHosts is a middleware serving the requests to the host of a sandbox
+// with its IDE, so that they never reach the API.
+func ideHosts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		namespace, name, ok := parseIDEHost(c.Request.Host)
+		if !ok {
+			c.Next()
+			return
+		}
+		c.Abort()
+		sandboxIDE(c, namespace, name)
+	}
+}
+
+// sandboxIDE proxies the requests to the host of a sandbox to its
+// code-server, websockets included, so that users can open the workspace
+// without port-forwarding. The ticket of openSandboxIDE is exchanged for an
+// ideCookie of that host only, and the user must still have the reviewer role
+// on the RepoWatch of the sandbox.
+//
+// The sandbox runs the code under review, so it is served from its own origin,
+// where the cookies of the API are neither sent nor readable.
+func sandboxIDE(c *gin.Context, namespace, name string) {
+	if ticket := c.Query(ideTicketParam); ticket != "" {
+		user, ok := verifyIDEAccess(ticket, namespace, name)
+		if !ok {
+			c.String(http.StatusUnauthorized, "The IDE link expired, open the IDE from the review UI again")
+			return
+		}
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     ideCookie,
+			Value:    signIDEAccess(namespace, name, user, time.Now().Add(sessionTTL)),
+			MaxAge:   int(sessionTTL.Seconds()),
+			Path:     "/",
+			Secure:   secureCookie(c, http.SameSiteLaxMode),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		// Drop the ticket from the URL, and so from the history
+		query := c.Request.URL.Query()
+		query.Del(ideTicketParam)
+		redirect := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+		c.Redirect(http.StatusSeeOther, redirect.String())
+		return
+	}
+	cookie, err := c.Cookie(ideCookie)
+	user, ok := verifyIDEAccess(cookie, namespace, name)
+	if err != nil || !ok {
+		c.String(http.StatusUnauthorized, "Open the IDE from the review UI")
+		return
+	}
+	sandbox, err := ideSandbox(namespace, name)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	repo := sandbox.GetLabels()["review.gemini.google.com/repowatch"]
+	if rbacEnabled && k8sCache.roleFor(user, namespace, repo) < roleReviewer {
+		c.String(http.StatusForbidden, "Requires the %s role", roleReviewer)
+		return
+	}
+	target, err := ideTarget(sandbox)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			// Keep the host of the sandbox, code-server checks that the
+			// origin of websockets matches it
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+			stripAPICredentials(r.Out)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			stripAPICookies(resp)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Failed to proxy %s to the IDE of sandbox %s: %v", r.URL.Path, name, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// apiCookies are the cookies of the API, never sent to or set by sandboxes.
+var apiCookies = map[string]bool{sessionCookie: true, csrfCookie: true, oauthStateCookie: true, ideCookie: true}
+
+// stripAPICredentials removes the credentials of the user from a request to
+// a sandbox.
+func stripAPICredentials(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if !apiCookies[cookie.Name] {
+			r.AddCookie(cookie)
+		}
+	}
+	r.Header.Del("Authorization")
+	r.Header.Del(csrfHeader)
+	if userHeader != "" {
+		r.Header.Del(userHeader)
+	}
+}
+
+// stripAPICookies drops the cookies a sandbox tries to set over those of the
+// API.
+func stripAPICookies(resp *http.Response) {
+	cookies := resp.Cookies()
+	resp.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		if !apiCookies[cookie.Name] {
+			resp.Header.Add("Set-Cookie", cookie.String())
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func ideTestSandbox(name string, replicas int64, fqdn string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "team-a",
+			"labels":    map[string]interface{}{"review.gemini.google.com/repowatch": "kubernetes"},
+		},
+		"spec":   map[string]interface{}{"replicas": replicas, "codeServer": map[string]interface{}{"enabled": true}},
+		"status": map[string]interface{}{"fqdn": fqdn},
+	}}
+}
+
+func TestSandboxIDE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	codeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ideErr := r.Cookie(ideCookie)
+		_, codeServerErr := r.Cookie("code-server-session")
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "forged"})
+		http.SetCookie(w, &http.Cookie{Name: "code-server-session", Value: "ide"})
+		fmt.Fprintf(w, "path=%s review_ide=%t code-server=%t host=%s", r.URL.Path, ideErr == nil, codeServerErr == nil, r.Host)
+	}))
+	defer codeServer.Close()
+	host, port, _ := net.SplitHostPort(codeServer.Listener.Addr().String())
+
+	oldCache, oldPort, oldDomain := k8sCache, codeServerServicePort, ideDomain
+	defer func() { k8sCache, codeServerServicePort, ideDomain = oldCache, oldPort, oldDomain }()
+	codeServerServicePort, _ = strconv.Atoi(port)
+	ideDomain = "ide.example.com"
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		reviewSandboxGVR: {ideTestSandbox("kubernetes-pr-1", 1, host), ideTestSandbox("kubernetes-pr-2", 0, host)},
+		issueSandboxGVR:  {ideTestSandbox("kubernetes-issue-3-triage", 1, "")},
+	})
+
+	router := newRouter()
+	// The reverse proxy needs a real server to detect closed connections
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	csrfToken, err := newCSRFToken("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	do := func(method, host, path string, cookies ...*http.Cookie) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		req.AddCookie(&http.Cookie{Name: "code-server-session", Value: "ide"})
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: csrfToken})
+		req.Header.Set(csrfHeader, csrfToken)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// The API returns a link with a ticket to the host of the sandbox
+	resp, body := do(http.MethodPost, "review.example.com", "/api/repo/team-a/kubernetes/sandboxes/kubernetes-pr-1/ide")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST ide = %d %s, want %d", resp.StatusCode, body, http.StatusOK)
+	}
+	var link ideResponse
+	if err := json.Unmarshal([]byte(body), &link); err != nil {
+		t.Fatal(err)
+	}
+	ideURL, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ideURL.Host != "kubernetes-pr-1--team-a.ide.example.com" {
+		t.Fatalf("IDE host = %s, want kubernetes-pr-1--team-a.ide.example.com", ideURL.Host)
+	}
+
+	// The ticket is exchanged for a cookie of that host only
+	resp, _ = do(http.MethodGet, ideURL.Host, "/?"+ideURL.RawQuery)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Fatalf("GET ticket = %d to %q, want %d to /", resp.StatusCode, resp.Header.Get("Location"), http.StatusSeeOther)
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == ideCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.Domain != "" {
+		t.Fatalf("IDE cookie = %v, want a host-only HttpOnly cookie", cookie)
+	}
+
+	resp, body = do(http.MethodGet, ideURL.Host, "/static/out/main.js", cookie)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("proxy status = %d %s, want %d", resp.StatusCode, body, http.StatusOK)
+	}
+	if want := "path=/static/out/main.js review_ide=false code-server=true host=" + ideURL.Host; body != want {
+		t.Errorf("code-server got %q, want %q", body, want)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookie {
+			t.Errorf("the sandbox set the session cookie of the API")
+		}
+	}
+
+	// The API is not served from the hosts of the IDEs, and the cookie of a
+	// sandbox doesn't open another one
+	if resp, body := do(http.MethodGet, ideURL.Host, "/api/repos", cookie); !strings.HasPrefix(body, "path=/api/repos ") {
+		t.Errorf("GET /api/repos on the IDE host = %d %q, want it proxied", resp.StatusCode, body)
+	}
+	for _, tt := range []struct {
+		host string
+		path string
+		want int
+	}{
+		{host: ideURL.Host, path: "/"},
+		{host: ideURL.Host, path: "/?" + ideTicketParam + "=forged"},
+		{host: "kubernetes-pr-2--team-a.ide.example.com", path: "/"},
+	} {
+		if resp, _ := do(http.MethodGet, tt.host, tt.path); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s%s without a cookie = %d, want %d", tt.host, tt.path, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	if resp, _ := do(http.MethodGet, "kubernetes-pr-2--team-a.ide.example.com", "/", cookie); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET another IDE with the cookie = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	for path, want := range map[string]int{
+		"/api/repo/team-a/kubernetes/sandboxes/missing/ide":                   http.StatusNotFound,
+		"/api/repo/team-b/kubernetes/sandboxes/kubernetes-pr-1/ide":           http.StatusNotFound,
+		"/api/repo/team-a/other/sandboxes/kubernetes-pr-1/ide":                http.StatusNotFound,
+		"/api/repo/team-a/kubernetes/sandboxes/kubernetes-pr-2/ide":           http.StatusConflict,
+		"/api/repo/team-a/kubernetes/sandboxes/kubernetes-issue-3-triage/ide": http.StatusServiceUnavailable,
+	} {
+		if resp, _ := do(http.MethodPost, "review.example.com", path); resp.StatusCode != want {
+			t.Errorf("POST %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestValidateIDEDomain(t *testing.T) {
+	oldIDE, oldCookie := ideDomain, cookieDomain
+	defer func() { ideDomain, cookieDomain = oldIDE, oldCookie }()
+	for _, tt := range []struct {
+		ide, cookie string
+		wantErr     bool
+	}{
+		{ide: "", cookie: "example.com"},
+		{ide: "ide.example.com", cookie: ""},
+		{ide: "ide.example.dev", cookie: "example.com"},
+		{ide: "ide.example.com", cookie: ".example.com", wantErr: true},
+		{ide: "ide.example.com:8443", cookie: "ide.example.com", wantErr: true},
+	} {
+		ideDomain, cookieDomain = tt.ide, tt.cookie
+		if err := validateIDEDomain(); (err != nil) != tt.wantErr {
+			t.Errorf("validateIDEDomain(%q, %q) error = %v, want error %t", tt.ide, tt.cookie, err, tt.wantErr)
+		}
+	}
+}
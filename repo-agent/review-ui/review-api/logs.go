@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// defaultLogTailLines is the number of log lines returned by default.
+	defaultLogTailLines = 500
+	// runLogContainer is the container of the sandbox pods streaming the
+	// agent run log of the workspace, see pkg/sandbox.RunLogContainer.
+	runLogContainer = "run-log"
+)
+
+// kubeClient is used for the pods and their logs subresource, which the
+// dynamic client doesn't support.
+var kubeClient kubernetes.Interface
+
+// flushWriter flushes every write so that logs reach the browser as they
+// are produced.
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+func (f flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	f.w.Flush()
+	return n, err
+}
+
+func getPRLogs(c *gin.Context) {
+	sandbox := k8sCache.findSandbox(reviewSandboxGVR, c.Param("namespace"), c.Param("repo"), "", "pr", c.Param("id"))
+	streamSandboxLogs(c, sandbox, "review-sandbox")
+}
+
+func getIssueLogs(c *gin.Context) {
+	sandbox := k8sCache.findSandbox(issueSandboxGVR, c.Param("namespace"), c.Param("repo"), c.Param("handler"), "issue", c.Param("issue_id"))
+	streamSandboxLogs(c, sandbox, "issue-sandbox")
+}
+
+// streamSandboxLogs streams the logs of a sandbox pod as plain text. The
+// source query parameter selects the pod's container logs (the default) or
+// the agent run log in the workspace. container selects the container,
+// follow keeps streaming new lines and tailLines limits the initial lines.
+func streamSandboxLogs(c *gin.Context, sandbox *unstructured.Unstructured, sandboxContainer string) {
+	if sandbox == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sandbox not found"})
+		return
+	}
+	namespace := sandbox.GetNamespace()
+	name := sandbox.GetName()
+	source := c.DefaultQuery("source", "container")
+	if source != "container" && source != "workspace" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be container or workspace"})
+		return
+	}
+	follow := c.Query("follow") == "true"
+	tailLines := int64(defaultLogTailLines)
+	if s := c.Query("tailLines"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tailLines must be a positive number"})
+			return
+		}
+		tailLines = n
+	}
+
+	ctx := c.Request.Context()
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{LabelSelector: "sandbox=devc-" + name})
+	if err != nil {
+		log.Printf("Failed to list pods of sandbox %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sandbox pods"})
+		return
+	}
+	pod := runningPod(pods.Items)
+	if pod == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sandbox has no running pod, it may be scaled down"})
+		return
+	}
+
+	// The run log is streamed by a container of its own, so that the API
+	// doesn't exec in the sandbox
+	container := c.DefaultQuery("container", sandboxContainer)
+	if source == "workspace" {
+		container = runLogContainer
+	}
+	if !hasContainer(pod, container) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("pod %s has no container %s", pod.Name, container)})
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	out := flushWriter{w: c.Writer}
+
+	stream, err := kubeClient.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    follow,
+		TailLines: &tailLines,
+	}).Stream(ctx)
+	if err != nil {
+		log.Printf("Failed to get logs of pod %s: %v", pod.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get logs: %v", err)})
+		return
+	}
+	defer stream.Close()
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(out, stream); err != nil && ctx.Err() == nil {
+		log.Printf("Failed to stream logs of pod %s: %v", pod.Name, err)
+	}
+}
+
+// runningPod returns a running pod that is not being deleted, e.g. by a
+// rerun, or nil.
+func runningPod(pods []corev1.Pod) *corev1.Pod {
+	for i := range pods {
+		if pods[i].Status.Phase == corev1.PodRunning && pods[i].DeletionTimestamp == nil {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			if container.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
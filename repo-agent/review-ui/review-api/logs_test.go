@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStreamSandboxLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := v1.Now()
+	pod := func(name string, phase corev1.PodPhase, deleted bool, containers ...string) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"sandbox": "devc-repo-pr-1"}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+		if deleted {
+			p.DeletionTimestamp = &now
+			p.Finalizers = []string{"test"}
+		}
+		for _, c := range containers {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: c})
+		}
+		return p
+	}
+	oldClient := kubeClient
+	defer func() { kubeClient = oldClient }()
+	sandbox := &unstructured.Unstructured{}
+	sandbox.SetName("repo-pr-1")
+	sandbox.SetNamespace("default")
+
+	for _, tt := range []struct {
+		name  string
+		query string
+		pods  []*corev1.Pod
+		want  int
+	}{
+		{name: "container", pods: []*corev1.Pod{pod("old", corev1.PodRunning, true, "review-sandbox"), pod("new", corev1.PodRunning, false, "review-sandbox")}, want: http.StatusOK},
+		{name: "workspace", query: "?source=workspace", pods: []*corev1.Pod{pod("new", corev1.PodRunning, false, "review-sandbox", runLogContainer)}, want: http.StatusOK},
+		{name: "workspace of a pod without run-log", query: "?source=workspace", pods: []*corev1.Pod{pod("new", corev1.PodRunning, false, "review-sandbox")}, want: http.StatusBadRequest},
+		{name: "pending", pods: []*corev1.Pod{pod("new", corev1.PodPending, false, "review-sandbox")}, want: http.StatusNotFound},
+		{name: "invalid source", query: "?source=exec", want: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			for _, p := range tt.pods {
+				if err := client.Tracker().Add(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			kubeClient = client
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/logs"+tt.query, nil)
+			streamSandboxLogs(c, sandbox, "review-sandbox")
+			if w.Code != tt.want {
+				t.Errorf("streamSandboxLogs() status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			for _, action := range client.Actions() {
+				if action.GetSubresource() == "exec" {
+					t.Errorf("streamSandboxLogs() execs in the sandbox")
+				}
+			}
+		})
+	}
+}
@@ -4,33 +4,46 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	redis "github.com/go-redis/redis/v8"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/secretmanager"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	//"github.com/google/go-github/github"
 	"github.com/google/go-github/v39/github"
 	yaml "go.yaml.in/yaml/v3"
-	"golang.org/x/oauth2"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
-	rdb       *redis.Client
+	store     kvStore
 	k8sClient dynamic.Interface
+	k8sCache  *resourceCache
+	// tracer traces the posting of reviews as part of the trace of their
+	// sandbox, started by the repowatch controller
+	tracer *tracing.Tracer
 )
 
 // AgentOutput defines the structure for the agent's YAML output.
@@ -49,6 +62,11 @@ type PR struct {
 	Review         string `json:"review,omitempty"`
 	HTMLURL        string `json:"htmlURL,omitempty"`
 	DiffURL        string `json:"diffURL,omitempty"`
+	// PendingApproval is the submitted review waiting for approval
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"`
+	// RequestedReviewers are the users and org/teams whose review was
+	// requested once the review was posted
+	RequestedReviewers []string `json:"requestedReviewers,omitempty"`
 }
 
 // Issue represents a GitHub issue
@@ -61,7 +79,12 @@ type Issue struct {
 	Comment        string `json:"comment,omitempty"`
 	HTMLURL        string `json:"htmlURL,omitempty"`
 	BranchURL      string `json:"branchURL,omitempty"`
+	PullRequestURL string `json:"pullRequestURL,omitempty"`
 	PushBranch     bool   `json:"pushBranch"`
+	Phase          string `json:"phase,omitempty"`
+	Heartbeat      string `json:"heartbeat,omitempty"`
+	// PendingApproval is the submitted comment waiting for approval
+	PendingApproval *PendingApproval `json:"pendingApproval,omitempty"`
 }
 
 // Repo represents a repository with its configuration
@@ -71,6 +94,8 @@ type Repo struct {
 	URL           string         `json:"url"`
 	Review        *ReviewConfig  `json:"review,omitempty"`
 	IssueHandlers []IssueHandler `json:"issueHandlers,omitempty"`
+	// Role of the user on the repo
+	Role string `json:"role"`
 }
 
 // ReviewConfig holds configuration for PR reviews
@@ -85,6 +110,39 @@ type IssueHandler struct {
 	PushBranch         bool   `json:"pushBranch"`
 }
 
+// apiError is an error handlers respond with.
+type apiError struct {
+	status  int
+	message string
+	// err is returned as details if set
+	err error
+}
+
+func (e *apiError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %v", e.message, e.err)
+	}
+	return e.message
+}
+
+// respondError responds with err, as a 500 unless it is an *apiError.
+func respondError(c *gin.Context, err error) {
+	var ae *apiError
+	if !errors.As(err, &ae) {
+		slog.ErrorContext(c.Request.Context(), "request failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ae.status >= http.StatusInternalServerError {
+		slog.ErrorContext(c.Request.Context(), ae.message, "error", ae.err)
+	}
+	if ae.err != nil {
+		c.JSON(ae.status, gin.H{"error": ae.message, "details": ae.err.Error()})
+		return
+	}
+	c.JSON(ae.status, gin.H{"error": ae.message})
+}
+
 type bodyLogWriter struct {
 	gin.ResponseWriter
 	body *bytes.Buffer
@@ -95,47 +153,103 @@ func (w bodyLogWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// requestIDHeader carries the ID of a request. It is generated unless the
+// request already has one, e.g. set by a load balancer.
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID adds the ID of the request to its context, so that the records
+// logged while serving it carry it, and to its response.
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			var err error
+			if id, err = randomHex(8); err != nil {
+				id = ""
+			}
+		}
+		if id != "" {
+			c.Header(requestIDHeader, id)
+			c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		}
+		c.Next()
+	}
+}
+
+// RequestLoggerMiddleware logs the requests, with their body at the debug
+// level.
 func RequestLoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Read the request body
 		var bodyBytes []byte
 		if c.Request.Body != nil {
-			bodyBytes, _ = io.ReadAll(c.Request.Body)
+			var err error
+			bodyBytes, err = io.ReadAll(c.Request.Body)
+			if isBodyTooLarge(err) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+				return
+			}
 			// Restore the io.ReadCloser to its original state for subsequent handlers
 			c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 		}
 
-		log.Printf("Request Method: %s\n", c.Request.Method)
-		log.Printf("Request URL: %s\n", c.Request.URL.String())
-		//log.Printf("Request Headers: %v\n", c.Request.Header)
-		log.Printf("Request Body: %s\n", string(bodyBytes))
+		ctx := c.Request.Context()
+		slog.InfoContext(ctx, "request", "method", c.Request.Method, "url", c.Request.URL.String())
+		if slog.Default().Enabled(ctx, slog.LevelDebug) {
+			body := string(bodyBytes)
+			// Credentials are never logged
+			if strings.HasPrefix(c.Request.URL.Path, "/api/settings/") {
+				body = logging.Redacted
+			}
+			slog.DebugContext(ctx, "request body", "body", body)
+		}
 
 		c.Next() // Process the request further
 	}
 }
 
+// ResponseLoggerMiddleware logs the status and latency of the responses,
+// with their headers and body at the debug level.
 func ResponseLoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		blw := &bodyLogWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
-		c.Writer = blw
+		start := time.Now()
+		ctx := c.Request.Context()
+		// Streamed responses never end, don't buffer them
+		streamed := c.Request.URL.Path == "/api/stream" || strings.HasSuffix(c.Request.URL.Path, "/logs")
+		debug := slog.Default().Enabled(ctx, slog.LevelDebug)
+		var blw *bodyLogWriter
+		if debug && !streamed {
+			blw = &bodyLogWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+			c.Writer = blw
+		}
 
 		c.Next() // Process the request and generate the response
 
-		log.Printf("Response Status: %d\n", c.Writer.Status())
-		log.Printf("Response Headers: %v\n", c.Writer.Header())
-		log.Printf("Response Body: %s\n", blw.body.String())
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "response", "method", c.Request.Method, "path", c.Request.URL.Path,
+			"status", c.Writer.Status(), "latency", time.Since(start).String())
+		if blw != nil {
+			// Set-Cookie holds the session
+			headers := c.Writer.Header().Clone()
+			if headers.Get("Set-Cookie") != "" {
+				headers.Set("Set-Cookie", logging.Redacted)
+			}
+			slog.DebugContext(ctx, "response body", "headers", fmt.Sprint(headers), "body", blw.body.String())
+		}
 	}
 }
 
 func main() {
-	// Redis client
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		printOpenAPISpec()
+		return
 	}
-	rdb = redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
+	logging.Setup("review-api", os.Stderr)
 
 	// Kubernetes client
 	config, err := rest.InClusterConfig()
@@ -154,54 +268,84 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create kubernetes client: %v", err)
 	}
-
-	// Ping redis to ensure connection
-	_, err = rdb.Ping(context.Background()).Result()
+	kubeClient, err = kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Failed to create kubernetes clientset: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed-demo" {
+		runSeedDemo(os.Args[2:])
+		return
+	}
+	k8sCache, err = newResourceCache(context.Background(), k8sClient)
+	if err != nil {
+		log.Fatalf("Failed to start kubernetes cache: %v", err)
+	}
+	if err := publishChanges(k8sCache); err != nil {
+		log.Fatalf("Failed to watch for changes: %v", err)
+	}
+
+	// Drafts and reviews are kept in Redis if configured
+	store = newStoreFromEnv()
+
+	if err := notifyChanges(k8sCache); err != nil {
+		log.Fatalf("Failed to watch for notifications: %v", err)
+	}
+
+	// Fake drafts must not show up for real tenants
+	if demoMode {
+		populateMockData()
 	}
 
-	// Pre-populate mock data in Redis
-	populateMockData()
+	initSessionSecret()
+	if err := validateIDEDomain(); err != nil {
+		log.Fatal(err)
+	}
+	initOAuth()
+	secretKMS = envelope.FromEnv()
+	secretManager = secretmanager.New()
+	tracer = tracing.FromEnv("review-api")
+	go runCredentialRotation(context.Background(), time.Duration(envInt("CREDENTIAL_ROTATION_INTERVAL_SECONDS", 300))*time.Second)
 
-	// Gin router
-	router := gin.Default()
+	// LISTEN_ADDR is set by the e2e tests, which run the API out of cluster
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	err = newRouter().Run(addr)
+	if err != nil {
+		log.Fatalf("Failed to start router: %v", err)
+	}
+}
+
+// newRouter returns the router of the API, probes and metrics.
+func newRouter() *gin.Engine {
+	// gin's logger is replaced by the redacted ones below
+	router := gin.New()
+	router.Use(gin.Recovery(), requestID())
+
+	router.Use(metricsMiddleware())
+	// The hosts of the IDEs are served by the sandboxes, never by the API
+	router.Use(ideHosts())
+	// Registered before the loggers so that probes and scrapes don't flood
+	// the logs
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
 
 	// Add middleware to log requests and responses
+	router.Use(limitBody())
 	router.Use(RequestLoggerMiddleware())
 	router.Use(ResponseLoggerMiddleware())
 
 	// API routes
 	api := router.Group("/api")
-	{
-		api.GET("/repos", getRepos)
-		api.GET("/repo/:namespace/:repo/prs", getPRs)
-		api.POST("/repo/:namespace/:repo/prs/:id/draft", saveDraft)
-		api.POST("/repo/:namespace/:repo/prs/:id/submitreview", submitReview)
-		api.DELETE("/repo/:namespace/:repo/prs/:id", deletePR)
-		api.GET("/repo/:namespace/:repo/issues/:handler", getIssues)
-		api.POST("/repo/:namespace/:repo/issues/:issue_id/handler/:handler/draft", saveIssueDraft)
-		api.POST("/repo/:namespace/:repo/issues/:issue_id/handler/:handler/submitcomment", submitIssueComment)
-		api.DELETE("/repo/:namespace/:repo/issues/:issue_id/handler/:handler", deleteIssue)
-		api.POST("/repowatch", createRepoWatch)
-		api.PUT("/repowatch/:namespace/:name", updateRepoWatch)
-		api.DELETE("/repowatch/:namespace/:name", deleteRepoWatch)
-		api.GET("/proxy", proxy)
-	}
-
-	err = router.Run(":8080")
-	if err != nil {
-		log.Fatalf("Failed to start router: %v", err)
-	}
+	api.Use(rateLimit(), csrfProtect())
+	registerAPIRoutes(api)
+	return router
 }
 
 func createRepoWatch(c *gin.Context) {
-	// TODO: Improve this - Prompts, Issue handlers, etc.
-	var payload struct {
-		Name      string `json:"name"`
-		Namespace string `json:"namespace"`
-		RepoURL   string `json:"repoURL"`
-	}
+	var payload RepoWatchPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -215,11 +359,38 @@ func createRepoWatch(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name and repoURL are required"})
 		return
 	}
-
-	gvr := schema.GroupVersionResource{
-		Group:    "review.gemini.google.com",
-		Version:  "v1alpha1",
-		Resource: "repowatches",
+	if !authorize(c, payload.Namespace, "", roleAdmin) {
+		return
+	}
+	c.Set(auditNamespaceKey, payload.Namespace)
+	c.Set(auditRepoKey, payload.Name)
+	if payload.PollIntervalSeconds == 0 {
+		payload.PollIntervalSeconds = defaultPollIntervalSeconds
+	}
+	// Without handlers, default to reviewing PRs
+	if payload.Review == nil && len(payload.IssueHandlers) == 0 {
+		payload.Review = &ReviewPayload{
+			MaxActiveSandboxes:    3,
+			DevcontainerConfigRef: defaultDevcontainerRef,
+			LLM: LLMPayload{
+				APIKeySecretRef: defaultAPIKeySecretRef,
+				Prompt:          defaultReviewPrompt,
+			},
+		}
+	}
+	if err := payload.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Review != nil {
+		if err := payload.Review.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := validateIssueHandlers(payload.IssueHandlers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	repoWatch := &unstructured.Unstructured{
@@ -232,22 +403,30 @@ func createRepoWatch(c *gin.Context) {
 			},
 			"spec": map[string]interface{}{
 				"repoURL":             payload.RepoURL,
-				"githubSecretName":    "github-pat",
-				"pollIntervalSeconds": 300,
-				"review": map[string]interface{}{
-					"maxActiveSandboxes":    3,
-					"devcontainerConfigRef": "go-devcontainer-json",
-					"llm": map[string]interface{}{
-						"provider":        "gemini-cli",
-						"apiKeySecretRef": "gemini-vscode-tokens",
-						"prompt":          "You are an expert code reviewer. Review the following pull request.",
-					},
-				},
+				"githubSecretName":    defaultGithubSecretName,
+				"pollIntervalSeconds": payload.PollIntervalSeconds,
 			},
 		},
 	}
+	if payload.Review != nil {
+		if err := payload.Review.apply(repoWatch); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if len(payload.IssueHandlers) > 0 {
+		if err := setIssueHandlers(repoWatch, payload.IssueHandlers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := checkRepoWatchQuota(c.Request.Context(), repoWatch); err != nil {
+		c.JSON(quotaStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	_, err := k8sClient.Resource(gvr).Namespace(payload.Namespace).Create(c.Request.Context(), repoWatch, v1.CreateOptions{})
+	_, err := k8sClient.Resource(repoWatchGVR).Namespace(payload.Namespace).Create(c.Request.Context(), repoWatch, v1.CreateOptions{})
 	if err != nil {
 		log.Printf("Failed to create RepoWatch: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create RepoWatch: %v", err)})
@@ -261,9 +440,7 @@ func updateRepoWatch(c *gin.Context) {
 	namespace := c.Param("namespace")
 	name := c.Param("name")
 
-	var payload struct {
-		RepoURL string `json:"repoURL"`
-	}
+	var payload RepoWatchPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -273,42 +450,25 @@ func updateRepoWatch(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "repoURL is required"})
 		return
 	}
-
-	gvr := schema.GroupVersionResource{
-		Group:    "review.gemini.google.com",
-		Version:  "v1alpha1",
-		Resource: "repowatches",
-	}
-
-	// Get existing resource
-	existing, err := k8sClient.Resource(gvr).Namespace(namespace).Get(c.Request.Context(), name, v1.GetOptions{})
-	if err != nil {
-		log.Printf("Failed to get RepoWatch for update: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get RepoWatch: %v", err)})
-		return
-	}
-
-	// Update spec.repoURL
-	if err := unstructured.SetNestedField(existing.Object, payload.RepoURL, "spec", "repoURL"); err != nil {
-		log.Printf("Failed to set repoURL: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update object structure"})
+	if err := payload.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Apply update
-	_, err = k8sClient.Resource(gvr).Namespace(namespace).Update(c.Request.Context(), existing, v1.UpdateOptions{})
+	err := modifyRepoWatch(c.Request.Context(), namespace, name, func(existing *unstructured.Unstructured) error {
+		if err := unstructured.SetNestedField(existing.Object, payload.RepoURL, "spec", "repoURL"); err != nil {
+			return err
+		}
+		if payload.PollIntervalSeconds != 0 {
+			return unstructured.SetNestedField(existing.Object, payload.PollIntervalSeconds, "spec", "pollIntervalSeconds")
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Failed to update RepoWatch: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to update RepoWatch: %v", err)})
+		respondModifyError(c, err)
 		return
 	}
 
-	// Update Redis cache to reflect the change immediately
-	if err := rdb.HSet(c.Request.Context(), fmt.Sprintf("repo:%s", name), "url", payload.RepoURL).Err(); err != nil {
-		log.Printf("Failed to update repo URL in Redis for %s: %v", name, err)
-		// We don't fail the request here as the K8s source of truth is updated
-	}
-
 	c.Status(http.StatusOK)
 }
 
@@ -316,28 +476,17 @@ func deleteRepoWatch(c *gin.Context) {
 	namespace := c.Param("namespace")
 	name := c.Param("name")
 
-	gvr := schema.GroupVersionResource{
-		Group:    "review.gemini.google.com",
-		Version:  "v1alpha1",
-		Resource: "repowatches",
-	}
-
-	err := k8sClient.Resource(gvr).Namespace(namespace).Delete(c.Request.Context(), name, v1.DeleteOptions{})
+	err := k8sClient.Resource(repoWatchGVR).Namespace(namespace).Delete(c.Request.Context(), name, v1.DeleteOptions{})
 	if err != nil {
 		log.Printf("Failed to delete RepoWatch: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete RepoWatch: %v", err)})
 		return
 	}
 
-	// Also delete from Redis
-	if err := rdb.Del(c.Request.Context(), fmt.Sprintf("repo:%s", name)).Err(); err != nil {
-		log.Printf("Failed to delete repo %s from Redis: %v", name, err)
-		// Don't fail the request if Redis fails, as K8s deletion is the source of truth
-	}
-
 	c.Status(http.StatusOK)
 }
 
+// populateMockData stores drafts and reviews of fake PRs, for DEMO_MODE.
 func populateMockData() {
 	ctx := context.Background()
 	mockRepos := []struct {
@@ -361,14 +510,14 @@ func populateMockData() {
 
 	for _, repo := range mockRepos {
 		// Store repo URL
-		if err := rdb.HSet(ctx, fmt.Sprintf("repo:%s", repo.Name), "url", repo.URL).Err(); err != nil {
+		if err := store.HSet(ctx, fmt.Sprintf("repo:%s", repo.Name), "url", repo.URL); err != nil {
 			log.Printf("Failed to set repo URL in Redis: %v", err)
 		}
 
 		// Store PRs for the repo
 		for _, pr := range mockPRs[repo.Name] {
 			prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo.Name, pr.ID)
-			if err := rdb.HSet(ctx, prKey, "title", pr.Title, "draft", pr.Draft, "sandbox", pr.Sandbox, "review", pr.Review).Err(); err != nil {
+			if err := store.HSet(ctx, prKey, "title", pr.Title, "draft", pr.Draft, "sandbox", pr.Sandbox, "review", pr.Review); err != nil {
 				log.Printf("Failed to set PR info in Redis: %v", err)
 			}
 		}
@@ -376,25 +525,12 @@ func populateMockData() {
 }
 
 func getRepos(c *gin.Context) {
-	fetchAndPopulateRepos(c.Request.Context())
-
 	repos := []Repo{}
-	iter := rdb.Scan(c.Request.Context(), 0, "repo:*", 0).Iterator()
-	for iter.Next(c.Request.Context()) {
-		key := iter.Val()
-		repoName := key[len("repo:"):]
-		namespace, err := rdb.HGet(c.Request.Context(), key, "namespace").Result()
-		if err != nil {
-			log.Printf("Failed to get namespace for repo %s from Redis: %v", repoName, err)
+	for _, repoWatch := range k8sCache.list(repoWatchGVR, "", labels.Everything()) {
+		role := userRole(c, repoWatch.GetNamespace(), repoWatch.GetName())
+		if role < roleViewer {
 			continue
 		}
-
-		repoWatch, err := getRepoWatch(c.Request.Context(), namespace, repoName)
-		if err != nil {
-			log.Printf("Failed to get RepoWatch %s/%s: %v", namespace, repoName, err)
-			continue
-		}
-
 		repoURL, found, err := unstructured.NestedString(repoWatch.Object, "spec", "repoURL")
 		if err != nil || !found {
 			log.Printf("repoURL not found in RepoWatch CR %s", repoWatch.GetName())
@@ -402,9 +538,10 @@ func getRepos(c *gin.Context) {
 		}
 
 		repo := Repo{
-			Name:      repoName,
-			Namespace: namespace,
+			Name:      repoWatch.GetName(),
+			Namespace: repoWatch.GetNamespace(),
 			URL:       repoURL,
+			Role:      role.String(),
 		}
 
 		// Extract review config
@@ -422,7 +559,7 @@ func getRepos(c *gin.Context) {
 				}
 				name, _ := handlerMap["name"].(string)
 				maxActiveSandboxes, _ := handlerMap["maxActiveSandboxes"].(int64)
-				pushBranch, _ := handlerMap["pushBranch"].(bool)
+				pushBranch, _ := handlerMap["pushEnabled"].(bool)
 
 				if maxActiveSandboxes > 0 {
 					issueHandlers = append(issueHandlers, IssueHandler{
@@ -437,195 +574,110 @@ func getRepos(c *gin.Context) {
 
 		repos = append(repos, repo)
 	}
-	if err := iter.Err(); err != nil {
-		log.Printf("Error during Redis SCAN: %v", err)
-	}
 
 	c.JSON(http.StatusOK, repos)
 }
 
-func fetchAndPopulateRepos(ctx context.Context) {
-	gvr := schema.GroupVersionResource{
-		Group:    "review.gemini.google.com",
-		Version:  "v1alpha1",
-		Resource: "repowatches",
-	}
-	list, err := k8sClient.Resource(gvr).List(context.Background(), v1.ListOptions{})
-	if err != nil {
-		log.Printf("Failed to list RepoWatch CRs: %v. Serving mock data.", err)
-		return
-	}
-
-	for _, item := range list.Items {
-		repoURL, found, err := unstructured.NestedString(item.Object, "spec", "repoURL")
-		if err != nil || !found {
-			log.Printf("repoURL not found in RepoWatch CR %s", item.GetName())
-			continue
-		}
-		repo := struct {
-			Name      string
-			Namespace string
-			URL       string
-		}{
-			Name:      item.GetName(),
-			Namespace: item.GetNamespace(),
-			URL:       repoURL,
-		}
-
-		// Ensure the URL is in Redis
-		if err := rdb.HSet(ctx, fmt.Sprintf("repo:%s", repo.Name), "url", repo.URL, "namespace", repo.Namespace).Err(); err != nil {
-			log.Printf("Failed to cache repo URL for %s: %v", repo.Name, err)
-		}
-	}
-}
-
 func getPRs(c *gin.Context) {
 	namespace := c.Param("namespace")
 	repo := c.Param("repo")
-	fetchAndPopulatePRs(c.Request.Context(), namespace, repo)
-	// SCAN Redis for PRs for repo
+
+	sandboxes := k8sCache.repoSandboxes(reviewSandboxGVR, namespace, repo, "")
+	log.Printf("Found %d reviewsandboxes for Repo: %s", len(sandboxes), repo)
 	prs := []PR{}
-	repoPRKeyPrefix := fmt.Sprintf("pr:repo:%s:pr:", repo)
-	iter := rdb.Scan(c.Request.Context(), 0, repoPRKeyPrefix+"*", 0).Iterator()
-	for iter.Next(c.Request.Context()) {
-		key := iter.Val()
-		prID := key[len(repoPRKeyPrefix):]
-		prData, err := rdb.HGetAll(c.Request.Context(), key).Result()
-		if err != nil {
-			log.Printf("Failed to get PR %s from Redis for repo %s: %v", prID, repo, err)
+	for _, item := range sandboxes {
+		pr, ok := prFromSandbox(item)
+		if !ok {
 			continue
 		}
-		pr := PR{
-			ID:    prID,
-			Title: prData["title"],
-		}
 
-		if _, ok := prData["htmlurl"]; ok {
-			pr.HTMLURL = prData["htmlurl"]
-		}
-		if _, ok := prData["diffurl"]; ok {
-			pr.DiffURL = prData["diffurl"]
-		}
-		if _, ok := prData["draft"]; ok {
-			pr.Draft = prData["draft"]
-		}
-		if _, ok := prData["sandbox"]; ok {
-			pr.Sandbox = prData["sandbox"]
-		}
-		if _, ok := prData["sandboxReplica"]; ok {
-			pr.SandboxReplica = prData["sandboxReplica"]
-		}
-		if _, ok := prData["review"]; ok {
-			pr.Review = prData["review"]
-		}
+		overlayPRState(c.Request.Context(), repo, &pr)
 		prs = append(prs, pr)
 	}
-	if err := iter.Err(); err != nil {
-		log.Printf("Error during Redis SCAN: %v", err)
-	}
 
 	c.JSON(http.StatusOK, prs)
 }
 
-func fetchAndPopulatePRs(ctx context.Context, namespace, repo string) {
-	gvr := schema.GroupVersionResource{
-		Group:    "custom.agents.x-k8s.io",
-		Version:  "v1alpha1",
-		Resource: "reviewsandboxes",
-	}
-	list, err := k8sClient.Resource(gvr).Namespace(namespace).List(context.Background(),
-		v1.ListOptions{
-			LabelSelector: fmt.Sprintf("review.gemini.google.com/repowatch=%s", repo),
-		})
+// overlayPRState sets the user's draft and submitted review of a PR.
+func overlayPRState(ctx context.Context, repo string, pr *PR) {
+	prData, err := store.HGetAll(ctx, prKey(repo, pr.ID))
 	if err != nil {
-		log.Printf("Failed to list ReviewSandbox CRs: %v. Serving mock data.", err)
-		return
+		log.Printf("Failed to get PR %s for repo %s: %v", pr.ID, repo, err)
 	}
+	if draft, ok := prData["draft"]; ok {
+		pr.Draft = draft
+	}
+	pr.Review = prData["review"]
+	pr.PendingApproval = getPendingApproval(prData)
+	if reviewers := prData["requestedReviewers"]; reviewers != "" {
+		pr.RequestedReviewers = strings.Split(reviewers, ",")
+	}
+}
 
-	log.Printf("Populating PRs: Found %d reviewsandboxes for Repo: %s", len(list.Items), repo)
-	for _, item := range list.Items {
-		log.Printf("Creating PR entry for ReviewSandbox: %s/%s", item.GetNamespace(), item.GetName())
-		// Get replicas and if it scaled down skip
-		replicas, found, err := unstructured.NestedInt64(item.Object, "spec", "replicas")
-		if err != nil || !found {
-			log.Printf("Replicas (.spec.replicas) not found in ReviewSandbox  %s", item.GetName())
-			continue
-		}
-
-		prID, found, err := unstructured.NestedString(item.Object, "spec", "source", "pr")
-		if err != nil || !found {
-			log.Printf("PR ID (.spec.source.pr) not found in ReviewSandbox  %s", item.GetName())
-			continue
-		}
-
-		title, found, err := unstructured.NestedString(item.Object, "spec", "source", "title")
-		if err != nil || !found {
-			log.Printf("Title (.spec.source.title) not found in ReviewSandbox  %s", item.GetName())
-			continue
-		}
-		htmlurl, found, err := unstructured.NestedString(item.Object, "spec", "source", "htmlURL")
-		if err != nil || !found {
-			log.Printf("Title (.spec.source.htmlURL) not found in ReviewSandbox  %s", item.GetName())
-		}
-		diffurl, found, err := unstructured.NestedString(item.Object, "spec", "source", "diffURL")
-		if err != nil || !found {
-			log.Printf("diffURL (.spec.source.diffURL) not found in ReviewSandbox  %s", item.GetName())
-		}
+// prKey is the store key of the user state of a PR.
+func prKey(repo, prID string) string {
+	return fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
+}
 
-		// get draft from annotation[agentDraft]
-		draft := ""
-		annotations := item.GetAnnotations()
-		if annotations == nil {
-			log.Printf("agentDraft (annotations=nil) not found in ReviewSandbox %s", item.GetName())
-		} else if _, ok := annotations["agentDraft"]; !ok {
-			log.Printf("agentDraft (annotations[agentDraft]) not found in ReviewSandbox %s", item.GetName())
-		} else {
-			draft = annotations["agentDraft"]
-		}
+// prFromSandbox builds a PR from its ReviewSandbox. The draft is the agent's
+// draft.
+func prFromSandbox(item *unstructured.Unstructured) (PR, bool) {
+	// Get replicas and if it scaled down skip
+	replicas, found, err := unstructured.NestedInt64(item.Object, "spec", "replicas")
+	if err != nil || !found {
+		log.Printf("Replicas (.spec.replicas) not found in ReviewSandbox  %s", item.GetName())
+		return PR{}, false
+	}
 
-		pr := PR{
-			ID:             prID,
-			Title:          title,
-			Sandbox:        item.GetName(),
-			HTMLURL:        htmlurl,
-			DiffURL:        diffurl,
-			SandboxReplica: fmt.Sprintf("%d", replicas),
-		}
+	prID, found, err := unstructured.NestedString(item.Object, "spec", "source", "pr")
+	if err != nil || !found {
+		log.Printf("PR ID (.spec.source.pr) not found in ReviewSandbox  %s", item.GetName())
+		return PR{}, false
+	}
 
-		prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
-		// Ensure the URL is in Redis
-		if err := rdb.HSet(ctx, prKey,
-			"title", pr.Title,
-			"sandbox", pr.Sandbox,
-			"htmlurl", pr.HTMLURL,
-			"diffurl", pr.DiffURL,
-			"sandboxReplica", pr.SandboxReplica,
-			"draft", draft,
-			"agentDraft", draft,
-		).Err(); err != nil {
-			log.Printf("Failed to cache PR %s for repo %s: %v", pr.ID, repo, err)
-		}
+	title, found, err := unstructured.NestedString(item.Object, "spec", "source", "title")
+	if err != nil || !found {
+		log.Printf("Title (.spec.source.title) not found in ReviewSandbox  %s", item.GetName())
+		return PR{}, false
+	}
+	htmlurl, found, err := unstructured.NestedString(item.Object, "spec", "source", "htmlURL")
+	if err != nil || !found {
+		log.Printf("Title (.spec.source.htmlURL) not found in ReviewSandbox  %s", item.GetName())
 	}
+	diffurl, found, err := unstructured.NestedString(item.Object, "spec", "source", "diffURL")
+	if err != nil || !found {
+		log.Printf("diffURL (.spec.source.diffURL) not found in ReviewSandbox  %s", item.GetName())
+	}
+
+	return PR{
+		ID:             prID,
+		Title:          title,
+		Draft:          item.GetAnnotations()["agentDraft"],
+		Sandbox:        item.GetName(),
+		HTMLURL:        htmlurl,
+		DiffURL:        diffurl,
+		SandboxReplica: fmt.Sprintf("%d", replicas),
+	}, true
 }
 
 func saveDraft(c *gin.Context) {
-	//namespace := c.Param("namespace")
+	namespace := c.Param("namespace")
 	repo := c.Param("repo")
 	prID := c.Param("id")
-	var payload struct {
-		Draft string
-	}
+	var payload draftPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
-	err := rdb.HSet(c.Request.Context(), prKey, "draft", payload.Draft).Err()
+	// Keep the agent draft the user started from in the history
+	recordAgentDraft(c.Request.Context(), prKey(repo, prID), prAgentDraft(namespace, repo, prID))
+	err := store.HSet(c.Request.Context(), prKey(repo, prID), "draft", payload.Draft)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
 		return
 	}
+	recordUserDraft(c, prKey(repo, prID), payload.Draft)
 
 	c.Status(http.StatusOK)
 }
@@ -634,35 +686,46 @@ func submitReview(c *gin.Context) {
 	namespace := c.Param("namespace")
 	repo := c.Param("repo")
 	prID := c.Param("id")
-	var payload struct {
-		Review string
-	}
+	var payload reviewSubmission
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx := c.Request.Context()
-	log.Printf("Submitting review for PR %s in repo %s with review: %s", prID, repo, payload.Review)
-
-	// Get draft and agentDraft from Redis
-	prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
-	prData, err := rdb.HGetAll(ctx, prKey).Result()
-	if err != nil {
-		log.Printf("Failed to get PR %s from Redis for repo %s: %v", prID, repo, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get PR data from Redis"})
+	if approvalRequired {
+		requestApproval(c, prKey(repo, prID), payload.Review)
 		return
 	}
+	if err := postReview(c, namespace, repo, prID, payload.Review); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
 
-	draft := payload.Review
-	agentDraft := prData["agentDraft"]
+// postReview posts review to a PR on GitHub, saves it and scales down the
+// sandbox. It returns an *apiError on failure.
+func postReview(c *gin.Context, namespace, repo, prID, review string) (err error) {
+	ctx := c.Request.Context()
+	log.Printf("Submitting review for PR %s in repo %s with review: %s", prID, repo, review)
+
+	draft := review
+	var agentDraft, sandboxName, traceparent string
+	if sandbox := k8sCache.findSandbox(reviewSandboxGVR, namespace, repo, "", "pr", prID); sandbox != nil {
+		agentDraft = sandbox.GetAnnotations()["agentDraft"]
+		sandboxName = sandbox.GetName()
+		traceparent, _, _ = unstructured.NestedString(sandbox.Object, "spec", "tracing", "traceparent")
+	}
+	ctx, span := tracer.Start(tracing.ContextWithTraceparent(ctx, traceparent), "review-api.postReview", tracing.KindInternal)
+	span.SetAttribute("repo", repo)
+	span.SetAttribute("pr", prID)
+	defer func() { span.End(err) }()
 
 	// Get RepoWatch to get repoURL and secret ref
 	repoWatch, err := getRepoWatch(ctx, namespace, repo)
 	if err != nil {
 		log.Printf("Failed to get repowatch %s: %v", repo, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get repowatch config"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to get repowatch config"}
 	}
 
 	if draft != agentDraft {
@@ -673,68 +736,53 @@ func submitReview(c *gin.Context) {
 		owner, _, _ := parseRepoURL(repoURL)
 
 		hfKey := fmt.Sprintf("hf:review:githubuser:%s:repo:%s:pr:%s", owner, repo, prID)
-		if err := rdb.HSet(ctx, hfKey,
+		if err := store.HSet(ctx, hfKey,
 			"draft", draft,
 			"agentDraft", agentDraft,
 			"prompt", prompt,
 			"configdir", configdir,
-		).Err(); err != nil {
+			"namespace", namespace,
+			"submittedBy", requestUser(c),
+			"submittedAt", time.Now().UTC().Format(time.RFC3339),
+		); err != nil {
 			log.Printf("Failed to store feedback for PR %s in repo %s: %v", prID, repo, err)
 			// Continue without failing the review submission
 		}
 
 		// Update the userDraft in the ReviewSandbox status
-		if err := updateReviewSandboxUserDraft(ctx, namespace, prData["sandbox"], draft); err != nil {
+		if err := updateReviewSandboxUserDraft(ctx, namespace, sandboxName, draft); err != nil {
 			log.Printf("Failed to update reviewsandbox userDraft for PR %s in repo %s: %v", prID, repo, err)
 			// Not failing the request for this, just logging.
 		}
 	}
 
-	// Get GitHub token from secret
-	token, err := getGitHubToken(ctx, repoWatch)
-	if err != nil {
-		log.Printf("Failed to get github token for repo %s: %v", repo, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get github token"})
-		return
-	}
-
-	// Create GitHub client
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
-
 	// Parse repo URL
 	repoURL, found, err := unstructured.NestedString(repoWatch.Object, "spec", "repoURL")
 	if err != nil || !found {
 		log.Printf("repoURL not found in RepoWatch CR %s", repoWatch.GetName())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "repoURL not found in RepoWatch CR"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "repoURL not found in RepoWatch CR"}
 	}
 	owner, repoName, err := parseRepoURL(repoURL)
 	if err != nil {
 		log.Printf("Failed to parse repo url %s: %v", repoURL, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse repo url"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to parse repo url"}
 	}
 
 	// Get PR number
 	prNumber, err := strconv.Atoi(prID)
 	if err != nil {
 		log.Printf("Failed to parse prID %s: %v", prID, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pr id"})
-		return
+		return &apiError{status: http.StatusBadRequest, message: "invalid pr id"}
 	}
 
 	// https://docs.github.com/en/rest/pulls/reviews?apiVersion=2022-11-28#create-a-review-for-a-pull-request
 	// Try Unmarshalling the yaml review payload into PullRequestReviewRequest
 	agentOutput := &AgentOutput{}
 	reviewRequest := &github.PullRequestReviewRequest{}
-	err = yaml.Unmarshal([]byte(payload.Review), &agentOutput)
+	err = yaml.Unmarshal([]byte(review), &agentOutput)
 	if err != nil {
 		log.Printf("Failed to unmarshal review payload: %v", err)
-		reviewRequest.Body = github.String(payload.Review)
+		reviewRequest.Body = github.String(review)
 	} else {
 		reviewRequest = agentOutput.Review
 	}
@@ -742,85 +790,115 @@ func submitReview(c *gin.Context) {
 	// Not setting event sets it as a draft
 	reviewRequest.Event = nil
 
+	// Retire the previous agent review first, GitHub allows a single pending
+	// review per user
+	if mode := supersedeMode(repoWatch); mode != "none" {
+		previous, _ := store.HGet(ctx, prKey(repo, prID), "reviewID")
+		if previousID, err := strconv.ParseInt(previous, 10, 64); err == nil {
+			if _, err := callGitHub(c, repoWatch, func(client *github.Client) (*github.Response, error) {
+				return supersedeReview(ctx, client, mode, owner, repoName, prNumber, previousID)
+			}); err != nil {
+				log.Printf("Failed to supersede review %d on PR %d: %v", previousID, prNumber, err)
+			}
+		}
+	}
+
 	log.Printf("reviewRequest being created: %v", reviewRequest)
-	review, resp, err := client.PullRequests.CreateReview(ctx, owner, repoName, prNumber, reviewRequest)
+	// Post as the logged in user if possible
+	var reviewID int64
+	postedBy, err := callGitHub(c, repoWatch, func(client *github.Client) (*github.Response, error) {
+		created, resp, err := client.PullRequests.CreateReview(ctx, owner, repoName, prNumber, reviewRequest)
+		if err == nil {
+			log.Printf("review created: %v", created)
+			reviewID = created.GetID()
+			span.SetAttribute("github.review_id", strconv.FormatInt(reviewID, 10))
+		}
+		return resp, err
+	})
 	if err != nil {
-		log.Printf("response: %v", resp)
 		log.Printf("Failed to create review on PR %d: %v", prNumber, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review on github"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to create review on github"}
 	}
-	log.Printf("review created: %v", review)
-	// Set review in Redis
-	err = rdb.HSet(c.Request.Context(), prKey, "review", payload.Review).Err()
+	// Save the review and clear the draft. The trace ID finds how the review
+	// was made, from the creation of its sandbox, and the review ID which one
+	// the next review supersedes.
+	err = store.HSet(c.Request.Context(), prKey(repo, prID), "review", review, "postedBy", postedBy, "traceID", span.SpanContext().TraceIDString(),
+		"reviewID", strconv.FormatInt(reviewID, 10))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review", "details": err.Error()})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to save review", err: err}
 	}
 
-	// Delete draft from Redis
-	err = rdb.HSet(c.Request.Context(), prKey, "draft", "").Err()
+	err = store.HSet(c.Request.Context(), prKey(repo, prID), "draft", "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear draft", "details": err.Error()})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to clear draft", err: err}
+	}
+
+	// The review is posted, failing to request human reviewers only logs
+	if cfg := getReviewersConfig(repoWatch); cfg.enabled() {
+		var reviewers []string
+		_, err := callGitHub(c, repoWatch, func(client *github.Client) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			reviewers, resp, err = requestReviewers(ctx, client, cfg, owner, repoName, prNumber)
+			return resp, err
+		})
+		if err != nil {
+			log.Printf("Failed to request reviewers on PR %d: %v", prNumber, err)
+		} else if len(reviewers) > 0 {
+			log.Printf("Requested reviews on PR %d from %v", prNumber, reviewers)
+			if err := store.HSet(ctx, prKey(repo, prID), "requestedReviewers", strings.Join(reviewers, ",")); err != nil {
+				log.Printf("Failed to save the requested reviewers of PR %s in repo %s: %v", prID, repo, err)
+			}
+		}
 	}
 
 	// scale down sandbox
 	err = scaledownSandbox(ctx, namespace, repo, prID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scaledown Sandbox after review submission", "details": err.Error()})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to scaledown Sandbox after review submission", err: err}
 	}
 
-	c.Status(http.StatusOK)
+	notifySubmitted(reviewSandboxGVR, namespace, repo, "", prID, submitter(c, postedBy))
+	return nil
 }
 
 func deletePR(c *gin.Context) {
 	namespace := c.Param("namespace")
 	repo := c.Param("repo")
 	prID := c.Param("id")
-	ctx := c.Request.Context()
-
-	if err := scaledownSandbox(ctx, namespace, repo, prID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sandbox", "details": err.Error()})
+	if err := discardPR(c.Request.Context(), namespace, repo, prID); err != nil {
+		respondError(c, err)
 		return
 	}
+	c.Status(http.StatusOK)
+}
 
-	// Clean up Redis keys
-	prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
-	if err := rdb.HDel(c.Request.Context(), prKey, "review", "draft", "sandbox", "htmlurl", "title").Err(); err != nil {
-		log.Printf("Failed to HDEL PR data from Redis: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to HDEL PR data from Redis"})
-		return
-	}
-	if err := rdb.Del(c.Request.Context(), prKey).Err(); err != nil {
-		log.Printf("Failed to DEL PR data from Redis: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to DEL PR data from Redis"})
-		return
+// discardPR scales down the sandbox of a PR and deletes its stored drafts. It
+// returns an *apiError on failure.
+func discardPR(ctx context.Context, namespace, repo, prID string) error {
+	if err := scaledownSandbox(ctx, namespace, repo, prID); err != nil {
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to delete sandbox", err: err}
 	}
 
-	c.Status(http.StatusOK)
+	// Clean up the user state
+	if err := store.Del(ctx, prKey(repo, prID), draftHistoryKey(prKey(repo, prID))); err != nil {
+		log.Printf("Failed to DEL PR data: %v", err)
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to DEL PR data"}
+	}
+	return nil
 }
 
 //nolint:unused
 func deleteSandbox(ctx context.Context, namespace, repo, prID string) error {
-	prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
-	sandboxName, err := rdb.HGet(ctx, prKey, "sandbox").Result()
-	if err == redis.Nil {
-		// If sandbox is not in Redis, we can assume it's already deleted or never existed.
-		log.Printf("Sandbox for repo %s, PR %s not found in Redis. Assuming it's already deleted.", repo, prID)
+	sandbox := k8sCache.findSandbox(reviewSandboxGVR, namespace, repo, "", "pr", prID)
+	if sandbox == nil {
+		// If the sandbox is not in the cache, we can assume it's already deleted or never existed.
+		log.Printf("Sandbox for repo %s, PR %s not found. Assuming it's already deleted.", repo, prID)
 		return nil
-	} else if err != nil {
-		return fmt.Errorf("failed to get sandbox name from Redis: %w", err)
 	}
 
-	gvr := schema.GroupVersionResource{
-		Group:    "custom.agents.x-k8s.io",
-		Version:  "v1alpha1",
-		Resource: "reviewsandboxes",
-	}
-	log.Printf("Deleting sandbox %s", sandboxName)
-	err = k8sClient.Resource(gvr).Namespace(namespace).Delete(ctx, sandboxName, v1.DeleteOptions{})
+	log.Printf("Deleting sandbox %s", sandbox.GetName())
+	err := k8sClient.Resource(reviewSandboxGVR).Namespace(namespace).Delete(ctx, sandbox.GetName(), v1.DeleteOptions{})
 	if err != nil {
 		// We can choose to not return an error if it's already gone.
 		return fmt.Errorf("failed to delete sandbox: %w", err)
@@ -829,22 +907,16 @@ func deleteSandbox(ctx context.Context, namespace, repo, prID string) error {
 }
 
 func scaledownSandbox(ctx context.Context, namespace, repo, prID string) error {
-	prKey := fmt.Sprintf("pr:repo:%s:pr:%s", repo, prID)
-	sandboxName, err := rdb.HGet(ctx, prKey, "sandbox").Result()
-	if err == redis.Nil {
-		// If sandbox is not in Redis, we can assume it's already deleted or never existed.
-		log.Printf("Sandbox for repo %s, PR %s not found in Redis. Assuming it's already deleted.", repo, prID)
+	var sandboxName string
+	if sandbox := k8sCache.findSandbox(reviewSandboxGVR, namespace, repo, "", "pr", prID); sandbox != nil {
+		sandboxName = sandbox.GetName()
+	} else {
+		// If the sandbox is not in the cache, we can assume it's already deleted or never existed.
+		log.Printf("Sandbox for repo %s, PR %s not found. Assuming it's already deleted.", repo, prID)
 		// For the demo, we'll construct the name to attempt deletion anyway.
 		sandboxName = fmt.Sprintf("%s-pr-%s", repo, prID)
-	} else if err != nil {
-		return fmt.Errorf("failed to get sandbox name from Redis: %w", err)
 	}
 
-	gvr := schema.GroupVersionResource{
-		Group:    "custom.agents.x-k8s.io",
-		Version:  "v1alpha1",
-		Resource: "reviewsandboxes",
-	}
 	log.Printf("Scaling down sandbox %s", sandboxName)
 
 	// Set .spec.replicas to 0 and apply the sandbox object
@@ -862,7 +934,7 @@ func scaledownSandbox(ctx context.Context, namespace, repo, prID string) error {
 		},
 	}
 
-	_, err = k8sClient.Resource(gvr).Namespace(namespace).Apply(ctx, sandboxName,
+	_, err := k8sClient.Resource(reviewSandboxGVR).Namespace(namespace).Apply(ctx, sandboxName,
 		sandbox, v1.ApplyOptions{FieldManager: "review-ui", Force: true})
 	if err != nil {
 		// We can choose to not return an error if it's already gone.
@@ -872,14 +944,8 @@ func scaledownSandbox(ctx context.Context, namespace, repo, prID string) error {
 }
 
 func updateReviewSandboxUserDraft(ctx context.Context, namespace, sandboxName, userDraft string) error {
-	gvr := schema.GroupVersionResource{
-		Group:    "custom.agents.x-k8s.io",
-		Version:  "v1alpha1",
-		Resource: "reviewsandboxes",
-	}
-
 	// Get the existing resource
-	sandbox, err := k8sClient.Resource(gvr).Namespace(namespace).Get(ctx, sandboxName, v1.GetOptions{})
+	sandbox, err := k8sClient.Resource(reviewSandboxGVR).Namespace(namespace).Get(ctx, sandboxName, v1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get reviewsandbox %s: %w", sandboxName, err)
 	}
@@ -892,7 +958,7 @@ func updateReviewSandboxUserDraft(ctx context.Context, namespace, sandboxName, u
 	annotations["userDraft"] = userDraft
 	sandbox.SetAnnotations(annotations)
 
-	_, err = k8sClient.Resource(gvr).Namespace(namespace).Update(context.TODO(), sandbox, v1.UpdateOptions{})
+	_, err = k8sClient.Resource(reviewSandboxGVR).Namespace(namespace).Update(context.TODO(), sandbox, v1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update reviewsandbox annotation: %w", err)
 	}
@@ -901,6 +967,17 @@ func updateReviewSandboxUserDraft(ctx context.Context, namespace, sandboxName, u
 }
 
 func getGitHubToken(ctx context.Context, repoWatch *unstructured.Unstructured) (string, error) {
+	if name, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "secretManager", "githubPAT"); name != "" {
+		if secretManager == nil {
+			return "", fmt.Errorf("secret manager is not configured to read %s", name)
+		}
+		token, err := secretManager.Access(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+
 	secretName, found, err := unstructured.NestedString(repoWatch.Object, "spec", "githubSecretName")
 	if err != nil || !found {
 		return "", fmt.Errorf("githubSecretName not found in repowatch %s", repoWatch.GetName())
@@ -927,6 +1004,10 @@ func getGitHubToken(ctx context.Context, repoWatch *unstructured.Unstructured) (
 	if err != nil {
 		return "", fmt.Errorf("failed to decode token for key %s in secret %s: %w", secretKey, secretName, err)
 	}
+	tokenBytes, err = envelope.Open(ctx, secretKMS, tokenBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token for key %s in secret %s: %w", secretKey, secretName, err)
+	}
 
 	return string(tokenBytes), nil
 }
@@ -943,188 +1024,152 @@ func parseRepoURL(repoURL string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
-func getRepoWatch(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
-	gvr := schema.GroupVersionResource{
-		Group:    "review.gemini.google.com",
-		Version:  "v1alpha1",
-		Resource: "repowatches",
-	}
-	repoWatch, err := k8sClient.Resource(gvr).Namespace(namespace).Get(ctx, name, v1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
-	return repoWatch, nil
+// getRepoWatch returns the cached RepoWatch. It must not be modified.
+func getRepoWatch(_ context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return k8sCache.get(repoWatchGVR, namespace, name)
 }
 
 func getIssues(c *gin.Context) {
 	namespace := c.Param("namespace")
 	repo := c.Param("repo")
 	handler := c.Param("handler")
-	fetchAndPopulateIssues(c.Request.Context(), namespace, repo, handler)
 
+	sandboxes := k8sCache.repoSandboxes(issueSandboxGVR, namespace, repo, handler)
+	log.Printf("Found %d issuesandboxes for Repo: %s Handler: %s", len(sandboxes), repo, handler)
 	issues := []Issue{}
-	issueKeyPrefix := fmt.Sprintf("issue:repo:%s:handler:%s:issue:*", repo, handler)
-	iter := rdb.Scan(c.Request.Context(), 0, issueKeyPrefix, 0).Iterator()
-	for iter.Next(c.Request.Context()) {
-		key := iter.Val()
-		// key is issue:repo:REPO:handler:HANDLER:issue:ISSUEID
-		parts := strings.Split(key, ":")
-		if len(parts) != 7 {
+	for _, item := range sandboxes {
+		issue, ok := issueFromSandbox(item)
+		if !ok {
 			continue
 		}
-		issueID := parts[6]
-
-		issueData, err := rdb.HGetAll(c.Request.Context(), key).Result()
-		if err != nil {
-			log.Printf("Failed to get Issue %s from Redis for repo %s handler %s: %v", issueID, repo, handler, err)
-			continue
-		}
-		pushBranch, _ := strconv.ParseBool(issueData["pushBranch"])
-		issue := Issue{
-			ID:         issueID,
-			Title:      issueData["title"],
-			PushBranch: pushBranch,
-		}
-
-		if val, ok := issueData["htmlurl"]; ok {
-			issue.HTMLURL = val
-		}
-		if val, ok := issueData["draft"]; ok {
-			issue.Draft = val
-		}
-		if val, ok := issueData["sandbox"]; ok {
-			issue.Sandbox = val
-		}
-		if val, ok := issueData["sandboxReplica"]; ok {
-			issue.SandboxReplica = val
-		}
-		if val, ok := issueData["comment"]; ok {
-			issue.Comment = val
-		}
-		if val, ok := issueData["branchURL"]; ok {
-			issue.BranchURL = val
-		}
 
+		overlayIssueState(c.Request.Context(), repo, handler, &issue)
 		issues = append(issues, issue)
 	}
-	if err := iter.Err(); err != nil {
-		log.Printf("Error during Redis SCAN for issues: %v", err)
-	}
 
 	c.JSON(http.StatusOK, issues)
 }
 
-func fetchAndPopulateIssues(ctx context.Context, namespace, repo, handler string) {
-	gvr := schema.GroupVersionResource{
-		Group:    "custom.agents.x-k8s.io",
-		Version:  "v1alpha1",
-		Resource: "issuesandboxes",
-	}
-	list, err := k8sClient.Resource(gvr).Namespace(namespace).List(context.Background(),
-		v1.ListOptions{
-			LabelSelector: fmt.Sprintf("review.gemini.google.com/repowatch=%s,review.gemini.google.com/handler=%s", repo, handler),
-		})
+// overlayIssueState sets the user's draft and submitted comment of an issue.
+func overlayIssueState(ctx context.Context, repo, handler string, issue *Issue) {
+	issueData, err := store.HGetAll(ctx, issueKey(repo, handler, issue.ID))
 	if err != nil {
-		log.Printf("Failed to list IssueSandbox CRs: %v.", err)
-		return
+		log.Printf("Failed to get Issue %s for repo %s handler %s: %v", issue.ID, repo, handler, err)
 	}
+	if draft, ok := issueData["draft"]; ok {
+		issue.Draft = draft
+	}
+	issue.Comment = issueData["comment"]
+	issue.PendingApproval = getPendingApproval(issueData)
+}
 
-	log.Printf("Populating Issues: Found %d issuesandboxes for Repo: %s Handler: %s", len(list.Items), repo, handler)
-	for _, item := range list.Items {
-		log.Printf("Creating Issue entry for IssueSandbox: %s/%s", item.GetNamespace(), item.GetName())
-		replicas, found, err := unstructured.NestedInt64(item.Object, "spec", "replicas")
-		if err != nil || !found {
-			log.Printf("Replicas (.spec.replicas) not found in IssueSandbox %s", item.GetName())
-			continue
-		}
+// issueKey is the store key of the user state of an issue.
+func issueKey(repo, handler, issueID string) string {
+	return fmt.Sprintf("issue:repo:%s:handler:%s:issue:%s", repo, handler, issueID)
+}
 
-		issueID, found, err := unstructured.NestedString(item.Object, "spec", "source", "issue")
-		if err != nil || !found {
-			log.Printf("Issue ID (.spec.source.issue) not found in IssueSandbox %s", item.GetName())
-			continue
-		}
+// issueFromSandbox builds an Issue from its IssueSandbox. The draft is the
+// agent's draft.
+func issueFromSandbox(item *unstructured.Unstructured) (Issue, bool) {
+	replicas, found, err := unstructured.NestedInt64(item.Object, "spec", "replicas")
+	if err != nil || !found {
+		log.Printf("Replicas (.spec.replicas) not found in IssueSandbox %s", item.GetName())
+		return Issue{}, false
+	}
 
-		title, found, err := unstructured.NestedString(item.Object, "spec", "source", "title")
-		if err != nil || !found {
-			log.Printf("Title (.spec.source.title) not found in IssueSandbox %s", item.GetName())
-			continue
-		}
-		htmlurl, found, err := unstructured.NestedString(item.Object, "spec", "source", "htmlURL")
-		if err != nil || !found {
-			log.Printf("htmlURL (.spec.source.htmlURL) not found in IssueSandbox %s", item.GetName())
-		}
+	issueID, found, err := unstructured.NestedString(item.Object, "spec", "source", "issue")
+	if err != nil || !found {
+		log.Printf("Issue ID (.spec.source.issue) not found in IssueSandbox %s", item.GetName())
+		return Issue{}, false
+	}
 
-		// https://github.com/barney-s/kro/tree/issue-753-bugfix
-		// https://github.com/ + .user.login + source.cloneURL repo name + /tree/ + .destination.branch
-		// https://github.com/kubernetes-sigs/kro/compare/main...barney-s:kro:issue-753-bugfix
-		// .source.cloneURL - .git + /compare/main... + .user.login + : + source.cloneURL repo name  + : + .destination.branch
+	title, found, err := unstructured.NestedString(item.Object, "spec", "source", "title")
+	if err != nil || !found {
+		log.Printf("Title (.spec.source.title) not found in IssueSandbox %s", item.GetName())
+		return Issue{}, false
+	}
+	htmlurl, found, err := unstructured.NestedString(item.Object, "spec", "source", "htmlURL")
+	if err != nil || !found {
+		log.Printf("htmlURL (.spec.source.htmlURL) not found in IssueSandbox %s", item.GetName())
+	}
 
-		cloneURL, found, err := unstructured.NestedString(item.Object, "spec", "source", "cloneURL")
-		if err != nil || !found {
-			log.Printf("branchURL (.spec.source.cloneURL) not found in IssueSandbox %s", item.GetName())
-			cloneURL = "https://github.com/noorg/norepo.git"
-		}
-		login, found, err := unstructured.NestedString(item.Object, "spec", "destination", "user", "login")
-		if err != nil || !found {
-			log.Printf("branchURL (.spec.destination.user.login) not found in IssueSandbox %s", item.GetName())
-			login = "nouser"
-		}
-		branch, found, err := unstructured.NestedString(item.Object, "spec", "destination", "branch")
-		if err != nil || !found {
-			log.Printf("branchURL (.spec.destination.branch) not found in IssueSandbox %s", item.GetName())
-			branch = "nobranch"
-		}
+	// https://github.com/barney-s/kro/tree/issue-753-bugfix
+	// https://github.com/ + .user.login + source.cloneURL repo name + /tree/ + .destination.branch
+	// https://github.com/kubernetes-sigs/kro/compare/main...barney-s:kro:issue-753-bugfix
+	// .source.cloneURL - .git + /compare/main... + .user.login + : + source.cloneURL repo name  + : + .destination.branch
 
-		repoParts := strings.Split(strings.TrimSuffix(cloneURL, ".git"), "/")
-		repoName := repoParts[len(repoParts)-1]
+	cloneURL, found, err := unstructured.NestedString(item.Object, "spec", "source", "cloneURL")
+	if err != nil || !found {
+		log.Printf("branchURL (.spec.source.cloneURL) not found in IssueSandbox %s", item.GetName())
+		cloneURL = "https://github.com/noorg/norepo.git"
+	}
+	login, found, err := unstructured.NestedString(item.Object, "spec", "destination", "user", "login")
+	if err != nil || !found {
+		log.Printf("branchURL (.spec.destination.user.login) not found in IssueSandbox %s", item.GetName())
+		login = "nouser"
+	}
+	branch, found, err := unstructured.NestedString(item.Object, "spec", "destination", "branch")
+	if err != nil || !found {
+		log.Printf("branchURL (.spec.destination.branch) not found in IssueSandbox %s", item.GetName())
+		branch = "nobranch"
+	}
 
-		branchURL := fmt.Sprintf("https://github.com/%s/%s/tree/%s", login, repoName, branch)
+	repoParts := strings.Split(strings.TrimSuffix(cloneURL, ".git"), "/")
+	repoName := repoParts[len(repoParts)-1]
 
-		pushBranch, found, err := unstructured.NestedBool(item.Object, "spec", "destination", "pushEnabled")
-		if err != nil || !found {
-			log.Printf("pushBranch (.spec.source.pushBranch) not found in IssueSandbox %s", item.GetName())
-		}
+	branchURL := fmt.Sprintf("https://github.com/%s/%s/tree/%s", login, repoName, branch)
 
-		draft, found, err := unstructured.NestedString(item.Object, "status", "agentDraft")
-		if err != nil || !found {
-			log.Printf("pushBranch (.status.agentDraft) not found in IssueSandbox %s", item.GetName())
-		}
+	pushBranch, found, err := unstructured.NestedBool(item.Object, "spec", "destination", "pushEnabled")
+	if err != nil || !found {
+		log.Printf("pushBranch (.spec.source.pushBranch) not found in IssueSandbox %s", item.GetName())
+	}
 
-		issueKey := fmt.Sprintf("issue:repo:%s:handler:%s:issue:%s", repo, handler, issueID)
-		if err := rdb.HSet(ctx, issueKey,
-			"title", title,
-			"sandbox", item.GetName(),
-			"htmlurl", htmlurl,
-			"sandboxReplica", fmt.Sprintf("%d", replicas),
-			"branchURL", branchURL,
-			"draft", draft,
-			"agentDraft", draft,
-			"pushBranch", strconv.FormatBool(pushBranch),
-		).Err(); err != nil {
-			log.Printf("Failed to cache Issue %s for repo %s handler %s: %v", issueID, repo, handler, err)
-		}
+	draft, found, err := unstructured.NestedString(item.Object, "status", "agentDraft")
+	if err != nil || !found {
+		log.Printf("pushBranch (.status.agentDraft) not found in IssueSandbox %s", item.GetName())
 	}
+
+	pullRequestURL, found, err := unstructured.NestedString(item.Object, "status", "pullRequestURL")
+	if err != nil || !found {
+		log.Printf("pullRequestURL (.status.pullRequestURL) not found in IssueSandbox %s", item.GetName())
+	}
+
+	phase, _, _ := unstructured.NestedString(item.Object, "status", "phase")
+	heartbeat, _, _ := unstructured.NestedString(item.Object, "status", "heartbeat")
+
+	return Issue{
+		ID:             issueID,
+		Title:          title,
+		Draft:          draft,
+		Sandbox:        item.GetName(),
+		SandboxReplica: fmt.Sprintf("%d", replicas),
+		HTMLURL:        htmlurl,
+		BranchURL:      branchURL,
+		PullRequestURL: pullRequestURL,
+		PushBranch:     pushBranch,
+		Phase:          phase,
+		Heartbeat:      heartbeat,
+	}, true
 }
 
 func saveIssueDraft(c *gin.Context) {
-	//namespace := c.Param("namespace")
+	namespace := c.Param("namespace")
 	repo := c.Param("repo")
 	issueID := c.Param("issue_id")
 	handler := c.Param("handler")
-	var payload struct {
-		Draft string
-	}
+	var payload draftPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	issueKey := fmt.Sprintf("issue:repo:%s:handler:%s:issue:%s", repo, handler, issueID)
-	err := rdb.HSet(c.Request.Context(), issueKey, "draft", payload.Draft).Err()
+	recordAgentDraft(c.Request.Context(), issueKey(repo, handler, issueID), issueAgentDraft(namespace, repo, handler, issueID))
+	err := store.HSet(c.Request.Context(), issueKey(repo, handler, issueID), "draft", payload.Draft)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
 		return
 	}
+	recordUserDraft(c, issueKey(repo, handler, issueID), payload.Draft)
 
 	c.Status(http.StatusOK)
 }
@@ -1134,33 +1179,39 @@ func submitIssueComment(c *gin.Context) {
 	repo := c.Param("repo")
 	issueID := c.Param("issue_id")
 	handler := c.Param("handler")
-	var payload struct {
-		Comment string
-	}
+	var payload commentSubmission
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx := c.Request.Context()
-	log.Printf("Submitting comment for Issue %s in repo %s with comment: %s", issueID, repo, payload.Comment)
-
-	issueKey := fmt.Sprintf("issue:repo:%s:handler:%s:issue:%s", repo, handler, issueID)
-	issueData, err := rdb.HGetAll(ctx, issueKey).Result()
-	if err != nil {
-		log.Printf("Failed to get Issue %s from Redis for repo %s handler %s: %v", issueID, repo, handler, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Issue data from Redis"})
+	if approvalRequired {
+		requestApproval(c, issueKey(repo, handler, issueID), payload.Comment)
 		return
 	}
+	if err := postIssueComment(c, namespace, repo, handler, issueID, payload.Comment); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// postIssueComment posts comment to an issue on GitHub, saves it and scales
+// down the sandbox. It returns an *apiError on failure.
+func postIssueComment(c *gin.Context, namespace, repo, handler, issueID, comment string) error {
+	ctx := c.Request.Context()
+	log.Printf("Submitting comment for Issue %s in repo %s with comment: %s", issueID, repo, comment)
 
-	draft := payload.Comment
-	agentDraft := issueData["agentDraft"]
+	draft := comment
+	var agentDraft string
+	if sandbox := k8sCache.findSandbox(issueSandboxGVR, namespace, repo, handler, "issue", issueID); sandbox != nil {
+		agentDraft, _, _ = unstructured.NestedString(sandbox.Object, "status", "agentDraft")
+	}
 
 	repoWatch, err := getRepoWatch(ctx, namespace, repo)
 	if err != nil {
 		log.Printf("Failed to get repowatch %s: %v", repo, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get repowatch config"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to get repowatch config"}
 	}
 
 	if draft != agentDraft {
@@ -1188,85 +1239,73 @@ func submitIssueComment(c *gin.Context) {
 		owner, _, _ := parseRepoURL(repoURL)
 
 		hfKey := fmt.Sprintf("hf:issue:githubuser:%s:repo:%s:handler:%s:pr:%s", owner, repo, handler, issueID)
-		if err := rdb.HSet(ctx, hfKey,
+		if err := store.HSet(ctx, hfKey,
 			"draft", draft,
 			"agentDraft", agentDraft,
 			"prompt", prompt,
 			"configdirname", configdir,
-		).Err(); err != nil {
+			"namespace", namespace,
+			"submittedBy", requestUser(c),
+			"submittedAt", time.Now().UTC().Format(time.RFC3339),
+		); err != nil {
 			log.Printf("Failed to store feedback for Issue %s in repo %s: %v", issueID, repo, err)
 			// Continue without failing the comment submission
 		}
 	}
 
-	token, err := getGitHubToken(ctx, repoWatch)
-	if err != nil {
-		log.Printf("Failed to get github token for repo %s: %v", repo, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get github token"})
-		return
-	}
-
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
-
 	repoURL, found, err := unstructured.NestedString(repoWatch.Object, "spec", "repoURL")
 	if err != nil || !found {
 		log.Printf("repoURL not found in RepoWatch CR %s", repoWatch.GetName())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "repoURL not found in RepoWatch CR"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "repoURL not found in RepoWatch CR"}
 	}
 	owner, repoName, err := parseRepoURL(repoURL)
 	if err != nil {
 		log.Printf("Failed to parse repo url %s: %v", repoURL, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse repo url"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to parse repo url"}
 	}
 
 	issueNumber, err := strconv.Atoi(issueID)
 	if err != nil {
 		log.Printf("Failed to parse issueID %s: %v", issueID, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid issue id"})
-		return
+		return &apiError{status: http.StatusBadRequest, message: "invalid issue id"}
 	}
 
-	comment := &github.IssueComment{Body: &payload.Comment}
-	_, _, err = client.Issues.CreateComment(ctx, owner, repoName, issueNumber, comment)
+	issueComment := &github.IssueComment{Body: &comment}
+	// Post as the logged in user if possible
+	postedBy, err := callGitHub(c, repoWatch, func(client *github.Client) (*github.Response, error) {
+		_, resp, err := client.Issues.CreateComment(ctx, owner, repoName, issueNumber, issueComment)
+		return resp, err
+	})
 	if err != nil {
 		log.Printf("Failed to create comment on Issue %d: %v", issueNumber, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment on github"})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to create comment on github"}
 	}
 
-	err = rdb.HSet(c.Request.Context(), issueKey, "comment", payload.Comment).Err()
+	err = store.HSet(c.Request.Context(), issueKey(repo, handler, issueID), "comment", comment, "postedBy", postedBy)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save comment", "details": err.Error()})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to save comment", err: err}
 	}
 
-	err = rdb.HSet(c.Request.Context(), issueKey, "draft", "").Err()
+	err = store.HSet(c.Request.Context(), issueKey(repo, handler, issueID), "draft", "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear draft", "details": err.Error()})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to clear draft", err: err}
 	}
 
 	err = scaledownIssueSandbox(ctx, namespace, repo, issueID, handler)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scaledown Sandbox after comment submission", "details": err.Error()})
-		return
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to scaledown Sandbox after comment submission", err: err}
 	}
 
-	c.Status(http.StatusOK)
+	notifySubmitted(issueSandboxGVR, namespace, repo, handler, issueID, submitter(c, postedBy))
+	return nil
 }
 
 func scaledownIssueSandbox(ctx context.Context, namespace, repo, issueID, handler string) error {
 	sandboxName := fmt.Sprintf("%s-issue-%s-%s", repo, issueID, handler)
-
-	gvr := schema.GroupVersionResource{
-		Group:    "custom.agents.x-k8s.io",
-		Version:  "v1alpha1",
-		Resource: "issuesandboxes",
+	if sandbox := k8sCache.findSandbox(issueSandboxGVR, namespace, repo, handler, "issue", issueID); sandbox != nil {
+		sandboxName = sandbox.GetName()
 	}
+
 	log.Printf("Scaling down issue sandbox %s", sandboxName)
 
 	sandbox := &unstructured.Unstructured{
@@ -1283,7 +1322,7 @@ func scaledownIssueSandbox(ctx context.Context, namespace, repo, issueID, handle
 		},
 	}
 
-	_, err := k8sClient.Resource(gvr).Namespace(namespace).Apply(ctx, sandboxName,
+	_, err := k8sClient.Resource(issueSandboxGVR).Namespace(namespace).Apply(ctx, sandboxName,
 		sandbox, v1.ApplyOptions{FieldManager: "review-ui", Force: true})
 	if err != nil {
 		return fmt.Errorf("failed to scaledown issue sandbox: %w", err)
@@ -1296,21 +1335,25 @@ func deleteIssue(c *gin.Context) {
 	repo := c.Param("repo")
 	issueID := c.Param("issue_id")
 	handler := c.Param("handler")
-	ctx := c.Request.Context()
-
-	if err := scaledownIssueSandbox(ctx, namespace, repo, issueID, handler); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sandbox", "details": err.Error()})
+	if err := discardIssue(c.Request.Context(), namespace, repo, issueID, handler); err != nil {
+		respondError(c, err)
 		return
 	}
+	c.Status(http.StatusOK)
+}
 
-	issueKey := fmt.Sprintf("issue:repo:%s:handler:%s:issue:%s", repo, handler, issueID)
-	if err := rdb.Del(c.Request.Context(), issueKey).Err(); err != nil {
-		log.Printf("Failed to DEL Issue data from Redis: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to DEL Issue data from Redis"})
-		return
+// discardIssue scales down the sandbox of an issue and deletes its stored
+// drafts. It returns an *apiError on failure.
+func discardIssue(ctx context.Context, namespace, repo, issueID, handler string) error {
+	if err := scaledownIssueSandbox(ctx, namespace, repo, issueID, handler); err != nil {
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to delete sandbox", err: err}
 	}
 
-	c.Status(http.StatusOK)
+	if err := store.Del(ctx, issueKey(repo, handler, issueID), draftHistoryKey(issueKey(repo, handler, issueID))); err != nil {
+		log.Printf("Failed to DEL Issue data: %v", err)
+		return &apiError{status: http.StatusInternalServerError, message: "Failed to DEL Issue data"}
+	}
+	return nil
 }
 
 func proxy(c *gin.Context) {
@@ -1320,9 +1363,8 @@ func proxy(c *gin.Context) {
 		return
 	}
 
-	// validate the URL begins with  https://github.com/ or https://raw.githubusercontent.com/
-	if !strings.HasPrefix(proxyURL, "https://github.com/") && !strings.HasPrefix(proxyURL, "https://raw.githubusercontent.com/") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "url must begin with https://github.com/ or https://raw.githubusercontent.com/"})
+	if !proxyAllowed(proxyURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be on https://github.com/, https://raw.githubusercontent.com/ or a configured GitHub Enterprise Server"})
 		return
 	}
 
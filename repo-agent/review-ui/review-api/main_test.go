@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestID())
+	router.GET("/id", func(c *gin.Context) {
+		c.String(http.StatusOK, logging.RequestID(c.Request.Context()))
+	})
+
+	for _, tc := range []struct {
+		header string
+		keep   bool
+	}{
+		{header: "", keep: false},
+		{header: "lb-1234.abcd", keep: true},
+		{header: "not valid\n", keep: false},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/id", nil)
+		if tc.header != "" {
+			req.Header.Set(requestIDHeader, tc.header)
+		}
+		router.ServeHTTP(w, req)
+		id := w.Header().Get(requestIDHeader)
+		if id == "" || w.Body.String() != id {
+			t.Errorf("header %q: got request ID %q in the context and %q in the response", tc.header, w.Body.String(), id)
+		}
+		if (id == tc.header) != tc.keep {
+			t.Errorf("header %q: got request ID %q, want it kept: %v", tc.header, id, tc.keep)
+		}
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// gin panics on conflicting routes, e.g. wildcards of different names at
+	// the same position
+	newRouter()
+}
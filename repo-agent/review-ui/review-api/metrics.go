@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v39/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "review_api_request_duration_seconds",
+		Help:    "Latency of the API requests by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	githubErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "review_api_github_errors_total",
+		Help: "Failed GitHub API calls by HTTP status, 0 for calls without a response.",
+	}, []string{"status"})
+
+	dependencyUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "review_api_dependency_up",
+		Help: "Whether the last readiness check reached the dependency, redis or kubernetes.",
+	}, []string{"dependency"})
+)
+
+// metricsMiddleware records the latency and status of the requests.
+// Requests are labeled by route rather than path to bound the cardinality.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		requestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// countGitHubError counts a failed GitHub API call.
+func countGitHubError(resp *github.Response) {
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	githubErrors.WithLabelValues(strconv.Itoa(status)).Inc()
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	yaml "go.yaml.in/yaml/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// notificationsConfigMapName is the ConfigMap holding the webhooks notified of
+// the events in its namespace under the notifications.yaml key, e.g.
+//
+//	webhooks:
+//	- name: reviewers
+//	  # slack (default), googlechat or json
+//	  format: slack
+//	  urlSecretRef:
+//	    name: slack-webhook
+//	    key: url
+//	  # All events when empty
+//	  events: [draftReady, reviewSubmitted, sandboxFailed]
+//	  # All RepoWatches when empty
+//	  repos: [kubernetes]
+//	  # Optional text/template of the request body, with the Notification
+//	  # as data
+//	  template: '{"text": {{json .Title}}}'
+const notificationsConfigMapName = "repo-agent-notifications"
+
+// Notification events.
+const (
+	// eventDraftReady is sent when the agent publishes a new draft.
+	eventDraftReady = "draftReady"
+	// eventReviewSubmitted is sent when a review or comment is posted to
+	// GitHub.
+	eventReviewSubmitted = "reviewSubmitted"
+	// eventSandboxFailed is sent when the run of a sandbox fails.
+	eventSandboxFailed = "sandboxFailed"
+)
+
+const (
+	// webhookAttempts is how many times a webhook is called before giving up.
+	webhookAttempts = 4
+	webhookTimeout  = 10 * time.Second
+)
+
+// webhookBackoff is the wait before the first retry of a webhook, doubled on
+// each retry.
+var webhookBackoff = 2 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+type webhookConfig struct {
+	Name string `yaml:"name"`
+	// URL is the webhook URL. URLSecretRef is preferred as webhook URLs are
+	// credentials.
+	URL          string        `yaml:"url"`
+	URLSecretRef *secretKeyRef `yaml:"urlSecretRef"`
+	Format       string        `yaml:"format"`
+	Events       []string      `yaml:"events"`
+	Repos        []string      `yaml:"repos"`
+	Template     string        `yaml:"template"`
+}
+
+type secretKeyRef struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type notificationsConfig struct {
+	Webhooks []webhookConfig `yaml:"webhooks"`
+}
+
+// Notification is an event sent to the webhooks, and the data of their
+// templates.
+type Notification struct {
+	Event     string `json:"event"`
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repo"`
+	Handler   string `json:"handler,omitempty"`
+	// Kind is pr or issue
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	// User posted the review or comment
+	User string `json:"user,omitempty"`
+	// Error is why the sandbox failed
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// matches reports whether the webhook is notified of n.
+func (w webhookConfig) matches(n Notification) bool {
+	return (len(w.Events) == 0 || containsString(w.Events, n.Event)) && (len(w.Repos) == 0 || containsString(w.Repos, n.Repo))
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// message returns the text of the default Slack and Google Chat messages.
+func (n Notification) message() string {
+	target := fmt.Sprintf("%s #%s", n.Repo, n.ID)
+	if n.Kind == "issue" {
+		target = fmt.Sprintf("%s issue #%s (%s)", n.Repo, n.ID, n.Handler)
+	}
+	if n.Title != "" {
+		target += " " + n.Title
+	}
+	var msg string
+	switch n.Event {
+	case eventDraftReady:
+		msg = "Agent draft ready for " + target
+	case eventReviewSubmitted:
+		what := "review"
+		if n.Kind == "issue" {
+			what = "comment"
+		}
+		msg = fmt.Sprintf("%s posted the %s of %s", n.User, what, target)
+	case eventSandboxFailed:
+		msg = fmt.Sprintf("Sandbox of %s failed: %s", target, n.Error)
+	default:
+		msg = fmt.Sprintf("%s: %s", n.Event, target)
+	}
+	if n.URL != "" {
+		msg += "\n" + n.URL
+	}
+	return msg
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	// json quotes a value for use in a JSON template
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// webhookBody returns the request body of the webhook for n.
+func webhookBody(w webhookConfig, n Notification) ([]byte, error) {
+	if w.Template != "" {
+		tmpl, err := template.New(w.Name).Funcs(webhookTemplateFuncs).Parse(w.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	switch w.Format {
+	case "", "slack", "googlechat":
+		// Both take the text of the message
+		return json.Marshal(map[string]string{"text": n.message()})
+	case "json":
+		return json.Marshal(n)
+	}
+	return nil, fmt.Errorf("unknown format %q", w.Format)
+}
+
+// webhookURL returns the URL of a webhook, from its secret if set.
+func webhookURL(ctx context.Context, namespace string, w webhookConfig) (string, error) {
+	if w.URLSecretRef == nil {
+		return w.URL, nil
+	}
+	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(ctx, w.URLSecretRef.Name, v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	url := strings.TrimSpace(string(secret.Data[w.URLSecretRef.Key]))
+	if url == "" {
+		return "", fmt.Errorf("key %s not found in secret %s", w.URLSecretRef.Key, w.URLSecretRef.Name)
+	}
+	return url, nil
+}
+
+// namespaceWebhooks returns the webhooks configured in a namespace.
+func namespaceWebhooks(ctx context.Context, namespace string) ([]webhookConfig, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, notificationsConfigMapName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config notificationsConfig
+	if err := yaml.Unmarshal([]byte(cm.Data["notifications.yaml"]), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s/%s: %w", namespace, notificationsConfigMapName, err)
+	}
+	return config.Webhooks, nil
+}
+
+// postWebhook posts body to a webhook, retrying with exponential backoff on
+// network errors, throttling and server errors.
+func postWebhook(ctx context.Context, url string, body []byte) error {
+	backoff := webhookBackoff
+	var lastErr error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("webhook responded %s", resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			// Retrying won't help
+			return lastErr
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", webhookAttempts, lastErr)
+}
+
+// sendNotification calls the webhooks of the namespace of n matching it.
+func sendNotification(ctx context.Context, n Notification) {
+	webhooks, err := namespaceWebhooks(ctx, n.Namespace)
+	if err != nil {
+		log.Printf("Failed to get webhooks of %s: %v", n.Namespace, err)
+		return
+	}
+	for _, w := range webhooks {
+		if !w.matches(n) {
+			continue
+		}
+		body, err := webhookBody(w, n)
+		if err != nil {
+			log.Printf("Failed to build %s notification for webhook %s/%s: %v", n.Event, n.Namespace, w.Name, err)
+			continue
+		}
+		url, err := webhookURL(ctx, n.Namespace, w)
+		if err != nil {
+			log.Printf("Failed to get the url of webhook %s/%s: %v", n.Namespace, w.Name, err)
+			continue
+		}
+		if err := postWebhook(ctx, url, body); err != nil {
+			log.Printf("Failed to notify webhook %s/%s of %s: %v", n.Namespace, w.Name, n.Event, err)
+		}
+	}
+}
+
+// notify sends n in the background.
+func notify(n Notification) {
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+	go sendNotification(context.Background(), n)
+}
+
+// sandboxNotification returns a notification of event about a sandbox.
+func sandboxNotification(gvr schema.GroupVersionResource, sandbox *unstructured.Unstructured, event string) Notification {
+	n := Notification{
+		Event:     event,
+		Namespace: sandbox.GetNamespace(),
+		Repo:      sandbox.GetLabels()["review.gemini.google.com/repowatch"],
+		Kind:      "pr",
+	}
+	idField := "pr"
+	if gvr == issueSandboxGVR {
+		n.Kind = "issue"
+		n.Handler = sandbox.GetLabels()["review.gemini.google.com/handler"]
+		idField = "issue"
+	}
+	n.ID, _, _ = unstructured.NestedString(sandbox.Object, "spec", "source", idField)
+	n.Title, _, _ = unstructured.NestedString(sandbox.Object, "spec", "source", "title")
+	n.URL, _, _ = unstructured.NestedString(sandbox.Object, "spec", "source", "htmlURL")
+	return n
+}
+
+// notifySubmitted notifies that user posted the review or comment of a PR or
+// issue.
+func notifySubmitted(gvr schema.GroupVersionResource, namespace, repo, handler, id, user string) {
+	field := "pr"
+	if gvr == issueSandboxGVR {
+		field = "issue"
+	}
+	var n Notification
+	if sandbox := k8sCache.findSandbox(gvr, namespace, repo, handler, field, id); sandbox != nil {
+		n = sandboxNotification(gvr, sandbox, eventReviewSubmitted)
+	} else {
+		n = Notification{Event: eventReviewSubmitted, Namespace: namespace, Repo: repo, Handler: handler, Kind: field, ID: id}
+	}
+	n.User = user
+	notify(n)
+}
+
+// submitter returns who submitted a review or comment posted by postedBy.
+func submitter(c *gin.Context, postedBy string) string {
+	if user := requestUser(c); user != "" {
+		return user
+	}
+	return postedBy
+}
+
+// sandboxDraft returns the agent's draft published on a sandbox.
+func sandboxDraft(gvr schema.GroupVersionResource, sandbox *unstructured.Unstructured) string {
+	if gvr == reviewSandboxGVR {
+		return sandbox.GetAnnotations()["agentDraft"]
+	}
+	draft, _, _ := unstructured.NestedString(sandbox.Object, "status", "agentDraft")
+	return draft
+}
+
+// sandboxFailure returns why the run of a sandbox failed, or "" if it has
+// not failed.
+func sandboxFailure(sandbox *unstructured.Unstructured) string {
+	conditions, _, _ := unstructured.NestedSlice(sandbox.Object, "status", "conditions")
+	for _, c := range conditions {
+		m, ok := c.(map[string]interface{})
+		if ok && m["type"] == "Failed" && m["status"] == string(v1.ConditionTrue) {
+			if msg, _ := m["message"].(string); msg != "" {
+				return msg
+			}
+			return "failed"
+		}
+	}
+	if phase, _, _ := unstructured.NestedString(sandbox.Object, "status", "phase"); phase == "Failed" {
+		if msg, _, _ := unstructured.NestedString(sandbox.Object, "status", "error"); msg != "" {
+			return msg
+		}
+		return "failed"
+	}
+	return ""
+}
+
+// notifiedKey is the store key of the hash recording the notifications sent
+// for a sandbox, shared by the replicas of the API so that each is sent once.
+func notifiedKey(sandbox *unstructured.Unstructured) string {
+	return fmt.Sprintf("notified:%s:%s", sandbox.GetNamespace(), sandbox.GetName())
+}
+
+// notifyOnce sends n unless a notification with the same id was already sent
+// for the sandbox.
+func notifyOnce(sandbox *unstructured.Unstructured, id string, n Notification) {
+	ok, err := store.HSetNX(context.Background(), notifiedKey(sandbox), id, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("Failed to record %s notification of %s: %v", n.Event, sandbox.GetName(), err)
+		return
+	}
+	if ok {
+		notify(n)
+	}
+}
+
+// notifySandboxChange sends the notifications for the update of a sandbox
+// from old to updated.
+func notifySandboxChange(gvr schema.GroupVersionResource, old, updated *unstructured.Unstructured) {
+	if draft := sandboxDraft(gvr, updated); draft != "" && draft != sandboxDraft(gvr, old) {
+		sum := sha256.Sum256([]byte(draft))
+		notifyOnce(updated, eventDraftReady+":"+hex.EncodeToString(sum[:8]), sandboxNotification(gvr, updated, eventDraftReady))
+	}
+	if failure := sandboxFailure(updated); failure != "" && sandboxFailure(old) == "" {
+		n := sandboxNotification(gvr, updated, eventSandboxFailed)
+		n.Error = failure
+		notifyOnce(updated, eventSandboxFailed+":"+updated.GetResourceVersion(), n)
+	}
+}
+
+// notifyChanges sends the notifications for the changes to the cached
+// sandboxes. Sandboxes listed on startup are not notified.
+func notifyChanges(c *resourceCache) error {
+	for _, gvr := range []schema.GroupVersionResource{reviewSandboxGVR, issueSandboxGVR} {
+		gvr := gvr
+		_, err := c.informers[gvr].AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldU, ok := oldObj.(*unstructured.Unstructured)
+				if !ok {
+					return
+				}
+				newU, ok := newObj.(*unstructured.Unstructured)
+				if !ok || oldU.GetResourceVersion() == newU.GetResourceVersion() {
+					return
+				}
+				notifySandboxChange(gvr, oldU, newU)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if u, ok := obj.(*unstructured.Unstructured); ok {
+					if err := store.Del(context.Background(), notifiedKey(u)); err != nil {
+						log.Printf("Failed to delete the notifications of %s: %v", u.GetName(), err)
+					}
+				}
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
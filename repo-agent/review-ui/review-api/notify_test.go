@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWebhookBody(t *testing.T) {
+	n := Notification{Event: eventDraftReady, Repo: "kubernetes", Kind: "pr", ID: "1", Title: `Fix "quotes"`, URL: "https://github.com/kubernetes/kubernetes/pull/1"}
+
+	body, err := webhookBody(webhookConfig{Format: "slack"}, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Agent draft ready for kubernetes #1 Fix \"quotes\"\nhttps://github.com/kubernetes/kubernetes/pull/1"; msg["text"] != want {
+		t.Errorf("slack text = %q, want %q", msg["text"], want)
+	}
+
+	body, err = webhookBody(webhookConfig{Template: `{"title": {{json .Title}}, "event": "{{.Event}}"}`}, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"title": "Fix \"quotes\"", "event": "draftReady"}`; string(body) != want {
+		t.Errorf("templated body = %s, want %s", body, want)
+	}
+
+	if _, err := webhookBody(webhookConfig{Format: "teams"}, n); err == nil {
+		t.Error("webhookBody() with an unknown format succeeded, want error")
+	}
+}
+
+func TestPostWebhookRetries(t *testing.T) {
+	oldBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = oldBackoff }()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	if err := postWebhook(context.Background(), srv.URL, []byte(`{}`)); err != nil {
+		t.Errorf("postWebhook() = %v, want nil", err)
+	}
+	if calls != 3 {
+		t.Errorf("webhook called %d times, want 3", calls)
+	}
+
+	calls = 0
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer bad.Close()
+	if err := postWebhook(context.Background(), bad.URL, []byte(`{}`)); err == nil {
+		t.Error("postWebhook() to a rejecting webhook succeeded, want error")
+	}
+	if calls != 1 {
+		t.Errorf("rejecting webhook called %d times, want 1", calls)
+	}
+}
+
+func TestNotifySandboxChange(t *testing.T) {
+	store = newMemoryStore()
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received <- string(b)
+	}))
+	defer srv.Close()
+
+	oldClient := kubeClient
+	defer func() { kubeClient = oldClient }()
+	kubeClient = fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: notificationsConfigMapName, Namespace: "team-a"},
+			Data: map[string]string{"notifications.yaml": `
+webhooks:
+- name: chat
+  format: json
+  urlSecretRef: {name: chat-webhook, key: url}
+  events: [draftReady]
+`},
+		},
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "chat-webhook", Namespace: "team-a"},
+			Data:       map[string][]byte{"url": []byte(srv.URL + "\n")},
+		},
+	)
+
+	sandbox := func(rv, draft string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":            "kubernetes-issue-7-fix",
+				"namespace":       "team-a",
+				"resourceVersion": rv,
+				"labels": map[string]interface{}{
+					"review.gemini.google.com/repowatch": "kubernetes",
+					"review.gemini.google.com/handler":   "fix",
+				},
+			},
+			"spec":   map[string]interface{}{"source": map[string]interface{}{"issue": "7"}},
+			"status": map[string]interface{}{"agentDraft": draft, "phase": "Failed"},
+		}}
+	}
+
+	notifySandboxChange(issueSandboxGVR, sandbox("1", ""), sandbox("2", "draft"))
+	select {
+	case body := <-received:
+		var n Notification
+		if err := json.Unmarshal([]byte(body), &n); err != nil {
+			t.Fatal(err)
+		}
+		if n.Event != eventDraftReady || n.Kind != "issue" || n.ID != "7" || n.Handler != "fix" {
+			t.Errorf("notification = %+v, want draftReady of issue 7 of handler fix", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	// Replayed by another replica, and the failure is not subscribed to
+	notifySandboxChange(issueSandboxGVR, sandbox("1", ""), sandbox("2", "draft"))
+	select {
+	case body := <-received:
+		t.Errorf("webhook called again with %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
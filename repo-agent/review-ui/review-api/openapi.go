@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pathParamRegexp matches the parameters of gin paths, :name or *name.
+var pathParamRegexp = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func getOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpecJSON())
+}
+
+// printOpenAPISpec is the openapi subcommand, printing the spec the typed
+// client is generated from.
+func printOpenAPISpec() {
+	os.Stdout.Write(openAPISpecJSON())
+}
+
+func openAPISpecJSON() []byte {
+	openAPIOnce.Do(func() {
+		spec, err := json.MarshalIndent(openAPISpec(), "", "  ")
+		if err != nil {
+			panic(fmt.Sprintf("failed to marshal the OpenAPI spec: %v", err))
+		}
+		openAPIJSON = append(spec, '\n')
+	})
+	return openAPIJSON
+}
+
+// openAPISpec returns the OpenAPI 3 spec of apiRoutes. Request and response
+// schemas are derived from the Go types of the bodies, and operation IDs from
+// the names of the handlers.
+func openAPISpec() map[string]interface{} {
+	schemas := &openAPISchemas{components: map[string]interface{}{}}
+	errorRef := schemas.schema(reflect.TypeOf(errorResponse{}))
+	paths := map[string]map[string]interface{}{}
+	for _, r := range apiRoutes() {
+		path := pathParamRegexp.ReplaceAllString(r.path, "{$1}")
+		var params []interface{}
+		for _, m := range pathParamRegexp.FindAllStringSubmatch(r.path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range r.query {
+			params = append(params, map[string]interface{}{
+				"name": q, "in": "query", "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		status := r.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		switch {
+		case r.contentType != "":
+			success["content"] = map[string]interface{}{r.contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+		case r.response != nil:
+			success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(r.response))}}
+		}
+		op := map[string]interface{}{
+			"operationId": handlerName(r.handler),
+			"summary":     r.summary,
+			"responses": map[string]interface{}{
+				fmt.Sprint(status): success,
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorRef}},
+				},
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if r.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(r.request))}},
+			}
+		}
+		if r.role != roleNone {
+			op["x-required-role"] = r.role.String()
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(r.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Repo Agent review API",
+			"version": "v1alpha1",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": "/api"}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas.components},
+	}
+}
+
+// handlerName returns the name of the function of a handler.
+func handlerName(h gin.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// openAPISchemas builds the schemas of Go types, named structs being added to
+// the components.
+type openAPISchemas struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := s.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		name := exportedName(t.Name())
+		if name == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s.components[name]; !ok {
+			// Set first so that recursive types terminate
+			s.components[name] = nil
+			s.components[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns the schema of the JSON encoding of a struct, with the
+// names of the Go fields in x-go-name for the typed client.
+func (s *openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	// Fields that are always encoded
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			schema := s.schema(f.Type)
+			if _, ok := schema["$ref"]; !ok {
+				schema["x-go-name"] = f.Name
+			} else {
+				schema = map[string]interface{}{"allOf": []interface{}{schema}, "x-go-name": f.Name}
+			}
+			properties[name] = schema
+			if !strings.Contains(tag, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// exportedName returns the name of a Go type as exported by the typed client.
+func exportedName(name string) string {
+	if name == "" {
+		return ""
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/review-ui/review-api/client"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOpenAPISpecUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("client/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(committed, openAPISpecJSON()) {
+		t.Error("client/openapi.json is out of date, run go generate ./client")
+	}
+}
+
+func TestOpenAPIOperationIDs(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpecJSON(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]string{}
+	for path, ops := range spec.Paths {
+		for method, op := range ops {
+			if op.OperationID == "" {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+			if other, ok := seen[op.OperationID]; ok {
+				t.Errorf("%s %s and %s share the operationId %s", method, path, other, op.OperationID)
+			}
+			seen[op.OperationID] = method + " " + path
+		}
+	}
+}
+
+// TestClient drives the API through the generated client, including the CSRF
+// cookie flow of mutating requests.
+func TestClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldStore, oldKube, oldCache := store, kubeClient, k8sCache
+	defer func() { store, kubeClient, k8sCache = oldStore, oldKube, oldCache }()
+	store = newMemoryStore()
+	kubeClient = fake.NewSimpleClientset()
+	k8sCache = newTestCache(t, nil)
+
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+	api := client.New(srv.URL + "/api")
+	ctx := context.Background()
+
+	settings, err := api.GetSettings(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := settings["csrfEnabled"]; !ok {
+		t.Errorf("settings = %v, want csrfEnabled", settings)
+	}
+
+	created, err := api.CreatePromptTemplate(ctx, "team-a", client.PromptTemplateCreation{Name: "review", Kind: "review", Prompt: "Review {{.Title}}."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.LatestVersion != 1 {
+		t.Errorf("created template = %+v, want version 1", created)
+	}
+	if _, err := api.AddPromptVersion(ctx, "team-a", "review", client.PromptVersionCreation{Prompt: "Review {{.Title}} carefully."}); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := api.ListPromptTemplates(ctx, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].LatestVersion != 2 {
+		t.Errorf("templates = %+v, want review at version 2", templates)
+	}
+
+	_, err = api.CreatePromptTemplate(ctx, "team-a", client.PromptTemplateCreation{Name: "review", Kind: "review", Prompt: "Again."})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("creating an existing template = %v, want a %d error", err, http.StatusConflict)
+	}
+
+	if err := api.SaveDraft(ctx, "team-a", "kubernetes", "1", client.DraftPayload{Draft: "LGTM"}); err != nil {
+		t.Fatal(err)
+	}
+	history, err := api.GetPRDraftHistory(ctx, "team-a", "kubernetes", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Draft != "LGTM" {
+		t.Errorf("draft history = %+v, want the saved draft", history)
+	}
+}
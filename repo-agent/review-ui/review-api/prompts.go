@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// Prompt templates are ConfigMaps of the tenant namespace named
+// promptTemplatePrefix + <name>, holding each version of the prompt under the
+// keys v1, v2, and so on. Versions are never modified, so that RepoWatches can
+// pin one. The RepoWatch controller reads the ConfigMap referenced by the
+// promptTemplateRef of a review or issue handler.
+const (
+	promptTemplatePrefix          = "prompt-template-"
+	promptKindLabel               = "review.gemini.google.com/prompt-kind"
+	promptLatestVersionAnnotation = "review.gemini.google.com/latest-version"
+	// promptAuthorAnnotationPrefix + v<N> records who saved version N.
+	promptAuthorAnnotationPrefix = "review.gemini.google.com/author-"
+)
+
+// promptKinds are the purposes a prompt template is written for.
+var promptKinds = []string{"review", "triage", "fix"}
+
+var errPromptTemplateExists = errors.New("prompt template already exists")
+
+// PromptTemplateRefPayload references a version of a prompt template, the
+// latest one if Version is 0.
+type PromptTemplateRefPayload struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version,omitempty"`
+}
+
+// PromptVersion is a version of a prompt template.
+type PromptVersion struct {
+	Version int64  `json:"version"`
+	Prompt  string `json:"prompt"`
+	Author  string `json:"author,omitempty"`
+}
+
+// PromptTemplate is a prompt template, with its versions oldest first when
+// requested alone.
+type PromptTemplate struct {
+	Name          string          `json:"name"`
+	Kind          string          `json:"kind"`
+	LatestVersion int64           `json:"latestVersion"`
+	Prompt        string          `json:"prompt"`
+	Versions      []PromptVersion `json:"versions,omitempty"`
+	UsedBy        []string        `json:"usedBy"`
+}
+
+// promptTemplateCreation is a prompt template to create, with its first
+// version.
+type promptTemplateCreation struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Prompt string `json:"prompt"`
+}
+
+// promptVersionCreation is a new version of a prompt template.
+type promptVersionCreation struct {
+	Prompt string `json:"prompt"`
+}
+
+// PromptAssignment sets the prompt template of the review, or of the issue
+// handler named Target, of a RepoWatch. An empty Name unassigns it.
+type PromptAssignment struct {
+	Target  string `json:"target"`
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+}
+
+func validatePromptTemplateName(name string) error {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("prompt template name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validatePrompt(prompt string) error {
+	if strings.TrimSpace(prompt) == "" {
+		return errors.New("prompt is required")
+	}
+	if _, err := template.New("prompt").Parse(prompt); err != nil {
+		return fmt.Errorf("prompt is not a valid template: %v", err)
+	}
+	return nil
+}
+
+// promptTemplateFromConfigMap returns the template stored in cm, with all its
+// versions if withVersions.
+func promptTemplateFromConfigMap(cm *corev1.ConfigMap, withVersions bool) PromptTemplate {
+	t := PromptTemplate{
+		Name:   strings.TrimPrefix(cm.Name, promptTemplatePrefix),
+		Kind:   cm.Labels[promptKindLabel],
+		UsedBy: []string{},
+	}
+	t.LatestVersion, _ = strconv.ParseInt(cm.Annotations[promptLatestVersionAnnotation], 10, 64)
+	t.Prompt = cm.Data[promptVersionKey(t.LatestVersion)]
+	if withVersions {
+		for v := int64(1); v <= t.LatestVersion; v++ {
+			key := promptVersionKey(v)
+			if prompt, ok := cm.Data[key]; ok {
+				t.Versions = append(t.Versions, PromptVersion{Version: v, Prompt: prompt, Author: cm.Annotations[promptAuthorAnnotationPrefix+key]})
+			}
+		}
+	}
+	return t
+}
+
+func promptVersionKey(version int64) string {
+	return fmt.Sprintf("v%d", version)
+}
+
+// llmPromptTemplateRef returns the prompt template referenced by the llm field
+// of a review or issue handler.
+func llmPromptTemplateRef(m map[string]interface{}) *PromptTemplateRefPayload {
+	name, _, _ := unstructured.NestedString(m, "llm", "promptTemplateRef", "name")
+	if name == "" {
+		return nil
+	}
+	version, _, _ := unstructured.NestedInt64(m, "llm", "promptTemplateRef", "version")
+	return &PromptTemplateRefPayload{Name: name, Version: version}
+}
+
+// promptTemplateUsers returns the RepoWatches of a namespace using each prompt
+// template, as <repowatch>/review or <repowatch>/<handler>.
+func promptTemplateUsers(namespace string) map[string][]string {
+	users := map[string][]string{}
+	for _, repoWatch := range k8sCache.list(repoWatchGVR, namespace, labels.Everything()) {
+		if review, found, _ := unstructured.NestedMap(repoWatch.Object, "spec", "review"); found {
+			if ref := llmPromptTemplateRef(review); ref != nil {
+				users[ref.Name] = append(users[ref.Name], repoWatch.GetName()+"/review")
+			}
+		}
+		handlers, _, _ := unstructured.NestedSlice(repoWatch.Object, "spec", "issueHandlers")
+		for _, h := range handlers {
+			m, ok := h.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if ref := llmPromptTemplateRef(m); ref != nil {
+				name, _ := m["name"].(string)
+				users[ref.Name] = append(users[ref.Name], repoWatch.GetName()+"/"+name)
+			}
+		}
+	}
+	return users
+}
+
+func getPromptTemplateConfigMap(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	return kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, promptTemplatePrefix+name, v1.GetOptions{})
+}
+
+func respondPromptError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case apierrors.IsNotFound(err):
+		status = http.StatusNotFound
+	case errors.Is(err, errPromptTemplateExists), apierrors.IsAlreadyExists(err):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func listPromptTemplates(c *gin.Context) {
+	namespace := c.Param("namespace")
+	cms, err := kubeClient.CoreV1().ConfigMaps(namespace).List(c.Request.Context(), v1.ListOptions{LabelSelector: promptKindLabel})
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+	users := promptTemplateUsers(namespace)
+	templates := []PromptTemplate{}
+	for i := range cms.Items {
+		t := promptTemplateFromConfigMap(&cms.Items[i], false)
+		if u, ok := users[t.Name]; ok {
+			t.UsedBy = u
+		}
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	c.JSON(http.StatusOK, templates)
+}
+
+func getPromptTemplate(c *gin.Context) {
+	namespace := c.Param("namespace")
+	cm, err := getPromptTemplateConfigMap(c.Request.Context(), namespace, c.Param("template"))
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+	t := promptTemplateFromConfigMap(cm, true)
+	if u, ok := promptTemplateUsers(namespace)[t.Name]; ok {
+		t.UsedBy = u
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+func createPromptTemplate(c *gin.Context) {
+	var payload promptTemplateCreation
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := validatePromptTemplateName(payload.Name)
+	if err == nil && !containsString(promptKinds, payload.Kind) {
+		err = fmt.Errorf("kind must be one of %s", strings.Join(promptKinds, ", "))
+	}
+	if err == nil {
+		err = validatePrompt(payload.Prompt)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Set(auditTargetKey, payload.Name)
+	key := promptVersionKey(1)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      promptTemplatePrefix + payload.Name,
+			Namespace: c.Param("namespace"),
+			Labels:    map[string]string{promptKindLabel: payload.Kind},
+			Annotations: map[string]string{
+				promptLatestVersionAnnotation:      "1",
+				promptAuthorAnnotationPrefix + key: requestUser(c),
+			},
+		},
+		Data: map[string]string{key: payload.Prompt},
+	}
+	created, err := kubeClient.CoreV1().ConfigMaps(cm.Namespace).Create(c.Request.Context(), cm, v1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		err = fmt.Errorf("%w: %s", errPromptTemplateExists, payload.Name)
+	}
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, promptTemplateFromConfigMap(created, true))
+}
+
+// addPromptVersion saves a new version of a prompt template, which becomes its
+// latest version.
+func addPromptVersion(c *gin.Context) {
+	var payload promptVersionCreation
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePrompt(payload.Prompt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	namespace := c.Param("namespace")
+	var updated *corev1.ConfigMap
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := getPromptTemplateConfigMap(ctx, namespace, c.Param("template"))
+		if err != nil {
+			return err
+		}
+		latest, _ := strconv.ParseInt(cm.Annotations[promptLatestVersionAnnotation], 10, 64)
+		key := promptVersionKey(latest + 1)
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Data[key] = payload.Prompt
+		cm.Annotations[promptLatestVersionAnnotation] = strconv.FormatInt(latest+1, 10)
+		cm.Annotations[promptAuthorAnnotationPrefix+key] = requestUser(c)
+		updated, err = kubeClient.CoreV1().ConfigMaps(namespace).Update(ctx, cm, v1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, promptTemplateFromConfigMap(updated, true))
+}
+
+// deletePromptTemplate deletes a prompt template, unless a RepoWatch uses it.
+func deletePromptTemplate(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("template")
+	if users := promptTemplateUsers(namespace)[name]; len(users) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("prompt template %s is used by %s", name, strings.Join(users, ", "))})
+		return
+	}
+	err := kubeClient.CoreV1().ConfigMaps(namespace).Delete(c.Request.Context(), promptTemplatePrefix+name, v1.DeleteOptions{})
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// setPromptTemplateRef sets the promptTemplateRef of the llm field of m, or
+// removes it if ref is nil.
+func setPromptTemplateRef(m map[string]interface{}, ref *PromptTemplateRefPayload) error {
+	if ref == nil {
+		unstructured.RemoveNestedField(m, "llm", "promptTemplateRef")
+		return nil
+	}
+	value := map[string]interface{}{"name": ref.Name}
+	if ref.Version > 0 {
+		value["version"] = ref.Version
+	}
+	return unstructured.SetNestedMap(m, value, "llm", "promptTemplateRef")
+}
+
+// assignPromptTemplate sets the prompt template used by the review or an issue
+// handler of a RepoWatch.
+func assignPromptTemplate(c *gin.Context) {
+	var payload PromptAssignment
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target must be review or the name of an issue handler"})
+		return
+	}
+	c.Set(auditTargetKey, payload.Target)
+	ctx := c.Request.Context()
+	namespace := c.Param("namespace")
+
+	var ref *PromptTemplateRefPayload
+	if payload.Name != "" {
+		cm, err := getPromptTemplateConfigMap(ctx, namespace, payload.Name)
+		if apierrors.IsNotFound(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("prompt template %s not found", payload.Name)})
+			return
+		}
+		if err != nil {
+			respondPromptError(c, err)
+			return
+		}
+		if _, ok := cm.Data[promptVersionKey(payload.Version)]; payload.Version != 0 && !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("prompt template %s has no version %d", payload.Name, payload.Version)})
+			return
+		}
+		ref = &PromptTemplateRefPayload{Name: payload.Name, Version: payload.Version}
+	}
+
+	err := modifyRepoWatch(ctx, namespace, c.Param("name"), func(obj *unstructured.Unstructured) error {
+		if payload.Target == "review" {
+			review, found, err := unstructured.NestedMap(obj.Object, "spec", "review")
+			if err != nil {
+				return err
+			}
+			if !found {
+				return errors.New("PR reviews are not configured")
+			}
+			if err := setPromptTemplateRef(review, ref); err != nil {
+				return err
+			}
+			return unstructured.SetNestedMap(obj.Object, review, "spec", "review")
+		}
+		handlers, _, err := unstructured.NestedSlice(obj.Object, "spec", "issueHandlers")
+		if err != nil {
+			return err
+		}
+		for _, h := range handlers {
+			if m, ok := h.(map[string]interface{}); ok && m["name"] == payload.Target {
+				if err := setPromptTemplateRef(m, ref); err != nil {
+					return err
+				}
+				return unstructured.SetNestedSlice(obj.Object, handlers, "spec", "issueHandlers")
+			}
+		}
+		return fmt.Errorf("issue handler %s not found", payload.Target)
+	})
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func callPromptHandler(handler gin.HandlerFunc, params gin.Params, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = params
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	handler(c)
+	return w
+}
+
+func TestPromptTemplates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldDynamic, oldKube, oldCache := k8sClient, kubeClient, k8sCache
+	defer func() { k8sClient, kubeClient, k8sCache = oldDynamic, oldKube, oldCache }()
+	kubeClient = fake.NewSimpleClientset()
+
+	repoWatch := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "review.gemini.google.com/v1alpha1",
+		"kind":       "RepoWatch",
+		"metadata":   map[string]interface{}{"name": "kubernetes", "namespace": "team-a"},
+		"spec": map[string]interface{}{
+			"review":        map[string]interface{}{"maxActiveSandboxes": int64(1), "llm": map[string]interface{}{"prompt": "inline"}},
+			"issueHandlers": []interface{}{map[string]interface{}{"name": "triage", "maxActiveSandboxes": int64(1)}},
+		},
+	}}
+	k8sClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{repoWatchGVR: "RepoWatchList"})
+	if _, err := k8sClient.Resource(repoWatchGVR).Namespace("team-a").Create(context.Background(), repoWatch, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	k8sCache = newTestCache(t, nil)
+
+	ns := gin.Params{{Key: "namespace", Value: "team-a"}}
+	tmpl := append(ns, gin.Param{Key: "template", Value: "triage"})
+	if w := callPromptHandler(createPromptTemplate, ns, `{"name": "triage", "kind": "triage", "prompt": "Triage {{.Number}}."}`); w.Code != http.StatusCreated {
+		t.Fatalf("create template = %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+	if w := callPromptHandler(createPromptTemplate, ns, `{"name": "triage", "kind": "triage", "prompt": "Again."}`); w.Code != http.StatusConflict {
+		t.Errorf("create existing template = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := callPromptHandler(createPromptTemplate, ns, `{"name": "bad", "kind": "triage", "prompt": "{{.Number"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("create template with an invalid prompt = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := callPromptHandler(addPromptVersion, tmpl, `{"prompt": "Triage issue {{.Number}}."}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("add version = %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+	var got PromptTemplate
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.LatestVersion != 2 || len(got.Versions) != 2 || got.Versions[0].Prompt != "Triage {{.Number}}." || got.Prompt != "Triage issue {{.Number}}." {
+		t.Errorf("template = %+v, want 2 versions", got)
+	}
+
+	repo := append(ns, gin.Param{Key: "name", Value: "kubernetes"})
+	if w := callPromptHandler(assignPromptTemplate, repo, `{"target": "triage", "name": "triage", "version": 3}`); w.Code != http.StatusBadRequest {
+		t.Errorf("assign missing version = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := callPromptHandler(assignPromptTemplate, repo, `{"target": "triage", "name": "triage", "version": 1}`); w.Code != http.StatusOK {
+		t.Fatalf("assign template = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	updated, err := k8sClient.Resource(repoWatchGVR).Namespace("team-a").Get(context.Background(), "kubernetes", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handlers := issueHandlerPayloads(updated); len(handlers) != 1 || handlers[0].LLM.PromptTemplateRef == nil || *handlers[0].LLM.PromptTemplateRef != (PromptTemplateRefPayload{Name: "triage", Version: 1}) {
+		t.Errorf("handlers = %+v, want triage pinned to version 1", handlers)
+	}
+
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{repoWatchGVR: {updated}})
+	if w := callPromptHandler(deletePromptTemplate, tmpl, ""); w.Code != http.StatusConflict {
+		t.Errorf("delete used template = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := callPromptHandler(assignPromptTemplate, repo, `{"target": "triage"}`); w.Code != http.StatusOK {
+		t.Fatalf("unassign template = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	k8sCache = newTestCache(t, nil)
+	if w := callPromptHandler(deletePromptTemplate, tmpl, ""); w.Code != http.StatusOK {
+		t.Errorf("delete unused template = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+}
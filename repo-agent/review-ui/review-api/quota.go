@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	yaml "go.yaml.in/yaml/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// quotasConfigMapName is the ConfigMap in the API's namespace holding the
+// quotas of the tenant namespaces under the quotas.yaml key, e.g.
+//
+//	# Quota of namespaces without their own
+//	default:
+//	  maxRepoWatches: 5
+//	  maxActiveSandboxes: 10
+//	  maxStorage: 100Gi
+//	namespaces:
+//	  team-a:
+//	    maxRepoWatches: 20
+//
+// It is kept out of the tenant namespaces so that tenant admins can't raise
+// their own quota. Unset limits are unlimited.
+const quotasConfigMapName = "repo-agent-quotas"
+
+// sandboxWorkspaceStorage is the size of the workspace volume of each
+// sandbox, see the sandbox ResourceGraphDefinitions.
+var sandboxWorkspaceStorage = resource.MustParse("5Gi")
+
+// apiNamespace is the namespace the API runs in, POD_NAMESPACE.
+var apiNamespace = func() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "repo-agent-system"
+}()
+
+// Quota limits what a namespace can use.
+type Quota struct {
+	MaxRepoWatches int64 `json:"maxRepoWatches,omitempty" yaml:"maxRepoWatches"`
+	// MaxActiveSandboxes bounds the sum of the maxActiveSandboxes of the
+	// RepoWatches, and the sandboxes scaled up by reruns.
+	MaxActiveSandboxes int64 `json:"maxActiveSandboxes,omitempty" yaml:"maxActiveSandboxes"`
+	// MaxStorage bounds the workspace volumes of the sandboxes.
+	MaxStorage string `json:"maxStorage,omitempty" yaml:"maxStorage"`
+}
+
+// QuotaUsage is what a namespace uses.
+type QuotaUsage struct {
+	RepoWatches int64 `json:"repoWatches"`
+	// ActiveSandboxes is the sum of the maxActiveSandboxes of the
+	// RepoWatches
+	ActiveSandboxes int64 `json:"activeSandboxes"`
+	// RunningSandboxes is the number of sandboxes scaled up
+	RunningSandboxes int64  `json:"runningSandboxes"`
+	Storage          string `json:"storage"`
+}
+
+type quotaConfig struct {
+	Default    Quota            `yaml:"default"`
+	Namespaces map[string]Quota `yaml:"namespaces"`
+}
+
+// quotaError is returned when an action would exceed a quota.
+type quotaError struct {
+	msg string
+}
+
+func (e *quotaError) Error() string {
+	return "quota exceeded: " + e.msg
+}
+
+// namespaceQuota returns the quota of a namespace, its own limits overriding
+// the default ones.
+func namespaceQuota(ctx context.Context, namespace string) (Quota, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(apiNamespace).Get(ctx, quotasConfigMapName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Quota{}, nil
+	}
+	if err != nil {
+		return Quota{}, err
+	}
+	var config quotaConfig
+	if err := yaml.Unmarshal([]byte(cm.Data["quotas.yaml"]), &config); err != nil {
+		return Quota{}, fmt.Errorf("failed to parse %s/%s: %w", apiNamespace, quotasConfigMapName, err)
+	}
+	return mergeQuota(config.Default, config.Namespaces[namespace]), nil
+}
+
+// mergeQuota returns base with the limits set in override replaced.
+func mergeQuota(base, override Quota) Quota {
+	if override.MaxRepoWatches != 0 {
+		base.MaxRepoWatches = override.MaxRepoWatches
+	}
+	if override.MaxActiveSandboxes != 0 {
+		base.MaxActiveSandboxes = override.MaxActiveSandboxes
+	}
+	if override.MaxStorage != "" {
+		base.MaxStorage = override.MaxStorage
+	}
+	return base
+}
+
+// repoWatchActiveSandboxes returns the sum of the maxActiveSandboxes of the
+// review and issue handlers of a RepoWatch.
+func repoWatchActiveSandboxes(repoWatch *unstructured.Unstructured) int64 {
+	total, _, _ := unstructured.NestedInt64(repoWatch.Object, "spec", "review", "maxActiveSandboxes")
+	handlers, _, _ := unstructured.NestedSlice(repoWatch.Object, "spec", "issueHandlers")
+	for _, h := range handlers {
+		if m, ok := h.(map[string]interface{}); ok {
+			n, _, _ := unstructured.NestedInt64(m, "maxActiveSandboxes")
+			total += n
+		}
+	}
+	return total
+}
+
+// namespaceUsage returns the usage of a namespace from the cache, with the
+// RepoWatch named replacing the cached one if not nil.
+func namespaceUsage(namespace string, replacing *unstructured.Unstructured) QuotaUsage {
+	var usage QuotaUsage
+	for _, repoWatch := range k8sCache.list(repoWatchGVR, namespace, labels.Everything()) {
+		if replacing != nil && repoWatch.GetName() == replacing.GetName() {
+			continue
+		}
+		usage.RepoWatches++
+		usage.ActiveSandboxes += repoWatchActiveSandboxes(repoWatch)
+	}
+	if replacing != nil {
+		usage.RepoWatches++
+		usage.ActiveSandboxes += repoWatchActiveSandboxes(replacing)
+	}
+
+	storage := resource.Quantity{}
+	for _, gvr := range []schema.GroupVersionResource{reviewSandboxGVR, issueSandboxGVR} {
+		for _, sandbox := range k8sCache.list(gvr, namespace, labels.Everything()) {
+			if replicas, _, _ := unstructured.NestedInt64(sandbox.Object, "spec", "replicas"); replicas > 0 {
+				usage.RunningSandboxes++
+			}
+			// Scaled down sandboxes keep their workspace
+			storage.Add(sandboxWorkspaceStorage)
+		}
+	}
+	usage.Storage = storage.String()
+	return usage
+}
+
+// checkRepoWatchQuota checks that creating or updating repoWatch keeps its
+// namespace within its quota.
+func checkRepoWatchQuota(ctx context.Context, repoWatch *unstructured.Unstructured) error {
+	quota, err := namespaceQuota(ctx, repoWatch.GetNamespace())
+	if err != nil {
+		return err
+	}
+	usage := namespaceUsage(repoWatch.GetNamespace(), repoWatch)
+	if quota.MaxRepoWatches > 0 && usage.RepoWatches > quota.MaxRepoWatches {
+		return &quotaError{fmt.Sprintf("namespace %s is limited to %d RepoWatches", repoWatch.GetNamespace(), quota.MaxRepoWatches)}
+	}
+	if quota.MaxActiveSandboxes > 0 && usage.ActiveSandboxes > quota.MaxActiveSandboxes {
+		return &quotaError{fmt.Sprintf("namespace %s is limited to %d active sandboxes, the RepoWatches would allow %d", repoWatch.GetNamespace(), quota.MaxActiveSandboxes, usage.ActiveSandboxes)}
+	}
+	if quota.MaxStorage != "" {
+		max, err := resource.ParseQuantity(quota.MaxStorage)
+		if err != nil {
+			return fmt.Errorf("invalid maxStorage %q: %w", quota.MaxStorage, err)
+		}
+		if used := resource.MustParse(usage.Storage); used.Cmp(max) >= 0 {
+			return &quotaError{fmt.Sprintf("namespace %s uses %s of its %s of storage", repoWatch.GetNamespace(), usage.Storage, quota.MaxStorage)}
+		}
+	}
+	return nil
+}
+
+// checkScaleUpQuota checks that one more sandbox can run in a namespace.
+func checkScaleUpQuota(ctx context.Context, namespace string) error {
+	quota, err := namespaceQuota(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	usage := namespaceUsage(namespace, nil)
+	if quota.MaxActiveSandboxes > 0 && usage.RunningSandboxes >= quota.MaxActiveSandboxes {
+		return &quotaError{fmt.Sprintf("namespace %s already runs %d of its %d active sandboxes", namespace, usage.RunningSandboxes, quota.MaxActiveSandboxes)}
+	}
+	return nil
+}
+
+// quotaStatus returns the HTTP status for an error checking a quota.
+func quotaStatus(err error) int {
+	var qe *quotaError
+	if errors.As(err, &qe) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// getSettings returns the features enabled in the API and, for the namespace
+// query parameter, its quota, usage and the age and expiration warnings of
+// its credentials.
+func getSettings(c *gin.Context) {
+	settings := gin.H{
+		"loginEnabled":     oauthConfig != nil,
+		"rbacEnabled":      rbacEnabled,
+		"approvalRequired": approvalRequired,
+		"csrfEnabled":      csrfEnabled,
+	}
+	if namespace := c.Query("namespace"); namespace != "" {
+		if !authorize(c, namespace, "", roleViewer) {
+			return
+		}
+		quota, err := namespaceQuota(c.Request.Context(), namespace)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get quota: %v", err)})
+			return
+		}
+		settings["quota"] = quota
+		settings["usage"] = namespaceUsage(namespace, nil)
+		credentials, err := namespaceCredentials(c.Request.Context(), namespace)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get credentials: %v", err)})
+			return
+		}
+		settings["credentials"] = credentials
+	}
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestCache(t *testing.T, objs map[schema.GroupVersionResource][]*unstructured.Unstructured) *resourceCache {
+	c := &resourceCache{informers: map[schema.GroupVersionResource]cache.SharedIndexInformer{}}
+	for _, gvr := range []schema.GroupVersionResource{repoWatchGVR, reviewSandboxGVR, issueSandboxGVR} {
+		informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, obj := range objs[gvr] {
+			if err := informer.GetIndexer().Add(obj); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.informers[gvr] = informer
+	}
+	return c
+}
+
+func quotaTestRepoWatch(name string, review int64, handlers ...int64) *unstructured.Unstructured {
+	var issueHandlers []interface{}
+	for _, n := range handlers {
+		issueHandlers = append(issueHandlers, map[string]interface{}{"maxActiveSandboxes": n})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": "team-a"},
+		"spec": map[string]interface{}{
+			"review":        map[string]interface{}{"maxActiveSandboxes": review},
+			"issueHandlers": issueHandlers,
+		},
+	}}
+}
+
+func quotaTestSandbox(name string, replicas int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": "team-a"},
+		"spec":     map[string]interface{}{"replicas": replicas},
+	}}
+}
+
+func TestQuota(t *testing.T) {
+	oldCache, oldClient := k8sCache, kubeClient
+	defer func() { k8sCache, kubeClient = oldCache, oldClient }()
+	kubeClient = fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: quotasConfigMapName, Namespace: apiNamespace},
+		Data: map[string]string{"quotas.yaml": `
+default:
+  maxRepoWatches: 2
+  maxActiveSandboxes: 5
+  maxStorage: 20Gi
+namespaces:
+  team-a:
+    maxActiveSandboxes: 8
+`},
+	})
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		repoWatchGVR:     {quotaTestRepoWatch("kubernetes", 3, 2)},
+		reviewSandboxGVR: {quotaTestSandbox("pr-1", 1), quotaTestSandbox("pr-2", 0)},
+		issueSandboxGVR:  {quotaTestSandbox("issue-1", 1)},
+	})
+	ctx := context.Background()
+
+	quota, err := namespaceQuota(ctx, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Quota{MaxRepoWatches: 2, MaxActiveSandboxes: 8, MaxStorage: "20Gi"}); quota != want {
+		t.Errorf("namespaceQuota() = %+v, want %+v", quota, want)
+	}
+
+	usage := namespaceUsage("team-a", nil)
+	if want := (QuotaUsage{RepoWatches: 1, ActiveSandboxes: 5, RunningSandboxes: 2, Storage: "15Gi"}); usage != want {
+		t.Errorf("namespaceUsage() = %+v, want %+v", usage, want)
+	}
+
+	tests := []struct {
+		name      string
+		repoWatch *unstructured.Unstructured
+		exceeded  bool
+	}{
+		{name: "within quota", repoWatch: quotaTestRepoWatch("linux", 3)},
+		{name: "too many sandboxes", repoWatch: quotaTestRepoWatch("linux", 3, 1), exceeded: true},
+		{name: "update replaces the cached RepoWatch", repoWatch: quotaTestRepoWatch("kubernetes", 4, 4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRepoWatchQuota(ctx, tt.repoWatch)
+			var qe *quotaError
+			if errors.As(err, &qe) != tt.exceeded {
+				t.Errorf("checkRepoWatchQuota() = %v, want exceeded %v", err, tt.exceeded)
+			}
+		})
+	}
+
+	// A third RepoWatch exceeds maxRepoWatches
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		repoWatchGVR: {quotaTestRepoWatch("kubernetes", 1), quotaTestRepoWatch("linux", 1)},
+	})
+	if err := checkRepoWatchQuota(ctx, quotaTestRepoWatch("go", 1)); quotaStatus(err) != 403 {
+		t.Errorf("checkRepoWatchQuota() = %v, want quota exceeded", err)
+	}
+
+	// Storage is full with 4 sandboxes of 5Gi
+	sandboxes := []*unstructured.Unstructured{quotaTestSandbox("pr-1", 0), quotaTestSandbox("pr-2", 0), quotaTestSandbox("pr-3", 0), quotaTestSandbox("pr-4", 0)}
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{reviewSandboxGVR: sandboxes})
+	if err := checkRepoWatchQuota(ctx, quotaTestRepoWatch("go", 1)); quotaStatus(err) != 403 {
+		t.Errorf("checkRepoWatchQuota() = %v, want quota exceeded", err)
+	}
+}
+
+func TestCheckScaleUpQuota(t *testing.T) {
+	oldCache, oldClient := k8sCache, kubeClient
+	defer func() { k8sCache, kubeClient = oldCache, oldClient }()
+	kubeClient = fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: quotasConfigMapName, Namespace: apiNamespace},
+		Data:       map[string]string{"quotas.yaml": "default:\n  maxActiveSandboxes: 2\n"},
+	})
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		reviewSandboxGVR: {quotaTestSandbox("pr-1", 1), quotaTestSandbox("pr-2", 0)},
+	})
+	ctx := context.Background()
+	if err := checkScaleUpQuota(ctx, "team-a"); err != nil {
+		t.Errorf("checkScaleUpQuota() = %v, want nil", err)
+	}
+	k8sCache = newTestCache(t, map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		reviewSandboxGVR: {quotaTestSandbox("pr-1", 1), quotaTestSandbox("pr-2", 1)},
+	})
+	if err := checkScaleUpQuota(ctx, "team-a"); quotaStatus(err) != 403 {
+		t.Errorf("checkScaleUpQuota() = %v, want quota exceeded", err)
+	}
+	// Without the ConfigMap nothing is limited
+	kubeClient = fake.NewSimpleClientset()
+	if err := checkScaleUpQuota(ctx, "team-a"); err != nil {
+		t.Errorf("checkScaleUpQuota() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultRateLimitPerMinute = 60
+	defaultRateLimitBurst     = 20
+	defaultMaxBodyBytes       = 1 << 20
+	// rateLimiterIdle is how long the bucket of an inactive client is kept.
+	rateLimiterIdle = 10 * time.Minute
+)
+
+// envInt returns the integer value of an environment variable, or def if it
+// is unset or invalid.
+func envInt(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d: %v", name, v, def, err)
+		return def
+	}
+	return n
+}
+
+// rateLimiter is a token bucket per client. Buckets are kept in memory, so
+// each replica of the API enforces the limit on its own.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*rateLimitedClient
+}
+
+type rateLimitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing perMinute requests a minute
+// per client, in bursts of up to burst requests.
+func newRateLimiter(perMinute, burst int64) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(float64(perMinute) / 60),
+		burst:   int(burst),
+		clients: map[string]*rateLimitedClient{},
+	}
+}
+
+// allow reports whether client can make a request now, and otherwise how long
+// it has to wait.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[client]
+	if !ok {
+		c = &rateLimitedClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	r := c.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Minute
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// forget drops the buckets of the clients inactive for idle.
+func (l *rateLimiter) forget(idle time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, c := range l.clients {
+		if now.Sub(c.lastSeen) > idle {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// rateLimitKey identifies the client of a request: the user if known, else
+// the session, else the client IP.
+func rateLimitKey(c *gin.Context) string {
+	if user := requestUser(c); user != "" {
+		return "user:" + user
+	}
+	if id, err := c.Cookie(sessionCookie); err == nil && id != "" {
+		return "session:" + id
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// rateLimited reports whether a request counts against the rate limit:
+// mutating requests and those calling GitHub with the repo's token.
+func rateLimited(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return true
+	}
+	path := c.Request.URL.Path
+	return path == "/api/proxy" || strings.HasSuffix(path, "/diff")
+}
+
+// rateLimit is a middleware rejecting the requests of clients exceeding
+// RATE_LIMIT_PER_MINUTE with bursts of RATE_LIMIT_BURST.
+func rateLimit() gin.HandlerFunc {
+	limiter := newRateLimiter(
+		envInt("RATE_LIMIT_PER_MINUTE", defaultRateLimitPerMinute),
+		envInt("RATE_LIMIT_BURST", defaultRateLimitBurst),
+	)
+	go func() {
+		for now := range time.Tick(rateLimiterIdle) {
+			limiter.forget(rateLimiterIdle, now)
+		}
+	}()
+	return func(c *gin.Context) {
+		if !rateLimited(c) {
+			c.Next()
+			return
+		}
+		ok, wait := limiter.allow(rateLimitKey(c), time.Now())
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// maxBodyBytes is the largest request body accepted, MAX_BODY_BYTES.
+var maxBodyBytes = envInt("MAX_BODY_BYTES", defaultMaxBodyBytes)
+
+// limitBody is a middleware rejecting request bodies larger than
+// maxBodyBytes. Bodies without a length are cut off at the limit, which
+// readers see as an *http.MaxBytesError.
+func limitBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBodyBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+		}
+		c.Next()
+	}
+}
+
+// isBodyTooLarge reports whether err comes from reading a body cut off by
+// limitBody.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("alice", now); !ok {
+			t.Fatalf("request %d in the burst was limited", i)
+		}
+	}
+	ok, wait := l.allow("alice", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Errorf("allow() after the burst = %v, %v, want a wait of up to a second", ok, wait)
+	}
+	if ok, _ := l.allow("bob", now); !ok {
+		t.Error("another client was limited")
+	}
+	if ok, _ := l.allow("alice", now.Add(time.Second)); !ok {
+		t.Error("request after refill was limited")
+	}
+
+	l.forget(time.Minute, now.Add(2*time.Minute))
+	if len(l.clients) != 0 {
+		t.Errorf("got %d clients after forget, want 0", len(l.clients))
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old := maxBodyBytes
+	maxBodyBytes = 8
+	defer func() { maxBodyBytes = old }()
+
+	router := gin.New()
+	router.Use(limitBody(), RequestLoggerMiddleware())
+	router.POST("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tt := range []struct {
+		body     string
+		chunked  bool
+		wantCode int
+	}{
+		{body: "small", wantCode: http.StatusOK},
+		{body: "much too large", wantCode: http.StatusRequestEntityTooLarge},
+		{body: "much too large", chunked: true, wantCode: http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("POST %q (chunked %v) status = %d, want %d", tt.body, tt.chunked, w.Code, tt.wantCode)
+		}
+	}
+}
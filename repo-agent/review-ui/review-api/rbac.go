@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	yaml "go.yaml.in/yaml/v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// rolesConfigMapName is the ConfigMap holding the roles of users in its
+// namespace under the roles.yaml key, e.g.
+//
+//	# Role of users on all RepoWatches in the namespace. "*" matches
+//	# every user.
+//	users:
+//	  octocat: admin
+//	  "*": viewer
+//	# Roles on single RepoWatches, e.g. to share a repo read-only with
+//	# another team.
+//	repos:
+//	  kubernetes:
+//	    hubot: viewer
+const rolesConfigMapName = "repo-agent-roles"
+
+// role is the access level of a user on a RepoWatch. Each role includes the
+// permissions of the lower ones.
+type role int
+
+const (
+	roleNone role = iota
+	// roleViewer can list repos, PRs and issues.
+	roleViewer
+	// roleReviewer can also edit drafts, submit reviews and comments and
+	// delete sandboxes.
+	roleReviewer
+	// roleApprover can also approve reviews and comments submitted by others
+	// when APPROVAL_REQUIRED is set.
+	roleApprover
+	// roleAdmin can also create, edit and delete RepoWatches.
+	roleAdmin
+)
+
+var roleNames = map[string]role{
+	"viewer":   roleViewer,
+	"reviewer": roleReviewer,
+	"approver": roleApprover,
+	"admin":    roleAdmin,
+}
+
+func (r role) String() string {
+	for name, v := range roleNames {
+		if v == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// roleBindings is the content of a roles ConfigMap.
+type roleBindings struct {
+	Users map[string]string            `yaml:"users"`
+	Repos map[string]map[string]string `yaml:"repos"`
+}
+
+var (
+	// rbacEnabled turns on role checks. When off every user is an admin.
+	rbacEnabled = os.Getenv("RBAC_ENABLED") == "true"
+	// userHeader is the header an authenticating proxy puts the user in,
+	// e.g. X-Forwarded-Email. It is used when the user has not logged in
+	// with GitHub.
+	userHeader = os.Getenv("AUTH_USER_HEADER")
+)
+
+// requestUser returns the user making the request, or "" if unknown.
+func requestUser(c *gin.Context) string {
+	if s := getSession(c); s != nil {
+		return s.Login
+	}
+	if userHeader != "" {
+		return c.GetHeader(userHeader)
+	}
+	return ""
+}
+
+// bestRole returns the highest role granted to user, or to every user, in
+// bindings.
+func bestRole(bindings map[string]string, user string) role {
+	best := roleNone
+	for _, u := range []string{user, "*"} {
+		if r := roleNames[bindings[u]]; r > best {
+			best = r
+		}
+	}
+	return best
+}
+
+// roleFor returns the role of user on a RepoWatch, or on the namespace if
+// repo is empty.
+func (c *resourceCache) roleFor(user, namespace, repo string) role {
+	cm, err := c.get(configMapGVR, namespace, rolesConfigMapName)
+	if err != nil {
+		return roleNone
+	}
+	data, _, _ := unstructured.NestedString(cm.Object, "data", "roles.yaml")
+	var bindings roleBindings
+	if err := yaml.Unmarshal([]byte(data), &bindings); err != nil {
+		log.Printf("Failed to parse roles in %s/%s: %v", namespace, rolesConfigMapName, err)
+		return roleNone
+	}
+	r := bestRole(bindings.Users, user)
+	if repo != "" {
+		if repoRole := bestRole(bindings.Repos[repo], user); repoRole > r {
+			r = repoRole
+		}
+	}
+	return r
+}
+
+// userRole returns the role of the requesting user on a RepoWatch.
+func userRole(c *gin.Context, namespace, repo string) role {
+	if !rbacEnabled {
+		return roleAdmin
+	}
+	user := requestUser(c)
+	if user == "" {
+		return roleNone
+	}
+	return k8sCache.roleFor(user, namespace, repo)
+}
+
+// authorize checks that the requesting user has at least role min on a
+// RepoWatch, or on the namespace if repo is empty, and responds with an error
+// if not.
+func authorize(c *gin.Context, namespace, repo string, min role) bool {
+	if !rbacEnabled {
+		return true
+	}
+	if requestUser(c) == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+		return false
+	}
+	if userRole(c, namespace, repo) < min {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires the " + min.String() + " role"})
+		return false
+	}
+	return true
+}
+
+// requireRole is a middleware checking that the user has at least role min
+// on the RepoWatch of the route, given by the namespace and repo or name
+// parameters. Routes without a namespace only require a known user.
+func requireRole(min role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		namespace := c.Param("namespace")
+		repo := c.Param("repo")
+		if repo == "" {
+			repo = c.Param("name")
+		}
+		if namespace == "" {
+			if rbacEnabled && requestUser(c) == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+			}
+			return
+		}
+		if authorize(c, namespace, repo, min) {
+			c.Next()
+		}
+	}
+}
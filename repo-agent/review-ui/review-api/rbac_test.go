@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestRoleFor(t *testing.T) {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	cm := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": rolesConfigMapName, "namespace": "team-a"},
+		"data": map[string]interface{}{"roles.yaml": `
+users:
+  alice: admin
+  bob: reviewer
+  "*": viewer
+repos:
+  kubernetes:
+    carol: reviewer
+`},
+	}}
+	if err := informer.GetIndexer().Add(cm); err != nil {
+		t.Fatal(err)
+	}
+	c := &resourceCache{informers: map[schema.GroupVersionResource]cache.SharedIndexInformer{configMapGVR: informer}}
+
+	tests := []struct {
+		user, namespace, repo string
+		want                  role
+	}{
+		{user: "alice", namespace: "team-a", repo: "kubernetes", want: roleAdmin},
+		{user: "bob", namespace: "team-a", want: roleReviewer},
+		{user: "dave", namespace: "team-a", repo: "kubernetes", want: roleViewer},
+		{user: "carol", namespace: "team-a", repo: "kubernetes", want: roleReviewer},
+		{user: "carol", namespace: "team-a", repo: "linux", want: roleViewer},
+		{user: "alice", namespace: "team-b", repo: "kubernetes", want: roleNone},
+	}
+	for _, tt := range tests {
+		if got := c.roleFor(tt.user, tt.namespace, tt.repo); got != tt.want {
+			t.Errorf("roleFor(%q, %q, %q) = %v, want %v", tt.user, tt.namespace, tt.repo, got, tt.want)
+		}
+	}
+}
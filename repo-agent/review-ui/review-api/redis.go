@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// redisPingInterval is how often a degraded store checks whether Redis is
+// back.
+const redisPingInterval = 10 * time.Second
+
+// redisOptionsFromEnv returns the options of the Redis client:
+//
+//   - REDIS_ADDR: comma separated addresses of the server, the Sentinels or
+//     the Cluster nodes
+//   - REDIS_MASTER_NAME: name of the master monitored by the Sentinels at
+//     REDIS_ADDR
+//   - REDIS_CLUSTER: "true" when REDIS_ADDR are Cluster nodes
+//   - REDIS_USERNAME, REDIS_PASSWORD: AUTH credentials
+//   - REDIS_SENTINEL_PASSWORD: AUTH password of the Sentinels
+//   - REDIS_DB: database number, unsupported by Cluster
+//   - REDIS_TLS: "true" to connect over TLS, verified against the CA bundle
+//     REDIS_TLS_CA_FILE if set, else the system roots
+func redisOptionsFromEnv() (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+		Username:         os.Getenv("REDIS_USERNAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		DB:               int(envInt("REDIS_DB", 0)),
+	}
+	for _, addr := range strings.Split(os.Getenv("REDIS_ADDR"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			opts.Addrs = append(opts.Addrs, addr)
+		}
+	}
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("REDIS_ADDR is empty")
+	}
+	if os.Getenv("REDIS_CLUSTER") == "true" && opts.MasterName != "" {
+		return nil, errors.New("REDIS_CLUSTER and REDIS_MASTER_NAME are exclusive")
+	}
+	if os.Getenv("REDIS_TLS") == "true" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if caFile := os.Getenv("REDIS_TLS_CA_FILE"); caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read REDIS_TLS_CA_FILE: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", caFile)
+			}
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+// newRedisClient returns a client for a single server, a Sentinel monitored
+// master or a Cluster.
+func newRedisClient(opts *redis.UniversalOptions) redis.UniversalClient {
+	switch {
+	case opts.MasterName != "":
+		return redis.NewFailoverClient(opts.Failover())
+	case os.Getenv("REDIS_CLUSTER") == "true":
+		return redis.NewClusterClient(opts.Cluster())
+	default:
+		return redis.NewClient(opts.Simple())
+	}
+}
+
+// newStoreFromEnv returns the store of the API: Redis if REDIS_ADDR is set,
+// else memory. With REDIS_MEMORY_FALLBACK=true the API starts and keeps
+// serving from memory while Redis is unreachable instead of failing.
+func newStoreFromEnv() kvStore {
+	if os.Getenv("REDIS_ADDR") == "" {
+		log.Println("REDIS_ADDR is not set, keeping drafts in memory")
+		return newMemoryStore()
+	}
+	opts, err := redisOptionsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid Redis configuration: %v", err)
+	}
+	primary := &redisStore{client: newRedisClient(opts)}
+	if os.Getenv("REDIS_MEMORY_FALLBACK") == "true" {
+		s := newFallbackStore(primary)
+		go s.watch(context.Background(), redisPingInterval)
+		return s
+	}
+	// Ping redis to ensure connection
+	if err := primary.Ping(context.Background()); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	return primary
+}
+
+// degradedError is returned by the Ping of a fallbackStore serving from
+// memory.
+type degradedError struct {
+	err error
+}
+
+func (e *degradedError) Error() string {
+	return fmt.Sprintf("degraded, serving from memory: %v", e.err)
+}
+
+// fallbackStore is a kvStore serving from memory while its primary store is
+// unreachable. What is written to memory is not copied back to the primary
+// store, so drafts saved while degraded are lost once it recovers.
+type fallbackStore struct {
+	primary  kvStore
+	fallback *memoryStore
+
+	mu sync.Mutex
+	// err is why the primary store is unreachable, nil if it is reachable
+	err error
+}
+
+func newFallbackStore(primary kvStore) *fallbackStore {
+	return &fallbackStore{primary: primary, fallback: newMemoryStore()}
+}
+
+// current returns the store to use.
+func (s *fallbackStore) current() kvStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.fallback
+	}
+	return s.primary
+}
+
+// setErr records the result of a call to the primary store.
+func (s *fallbackStore) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && s.err == nil:
+		log.Printf("Redis is unreachable, serving from memory: %v", err)
+	case err == nil && s.err != nil:
+		log.Println("Redis is reachable again, changes made while degraded are lost")
+	}
+	s.err = err
+}
+
+// unavailable reports whether err means that the primary store can't be
+// reached, rather than a failure of the call.
+func unavailable(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var redisErr redis.Error
+	// Errors replied by the server
+	return !errors.As(err, &redisErr)
+}
+
+// do runs call on the current store, falling back to memory if the primary
+// store turns out to be unreachable.
+func (s *fallbackStore) do(call func(kvStore) error) error {
+	current := s.current()
+	err := call(current)
+	if current == s.primary && unavailable(err) {
+		s.setErr(err)
+		return call(s.fallback)
+	}
+	return err
+}
+
+// watch pings the primary store every interval to switch back to it once it
+// is reachable.
+func (s *fallbackStore) watch(ctx context.Context, interval time.Duration) {
+	s.setErr(s.primary.Ping(ctx))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.primary.Ping(ctx)
+			if !unavailable(err) {
+				err = nil
+			}
+			s.setErr(err)
+		}
+	}
+}
+
+func (s *fallbackStore) HSet(ctx context.Context, key string, values ...interface{}) error {
+	return s.do(func(kv kvStore) error { return kv.HSet(ctx, key, values...) })
+}
+
+func (s *fallbackStore) HGet(ctx context.Context, key, field string) (string, error) {
+	var v string
+	err := s.do(func(kv kvStore) error {
+		var err error
+		v, err = kv.HGet(ctx, key, field)
+		return err
+	})
+	return v, err
+}
+
+func (s *fallbackStore) HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	var ok bool
+	err := s.do(func(kv kvStore) error {
+		var err error
+		ok, err = kv.HSetNX(ctx, key, field, value)
+		return err
+	})
+	return ok, err
+}
+
+func (s *fallbackStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	var h map[string]string
+	err := s.do(func(kv kvStore) error {
+		var err error
+		h, err = kv.HGetAll(ctx, key)
+		return err
+	})
+	return h, err
+}
+
+func (s *fallbackStore) HDel(ctx context.Context, key string, fields ...string) error {
+	return s.do(func(kv kvStore) error { return kv.HDel(ctx, key, fields...) })
+}
+
+func (s *fallbackStore) Del(ctx context.Context, keys ...string) error {
+	return s.do(func(kv kvStore) error { return kv.Del(ctx, keys...) })
+}
+
+func (s *fallbackStore) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	err := s.do(func(kv kvStore) error {
+		var err error
+		keys, err = kv.Keys(ctx, pattern)
+		return err
+	})
+	return keys, err
+}
+
+// Ping reports a *degradedError while serving from memory.
+func (s *fallbackStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return &degradedError{err: s.err}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestRedisOptionsFromEnv(t *testing.T) {
+	t.Setenv("REDIS_ADDR", "sentinel-0:26379, sentinel-1:26379")
+	t.Setenv("REDIS_MASTER_NAME", "mymaster")
+	t.Setenv("REDIS_PASSWORD", "secret")
+	t.Setenv("REDIS_TLS", "true")
+	opts, err := redisOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.Addrs) != 2 || opts.Addrs[1] != "sentinel-1:26379" {
+		t.Errorf("Addrs = %q, want both sentinels", opts.Addrs)
+	}
+	if opts.MasterName != "mymaster" || opts.Password != "secret" || opts.TLSConfig == nil {
+		t.Errorf("options = %+v, want master mymaster with password and TLS", opts)
+	}
+	if _, ok := newRedisClient(opts).(*redis.Client); !ok {
+		t.Error("newRedisClient() with a master name is not a failover client")
+	}
+
+	t.Setenv("REDIS_CLUSTER", "true")
+	if _, err := redisOptionsFromEnv(); err == nil {
+		t.Error("redisOptionsFromEnv() with both Cluster and Sentinel succeeded, want error")
+	}
+	t.Setenv("REDIS_MASTER_NAME", "")
+	opts, err = redisOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := newRedisClient(opts).(*redis.ClusterClient); !ok {
+		t.Error("newRedisClient() with REDIS_CLUSTER is not a cluster client")
+	}
+}
+
+// downStore is a kvStore whose server can't be reached.
+type downStore struct {
+	*memoryStore
+	down bool
+}
+
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func (s *downStore) HSet(ctx context.Context, key string, values ...interface{}) error {
+	if s.down {
+		return errConnRefused
+	}
+	return s.memoryStore.HSet(ctx, key, values...)
+}
+
+func (s *downStore) HGet(ctx context.Context, key, field string) (string, error) {
+	if s.down {
+		return "", errConnRefused
+	}
+	return s.memoryStore.HGet(ctx, key, field)
+}
+
+func (s *downStore) Ping(context.Context) error {
+	if s.down {
+		return errConnRefused
+	}
+	return nil
+}
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	primary := &downStore{memoryStore: newMemoryStore()}
+	s := newFallbackStore(primary)
+
+	if err := s.HSet(ctx, "k", "draft", "from redis"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.HGet(ctx, "k", "review"); err != redis.Nil {
+		t.Errorf("HGet() of a missing field = %v, want redis.Nil", err)
+	}
+	if err := s.Ping(ctx); err != nil {
+		t.Errorf("Ping() = %v, want nil", err)
+	}
+
+	primary.down = true
+	if err := s.HSet(ctx, "k", "draft", "from memory"); err != nil {
+		t.Errorf("HSet() while Redis is down = %v, want nil", err)
+	}
+	if got, err := s.HGet(ctx, "k", "draft"); err != nil || got != "from memory" {
+		t.Errorf("HGet() while Redis is down = %q, %v, want \"from memory\"", got, err)
+	}
+	var degraded *degradedError
+	if err := s.Ping(ctx); !errors.As(err, &degraded) {
+		t.Errorf("Ping() while Redis is down = %v, want degraded", err)
+	}
+
+	primary.down = false
+	s.setErr(primary.Ping(ctx))
+	if got, err := s.HGet(ctx, "k", "draft"); err != nil || got != "from redis" {
+		t.Errorf("HGet() once Redis is back = %q, %v, want \"from redis\"", got, err)
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// Defaults of RepoWatches created through the API.
+const (
+	defaultLLMProvider         = "gemini-cli"
+	defaultReviewPrompt        = "You are an expert code reviewer. Review the following pull request."
+	defaultDevcontainerRef     = "go-devcontainer-json"
+	defaultAPIKeySecretRef     = "gemini-vscode-tokens"
+	defaultGithubSecretName    = "github-pat"
+	defaultPollIntervalSeconds = 300
+	minPollIntervalSeconds     = 30
+)
+
+// LLMPayload is the editable LLM configuration of a review or issue handler.
+type LLMPayload struct {
+	Provider        string `json:"provider"`
+	APIKeySecretRef string `json:"apiKeySecretRef"`
+	Prompt          string `json:"prompt"`
+	ConfigdirRef    string `json:"configdirRef"`
+	// PromptTemplateRef, if set, is used instead of Prompt.
+	PromptTemplateRef *PromptTemplateRefPayload `json:"promptTemplateRef,omitempty"`
+}
+
+// ReviewPayload is the editable PR review configuration of a RepoWatch.
+type ReviewPayload struct {
+	MaxActiveSandboxes    int64      `json:"maxActiveSandboxes"`
+	DevcontainerConfigRef string     `json:"devcontainerConfigRef"`
+	PullRequests          []int64    `json:"pullRequests"`
+	LLM                   LLMPayload `json:"llm"`
+}
+
+// IssueHandlerPayload is the editable configuration of an issue handler.
+type IssueHandlerPayload struct {
+	Name                  string     `json:"name"`
+	MaxActiveSandboxes    int64      `json:"maxActiveSandboxes"`
+	DevcontainerConfigRef string     `json:"devcontainerConfigRef"`
+	Labels                []string   `json:"labels"`
+	Issues                []int64    `json:"issues"`
+	PushEnabled           bool       `json:"pushEnabled"`
+	LLM                   LLMPayload `json:"llm"`
+}
+
+// RepoWatchPayload is the editable part of a RepoWatch.
+type RepoWatchPayload struct {
+	Name                string                `json:"name"`
+	Namespace           string                `json:"namespace"`
+	RepoURL             string                `json:"repoURL"`
+	PollIntervalSeconds int64                 `json:"pollIntervalSeconds"`
+	Review              *ReviewPayload        `json:"review,omitempty"`
+	IssueHandlers       []IssueHandlerPayload `json:"issueHandlers"`
+}
+
+func (l *LLMPayload) validate(field string, promptRequired bool) error {
+	if l.Provider != "" && l.Provider != defaultLLMProvider {
+		return fmt.Errorf("%s.provider must be %q", field, defaultLLMProvider)
+	}
+	for name, ref := range map[string]string{"apiKeySecretRef": l.APIKeySecretRef, "configdirRef": l.ConfigdirRef} {
+		if ref == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(ref); len(errs) > 0 {
+			return fmt.Errorf("%s.%s: %s", field, name, strings.Join(errs, ", "))
+		}
+	}
+	if ref := l.PromptTemplateRef; ref != nil {
+		if err := validatePromptTemplateName(ref.Name); err != nil {
+			return fmt.Errorf("%s.promptTemplateRef: %v", field, err)
+		}
+		if ref.Version < 0 {
+			return fmt.Errorf("%s.promptTemplateRef.version must be positive", field)
+		}
+	}
+	if strings.TrimSpace(l.Prompt) == "" {
+		if promptRequired && l.PromptTemplateRef == nil {
+			return fmt.Errorf("%s.prompt is required", field)
+		}
+		return nil
+	}
+	if _, err := template.New("prompt").Parse(l.Prompt); err != nil {
+		return fmt.Errorf("%s.prompt is not a valid template: %v", field, err)
+	}
+	return nil
+}
+
+// toMap returns the llm field. A nil PromptTemplateRef is left out, keeping
+// the one assigned through the prompt template endpoints.
+func (l *LLMPayload) toMap() map[string]interface{} {
+	provider := l.Provider
+	if provider == "" {
+		provider = defaultLLMProvider
+	}
+	m := map[string]interface{}{
+		"provider":        provider,
+		"apiKeySecretRef": l.APIKeySecretRef,
+		"prompt":          l.Prompt,
+		"configdirRef":    l.ConfigdirRef,
+	}
+	if ref := l.PromptTemplateRef; ref != nil {
+		value := map[string]interface{}{"name": ref.Name}
+		if ref.Version > 0 {
+			value["version"] = ref.Version
+		}
+		m["promptTemplateRef"] = value
+	}
+	return m
+}
+
+func validateDevcontainerRef(field, ref string) error {
+	if ref == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(ref); len(errs) > 0 {
+		return fmt.Errorf("%s: %s", field, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validateNumbers(field string, numbers []int64) error {
+	for _, n := range numbers {
+		if n <= 0 {
+			return fmt.Errorf("%s must only contain positive numbers", field)
+		}
+	}
+	return nil
+}
+
+func (r *ReviewPayload) validate() error {
+	if r.MaxActiveSandboxes < 1 {
+		return fmt.Errorf("review.maxActiveSandboxes must be at least 1")
+	}
+	if err := validateDevcontainerRef("review.devcontainerConfigRef", r.DevcontainerConfigRef); err != nil {
+		return err
+	}
+	if err := validateNumbers("review.pullRequests", r.PullRequests); err != nil {
+		return err
+	}
+	return r.LLM.validate("review.llm", false)
+}
+
+// apply sets the review configuration of a RepoWatch, keeping the fields
+// that are not editable through the API.
+func (r *ReviewPayload) apply(obj *unstructured.Unstructured) error {
+	review, _, err := unstructured.NestedMap(obj.Object, "spec", "review")
+	if err != nil {
+		return err
+	}
+	if review == nil {
+		review = map[string]interface{}{}
+	}
+	review["maxActiveSandboxes"] = r.MaxActiveSandboxes
+	review["devcontainerConfigRef"] = r.DevcontainerConfigRef
+	review["pullRequests"] = int64Slice(r.PullRequests)
+	review["llm"] = mergeMap(review["llm"], r.LLM.toMap())
+	return unstructured.SetNestedMap(obj.Object, review, "spec", "review")
+}
+
+func (h *IssueHandlerPayload) validate() error {
+	if errs := validation.IsDNS1123Label(h.Name); len(errs) > 0 {
+		return fmt.Errorf("issue handler name %q: %s", h.Name, strings.Join(errs, ", "))
+	}
+	field := fmt.Sprintf("issueHandlers[%s]", h.Name)
+	if h.MaxActiveSandboxes < 1 {
+		return fmt.Errorf("%s.maxActiveSandboxes must be at least 1", field)
+	}
+	if err := validateDevcontainerRef(field+".devcontainerConfigRef", h.DevcontainerConfigRef); err != nil {
+		return err
+	}
+	if err := validateNumbers(field+".issues", h.Issues); err != nil {
+		return err
+	}
+	for _, label := range h.Labels {
+		if strings.TrimSpace(label) == "" {
+			return fmt.Errorf("%s.labels must not contain empty labels", field)
+		}
+	}
+	return h.LLM.validate(field+".llm", true)
+}
+
+// setDefaults fills the references left empty when creating a handler with
+// the defaults of RepoWatches created through the API.
+func (h *IssueHandlerPayload) setDefaults() {
+	if h.DevcontainerConfigRef == "" {
+		h.DevcontainerConfigRef = defaultDevcontainerRef
+	}
+	if h.LLM.APIKeySecretRef == "" {
+		h.LLM.APIKeySecretRef = defaultAPIKeySecretRef
+	}
+	if h.Labels == nil {
+		h.Labels = []string{}
+	}
+	if h.Issues == nil {
+		h.Issues = []int64{}
+	}
+}
+
+// toMap returns the handler merged into existing, the handler's current
+// configuration, so that fields not editable through the API are kept.
+func (h *IssueHandlerPayload) toMap(existing map[string]interface{}) map[string]interface{} {
+	handler := map[string]interface{}{}
+	for k, v := range existing {
+		handler[k] = v
+	}
+	handler["name"] = h.Name
+	handler["maxActiveSandboxes"] = h.MaxActiveSandboxes
+	handler["devcontainerConfigRef"] = h.DevcontainerConfigRef
+	handler["labels"] = stringSlice(h.Labels)
+	handler["issues"] = int64Slice(h.Issues)
+	handler["pushEnabled"] = h.PushEnabled
+	handler["llm"] = mergeMap(existing["llm"], h.LLM.toMap())
+	return handler
+}
+
+// validate checks a RepoWatch to be created or updated. Review and issue
+// handlers are validated separately.
+func (p *RepoWatchPayload) validate() error {
+	if _, _, err := parseRepoURL(p.RepoURL); err != nil || !strings.HasPrefix(p.RepoURL, "https://") {
+		return fmt.Errorf("repoURL must be of the form https://<host>/<owner>/<repo>")
+	}
+	if p.PollIntervalSeconds != 0 && p.PollIntervalSeconds < minPollIntervalSeconds {
+		return fmt.Errorf("pollIntervalSeconds must be at least %d", minPollIntervalSeconds)
+	}
+	return nil
+}
+
+func validateIssueHandlers(handlers []IssueHandlerPayload) error {
+	seen := map[string]bool{}
+	for i := range handlers {
+		if err := handlers[i].validate(); err != nil {
+			return err
+		}
+		if seen[handlers[i].Name] {
+			return fmt.Errorf("duplicate issue handler %q", handlers[i].Name)
+		}
+		seen[handlers[i].Name] = true
+	}
+	return nil
+}
+
+// setIssueHandlers replaces the issue handlers of a RepoWatch. Handlers are
+// matched by name to keep the fields not editable through the API.
+func setIssueHandlers(obj *unstructured.Unstructured, handlers []IssueHandlerPayload) error {
+	existing, _, err := unstructured.NestedSlice(obj.Object, "spec", "issueHandlers")
+	if err != nil {
+		return err
+	}
+	byName := map[string]map[string]interface{}{}
+	for _, h := range existing {
+		if m, ok := h.(map[string]interface{}); ok {
+			name, _ := m["name"].(string)
+			byName[name] = m
+		}
+	}
+	updated := make([]interface{}, 0, len(handlers))
+	for i := range handlers {
+		updated = append(updated, handlers[i].toMap(byName[handlers[i].Name]))
+	}
+	return unstructured.SetNestedSlice(obj.Object, updated, "spec", "issueHandlers")
+}
+
+// issueHandlerPayloads returns the editable issue handlers of a RepoWatch.
+func issueHandlerPayloads(obj *unstructured.Unstructured) []IssueHandlerPayload {
+	handlers := []IssueHandlerPayload{}
+	existing, _, _ := unstructured.NestedSlice(obj.Object, "spec", "issueHandlers")
+	for _, h := range existing {
+		m, ok := h.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		handler := IssueHandlerPayload{
+			LLM:    llmPayload(m),
+			Labels: []string{},
+			Issues: []int64{},
+		}
+		handler.Name, _, _ = unstructured.NestedString(m, "name")
+		handler.MaxActiveSandboxes, _, _ = unstructured.NestedInt64(m, "maxActiveSandboxes")
+		handler.DevcontainerConfigRef, _, _ = unstructured.NestedString(m, "devcontainerConfigRef")
+		handler.PushEnabled, _, _ = unstructured.NestedBool(m, "pushEnabled")
+		if labels, found, _ := unstructured.NestedStringSlice(m, "labels"); found {
+			handler.Labels = labels
+		}
+		handler.Issues = nestedInt64Slice(m, "issues")
+		handlers = append(handlers, handler)
+	}
+	return handlers
+}
+
+// reviewPayload returns the editable review configuration of a RepoWatch, or
+// nil if PR reviews are not configured.
+func reviewPayload(obj *unstructured.Unstructured) *ReviewPayload {
+	m, found, _ := unstructured.NestedMap(obj.Object, "spec", "review")
+	if !found {
+		return nil
+	}
+	review := &ReviewPayload{LLM: llmPayload(m)}
+	review.MaxActiveSandboxes, _, _ = unstructured.NestedInt64(m, "maxActiveSandboxes")
+	if review.MaxActiveSandboxes < 1 {
+		return nil
+	}
+	review.DevcontainerConfigRef, _, _ = unstructured.NestedString(m, "devcontainerConfigRef")
+	review.PullRequests = nestedInt64Slice(m, "pullRequests")
+	return review
+}
+
+func llmPayload(m map[string]interface{}) LLMPayload {
+	var llm LLMPayload
+	llm.Provider, _, _ = unstructured.NestedString(m, "llm", "provider")
+	llm.APIKeySecretRef, _, _ = unstructured.NestedString(m, "llm", "apiKeySecretRef")
+	llm.Prompt, _, _ = unstructured.NestedString(m, "llm", "prompt")
+	llm.ConfigdirRef, _, _ = unstructured.NestedString(m, "llm", "configdirRef")
+	llm.PromptTemplateRef = llmPromptTemplateRef(m)
+	return llm
+}
+
+func nestedInt64Slice(m map[string]interface{}, fields ...string) []int64 {
+	numbers := []int64{}
+	values, _, _ := unstructured.NestedSlice(m, fields...)
+	for _, v := range values {
+		if n, ok := v.(int64); ok {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+func int64Slice(numbers []int64) []interface{} {
+	s := make([]interface{}, 0, len(numbers))
+	for _, n := range numbers {
+		s = append(s, n)
+	}
+	return s
+}
+
+func stringSlice(strs []string) []interface{} {
+	s := make([]interface{}, 0, len(strs))
+	for _, str := range strs {
+		s = append(s, str)
+	}
+	return s
+}
+
+// mergeMap returns the fields of update set on top of existing, if it is a
+// map.
+func mergeMap(existing interface{}, update map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	if m, ok := existing.(map[string]interface{}); ok {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	for k, v := range update {
+		merged[k] = v
+	}
+	return merged
+}
+
+// modifyRepoWatch applies modify to the latest version of a RepoWatch and
+// updates it, retrying on conflicts.
+func modifyRepoWatch(ctx context.Context, namespace, name string, modify func(*unstructured.Unstructured) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := k8sClient.Resource(repoWatchGVR).Namespace(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		before := repoWatchActiveSandboxes(existing)
+		if err := modify(existing); err != nil {
+			return err
+		}
+		// Only raising the sandboxes is checked, so that a RepoWatch over a
+		// lowered quota can still be edited
+		if repoWatchActiveSandboxes(existing) > before {
+			if err := checkRepoWatchQuota(ctx, existing); err != nil {
+				return err
+			}
+		}
+		_, err = k8sClient.Resource(repoWatchGVR).Namespace(namespace).Update(ctx, existing, v1.UpdateOptions{})
+		return err
+	})
+}
+
+// errIssueHandlerExists is returned when creating an issue handler whose name
+// is taken.
+var errIssueHandlerExists = errors.New("issue handler already exists")
+
+// respondModifyError reports an error returned by modifyRepoWatch.
+func respondModifyError(c *gin.Context, err error) {
+	log.Printf("Failed to update RepoWatch: %v", err)
+	status := quotaStatus(err)
+	switch {
+	case errors.Is(err, errIssueHandlerExists):
+		status = http.StatusConflict
+	case apierrors.IsNotFound(err):
+		status = http.StatusNotFound
+	}
+	c.JSON(status, gin.H{"error": fmt.Sprintf("Failed to update RepoWatch: %v", err)})
+}
+
+func getRepoWatchSpec(c *gin.Context) {
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+
+	repoWatch, err := getRepoWatch(c.Request.Context(), namespace, name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	payload := RepoWatchPayload{
+		Name:          name,
+		Namespace:     namespace,
+		Review:        reviewPayload(repoWatch),
+		IssueHandlers: issueHandlerPayloads(repoWatch),
+	}
+	payload.RepoURL, _, _ = unstructured.NestedString(repoWatch.Object, "spec", "repoURL")
+	payload.PollIntervalSeconds, _, _ = unstructured.NestedInt64(repoWatch.Object, "spec", "pollIntervalSeconds")
+	c.JSON(http.StatusOK, payload)
+}
+
+func updateReviewConfig(c *gin.Context) {
+	var payload ReviewPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := payload.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := modifyRepoWatch(c.Request.Context(), c.Param("namespace"), c.Param("name"), payload.apply)
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func deleteReviewConfig(c *gin.Context) {
+	err := modifyRepoWatch(c.Request.Context(), c.Param("namespace"), c.Param("name"), func(obj *unstructured.Unstructured) error {
+		unstructured.RemoveNestedField(obj.Object, "spec", "review")
+		return nil
+	})
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func updateIssueHandlers(c *gin.Context) {
+	var payload []IssueHandlerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateIssueHandlers(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := modifyRepoWatch(c.Request.Context(), c.Param("namespace"), c.Param("name"), func(obj *unstructured.Unstructured) error {
+		return setIssueHandlers(obj, payload)
+	})
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// createIssueHandler adds an issue handler to a RepoWatch, failing with 409 if
+// a handler of the same name exists.
+func createIssueHandler(c *gin.Context) {
+	var payload IssueHandlerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload.setDefaults()
+	if err := payload.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := modifyRepoWatch(c.Request.Context(), c.Param("namespace"), c.Param("name"), func(obj *unstructured.Unstructured) error {
+		handlers := issueHandlerPayloads(obj)
+		for _, h := range handlers {
+			if h.Name == payload.Name {
+				return fmt.Errorf("%w: %s", errIssueHandlerExists, payload.Name)
+			}
+		}
+		return setIssueHandlers(obj, append(handlers, payload))
+	})
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, payload)
+}
+
+func updateIssueHandler(c *gin.Context) {
+	var payload IssueHandlerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload.Name = c.Param("handler")
+	if err := payload.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := modifyRepoWatch(c.Request.Context(), c.Param("namespace"), c.Param("name"), func(obj *unstructured.Unstructured) error {
+		handlers := issueHandlerPayloads(obj)
+		replaced := false
+		for i := range handlers {
+			if handlers[i].Name == payload.Name {
+				handlers[i] = payload
+				replaced = true
+			}
+		}
+		if !replaced {
+			handlers = append(handlers, payload)
+		}
+		return setIssueHandlers(obj, handlers)
+	})
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func deleteIssueHandler(c *gin.Context) {
+	name := c.Param("handler")
+	err := modifyRepoWatch(c.Request.Context(), c.Param("namespace"), c.Param("name"), func(obj *unstructured.Unstructured) error {
+		var handlers []IssueHandlerPayload
+		for _, h := range issueHandlerPayloads(obj) {
+			if h.Name != name {
+				handlers = append(handlers, h)
+			}
+		}
+		return setIssueHandlers(obj, handlers)
+	})
+	if err != nil {
+		respondModifyError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
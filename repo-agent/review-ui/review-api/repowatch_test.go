@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIssueHandlerPayloadValidate(t *testing.T) {
+	valid := func() IssueHandlerPayload {
+		return IssueHandlerPayload{
+			Name:               "triage",
+			MaxActiveSandboxes: 1,
+			Labels:             []string{"bug"},
+			LLM:                LLMPayload{Prompt: "Triage issue {{.Number}}"},
+		}
+	}
+	tests := []struct {
+		name    string
+		modify  func(*IssueHandlerPayload)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(*IssueHandlerPayload) {}},
+		{name: "invalid name", modify: func(h *IssueHandlerPayload) { h.Name = "Bug Fix" }, wantErr: true},
+		{name: "no sandboxes", modify: func(h *IssueHandlerPayload) { h.MaxActiveSandboxes = 0 }, wantErr: true},
+		{name: "missing prompt", modify: func(h *IssueHandlerPayload) { h.LLM.Prompt = " " }, wantErr: true},
+		{name: "invalid template", modify: func(h *IssueHandlerPayload) { h.LLM.Prompt = "{{.Number" }, wantErr: true},
+		{name: "invalid issue", modify: func(h *IssueHandlerPayload) { h.Issues = []int64{-1} }, wantErr: true},
+		{name: "invalid provider", modify: func(h *IssueHandlerPayload) { h.LLM.Provider = "other" }, wantErr: true},
+		{name: "invalid devcontainer", modify: func(h *IssueHandlerPayload) { h.DevcontainerConfigRef = "Go_JSON" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := valid()
+			tt.modify(&h)
+			if err := h.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetIssueHandlersKeepsOtherFields(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"issueHandlers": []interface{}{
+				map[string]interface{}{
+					"name":               "fix",
+					"maxActiveSandboxes": int64(1),
+					"createPR":           true,
+					"llm":                map[string]interface{}{"prompt": "old"},
+				},
+				map[string]interface{}{"name": "removed"},
+			},
+		},
+	}}
+
+	err := setIssueHandlers(obj, []IssueHandlerPayload{{
+		Name:               "fix",
+		MaxActiveSandboxes: 2,
+		LLM:                LLMPayload{Prompt: "new"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := issueHandlerPayloads(obj)
+	if len(handlers) != 1 || handlers[0].MaxActiveSandboxes != 2 || handlers[0].LLM.Prompt != "new" {
+		t.Errorf("issueHandlerPayloads() = %+v, want only the updated fix handler", handlers)
+	}
+	handler, _, _ := unstructured.NestedSlice(obj.Object, "spec", "issueHandlers")
+	if createPR, _, _ := unstructured.NestedBool(handler[0].(map[string]interface{}), "createPR"); !createPR {
+		t.Errorf("createPR was not kept")
+	}
+}
+
+func TestCreateIssueHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldDynamic, oldKube, oldCache := k8sClient, kubeClient, k8sCache
+	defer func() { k8sClient, kubeClient, k8sCache = oldDynamic, oldKube, oldCache }()
+	repoWatch := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "review.gemini.google.com/v1alpha1",
+		"kind":       "RepoWatch",
+		"metadata":   map[string]interface{}{"name": "kubernetes", "namespace": "team-a"},
+		"spec": map[string]interface{}{
+			"issueHandlers": []interface{}{map[string]interface{}{"name": "triage", "maxActiveSandboxes": int64(1)}},
+		},
+	}}
+	k8sClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{repoWatchGVR: "RepoWatchList"})
+	if _, err := k8sClient.Resource(repoWatchGVR).Namespace("team-a").Create(context.Background(), repoWatch, v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	kubeClient = fake.NewSimpleClientset()
+	k8sCache = newTestCache(t, nil)
+
+	create := func(name, body string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "namespace", Value: "team-a"}, {Key: "name", Value: name}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		createIssueHandler(c)
+		return w.Code
+	}
+	fix := `{"name": "fix", "maxActiveSandboxes": 2, "labels": ["bug"], "pushEnabled": true, "llm": {"prompt": "Fix the issue."}}`
+	if code := create("kubernetes", fix); code != http.StatusCreated {
+		t.Fatalf("create handler = %d, want %d", code, http.StatusCreated)
+	}
+	updated, err := k8sClient.Resource(repoWatchGVR).Namespace("team-a").Get(context.Background(), "kubernetes", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers := issueHandlerPayloads(updated)
+	if len(handlers) != 2 || handlers[1].Name != "fix" || !handlers[1].PushEnabled || handlers[1].LLM.APIKeySecretRef != defaultAPIKeySecretRef {
+		t.Errorf("handlers = %+v, want triage and fix with the default API key", handlers)
+	}
+
+	if code := create("kubernetes", fix); code != http.StatusConflict {
+		t.Errorf("create existing handler = %d, want %d", code, http.StatusConflict)
+	}
+	if code := create("kubernetes", `{"name": "Fix", "maxActiveSandboxes": 1, "llm": {"prompt": "x"}}`); code != http.StatusBadRequest {
+		t.Errorf("create invalid handler = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := create("missing", fix); code != http.StatusNotFound {
+		t.Errorf("create handler of missing RepoWatch = %d, want %d", code, http.StatusNotFound)
+	}
+}
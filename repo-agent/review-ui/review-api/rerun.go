@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+// basePromptAnnotation keeps the prompt generated by the controller so that
+// a prompt override only applies to a single rerun.
+const basePromptAnnotation = "review.gemini.google.com/base-prompt"
+
+// rerunPayload is the optional body of the rerun endpoints.
+type rerunPayload struct {
+	// Prompt is appended to the sandbox's prompt for this run only.
+	Prompt string `json:"prompt"`
+}
+
+func rerunPR(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	prID := c.Param("id")
+	sandbox := k8sCache.findSandbox(reviewSandboxGVR, namespace, repo, "", "pr", prID)
+	if !rerunSandbox(c, reviewSandboxGVR, sandbox) {
+		return
+	}
+	// Show the new agent draft instead of the user's edits of the old one
+	if err := store.HDel(c.Request.Context(), prKey(repo, prID), "draft"); err != nil {
+		log.Printf("Failed to clear draft of PR %s in repo %s: %v", prID, repo, err)
+	}
+	c.Status(http.StatusOK)
+}
+
+func rerunIssue(c *gin.Context) {
+	namespace := c.Param("namespace")
+	repo := c.Param("repo")
+	issueID := c.Param("issue_id")
+	handler := c.Param("handler")
+	sandbox := k8sCache.findSandbox(issueSandboxGVR, namespace, repo, handler, "issue", issueID)
+	if !rerunSandbox(c, issueSandboxGVR, sandbox) {
+		return
+	}
+	if err := store.HDel(c.Request.Context(), issueKey(repo, handler, issueID), "draft"); err != nil {
+		log.Printf("Failed to clear draft of Issue %s in repo %s: %v", issueID, repo, err)
+	}
+	c.Status(http.StatusOK)
+}
+
+// rerunSandbox forces a fresh agent run in a sandbox, with the prompt
+// override of the request if any. A scaled down sandbox is scaled back up and
+// the pod of a running one is deleted so that it is recreated. It responds
+// with an error and returns false on failure.
+func rerunSandbox(c *gin.Context, gvr schema.GroupVersionResource, cached *unstructured.Unstructured) bool {
+	if cached == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sandbox not found"})
+		return false
+	}
+	var payload rerunPayload
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+
+	ctx := c.Request.Context()
+	namespace := cached.GetNamespace()
+	name := cached.GetName()
+	var wasRunning bool
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		sandbox, err := k8sClient.Resource(gvr).Namespace(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		replicas, _, _ := unstructured.NestedInt64(sandbox.Object, "spec", "replicas")
+		wasRunning = replicas > 0
+		if !wasRunning {
+			if err := checkScaleUpQuota(ctx, namespace); err != nil {
+				return err
+			}
+		}
+
+		annotations := sandbox.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		basePrompt, ok := annotations[basePromptAnnotation]
+		if !ok {
+			basePrompt, _, _ = unstructured.NestedString(sandbox.Object, "spec", "llm", "prompt")
+			annotations[basePromptAnnotation] = basePrompt
+		}
+		prompt := basePrompt
+		if payload.Prompt != "" {
+			prompt = fmt.Sprintf("%s\n\nAdditional instructions for this run:\n%s", basePrompt, payload.Prompt)
+		}
+		// Drop the previous run's draft and error, the sidecar publishes the
+		// new ones
+		delete(annotations, "agentDraft")
+		delete(annotations, "agentError")
+		sandbox.SetAnnotations(annotations)
+
+		if err := unstructured.SetNestedField(sandbox.Object, prompt, "spec", "llm", "prompt"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(sandbox.Object, int64(1), "spec", "replicas"); err != nil {
+			return err
+		}
+		_, err = k8sClient.Resource(gvr).Namespace(namespace).Update(ctx, sandbox, v1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to update sandbox %s for rerun: %v", name, err)
+		c.JSON(quotaStatus(err), gin.H{"error": fmt.Sprintf("Failed to rerun sandbox: %v", err)})
+		return false
+	}
+
+	if wasRunning {
+		if err := deleteSandboxPods(ctx, namespace, name); err != nil {
+			log.Printf("Failed to restart sandbox %s: %v", name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to restart sandbox: %v", err)})
+			return false
+		}
+	}
+	log.Printf("Rerunning sandbox %s", name)
+	return true
+}
+
+// deleteSandboxPods deletes the pods of a sandbox, which are recreated by the
+// Sandbox controller. They are deleted by name so that the API needs no
+// deletecollection on pods.
+func deleteSandboxPods(ctx context.Context, namespace, name string) error {
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{LabelSelector: "sandbox=devc-" + name})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		err := kubeClient.CoreV1().Pods(namespace).Delete(ctx, pod.Name, v1.DeleteOptions{Preconditions: v1.NewUIDPreconditions(string(pod.UID))})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// codeOwnersPaths are where GitHub looks for the CODEOWNERS file of a
+// branch, in order.
+var codeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// reviewersConfig is spec.review.reviewers of a RepoWatch.
+type reviewersConfig struct {
+	codeOwners bool
+	pool       []string
+	count      int
+}
+
+func getReviewersConfig(repoWatch *unstructured.Unstructured) reviewersConfig {
+	cfg := reviewersConfig{count: 1}
+	cfg.codeOwners, _, _ = unstructured.NestedBool(repoWatch.Object, "spec", "review", "reviewers", "codeOwners")
+	cfg.pool, _, _ = unstructured.NestedStringSlice(repoWatch.Object, "spec", "review", "reviewers", "pool")
+	if count, found, _ := unstructured.NestedInt64(repoWatch.Object, "spec", "review", "reviewers", "count"); found && count > 0 {
+		cfg.count = int(count)
+	}
+	return cfg
+}
+
+func (cfg reviewersConfig) enabled() bool {
+	return cfg.codeOwners || len(cfg.pool) > 0
+}
+
+// requestReviewers requests the reviews of a PR from its code owners and from
+// the pool of reviewers configured in a RepoWatch, and returns who was
+// requested: users by login and teams as org/team. The author of the PR is
+// never requested.
+func requestReviewers(ctx context.Context, client *github.Client, cfg reviewersConfig, owner, repo string, number int) ([]string, *github.Response, error) {
+	pr, resp, err := client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return nil, resp, fmt.Errorf("failed to get pr: %w", err)
+	}
+	author := pr.GetUser().GetLogin()
+
+	var candidates []string
+	if cfg.codeOwners {
+		owners, resp, err := prCodeOwners(ctx, client, owner, repo, number, pr.GetBase().GetRef())
+		if err != nil {
+			return nil, resp, err
+		}
+		candidates = append(candidates, owners...)
+	}
+	candidates = append(candidates, pickFromPool(cfg.pool, cfg.count, number, author)...)
+
+	var reviewers []string
+	request := github.ReviewersRequest{}
+	seen := map[string]bool{}
+	for _, reviewer := range candidates {
+		key := strings.ToLower(reviewer)
+		if seen[key] || strings.EqualFold(reviewer, author) {
+			continue
+		}
+		seen[key] = true
+		if _, team, ok := strings.Cut(reviewer, "/"); ok {
+			request.TeamReviewers = append(request.TeamReviewers, team)
+		} else {
+			request.Reviewers = append(request.Reviewers, reviewer)
+		}
+		reviewers = append(reviewers, reviewer)
+	}
+	if len(reviewers) == 0 {
+		return nil, nil, nil
+	}
+	if _, resp, err := client.PullRequests.RequestReviewers(ctx, owner, repo, number, request); err != nil {
+		return nil, resp, fmt.Errorf("failed to request reviewers: %w", err)
+	}
+	return reviewers, nil, nil
+}
+
+// pickFromPool returns count reviewers of the pool other than the author, in
+// turn by PR number so that reviews are spread over the pool.
+func pickFromPool(pool []string, count, number int, author string) []string {
+	var picked []string
+	for i := 0; i < len(pool) && len(picked) < count; i++ {
+		reviewer := strings.TrimPrefix(pool[(number+i)%len(pool)], "@")
+		if reviewer != "" && !strings.EqualFold(reviewer, author) {
+			picked = append(picked, reviewer)
+		}
+	}
+	return picked
+}
+
+// prCodeOwners returns the owners of the files changed by a PR, in the
+// CODEOWNERS file of its base branch. There are none if the file is missing.
+func prCodeOwners(ctx context.Context, client *github.Client, owner, repo string, number int, base string) ([]string, *github.Response, error) {
+	var rules []codeOwnersRule
+	for _, path := range codeOwnersPaths {
+		file, _, resp, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: base})
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, resp, fmt.Errorf("failed to get %s: %w", path, err)
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		rules = parseCodeOwners(content)
+		break
+	}
+	if len(rules) == 0 {
+		return nil, nil, nil
+	}
+
+	var owners []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, resp, fmt.Errorf("failed to list pr files: %w", err)
+		}
+		for _, file := range files {
+			owners = append(owners, codeOwnersOf(rules, file.GetFilename())...)
+		}
+		if resp.NextPage == 0 {
+			return owners, nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// codeOwnersRule is a line of a CODEOWNERS file.
+type codeOwnersRule struct {
+	pattern *regexp.Regexp
+	// owners are users by login and teams as org/team. Owners by email can't
+	// be requested and are left out.
+	owners []string
+}
+
+// parseCodeOwners returns the rules of a CODEOWNERS file. Invalid lines are
+// skipped, as GitHub does.
+func parseCodeOwners(content string) []codeOwnersRule {
+	var rules []codeOwnersRule
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pattern, err := codeOwnersPattern(fields[0])
+		if err != nil {
+			continue
+		}
+		rule := codeOwnersRule{pattern: pattern}
+		for _, owner := range fields[1:] {
+			if strings.HasPrefix(owner, "@") {
+				rule.owners = append(rule.owners, strings.TrimPrefix(owner, "@"))
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// codeOwnersPattern compiles a gitignore style CODEOWNERS pattern to a
+// regexp of the paths it owns. Patterns with a leading or inner slash are
+// relative to the root of the repo, others match at any depth. The contents
+// of matched directories are owned too, except for patterns ending in /*.
+func codeOwnersPattern(pattern string) (*regexp.Regexp, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	trimmed := strings.Trim(pattern, "/")
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	var b strings.Builder
+	if strings.HasPrefix(pattern, "/") || strings.Contains(trimmed, "/") {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(trimmed); i++ {
+		switch {
+		case strings.HasPrefix(trimmed[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(trimmed[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case trimmed[i] == '*':
+			b.WriteString("[^/]*")
+		case trimmed[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(trimmed[i : i+1]))
+		}
+	}
+	switch {
+	case dirOnly:
+		b.WriteString("/.*$")
+	case strings.HasSuffix(trimmed, "/*"):
+		b.WriteString("$")
+	default:
+		b.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(b.String())
+}
+
+// codeOwnersOf returns the owners of a file: those of the last rule matching
+// it.
+func codeOwnersOf(rules []codeOwnersRule, path string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].pattern.MatchString(path) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
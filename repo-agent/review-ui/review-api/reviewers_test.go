@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCodeOwnersOf(t *testing.T) {
+	rules := parseCodeOwners(`# Owners of the repo
+*                 @global
+*.go              @org/go-reviewers owner@example.com
+/docs/            @writer
+api/*             @api
+**/testdata       @tester
+repowatch/api/    # unowned
+`)
+	for path, want := range map[string][]string{
+		"README.md":                     {"global"},
+		"main.go":                       {"org/go-reviewers"},
+		"pkg/llm/gemini.go":             {"org/go-reviewers"},
+		"docs/index.md":                 {"writer"},
+		"docs/guides/setup.md":          {"writer"},
+		"pkg/docs/index.md":             {"global"},
+		"api/types.go":                  {"api"},
+		"api/v1/types.go":               {"org/go-reviewers"},
+		"pkg/testdata/diff.patch":       {"tester"},
+		"repowatch/api/v1alpha1/doc.go": nil,
+	} {
+		if got := codeOwnersOf(rules, path); !reflect.DeepEqual(got, want) {
+			t.Errorf("codeOwnersOf(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestPickFromPool(t *testing.T) {
+	pool := []string{"alice", "@bob", "org/team"}
+	for _, tt := range []struct {
+		count, number int
+		author        string
+		want          []string
+	}{
+		{count: 1, number: 3, want: []string{"alice"}},
+		{count: 1, number: 4, want: []string{"bob"}},
+		{count: 2, number: 5, want: []string{"org/team", "alice"}},
+		{count: 1, number: 4, author: "Bob", want: []string{"org/team"}},
+		{count: 5, number: 0, author: "alice", want: []string{"bob", "org/team"}},
+	} {
+		if got := pickFromPool(pool, tt.count, tt.number, tt.author); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pickFromPool(%d, %d, %q) = %v, want %v", tt.count, tt.number, tt.author, got, tt.want)
+		}
+	}
+}
+
+func TestRequestReviewers(t *testing.T) {
+	codeOwners := base64.StdEncoding.EncodeToString([]byte("*.go @alice @author\n"))
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v3/repos/test/repo/pulls/1":
+			w.Write([]byte(`{"user": {"login": "author"}, "base": {"ref": "main"}}`))
+		case "GET /api/v3/repos/test/repo/contents/CODEOWNERS":
+			if r.URL.Query().Get("ref") != "main" {
+				t.Errorf("CODEOWNERS read at %q, want main", r.URL.Query().Get("ref"))
+			}
+			w.Write([]byte(`{"type": "file", "encoding": "base64", "content": "` + codeOwners + `"}`))
+		case "GET /api/v3/repos/test/repo/pulls/1/files":
+			w.Write([]byte(`[{"filename": "main.go"}, {"filename": "README.md"}]`))
+		case "POST /api/v3/repos/test/repo/pulls/1/requested_reviewers":
+			body, _ := io.ReadAll(r.Body)
+			requested = string(body)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	client, err := newGitHubAPIClient(&http.Client{}, srv.URL+"/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := reviewersConfig{codeOwners: true, pool: []string{"alice", "org/team"}, count: 1}
+	reviewers, _, err := requestReviewers(context.Background(), client, cfg, "test", "repo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alice", "org/team"}; !reflect.DeepEqual(reviewers, want) {
+		t.Errorf("requestReviewers() = %v, want %v", reviewers, want)
+	}
+	if want := `{"reviewers":["alice"],"team_reviewers":["team"]}` + "\n"; requested != want {
+		t.Errorf("requested reviewers = %s, want %s", requested, want)
+	}
+
+	// Nobody is requested if the author is the only candidate
+	requested = ""
+	cfg = reviewersConfig{pool: []string{"author"}, count: 1}
+	if reviewers, _, err := requestReviewers(context.Background(), client, cfg, "test", "repo", 1); err != nil || reviewers != nil {
+		t.Errorf("requestReviewers() = %v, %v, want none", reviewers, err)
+	}
+	if requested != "" {
+		t.Errorf("requested reviewers = %s, want none", requested)
+	}
+}
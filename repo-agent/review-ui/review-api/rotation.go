@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/envelope"
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// githubTokenExpirationHeader is set by GitHub on the responses to
+	// requests authenticated with a PAT that expires, e.g.
+	// "2025-06-30 12:00:00 UTC".
+	githubTokenExpirationHeader = "GitHub-Authentication-Token-Expiration"
+	// credentialAnnotationPrefix prefixes the <kind>-saved-at and
+	// <kind>-expires-at annotations of the credential secrets.
+	credentialAnnotationPrefix = "review.gemini.google.com/"
+	// githubAppLabel marks the secrets whose pat is an installation token of a
+	// GitHub App, rotated by runCredentialRotation.
+	githubAppLabel = "review.gemini.google.com/github-app"
+	// rotationErrorAnnotation is the error of the last failed rotation.
+	rotationErrorAnnotation = "review.gemini.google.com/rotation-error"
+	// rotationWindow is how long before they expire installation tokens are
+	// rotated. They are valid for an hour.
+	rotationWindow = 15 * time.Minute
+)
+
+// githubAppSecretKeys are the keys of a GitHub App in its secret, next to the
+// pat of the current installation token.
+var githubAppSecretKeys = []string{"appID", "installationID", "privateKey"}
+
+var (
+	// credentialMaxAge is the age past which credentials that don't expire
+	// should be rotated, CREDENTIAL_MAX_AGE_DAYS.
+	credentialMaxAge = time.Duration(envInt("CREDENTIAL_MAX_AGE_DAYS", 90)) * 24 * time.Hour
+	// credentialExpiryWarning is how long before a PAT expires it is warned
+	// about, CREDENTIAL_EXPIRY_WARNING_DAYS.
+	credentialExpiryWarning = time.Duration(envInt("CREDENTIAL_EXPIRY_WARNING_DAYS", 7)) * 24 * time.Hour
+)
+
+// GitHubAppCredentialPayload is a GitHub App installation whose tokens are
+// stored, and rotated before they expire, in the secret of a namespace.
+type GitHubAppCredentialPayload struct {
+	AppID          int64 `json:"appID" binding:"required"`
+	InstallationID int64 `json:"installationID" binding:"required"`
+	// PrivateKey is the PEM encoded private key of the app
+	PrivateKey string `json:"privateKey" binding:"required"`
+	// Secret is the name of the secret, github-pat if empty
+	Secret string `json:"secret,omitempty"`
+}
+
+// CredentialInfo is the age and expiration of a credential of a namespace.
+type CredentialInfo struct {
+	// Kind is github, githubApp or gemini
+	Kind   string `json:"kind"`
+	Secret string `json:"secret"`
+	// SavedAt is when the credential was saved or rotated through the API,
+	// unknown for credentials stored otherwise
+	SavedAt   *time.Time `json:"savedAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Warnings  []string   `json:"warnings,omitempty"`
+}
+
+// parseTokenExpiration parses the githubTokenExpirationHeader, nil if it is
+// empty or invalid.
+func parseTokenExpiration(value string) *time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// setCredentialDates records when the credential kind of a secret was saved
+// and when it expires, if it does.
+func setCredentialDates(secret *corev1.Secret, kind string, savedAt time.Time, expiresAt *time.Time) {
+	secret.Annotations[credentialAnnotationPrefix+kind+"-saved-at"] = savedAt.UTC().Format(time.RFC3339)
+	if expiresAt != nil {
+		secret.Annotations[credentialAnnotationPrefix+kind+"-expires-at"] = expiresAt.UTC().Format(time.RFC3339)
+	} else {
+		delete(secret.Annotations, credentialAnnotationPrefix+kind+"-expires-at")
+	}
+}
+
+// credentialDate returns the date of an annotation, nil if it is unset.
+func credentialDate(secret *corev1.Secret, annotation string) *time.Time {
+	t, err := time.Parse(time.RFC3339, secret.Annotations[credentialAnnotationPrefix+annotation])
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// credentialInfo returns the age and expiration of the credential kind of a
+// secret, with the warnings due at now.
+func credentialInfo(secret *corev1.Secret, kind string, now time.Time) CredentialInfo {
+	info := CredentialInfo{
+		Kind:      kind,
+		Secret:    secret.Name,
+		SavedAt:   credentialDate(secret, kind+"-saved-at"),
+		ExpiresAt: credentialDate(secret, kind+"-expires-at"),
+	}
+	if kind == "github" && secret.Labels[githubAppLabel] == "true" {
+		info.Kind = "githubApp"
+	}
+
+	switch {
+	case info.ExpiresAt != nil && !now.Before(*info.ExpiresAt):
+		info.Warnings = append(info.Warnings, fmt.Sprintf("The token expired on %s", info.ExpiresAt.Format(time.RFC1123)))
+	case info.Kind == "githubApp":
+		// Installation tokens expire within the hour and are rotated
+	case info.ExpiresAt != nil && info.ExpiresAt.Sub(now) < credentialExpiryWarning:
+		info.Warnings = append(info.Warnings, fmt.Sprintf("The token expires in %s, replace it", humanDuration(info.ExpiresAt.Sub(now))))
+	case info.ExpiresAt == nil && info.SavedAt != nil && now.Sub(*info.SavedAt) > credentialMaxAge:
+		info.Warnings = append(info.Warnings, fmt.Sprintf("The credential was saved %s ago, rotate it", humanDuration(now.Sub(*info.SavedAt))))
+	}
+	if msg := secret.Annotations[rotationErrorAnnotation]; msg != "" && info.Kind == "githubApp" {
+		info.Warnings = append(info.Warnings, "The last rotation failed: "+msg)
+	}
+	return info
+}
+
+// humanDuration formats d in days, or hours under two days.
+func humanDuration(d time.Duration) string {
+	if d < 48*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+// credentialSecrets returns the secrets of the GitHub and Gemini credentials
+// of a namespace: the defaults and those its RepoWatches reference.
+func credentialSecrets(namespace string) (githubSecrets, geminiSecrets []string) {
+	github := map[string]bool{defaultGithubSecretName: true}
+	gemini := map[string]bool{defaultAPIKeySecretRef: true}
+	for _, repoWatch := range k8sCache.list(repoWatchGVR, namespace, labels.Everything()) {
+		if name, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "githubSecretName"); name != "" {
+			github[name] = true
+		}
+		if name, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "review", "llm", "apiKeySecretRef"); name != "" {
+			gemini[name] = true
+		}
+		handlers, _, _ := unstructured.NestedSlice(repoWatch.Object, "spec", "issueHandlers")
+		for _, h := range handlers {
+			handler, ok := h.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if name, _, _ := unstructured.NestedString(handler, "llm", "apiKeySecretRef"); name != "" {
+				gemini[name] = true
+			}
+		}
+	}
+	return sortedKeys(github), sortedKeys(gemini)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// namespaceCredentials returns the credentials of a namespace with their
+// warnings, for /api/settings.
+func namespaceCredentials(ctx context.Context, namespace string) ([]CredentialInfo, error) {
+	now := time.Now()
+	githubSecrets, geminiSecrets := credentialSecrets(namespace)
+	credentials := []CredentialInfo{}
+	for _, secrets := range []struct {
+		kind, key string
+		names     []string
+	}{
+		{"github", "pat", githubSecrets},
+		{"gemini", geminiSecretKey, geminiSecrets},
+	} {
+		for _, name := range secrets.names {
+			secret, err := kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, v1.GetOptions{})
+			if k8serrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := secret.Data[secrets.key]; ok {
+				credentials = append(credentials, credentialInfo(secret, secrets.kind, now))
+			}
+		}
+	}
+	return credentials, nil
+}
+
+// parsePrivateKey parses the PEM encoded RSA private key of a GitHub App.
+func parsePrivateKey(pemKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// githubAppJWT returns the JWT authenticating as a GitHub App, valid for
+// less than the 10 minutes GitHub allows.
+func githubAppJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		// Backdated against clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+// newGitHubAppClient returns a client authenticated as a GitHub App for the
+// global GitHub API.
+func newGitHubAppClient(ctx context.Context, appID int64, key *rsa.PrivateKey) (*github.Client, error) {
+	jwt, err := githubAppJWT(appID, key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt})
+	return newGitHubAPIClient(oauth2.NewClient(ctx, ts), githubAPIURL, githubUploadURL)
+}
+
+// createInstallationToken returns a new token of a GitHub App installation.
+func createInstallationToken(ctx context.Context, appID, installationID int64, key *rsa.PrivateKey) (*github.InstallationToken, error) {
+	client, err := newGitHubAppClient(ctx, appID, key)
+	if err != nil {
+		return nil, err
+	}
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create an installation token: %w", err)
+	}
+	return token, nil
+}
+
+// saveGitHubAppCredential validates a GitHub App installation and stores it
+// with its first token in the secret RepoWatches reference in
+// githubSecretName. The commits of the sandboxes are authored by the bot of
+// the app.
+func saveGitHubAppCredential(c *gin.Context) {
+	ctx := c.Request.Context()
+	namespace := c.Param("namespace")
+	var payload GitHubAppCredentialPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Secret == "" {
+		payload.Secret = defaultGithubSecretName
+	}
+	privateKey := strings.TrimSpace(payload.PrivateKey) + "\n"
+	key, err := parsePrivateKey([]byte(privateKey))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	client, err := newGitHubAppClient(ctx, payload.AppID, key)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	app, _, err := client.Apps.Get(ctx, "")
+	if err != nil {
+		var ghErr *github.ErrorResponse
+		if errors.As(err, &ghErr) && ghErr.Response.StatusCode == http.StatusUnauthorized {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "GitHub rejected the app ID or private key"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to validate the app with GitHub: %v", err)})
+		return
+	}
+	token, err := createInstallationToken(ctx, payload.AppID, payload.InstallationID, key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to get a token of installation %d: %v", payload.InstallationID, err)})
+		return
+	}
+
+	login := app.GetSlug() + "[bot]"
+	email := login + "@users.noreply.github.com"
+	if tokenClient, err := newGitHubClient(ctx, nil, token.GetToken()); err == nil {
+		// The commits are attributed to the bot by its noreply address
+		if bot, _, err := tokenClient.Users.Get(ctx, login); err == nil {
+			email = fmt.Sprintf("%d+%s", bot.GetID(), email)
+		}
+	}
+	sealedToken, err := sealCredential(ctx, token.GetToken())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	sealedKey, err := sealCredential(ctx, privateKey)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	expiresAt := token.GetExpiresAt()
+	err = saveCredentialSecret(ctx, namespace, payload.Secret, "github", func(secret *corev1.Secret) {
+		secret.Data["pat"] = sealedToken
+		secret.Data["name"] = []byte(login)
+		secret.Data["email"] = []byte(email)
+		secret.Data["appID"] = []byte(strconv.FormatInt(payload.AppID, 10))
+		secret.Data["installationID"] = []byte(strconv.FormatInt(payload.InstallationID, 10))
+		secret.Data["privateKey"] = sealedKey
+		secret.Labels[githubAppLabel] = "true"
+		delete(secret.Annotations, rotationErrorAnnotation)
+		setCredentialDates(secret, "github", time.Now(), &expiresAt)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, CredentialStatus{Secret: payload.Secret, Login: login, Encrypted: secretKMS != nil, ExpiresAt: &expiresAt})
+}
+
+// rotateGitHubAppToken replaces the installation token of a GitHub App secret
+// if it expires within rotationWindow of now.
+func rotateGitHubAppToken(ctx context.Context, secret *corev1.Secret, now time.Time) (bool, error) {
+	if expiresAt := credentialDate(secret, "github-expires-at"); expiresAt != nil && expiresAt.Sub(now) > rotationWindow {
+		return false, nil
+	}
+	appID, err := strconv.ParseInt(string(secret.Data["appID"]), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid appID: %w", err)
+	}
+	installationID, err := strconv.ParseInt(string(secret.Data["installationID"]), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid installationID: %w", err)
+	}
+	pemKey, err := envelope.Open(ctx, secretKMS, secret.Data["privateKey"])
+	if err != nil {
+		return false, err
+	}
+	key, err := parsePrivateKey(pemKey)
+	if err != nil {
+		return false, err
+	}
+	token, err := createInstallationToken(ctx, appID, installationID, key)
+	if err != nil {
+		return false, err
+	}
+	sealed, err := sealCredential(ctx, token.GetToken())
+	if err != nil {
+		return false, err
+	}
+	secret.Data["pat"] = sealed
+	expiresAt := token.GetExpiresAt()
+	setCredentialDates(secret, "github", now, &expiresAt)
+	return true, nil
+}
+
+// rotateGitHubAppTokens rotates the installation tokens of the GitHub App
+// secrets of all namespaces expiring soon. Failures are recorded on the
+// secrets, for /api/settings to warn about them.
+func rotateGitHubAppTokens(ctx context.Context, now time.Time) {
+	secrets, err := kubeClient.CoreV1().Secrets("").List(ctx, v1.ListOptions{LabelSelector: githubAppLabel + "=true," + managedByLabel + "=" + managedBy})
+	if err != nil {
+		log.Printf("Failed to list GitHub App secrets: %v", err)
+		return
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		rotated, err := rotateGitHubAppToken(ctx, secret, now)
+		switch {
+		case err != nil:
+			log.Printf("Failed to rotate the token of secret %s/%s: %v", secret.Namespace, secret.Name, err)
+			if secret.Annotations[rotationErrorAnnotation] == err.Error() {
+				continue
+			}
+			secret.Annotations[rotationErrorAnnotation] = err.Error()
+		case rotated:
+			delete(secret.Annotations, rotationErrorAnnotation)
+		default:
+			continue
+		}
+		// Replicas rotating the same secret conflict, one of them wins
+		if _, err := kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, v1.UpdateOptions{}); err != nil && !k8serrors.IsConflict(err) {
+			log.Printf("Failed to update secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+	}
+}
+
+// runCredentialRotation rotates the GitHub App tokens every interval, until
+// ctx is done. The controller reads the new token at its next reconcile.
+// Running sandboxes keep the token they started with.
+func runCredentialRotation(ctx context.Context, interval time.Duration) {
+	rotateGitHubAppTokens(ctx, time.Now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rotateGitHubAppTokens(ctx, now)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseTokenExpiration(t *testing.T) {
+	want := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2025-06-30 12:00:00 UTC", "2025-06-30 14:00:00 +0200"} {
+		if got := parseTokenExpiration(value); got == nil || !got.Equal(want) {
+			t.Errorf("parseTokenExpiration(%q) = %v, want %v", value, got, want)
+		}
+	}
+	if got := parseTokenExpiration(""); got != nil {
+		t.Errorf("parseTokenExpiration(\"\") = %v, want nil", got)
+	}
+}
+
+func TestCredentialInfo(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	secret := func(annotations map[string]string, app bool) *corev1.Secret {
+		s := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: "github-pat", Annotations: annotations, Labels: map[string]string{}}}
+		if app {
+			s.Labels[githubAppLabel] = "true"
+		}
+		return s
+	}
+	day := func(d int) string { return now.AddDate(0, 0, d).Format(time.RFC3339) }
+	tests := []struct {
+		name        string
+		secret      *corev1.Secret
+		kind        string
+		wantKind    string
+		wantWarning string
+	}{
+		{"recent", secret(map[string]string{"review.gemini.google.com/gemini-saved-at": day(-10)}, false), "gemini", "gemini", ""},
+		{"old", secret(map[string]string{"review.gemini.google.com/gemini-saved-at": day(-100)}, false), "gemini", "gemini", "saved 100 days ago"},
+		{"unknown age", secret(nil, false), "github", "github", ""},
+		{"expiring", secret(map[string]string{"review.gemini.google.com/github-expires-at": day(3)}, false), "github", "github", "expires in 3 days"},
+		{"expired", secret(map[string]string{"review.gemini.google.com/github-expires-at": day(-1)}, false), "github", "github", "expired"},
+		{"app", secret(map[string]string{"review.gemini.google.com/github-expires-at": now.Add(time.Hour).Format(time.RFC3339)}, true), "github", "githubApp", ""},
+		{"app rotation failed", secret(map[string]string{rotationErrorAnnotation: "bad key"}, true), "github", "githubApp", "rotation failed: bad key"},
+	}
+	for _, tt := range tests {
+		info := credentialInfo(tt.secret, tt.kind, now)
+		if info.Kind != tt.wantKind {
+			t.Errorf("%s: kind = %s, want %s", tt.name, info.Kind, tt.wantKind)
+		}
+		warnings := strings.Join(info.Warnings, "\n")
+		if (tt.wantWarning == "") != (warnings == "") || !strings.Contains(warnings, tt.wantWarning) {
+			t.Errorf("%s: warnings = %q, want %q", tt.name, warnings, tt.wantWarning)
+		}
+	}
+}
+
+func TestRotateGitHubAppTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v3/app/installations/7/access_tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// The JWT must be signed by the private key of the app
+		parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token": "ghs_new", "expires_at": "` + expiresAt.Format(time.RFC3339) + `"}`))
+	}))
+	defer gh.Close()
+
+	oldKube, oldURL := kubeClient, githubAPIURL
+	defer func() { kubeClient, githubAPIURL = oldKube, oldURL }()
+	githubAPIURL = gh.URL + "/"
+	appSecret := func(name, expiresAt string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        name,
+				Namespace:   "team-a",
+				Labels:      map[string]string{githubAppLabel: "true", managedByLabel: managedBy},
+				Annotations: map[string]string{"review.gemini.google.com/github-expires-at": expiresAt},
+			},
+			Data: map[string][]byte{"pat": []byte("ghs_old"), "appID": []byte("1"), "installationID": []byte("7"), "privateKey": pemKey},
+		}
+	}
+	now := time.Now()
+	unmanaged := appSecret("unmanaged", now.Add(5*time.Minute).Format(time.RFC3339))
+	delete(unmanaged.Labels, managedByLabel)
+	kubeClient = fake.NewSimpleClientset(
+		appSecret("expiring", now.Add(5*time.Minute).Format(time.RFC3339)),
+		appSecret("fresh", now.Add(time.Hour).Format(time.RFC3339)),
+		unmanaged,
+	)
+	broken := appSecret("broken", "")
+	broken.Data["installationID"] = []byte("8")
+	kubeClient.CoreV1().Secrets("team-a").Create(context.Background(), broken, v1.CreateOptions{})
+
+	rotateGitHubAppTokens(context.Background(), now)
+
+	get := func(name string) *corev1.Secret {
+		secret, err := kubeClient.CoreV1().Secrets("team-a").Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	if secret := get("expiring"); string(secret.Data["pat"]) != "ghs_new" || !credentialDate(secret, "github-expires-at").Equal(expiresAt) {
+		t.Errorf("expiring secret = %q %v, want the new token", secret.Data["pat"], secret.Annotations)
+	}
+	if secret := get("fresh"); string(secret.Data["pat"]) != "ghs_old" {
+		t.Errorf("fresh secret pat = %q, want it kept", secret.Data["pat"])
+	}
+	if secret := get("unmanaged"); string(secret.Data["pat"]) != "ghs_old" {
+		t.Errorf("unmanaged secret pat = %q, want it kept", secret.Data["pat"])
+	}
+	if secret := get("broken"); secret.Annotations[rotationErrorAnnotation] == "" {
+		t.Errorf("broken secret annotations = %v, want the rotation error", secret.Annotations)
+	}
+}
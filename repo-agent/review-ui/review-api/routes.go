@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// apiRoute is a route under /api. Both the router and the OpenAPI spec served
+// at /api/openapi.json are built from apiRoutes, so that they can't drift.
+type apiRoute struct {
+	method  string
+	path    string
+	handler gin.HandlerFunc
+	// role is the role required to call the route, roleNone if it is open
+	// to anyone
+	role role
+	// audit is the action recorded in the audit log, if any
+	audit   string
+	summary string
+	// query are the query parameters of the route
+	query []string
+	// request and response are values of the types of the JSON bodies, nil
+	// if there is none
+	request  interface{}
+	response interface{}
+	// status is the status of a successful response, 200 if not set
+	status int
+	// contentType is the type of a non JSON response
+	contentType string
+}
+
+// draftPayload is a user draft of a review or comment.
+type draftPayload struct {
+	Draft string `json:"draft"`
+}
+
+// reviewSubmission is a review to post on a PR.
+type reviewSubmission struct {
+	Review string `json:"review"`
+}
+
+// commentSubmission is a comment to post on an issue.
+type commentSubmission struct {
+	Comment string `json:"comment"`
+}
+
+// bulkResponse is the outcome of a bulk action.
+type bulkResponse struct {
+	Results []BulkResult `json:"results"`
+	// Failed is the number of items the action failed for
+	Failed int `json:"failed"`
+}
+
+// errorResponse is the body of error responses.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{method: "GET", path: "/repos", handler: getRepos, role: roleViewer, summary: "List the RepoWatches", response: []Repo{}},
+		{method: "GET", path: "/repo/:namespace/:repo/prs", handler: getPRs, role: roleViewer, summary: "List the PRs under review", response: []PR{}},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/bulk", handler: bulkPRs, role: roleReviewer, audit: "pr.bulk", summary: "Scale down, delete or submit PRs at once", request: bulkPayload{}, response: bulkResponse{}},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/:id/draft", handler: saveDraft, role: roleReviewer, audit: "pr.draft.save", summary: "Save the draft review of a PR", request: draftPayload{}},
+		{method: "GET", path: "/repo/:namespace/:repo/prs/:id/drafts", handler: getPRDraftHistory, role: roleViewer, summary: "List the saved drafts of a PR", response: []DraftVersion{}},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/:id/drafts/:version/restore", handler: restorePRDraft, role: roleReviewer, audit: "pr.draft.restore", summary: "Restore a saved draft of a PR", response: draftPayload{}},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/:id/submitreview", handler: submitReview, role: roleReviewer, audit: "pr.review.submit", summary: "Post a review on a PR, or request its approval", request: reviewSubmission{}},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/:id/approve", handler: approveReview, role: roleApprover, audit: "pr.review.approve", summary: "Approve and post the pending review of a PR"},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/:id/reject", handler: rejectReview, role: roleApprover, audit: "pr.review.reject", summary: "Reject the pending review of a PR"},
+		{method: "GET", path: "/repo/:namespace/:repo/prs/:id/logs", handler: getPRLogs, role: roleViewer, summary: "Stream the logs of the sandbox of a PR", query: []string{"source", "follow", "tailLines", "container"}, contentType: "text/plain"},
+		{method: "GET", path: "/repo/:namespace/:repo/prs/:id/diff", handler: getPRDiff, role: roleViewer, summary: "Get the diff of a PR", response: []DiffFile{}},
+		{method: "POST", path: "/repo/:namespace/:repo/prs/:id/rerun", handler: rerunPR, role: roleReviewer, audit: "pr.sandbox.rerun", summary: "Run the agent of a PR again", request: rerunPayload{}},
+		{method: "DELETE", path: "/repo/:namespace/:repo/prs/:id", handler: deletePR, role: roleReviewer, audit: "pr.sandbox.delete", summary: "Scale down the sandbox of a PR and discard its draft"},
+		{method: "GET", path: "/repo/:namespace/:repo/issues/:handler", handler: getIssues, role: roleViewer, summary: "List the issues of an issue handler", response: []Issue{}},
+		{method: "POST", path: "/repo/:namespace/:repo/issues/bulk", handler: bulkIssues, role: roleReviewer, audit: "issue.bulk", summary: "Scale down, delete or submit issues at once", request: bulkPayload{}, response: bulkResponse{}},
+		{method: "POST", path: "/repo/:namespace/:repo/issues/:issue_id/handler/:handler/draft", handler: saveIssueDraft, role: roleReviewer, audit: "issue.draft.save", summary: "Save the draft comment of an issue", request: draftPayload{}},
+		{method: "GET", path: "/repo/:namespace/:repo/handlers/:handler/issues/:issue_id/drafts", handler: getIssueDraftHistory, role: roleViewer, summary: "List the saved drafts of an issue", response: []DraftVersion{}},
+		{method: "POST", path: "/repo/:namespace/:repo/handlers/:handler/issues/:issue_id/drafts/:version/restore", handler: restoreIssueDraft, role: roleReviewer, audit: "issue.draft.restore", summary: "Restore a saved draft of an issue", response: draftPayload{}},
+		{method: "POST", path: "/repo/:namespace/:repo/issues/:issue_id/handler/:handler/submitcomment", handler: submitIssueComment, role: roleReviewer, audit: "issue.comment.submit", summary: "Post a comment on an issue, or request its approval", request: commentSubmission{}},
+		{method: "POST", path: "/repo/:namespace/:repo/issues/:issue_id/handler/:handler/approve", handler: approveIssueComment, role: roleApprover, audit: "issue.comment.approve", summary: "Approve and post the pending comment of an issue"},
+		{method: "POST", path: "/repo/:namespace/:repo/issues/:issue_id/handler/:handler/reject", handler: rejectIssueComment, role: roleApprover, audit: "issue.comment.reject", summary: "Reject the pending comment of an issue"},
+		{method: "GET", path: "/repo/:namespace/:repo/handlers/:handler/issues/:issue_id/logs", handler: getIssueLogs, role: roleViewer, summary: "Stream the logs of the sandbox of an issue", query: []string{"source", "follow", "tailLines", "container"}, contentType: "text/plain"},
+		{method: "POST", path: "/repo/:namespace/:repo/issues/:issue_id/handler/:handler/rerun", handler: rerunIssue, role: roleReviewer, audit: "issue.sandbox.rerun", summary: "Run the agent of an issue again", request: rerunPayload{}},
+		{method: "DELETE", path: "/repo/:namespace/:repo/issues/:issue_id/handler/:handler", handler: deleteIssue, role: roleReviewer, audit: "issue.sandbox.delete", summary: "Scale down the sandbox of an issue and discard its draft"},
+		{method: "POST", path: "/repo/:namespace/:repo/sandboxes/:name/ide", handler: openSandboxIDE, role: roleReviewer, audit: "sandbox.ide.open", summary: "Get a link to the IDE of a sandbox, served from its own host", response: ideResponse{}},
+		{method: "POST", path: "/repowatch", handler: createRepoWatch, role: roleViewer, audit: "repowatch.create", summary: "Create a RepoWatch", request: RepoWatchPayload{}, status: 201},
+		{method: "GET", path: "/repowatch/:namespace/:name", handler: getRepoWatchSpec, role: roleViewer, summary: "Get the configuration of a RepoWatch", response: RepoWatchPayload{}},
+		{method: "PUT", path: "/repowatch/:namespace/:name", handler: updateRepoWatch, role: roleAdmin, audit: "repowatch.update", summary: "Update the configuration of a RepoWatch", request: RepoWatchPayload{}},
+		{method: "PUT", path: "/repowatch/:namespace/:name/review", handler: updateReviewConfig, role: roleAdmin, audit: "repowatch.review.update", summary: "Configure the PR reviews of a RepoWatch", request: ReviewPayload{}},
+		{method: "DELETE", path: "/repowatch/:namespace/:name/review", handler: deleteReviewConfig, role: roleAdmin, audit: "repowatch.review.delete", summary: "Stop reviewing the PRs of a RepoWatch"},
+		{method: "POST", path: "/repowatch/:namespace/:name/handlers", handler: createIssueHandler, role: roleAdmin, audit: "repowatch.handler.create", summary: "Add an issue handler to a RepoWatch", request: IssueHandlerPayload{}, response: IssueHandlerPayload{}, status: 201},
+		{method: "PUT", path: "/repowatch/:namespace/:name/handlers", handler: updateIssueHandlers, role: roleAdmin, audit: "repowatch.handlers.update", summary: "Replace the issue handlers of a RepoWatch", request: []IssueHandlerPayload{}},
+		{method: "PUT", path: "/repowatch/:namespace/:name/handlers/:handler", handler: updateIssueHandler, role: roleAdmin, audit: "repowatch.handler.update", summary: "Create or update an issue handler of a RepoWatch", request: IssueHandlerPayload{}},
+		{method: "DELETE", path: "/repowatch/:namespace/:name/handlers/:handler", handler: deleteIssueHandler, role: roleAdmin, audit: "repowatch.handler.delete", summary: "Remove an issue handler from a RepoWatch"},
+		{method: "PUT", path: "/repowatch/:namespace/:name/prompt", handler: assignPromptTemplate, role: roleAdmin, audit: "repowatch.prompt.assign", summary: "Assign a prompt template to the review or an issue handler of a RepoWatch", request: PromptAssignment{}},
+		{method: "DELETE", path: "/repowatch/:namespace/:name", handler: deleteRepoWatch, role: roleAdmin, audit: "repowatch.delete", summary: "Delete a RepoWatch"},
+		{method: "GET", path: "/prompts/:namespace", handler: listPromptTemplates, role: roleViewer, summary: "List the prompt templates of a namespace", response: []PromptTemplate{}},
+		{method: "POST", path: "/prompts/:namespace", handler: createPromptTemplate, role: roleAdmin, audit: "prompt.create", summary: "Create a prompt template", request: promptTemplateCreation{}, response: PromptTemplate{}, status: 201},
+		{method: "GET", path: "/prompts/:namespace/:template", handler: getPromptTemplate, role: roleViewer, summary: "Get a prompt template and its versions", response: PromptTemplate{}},
+		{method: "POST", path: "/prompts/:namespace/:template/versions", handler: addPromptVersion, role: roleAdmin, audit: "prompt.version.create", summary: "Save a new version of a prompt template", request: promptVersionCreation{}, response: PromptTemplate{}, status: 201},
+		{method: "DELETE", path: "/prompts/:namespace/:template", handler: deletePromptTemplate, role: roleAdmin, audit: "prompt.delete", summary: "Delete a prompt template that no RepoWatch uses"},
+		{method: "GET", path: "/proxy", handler: proxy, role: roleViewer, summary: "Fetch a GitHub URL", query: []string{"url"}, contentType: "text/plain"},
+		{method: "GET", path: "/stream", handler: stream, role: roleViewer, summary: "Stream the changes of PRs and issues as server-sent events", query: []string{"namespace", "repo"}, contentType: "text/event-stream"},
+		{method: "GET", path: "/audit", handler: getAudit, role: roleViewer, summary: "List the audit log", query: []string{"since", "until", "user", "namespace", "repo"}, response: []AuditEntry{}},
+		{method: "GET", path: "/feedback/export", handler: exportFeedback, role: roleViewer, summary: "Export the human feedback as JSON lines", query: []string{"since", "until", "type", "namespace", "repo"}, contentType: "application/x-ndjson"},
+		{method: "GET", path: "/settings", handler: getSettings, role: roleViewer, summary: "Get the features enabled and the quota of a namespace", query: []string{"namespace"}, response: map[string]interface{}{}},
+		{method: "PUT", path: "/settings/:namespace/github", handler: saveGitHubCredential, role: roleAdmin, audit: "settings.github.update", summary: "Validate a GitHub PAT and store it in the secret of a namespace", request: GitHubCredentialPayload{}, response: CredentialStatus{}},
+		{method: "PUT", path: "/settings/:namespace/github-app", handler: saveGitHubAppCredential, role: roleAdmin, audit: "settings.github-app.update", summary: "Validate a GitHub App installation and store it in the secret of a namespace, its tokens are rotated", request: GitHubAppCredentialPayload{}, response: CredentialStatus{}},
+		{method: "PUT", path: "/settings/:namespace/gemini", handler: saveGeminiCredential, role: roleAdmin, audit: "settings.gemini.update", summary: "Validate a Gemini API key and store it in the secret of a namespace", request: GeminiCredentialPayload{}, response: CredentialStatus{}},
+		{method: "GET", path: "/openapi.json", handler: getOpenAPISpec, summary: "Get the OpenAPI spec of the API", response: map[string]interface{}{}},
+		{method: "GET", path: "/auth/login", handler: login, summary: "Log in with GitHub"},
+		{method: "GET", path: "/auth/callback", handler: oauthCallback, summary: "Complete the GitHub login", query: []string{"code", "state"}},
+		{method: "POST", path: "/auth/logout", handler: logout, summary: "Log out"},
+		{method: "GET", path: "/auth/user", handler: currentUser, summary: "Get the logged in user", response: map[string]interface{}{}},
+	}
+}
+
+// registerAPIRoutes adds apiRoutes to api, behind their role check and audit.
+func registerAPIRoutes(api *gin.RouterGroup) {
+	for _, r := range apiRoutes() {
+		var handlers []gin.HandlerFunc
+		if r.role != roleNone {
+			handlers = append(handlers, requireRole(r.role))
+		}
+		if r.audit != "" {
+			handlers = append(handlers, audit(r.audit))
+		}
+		api.Handle(r.method, r.path, append(handlers, r.handler)...)
+	}
+}
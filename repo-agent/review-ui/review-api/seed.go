@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// demoFieldManager owns the fields of the seeded objects, so that seeding
+	// again updates them.
+	demoFieldManager = "review-api-demo"
+	// demoAnnotation marks the seeded RepoWatches, which the RepoWatch
+	// controller does not reconcile.
+	demoAnnotation = "review.gemini.google.com/demo"
+)
+
+// demoMode seeds fake drafts in the store on startup, DEMO_MODE.
+var demoMode = os.Getenv("DEMO_MODE") == "true"
+
+var namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+type demoPR struct {
+	ID, Title, AgentDraft string
+}
+
+type demoIssue struct {
+	ID, Title, Handler, AgentDraft string
+}
+
+// The seeded RepoWatch and its fake sandboxes.
+var (
+	demoRepo    = "redis"
+	demoRepoURL = "https://github.com/redis/redis"
+	demoPRs     = []demoPR{
+		{ID: "123", Title: "Feat: Add awesome feature", AgentDraft: "note: Looks good overall.\nreview:\n  body: The new command is well tested.\n  comments: []\n"},
+		{ID: "124", Title: "Fix: A really bad bug", AgentDraft: "note: The fix misses a case.\nreview:\n  body: Please also handle empty keys.\n  comments: []\n"},
+	}
+	demoIssues = []demoIssue{
+		{ID: "42", Title: "Crash on startup with an empty config", Handler: "triage", AgentDraft: "The crash comes from the config loader not handling empty files."},
+	}
+)
+
+// runSeedDemo is the seed-demo subcommand. It provisions a demo namespace with
+// a RepoWatch and fake PR and issue sandboxes that have agent drafts, without
+// reaching GitHub or running any agent.
+func runSeedDemo(args []string) {
+	fs := flag.NewFlagSet("seed-demo", flag.ExitOnError)
+	namespace := fs.String("namespace", "repo-agent-demo", "namespace to provision")
+	if err := fs.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if err := seedDemo(context.Background(), *namespace); err != nil {
+		log.Fatalf("Failed to seed demo namespace %s: %v", *namespace, err)
+	}
+	log.Printf("Seeded demo namespace %s", *namespace)
+}
+
+// seedDemo creates or updates the demo objects in namespace.
+func seedDemo(ctx context.Context, namespace string) error {
+	ns := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": namespace},
+	}}
+	if err := applyDemoObject(ctx, namespaceGVR, ns); err != nil {
+		return err
+	}
+
+	repoWatch, err := demoRepoWatch(namespace)
+	if err != nil {
+		return err
+	}
+	if err := applyDemoObject(ctx, repoWatchGVR, repoWatch); err != nil {
+		return err
+	}
+
+	for _, pr := range demoPRs {
+		sandbox := demoSandbox(namespace, "ReviewSandbox", fmt.Sprintf("%s-pr-%s", demoRepo, pr.ID), "", map[string]interface{}{
+			"pr":       pr.ID,
+			"title":    pr.Title,
+			"repo":     demoRepo,
+			"cloneURL": demoRepoURL + ".git",
+			"htmlURL":  fmt.Sprintf("%s/pull/%s", demoRepoURL, pr.ID),
+			"diffURL":  fmt.Sprintf("%s/pull/%s.diff", demoRepoURL, pr.ID),
+		})
+		// Review sandboxes publish their draft as an annotation
+		sandbox.SetAnnotations(map[string]string{"agentDraft": pr.AgentDraft})
+		if err := applyDemoObject(ctx, reviewSandboxGVR, sandbox); err != nil {
+			return err
+		}
+	}
+
+	for _, issue := range demoIssues {
+		name := fmt.Sprintf("%s-issue-%s-%s", demoRepo, issue.ID, issue.Handler)
+		sandbox := demoSandbox(namespace, "IssueSandbox", name, issue.Handler, map[string]interface{}{
+			"issue":    issue.ID,
+			"title":    issue.Title,
+			"repo":     demoRepo,
+			"cloneURL": demoRepoURL + ".git",
+			"htmlURL":  fmt.Sprintf("%s/issues/%s", demoRepoURL, issue.ID),
+		})
+		if err := applyDemoObject(ctx, issueSandboxGVR, sandbox); err != nil {
+			return err
+		}
+		// Issue sandboxes publish their draft in the status
+		status := demoSandbox(namespace, "IssueSandbox", name, issue.Handler, nil)
+		delete(status.Object, "spec")
+		status.Object["status"] = map[string]interface{}{"agentDraft": issue.AgentDraft, "phase": "Completed"}
+		if _, err := k8sClient.Resource(issueSandboxGVR).Namespace(namespace).ApplyStatus(ctx, name, status,
+			v1.ApplyOptions{FieldManager: demoFieldManager, Force: true}); err != nil {
+			return fmt.Errorf("failed to set the status of IssueSandbox %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// demoRepoWatch returns the demo RepoWatch, with a review and a triage issue
+// handler.
+func demoRepoWatch(namespace string) (*unstructured.Unstructured, error) {
+	repoWatch := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "review.gemini.google.com/v1alpha1",
+		"kind":       "RepoWatch",
+		"metadata": map[string]interface{}{
+			"name":        demoRepo,
+			"namespace":   namespace,
+			"annotations": map[string]interface{}{demoAnnotation: "true"},
+		},
+		"spec": map[string]interface{}{
+			"repoURL":             demoRepoURL,
+			"githubSecretName":    defaultGithubSecretName,
+			"pollIntervalSeconds": int64(defaultPollIntervalSeconds),
+		},
+	}}
+	review := &ReviewPayload{
+		MaxActiveSandboxes:    3,
+		DevcontainerConfigRef: defaultDevcontainerRef,
+		LLM:                   LLMPayload{APIKeySecretRef: defaultAPIKeySecretRef, Prompt: defaultReviewPrompt},
+	}
+	if err := review.apply(repoWatch); err != nil {
+		return nil, err
+	}
+	handlers := []IssueHandlerPayload{{
+		Name:                  "triage",
+		MaxActiveSandboxes:    1,
+		DevcontainerConfigRef: defaultDevcontainerRef,
+		Labels:                []string{"bug"},
+		LLM:                   LLMPayload{APIKeySecretRef: defaultAPIKeySecretRef, Prompt: "Find the root cause of the issue."},
+	}}
+	if err := setIssueHandlers(repoWatch, handlers); err != nil {
+		return nil, err
+	}
+	return repoWatch, nil
+}
+
+// demoSandbox returns a scaled down sandbox of the demo RepoWatch, so that no
+// agent runs.
+func demoSandbox(namespace, kind, name, handler string, source map[string]interface{}) *unstructured.Unstructured {
+	labels := map[string]interface{}{"review.gemini.google.com/repowatch": demoRepo}
+	if handler != "" {
+		labels["review.gemini.google.com/handler"] = handler
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "custom.agents.x-k8s.io/v1alpha1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels":    labels,
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(0),
+			"llm":      map[string]interface{}{"prompt": "demo"},
+			"source":   source,
+		},
+	}}
+}
+
+// applyDemoObject creates or updates obj with server-side apply.
+func applyDemoObject(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	var err error
+	opts := v1.ApplyOptions{FieldManager: demoFieldManager, Force: true}
+	if ns := obj.GetNamespace(); ns != "" {
+		_, err = k8sClient.Resource(gvr).Namespace(ns).Apply(ctx, obj.GetName(), obj, opts)
+	} else {
+		_, err = k8sClient.Resource(gvr).Apply(ctx, obj.GetName(), obj, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
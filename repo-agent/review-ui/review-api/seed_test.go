@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDemoObjects(t *testing.T) {
+	repoWatch, err := demoRepoWatch("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoWatch.GetAnnotations()[demoAnnotation] != "true" {
+		t.Errorf("demo RepoWatch annotations = %v, want %s", repoWatch.GetAnnotations(), demoAnnotation)
+	}
+	if review := reviewPayload(repoWatch); review == nil || review.MaxActiveSandboxes != 3 {
+		t.Errorf("demo RepoWatch review = %+v, want 3 sandboxes", review)
+	}
+	if handlers := issueHandlerPayloads(repoWatch); len(handlers) != 1 || handlers[0].Name != "triage" {
+		t.Errorf("demo RepoWatch issue handlers = %+v, want triage", handlers)
+	}
+
+	sandbox := demoSandbox("demo", "ReviewSandbox", "redis-pr-123", "", map[string]interface{}{"pr": "123", "title": "Feat"})
+	pr, ok := prFromSandbox(sandbox)
+	if !ok || pr.ID != "123" || pr.SandboxReplica != "0" {
+		t.Errorf("prFromSandbox(demo sandbox) = %+v, %v, want scaled down PR 123", pr, ok)
+	}
+}
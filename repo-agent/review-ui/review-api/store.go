@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// kvStore holds the state owned by the API, such as user drafts, submitted
+// reviews and human feedback. Everything else is read from the Kubernetes
+// cache. Missing keys and fields are reported as redis.Nil.
+type kvStore interface {
+	HSet(ctx context.Context, key string, values ...interface{}) error
+	HGet(ctx context.Context, key, field string) (string, error)
+	// HSetNX sets a field only if it is not set, and reports whether it did.
+	HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HDel(ctx context.Context, key string, fields ...string) error
+	Del(ctx context.Context, keys ...string) error
+	// Keys returns the keys matching a glob pattern.
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	// Ping checks that the store is reachable.
+	Ping(ctx context.Context) error
+}
+
+// redisStore is a kvStore backed by a Redis server, Sentinel monitored
+// master or Cluster.
+type redisStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisStore) HSet(ctx context.Context, key string, values ...interface{}) error {
+	return s.client.HSet(ctx, key, values...).Err()
+}
+
+func (s *redisStore) HGet(ctx context.Context, key, field string) (string, error) {
+	return s.client.HGet(ctx, key, field).Result()
+}
+
+func (s *redisStore) HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	return s.client.HSetNX(ctx, key, field, value).Result()
+}
+
+func (s *redisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return s.client.HGetAll(ctx, key).Result()
+}
+
+func (s *redisStore) HDel(ctx context.Context, key string, fields ...string) error {
+	return s.client.HDel(ctx, key, fields...).Err()
+}
+
+func (s *redisStore) Del(ctx context.Context, keys ...string) error {
+	return s.client.Del(ctx, keys...).Err()
+}
+
+func (s *redisStore) Keys(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := s.client.(*redis.ClusterClient)
+	if !ok {
+		return scanKeys(ctx, s.client, pattern)
+	}
+	// Each master holds a part of the keys
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		masterKeys, err := scanKeys(ctx, client, pattern)
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, masterKeys...)
+		return err
+	})
+	return keys, err
+}
+
+func scanKeys(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// memoryStore is an in-memory kvStore used when Redis is not configured.
+// Its contents are lost when the API restarts.
+type memoryStore struct {
+	mu     sync.Mutex
+	hashes map[string]map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{hashes: map[string]map[string]string{}}
+}
+
+func (s *memoryStore) HSet(_ context.Context, key string, values ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hashes[key]
+	if !ok {
+		h = map[string]string{}
+		s.hashes[key] = h
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		h[toString(values[i])] = toString(values[i+1])
+	}
+	return nil
+}
+
+func (s *memoryStore) HGet(_ context.Context, key, field string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.hashes[key][field]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (s *memoryStore) HSetNX(_ context.Context, key, field string, value interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hashes[key]
+	if !ok {
+		h = map[string]string{}
+		s.hashes[key] = h
+	}
+	if _, ok := h[field]; ok {
+		return false, nil
+	}
+	h[field] = toString(value)
+	return true, nil
+}
+
+func (s *memoryStore) HGetAll(_ context.Context, key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := map[string]string{}
+	for k, v := range s.hashes[key] {
+		h[k] = v
+	}
+	return h, nil
+}
+
+func (s *memoryStore) HDel(_ context.Context, key string, fields ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range fields {
+		delete(s.hashes[key], f)
+	}
+	return nil
+}
+
+func (s *memoryStore) Del(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.hashes, k)
+	}
+	return nil
+}
+
+func (s *memoryStore) Keys(_ context.Context, pattern string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.hashes {
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) Ping(_ context.Context) error {
+	return nil
+}
+
+func toString(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
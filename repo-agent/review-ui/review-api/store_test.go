@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	if err := s.HSet(ctx, "pr:repo:r:pr:1", "draft", "LGTM", "count", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.HGet(ctx, "pr:repo:r:pr:1", "count"); err != nil || got != "2" {
+		t.Errorf("HGet() = %q, %v, want \"2\"", got, err)
+	}
+	if _, err := s.HGet(ctx, "pr:repo:r:pr:1", "review"); err != redis.Nil {
+		t.Errorf("HGet() of a missing field error = %v, want redis.Nil", err)
+	}
+
+	if ok, err := s.HSetNX(ctx, "pr:repo:r:pr:1", "draft", "NACK"); err != nil || ok {
+		t.Errorf("HSetNX() of a set field = %v, %v, want false", ok, err)
+	}
+	if ok, err := s.HSetNX(ctx, "pr:repo:r:pr:1", "review", "LGTM"); err != nil || !ok {
+		t.Errorf("HSetNX() of a missing field = %v, %v, want true", ok, err)
+	}
+	if got, _ := s.HGet(ctx, "pr:repo:r:pr:1", "draft"); got != "LGTM" {
+		t.Errorf("HGet() after HSetNX = %q, want LGTM", got)
+	}
+
+	keys, err := s.Keys(ctx, "pr:repo:r:pr:*")
+	if err != nil || len(keys) != 1 {
+		t.Errorf("Keys() = %v, %v, want one key", keys, err)
+	}
+
+	if err := s.Del(ctx, "pr:repo:r:pr:1"); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := s.HGetAll(ctx, "pr:repo:r:pr:1"); len(h) != 0 {
+		t.Errorf("HGetAll() after Del = %v, want empty", h)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// streamKeepAlive is how often a ping is sent on idle streams so proxies
+// don't close them.
+const streamKeepAlive = 30 * time.Second
+
+// streamEvent is a change pushed to the browser by /api/stream. The SSE
+// event name is the Type.
+type streamEvent struct {
+	// Type is repo, pr or issue
+	Type string `json:"type"`
+	// Action is updated or deleted
+	Action    string `json:"action"`
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repo"`
+	Handler   string `json:"handler,omitempty"`
+	PR        *PR    `json:"pr,omitempty"`
+	Issue     *Issue `json:"issue,omitempty"`
+}
+
+// broadcaster fans out stream events to the connected browsers. Events are
+// dropped for subscribers that don't keep up.
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan streamEvent]struct{}
+}
+
+var events = &broadcaster{subscribers: map[chan streamEvent]struct{}{}}
+
+func (b *broadcaster) subscribe() chan streamEvent {
+	ch := make(chan streamEvent, 64)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *broadcaster) publish(e streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("Dropping %s event for a slow stream subscriber", e.Type)
+		}
+	}
+}
+
+// publishChanges publishes the changes to the cached RepoWatches and
+// sandboxes as stream events.
+func publishChanges(c *resourceCache) error {
+	for _, gvr := range []schema.GroupVersionResource{repoWatchGVR, reviewSandboxGVR, issueSandboxGVR} {
+		gvr := gvr
+		_, err := c.informers[gvr].AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				publishChange(gvr, obj, "updated")
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if oldU, ok := oldObj.(*unstructured.Unstructured); ok {
+					if newU, ok := newObj.(*unstructured.Unstructured); ok && oldU.GetResourceVersion() == newU.GetResourceVersion() {
+						// Periodic resync
+						return
+					}
+				}
+				publishChange(gvr, newObj, "updated")
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				publishChange(gvr, obj, "deleted")
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func publishChange(gvr schema.GroupVersionResource, obj interface{}, action string) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	e := streamEvent{
+		Action:    action,
+		Namespace: u.GetNamespace(),
+		Repo:      u.GetLabels()["review.gemini.google.com/repowatch"],
+	}
+	switch gvr {
+	case repoWatchGVR:
+		e.Type = "repo"
+		e.Repo = u.GetName()
+	case reviewSandboxGVR:
+		pr, ok := prFromSandbox(u)
+		if !ok {
+			return
+		}
+		overlayPRState(ctx, e.Repo, &pr)
+		e.Type = "pr"
+		e.PR = &pr
+	case issueSandboxGVR:
+		issue, ok := issueFromSandbox(u)
+		if !ok {
+			return
+		}
+		e.Handler = u.GetLabels()["review.gemini.google.com/handler"]
+		overlayIssueState(ctx, e.Repo, e.Handler, &issue)
+		e.Type = "issue"
+		e.Issue = &issue
+	}
+	events.publish(e)
+}
+
+// stream pushes repo, PR and issue changes to the browser as Server-Sent
+// Events. The optional namespace and repo query parameters filter the
+// events.
+func stream(c *gin.Context) {
+	namespace := c.Query("namespace")
+	repo := c.Query("repo")
+	user := requestUser(c)
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(_ io.Writer) bool {
+		select {
+		case e := <-ch:
+			if (namespace != "" && e.Namespace != namespace) || (repo != "" && e.Repo != repo) {
+				return true
+			}
+			if rbacEnabled && k8sCache.roleFor(user, e.Namespace, e.Repo) < roleViewer {
+				return true
+			}
+			c.SSEvent(e.Type, e)
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", "")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestBroadcaster(t *testing.T) {
+	b := &broadcaster{subscribers: map[chan streamEvent]struct{}{}}
+	ch := b.subscribe()
+
+	b.publish(streamEvent{Type: "pr", Repo: "a"})
+	if e := <-ch; e.Type != "pr" || e.Repo != "a" {
+		t.Errorf("received %+v, want the pr event", e)
+	}
+
+	// A slow subscriber drops events instead of blocking the publisher
+	for i := 0; i < cap(ch)+1; i++ {
+		b.publish(streamEvent{Type: "issue"})
+	}
+	if len(ch) != cap(ch) {
+		t.Errorf("buffered %d events, want %d", len(ch), cap(ch))
+	}
+
+	b.unsubscribe(ch)
+	if len(b.subscribers) != 0 {
+		t.Errorf("%d subscribers left after unsubscribe", len(b.subscribers))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// outdatedNote prefixes the body of the superseded agent reviews.
+	outdatedNote = "**Outdated:** this automated review was superseded by a newer one.\n\n"
+	// supersededMessage is the message of the dismissed agent reviews.
+	supersededMessage = "Superseded by a newer automated review."
+)
+
+// supersedeMode returns spec.review.supersede of a RepoWatch: none, dismiss
+// or outdate.
+func supersedeMode(repoWatch *unstructured.Unstructured) string {
+	mode, _, _ := unstructured.NestedString(repoWatch.Object, "spec", "review", "supersede")
+	if mode == "" {
+		return "none"
+	}
+	return mode
+}
+
+// supersedeReview retires the agent review previously posted on a PR before
+// a new one is. Pending reviews are deleted, approvals and change requests
+// are dismissed in dismiss mode, and the other reviews are marked outdated.
+// Reviews already retired or deleted are left as they are, so that it can be
+// retried.
+func supersedeReview(ctx context.Context, client *github.Client, mode, owner, repo string, number int, reviewID int64) (*github.Response, error) {
+	review, resp, err := client.PullRequests.GetReview(ctx, owner, repo, number, reviewID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return resp, fmt.Errorf("failed to get review %d: %w", reviewID, err)
+	}
+
+	switch state := review.GetState(); {
+	case state == "PENDING":
+		_, resp, err = client.PullRequests.DeletePendingReview(ctx, owner, repo, number, reviewID)
+	case state == "DISMISSED" || strings.HasPrefix(review.GetBody(), outdatedNote):
+		return nil, nil
+	case mode == "dismiss" && (state == "APPROVED" || state == "CHANGES_REQUESTED"):
+		_, resp, err = client.PullRequests.DismissReview(ctx, owner, repo, number, reviewID, &github.PullRequestReviewDismissalRequest{
+			Message: github.String(supersededMessage),
+		})
+	default:
+		_, resp, err = client.PullRequests.UpdateReview(ctx, owner, repo, number, reviewID, outdatedNote+review.GetBody())
+	}
+	if err != nil {
+		return resp, fmt.Errorf("failed to supersede review %d: %w", reviewID, err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSupersedeReview(t *testing.T) {
+	const reviewPath = "/api/v3/repos/test/repo/pulls/1/reviews/7"
+	for _, tt := range []struct {
+		name   string
+		mode   string
+		review string
+		want   []string
+	}{
+		{name: "pending", mode: "outdate", review: `{"id": 7, "state": "PENDING", "body": "LGTM"}`,
+			want: []string{"DELETE " + reviewPath + " "}},
+		{name: "dismiss approval", mode: "dismiss", review: `{"id": 7, "state": "APPROVED", "body": "LGTM"}`,
+			want: []string{"PUT " + reviewPath + "/dismissals " + `{"message":"` + supersededMessage + `"}` + "\n"}},
+		{name: "outdate approval", mode: "outdate", review: `{"id": 7, "state": "APPROVED", "body": "LGTM"}`,
+			want: []string{"PUT " + reviewPath + " " + `{"body":"**Outdated:** this automated review was superseded by a newer one.\n\nLGTM"}` + "\n"}},
+		{name: "comments can't be dismissed", mode: "dismiss", review: `{"id": 7, "state": "COMMENTED", "body": "LGTM"}`,
+			want: []string{"PUT " + reviewPath + " " + `{"body":"**Outdated:** this automated review was superseded by a newer one.\n\nLGTM"}` + "\n"}},
+		{name: "already outdated", mode: "outdate", review: `{"id": 7, "state": "COMMENTED", "body": "**Outdated:** this automated review was superseded by a newer one.\n\nLGTM"}`},
+		{name: "already dismissed", mode: "dismiss", review: `{"id": 7, "state": "DISMISSED", "body": "LGTM"}`},
+		{name: "deleted", mode: "dismiss"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet && r.URL.Path == reviewPath && tt.review != "" {
+					w.Write([]byte(tt.review))
+					return
+				}
+				if r.Method == http.MethodGet {
+					http.NotFound(w, r)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+				w.Write([]byte(`{"id": 7}`))
+			}))
+			defer srv.Close()
+			client, err := newGitHubAPIClient(&http.Client{}, srv.URL+"/", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := supersedeReview(context.Background(), client, tt.mode, "test", "repo", 1, 7); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(requests, tt.want) {
+				t.Errorf("requests = %q, want %q", requests, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+import React, { useState } from 'react';
+
+function AddHandler({ repo, onHandlerAdded }) {
+    const [name, setName] = useState('');
+    const [labels, setLabels] = useState('');
+    const [prompt, setPrompt] = useState('');
+    const [pushEnabled, setPushEnabled] = useState(false);
+    const [maxActiveSandboxes, setMaxActiveSandboxes] = useState(1);
+    const [error, setError] = useState(null);
+    const [isSubmitting, setIsSubmitting] = useState(false);
+
+    const handleSubmit = async (e) => {
+        e.preventDefault();
+        setError(null);
+        setIsSubmitting(true);
+
+        try {
+            const response = await fetch(`/api/repowatch/${repo.namespace}/${repo.name}/handlers`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({
+                    name,
+                    labels: labels.split(',').map(l => l.trim()).filter(l => l !== ''),
+                    pushEnabled,
+                    maxActiveSandboxes: Number(maxActiveSandboxes),
+                    llm: { prompt },
+                }),
+            });
+
+            if (!response.ok) {
+                const data = await response.json();
+                throw new Error(data.error || 'Failed to add issue handler');
+            }
+
+            if (onHandlerAdded) {
+                onHandlerAdded(name);
+            }
+        } catch (err) {
+            setError(err.message);
+        } finally {
+            setIsSubmitting(false);
+        }
+    };
+
+    const inputStyle = { width: '100%', padding: '8px', borderRadius: '4px', border: '1px solid #ccc' };
+    const labelStyle = { display: 'block', marginBottom: '5px', fontWeight: 'bold' };
+
+    return (
+        <div className="pr-card">
+            <h3>Add Issue Handler to {repo.name}</h3>
+            {error && <div style={{ color: 'red', marginBottom: '10px' }}>{error}</div>}
+            <form onSubmit={handleSubmit} className="review-form">
+                <div style={{ marginBottom: '15px' }}>
+                    <label htmlFor="handler-name" style={labelStyle}>Name:</label>
+                    <input
+                        type="text"
+                        id="handler-name"
+                        value={name}
+                        onChange={(e) => setName(e.target.value)}
+                        required
+                        placeholder="e.g., triage"
+                        style={inputStyle}
+                    />
+                </div>
+                <div style={{ marginBottom: '15px' }}>
+                    <label htmlFor="handler-labels" style={labelStyle}>Labels (comma separated):</label>
+                    <input
+                        type="text"
+                        id="handler-labels"
+                        value={labels}
+                        onChange={(e) => setLabels(e.target.value)}
+                        placeholder="e.g., bug, good first issue"
+                        style={inputStyle}
+                    />
+                </div>
+                <div style={{ marginBottom: '15px' }}>
+                    <label htmlFor="handler-prompt" style={labelStyle}>Prompt:</label>
+                    <textarea
+                        id="handler-prompt"
+                        value={prompt}
+                        onChange={(e) => setPrompt(e.target.value)}
+                        required
+                        rows="6"
+                        style={inputStyle}
+                    />
+                </div>
+                <div style={{ marginBottom: '15px' }}>
+                    <label htmlFor="handler-max" style={labelStyle}>Max active sandboxes:</label>
+                    <input
+                        type="number"
+                        id="handler-max"
+                        min="1"
+                        value={maxActiveSandboxes}
+                        onChange={(e) => setMaxActiveSandboxes(e.target.value)}
+                        required
+                        style={inputStyle}
+                    />
+                </div>
+                <div style={{ marginBottom: '15px' }}>
+                    <label>
+                        <input
+                            type="checkbox"
+                            checked={pushEnabled}
+                            onChange={(e) => setPushEnabled(e.target.checked)}
+                        />
+                        {' '}Push the fix to a branch
+                    </label>
+                </div>
+                <button type="submit" className="btn btn-submit" disabled={isSubmitting}>
+                    {isSubmitting ? 'Adding...' : 'Add Handler'}
+                </button>
+            </form>
+        </div>
+    );
+}
+
+export default AddHandler;
@@ -5,6 +5,8 @@ import PrReviewCard from './PrReviewCard';
 import IssueCard from './IssueCard';
 import AddRepo from './AddRepo';
 import DeleteRepo from './DeleteRepo';
+import AddHandler from './AddHandler';
+import Credentials from './Credentials';
 
 function App() {
   const [repos, setRepos] = useState([]);
@@ -18,19 +20,35 @@ function App() {
   const [reviewViewModes, setReviewViewModes] = useState({});
   const [yamlDrafts, setYamlDrafts] = useState({});
   const [showAddRepo, setShowAddRepo] = useState(false);
+  const [showAddHandler, setShowAddHandler] = useState(false);
+  const [credentialsNamespace, setCredentialsNamespace] = useState(null);
+  const [user, setUser] = useState({ loginEnabled: false });
 
   useEffect(() => {
     document.body.className = theme === 'dark' ? 'dark-mode' : '';
     localStorage.setItem('theme', theme);
   }, [theme]);
 
+  useEffect(() => {
+    fetch('/api/auth/user')
+      .then(res => res.json())
+      .then(data => setUser(data))
+      .catch(err => console.error("Failed to fetch user:", err));
+  }, []);
+
+  const handleLogout = () => {
+    fetch('/api/auth/logout', { method: 'POST' })
+      .then(() => setUser(prev => ({ loginEnabled: prev.loginEnabled })))
+      .catch(err => console.error("Failed to log out:", err));
+  };
+
   const fetchRepos = useCallback(() => {
     fetch('/api/repos')
       .then(res => res.json())
       .then(data => {
         const safeData = data || [];
         setRepos(safeData);
-        if (safeData.length > 0 && !activeRepo && !showAddRepo) {
+        if (safeData.length > 0 && !activeRepo && !showAddRepo && !credentialsNamespace) {
           const firstRepo = safeData[0];
           setActiveRepo(firstRepo);
           if (firstRepo.review) {
@@ -41,7 +59,7 @@ function App() {
         }
       })
       .catch(err => console.error("Failed to fetch repos:", err));
-  }, [activeRepo, showAddRepo]);
+  }, [activeRepo, showAddRepo, credentialsNamespace]);
 
   useEffect(() => {
     fetchRepos();
@@ -95,8 +113,59 @@ function App() {
     }
   }, [activeRepo, activeSubTab]);
 
+  useEffect(() => {
+    if (!activeRepo) {
+      return;
+    }
+    const source = new EventSource(`/api/stream?namespace=${activeRepo.namespace}&repo=${activeRepo.name}`);
+    const upsert = (items, item) => (
+      items.some(i => i.id === item.id) ? items.map(i => i.id === item.id ? item : i) : [...items, item]
+    );
+    source.addEventListener('repo', () => fetchRepos());
+    source.addEventListener('pr', (e) => {
+      if (activeSubTab.name !== 'review') {
+        return;
+      }
+      const { action, pr } = JSON.parse(e.data);
+      if (action === 'deleted') {
+        setPrs(prev => prev.filter(p => p.id !== pr.id));
+        return;
+      }
+      setPrs(prev => upsert(prev, pr));
+      setDrafts(prev => {
+        if (prev[pr.id] !== undefined) {
+          return prev;
+        }
+        let parsedDraft;
+        try {
+          parsedDraft = yaml.load(pr.draft || '');
+        } catch (err) {
+          console.error(`Error parsing draft YAML for PR ${pr.id}:`, err);
+        }
+        return { ...prev, [pr.id]: parsedDraft || { note: '', review: { body: '', comments: [] } } };
+      });
+      setCollapsedReviews(prev => prev[pr.id] === undefined ? { ...prev, [pr.id]: true } : prev);
+      setReviewViewModes(prev => prev[pr.id] === undefined ? { ...prev, [pr.id]: 'structured' } : prev);
+    });
+    source.addEventListener('issue', (e) => {
+      const { action, handler, issue } = JSON.parse(e.data);
+      if (handler !== activeSubTab.name) {
+        return;
+      }
+      if (action === 'deleted') {
+        setIssues(prev => prev.filter(i => i.id !== issue.id));
+        return;
+      }
+      setIssues(prev => upsert(prev, issue));
+      setDrafts(prev => prev[issue.id] === undefined ? { ...prev, [issue.id]: issue.draft || '' } : prev);
+    });
+    return () => source.close();
+  }, [activeRepo, activeSubTab, fetchRepos]);
+
   const handleRepoClick = (repoName) => {
     setShowAddRepo(false);
+    setCredentialsNamespace(null);
+    setShowAddHandler(false);
     const repo = repos.find(r => r.name === repoName);
     setActiveRepo(repo);
     setPrs([]);
@@ -119,6 +188,13 @@ function App() {
 
   const handleAddRepoClick = () => {
     setShowAddRepo(true);
+    setCredentialsNamespace(null);
+    setActiveRepo(null);
+  };
+
+  const handleCredentialsClick = () => {
+    setCredentialsNamespace((activeRepo || repos[0] || { namespace: 'default' }).namespace);
+    setShowAddRepo(false);
     setActiveRepo(null);
   };
 
@@ -134,6 +210,59 @@ function App() {
       .catch(err => console.error("Failed to delete PR:", err));
   };
 
+  const handleHandlerAdded = (name) => {
+    setShowAddHandler(false);
+    fetchRepos();
+    setActiveSubTab({ repo: activeRepo.name, name });
+  };
+
+  const handleRemoveHandler = () => {
+    const name = activeSubTab.name;
+    if (!window.confirm(`Remove issue handler ${name} from ${activeRepo.name}? Its sandboxes are kept.`)) {
+      return;
+    }
+    fetch(`/api/repowatch/${activeRepo.namespace}/${activeRepo.name}/handlers/${name}`, { method: 'DELETE' })
+      .then(res => {
+        if (!res.ok) {
+          return res.json().then(data => { throw new Error(data.error || 'Failed to remove issue handler'); });
+        }
+        setActiveSubTab({ repo: activeRepo.name, name: '' });
+        fetchRepos();
+      })
+      .catch(err => alert(err.message));
+  };
+
+  const handleBulk = (action) => {
+    const isReview = activeSubTab.name === 'review';
+    const ids = (isReview ? prs : issues).map(item => item.id);
+    if (ids.length === 0 || !window.confirm(`${action} ${ids.length} ${isReview ? 'PRs' : 'issues'}?`)) {
+      return;
+    }
+    const url = `/api/repo/${activeRepo.namespace}/${activeRepo.name}/${isReview ? 'prs' : 'issues'}/bulk`;
+    fetch(url, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ action, ids, handler: isReview ? '' : activeSubTab.name }),
+    })
+      .then(res => res.json())
+      .then(data => {
+        const results = data.results || [];
+        if (action === 'delete') {
+          const deleted = new Set(results.filter(r => r.status !== 'error').map(r => r.id));
+          if (isReview) {
+            setPrs(prs.filter(pr => !deleted.has(pr.id)));
+          } else {
+            setIssues(issues.filter(issue => !deleted.has(issue.id)));
+          }
+        }
+        const failed = results.filter(r => r.status === 'error');
+        if (data.error || failed.length > 0) {
+          alert(data.error || `Failed for ${failed.map(r => `${r.id} (${r.error})`).join(', ')}`);
+        }
+      })
+      .catch(err => console.error(`Failed to ${action}:`, err));
+  };
+
   const handleSaveDraft = (id) => {
     const draft = yaml.dump(drafts[id]);
     fetch(`/api/repo/${activeRepo.namespace}/${activeRepo.name}/prs/${id}/draft`, {
@@ -233,7 +362,10 @@ function App() {
       body: JSON.stringify({ review: reviewYAML })
     })
     .then(res => {
-      if (res.ok) {
+      if (res.status === 202) {
+        // Waiting for a second user to approve it
+        setPrs(prs.map(pr => pr.id === id ? { ...pr, pendingApproval: { content: reviewYAML, submittedBy: user.login } } : pr));
+      } else if (res.ok) {
         setPrs(prs.map(pr => pr.id === id ? { ...pr, review: reviewYAML, draft: '' } : pr));
       } else {
         alert("Failed to submit PR review");
@@ -242,6 +374,25 @@ function App() {
     .catch(err => console.error("Failed to submit PR review:", err));
   };
 
+  const handleApproval = (id, decision) => {
+    fetch(`/api/repo/${activeRepo.namespace}/${activeRepo.name}/prs/${id}/${decision}`, { method: 'POST' })
+      .then(async res => {
+        if (res.ok) {
+          setPrs(prs.map(pr => {
+            if (pr.id !== id) {
+              return pr;
+            }
+            const review = decision === 'approve' ? pr.pendingApproval.content : pr.review;
+            return { ...pr, review, pendingApproval: undefined };
+          }));
+        } else {
+          const data = await res.json().catch(() => ({}));
+          alert(`Failed to ${decision} PR review: ${data.error || res.statusText}`);
+        }
+      })
+      .catch(err => console.error(`Failed to ${decision} PR review:`, err));
+  };
+
   const handleExportCurl = (id, onSuccess) => {
     let review;
     if (reviewViewModes[id] === 'yaml') {
@@ -330,7 +481,9 @@ function App() {
       body: JSON.stringify({ comment })
     })
     .then(res => {
-      if (res.ok) {
+      if (res.status === 202) {
+        setIssues(issues.map(issue => issue.id === issueId ? { ...issue, pendingApproval: { content: comment, submittedBy: user.login } } : issue));
+      } else if (res.ok) {
         setIssues(issues.map(issue => issue.id === issueId ? { ...issue, comment, draft: '' } : issue));
       } else {
         alert("Failed to submit issue comment");
@@ -339,6 +492,25 @@ function App() {
     .catch(err => console.error("Failed to submit issue comment:", err));
   };
 
+  const handleIssueApproval = (issueId, handlerName, decision) => {
+    fetch(`/api/repo/${activeRepo.namespace}/${activeRepo.name}/issues/${issueId}/handler/${handlerName}/${decision}`, { method: 'POST' })
+      .then(async res => {
+        if (res.ok) {
+          setIssues(issues.map(issue => {
+            if (issue.id !== issueId) {
+              return issue;
+            }
+            const comment = decision === 'approve' ? issue.pendingApproval.content : issue.comment;
+            return { ...issue, comment, pendingApproval: undefined };
+          }));
+        } else {
+          const data = await res.json().catch(() => ({}));
+          alert(`Failed to ${decision} issue comment: ${data.error || res.statusText}`);
+        }
+      })
+      .catch(err => console.error(`Failed to ${decision} issue comment:`, err));
+  };
+
   const handleIssueDelete = (issueId, handlerName) => {
     fetch(`/api/repo/${activeRepo.namespace}/${activeRepo.name}/issues/${issueId}/handler/${handlerName}`, { method: 'DELETE' })
       .then(res => {
@@ -351,6 +523,32 @@ function App() {
       .catch(err => console.error("Failed to delete issue:", err));
   };
 
+  const rerun = (url) => {
+    const prompt = window.prompt("Rerun the agent. Optional extra instructions for this run:");
+    if (prompt === null) {
+      return;
+    }
+    fetch(url, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ prompt }),
+    })
+      .then(res => {
+        if (!res.ok) {
+          alert("Failed to rerun sandbox");
+        }
+      })
+      .catch(err => console.error("Failed to rerun sandbox:", err));
+  };
+
+  const handleRerun = (id) => {
+    rerun(`/api/repo/${activeRepo.namespace}/${activeRepo.name}/prs/${id}/rerun`);
+  };
+
+  const handleIssueRerun = (issueId, handlerName) => {
+    rerun(`/api/repo/${activeRepo.namespace}/${activeRepo.name}/issues/${issueId}/handler/${handlerName}/rerun`);
+  };
+
   const getSandboxStatusClass = (item) => {
     if (!item.sandbox) {
       return 'grey';
@@ -376,17 +574,26 @@ function App() {
     if (showAddRepo) {
       return <AddRepo onRepoAdded={fetchRepos} />;
     }
+    if (credentialsNamespace) {
+      return <Credentials namespace={credentialsNamespace} />;
+    }
+    if (showAddHandler) {
+      return <AddHandler repo={activeRepo} onHandlerAdded={handleHandlerAdded} />;
+    }
 
     if (activeSubTab.name === 'review') {
       return prs.map(pr => (
         <PrReviewCard
           key={pr.id}
           pr={pr}
+          prURL={`/api/repo/${activeRepo.namespace}/${activeRepo.name}/prs/${pr.id}`}
+          ideURL={`/api/repo/${activeRepo.namespace}/${activeRepo.name}/sandboxes/${pr.sandbox}/ide`}
           drafts={drafts}
           collapsedReviews={collapsedReviews}
           reviewViewModes={reviewViewModes}
           yamlDrafts={yamlDrafts}
           handleDelete={handleDelete}
+          handleRerun={handleRerun}
           handleSaveDraft={handleSaveDraft}
           handleDraftChange={handleDraftChange}
           handleRemoveComment={handleRemoveComment}
@@ -394,6 +601,7 @@ function App() {
           handleYamlDraftChange={handleYamlDraftChange}
           handleYamlDraftBlur={handleYamlDraftBlur}
           handleSubmit={handleSubmit}
+          handleApproval={handleApproval}
           handleExportCurl={handleExportCurl}
           getSandboxStatusClass={getSandboxStatusClass}
           toggleCollapse={toggleCollapse}
@@ -404,12 +612,16 @@ function App() {
         <IssueCard
           key={issue.id}
           issue={issue}
+          logsURL={`/api/repo/${activeRepo.namespace}/${activeRepo.name}/handlers/${activeSubTab.name}/issues/${issue.id}/logs`}
+          ideURL={`/api/repo/${activeRepo.namespace}/${activeRepo.name}/sandboxes/${issue.sandbox}/ide`}
           drafts={drafts}
           activeSubTab={activeSubTab}
           handleIssueDraftChange={handleIssueDraftChange}
           handleIssueSaveDraft={handleIssueSaveDraft}
           handleIssueSubmit={handleIssueSubmit}
+          handleIssueApproval={handleIssueApproval}
           handleIssueDelete={handleIssueDelete}
+          handleIssueRerun={handleIssueRerun}
           getSandboxStatusClass={getSandboxStatusClass}
         />
       ));
@@ -420,6 +632,18 @@ function App() {
     <div className="App">
       <header className="App-header">
         <h1>Repo Agent</h1>
+        {user.loginEnabled && (
+          <div className="user-info">
+            {user.login ? (
+              <>
+                <span>Signed in as {user.login}</span>
+                <button className="logout-btn" onClick={handleLogout}>Log out</button>
+              </>
+            ) : (
+              <a className="login-btn" href="/api/auth/login">Log in with GitHub</a>
+            )}
+          </div>
+        )}
         <div className="theme-switch-wrapper">
           <label className="theme-switch" htmlFor="checkbox">
             <input type="checkbox" id="checkbox" onChange={toggleTheme} checked={theme === 'dark'} />
@@ -443,13 +667,19 @@ function App() {
         >
           Add Repo
         </button>
+        <button
+          className={`tab-btn ${credentialsNamespace ? 'active' : ''}`}
+          onClick={handleCredentialsClick}
+        >
+          Credentials
+        </button>
       </nav>
       {activeRepo && !showAddRepo && (
         <nav className="sub-tabs">
           {repos.find(r => r.name === activeRepo.name)?.review && (
             <button
-              className={`sub-tab-btn ${activeSubTab.name === 'review' ? 'active' : ''}`}
-              onClick={() => setActiveSubTab({ repo: activeRepo.name, name: 'review' })}
+              className={`sub-tab-btn ${!showAddHandler && activeSubTab.name === 'review' ? 'active' : ''}`}
+              onClick={() => { setShowAddHandler(false); setActiveSubTab({ repo: activeRepo.name, name: 'review' }); }}
             >
               Review
             </button>
@@ -457,15 +687,31 @@ function App() {
           {repos.find(r => r.name === activeRepo.name)?.issueHandlers?.map(handler => (
             <button
               key={handler.name}
-              className={`sub-tab-btn ${activeSubTab.name === handler.name ? 'active' : ''}`}
-              onClick={() => setActiveSubTab({ repo: activeRepo.name, name: handler.name })}
+              className={`sub-tab-btn ${!showAddHandler && activeSubTab.name === handler.name ? 'active' : ''}`}
+              onClick={() => { setShowAddHandler(false); setActiveSubTab({ repo: activeRepo.name, name: handler.name }); }}
             >
               {handler.name}
             </button>
           ))}
+          <button
+            className={`sub-tab-btn ${showAddHandler ? 'active' : ''}`}
+            onClick={() => setShowAddHandler(true)}
+          >
+            Add Handler
+          </button>
           <DeleteRepo repo={activeRepo} onRepoDeleted={handleRepoDeleted} />
         </nav>
       )}
+      {activeRepo && !showAddRepo && !showAddHandler && activeSubTab.name && (
+        <div className="bulk-actions">
+          <button className="sub-tab-btn" onClick={() => handleBulk('submit')}>Submit all drafts</button>
+          <button className="sub-tab-btn" onClick={() => handleBulk('scaledown')}>Scale down all</button>
+          <button className="sub-tab-btn" onClick={() => handleBulk('delete')}>Delete all</button>
+          {activeSubTab.name !== 'review' && (
+            <button className="sub-tab-btn" onClick={handleRemoveHandler}>Remove handler</button>
+          )}
+        </div>
+      )}
       <main className="pr-list">
         {renderContent()}
       </main>
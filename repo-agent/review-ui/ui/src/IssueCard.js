@@ -1,13 +1,18 @@
 import React, { useState, useEffect } from 'react';
+import { openIDE } from './ide';
 
 function IssueCard({
   issue,
+  logsURL,
+  ideURL,
   drafts,
   activeSubTab,
   handleIssueDraftChange,
   handleIssueSaveDraft,
   handleIssueSubmit,
+  handleIssueApproval,
   handleIssueDelete,
+  handleIssueRerun,
   getSandboxStatusClass,
 }) {
   const [isCollapsed, setIsCollapsed] = useState(true);
@@ -21,6 +26,8 @@ function IssueCard({
         return 'orange';
       case 'Submitted':
         return '#3f5398ff';
+      case 'Pending approval':
+        return '#8a6d3b';
       default:
         return '#3e7f67ff'; // Default color
     }
@@ -33,12 +40,14 @@ function IssueCard({
   useEffect(() => {
     if (issue.comment) {
       setReviewFlairText('Submitted');
+    } else if (issue.pendingApproval) {
+      setReviewFlairText('Pending approval');
     } else if (drafts[issue.id] && drafts[issue.id].trim() !== '') {
       setReviewFlairText('Ready');
     } else {
       setReviewFlairText('Generating ...');
     }
-  }, [issue.comment, drafts, issue.id]);
+  }, [issue.comment, issue.pendingApproval, drafts, issue.id]);
 
   return (
     <div key={issue.id} className={`pr-card ${issue.comment ? 'review-submitted' : ''}`}>
@@ -50,15 +59,28 @@ function IssueCard({
           </span>
         </h3>
         <div className="pr-card-actions-header">
+          {issue.phase && (
+            <span
+              title={issue.heartbeat ? `Last heartbeat: ${issue.heartbeat}` : undefined}
+              style={{ marginRight: '10px', backgroundColor: '#555', color: 'white', padding: '5px 10px', borderRadius: '5px', fontSize: 'small' }}
+            >
+              {issue.phase}
+            </span>
+          )}
           {reviewFlairText && (
             <span style={{ marginRight: '10px', backgroundColor: getReviewFlairColor(reviewFlairText), color: 'white', padding: '5px 10px', borderRadius: '5px', fontSize: 'small' }}>
               {reviewFlairText}
             </span>
           )}
           {getSandboxStatusClass(issue) === 'green' ? (
-            <a href={`/sandbox/${issue.sandbox}/`} target="_blank" rel="noopener noreferrer" className={`pr-sandbox ${getSandboxStatusClass(issue)}`}>
-              Sandbox &#9654;
-            </a>
+            <>
+              <a href={`${logsURL}?follow=true`} target="_blank" rel="noopener noreferrer" className="pr-sandbox">
+                Logs
+              </a>
+              <button type="button" onClick={() => openIDE(ideURL)} className={`pr-sandbox ${getSandboxStatusClass(issue)}`}>
+                Sandbox &#9654;
+              </button>
+            </>
           ) : getSandboxStatusClass(issue) === 'yellow' ? (
             <span className={`pr-sandbox ${getSandboxStatusClass(issue)}`}>Sandbox &#9646;&#9646;</span>
           ) : (
@@ -71,6 +93,12 @@ function IssueCard({
           {issue.pushBranch ? (
             <div className="branch-link">
               <strong>Branch: </strong> <a href={issue.branchURL} target="_blank" rel="noopener noreferrer">{issue.branchURL}</a>
+              {issue.pullRequestURL && (
+                <>
+                  <br />
+                  <strong>Pull Request: </strong> <a href={issue.pullRequestURL} target="_blank" rel="noopener noreferrer">{issue.pullRequestURL}</a>
+                </>
+              )}
             </div>
           ) : issue.comment ? (
             <div className="review-display">
@@ -87,11 +115,20 @@ function IssueCard({
             ></textarea>
           )}
           <div className="pr-card-actions">
-            {!issue.pushBranch && (
+            {!issue.pushBranch && (issue.pendingApproval ? (
+              <>
+                <span className="pending-approval">Submitted by {issue.pendingApproval.submittedBy || 'unknown'}</span>
+                <button className="btn btn-submit" onClick={() => handleIssueApproval(issue.id, activeSubTab.name, 'approve')}>Approve</button>
+                <button className="btn" onClick={() => handleIssueApproval(issue.id, activeSubTab.name, 'reject')}>Reject</button>
+              </>
+            ) : (
               <button className="btn btn-submit" onClick={() => handleIssueSubmit(issue.id, activeSubTab.name)} disabled={!!issue.comment}>
                 {issue.comment ? 'Submitted' : 'Create Comment'}
               </button>
-            )}
+            ))}
+            <button className="btn" onClick={() => handleIssueRerun(issue.id, activeSubTab.name)} disabled={!issue.sandbox}>
+              Rerun
+            </button>
             <button className="btn btn-delete" onClick={() => handleIssueDelete(issue.id, activeSubTab.name)}>&#x2715;</button>
           </div>
         </>
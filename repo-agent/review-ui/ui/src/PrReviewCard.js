@@ -1,16 +1,20 @@
 import React, { useState, useEffect } from 'react';
 import yaml from 'js-yaml';
-import { parseDiff, Diff, getChangeKey } from 'react-diff-view';
+import { Diff, getChangeKey } from 'react-diff-view';
 import 'react-diff-view/style/index.css';
+import { openIDE } from './ide';
 
 
 function PrReviewCard({
   pr,
+  prURL,
+  ideURL,
   drafts,
   collapsedReviews,
   reviewViewModes,
   yamlDrafts,
   handleDelete,
+  handleRerun,
   handleSaveDraft,
   handleDraftChange,
   handleRemoveComment,
@@ -18,6 +22,7 @@ function PrReviewCard({
   handleYamlDraftChange,
   handleYamlDraftBlur,
   handleSubmit,
+  handleApproval,
   handleExportCurl,
   toggleCollapse,
   getSandboxStatusClass,
@@ -36,6 +41,8 @@ function PrReviewCard({
         return 'orange';
       case 'Submitted':
         return '#3f5398ff';
+      case 'Pending approval':
+        return '#8a6d3b';
       default:
         return '#3e7f67ff'; // Default color
     }
@@ -45,53 +52,42 @@ function PrReviewCard({
   useEffect(() => {
     if (pr.review) {
       setReviewFlairText('Submitted');
+    } else if (pr.pendingApproval) {
+      setReviewFlairText('Pending approval');
     } else if (drafts[pr.id] && drafts[pr.id].note && drafts[pr.id].note.trim() !== '') {
       setReviewFlairText('Ready');
     } else {
       setReviewFlairText('Generating ...');
     }
-  }, [pr.review, drafts, pr.id]);
+  }, [pr.review, pr.pendingApproval, drafts, pr.id]);
   useEffect(() => {
     if (!isCollapsed && !diff && !diffError) {
-      if (!pr.diffURL) {
-        setDiffError("diffURL is empty");
-        return;
-      }
-      fetch(`/api/proxy?url=${encodeURIComponent(pr.diffURL)}`)
+      // The API fetches the diff with the repo's token and parses it
+      fetch(`${prURL}/diff`)
         .then(async (res) => {
           if (res.ok) {
-            return res.text();
+            return res.json();
           }
           const text = await res.text();
           throw new Error(`HTTP ${res.status}: ${res.statusText}. ${text}`);
         })
-        .then(text => {
-          if (text) {
-            try {
-              const files = parseDiff(text);
-              setDiff(files);
-              // Initialize fileCollapsed state here to ensure files are collapsed by default
-              const initialCollapsedState = {};
-              files.forEach(({ oldRevision, newRevision }) => {
-                const fileId = oldRevision + '-' + newRevision;
-                initialCollapsedState[fileId] = true;
-              });
-              setFileCollapsed(initialCollapsedState);
-            } catch (e) {
-              console.error("Failed to parse diff:", e);
-              setDiffError(`Failed to parse diff: ${e.message}`);
-            }
-          } else {
-            setDiff([]); // Empty diff
-            setFileCollapsed({}); // Also reset collapsed state for empty diff
-          }
+        .then(data => {
+          const files = data || [];
+          setDiff(files);
+          // Initialize fileCollapsed state here to ensure files are collapsed by default
+          const initialCollapsedState = {};
+          files.forEach(({ oldRevision, newRevision }) => {
+            const fileId = oldRevision + '-' + newRevision;
+            initialCollapsedState[fileId] = true;
+          });
+          setFileCollapsed(initialCollapsedState);
         })
         .catch(err => {
           console.error("Failed to fetch diff:", err);
           setDiffError(err.message);
         });
     }
-  }, [pr.diffURL, pr.id, isCollapsed, diff, diffError]);
+  }, [prURL, pr.id, isCollapsed, diff, diffError]);
 
   const reviewData = pr.review ? yaml.load(pr.review) : null;
 
@@ -260,9 +256,14 @@ function PrReviewCard({
             </span>
           )}
           {getSandboxStatusClass(pr) === 'green' ? (
-            <a href={`/sandbox/${pr.sandbox}/`} target="_blank" rel="noopener noreferrer" className={`pr-sandbox ${getSandboxStatusClass(pr)}`}>
-              Sandbox &#9654;
-            </a>
+            <>
+              <a href={`${prURL}/logs?follow=true`} target="_blank" rel="noopener noreferrer" className="pr-sandbox">
+                Logs
+              </a>
+              <button type="button" onClick={() => openIDE(ideURL)} className={`pr-sandbox ${getSandboxStatusClass(pr)}`}>
+                Sandbox &#9654;
+              </button>
+            </>
           ) : getSandboxStatusClass(pr) === 'yellow' ? (
             <span className={`pr-sandbox ${getSandboxStatusClass(pr)}`}>Sandbox &#9646;&#9646;</span>
           ) : (
@@ -341,13 +342,29 @@ function PrReviewCard({
             )
           )}
           {renderDiffView()}
+          {pr.requestedReviewers?.length > 0 && (
+            <div className="requested-reviewers">
+              Review requested from {pr.requestedReviewers.map(r => '@' + r).join(', ')}
+            </div>
+          )}
           <div className="pr-card-actions">
-            <button className="btn btn-submit" onClick={() => handleSubmit(pr.id)} disabled={!!pr.review}>
-              {pr.review ? 'Draft Created' : 'Create Draft Review'}
-            </button>
+            {pr.pendingApproval ? (
+              <>
+                <span className="pending-approval">Submitted by {pr.pendingApproval.submittedBy || 'unknown'}</span>
+                <button className="btn btn-submit" onClick={() => handleApproval(pr.id, 'approve')}>Approve</button>
+                <button className="btn" style={{marginLeft: '10px'}} onClick={() => handleApproval(pr.id, 'reject')}>Reject</button>
+              </>
+            ) : (
+              <button className="btn btn-submit" onClick={() => handleSubmit(pr.id)} disabled={!!pr.review}>
+                {pr.review ? 'Draft Created' : 'Create Draft Review'}
+              </button>
+            )}
             <button className="btn btn-submit" style={{marginLeft: '10px', backgroundColor: '#6c757d'}} onClick={() => handleExportCurl(pr.id, setCurlCommand)} disabled={!!pr.review}>
               Export Curl Command
             </button>
+            <button className="btn" style={{marginLeft: '10px'}} onClick={() => handleRerun(pr.id)} disabled={!pr.sandbox}>
+              Rerun
+            </button>
           <button className="btn btn-delete" onClick={(e) => { e.stopPropagation(); handleDelete(pr.id); }}>&#x2715;</button>
           </div>
           {curlCommand && (
@@ -0,0 +1,24 @@
+// The API rejects mutating requests without the CSRF token it sets in the
+// review_csrf cookie, echoed in the X-CSRF-Token header.
+const CSRF_COOKIE = 'review_csrf';
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
+function csrfToken() {
+  const match = document.cookie.match(new RegExp(`(?:^|; )${CSRF_COOKIE}=([^;]*)`));
+  return match ? decodeURIComponent(match[1]) : '';
+}
+
+// installCSRFFetch makes fetch send the CSRF token with mutating API requests.
+export function installCSRFFetch() {
+  const originalFetch = window.fetch;
+  window.fetch = (input, init = {}) => {
+    const method = (init.method || 'GET').toUpperCase();
+    const url = typeof input === 'string' ? input : input.url;
+    if (SAFE_METHODS.includes(method) || !url.startsWith('/api/')) {
+      return originalFetch(input, init);
+    }
+    const headers = new Headers(init.headers || {});
+    headers.set('X-CSRF-Token', csrfToken());
+    return originalFetch(input, { ...init, headers });
+  };
+}
@@ -0,0 +1,24 @@
+// The IDE of a sandbox is served from its own host, so that the code under
+// review never runs on the origin of the UI. The API returns a link to it with
+// a short-lived ticket the IDE exchanges for its own cookie.
+
+// openIDE opens the IDE of a sandbox in a new tab. The tab is opened before
+// the request so that it isn't blocked as a popup.
+export function openIDE(ideURL) {
+  const tab = window.open('', '_blank');
+  fetch(ideURL, { method: 'POST' })
+    .then(res => res.json().then(body => {
+      if (!res.ok) {
+        throw new Error(body.error || res.statusText);
+      }
+      return body;
+    }))
+    .then(({ url }) => {
+      tab.opener = null;
+      tab.location = url;
+    })
+    .catch(err => {
+      tab.close();
+      alert(`Failed to open the IDE: ${err.message}`);
+    });
+}
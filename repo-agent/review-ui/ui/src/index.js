@@ -1,6 +1,9 @@
 import React from 'react';
 import ReactDOM from 'react-dom/client';
 import App from './App';
+import { installCSRFFetch } from './csrf';
+
+installCSRFFetch();
 
 const root = ReactDOM.createRoot(document.getElementById('root'));
 root.render(
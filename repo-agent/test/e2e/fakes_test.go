@@ -0,0 +1,150 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/llm"
+)
+
+// fakeGitHub is a GitHub Enterprise API serving the open pull requests of
+// owner/repo and recording the reviews posted on them. Requests must be
+// authorized with pat.
+type fakeGitHub struct {
+	*httptest.Server
+	owner, repo, pat string
+	prs              []*github.PullRequest
+
+	mu      sync.Mutex
+	reviews map[int][]*github.PullRequestReviewRequest
+}
+
+func newFakeGitHub(t *testing.T, owner, repo, pat string, prs ...*github.PullRequest) *fakeGitHub {
+	gh := &fakeGitHub{owner: owner, repo: repo, pat: pat, prs: prs, reviews: map[int][]*github.PullRequestReviewRequest{}}
+	prefix := fmt.Sprintf("/api/v3/repos/%s/%s", owner, repo)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &github.User{Login: github.String("repo-agent-bot")})
+	})
+	mux.HandleFunc("GET "+prefix+"/pulls", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, gh.prs)
+	})
+	mux.HandleFunc("GET "+prefix+"/pulls/{number}", func(w http.ResponseWriter, r *http.Request) {
+		if pr := gh.pr(r.PathValue("number")); pr != nil {
+			writeJSON(w, http.StatusOK, pr)
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	})
+	mux.HandleFunc("GET "+prefix+"/issues", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []*github.Issue{})
+	})
+	mux.HandleFunc("POST "+prefix+"/pulls/{number}/reviews", func(w http.ResponseWriter, r *http.Request) {
+		pr := gh.pr(r.PathValue("number"))
+		if pr == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+		review := &github.PullRequestReviewRequest{}
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": err.Error()})
+			return
+		}
+		gh.mu.Lock()
+		gh.reviews[pr.GetNumber()] = append(gh.reviews[pr.GetNumber()], review)
+		id := int64(len(gh.reviews[pr.GetNumber()]))
+		gh.mu.Unlock()
+		writeJSON(w, http.StatusOK, &github.PullRequestReview{ID: github.Int64(id), Body: review.Body, State: github.String("PENDING")})
+	})
+	gh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+pat {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(gh.Close)
+	return gh
+}
+
+// BaseURL is the API URL of the RepoWatches of the server.
+func (gh *fakeGitHub) BaseURL() string {
+	return gh.URL + "/api/v3/"
+}
+
+func (gh *fakeGitHub) pr(number string) *github.PullRequest {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil
+	}
+	for _, pr := range gh.prs {
+		if pr.GetNumber() == n {
+			return pr
+		}
+	}
+	return nil
+}
+
+// Reviews returns the reviews posted on the PR number.
+func (gh *fakeGitHub) Reviews(number int) []*github.PullRequestReviewRequest {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	return append([]*github.PullRequestReviewRequest(nil), gh.reviews[number]...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// fakeLLM is an llm.Provider answering every prompt with output, as the
+// agent of a review sandbox would.
+type fakeLLM struct {
+	output         string
+	prompts        []string
+	postProcessors []llm.PostProcessor
+}
+
+var _ llm.Provider = &fakeLLM{}
+
+func (f *fakeLLM) Setup(_, _ string) error {
+	return nil
+}
+
+func (f *fakeLLM) Run(prompt string) ([]byte, error) {
+	f.prompts = append(f.prompts, prompt)
+	out := []byte(f.output)
+	for _, p := range f.postProcessors {
+		var err error
+		if out, err = p(out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeLLM) AddPostProcessor(p llm.PostProcessor) {
+	f.postProcessors = append(f.postProcessors, p)
+}
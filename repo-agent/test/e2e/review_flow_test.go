@@ -0,0 +1,333 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package e2e tests the review flow end to end: the RepoWatch controller
+// creates a ReviewSandbox for a PR of a fake GitHub, a fake LLM drafts its
+// review as the sandbox would, and the review API posts the draft to GitHub
+// and scales the sandbox down. The Kubernetes API is envtest's, so the tests
+// are skipped unless KUBEBUILDER_ASSETS is set, see make e2e.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v39/github"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/llm"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/pkg/logging"
+	reviewv1alpha1 "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/api/v1alpha1"
+	"github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/repowatch/controllers"
+	apiclient "github.com/gke-labs/gemini-for-kubernetes-development/repo-agent/review-ui/review-api/client"
+)
+
+const (
+	namespace = "default"
+	owner     = "octo"
+	// repo is also the name of the RepoWatch, the review API finds the
+	// RepoWatch of a repo by name
+	repo = "repo"
+	pat  = "e2e-pat"
+
+	timeout  = 60 * time.Second
+	interval = 250 * time.Millisecond
+)
+
+var reviewSandboxGVK = schema.GroupVersionKind{Group: "custom.agents.x-k8s.io", Version: "v1alpha1", Kind: "ReviewSandbox"}
+
+// agentOutput is the draft of the fake LLM, fenced as models do.
+const agentOutput = "```yaml\n" + `note: The change looks good overall.
+review:
+  body: Two nits, otherwise LGTM.
+  comments:
+  - path: main.go
+    line: 12
+    body: Check the error returned by Close.
+  - path: README.md
+    line: 3
+    body: Typo, "recieve".
+` + "```\n"
+
+func TestReviewFlow(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run make e2e")
+	}
+	g := NewWithT(t)
+	g.SetDefaultEventuallyTimeout(timeout)
+	g.SetDefaultEventuallyPollingInterval(interval)
+	ctrl.SetLogger(logr.FromSlogHandler(logging.New(os.Stderr).Handler()))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	pr := &github.PullRequest{
+		Number:  github.Int(1),
+		State:   github.String("open"),
+		Title:   github.String("Add a greeting"),
+		HTMLURL: github.String("https://github.com/octo/repo/pull/1"),
+		DiffURL: github.String("https://github.com/octo/repo/pull/1.diff"),
+		Head: &github.PullRequestBranch{
+			Ref:  github.String("greeting"),
+			Repo: &github.Repository{CloneURL: github.String("https://github.com/octo/repo.git")},
+		},
+	}
+	gh := newFakeGitHub(t, owner, repo, pat, pr)
+
+	cfg, kubeconfig := startEnvtest(t)
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(reviewv1alpha1.AddToScheme(scheme))
+	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The controller, as deployed but for the GitHub API
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect((&controllers.RepoWatchReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		NewGithubClient: func(ctx context.Context, k8sClient client.Client, repoWatch *reviewv1alpha1.RepoWatch) (*github.Client, map[string]string, error) {
+			return controllers.NewGithubClient(ctx, k8sClient, nil, nil, repoWatch)
+		},
+	}).SetupWithManager(mgr)).To(Succeed())
+	managerDone := make(chan error)
+	go func() { managerDone <- mgr.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-managerDone
+	})
+
+	g.Expect(k8sClient.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: namespace},
+		StringData: map[string]string{"pat": pat},
+	})).To(Succeed())
+	g.Expect(k8sClient.Create(ctx, &reviewv1alpha1.RepoWatch{
+		ObjectMeta: metav1.ObjectMeta{Name: repo, Namespace: namespace},
+		Spec: reviewv1alpha1.RepoWatchSpec{
+			RepoURL:          fmt.Sprintf("https://github.com/%s/%s", owner, repo),
+			GithubSecretName: "github",
+			GitHub:           reviewv1alpha1.GitHubSpec{BaseURL: gh.BaseURL()},
+			Review: reviewv1alpha1.PRReviewSpec{
+				MaxActiveSandboxes: 1,
+				LLM:                reviewv1alpha1.LLMConfig{Prompt: "Review the changes of {{.DiffURL}}."},
+			},
+		},
+	})).To(Succeed())
+
+	// The controller creates the sandbox of the PR
+	sandbox := &unstructured.Unstructured{}
+	sandbox.SetGroupVersionKind(reviewSandboxGVK)
+	sandboxKey := types.NamespacedName{Namespace: namespace, Name: "repo-pr-1"}
+	g.Eventually(func() error { return k8sClient.Get(ctx, sandboxKey, sandbox) }).Should(Succeed())
+	g.Expect(sandbox.GetLabels()).To(HaveKeyWithValue("review.gemini.google.com/repowatch", repo))
+	prID, _, _ := unstructured.NestedString(sandbox.Object, "spec", "source", "pr")
+	g.Expect(prID).To(Equal("1"))
+	replicas, _, _ := unstructured.NestedInt64(sandbox.Object, "spec", "replicas")
+	g.Expect(replicas).To(BeEquivalentTo(1))
+	prompt, _, _ := unstructured.NestedString(sandbox.Object, "spec", "llm", "prompt")
+	g.Expect(prompt).To(ContainSubstring(pr.GetDiffURL()))
+
+	// The sandbox runs the agent on the prompt and its sidecar publishes the
+	// draft
+	provider := &fakeLLM{output: agentOutput}
+	draft := runReviewAgent(t, provider, prompt)
+	g.Expect(provider.prompts).To(ConsistOf(prompt))
+	g.Eventually(func() error {
+		if err := k8sClient.Get(ctx, sandboxKey, sandbox); err != nil {
+			return err
+		}
+		annotations := sandbox.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations["agentDraft"] = draft
+		sandbox.SetAnnotations(annotations)
+		return k8sClient.Update(ctx, sandbox)
+	}).Should(Succeed())
+
+	// The review API shows the draft and posts it to GitHub when submitted
+	api := apiclient.New(startReviewAPI(t, kubeconfig) + "/api")
+	g.Eventually(func(g Gomega) {
+		prs, err := api.GetPRs(ctx, namespace, repo)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(prs).To(HaveLen(1))
+		g.Expect(prs[0].ID).To(Equal("1"))
+		g.Expect(prs[0].Draft).To(Equal(draft))
+	}).Should(Succeed())
+	g.Expect(api.SubmitReview(ctx, namespace, repo, "1", apiclient.ReviewSubmission{Review: draft})).To(Succeed())
+
+	reviews := gh.Reviews(1)
+	g.Expect(reviews).To(HaveLen(1))
+	g.Expect(reviews[0].GetBody()).To(Equal("Two nits, otherwise LGTM."))
+	g.Expect(reviews[0].Event).To(BeNil(), "reviews are posted as drafts")
+	g.Expect(reviews[0].Comments).To(HaveLen(2))
+	g.Expect(reviews[0].Comments[0].GetPath()).To(Equal("main.go"))
+	g.Expect(reviews[0].Comments[0].GetLine()).To(Equal(12))
+	g.Expect(reviews[0].Comments[1].GetBody()).To(Equal(`Typo, "recieve".`))
+
+	// The sandbox of the reviewed PR is scaled down
+	g.Eventually(func(g Gomega) {
+		g.Expect(k8sClient.Get(ctx, sandboxKey, sandbox)).To(Succeed())
+		replicas, _, _ := unstructured.NestedInt64(sandbox.Object, "spec", "replicas")
+		g.Expect(replicas).To(BeEquivalentTo(0))
+	}).Should(Succeed())
+}
+
+// startEnvtest starts an API server with the CRDs of the repo and returns its
+// config and the path of a kubeconfig for it.
+func startEnvtest(t *testing.T) (*rest.Config, string) {
+	t.Helper()
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "k8s", "crds"), filepath.Join("testdata", "crds")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("failed to stop envtest: %v", err)
+		}
+	})
+
+	user, err := env.AddUser(envtest.User{Name: "e2e-admin", Groups: []string{"system:masters"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to add envtest user: %v", err)
+	}
+	b, err := user.KubeConfig()
+	if err != nil {
+		t.Fatalf("failed to get envtest kubeconfig: %v", err)
+	}
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, kubeconfig
+}
+
+// runReviewAgent runs provider on prompt as the review sandbox does and
+// returns the draft its sidecar publishes.
+func runReviewAgent(t *testing.T, provider llm.Provider, prompt string) string {
+	t.Helper()
+	provider.AddPostProcessor(llm.StripYAMLMarkers)
+	if err := provider.Setup(t.TempDir(), t.TempDir()); err != nil {
+		t.Fatalf("failed to set up the LLM: %v", err)
+	}
+	out, err := provider.Run(prompt)
+	if err != nil {
+		t.Fatalf("failed to run the LLM: %v", err)
+	}
+	return string(out)
+}
+
+// startReviewAPI builds and runs the review API against the API server of
+// kubeconfig and returns its URL once it is ready. Its logs are shown if the
+// test fails.
+func startReviewAPI(t *testing.T, kubeconfig string) string {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "review-api")
+	build := exec.Command("go", "build", "-o", bin, "../../review-ui/review-api")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the review API: %v\n%s", err, out)
+	}
+
+	addr := freeAddr(t)
+	var logs bytes.Buffer
+	cmd := exec.Command(bin)
+	cmd.Stdout, cmd.Stderr = &logs, &logs
+	cmd.Env = append(outOfClusterEnv(),
+		"KUBECONFIG="+kubeconfig,
+		"LISTEN_ADDR="+addr,
+		"LOG_LEVEL=debug",
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start the review API: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if t.Failed() {
+			t.Logf("review API logs:\n%s", logs.String())
+		}
+	})
+
+	url := "http://" + addr
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get(url + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return url
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the review API isn't ready after %v: %v", timeout, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// outOfClusterEnv returns the environment without the in-cluster variables,
+// so that the review API uses KUBECONFIG even when the tests run in a pod.
+func outOfClusterEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "KUBERNETES_SERVICE_") || strings.HasPrefix(kv, "KUBECONFIG=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}